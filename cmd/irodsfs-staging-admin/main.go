@@ -0,0 +1,255 @@
+// irodsfs-staging-admin inspects and repairs the staging state left behind by a mount.
+// The mount using the staging root must not be running.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	irodsclient_fs "github.com/cyverse/go-irodsclient/fs"
+	irodsclient_types "github.com/cyverse/go-irodsclient/irods/types"
+	"github.com/cyverse/irodsfs-common/irods"
+	"github.com/cyverse/irodsfs-common/irods/stagingfs"
+)
+
+const usage = `Usage: irodsfs-staging-admin -root <staging root> <command> [flags] [paths...]
+
+Commands:
+  list      list pending and failed items
+  verify    check local data files against metadata
+  export    write the staging state as JSON
+  replay    perform the iRODS operations of the given items now
+  discard   drop the given items and their local data
+`
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %+v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	globalFlags := flag.NewFlagSet("irodsfs-staging-admin", flag.ContinueOnError)
+	globalFlags.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	rootPath := globalFlags.String("root", "", "staging root path (LocalRootPath)")
+	if err := globalFlags.Parse(args); err != nil {
+		return err
+	}
+
+	if *rootPath == "" || globalFlags.NArg() == 0 {
+		globalFlags.Usage()
+		return errors.New("staging root and command are required")
+	}
+
+	command := globalFlags.Arg(0)
+	commandArgs := globalFlags.Args()[1:]
+
+	switch command {
+	case "list":
+		return runList(*rootPath, commandArgs)
+	case "verify":
+		return runVerify(*rootPath, commandArgs)
+	case "export":
+		return runExport(*rootPath, commandArgs)
+	case "replay":
+		return runReplay(*rootPath, commandArgs)
+	case "discard":
+		return runDiscard(*rootPath, commandArgs)
+	default:
+		globalFlags.Usage()
+		return errors.Newf("unknown command %q", command)
+	}
+}
+
+func runList(rootPath string, args []string) error {
+	flags := flag.NewFlagSet("list", flag.ContinueOnError)
+	failedOnly := flags.Bool("failed", false, "list failed items only")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	snapshot, err := stagingfs.OpenStagingSnapshot(rootPath, true)
+	if err != nil {
+		return err
+	}
+	defer snapshot.Close()
+
+	items := snapshot.GetFailed()
+	if !*failedOnly {
+		items = append(snapshot.GetPending(), items...)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "STATE\tACTION\tSIZE\tMODIFIED\tFAILS\tPATH")
+	for _, item := range items {
+		state := "pending"
+		if item.Failed {
+			state = "failed"
+		}
+
+		size := "-"
+		if item.LocalSize >= 0 {
+			size = fmt.Sprintf("%d", item.LocalSize)
+		}
+
+		path := item.Path
+		if item.OldPath != "" {
+			path = item.OldPath + " -> " + item.Path
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\n", state, item.Action, size,
+			item.Metadata.LastModifiedAt.Format(time.RFC3339), item.Metadata.SyncFailCount, path)
+	}
+	return w.Flush()
+}
+
+func runVerify(rootPath string, args []string) error {
+	snapshot, err := stagingfs.OpenStagingSnapshot(rootPath, true)
+	if err != nil {
+		return err
+	}
+	defer snapshot.Close()
+
+	report := snapshot.Verify()
+	for _, p := range report.MissingData {
+		fmt.Printf("missing data: %s\n", p)
+	}
+	for _, p := range report.OrphanFiles {
+		fmt.Printf("orphan file: %s\n", p)
+	}
+	for _, p := range report.StaleCheckpoints {
+		fmt.Printf("stale checkpoint: %s\n", p)
+	}
+
+	if !report.IsConsistent() {
+		return errors.New("staging state is inconsistent")
+	}
+	fmt.Println("staging state is consistent")
+	return nil
+}
+
+func runExport(rootPath string, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	output := flags.String("o", "", "output file (default: stdout)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	snapshot, err := stagingfs.OpenStagingSnapshot(rootPath, true)
+	if err != nil {
+		return err
+	}
+	defer snapshot.Close()
+
+	if *output == "" {
+		return snapshot.Export(os.Stdout)
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create %s", *output)
+	}
+	defer f.Close()
+
+	return snapshot.Export(f)
+}
+
+// selectPaths returns the paths given, or all failed items when allFailed is set
+func selectPaths(snapshot *stagingfs.StagingSnapshot, paths []string, allFailed bool) ([]string, error) {
+	if allFailed {
+		for _, item := range snapshot.GetFailed() {
+			paths = append(paths, item.Path)
+		}
+	}
+
+	if len(paths) == 0 {
+		return nil, errors.New("no items selected, give paths or -failed")
+	}
+	return paths, nil
+}
+
+func runReplay(rootPath string, args []string) error {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	allFailed := flags.Bool("failed", false, "replay all failed items")
+	host := flags.String("host", "", "iRODS host")
+	port := flags.Int("port", 1247, "iRODS port")
+	zone := flags.String("zone", "", "iRODS zone")
+	user := flags.String("user", "", "iRODS user")
+	authScheme := flags.String("auth_scheme", "native", "iRODS authentication scheme")
+	resource := flags.String("resource", "", "iRODS default resource")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *host == "" || *zone == "" || *user == "" {
+		return errors.New("host, zone and user are required for replay")
+	}
+
+	// Keep the password out of the process list
+	password := os.Getenv("IRODS_PASSWORD")
+
+	account, err := irodsclient_types.CreateIRODSAccount(*host, *port, *user, *zone, irodsclient_types.GetAuthScheme(*authScheme), password, *resource)
+	if err != nil {
+		return errors.Wrap(err, "failed to create iRODS account")
+	}
+
+	fs, err := irodsclient_fs.NewFileSystemWithDefault(account, "irodsfs-staging-admin")
+	if err != nil {
+		return errors.Wrap(err, "failed to connect to iRODS")
+	}
+	defer fs.Release()
+
+	client, err := irods.NewIRODSFSClientDirect(fs)
+	if err != nil {
+		return err
+	}
+
+	snapshot, err := stagingfs.OpenStagingSnapshot(rootPath, false)
+	if err != nil {
+		return err
+	}
+	defer snapshot.Close()
+
+	paths, err := selectPaths(snapshot, flags.Args(), *allFailed)
+	if err != nil {
+		return err
+	}
+
+	replayed, err := snapshot.Replay(client.(*irods.IRODSFSClientDirect), nil, paths)
+	for _, p := range replayed {
+		fmt.Printf("replayed: %s\n", p)
+	}
+	return err
+}
+
+func runDiscard(rootPath string, args []string) error {
+	flags := flag.NewFlagSet("discard", flag.ContinueOnError)
+	allFailed := flags.Bool("failed", false, "discard all failed items")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	snapshot, err := stagingfs.OpenStagingSnapshot(rootPath, false)
+	if err != nil {
+		return err
+	}
+	defer snapshot.Close()
+
+	paths, err := selectPaths(snapshot, flags.Args(), *allFailed)
+	if err != nil {
+		return err
+	}
+
+	if err := snapshot.Discard(paths); err != nil {
+		return err
+	}
+	for _, p := range paths {
+		fmt.Printf("discarded: %s\n", p)
+	}
+	return nil
+}
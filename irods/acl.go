@@ -0,0 +1,83 @@
+package irods
+
+import (
+	"os"
+
+	irodsclient_fs "github.com/cyverse/go-irodsclient/fs"
+	irodsclient_types "github.com/cyverse/go-irodsclient/irods/types"
+)
+
+// PublicGroupName is the iRODS group every user belongs to. Its access projects onto the other bits.
+const PublicGroupName = "public"
+
+// accessLevelRank orders access levels from none to own
+func accessLevelRank(level irodsclient_types.IRODSAccessLevelType) int {
+	switch level {
+	case irodsclient_types.IRODSAccessLevelOwner:
+		return 3
+	case irodsclient_types.IRODSAccessLevelDeleteObject, irodsclient_types.IRODSAccessLevelModifyObject:
+		return 2
+	case irodsclient_types.IRODSAccessLevelReadObject:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// accessPermBits returns the rwx bits for an access level rank
+func accessPermBits(rank int, isDir bool) os.FileMode {
+	switch {
+	case rank >= 2 && isDir:
+		return 0o7
+	case rank >= 2:
+		return 0o6
+	case rank == 1 && isDir:
+		return 0o5
+	case rank == 1:
+		return 0o4
+	default:
+		return 0
+	}
+}
+
+// ACLToMode projects the ACL of an entry onto mode bits for FUSE getattr, from the point of view of
+// userName who belongs to groupNames. The owner bits are the effective access of the user through the
+// ACL entries of the user, its groups and the public group; the group bits are the access of its
+// groups and the public group; the other bits are the access of the public group.
+// Read access maps to r (r-x for collections), modify and own to rw (rwx). Symlinks are always 0777.
+// Users and groups are matched by name regardless of their zone.
+func ACLToMode(entryType irodsclient_fs.EntryType, accesses []*irodsclient_types.IRODSAccess, userName string, groupNames []string) os.FileMode {
+	if entryType == SymlinkEntry {
+		return os.ModeSymlink | 0o777
+	}
+
+	isDir := entryType == irodsclient_fs.DirectoryEntry
+
+	groups := map[string]bool{}
+	for _, group := range groupNames {
+		groups[group] = true
+	}
+
+	userRank, groupRank, publicRank := 0, 0, 0
+	for _, access := range accesses {
+		rank := accessLevelRank(access.AccessLevel)
+
+		switch {
+		case access.UserName == PublicGroupName:
+			publicRank = max(publicRank, rank)
+		case access.UserName == userName && access.UserType != irodsclient_types.IRODSUserRodsGroup:
+			userRank = max(userRank, rank)
+		case groups[access.UserName]:
+			groupRank = max(groupRank, rank)
+		}
+	}
+
+	groupRank = max(groupRank, publicRank)
+	userRank = max(userRank, groupRank)
+
+	mode := accessPermBits(userRank, isDir)<<6 | accessPermBits(groupRank, isDir)<<3 | accessPermBits(publicRank, isDir)
+	if isDir {
+		mode |= os.ModeDir
+	}
+	return mode
+}
@@ -0,0 +1,158 @@
+package irods
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	irodsclient_fs "github.com/cyverse/go-irodsclient/fs"
+	irodsclient_types "github.com/cyverse/go-irodsclient/irods/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testAccess(userName string, userType irodsclient_types.IRODSUserType, level irodsclient_types.IRODSAccessLevelType) *irodsclient_types.IRODSAccess {
+	return &irodsclient_types.IRODSAccess{
+		Path:        "/zone/home/alice/data",
+		UserName:    userName,
+		UserZone:    "zone",
+		UserType:    userType,
+		AccessLevel: level,
+	}
+}
+
+func TestACLToMode(t *testing.T) {
+	user := irodsclient_types.IRODSUserRodsUser
+	group := irodsclient_types.IRODSUserRodsGroup
+
+	tests := []struct {
+		name      string
+		entryType irodsclient_fs.EntryType
+		accesses  []*irodsclient_types.IRODSAccess
+		expected  os.FileMode
+	}{
+		{
+			name:      "owned file",
+			entryType: irodsclient_fs.FileEntry,
+			accesses:  []*irodsclient_types.IRODSAccess{testAccess("alice", user, irodsclient_types.IRODSAccessLevelOwner)},
+			expected:  0o600,
+		},
+		{
+			name:      "owned directory",
+			entryType: irodsclient_fs.DirectoryEntry,
+			accesses:  []*irodsclient_types.IRODSAccess{testAccess("alice", user, irodsclient_types.IRODSAccessLevelOwner)},
+			expected:  os.ModeDir | 0o700,
+		},
+		{
+			name:      "read through group",
+			entryType: irodsclient_fs.FileEntry,
+			accesses:  []*irodsclient_types.IRODSAccess{testAccess("lab", group, irodsclient_types.IRODSAccessLevelReadObject)},
+			expected:  0o440,
+		},
+		{
+			name:      "modify through group, readable by public",
+			entryType: irodsclient_fs.DirectoryEntry,
+			accesses: []*irodsclient_types.IRODSAccess{
+				testAccess("lab", group, irodsclient_types.IRODSAccessLevelModifyObject),
+				testAccess(PublicGroupName, group, irodsclient_types.IRODSAccessLevelReadObject),
+			},
+			expected: os.ModeDir | 0o775,
+		},
+		{
+			name:      "delete maps to write",
+			entryType: irodsclient_fs.FileEntry,
+			accesses:  []*irodsclient_types.IRODSAccess{testAccess("alice", user, irodsclient_types.IRODSAccessLevelDeleteObject)},
+			expected:  0o600,
+		},
+		{
+			name:      "other users and groups are ignored",
+			entryType: irodsclient_fs.FileEntry,
+			accesses: []*irodsclient_types.IRODSAccess{
+				testAccess("bob", user, irodsclient_types.IRODSAccessLevelOwner),
+				testAccess("other", group, irodsclient_types.IRODSAccessLevelOwner),
+				testAccess("alice", group, irodsclient_types.IRODSAccessLevelOwner),
+			},
+			expected: 0,
+		},
+		{
+			name:      "symlink",
+			entryType: SymlinkEntry,
+			expected:  os.ModeSymlink | 0o777,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, ACLToMode(test.entryType, test.accesses, "alice", []string{"lab"}))
+		})
+	}
+}
+
+func TestACLCache(t *testing.T) {
+	accesses := []*irodsclient_types.IRODSAccess{testAccess("alice", irodsclient_types.IRODSUserRodsUser, irodsclient_types.IRODSAccessLevelOwner)}
+
+	acls := newACLCache(time.Hour)
+	acls.put("/zone/dir", accesses)
+	acls.put("/zone/dir/file", accesses)
+	acls.put("/zone/dir2", accesses)
+
+	cached, ok := acls.get("/zone/dir/file")
+	require.True(t, ok)
+	assert.Equal(t, accesses, cached)
+
+	acls.invalidate("/zone/dir/file", false)
+	_, ok = acls.get("/zone/dir/file")
+	assert.False(t, ok)
+
+	acls.put("/zone/dir/file", accesses)
+	acls.invalidate("/zone/dir", true)
+	_, ok = acls.get("/zone/dir")
+	assert.False(t, ok)
+	_, ok = acls.get("/zone/dir/file")
+	assert.False(t, ok)
+	_, ok = acls.get("/zone/dir2")
+	assert.True(t, ok, "siblings sharing a name prefix are kept")
+
+	expiring := newACLCache(time.Millisecond)
+	expiring.put("/zone/file", accesses)
+	time.Sleep(5 * time.Millisecond)
+	_, ok = expiring.get("/zone/file")
+	assert.False(t, ok)
+
+	// Disabled caches cache nothing
+	disabled := newACLCache(-1)
+	disabled.put("/zone/file", accesses)
+	_, ok = disabled.get("/zone/file")
+	assert.False(t, ok)
+}
+
+func TestBufferedClientStagedACL(t *testing.T) {
+	mockClient := &mockStagingClient{}
+	client := newTestBufferedClient(t, &IRODSFSClientBufferedConfig{
+		StagingRootPath: t.TempDir(),
+		GracePeriod:     time.Hour,
+	}, mockClient)
+
+	// Paths deleted in staging have no ACL
+	staging := client.router.getStaging("/zone/deleted")
+	require.NoError(t, staging.Delete("/zone/deleted"))
+
+	_, err := client.GetACL("/zone/deleted")
+	assert.ErrorIs(t, err, ErrNotFound)
+	err = client.SetACL("/zone/deleted", "bob", "zone", irodsclient_types.IRODSAccessLevelReadObject, false)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.ErrorIs(t, client.SetInheritance("/zone/deleted", true, false), ErrNotFound)
+
+	// Cached ACLs are served until the path changes
+	accesses := []*irodsclient_types.IRODSAccess{testAccess("alice", irodsclient_types.IRODSUserRodsUser, irodsclient_types.IRODSAccessLevelOwner)}
+	client.acls.put("/zone/dir/file", accesses)
+
+	cached, err := client.GetACL("/zone/dir/file")
+	require.NoError(t, err)
+	assert.Equal(t, accesses, cached)
+
+	require.NoError(t, client.MakeDir("/zone/dir", false))
+	require.NoError(t, client.RemoveDir("/zone/dir", false, false))
+	_, ok := client.acls.get("/zone/dir/file")
+	assert.False(t, ok)
+}
@@ -0,0 +1,192 @@
+package irods
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+// Results of audit records
+const (
+	AuditResultOK    = "ok"
+	AuditResultError = "error"
+)
+
+// AuditRecord records a call that changed iRODS, or the sync of a staged change
+type AuditRecord struct {
+	ID           string        `json:"id"`
+	Time         time.Time     `json:"time"`                    // Start of the call or sync
+	User         string        `json:"user"`                    // Client user of the account
+	Zone         string        `json:"zone"`                    // Client zone of the account
+	ProxyUser    string        `json:"proxy_user,omitempty"`    // Proxy user of the account, if different from User
+	Host         string        `json:"host"`                    // iRODS host
+	Application  string        `json:"application"`             // Application name of the client
+	Op           string        `json:"op"`                      // Method name, e.g. "RenameFileToFile" or "Close", or the staging event of syncs, e.g. "SYNC_SUCCEEDED"
+	Path         string        `json:"path"`                    // iRODS path changed, or the source of renames and copies
+	DestPath     string        `json:"dest_path,omitempty"`     // Destination of renames and copies
+	Mode         string        `json:"mode,omitempty"`          // Open mode of file handles
+	HandleID     string        `json:"handle_id,omitempty"`     // File handle of opens, writes and closes
+	Action       string        `json:"action,omitempty"`        // Staging action of syncs, e.g. "UPLOAD"
+	Detail       string        `json:"detail,omitempty"`        // Attribute name, ACL entry or symlink target
+	BytesWritten int64         `json:"bytes_written,omitempty"` // Bytes written through a handle, uploaded or synced
+	Result       string        `json:"result"`                  // AuditResultOK or AuditResultError
+	Error        string        `json:"error,omitempty"`
+	Duration     time.Duration `json:"duration_ns"`
+	Staged       bool          `json:"staged,omitempty"`      // The change is staged locally and reaches iRODS with a later sync record
+	RelatedIDs   []string      `json:"related_ids,omitempty"` // Records of the same change: the open of a close, the staged calls a sync completes
+}
+
+// setResult sets the result of the record from the error of the call
+func (record *AuditRecord) setResult(err error) {
+	if err != nil {
+		record.Result = AuditResultError
+		record.Error = err.Error()
+		return
+	}
+	record.Result = AuditResultOK
+}
+
+// AuditSink stores audit records. Write is called by one goroutine at a time.
+type AuditSink interface {
+	Write(record *AuditRecord) error
+	Close() error
+}
+
+// AuditJSONSink writes audit records as JSON lines
+type AuditJSONSink struct {
+	mu      sync.Mutex
+	writer  io.Writer
+	encoder *json.Encoder
+}
+
+// NewAuditJSONSink creates an AuditJSONSink writing to writer, which is closed with the sink if it is an io.Closer
+func NewAuditJSONSink(writer io.Writer) *AuditJSONSink {
+	return &AuditJSONSink{
+		writer:  writer,
+		encoder: json.NewEncoder(writer),
+	}
+}
+
+// NewAuditJSONFileSink creates an AuditJSONSink appending to the file at path
+func NewAuditJSONFileSink(path string) (*AuditJSONSink, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open audit log %q", path)
+	}
+	return NewAuditJSONSink(file), nil
+}
+
+func (s *AuditJSONSink) Write(record *AuditRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.encoder.Encode(record)
+}
+
+func (s *AuditJSONSink) Close() error {
+	if closer, ok := s.writer.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// AuditSyslogWriter is the part of *syslog.Writer that AuditSyslogSink uses
+type AuditSyslogWriter interface {
+	Info(message string) error
+	Warning(message string) error
+	Close() error
+}
+
+// AuditSyslogSink writes audit records as key=value messages, at warning level for failed calls
+type AuditSyslogSink struct {
+	writer AuditSyslogWriter
+}
+
+// NewAuditSyslogSink creates an AuditSyslogSink writing to writer, e.g. a *syslog.Writer
+func NewAuditSyslogSink(writer AuditSyslogWriter) *AuditSyslogSink {
+	return &AuditSyslogSink{
+		writer: writer,
+	}
+}
+
+func (s *AuditSyslogSink) Write(record *AuditRecord) error {
+	message := formatAuditRecord(record)
+	if record.Result == AuditResultError {
+		return s.writer.Warning(message)
+	}
+	return s.writer.Info(message)
+}
+
+func (s *AuditSyslogSink) Close() error {
+	return s.writer.Close()
+}
+
+// formatAuditRecord formats a record as key=value pairs, leaving out empty values
+func formatAuditRecord(record *AuditRecord) string {
+	quote := func(value string) string {
+		if value == "" {
+			return ""
+		}
+		return strconv.Quote(value)
+	}
+
+	bytesWritten := ""
+	if record.BytesWritten > 0 {
+		bytesWritten = strconv.FormatInt(record.BytesWritten, 10)
+	}
+	staged := ""
+	if record.Staged {
+		staged = "true"
+	}
+
+	fields := []struct {
+		key   string
+		value string
+	}{
+		{"id", record.ID},
+		{"time", record.Time.UTC().Format(time.RFC3339Nano)},
+		{"user", record.User},
+		{"zone", record.Zone},
+		{"proxy_user", record.ProxyUser},
+		{"host", record.Host},
+		{"application", quote(record.Application)},
+		{"op", record.Op},
+		{"path", quote(record.Path)},
+		{"dest_path", quote(record.DestPath)},
+		{"mode", record.Mode},
+		{"handle_id", record.HandleID},
+		{"action", record.Action},
+		{"detail", quote(record.Detail)},
+		{"bytes_written", bytesWritten},
+		{"staged", staged},
+		{"result", record.Result},
+		{"error", quote(record.Error)},
+		{"duration", record.Duration.String()},
+		{"related_ids", strings.Join(record.RelatedIDs, ",")},
+	}
+
+	pairs := []string{}
+	for _, field := range fields {
+		if field.value != "" {
+			pairs = append(pairs, field.key+"="+field.value)
+		}
+	}
+	return strings.Join(pairs, " ")
+}
+
+// AuditFuncSink passes audit records to a function
+type AuditFuncSink func(record *AuditRecord) error
+
+func (f AuditFuncSink) Write(record *AuditRecord) error {
+	return f(record)
+}
+
+func (f AuditFuncSink) Close() error {
+	return nil
+}
@@ -0,0 +1,97 @@
+package irods
+
+import (
+	"encoding/base64"
+	"sort"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	irodsclient_fs "github.com/cyverse/go-irodsclient/fs"
+	irodsclient_types "github.com/cyverse/go-irodsclient/irods/types"
+)
+
+// AVUNamespace prefixes the names of AVUs that hold filesystem metadata. Other tools should not
+// set AVUs in this namespace.
+const AVUNamespace = "irodsfs::"
+
+const (
+	// SymlinkAVUName marks a data object as a symlink. Its value is the link target,
+	// which is also the content of the data object.
+	SymlinkAVUName = AVUNamespace + "symlink"
+	// XattrAVUPrefix prefixes the name of an extended attribute to make its AVU name
+	XattrAVUPrefix = AVUNamespace + "xattr::"
+	// MaxSymlinkTargetLength is the longest symlink target. Larger data objects are never symlinks,
+	// so Stat and List do not look up their AVUs.
+	MaxSymlinkTargetLength = 4096
+)
+
+// SymlinkEntry is the entry type Stat returns for symlinks
+const SymlinkEntry irodsclient_fs.EntryType = "symlink"
+
+// xattrValuePrefix marks an encoded extended attribute value. AVU values cannot be empty and must be
+// text, so values are stored base64 encoded behind this prefix. AVU values without it, e.g. set with
+// imeta, are returned as they are.
+const xattrValuePrefix = "base64:"
+
+func encodeXattrValue(value []byte) string {
+	return xattrValuePrefix + base64.StdEncoding.EncodeToString(value)
+}
+
+func decodeXattrValue(value string) ([]byte, error) {
+	if !strings.HasPrefix(value, xattrValuePrefix) {
+		return []byte(value), nil
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, xattrValuePrefix))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode extended attribute value")
+	}
+	return decoded, nil
+}
+
+// findAVU returns the first AVU named name, or nil
+func findAVU(metas []*irodsclient_types.IRODSMeta, name string) *irodsclient_types.IRODSMeta {
+	for _, meta := range metas {
+		if meta.Name == name {
+			return meta
+		}
+	}
+	return nil
+}
+
+// xattrNames returns the names of the extended attributes in metas, sorted and without duplicates
+func xattrNames(metas []*irodsclient_types.IRODSMeta) []string {
+	seen := map[string]bool{}
+	names := []string{}
+	for _, meta := range metas {
+		if !strings.HasPrefix(meta.Name, XattrAVUPrefix) {
+			continue
+		}
+
+		name := strings.TrimPrefix(meta.Name, XattrAVUPrefix)
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// validateSymlinkTarget checks that target can be stored as a symlink
+func validateSymlinkTarget(linkPath string, target string) error {
+	if target == "" || len(target) > MaxSymlinkTargetLength {
+		return newError("symlink", linkPath, ErrInvalid, errors.Newf("symlink target must be 1 to %d bytes long", MaxSymlinkTargetLength))
+	}
+	return nil
+}
+
+// validateXattrName checks that name can be stored as an extended attribute
+func validateXattrName(op string, path string, name string) error {
+	if name == "" {
+		return newError(op, path, ErrInvalid, errors.New("empty extended attribute name"))
+	}
+	return nil
+}
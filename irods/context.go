@@ -0,0 +1,533 @@
+package irods
+
+import (
+	"context"
+	"os"
+	"sync"
+
+	"github.com/cockroachdb/errors"
+	irodsclient_fs "github.com/cyverse/go-irodsclient/fs"
+	irodsclient_common "github.com/cyverse/go-irodsclient/irods/common"
+	irodsclient_types "github.com/cyverse/go-irodsclient/irods/types"
+	"github.com/cyverse/irodsfs-common/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// contextLogger logs panics of calls that run in the background of waitContext and readAtContext
+var contextLogger = log.NewEntry(log.StandardLogger())
+
+// waitContext runs fn and waits for it until ctx is done.
+// After ctx is done fn keeps running in the background, so it must only read and must not
+// touch memory owned by the caller.
+func waitContext(ctx context.Context, fn func() error) error {
+	if ctx.Done() == nil {
+		// Not cancellable, run in the caller's goroutine
+		return fn()
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		// Nobody may wait for an abandoned call anymore, log where it panicked
+		defer util.StackTraceFromPanic(contextLogger)
+
+		errCh <- fn()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// readBufferPool holds the private buffers of cancellable reads
+var readBufferPool = sync.Pool{
+	New: func() any {
+		return &[]byte{}
+	},
+}
+
+// readAtContext reads through readAt until ctx is done. A cancellable read goes to a private buffer,
+// so that an abandoned read cannot write to the caller's buffer later. Buffers of completed reads are
+// reused, the buffer of an abandoned read is left to the read.
+func readAtContext(ctx context.Context, readAt func(buffer []byte, offset int64) (int, error), buffer []byte, offset int64) (int, error) {
+	if ctx.Done() == nil {
+		return readAt(buffer, offset)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	pooled := readBufferPool.Get().(*[]byte)
+	if cap(*pooled) < len(buffer) {
+		*pooled = make([]byte, len(buffer))
+	}
+	readBuffer := (*pooled)[:len(buffer)]
+
+	type readResult struct {
+		readLen int
+		err     error
+	}
+
+	resultCh := make(chan readResult, 1)
+	go func() {
+		defer util.StackTraceFromPanic(contextLogger)
+
+		readLen, err := readAt(readBuffer, offset)
+		resultCh <- readResult{readLen: readLen, err: err}
+	}()
+
+	select {
+	case result := <-resultCh:
+		copy(buffer, readBuffer[:result.readLen])
+		readBufferPool.Put(pooled)
+		return result.readLen, result.err
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+// contextBlockCallback wraps a block callback so that a transfer stops at the next block once ctx is done
+func contextBlockCallback(ctx context.Context, blockReadyCallback irodsclient_common.DataObjectBlockCallback) irodsclient_common.DataObjectBlockCallback {
+	if ctx.Done() == nil {
+		return blockReadyCallback
+	}
+
+	return func(data []byte, offset int64) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if blockReadyCallback == nil {
+			return nil
+		}
+		return blockReadyCallback(data, offset)
+	}
+}
+
+var (
+	_ IRODSFSClientCtx     = (*IRODSFSClientDirect)(nil)
+	_ IRODSFSClientCtx     = (*IRODSFSClientBuffered)(nil)
+	_ IRODSFSClientCtx     = (*IRODSFSClientRetry)(nil)
+	_ IRODSFSClientCtx     = (*IRODSFSClientFaulty)(nil)
+	_ IRODSFSClientCtx     = (*IRODSFSClientReadOnly)(nil)
+	_ IRODSFSClientCtx     = (*IRODSFSClientAudit)(nil)
+	_ IRODSFSFileHandleCtx = (*IRODSFSClientDirectFileHandle)(nil)
+	_ IRODSFSFileHandleCtx = (*IRODSFSClientBufferedFileHandle)(nil)
+	_ IRODSFSFileHandleCtx = (*IRODSFSClientRetryFileHandle)(nil)
+	_ IRODSFSFileHandleCtx = (*IRODSFSClientFaultyFileHandle)(nil)
+	_ IRODSFSFileHandleCtx = (*IRODSFSClientAuditFileHandle)(nil)
+)
+
+// downloadFileByBlocks writes the blocks download passes to its callback into localPath and
+// stops at the next block once ctx is done
+func downloadFileByBlocks(ctx context.Context, localPath string, download func(blockReadyCallback irodsclient_common.DataObjectBlockCallback) error) error {
+	localFile, err := os.OpenFile(localPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create local file %q", localPath)
+	}
+
+	err = download(contextBlockCallback(ctx, func(data []byte, offset int64) error {
+		_, writeErr := localFile.WriteAt(data, offset)
+		return writeErr
+	}))
+	if err != nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+
+	if closeErr := localFile.Close(); err == nil && closeErr != nil {
+		err = errors.Wrapf(closeErr, "failed to close local file %q", localPath)
+	}
+	return err
+}
+
+// WithContext returns client as an IRODSFSClientCtx. Clients that do not implement it are wrapped:
+// reads wait until ctx is done, block downloads stop at the next block, and all other operations
+// only check ctx before they start.
+func WithContext(client IRODSFSClient) IRODSFSClientCtx {
+	if ctxClient, ok := client.(IRODSFSClientCtx); ok {
+		return ctxClient
+	}
+	return &contextClient{IRODSFSClient: client}
+}
+
+// WithContextHandle returns handle as an IRODSFSFileHandleCtx, wrapping handles that do not implement it
+func WithContextHandle(handle IRODSFSFileHandle) IRODSFSFileHandleCtx {
+	if ctxHandle, ok := handle.(IRODSFSFileHandleCtx); ok {
+		return ctxHandle
+	}
+	return &contextFileHandle{IRODSFSFileHandle: handle}
+}
+
+// contextClient adds context variants to an IRODSFSClient. Reads wait until ctx is done; calls that
+// change iRODS or local files only check ctx before they start and then run to completion.
+type contextClient struct {
+	IRODSFSClient
+}
+
+func (c *contextClient) ListCtx(ctx context.Context, path string) ([]*irodsclient_fs.Entry, error) {
+	var entries []*irodsclient_fs.Entry
+	err := waitContext(ctx, func() error {
+		var listErr error
+		entries, listErr = c.List(path)
+		return listErr
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (c *contextClient) StatCtx(ctx context.Context, path string) (*irodsclient_fs.Entry, error) {
+	var entry *irodsclient_fs.Entry
+	err := waitContext(ctx, func() error {
+		var statErr error
+		entry, statErr = c.Stat(path)
+		return statErr
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func (c *contextClient) ExistsDirCtx(ctx context.Context, path string) bool {
+	exists := false
+	err := waitContext(ctx, func() error {
+		exists = c.ExistsDir(path)
+		return nil
+	})
+	return err == nil && exists
+}
+
+func (c *contextClient) ExistsFileCtx(ctx context.Context, path string) bool {
+	exists := false
+	err := waitContext(ctx, func() error {
+		exists = c.ExistsFile(path)
+		return nil
+	})
+	return err == nil && exists
+}
+
+func (c *contextClient) RemoveFileCtx(ctx context.Context, path string, force bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.RemoveFile(path, force)
+}
+
+func (c *contextClient) RemoveDirCtx(ctx context.Context, path string, recurse bool, force bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.RemoveDir(path, recurse, force)
+}
+
+func (c *contextClient) MakeDirCtx(ctx context.Context, path string, recurse bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.MakeDir(path, recurse)
+}
+
+func (c *contextClient) RenameDirToDirCtx(ctx context.Context, srcPath string, destPath string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.RenameDirToDir(srcPath, destPath)
+}
+
+func (c *contextClient) RenameFileToFileCtx(ctx context.Context, srcPath string, destPath string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.RenameFileToFile(srcPath, destPath)
+}
+
+func (c *contextClient) CreateFileCtx(ctx context.Context, path string, mode string) (IRODSFSFileHandle, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	handle, err := c.CreateFile(path, mode)
+	if err != nil {
+		return nil, err
+	}
+	return WithContextHandle(handle), nil
+}
+
+func (c *contextClient) OpenFileCtx(ctx context.Context, path string, mode string) (IRODSFSFileHandle, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	handle, err := c.OpenFile(path, mode)
+	if err != nil {
+		return nil, err
+	}
+	return WithContextHandle(handle), nil
+}
+
+func (c *contextClient) CreateFileWithOptionsCtx(ctx context.Context, path string, mode string, options *OpenOptions) (IRODSFSFileHandle, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	handle, err := c.CreateFileWithOptions(path, mode, options)
+	if err != nil {
+		return nil, err
+	}
+	return WithContextHandle(handle), nil
+}
+
+func (c *contextClient) OpenFileWithOptionsCtx(ctx context.Context, path string, mode string, options *OpenOptions) (IRODSFSFileHandle, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	handle, err := c.OpenFileWithOptions(path, mode, options)
+	if err != nil {
+		return nil, err
+	}
+	return WithContextHandle(handle), nil
+}
+
+func (c *contextClient) ListReplicasCtx(ctx context.Context, path string) ([]*irodsclient_types.IRODSReplica, error) {
+	var replicas []*irodsclient_types.IRODSReplica
+	err := waitContext(ctx, func() error {
+		var listErr error
+		replicas, listErr = c.ListReplicas(path)
+		return listErr
+	})
+	if err != nil {
+		return nil, err
+	}
+	return replicas, nil
+}
+
+func (c *contextClient) TruncateFileCtx(ctx context.Context, path string, size int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.TruncateFile(path, size)
+}
+
+func (c *contextClient) SymlinkCtx(ctx context.Context, target string, linkPath string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.Symlink(target, linkPath)
+}
+
+func (c *contextClient) ReadlinkCtx(ctx context.Context, path string) (string, error) {
+	var target string
+	err := waitContext(ctx, func() error {
+		var readErr error
+		target, readErr = c.Readlink(path)
+		return readErr
+	})
+	if err != nil {
+		return "", err
+	}
+	return target, nil
+}
+
+func (c *contextClient) GetXattrCtx(ctx context.Context, path string, name string) ([]byte, error) {
+	var value []byte
+	err := waitContext(ctx, func() error {
+		var getErr error
+		value, getErr = c.GetXattr(path, name)
+		return getErr
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+func (c *contextClient) SetXattrCtx(ctx context.Context, path string, name string, value []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.SetXattr(path, name, value)
+}
+
+func (c *contextClient) ListXattrCtx(ctx context.Context, path string) ([]string, error) {
+	var names []string
+	err := waitContext(ctx, func() error {
+		var listErr error
+		names, listErr = c.ListXattr(path)
+		return listErr
+	})
+	if err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+func (c *contextClient) RemoveXattrCtx(ctx context.Context, path string, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.RemoveXattr(path, name)
+}
+
+func (c *contextClient) GetACLCtx(ctx context.Context, path string) ([]*irodsclient_types.IRODSAccess, error) {
+	var accesses []*irodsclient_types.IRODSAccess
+	err := waitContext(ctx, func() error {
+		var getErr error
+		accesses, getErr = c.GetACL(path)
+		return getErr
+	})
+	if err != nil {
+		return nil, err
+	}
+	return accesses, nil
+}
+
+func (c *contextClient) SetACLCtx(ctx context.Context, path string, userName string, zoneName string, access irodsclient_types.IRODSAccessLevelType, recurse bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.SetACL(path, userName, zoneName, access, recurse)
+}
+
+func (c *contextClient) SetInheritanceCtx(ctx context.Context, path string, inherit bool, recurse bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.SetInheritance(path, inherit, recurse)
+}
+
+func (c *contextClient) CopyFileToFileCtx(ctx context.Context, srcPath string, destPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.CopyFileToFile(srcPath, destPath, transferCallback)
+}
+
+func (c *contextClient) CopyDirToDirCtx(ctx context.Context, srcPath string, destPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.CopyDirToDir(srcPath, destPath, transferCallback)
+}
+
+func (c *contextClient) SyncCtx(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.Sync()
+}
+
+func (c *contextClient) DownloadFileCtx(ctx context.Context, irodsPath string, localPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.DownloadFile(irodsPath, localPath, transferCallback)
+}
+
+func (c *contextClient) DownloadFileParallelCtx(ctx context.Context, irodsPath string, localPath string, taskNum int, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.DownloadFileParallel(irodsPath, localPath, taskNum, transferCallback)
+}
+
+func (c *contextClient) DownloadFileWithCallbackCtx(ctx context.Context, irodsPath string, blockSize int, numBlocks int, blockReadyCallback irodsclient_common.DataObjectBlockCallback, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.DownloadFileWithCallback(irodsPath, blockSize, numBlocks, contextBlockCallback(ctx, blockReadyCallback), transferCallback)
+}
+
+func (c *contextClient) DownloadFileParallelWithCallbackCtx(ctx context.Context, irodsPath string, blockSize int, numBlocks int, blockReadyCallback irodsclient_common.DataObjectBlockCallback, taskNum int, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.DownloadFileParallelWithCallback(irodsPath, blockSize, numBlocks, contextBlockCallback(ctx, blockReadyCallback), taskNum, transferCallback)
+}
+
+func (c *contextClient) UploadFileCtx(ctx context.Context, localPath string, irodsPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.UploadFile(localPath, irodsPath, transferCallback)
+}
+
+func (c *contextClient) UploadFileParallelCtx(ctx context.Context, localPath string, irodsPath string, taskNum int, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.UploadFileParallel(localPath, irodsPath, taskNum, transferCallback)
+}
+
+func (c *contextClient) DownloadFileWithOptionsCtx(ctx context.Context, irodsPath string, localPath string, options *TransferOptions) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if options != nil && options.BlockReadyCallback != nil {
+		withCallback := *options
+		withCallback.BlockReadyCallback = contextBlockCallback(ctx, options.BlockReadyCallback)
+		options = &withCallback
+	}
+	return c.DownloadFileWithOptions(irodsPath, localPath, options)
+}
+
+func (c *contextClient) UploadFileWithOptionsCtx(ctx context.Context, localPath string, irodsPath string, options *TransferOptions) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.UploadFileWithOptions(localPath, irodsPath, options)
+}
+
+func (c *contextClient) UploadDirCtx(ctx context.Context, localDir string, irodsDir string, options *DirTransferOptions) (*DirTransferReport, error) {
+	return uploadDir(ctx, c, localDir, irodsDir, options)
+}
+
+func (c *contextClient) DownloadDirCtx(ctx context.Context, irodsDir string, localDir string, options *DirTransferOptions) (*DirTransferReport, error) {
+	return downloadDir(ctx, c, irodsDir, localDir, options)
+}
+
+func (c *contextClient) CacheFileCtx(ctx context.Context, irodsPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.CacheFile(irodsPath, transferCallback)
+}
+
+// contextFileHandle adds context variants to an IRODSFSFileHandle. ReadAtCtx waits until ctx is
+// done; WriteAtCtx, TruncateCtx and FlushCtx only check ctx before they start.
+type contextFileHandle struct {
+	IRODSFSFileHandle
+}
+
+func (h *contextFileHandle) ReadAtCtx(ctx context.Context, buffer []byte, offset int64) (int, error) {
+	return readAtContext(ctx, h.ReadAt, buffer, offset)
+}
+
+func (h *contextFileHandle) WriteAtCtx(ctx context.Context, data []byte, offset int64) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return h.WriteAt(data, offset)
+}
+
+func (h *contextFileHandle) TruncateCtx(ctx context.Context, size int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return h.Truncate(size)
+}
+
+func (h *contextFileHandle) FlushCtx(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return h.Flush()
+}
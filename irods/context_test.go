@@ -0,0 +1,205 @@
+package irods
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	irodsclient_fs "github.com/cyverse/go-irodsclient/fs"
+	irodsclient_types "github.com/cyverse/go-irodsclient/irods/types"
+	"github.com/cyverse/irodsfs-common/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// blockingClient implements the IRODSFSClient methods used by the tests, blocking List until released
+type blockingClient struct {
+	IRODSFSClient
+
+	release chan struct{}
+
+	mu      sync.Mutex
+	removed []string
+}
+
+func (c *blockingClient) List(path string) ([]*irodsclient_fs.Entry, error) {
+	<-c.release
+	return []*irodsclient_fs.Entry{}, nil
+}
+
+func (c *blockingClient) RemoveFile(path string, force bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.removed = append(c.removed, path)
+	return nil
+}
+
+// blockingFileHandle blocks ReadAt until released, then writes to the buffer it was given
+type blockingFileHandle struct {
+	*mockFileHandle
+
+	started chan struct{}
+	release chan struct{}
+}
+
+func (h *blockingFileHandle) ReadAt(buffer []byte, offset int64) (int, error) {
+	close(h.started)
+	<-h.release
+	return h.mockFileHandle.ReadAt(buffer, offset)
+}
+
+func TestWithContextListCancelled(t *testing.T) {
+	mock := &blockingClient{release: make(chan struct{})}
+	defer close(mock.release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := WithContext(mock).ListCtx(ctx, "/zone/dir")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestWithContextMutationNotStarted(t *testing.T) {
+	mock := &blockingClient{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := WithContext(mock).RemoveFileCtx(ctx, "/zone/file", false)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Empty(t, mock.removed)
+
+	require.NoError(t, WithContext(mock).RemoveFileCtx(context.Background(), "/zone/file", false))
+	assert.Equal(t, []string{"/zone/file"}, mock.removed)
+}
+
+func TestWithContextHandleReadCancelled(t *testing.T) {
+	mock := &blockingFileHandle{
+		mockFileHandle: newMockFileHandle("/test/file.dat", []byte("0123456789"), irodsclient_types.FileOpenModeReadOnly),
+		started:        make(chan struct{}),
+		release:        make(chan struct{}),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	buf := make([]byte, 10)
+
+	readDone := make(chan error)
+	go func() {
+		_, err := WithContextHandle(mock).ReadAtCtx(ctx, buf, 0)
+		readDone <- err
+	}()
+
+	<-mock.started
+	cancel()
+	assert.ErrorIs(t, <-readDone, context.Canceled)
+
+	// The abandoned read completes later without touching the caller's buffer
+	close(mock.release)
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, make([]byte, 10), buf)
+}
+
+func TestWithContextHandleReadCompleted(t *testing.T) {
+	mock := newMockFileHandle("/test/file.dat", []byte("0123456789"), irodsclient_types.FileOpenModeReadOnly)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Reads of different sizes through reused private buffers return only their own data
+	for _, tc := range []struct {
+		size     int
+		offset   int64
+		expected string
+	}{
+		{size: 10, offset: 0, expected: "0123456789"},
+		{size: 4, offset: 8, expected: "89"},
+		{size: 3, offset: 2, expected: "234"},
+	} {
+		buf := make([]byte, tc.size)
+		readLen, err := WithContextHandle(mock).ReadAtCtx(ctx, buf, tc.offset)
+		if err != nil {
+			require.ErrorIs(t, err, io.EOF)
+		}
+		assert.Equal(t, tc.expected, string(buf[:readLen]))
+	}
+}
+
+func TestBufferedFileHandleReadAtCtxCancelled(t *testing.T) {
+	cacheMgr := newTestCacheManager(t)
+	defer cacheMgr.Release()
+
+	mock := newMockFileHandle("/test/file.dat", []byte("0123456789abcdef"), irodsclient_types.FileOpenModeReadOnly)
+	handle := &IRODSFSClientBufferedFileHandle{
+		client:    &IRODSFSClientBuffered{logger: newTestLogger()},
+		handle:    mock,
+		cache:     cacheMgr,
+		irodsPath: "/test/file.dat",
+		helper:    util.NewFileBlockHelper(16),
+		logger:    newTestLogger(),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	buf := make([]byte, 16)
+	_, err := handle.ReadAtCtx(ctx, buf, 0)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Nil(t, cacheMgr.Get(handle.makeCacheKey(0)))
+
+	n, err := handle.ReadAtCtx(context.Background(), buf, 0)
+	assert.Equal(t, 16, n)
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Equal(t, []byte("0123456789abcdef"), buf)
+}
+
+func TestStagedHandleCtxCancelled(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "staged-ctx-*")
+	require.NoError(t, err)
+	defer os.Remove(tmpFile.Name())
+
+	handle := newStagedHandle(nil, tmpFile, "/test/staged.dat",
+		irodsclient_types.FileOpenModeReadWrite,
+		&irodsclient_fs.Entry{
+			Type: irodsclient_fs.FileEntry,
+			Name: "staged.dat",
+			Path: "/test/staged.dat",
+		})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = handle.WriteAtCtx(ctx, []byte("hello"), 0)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, int64(0), handle.GetEntry().Size)
+
+	_, err = handle.ReadAtCtx(ctx, make([]byte, 5), 0)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.ErrorIs(t, handle.TruncateCtx(ctx, 3), context.Canceled)
+}
+
+func TestBufferedClientMakeDirCtxCancelled(t *testing.T) {
+	mockClient := &mockStagingClient{}
+	router := newTestStagingRouter(t, &IRODSFSClientBufferedConfig{
+		StagingRootPath: t.TempDir(),
+		GracePeriod:     time.Hour,
+	}, mockClient)
+
+	client := &IRODSFSClientBuffered{
+		cache:  newTestCacheManager(t),
+		helper: util.NewFileBlockHelper(1024 * 1024),
+		router: router,
+		logger: newTestLogger(),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	assert.ErrorIs(t, client.MakeDirCtx(ctx, "/zone/dir", false), context.Canceled)
+	assert.Nil(t, router.getStaging("/zone/dir").Get("/zone/dir"))
+	assert.ErrorIs(t, client.SyncCtx(ctx), context.Canceled)
+}
@@ -0,0 +1,126 @@
+package irods
+
+import (
+	"context"
+	"path"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	irodsclient_fs "github.com/cyverse/go-irodsclient/fs"
+	irodsclient_common "github.com/cyverse/go-irodsclient/irods/common"
+)
+
+// CopyTaskName is the task name passed to transfer callbacks of copies
+const CopyTaskName = "copy"
+
+// copyClient is what copyDir needs from a client
+type copyClient interface {
+	StatCtx(ctx context.Context, path string) (*irodsclient_fs.Entry, error)
+	ListCtx(ctx context.Context, path string) ([]*irodsclient_fs.Entry, error)
+	MakeDirCtx(ctx context.Context, path string, recurse bool) error
+	CopyFileToFileCtx(ctx context.Context, srcPath string, destPath string, transferCallback irodsclient_common.TransferTrackerCallback) error
+}
+
+// copyFileTask is a file copied by copyDir
+type copyFileTask struct {
+	srcPath  string
+	destPath string
+	size     int64
+}
+
+// validateCopyPaths rejects copies of an entry onto itself or a directory into itself
+func validateCopyPaths(srcPath string, destPath string) error {
+	if destPath == srcPath || strings.HasPrefix(destPath, strings.TrimSuffix(srcPath, "/")+"/") {
+		return newError("copy", destPath, ErrInvalid, errors.Newf("cannot copy %q into itself", srcPath))
+	}
+	return nil
+}
+
+// copyDir copies the directory srcPath recursively to destPath with client.CopyFileToFileCtx.
+// destPath is created if it does not exist; files in it are replaced. Directories are created first,
+// then files are copied one by one, reporting progress over the total size of all files.
+func copyDir(ctx context.Context, client copyClient, srcPath string, destPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	if err := validateCopyPaths(srcPath, destPath); err != nil {
+		return err
+	}
+
+	srcEntry, err := client.StatCtx(ctx, srcPath)
+	if err != nil {
+		return err
+	}
+	if !srcEntry.IsDir() {
+		return newError("copy", srcPath, ErrInvalid, errors.New("not a directory"))
+	}
+
+	tasks := []copyFileTask{}
+	if err := planDirCopy(ctx, client, srcPath, destPath, &tasks); err != nil {
+		return err
+	}
+
+	total := int64(0)
+	for _, task := range tasks {
+		total += task.size
+	}
+
+	copied := int64(0)
+	for _, task := range tasks {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		var fileCallback irodsclient_common.TransferTrackerCallback
+		if transferCallback != nil {
+			done := copied
+			fileCallback = func(_ string, processed int64, _ int64) {
+				transferCallback(CopyTaskName, done+processed, total)
+			}
+		}
+
+		if err := client.CopyFileToFileCtx(ctx, task.srcPath, task.destPath, fileCallback); err != nil {
+			return err
+		}
+
+		copied += task.size
+		if transferCallback != nil {
+			transferCallback(CopyTaskName, copied, total)
+		}
+	}
+	return nil
+}
+
+// planDirCopy creates destPath and the directories below it, adding the files to copy to tasks
+func planDirCopy(ctx context.Context, client copyClient, srcPath string, destPath string, tasks *[]copyFileTask) error {
+	destEntry, err := client.StatCtx(ctx, destPath)
+	switch {
+	case err == nil && !destEntry.IsDir():
+		return newError("copy", destPath, ErrExists, errors.New("not a directory"))
+	case errors.Is(err, ErrNotFound):
+		if err := client.MakeDirCtx(ctx, destPath, false); err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	}
+
+	entries, err := client.ListCtx(ctx, srcPath)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		destEntryPath := path.Join(destPath, entry.Name)
+		if entry.IsDir() {
+			if err := planDirCopy(ctx, client, entry.Path, destEntryPath, tasks); err != nil {
+				return err
+			}
+			continue
+		}
+
+		*tasks = append(*tasks, copyFileTask{
+			srcPath:  entry.Path,
+			destPath: destEntryPath,
+			size:     entry.Size,
+		})
+	}
+	return nil
+}
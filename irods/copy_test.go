@@ -0,0 +1,157 @@
+package irods
+
+import (
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	irodsclient_fs "github.com/cyverse/go-irodsclient/fs"
+	irodsclient_common "github.com/cyverse/go-irodsclient/irods/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memCopyClient is an in-memory copyClient
+type memCopyClient struct {
+	dirs  map[string]bool
+	files map[string]string
+}
+
+func (m *memCopyClient) StatCtx(ctx context.Context, p string) (*irodsclient_fs.Entry, error) {
+	if m.dirs[p] {
+		return &irodsclient_fs.Entry{Type: irodsclient_fs.DirectoryEntry, Name: path.Base(p), Path: p}, nil
+	}
+	if content, ok := m.files[p]; ok {
+		return &irodsclient_fs.Entry{Type: irodsclient_fs.FileEntry, Name: path.Base(p), Path: p, Size: int64(len(content))}, nil
+	}
+	return nil, newError("stat", p, ErrNotFound, nil)
+}
+
+func (m *memCopyClient) ListCtx(ctx context.Context, p string) ([]*irodsclient_fs.Entry, error) {
+	entries := []*irodsclient_fs.Entry{}
+	for _, names := range []map[string]bool{m.dirs, m.fileNames()} {
+		for name := range names {
+			if name != p && path.Dir(name) == p {
+				entry, _ := m.StatCtx(ctx, name)
+				entries = append(entries, entry)
+			}
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	return entries, nil
+}
+
+func (m *memCopyClient) fileNames() map[string]bool {
+	names := map[string]bool{}
+	for name := range m.files {
+		names[name] = true
+	}
+	return names
+}
+
+func (m *memCopyClient) MakeDirCtx(ctx context.Context, p string, recurse bool) error {
+	if !m.dirs[path.Dir(p)] {
+		return newError("mkdir", p, ErrNotFound, nil)
+	}
+	m.dirs[p] = true
+	return nil
+}
+
+func (m *memCopyClient) CopyFileToFileCtx(ctx context.Context, srcPath string, destPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	content := m.files[srcPath]
+	m.files[destPath] = content
+	if transferCallback != nil {
+		transferCallback(CopyTaskName, int64(len(content)), int64(len(content)))
+	}
+	return nil
+}
+
+func TestCopyDir(t *testing.T) {
+	client := &memCopyClient{
+		dirs: map[string]bool{"/zone": true, "/zone/src": true, "/zone/src/sub": true, "/zone/src/empty": true},
+		files: map[string]string{
+			"/zone/src/a":     "12345",
+			"/zone/src/sub/b": "123",
+		},
+	}
+
+	progress := []int64{}
+	err := copyDir(context.Background(), client, "/zone/src", "/zone/dest", func(taskName string, processed int64, total int64) {
+		assert.Equal(t, CopyTaskName, taskName)
+		assert.Equal(t, int64(8), total)
+		progress = append(progress, processed)
+	})
+	require.NoError(t, err)
+
+	assert.True(t, client.dirs["/zone/dest"])
+	assert.True(t, client.dirs["/zone/dest/sub"])
+	assert.True(t, client.dirs["/zone/dest/empty"])
+	assert.Equal(t, "12345", client.files["/zone/dest/a"])
+	assert.Equal(t, "123", client.files["/zone/dest/sub/b"])
+	assert.Equal(t, int64(8), progress[len(progress)-1])
+	assert.True(t, sort.SliceIsSorted(progress, func(i, j int) bool { return progress[i] < progress[j] }))
+
+	// Copying into an existing directory replaces its files
+	client.files["/zone/src/a"] = "changed"
+	require.NoError(t, copyDir(context.Background(), client, "/zone/src", "/zone/dest", nil))
+	assert.Equal(t, "changed", client.files["/zone/dest/a"])
+
+	err = copyDir(context.Background(), client, "/zone/src", "/zone/src/sub/copy", nil)
+	assert.ErrorIs(t, err, ErrInvalid)
+	err = copyDir(context.Background(), client, "/zone/src/a", "/zone/copy", nil)
+	assert.ErrorIs(t, err, ErrInvalid)
+	err = copyDir(context.Background(), client, "/zone/src", "/zone/dest/a", nil)
+	assert.ErrorIs(t, err, ErrExists)
+	err = copyDir(context.Background(), client, "/zone/missing", "/zone/copy", nil)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, copyDir(ctx, client, "/zone/src", "/zone/dest2", nil), context.Canceled)
+}
+
+func TestCopyStagedData(t *testing.T) {
+	dir := t.TempDir()
+	src, err := os.Create(filepath.Join(dir, "src"))
+	require.NoError(t, err)
+	defer src.Close()
+	_, err = src.WriteString(strings.Repeat("x", 10))
+	require.NoError(t, err)
+
+	dest, err := os.Create(filepath.Join(dir, "dest"))
+	require.NoError(t, err)
+	defer dest.Close()
+	_, err = dest.WriteString(strings.Repeat("old", 10))
+	require.NoError(t, err)
+
+	progress := []int64{}
+	err = copyStagedData(context.Background(), dest, src, 10, 4, func(taskName string, processed int64, total int64) {
+		assert.Equal(t, int64(10), total)
+		progress = append(progress, processed)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []int64{4, 8, 10}, progress)
+
+	content, err := os.ReadFile(filepath.Join(dir, "dest"))
+	require.NoError(t, err)
+	assert.Equal(t, strings.Repeat("x", 10), string(content))
+}
+
+func TestBufferedClientCopyStaged(t *testing.T) {
+	client := newTestBufferedClient(t, &IRODSFSClientBufferedConfig{
+		StagingRootPath: t.TempDir(),
+		GracePeriod:     time.Hour,
+	}, &mockStagingClient{})
+
+	staging := client.router.getStaging("/zone/deleted")
+	require.NoError(t, staging.Delete("/zone/deleted"))
+
+	assert.ErrorIs(t, client.CopyFileToFile("/zone/deleted", "/zone/copy", nil), ErrNotFound)
+	assert.ErrorIs(t, client.CopyFileToFile("/zone/file", "/zone/file", nil), ErrInvalid)
+	assert.ErrorIs(t, client.CopyDirToDir("/zone/dir", "/zone/dir/copy", nil), ErrInvalid)
+}
@@ -0,0 +1,614 @@
+package irods
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"hash"
+	"hash/adler32"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	irodsclient_fs "github.com/cyverse/go-irodsclient/fs"
+	irodsclient_common "github.com/cyverse/go-irodsclient/irods/common"
+)
+
+// DefaultDirTransferParallel is how many files a directory transfer moves at once by default
+const DefaultDirTransferParallel = 4
+
+// SkipMode tells how directory transfers decide that a destination file is the same as its source
+type SkipMode string
+
+const (
+	SkipNever       SkipMode = ""           // Transfer all files
+	SkipSizeAndTime SkipMode = "size+mtime" // Skip files of the same size whose destination is not older than the source
+	SkipChecksum    SkipMode = "checksum"   // Skip files whose iRODS checksum matches the local file; files without one are transferred
+)
+
+// DirTransferOptions configures UploadDir and DownloadDir
+type DirTransferOptions struct {
+	FileOptions TransferOptions // Options of each file transfer; its TransferCallback is not called
+	Parallel    int             // Files transferred at once (0 = DefaultDirTransferParallel)
+
+	// Glob patterns in path.Match syntax, matched against paths relative to the transferred directory,
+	// then against base names. Include selects files (empty = all files); Exclude drops files and whole
+	// directories. Excluded entries are never deleted.
+	Include []string
+	Exclude []string
+
+	Skip             SkipMode
+	DeleteExtraneous bool // Delete destination files and directories that are not in the source, keeping directories that hold excluded entries
+	DryRun           bool // Only report what would be done
+
+	// Progress over the total size of all transferred files
+	TransferCallback irodsclient_common.TransferTrackerCallback
+}
+
+// DirTransferAction is what a directory transfer does with an entry
+type DirTransferAction string
+
+const (
+	DirTransferMkdir  DirTransferAction = "mkdir"
+	DirTransferCopy   DirTransferAction = "transfer"
+	DirTransferSkip   DirTransferAction = "skip"
+	DirTransferDelete DirTransferAction = "delete"
+)
+
+// DirTransferItem is an entry of a directory transfer and what is done with it
+type DirTransferItem struct {
+	Action    DirTransferAction
+	IRODSPath string
+	LocalPath string
+	IsDir     bool
+	Size      int64
+	ModTime   time.Time
+}
+
+// DirTransferReport lists what a directory transfer did, or would do in a dry run.
+// Items are in the order they are applied: directories first, then files, then deletions.
+type DirTransferReport struct {
+	Items       []DirTransferItem
+	Transferred int   // Files transferred
+	Skipped     int   // Files that were the same at the destination
+	Deleted     int   // Extraneous files and directories deleted
+	Bytes       int64 // Bytes transferred
+}
+
+// dirTransferClient is what directory transfers need from a client
+type dirTransferClient interface {
+	StatCtx(ctx context.Context, path string) (*irodsclient_fs.Entry, error)
+	ListCtx(ctx context.Context, path string) ([]*irodsclient_fs.Entry, error)
+	MakeDirCtx(ctx context.Context, path string, recurse bool) error
+	RemoveFileCtx(ctx context.Context, path string, force bool) error
+	RemoveDirCtx(ctx context.Context, path string, recurse bool, force bool) error
+	DownloadFileWithOptionsCtx(ctx context.Context, irodsPath string, localPath string, options *TransferOptions) error
+	UploadFileWithOptionsCtx(ctx context.Context, localPath string, irodsPath string, options *TransferOptions) error
+}
+
+// dirTransferEntry is a file or directory below the root of a directory transfer
+type dirTransferEntry struct {
+	isDir      bool
+	size       int64
+	modTime    time.Time
+	irods      *irodsclient_fs.Entry // Set for iRODS entries
+	unselected bool                  // Set for directories holding entries left out of the transfer
+}
+
+// dirTransfer is a directory upload or download
+type dirTransfer struct {
+	client    dirTransferClient
+	direction TransferDirection
+	irodsDir  string
+	localDir  string
+	options   *DirTransferOptions
+}
+
+// uploadDir uploads localDir recursively to irodsDir with client
+func uploadDir(ctx context.Context, client dirTransferClient, localDir string, irodsDir string, options *DirTransferOptions) (*DirTransferReport, error) {
+	transfer := &dirTransfer{client: client, direction: TransferUpload, irodsDir: irodsDir, localDir: localDir, options: options}
+	return transfer.run(ctx)
+}
+
+// downloadDir downloads irodsDir recursively to localDir with client
+func downloadDir(ctx context.Context, client dirTransferClient, irodsDir string, localDir string, options *DirTransferOptions) (*DirTransferReport, error) {
+	transfer := &dirTransfer{client: client, direction: TransferDownload, irodsDir: irodsDir, localDir: localDir, options: options}
+	return transfer.run(ctx)
+}
+
+func (t *dirTransfer) run(ctx context.Context) (*DirTransferReport, error) {
+	if t.options == nil {
+		t.options = &DirTransferOptions{}
+	}
+
+	localEntries, localFound, err := t.walkLocal()
+	if err != nil {
+		return nil, err
+	}
+	irodsEntries, irodsFound, err := t.walkIRODS(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	src, dest, srcFound, destFound := localEntries, irodsEntries, localFound, irodsFound
+	if t.direction == TransferDownload {
+		src, dest, srcFound, destFound = irodsEntries, localEntries, irodsFound, localFound
+	}
+	if !srcFound {
+		return nil, newError(string(t.direction), t.rootPath(true), ErrNotFound, errors.New("no such directory"))
+	}
+
+	report, err := t.plan(src, dest, destFound)
+	if err != nil || t.options.DryRun {
+		return report, err
+	}
+	return report, t.apply(ctx, report)
+}
+
+// rootPath returns the directory transferred from if source is true, else the one transferred to
+func (t *dirTransfer) rootPath(source bool) string {
+	if source == (t.direction == TransferUpload) {
+		return t.localDir
+	}
+	return t.irodsDir
+}
+
+// rootNotDir returns the error for a root that exists but is not a directory
+func (t *dirTransfer) rootNotDir(rootPath string) error {
+	if rootPath == t.rootPath(true) {
+		return newError(string(t.direction), rootPath, ErrInvalid, errors.New("not a directory"))
+	}
+	return newError(string(t.direction), rootPath, ErrExists, errors.New("not a directory"))
+}
+
+// matchesAnyGlob returns true if a pattern matches the relative path or its base name
+func matchesAnyGlob(patterns []string, relPath string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, relPath); matched {
+			return true
+		}
+		if matched, _ := path.Match(pattern, path.Base(relPath)); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// selected returns true if an entry at relPath takes part in the transfer
+func (t *dirTransfer) selected(relPath string, isDir bool) bool {
+	if matchesAnyGlob(t.options.Exclude, relPath) {
+		return false
+	}
+	return isDir || len(t.options.Include) == 0 || matchesAnyGlob(t.options.Include, relPath)
+}
+
+// markUnselected records that the directories above relPath hold an entry left out of the transfer
+func markUnselected(entries map[string]*dirTransferEntry, relPath string) {
+	for dir := path.Dir(relPath); dir != "."; dir = path.Dir(dir) {
+		entry := entries[dir]
+		if entry == nil || entry.unselected {
+			// Directories above were marked with it
+			return
+		}
+		entry.unselected = true
+	}
+}
+
+// walkLocal returns the selected entries below localDir by relative path, and whether localDir exists
+func (t *dirTransfer) walkLocal() (map[string]*dirTransferEntry, bool, error) {
+	entries := map[string]*dirTransferEntry{}
+
+	root, err := os.Stat(t.localDir)
+	switch {
+	case os.IsNotExist(err):
+		return entries, false, nil
+	case err != nil:
+		return nil, false, errors.Wrapf(err, "failed to stat local dir %q", t.localDir)
+	case !root.IsDir():
+		return nil, true, t.rootNotDir(t.localDir)
+	}
+
+	err = filepath.WalkDir(t.localDir, func(localPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if localPath == t.localDir {
+			return nil
+		}
+
+		rel, err := filepath.Rel(t.localDir, localPath)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		// Symlinks to files are followed; symlinks to directories, broken ones and special files are left out
+		info, err := os.Stat(localPath)
+		if err != nil || !(info.IsDir() || info.Mode().IsRegular()) || info.IsDir() != d.IsDir() {
+			return nil
+		}
+
+		if !t.selected(rel, info.IsDir()) {
+			markUnselected(entries, rel)
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		entries[rel] = &dirTransferEntry{
+			isDir:   info.IsDir(),
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		}
+		if info.IsDir() {
+			entries[rel].size = 0
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, true, errors.Wrapf(err, "failed to walk local dir %q", t.localDir)
+	}
+	return entries, true, nil
+}
+
+// walkIRODS returns the selected entries below irodsDir by relative path, and whether irodsDir exists
+func (t *dirTransfer) walkIRODS(ctx context.Context) (map[string]*dirTransferEntry, bool, error) {
+	entries := map[string]*dirTransferEntry{}
+
+	root, err := t.client.StatCtx(ctx, t.irodsDir)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return entries, false, nil
+	case err != nil:
+		return nil, false, err
+	case !root.IsDir():
+		return nil, true, t.rootNotDir(t.irodsDir)
+	}
+
+	var walk func(dir string, relDir string) error
+	walk = func(dir string, relDir string) error {
+		children, err := t.client.ListCtx(ctx, dir)
+		if err != nil {
+			return err
+		}
+
+		for _, child := range children {
+			rel := path.Join(relDir, child.Name)
+			if !t.selected(rel, child.IsDir()) {
+				markUnselected(entries, rel)
+				continue
+			}
+
+			entries[rel] = &dirTransferEntry{
+				isDir:   child.IsDir(),
+				size:    child.Size,
+				modTime: child.ModifyTime,
+				irods:   child,
+			}
+			if child.IsDir() {
+				if err := walk(child.Path, rel); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	if err := walk(t.irodsDir, ""); err != nil {
+		return nil, true, err
+	}
+	return entries, true, nil
+}
+
+// plan lists the actions that make dest the same as src
+func (t *dirTransfer) plan(src map[string]*dirTransferEntry, dest map[string]*dirTransferEntry, destFound bool) (*DirTransferReport, error) {
+	report := &DirTransferReport{}
+
+	if !destFound {
+		report.Items = append(report.Items, t.item(DirTransferMkdir, "", &dirTransferEntry{isDir: true}))
+	}
+
+	// Parents sort before their children
+	srcPaths := sortedRelPaths(src)
+	files := []DirTransferItem{}
+	for _, rel := range srcPaths {
+		srcEntry := src[rel]
+		destEntry := dest[rel]
+
+		if destEntry != nil && destEntry.isDir != srcEntry.isDir {
+			return report, newError(string(t.direction), t.destPath(rel), ErrExists, errors.New("destination is a different type of entry"))
+		}
+
+		if srcEntry.isDir {
+			if destEntry == nil {
+				report.Items = append(report.Items, t.item(DirTransferMkdir, rel, srcEntry))
+			}
+			continue
+		}
+
+		same, err := t.same(rel, srcEntry, destEntry)
+		if err != nil {
+			return report, err
+		}
+		if same {
+			files = append(files, t.item(DirTransferSkip, rel, srcEntry))
+			report.Skipped++
+			continue
+		}
+
+		files = append(files, t.item(DirTransferCopy, rel, srcEntry))
+		report.Transferred++
+		report.Bytes += srcEntry.size
+	}
+	report.Items = append(report.Items, files...)
+
+	if t.options.DeleteExtraneous {
+		deletedDir := ""
+		for _, rel := range sortedRelPaths(dest) {
+			if src[rel] != nil {
+				continue
+			}
+			// Entries below a deleted directory go with it
+			if deletedDir != "" && strings.HasPrefix(rel, deletedDir+"/") {
+				continue
+			}
+			if dest[rel].isDir {
+				if dest[rel].unselected {
+					// Deleting it would delete the entries left out, its selected entries are deleted one by one
+					continue
+				}
+				deletedDir = rel
+			}
+
+			report.Items = append(report.Items, t.item(DirTransferDelete, rel, dest[rel]))
+			report.Deleted++
+		}
+	}
+	return report, nil
+}
+
+func sortedRelPaths(entries map[string]*dirTransferEntry) []string {
+	paths := make([]string, 0, len(entries))
+	for rel := range entries {
+		paths = append(paths, rel)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func (t *dirTransfer) destPath(rel string) string {
+	if t.direction == TransferUpload {
+		return path.Join(t.irodsDir, rel)
+	}
+	return filepath.Join(t.localDir, filepath.FromSlash(rel))
+}
+
+func (t *dirTransfer) item(action DirTransferAction, rel string, entry *dirTransferEntry) DirTransferItem {
+	return DirTransferItem{
+		Action:    action,
+		IRODSPath: path.Join(t.irodsDir, rel),
+		LocalPath: filepath.Join(t.localDir, filepath.FromSlash(rel)),
+		IsDir:     entry.isDir,
+		Size:      entry.size,
+		ModTime:   entry.modTime,
+	}
+}
+
+// same returns true if the destination of a file can be left as it is
+func (t *dirTransfer) same(rel string, srcEntry *dirTransferEntry, destEntry *dirTransferEntry) (bool, error) {
+	if destEntry == nil || destEntry.size != srcEntry.size {
+		return false, nil
+	}
+
+	switch t.options.Skip {
+	case SkipSizeAndTime:
+		// iRODS keeps modification times in seconds
+		return !destEntry.modTime.Truncate(time.Second).Before(srcEntry.modTime.Truncate(time.Second)), nil
+	case SkipChecksum:
+		irodsEntry := srcEntry.irods
+		if irodsEntry == nil {
+			irodsEntry = destEntry.irods
+		}
+		return matchesIRODSChecksum(filepath.Join(t.localDir, filepath.FromSlash(rel)), irodsEntry)
+	default:
+		return false, nil
+	}
+}
+
+// newChecksumHash returns the hash of an iRODS checksum algorithm, or nil if it is unknown
+func newChecksumHash(algorithm string) hash.Hash {
+	switch strings.ToUpper(strings.ReplaceAll(algorithm, "-", "")) {
+	case "MD5":
+		return md5.New()
+	case "SHA1":
+		return sha1.New()
+	case "SHA256":
+		return sha256.New()
+	case "SHA512":
+		return sha512.New()
+	case "ADLER32":
+		return adler32.New()
+	default:
+		return nil
+	}
+}
+
+// matchesIRODSChecksum returns true if the local file has the checksum iRODS recorded for entry.
+// Data objects without a checksum, or with one of an unknown algorithm, do not match.
+func matchesIRODSChecksum(localPath string, entry *irodsclient_fs.Entry) (bool, error) {
+	if len(entry.CheckSum) == 0 {
+		return false, nil
+	}
+
+	h := newChecksumHash(string(entry.CheckSumAlgorithm))
+	if h == nil {
+		return false, nil
+	}
+
+	f, err := os.Open(localPath)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to open local file %q", localPath)
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(h, f); err != nil {
+		return false, errors.Wrapf(err, "failed to read local file %q", localPath)
+	}
+	return bytes.Equal(h.Sum(nil), entry.CheckSum), nil
+}
+
+// apply carries out the actions of report
+func (t *dirTransfer) apply(ctx context.Context, report *DirTransferReport) error {
+	files := []DirTransferItem{}
+	for _, item := range report.Items {
+		switch item.Action {
+		case DirTransferMkdir:
+			if err := t.mkdir(ctx, item); err != nil {
+				return err
+			}
+		case DirTransferCopy:
+			files = append(files, item)
+		}
+	}
+
+	if err := t.transferFiles(ctx, files, report.Bytes); err != nil {
+		return err
+	}
+
+	for _, item := range report.Items {
+		if item.Action == DirTransferDelete {
+			if err := t.delete(ctx, item); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (t *dirTransfer) mkdir(ctx context.Context, item DirTransferItem) error {
+	if t.direction == TransferUpload {
+		return t.client.MakeDirCtx(ctx, item.IRODSPath, true)
+	}
+
+	if err := os.MkdirAll(item.LocalPath, 0755); err != nil {
+		return errors.Wrapf(err, "failed to create local dir %q", item.LocalPath)
+	}
+	return nil
+}
+
+func (t *dirTransfer) delete(ctx context.Context, item DirTransferItem) error {
+	if t.direction == TransferDownload {
+		if err := os.RemoveAll(item.LocalPath); err != nil {
+			return errors.Wrapf(err, "failed to delete local path %q", item.LocalPath)
+		}
+		return nil
+	}
+
+	if item.IsDir {
+		return t.client.RemoveDirCtx(ctx, item.IRODSPath, true, false)
+	}
+	return t.client.RemoveFileCtx(ctx, item.IRODSPath, false)
+}
+
+// transferFiles transfers files with up to Parallel transfers at once, reporting progress over total.
+// The first failure cancels the transfers still running.
+func (t *dirTransfer) transferFiles(ctx context.Context, files []DirTransferItem, total int64) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	parallel := t.options.Parallel
+	if parallel <= 0 {
+		parallel = DefaultDirTransferParallel
+	}
+
+	var mu sync.Mutex
+	processed := map[int]int64{}
+	sum := int64(0)
+	var firstErr error
+
+	progress := func(index int, done int64) {
+		mu.Lock()
+		sum += done - processed[index]
+		processed[index] = done
+		current := sum
+		mu.Unlock()
+
+		if t.options.TransferCallback != nil {
+			t.options.TransferCallback(string(t.direction), current, total)
+		}
+	}
+
+	tasks := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < min(parallel, len(files)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for index := range tasks {
+				err := t.transferFile(ctx, files[index], func(done int64) {
+					progress(index, done)
+				})
+				if err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+					cancel()
+					continue
+				}
+				progress(index, files[index].Size)
+			}
+		}()
+	}
+
+	for index := range files {
+		if ctx.Err() != nil {
+			break
+		}
+		tasks <- index
+	}
+	close(tasks)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+func (t *dirTransfer) transferFile(ctx context.Context, item DirTransferItem, progress func(done int64)) error {
+	options := t.options.FileOptions
+	options.BlockReadyCallback = nil
+	options.TransferCallback = func(_ string, processed int64, _ int64) {
+		progress(processed)
+	}
+
+	if t.direction == TransferUpload {
+		return t.client.UploadFileWithOptionsCtx(ctx, item.LocalPath, item.IRODSPath, &options)
+	}
+
+	if err := t.client.DownloadFileWithOptionsCtx(ctx, item.IRODSPath, item.LocalPath, &options); err != nil {
+		return err
+	}
+
+	// Downloads take the modification time of the data object, so later size+mtime syncs skip them
+	if !item.ModTime.IsZero() {
+		if err := os.Chtimes(item.LocalPath, item.ModTime, item.ModTime); err != nil {
+			return errors.Wrapf(err, "failed to set modification time of %q", item.LocalPath)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,324 @@
+package irods
+
+import (
+	"context"
+	"crypto/sha256"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	irodsclient_fs "github.com/cyverse/go-irodsclient/fs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memDirClient is a memCopyClient that transfers files from and to local paths
+type memDirClient struct {
+	*memCopyClient
+
+	mu        sync.Mutex
+	mtimes    map[string]time.Time
+	checksums map[string][]byte // SHA-256 checksums registered in iRODS
+}
+
+func newMemDirClient(dirs []string, files map[string]string) *memDirClient {
+	client := &memDirClient{
+		memCopyClient: &memCopyClient{dirs: map[string]bool{}, files: files},
+		mtimes:        map[string]time.Time{},
+		checksums:     map[string][]byte{},
+	}
+	for _, dir := range dirs {
+		client.dirs[dir] = true
+	}
+	return client
+}
+
+func (m *memDirClient) withTimes(entry *irodsclient_fs.Entry) *irodsclient_fs.Entry {
+	entry.ModifyTime = m.mtimes[entry.Path]
+	if checksum, ok := m.checksums[entry.Path]; ok {
+		entry.CheckSumAlgorithm = "SHA-256"
+		entry.CheckSum = checksum
+	}
+	return entry
+}
+
+func (m *memDirClient) StatCtx(ctx context.Context, p string) (*irodsclient_fs.Entry, error) {
+	entry, err := m.memCopyClient.StatCtx(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	return m.withTimes(entry), nil
+}
+
+func (m *memDirClient) ListCtx(ctx context.Context, p string) ([]*irodsclient_fs.Entry, error) {
+	entries, err := m.memCopyClient.ListCtx(ctx, p)
+	for _, entry := range entries {
+		m.withTimes(entry)
+	}
+	return entries, err
+}
+
+func (m *memDirClient) MakeDirCtx(ctx context.Context, p string, recurse bool) error {
+	for dir := p; dir != "/" && !m.dirs[dir]; dir = path.Dir(dir) {
+		m.dirs[dir] = true
+	}
+	return nil
+}
+
+func (m *memDirClient) RemoveFileCtx(ctx context.Context, p string, force bool) error {
+	delete(m.files, p)
+	return nil
+}
+
+func (m *memDirClient) RemoveDirCtx(ctx context.Context, p string, recurse bool, force bool) error {
+	for name := range m.fileNames() {
+		if strings.HasPrefix(name, p+"/") {
+			delete(m.files, name)
+		}
+	}
+	for dir := range m.dirs {
+		if dir == p || strings.HasPrefix(dir, p+"/") {
+			delete(m.dirs, dir)
+		}
+	}
+	return nil
+}
+
+func (m *memDirClient) DownloadFileWithOptionsCtx(ctx context.Context, irodsPath string, localPath string, options *TransferOptions) error {
+	m.mu.Lock()
+	content := m.files[irodsPath]
+	m.mu.Unlock()
+
+	if err := os.WriteFile(localPath, []byte(content), 0644); err != nil {
+		return err
+	}
+	options.TransferCallback(string(TransferDownload), int64(len(content)), int64(len(content)))
+	return nil
+}
+
+func (m *memDirClient) UploadFileWithOptionsCtx(ctx context.Context, localPath string, irodsPath string, options *TransferOptions) error {
+	data, err := os.ReadFile(localPath)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.files[irodsPath] = string(data)
+	m.mtimes[irodsPath] = time.Now()
+	m.mu.Unlock()
+
+	options.TransferCallback(string(TransferUpload), int64(len(data)), int64(len(data)))
+	return nil
+}
+
+func writeLocalFiles(t *testing.T, root string, files map[string]string) {
+	for name, content := range files {
+		localPath := filepath.Join(root, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(localPath), 0755))
+		require.NoError(t, os.WriteFile(localPath, []byte(content), 0644))
+	}
+}
+
+func TestUploadDir(t *testing.T) {
+	localDir := t.TempDir()
+	writeLocalFiles(t, localDir, map[string]string{
+		"a.txt":       "12345",
+		"sub/b.csv":   "123",
+		"skip/c.txt":  "1",
+		"debug.log":   "12",
+		"sub/deep/d":  "1234",
+		"sub/keep.db": "",
+	})
+
+	client := newMemDirClient([]string{"/zone", "/zone/dest", "/zone/dest/old"}, map[string]string{
+		"/zone/dest/a.txt":   "stale",
+		"/zone/dest/extra":   "x",
+		"/zone/dest/old/x":   "x",
+		"/zone/dest/app.log": "kept",
+	})
+
+	var progress []int64
+	var total int64
+	var progressMu sync.Mutex
+	options := &DirTransferOptions{
+		Parallel:         2,
+		Exclude:          []string{"*.log", "skip", "keep.db"},
+		DeleteExtraneous: true,
+		TransferCallback: func(taskName string, processed int64, size int64) {
+			progressMu.Lock()
+			progress = append(progress, processed)
+			total = size
+			progressMu.Unlock()
+		},
+	}
+
+	// A dry run plans without changing anything
+	options.DryRun = true
+	report, err := uploadDir(context.Background(), client, localDir, "/zone/dest", options)
+	require.NoError(t, err)
+	assert.Equal(t, 3, report.Transferred)
+	assert.Equal(t, 2, report.Deleted)
+	assert.Equal(t, int64(12), report.Bytes)
+	assert.Equal(t, "stale", client.files["/zone/dest/a.txt"])
+	assert.Empty(t, progress)
+
+	actions := []string{}
+	for _, item := range report.Items {
+		actions = append(actions, string(item.Action)+" "+item.IRODSPath)
+	}
+	assert.Equal(t, []string{
+		"mkdir /zone/dest/sub",
+		"mkdir /zone/dest/sub/deep",
+		"transfer /zone/dest/a.txt",
+		"transfer /zone/dest/sub/b.csv",
+		"transfer /zone/dest/sub/deep/d",
+		"delete /zone/dest/extra",
+		"delete /zone/dest/old",
+	}, actions)
+
+	options.DryRun = false
+	report, err = uploadDir(context.Background(), client, localDir, "/zone/dest", options)
+	require.NoError(t, err)
+	assert.Equal(t, 3, report.Transferred)
+
+	assert.Equal(t, map[string]string{
+		"/zone/dest/a.txt":      "12345",
+		"/zone/dest/sub/b.csv":  "123",
+		"/zone/dest/sub/deep/d": "1234",
+		"/zone/dest/app.log":    "kept",
+	}, client.files)
+	assert.False(t, client.dirs["/zone/dest/old"])
+	assert.True(t, client.dirs["/zone/dest/sub/deep"])
+
+	require.NotEmpty(t, progress)
+	assert.Equal(t, int64(12), total)
+	assert.Equal(t, int64(12), progress[len(progress)-1])
+
+	// Uploaded files are newer than the local ones
+	options.Skip = SkipSizeAndTime
+	report, err = uploadDir(context.Background(), client, localDir, "/zone/dest", options)
+	require.NoError(t, err)
+	assert.Equal(t, 0, report.Transferred)
+	assert.Equal(t, 3, report.Skipped)
+}
+
+func TestDownloadDir(t *testing.T) {
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	client := newMemDirClient([]string{"/zone", "/zone/src", "/zone/src/sub"}, map[string]string{
+		"/zone/src/a.csv":     "12345",
+		"/zone/src/sub/b.csv": "123",
+		"/zone/src/sub/c.txt": "1",
+	})
+	for name := range client.files {
+		client.mtimes[name] = modTime
+	}
+
+	localDir := filepath.Join(t.TempDir(), "dest")
+	options := &DirTransferOptions{Include: []string{"*.csv"}}
+
+	report, err := downloadDir(context.Background(), client, "/zone/src", localDir, options)
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Transferred)
+
+	data, err := os.ReadFile(filepath.Join(localDir, "sub", "b.csv"))
+	require.NoError(t, err)
+	assert.Equal(t, "123", string(data))
+	assert.NoFileExists(t, filepath.Join(localDir, "sub", "c.txt"))
+
+	info, err := os.Stat(filepath.Join(localDir, "a.csv"))
+	require.NoError(t, err)
+	assert.True(t, info.ModTime().Equal(modTime))
+
+	// Downloaded files keep the modification time of their data objects
+	options.Skip = SkipSizeAndTime
+	report, err = downloadDir(context.Background(), client, "/zone/src", localDir, options)
+	require.NoError(t, err)
+	assert.Equal(t, 0, report.Transferred)
+	assert.Equal(t, 2, report.Skipped)
+
+	// Checksums are compared where iRODS has one
+	checksum := sha256.Sum256([]byte("12345"))
+	client.checksums["/zone/src/a.csv"] = checksum[:]
+	options.Skip = SkipChecksum
+	report, err = downloadDir(context.Background(), client, "/zone/src", localDir, options)
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Skipped)
+	assert.Equal(t, 1, report.Transferred)
+	assert.Equal(t, int64(3), report.Bytes)
+}
+
+func TestDirTransferDeleteKeepsExcluded(t *testing.T) {
+	localDir := t.TempDir()
+	writeLocalFiles(t, localDir, map[string]string{
+		"a.txt": "1",
+	})
+
+	client := newMemDirClient([]string{"/zone", "/zone/dest", "/zone/dest/old", "/zone/dest/old/inner"}, map[string]string{
+		"/zone/dest/old/x":       "x",
+		"/zone/dest/old/app.log": "kept",
+		"/zone/dest/old/inner/y": "y",
+	})
+
+	// The directory holding an excluded file is kept, its other entries are deleted
+	report, err := uploadDir(context.Background(), client, localDir, "/zone/dest", &DirTransferOptions{
+		Exclude:          []string{"*.log"},
+		DeleteExtraneous: true,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Deleted)
+
+	assert.Equal(t, map[string]string{
+		"/zone/dest/a.txt":       "1",
+		"/zone/dest/old/app.log": "kept",
+	}, client.files)
+	assert.True(t, client.dirs["/zone/dest/old"])
+	assert.False(t, client.dirs["/zone/dest/old/inner"])
+
+	// Files not included are kept the same way
+	client = newMemDirClient([]string{"/zone", "/zone/src"}, map[string]string{
+		"/zone/src/a.csv": "1",
+	})
+	writeLocalFiles(t, localDir, map[string]string{
+		"extra/b.csv":    "2",
+		"extra/note.txt": "kept",
+		"gone/c.csv":     "3",
+	})
+
+	report, err = downloadDir(context.Background(), client, "/zone/src", localDir, &DirTransferOptions{
+		Include:          []string{"*.csv"},
+		DeleteExtraneous: true,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Deleted)
+
+	assert.FileExists(t, filepath.Join(localDir, "extra", "note.txt"))
+	assert.NoFileExists(t, filepath.Join(localDir, "extra", "b.csv"))
+	assert.NoDirExists(t, filepath.Join(localDir, "gone"))
+	assert.FileExists(t, filepath.Join(localDir, "a.txt"))
+}
+
+func TestDirTransferErrors(t *testing.T) {
+	client := newMemDirClient([]string{"/zone", "/zone/dest"}, map[string]string{
+		"/zone/file":     "x",
+		"/zone/dest/sub": "file where a directory is uploaded",
+	})
+
+	localDir := t.TempDir()
+	writeLocalFiles(t, localDir, map[string]string{"sub/a": "1"})
+
+	_, err := uploadDir(context.Background(), client, filepath.Join(localDir, "missing"), "/zone/dest", nil)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	_, err = downloadDir(context.Background(), client, "/zone/file", localDir, nil)
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	_, err = uploadDir(context.Background(), client, localDir, "/zone/file", nil)
+	assert.ErrorIs(t, err, ErrExists)
+
+	_, err = uploadDir(context.Background(), client, localDir, "/zone/dest", nil)
+	assert.ErrorIs(t, err, ErrExists)
+}
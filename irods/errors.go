@@ -0,0 +1,242 @@
+package irods
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/cockroachdb/errors"
+	irodsclient_types "github.com/cyverse/go-irodsclient/irods/types"
+	"github.com/cyverse/irodsfs-common/irods/stagingfs"
+)
+
+// Error kinds returned by IRODSFSClient and IRODSFSFileHandle implementations, matched with errors.Is
+var (
+	ErrNotFound         = errors.New("not found")
+	ErrExists           = errors.New("already exists")
+	ErrNotEmpty         = errors.New("directory is not empty")
+	ErrPermissionDenied = errors.New("access denied")
+	ErrQuotaExceeded    = errors.New("quota exceeded")
+	ErrStaging          = errors.New("local staging failed")
+	ErrConflict         = errors.New("conflicts with a pending change")
+	ErrTransient        = errors.New("temporary failure, may succeed on retry")
+	ErrInvalid          = errors.New("invalid argument")
+	ErrNoAttribute      = errors.New("no such extended attribute")
+	ErrReadOnly         = errors.New("read-only file system")
+)
+
+// Error is an error of an operation on a path, classified by Kind.
+// errors.Is matches Kind, the io/fs error of the same meaning, if any, and the underlying error.
+type Error struct {
+	Op   string // Operation, e.g. "stat" or "rename"
+	Path string // iRODS path the operation failed on
+	Kind error  // One of the Err* kinds
+	Err  error  // Underlying error, nil if the kind says it all
+}
+
+func (e *Error) Error() string {
+	cause := e.Kind
+	if e.Err != nil {
+		cause = e.Err
+	}
+
+	if e.Op == "" {
+		return cause.Error()
+	}
+	if e.Path == "" {
+		return e.Op + ": " + cause.Error()
+	}
+	return e.Op + " " + e.Path + ": " + cause.Error()
+}
+
+// Is reports whether target is the kind of e or its io/fs counterpart
+func (e *Error) Is(target error) bool {
+	if target == e.Kind {
+		return true
+	}
+
+	switch e.Kind {
+	case ErrNotFound:
+		return target == fs.ErrNotExist
+	case ErrExists:
+		return target == fs.ErrExist
+	case ErrPermissionDenied:
+		return target == fs.ErrPermission
+	default:
+		return false
+	}
+}
+
+// Unwrap returns the underlying error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// newError returns an error of the given kind for op on path
+func newError(op string, path string, kind error, err error) error {
+	return &Error{
+		Op:   op,
+		Path: path,
+		Kind: kind,
+		Err:  err,
+	}
+}
+
+// wrapError classifies err and returns it as an *Error of op on path.
+// Errors that already have a kind, context errors and errors of unknown kind are returned as is.
+func wrapError(op string, path string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var kindErr *Error
+	if errors.As(err, &kindErr) {
+		return err
+	}
+
+	kind := errorKind(err)
+	if kind == nil {
+		return err
+	}
+	return newError(op, path, kind, err)
+}
+
+// wrapStagingError is wrapError for errors of local staging, which are ErrStaging unless classified otherwise
+func wrapStagingError(op string, path string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var kindErr *Error
+	if errors.As(err, &kindErr) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+
+	kind := errorKind(err)
+	if kind == nil {
+		kind = ErrStaging
+	}
+	return newError(op, path, kind, err)
+}
+
+// irodsErrorKinds maps names of iRODS error codes, as they appear in go-irodsclient error messages, to kinds
+var irodsErrorKinds = []struct {
+	name string
+	kind error
+}{
+	{"CAT_NO_ROWS_FOUND", ErrNotFound},
+	{"CAT_UNKNOWN_COLLECTION", ErrNotFound},
+	{"CAT_UNKNOWN_FILE", ErrNotFound},
+	{"USER_FILE_DOES_NOT_EXIST", ErrNotFound},
+	{"CATALOG_ALREADY_HAS_ITEM_BY_THAT_NAME", ErrExists},
+	{"CAT_NAME_EXISTS_AS_COLLECTION", ErrExists},
+	{"CAT_NAME_EXISTS_AS_DATAOBJ", ErrExists},
+	{"OVERWRITE_WITHOUT_FORCE_FLAG", ErrExists},
+	{"CAT_COLLECTION_NOT_EMPTY", ErrNotEmpty},
+	{"CAT_NO_ACCESS_PERMISSION", ErrPermissionDenied},
+	{"SYS_NO_API_PRIV", ErrPermissionDenied},
+	{"CAT_INSUFFICIENT_PRIVILEGE_LEVEL", ErrPermissionDenied},
+	{"SYS_RESC_QUOTA_EXCEEDED", ErrQuotaExceeded},
+	{"SYS_NOT_ENOUGH_SPACE", ErrQuotaExceeded},
+	{"LOCKED_DATA_OBJECT_ACCESS", ErrConflict},
+	{"SYS_HEADER_READ_LEN_ERR", ErrTransient},
+	{"SYS_SOCK_READ_ERR", ErrTransient},
+	{"SYS_SOCK_READ_TIMEDOUT", ErrTransient},
+	{"SYS_SOCK_CONNECT_ERR", ErrTransient},
+}
+
+// errorKind returns the kind of err, or nil if unknown
+func errorKind(err error) error {
+	var kindErr *Error
+	if errors.As(err, &kindErr) {
+		return kindErr.Kind
+	}
+
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		// Not a failure of the operation, see ErrnoFor
+		return nil
+	}
+
+	switch {
+	case irodsclient_types.IsFileNotFoundError(err), errors.Is(err, os.ErrNotExist):
+		return ErrNotFound
+	case errors.Is(err, os.ErrExist):
+		return ErrExists
+	case errors.Is(err, os.ErrPermission):
+		return ErrPermissionDenied
+	case errors.Is(err, syscall.ENOTEMPTY):
+		return ErrNotEmpty
+	case errors.Is(err, syscall.EROFS):
+		return ErrReadOnly
+	case errors.Is(err, stagingfs.ErrQuotaExceeded), errors.Is(err, syscall.ENOSPC), errors.Is(err, syscall.EDQUOT):
+		return ErrQuotaExceeded
+	case errors.Is(err, stagingfs.ErrInvalidTransition):
+		return ErrConflict
+	case errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.EPIPE):
+		return ErrTransient
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return ErrTransient
+	}
+
+	msg := err.Error()
+	for _, irodsErr := range irodsErrorKinds {
+		if strings.Contains(msg, irodsErr.name) {
+			return irodsErr.kind
+		}
+	}
+	return nil
+}
+
+// ErrnoFor returns the errno a filesystem frontend should report for err: 0 for nil, EIO if the kind is unknown
+func ErrnoFor(err error) syscall.Errno {
+	if err == nil {
+		return 0
+	}
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		return syscall.EINTR
+	case errors.Is(err, context.DeadlineExceeded):
+		return syscall.ETIMEDOUT
+	case errors.Is(err, ErrNotReadMode), errors.Is(err, ErrNotWriteMode):
+		return syscall.EBADF
+	}
+
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		return errno
+	}
+
+	switch errorKind(err) {
+	case ErrNotFound:
+		return syscall.ENOENT
+	case ErrExists:
+		return syscall.EEXIST
+	case ErrNotEmpty:
+		return syscall.ENOTEMPTY
+	case ErrPermissionDenied:
+		return syscall.EACCES
+	case ErrQuotaExceeded:
+		return syscall.EDQUOT
+	case ErrConflict:
+		return syscall.EBUSY
+	case ErrTransient:
+		return syscall.EAGAIN
+	case ErrInvalid:
+		return syscall.EINVAL
+	case ErrNoAttribute:
+		return syscall.ENODATA
+	case ErrReadOnly:
+		return syscall.EROFS
+	default:
+		// ErrStaging and unknown errors
+		return syscall.EIO
+	}
+}
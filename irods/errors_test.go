@@ -0,0 +1,157 @@
+package irods
+
+import (
+	"context"
+	stderrors "errors"
+	"io"
+	"io/fs"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	irodsclient_types "github.com/cyverse/go-irodsclient/irods/types"
+	"github.com/cyverse/irodsfs-common/irods/stagingfs"
+	"github.com/cyverse/irodsfs-common/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWrapErrorKinds(t *testing.T) {
+	tests := []struct {
+		name  string
+		err   error
+		kind  error
+		errno syscall.Errno
+	}{
+		{"irods not found", irodsclient_types.NewFileNotFoundError("/zone/a"), ErrNotFound, syscall.ENOENT},
+		{"local not found", &os.PathError{Op: "open", Path: "/tmp/a", Err: syscall.ENOENT}, ErrNotFound, syscall.ENOENT},
+		{"exists", errors.New("iRODS error -809000 (CATALOG_ALREADY_HAS_ITEM_BY_THAT_NAME)"), ErrExists, syscall.EEXIST},
+		{"not empty", errors.New("iRODS error -821000 (CAT_COLLECTION_NOT_EMPTY)"), ErrNotEmpty, syscall.ENOTEMPTY},
+		{"permission denied", errors.Wrap(errors.New("iRODS error -818000 (CAT_NO_ACCESS_PERMISSION)"), "failed to open"), ErrPermissionDenied, syscall.EACCES},
+		{"quota", errors.New("iRODS error -110000 (SYS_RESC_QUOTA_EXCEEDED)"), ErrQuotaExceeded, syscall.EDQUOT},
+		{"staging quota", errors.Wrap(stagingfs.ErrQuotaExceeded, "current 10"), ErrQuotaExceeded, syscall.EDQUOT},
+		{"conflict", errors.Wrap(stagingfs.ErrInvalidTransition, "cannot delete /zone/a"), ErrConflict, syscall.EBUSY},
+		{"transient", errors.Wrap(io.ErrUnexpectedEOF, "failed to read header"), ErrTransient, syscall.EAGAIN},
+		{"read-only", &os.PathError{Op: "open", Path: "/tmp/a", Err: syscall.EROFS}, ErrReadOnly, syscall.EROFS},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := wrapError("stat", "/zone/a", test.err)
+
+			assert.ErrorIs(t, err, test.kind)
+			assert.True(t, stderrors.Is(err, test.kind), "standard errors.Is must match the kind")
+			assert.ErrorIs(t, err, test.err, "the underlying error must stay visible")
+			if test.kind == ErrExists {
+				assert.ErrorIs(t, err, fs.ErrExist, "kinds with an io/fs counterpart must match it")
+			}
+			assert.Equal(t, test.errno, ErrnoFor(err))
+			assert.Contains(t, err.Error(), "stat /zone/a")
+		})
+	}
+}
+
+func TestWrapErrorUnknownKind(t *testing.T) {
+	raw := errors.New("something odd")
+	assert.Equal(t, raw, wrapError("stat", "/zone/a", raw))
+	assert.Equal(t, syscall.EIO, ErrnoFor(raw))
+
+	// Local staging failures are ErrStaging unless they say otherwise
+	err := wrapStagingError("write", "/zone/a", raw)
+	assert.ErrorIs(t, err, ErrStaging)
+	assert.Equal(t, syscall.EIO, ErrnoFor(err))
+
+	err = wrapStagingError("write", "/zone/a", &os.PathError{Op: "write", Path: "/tmp/a", Err: syscall.ENOSPC})
+	assert.ErrorIs(t, err, ErrQuotaExceeded)
+	assert.Equal(t, syscall.ENOSPC, ErrnoFor(err))
+
+	// Errors that already have a kind keep it
+	err = wrapStagingError("rename", "/zone/a", errors.Wrap(newError("rename", "/zone/a", ErrPermissionDenied, nil), "handler failed"))
+	assert.ErrorIs(t, err, ErrPermissionDenied)
+	assert.NotErrorIs(t, err, ErrStaging)
+}
+
+func TestErrnoForSpecialErrors(t *testing.T) {
+	assert.Equal(t, syscall.Errno(0), ErrnoFor(nil))
+	assert.Equal(t, syscall.EINTR, ErrnoFor(errors.Wrap(context.Canceled, "failed to list")))
+	assert.Equal(t, syscall.ETIMEDOUT, ErrnoFor(wrapError("list", "/zone", context.DeadlineExceeded)))
+	assert.Equal(t, syscall.EBADF, ErrnoFor(ErrNotReadMode))
+	assert.Equal(t, syscall.EBADF, ErrnoFor(ErrNotWriteMode))
+	assert.ErrorIs(t, ErrFileStaging, ErrStaging)
+	assert.Equal(t, syscall.EIO, ErrnoFor(ErrFileStaging))
+}
+
+func newTestBufferedClient(t *testing.T, config *IRODSFSClientBufferedConfig, mockClient stagingfs.StagingClient) *IRODSFSClientBuffered {
+	return &IRODSFSClientBuffered{
+		cache:  newTestCacheManager(t),
+		helper: util.NewFileBlockHelper(1024 * 1024),
+		router: newTestStagingRouter(t, config, mockClient),
+		acls:   newACLCache(config.ACLCacheTTL),
+		config: config,
+		logger: newTestLogger(),
+	}
+}
+
+func TestBufferedClientErrorKinds(t *testing.T) {
+	mockClient := &mockStagingClient{}
+	client := newTestBufferedClient(t, &IRODSFSClientBufferedConfig{
+		StagingRootPath:    t.TempDir(),
+		GracePeriod:        time.Hour,
+		MaxStagingDataSize: 4,
+		StagingPolicies: []StagingPolicy{
+			{IRODSPathPrefix: "/zone/sync", RootPath: t.TempDir(), SyncMode: StagingSyncModeWriteThrough},
+		},
+	}, mockClient)
+
+	// Stat of an iRODS file deleted in staging
+	staging := client.router.getStaging("/zone/deleted")
+	require.NoError(t, staging.Delete("/zone/deleted"))
+
+	_, err := client.Stat("/zone/deleted")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Equal(t, syscall.ENOENT, ErrnoFor(err))
+
+	// Removing a staged directory as a file conflicts with the pending mkdir
+	require.NoError(t, client.MakeDir("/zone/dir", false))
+	err = client.RemoveFile("/zone/dir", false)
+	assert.ErrorIs(t, err, ErrConflict)
+	assert.Equal(t, syscall.EBUSY, ErrnoFor(err))
+
+	// A write-through sync refused by iRODS
+	mockClient.err = errors.New("iRODS error -818000 (CAT_NO_ACCESS_PERMISSION)")
+	err = client.MakeDir("/zone/sync/dir", false)
+	assert.ErrorIs(t, err, ErrPermissionDenied)
+	assert.Equal(t, syscall.EACCES, ErrnoFor(err))
+	mockClient.err = nil
+
+	// Staging quota
+	f, err := staging.OpenForWrite("/zone/big")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.NoError(t, client.TruncateFile("/zone/big", 10))
+
+	_, err = staging.OpenForWrite("/zone/more")
+	err = wrapStagingError("create", "/zone/more", err)
+	assert.ErrorIs(t, err, ErrQuotaExceeded)
+	assert.Equal(t, syscall.EDQUOT, ErrnoFor(err))
+}
+
+func TestHandleModeErrorKinds(t *testing.T) {
+	cacheMgr := newTestCacheManager(t)
+	defer cacheMgr.Release()
+
+	handle := &IRODSFSClientBufferedFileHandle{
+		client:    &IRODSFSClientBuffered{logger: newTestLogger()},
+		handle:    newMockFileHandle("/test/wo.dat", []byte("data"), irodsclient_types.FileOpenModeWriteOnly),
+		cache:     cacheMgr,
+		irodsPath: "/test/wo.dat",
+		helper:    util.NewFileBlockHelper(16),
+		logger:    newTestLogger(),
+	}
+
+	_, err := handle.ReadAt(make([]byte, 4), 0)
+	assert.ErrorIs(t, err, ErrNotReadMode)
+	assert.Equal(t, syscall.EBADF, ErrnoFor(err))
+}
@@ -0,0 +1,9 @@
+package irods
+
+// PendingAuditPaths returns the number of iRODS paths with records of staged changes that are not synced
+func PendingAuditPaths(c *IRODSFSClientAudit) int {
+	c.pendingMu.Lock()
+	defer c.pendingMu.Unlock()
+
+	return len(c.pending)
+}
@@ -0,0 +1,773 @@
+// Package fake provides Client, an in-process irods.IRODSFSClient backed by an in-memory tree, for testing
+// code built on the irods package without an iRODS server. Latency, failures and permission errors can be
+// injected per operation and path, and calls are counted in Metrics.
+//
+// Client also implements the stagingfs client interfaces, so it can back a StagingFS, and can be passed to
+// irods.WithContext, irods.NewIRODSFSClientBufferedWithClient and vpath.NewVPathManager like any other
+// IRODSFSClient.
+package fake
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	irodsclient_fs "github.com/cyverse/go-irodsclient/fs"
+	irodsclient_metrics "github.com/cyverse/go-irodsclient/irods/metrics"
+	irodsclient_types "github.com/cyverse/go-irodsclient/irods/types"
+	"github.com/cyverse/irodsfs-common/irods"
+	"github.com/cyverse/irodsfs-common/irods/stagingfs"
+	"github.com/rs/xid"
+)
+
+const (
+	DefaultUser            = "rods"
+	DefaultZone            = "tempZone"
+	DefaultResource        = "demoResc"
+	DefaultApplicationName = "irodsfs-fake"
+)
+
+// Config configures a Client
+type Config struct {
+	User            string // Client user, owner of new entries (default: DefaultUser)
+	Zone            string // Zone of the user (default: DefaultZone)
+	DefaultResource string // Resource of new data objects (default: DefaultResource)
+	ApplicationName string // Returned by GetApplicationName (default: DefaultApplicationName)
+	FaultSeed       int64  // Seed of the decisions of faults with a Probability
+
+	Transfers irods.TransferManagerConfig // Queueing and bandwidth limits of downloads and uploads
+}
+
+// node is a collection, data object or symlink in the tree
+type node struct {
+	id         int64
+	isDir      bool
+	data       []byte
+	target     string // Symlink target, empty for other entries
+	owner      string
+	createTime time.Time
+	modifyTime time.Time
+	xattrs     map[string][]byte
+	acl        []*irodsclient_types.IRODSAccess
+	inherit    bool
+	replicas   []*irodsclient_types.IRODSReplica // Replicas of data objects; they share data
+}
+
+// Client is an irods.IRODSFSClient that keeps its tree in memory. It is safe for concurrent use.
+type Client struct {
+	id        string
+	config    Config
+	account   *irodsclient_types.IRODSAccount
+	transfers *irods.TransferManager
+
+	mu          sync.Mutex
+	nodes       map[string]*node
+	nextID      int64
+	openHandles int
+
+	faults *irods.FaultInjector
+
+	metricsMu sync.Mutex
+	metrics   Metrics
+
+	irodsMetrics irodsclient_metrics.IRODSMetrics
+}
+
+var (
+	_ irods.IRODSFSClient              = (*Client)(nil)
+	_ stagingfs.ResumableStagingClient = (*Client)(nil)
+	_ stagingfs.VerifyingStagingClient = (*Client)(nil)
+	_ stagingfs.LinkStagingClient      = (*Client)(nil)
+)
+
+// New creates a Client with an empty tree holding the root collection, /<zone> and /<zone>/home/<user>
+func New(config *Config) *Client {
+	c := &Client{
+		id:    xid.New().String(),
+		nodes: map[string]*node{},
+	}
+	if config != nil {
+		c.config = *config
+	}
+	if c.config.User == "" {
+		c.config.User = DefaultUser
+	}
+	if c.config.Zone == "" {
+		c.config.Zone = DefaultZone
+	}
+	if c.config.DefaultResource == "" {
+		c.config.DefaultResource = DefaultResource
+	}
+	if c.config.ApplicationName == "" {
+		c.config.ApplicationName = DefaultApplicationName
+	}
+
+	c.account = &irodsclient_types.IRODSAccount{
+		AuthenticationScheme: irodsclient_types.AuthSchemeNative,
+		Host:                 "localhost",
+		Port:                 1247,
+		ClientUser:           c.config.User,
+		ClientZone:           c.config.Zone,
+		ProxyUser:            c.config.User,
+		ProxyZone:            c.config.Zone,
+		DefaultResource:      c.config.DefaultResource,
+	}
+	c.transfers = irods.NewTransferManager(c.config.Transfers)
+	c.faults = irods.NewFaultInjector(c.config.FaultSeed)
+	c.metrics.Calls = map[string]int64{}
+
+	c.nodes["/"] = c.newNode(true)
+	c.mkdirAll(c.HomeDir())
+	return c
+}
+
+// HomeDir returns the home collection of the user
+func (c *Client) HomeDir() string {
+	return path.Join("/", c.config.Zone, "home", c.config.User)
+}
+
+func (c *Client) newNode(isDir bool) *node {
+	c.nextID++
+	now := time.Now()
+	return &node{
+		id:         c.nextID,
+		isDir:      isDir,
+		owner:      c.config.User,
+		createTime: now,
+		modifyTime: now,
+		xattrs:     map[string][]byte{},
+		acl: []*irodsclient_types.IRODSAccess{{
+			UserName:    c.config.User,
+			UserZone:    c.config.Zone,
+			UserType:    irodsclient_types.IRODSUserRodsUser,
+			AccessLevel: irodsclient_types.IRODSAccessLevelOwner,
+		}},
+	}
+}
+
+func (c *Client) newFileNode(resource string, data []byte) *node {
+	n := c.newNode(false)
+	n.data = data
+	if resource == "" {
+		resource = c.config.DefaultResource
+	}
+	n.replicas = []*irodsclient_types.IRODSReplica{newReplica(0, resource, n)}
+	return n
+}
+
+func newReplica(number int64, resource string, n *node) *irodsclient_types.IRODSReplica {
+	return &irodsclient_types.IRODSReplica{
+		Number:            number,
+		Owner:             n.owner,
+		Status:            "1",
+		ResourceName:      resource,
+		ResourceHierarchy: resource,
+		CreateTime:        n.createTime,
+		ModifyTime:        n.modifyTime,
+	}
+}
+
+// newError returns an irods.Error of kind for op on p
+func newError(op string, p string, kind error, err error) error {
+	return &irods.Error{Op: op, Path: p, Kind: kind, Err: err}
+}
+
+func cleanPath(p string) string {
+	return path.Clean("/" + p)
+}
+
+// isBelow returns true if p is below dir
+func isBelow(p string, dir string) bool {
+	return dir == "/" && p != "/" || strings.HasPrefix(p, dir+"/")
+}
+
+// lookup returns the node at p, or an ErrNotFound error of op. Must be called with c.mu held.
+func (c *Client) lookup(op string, p string) (*node, error) {
+	n, ok := c.nodes[p]
+	if !ok {
+		return nil, newError(op, p, irods.ErrNotFound, nil)
+	}
+	return n, nil
+}
+
+// lookupParent checks that the parent collection of p exists. Must be called with c.mu held.
+func (c *Client) lookupParent(op string, p string) error {
+	parent, ok := c.nodes[path.Dir(p)]
+	if !ok {
+		return newError(op, p, irods.ErrNotFound, errors.Newf("no collection %q", path.Dir(p)))
+	}
+	if !parent.isDir {
+		return newError(op, p, irods.ErrInvalid, errors.Newf("%q is not a collection", path.Dir(p)))
+	}
+	return nil
+}
+
+// mkdirAll creates the collection p and missing parents. Must be called with c.mu held, or from New.
+func (c *Client) mkdirAll(p string) error {
+	if n, ok := c.nodes[p]; ok {
+		if !n.isDir {
+			return newError("mkdir", p, irods.ErrExists, errors.New("not a collection"))
+		}
+		return nil
+	}
+	if p != "/" {
+		if err := c.mkdirAll(path.Dir(p)); err != nil {
+			return err
+		}
+	}
+	c.nodes[p] = c.newNode(true)
+	return nil
+}
+
+// children returns the paths of the entries directly in dir, sorted. Must be called with c.mu held.
+func (c *Client) children(dir string) []string {
+	paths := []string{}
+	for p := range c.nodes {
+		if p != dir && path.Dir(p) == dir {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// subtree returns p and the paths below it, parents first. Must be called with c.mu held.
+func (c *Client) subtree(p string) []string {
+	paths := []string{}
+	for other := range c.nodes {
+		if other == p || isBelow(other, p) {
+			paths = append(paths, other)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// entry returns the entry of the node n at p. Must be called with c.mu held.
+func (c *Client) entry(p string, n *node) *irodsclient_fs.Entry {
+	entry := &irodsclient_fs.Entry{
+		ID:         n.id,
+		Type:       irodsclient_fs.FileEntry,
+		Name:       path.Base(p),
+		Path:       p,
+		Owner:      n.owner,
+		Size:       int64(len(n.data)),
+		CreateTime: n.createTime,
+		ModifyTime: n.modifyTime,
+		AccessTime: n.modifyTime,
+	}
+	switch {
+	case n.isDir:
+		entry.Type = irodsclient_fs.DirectoryEntry
+		entry.Size = 0
+	case n.target != "":
+		entry.Type = irods.SymlinkEntry
+	default:
+		entry.CheckSumAlgorithm = checksumAlgorithm
+		entry.CheckSum = checksum(n.data)
+	}
+	return entry
+}
+
+// AddDir creates a collection and its missing parents
+func (c *Client) AddDir(p string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.mkdirAll(cleanPath(p))
+}
+
+// AddFile creates or replaces a data object, creating missing parent collections
+func (c *Client) AddFile(p string, data []byte) error {
+	p = cleanPath(p)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.mkdirAll(path.Dir(p)); err != nil {
+		return err
+	}
+	if n, ok := c.nodes[p]; ok && n.isDir {
+		return newError("create", p, irods.ErrExists, errors.New("is a collection"))
+	}
+	c.nodes[p] = c.newFileNode("", append([]byte{}, data...))
+	return nil
+}
+
+// AddReplica adds a replica of the data object at p on resource. Replicas share the data of the object.
+func (c *Client) AddReplica(p string, resource string) error {
+	p = cleanPath(p)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n, err := c.lookup("replicate", p)
+	if err != nil {
+		return err
+	}
+	if n.isDir {
+		return newError("replicate", p, irods.ErrInvalid, errors.New("is a collection"))
+	}
+
+	number := int64(0)
+	for _, replica := range n.replicas {
+		number = max(number, replica.Number+1)
+	}
+	n.replicas = append(n.replicas, newReplica(number, resource, n))
+	return nil
+}
+
+// ReadFile returns a copy of the data of the data object at p
+func (c *Client) ReadFile(p string) ([]byte, error) {
+	p = cleanPath(p)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n, err := c.lookup("read", p)
+	if err != nil {
+		return nil, err
+	}
+	if n.isDir {
+		return nil, newError("read", p, irods.ErrInvalid, errors.New("is a collection"))
+	}
+	return append([]byte{}, n.data...), nil
+}
+
+// LoadLocalDir copies the local directory localDir recursively into the collection irodsDir
+func (c *Client) LoadLocalDir(localDir string, irodsDir string) error {
+	irodsDir = cleanPath(irodsDir)
+
+	return filepath.Walk(localDir, func(localPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(localDir, localPath)
+		if err != nil {
+			return err
+		}
+		irodsPath := path.Join(irodsDir, filepath.ToSlash(rel))
+
+		if info.IsDir() {
+			return c.AddDir(irodsPath)
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		data, err := os.ReadFile(localPath)
+		if err != nil {
+			return err
+		}
+		return c.AddFile(irodsPath, data)
+	})
+}
+
+// Paths returns the paths of all entries, sorted
+func (c *Client) Paths() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.subtree("/")
+}
+
+func (c *Client) Release() {
+	c.transfers.CancelAll()
+}
+
+func (c *Client) GetAccount() *irodsclient_types.IRODSAccount {
+	return c.account
+}
+
+func (c *Client) GetApplicationName() string {
+	return c.config.ApplicationName
+}
+
+// GetOpenConnections returns the number of open file handles
+func (c *Client) GetOpenConnections() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.openHandles
+}
+
+// GetMetrics returns the go-irodsclient metrics of the client, which the fake never updates; see
+// GetCallMetrics for the metrics of the fake
+func (c *Client) GetMetrics() *irodsclient_metrics.IRODSMetrics {
+	return &c.irodsMetrics
+}
+
+func (c *Client) List(p string) ([]*irodsclient_fs.Entry, error) {
+	p = cleanPath(p)
+	if err := c.begin("List", p); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n, err := c.lookup("list", p)
+	if err != nil {
+		return nil, err
+	}
+	if !n.isDir {
+		return nil, newError("list", p, irods.ErrInvalid, errors.New("not a collection"))
+	}
+
+	// Like the direct client, List does not detect symlinks, Stat does
+	entries := []*irodsclient_fs.Entry{}
+	for _, child := range c.children(p) {
+		entry := c.entry(child, c.nodes[child])
+		if entry.Type == irods.SymlinkEntry {
+			entry.Type = irodsclient_fs.FileEntry
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (c *Client) Stat(p string) (*irodsclient_fs.Entry, error) {
+	p = cleanPath(p)
+	if err := c.begin("Stat", p); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n, err := c.lookup("stat", p)
+	if err != nil {
+		return nil, err
+	}
+	return c.entry(p, n), nil
+}
+
+func (c *Client) ExistsDir(p string) bool {
+	p = cleanPath(p)
+	if err := c.begin("ExistsDir", p); err != nil {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n, ok := c.nodes[p]
+	return ok && n.isDir
+}
+
+func (c *Client) ExistsFile(p string) bool {
+	p = cleanPath(p)
+	if err := c.begin("ExistsFile", p); err != nil {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n, ok := c.nodes[p]
+	return ok && !n.isDir
+}
+
+func (c *Client) RemoveFile(p string, force bool) error {
+	p = cleanPath(p)
+	if err := c.begin("RemoveFile", p); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n, err := c.lookup("remove", p)
+	if err != nil {
+		return err
+	}
+	if n.isDir {
+		return newError("remove", p, irods.ErrInvalid, errors.New("is a collection"))
+	}
+	delete(c.nodes, p)
+	return nil
+}
+
+func (c *Client) RemoveDir(p string, recurse bool, force bool) error {
+	p = cleanPath(p)
+	if err := c.begin("RemoveDir", p); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n, err := c.lookup("rmdir", p)
+	if err != nil {
+		return err
+	}
+	if !n.isDir {
+		return newError("rmdir", p, irods.ErrInvalid, errors.New("not a collection"))
+	}
+	if p == "/" {
+		return newError("rmdir", p, irods.ErrInvalid, errors.New("cannot remove the root collection"))
+	}
+	if !recurse && len(c.children(p)) > 0 {
+		return newError("rmdir", p, irods.ErrNotEmpty, nil)
+	}
+
+	for _, other := range c.subtree(p) {
+		delete(c.nodes, other)
+	}
+	return nil
+}
+
+func (c *Client) MakeDir(p string, recurse bool) error {
+	p = cleanPath(p)
+	if err := c.begin("MakeDir", p); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if recurse {
+		return c.mkdirAll(p)
+	}
+	if _, ok := c.nodes[p]; ok {
+		return newError("mkdir", p, irods.ErrExists, nil)
+	}
+	if err := c.lookupParent("mkdir", p); err != nil {
+		return err
+	}
+	c.nodes[p] = c.newNode(true)
+	return nil
+}
+
+func (c *Client) RenameDirToDir(srcPath string, destPath string) error {
+	srcPath = cleanPath(srcPath)
+	destPath = cleanPath(destPath)
+	if err := c.begin("RenameDirToDir", srcPath); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n, err := c.lookup("rename", srcPath)
+	if err != nil {
+		return err
+	}
+	if !n.isDir {
+		return newError("rename", srcPath, irods.ErrInvalid, errors.New("not a collection"))
+	}
+	if srcPath == "/" || destPath == srcPath || isBelow(destPath, srcPath) {
+		return newError("rename", destPath, irods.ErrInvalid, errors.Newf("cannot move %q into itself", srcPath))
+	}
+	return c.move(srcPath, destPath)
+}
+
+func (c *Client) RenameFileToFile(srcPath string, destPath string) error {
+	srcPath = cleanPath(srcPath)
+	destPath = cleanPath(destPath)
+	if err := c.begin("RenameFileToFile", srcPath); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n, err := c.lookup("rename", srcPath)
+	if err != nil {
+		return err
+	}
+	if n.isDir {
+		return newError("rename", srcPath, irods.ErrInvalid, errors.New("is a collection"))
+	}
+	if destPath == srcPath {
+		return nil
+	}
+	return c.move(srcPath, destPath)
+}
+
+// move moves the subtree at srcPath to destPath, which must not exist. Must be called with c.mu held.
+func (c *Client) move(srcPath string, destPath string) error {
+	if _, ok := c.nodes[destPath]; ok {
+		return newError("rename", destPath, irods.ErrExists, nil)
+	}
+	if err := c.lookupParent("rename", destPath); err != nil {
+		return err
+	}
+
+	for _, p := range c.subtree(srcPath) {
+		c.nodes[destPath+strings.TrimPrefix(p, srcPath)] = c.nodes[p]
+		delete(c.nodes, p)
+	}
+	return nil
+}
+
+func (c *Client) TruncateFile(p string, size int64) error {
+	p = cleanPath(p)
+	if err := c.begin("TruncateFile", p); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n, err := c.lookup("truncate", p)
+	if err != nil {
+		return err
+	}
+	return n.truncate("truncate", p, size)
+}
+
+// truncate resizes the data of n. Must be called with c.mu held.
+func (n *node) truncate(op string, p string, size int64) error {
+	if n.isDir {
+		return newError(op, p, irods.ErrInvalid, errors.New("is a collection"))
+	}
+	if size < 0 {
+		return newError(op, p, irods.ErrInvalid, errors.Newf("negative size %d", size))
+	}
+
+	if size <= int64(len(n.data)) {
+		n.data = n.data[:size]
+	} else {
+		n.data = append(n.data, make([]byte, size-int64(len(n.data)))...)
+	}
+	n.modifyTime = time.Now()
+	return nil
+}
+
+// writeAt writes data at offset, growing the data of n. Must be called with c.mu held.
+func (n *node) writeAt(data []byte, offset int64) {
+	if end := offset + int64(len(data)); end > int64(len(n.data)) {
+		n.data = append(n.data, make([]byte, end-int64(len(n.data)))...)
+	}
+	copy(n.data[offset:], data)
+	n.modifyTime = time.Now()
+}
+
+func (c *Client) CreateFile(p string, mode string) (irods.IRODSFSFileHandle, error) {
+	return c.CreateFileWithOptions(p, mode, nil)
+}
+
+func (c *Client) OpenFile(p string, mode string) (irods.IRODSFSFileHandle, error) {
+	return c.OpenFileWithOptions(p, mode, nil)
+}
+
+// CreateFileWithOptions creates or truncates a data object with a replica on options.Resource
+func (c *Client) CreateFileWithOptions(p string, mode string, options *irods.OpenOptions) (irods.IRODSFSFileHandle, error) {
+	p = cleanPath(p)
+	if err := c.begin("CreateFile", p); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.lookupParent("create", p); err != nil {
+		return nil, err
+	}
+
+	n, ok := c.nodes[p]
+	switch {
+	case ok && n.isDir:
+		return nil, newError("create", p, irods.ErrExists, errors.New("is a collection"))
+	case ok:
+		n.truncate("create", p, 0)
+	default:
+		resource := ""
+		if options != nil {
+			resource = options.Resource
+		}
+		n = c.newFileNode(resource, nil)
+		c.nodes[p] = n
+	}
+	return c.newHandle(p, n, irodsclient_types.FileOpenMode(mode), nil), nil
+}
+
+// OpenFileWithOptions opens a data object. Write modes create missing data objects. Reads are pinned
+// to the replica options select.
+func (c *Client) OpenFileWithOptions(p string, mode string, options *irods.OpenOptions) (irods.IRODSFSFileHandle, error) {
+	p = cleanPath(p)
+	if err := c.begin("OpenFile", p); err != nil {
+		return nil, err
+	}
+
+	openMode := irodsclient_types.FileOpenMode(mode)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n, ok := c.nodes[p]
+	switch {
+	case !ok && openMode.IsWrite():
+		if err := c.lookupParent("open", p); err != nil {
+			return nil, err
+		}
+		resource := ""
+		if options != nil {
+			resource = options.Resource
+		}
+		n = c.newFileNode(resource, nil)
+		c.nodes[p] = n
+	case !ok:
+		return nil, newError("open", p, irods.ErrNotFound, nil)
+	case n.isDir:
+		return nil, newError("open", p, irods.ErrInvalid, errors.New("is a collection"))
+	case openMode.Truncate():
+		n.truncate("open", p, 0)
+	}
+
+	replica, err := selectReplica(p, n, options)
+	if err != nil {
+		return nil, err
+	}
+	return c.newHandle(p, n, openMode, replica), nil
+}
+
+// selectReplica returns the replica options pin reads to, or nil
+func selectReplica(p string, n *node, options *irods.OpenOptions) (*irodsclient_types.IRODSReplica, error) {
+	if options == nil {
+		return nil, nil
+	}
+
+	for _, replica := range n.replicas {
+		if options.ReadReplica != nil && replica.Number == *options.ReadReplica {
+			return replica, nil
+		}
+		if options.ReadReplica == nil && options.ReadResource != "" && replica.ResourceName == options.ReadResource {
+			return replica, nil
+		}
+	}
+	if options.ReadReplica != nil {
+		return nil, newError("open", p, irods.ErrNotFound, errors.Newf("no replica %d", *options.ReadReplica))
+	}
+	return nil, nil
+}
+
+func (c *Client) ListReplicas(p string) ([]*irodsclient_types.IRODSReplica, error) {
+	p = cleanPath(p)
+	if err := c.begin("ListReplicas", p); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n, err := c.lookup("replicas", p)
+	if err != nil {
+		return nil, err
+	}
+
+	replicas := make([]*irodsclient_types.IRODSReplica, 0, len(n.replicas))
+	for _, replica := range n.replicas {
+		copied := *replica
+		copied.ModifyTime = n.modifyTime
+		replicas = append(replicas, &copied)
+	}
+	return replicas, nil
+}
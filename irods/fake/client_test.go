@@ -0,0 +1,292 @@
+package fake
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	irodsclient_fs "github.com/cyverse/go-irodsclient/fs"
+	irodsclient_types "github.com/cyverse/go-irodsclient/irods/types"
+	"github.com/cyverse/irodsfs-common/irods"
+	"github.com/cyverse/irodsfs-common/irods/cache"
+	"github.com/cyverse/irodsfs-common/irods/stagingfs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientTree(t *testing.T) {
+	c := New(nil)
+	home := c.HomeDir()
+	assert.Equal(t, "/tempZone/home/rods", home)
+	assert.True(t, c.ExistsDir(home))
+
+	require.NoError(t, c.MakeDir(home+"/a/b", true))
+	require.NoError(t, c.AddFile(home+"/a/b/file.txt", []byte("hello")))
+
+	err := c.MakeDir(home+"/x/y", false)
+	assert.ErrorIs(t, err, irods.ErrNotFound)
+	err = c.MakeDir(home+"/a", false)
+	assert.ErrorIs(t, err, irods.ErrExists)
+
+	entries, err := c.List(home + "/a/b")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "file.txt", entries[0].Name)
+	assert.Equal(t, int64(5), entries[0].Size)
+	assert.Equal(t, checksumAlgorithm, entries[0].CheckSumAlgorithm)
+	assert.Equal(t, checksum([]byte("hello")), entries[0].CheckSum)
+
+	require.NoError(t, c.RenameDirToDir(home+"/a", home+"/c"))
+	assert.False(t, c.ExistsDir(home+"/a"))
+	data, err := c.ReadFile(home + "/c/b/file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	err = c.RenameDirToDir(home+"/c", home+"/c/b/d")
+	assert.ErrorIs(t, err, irods.ErrInvalid)
+	err = c.RemoveDir(home+"/c", false, false)
+	assert.ErrorIs(t, err, irods.ErrNotEmpty)
+	err = c.RemoveFile(home+"/c", false)
+	assert.ErrorIs(t, err, irods.ErrInvalid)
+
+	require.NoError(t, c.RemoveDir(home+"/c", true, false))
+	_, err = c.Stat(home + "/c/b/file.txt")
+	assert.ErrorIs(t, err, irods.ErrNotFound)
+	assert.Equal(t, []string{"/", "/tempZone", "/tempZone/home", home}, c.Paths())
+
+	// Symlinks are detected by Stat only, like with the direct client
+	require.NoError(t, c.Symlink("target", home+"/link"))
+	entries, err = c.List(home)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, irodsclient_fs.FileEntry, entries[0].Type)
+	entry, err := c.Stat(home + "/link")
+	require.NoError(t, err)
+	assert.Equal(t, irods.SymlinkEntry, entry.Type)
+}
+
+func TestClientFileHandle(t *testing.T) {
+	c := New(nil)
+	p := c.HomeDir() + "/file.txt"
+
+	handle, err := c.CreateFile(p, string(irodsclient_types.FileOpenModeWriteOnly))
+	require.NoError(t, err)
+	assert.Equal(t, 1, c.GetOpenConnections())
+
+	_, err = handle.WriteAt([]byte("hello world"), 0)
+	require.NoError(t, err)
+	_, err = handle.ReadAt(make([]byte, 5), 0)
+	assert.ErrorIs(t, err, irods.ErrNotReadMode)
+	require.NoError(t, handle.Truncate(5))
+	require.NoError(t, handle.Close())
+	assert.ErrorIs(t, handle.Close(), irods.ErrInvalid)
+	assert.Equal(t, 0, c.GetOpenConnections())
+
+	handle, err = c.OpenFile(p, string(irodsclient_types.FileOpenModeReadAppend))
+	require.NoError(t, err)
+	defer handle.Close()
+
+	_, err = handle.WriteAt([]byte("!"), 0)
+	require.NoError(t, err)
+
+	buffer := make([]byte, 10)
+	n, err := handle.ReadAt(buffer, 0)
+	assert.Equal(t, "hello!", string(buffer[:n]))
+	assert.Error(t, err)
+	assert.Equal(t, int64(4), handle.GetAvailable(2))
+
+	metrics := c.GetCallMetrics()
+	assert.Equal(t, int64(6), metrics.BytesRead)
+	assert.Equal(t, int64(12), metrics.BytesWritten)
+	assert.Equal(t, int64(1), metrics.Calls["CreateFile"])
+	assert.Equal(t, int64(2), metrics.Calls["ReadAt"])
+}
+
+func TestClientReplicas(t *testing.T) {
+	c := New(nil)
+	p := c.HomeDir() + "/file.txt"
+	require.NoError(t, c.AddFile(p, []byte("data")))
+	require.NoError(t, c.AddReplica(p, "archiveResc"))
+
+	replicas, err := c.ListReplicas(p)
+	require.NoError(t, err)
+	require.Len(t, replicas, 2)
+	assert.Equal(t, DefaultResource, replicas[0].ResourceName)
+	assert.Equal(t, "archiveResc", replicas[1].ResourceName)
+
+	handle, err := c.OpenFileWithOptions(p, string(irodsclient_types.FileOpenModeReadOnly), &irods.OpenOptions{ReadResource: "archiveResc"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), handle.GetReplica().Number)
+	require.NoError(t, handle.Close())
+
+	missing := int64(5)
+	_, err = c.OpenFileWithOptions(p, string(irodsclient_types.FileOpenModeReadOnly), &irods.OpenOptions{ReadReplica: &missing})
+	assert.ErrorIs(t, err, irods.ErrNotFound)
+}
+
+func TestClientFaults(t *testing.T) {
+	c := New(nil)
+	home := c.HomeDir()
+
+	c.InjectFault(irods.Fault{Op: "MakeDir", Err: irods.FailureFault("", nil).Err, Count: 2})
+	assert.ErrorIs(t, c.MakeDir(home+"/a", false), irods.ErrTransient)
+	assert.ErrorIs(t, c.MakeDir(home+"/a", false), irods.ErrTransient)
+	require.NoError(t, c.MakeDir(home+"/a", false))
+
+	id := c.InjectFault(irods.PermissionFault(home+"/a", true))
+	require.NoError(t, c.AddFile(home+"/a/file.txt", []byte("data")))
+	_, err := c.Stat(home + "/a/file.txt")
+	require.NoError(t, err)
+	err = c.RemoveFile(home+"/a/file.txt", false)
+	assert.ErrorIs(t, err, irods.ErrPermissionDenied)
+	err = c.MakeDir(home+"/b", false)
+	require.NoError(t, err)
+
+	c.RemoveFault(id)
+	require.NoError(t, c.RemoveFile(home+"/a/file.txt", false))
+
+	c.InjectFault(irods.LatencyFault("Stat", 20*time.Millisecond))
+	start := time.Now()
+	_, err = c.Stat(home)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+
+	c.ClearFaults()
+	c.InjectFault(irods.Fault{Op: "List", Err: irods.ErrQuotaExceeded, Probability: 0.5})
+	failures := 0
+	for i := 0; i < 200; i++ {
+		if _, err := c.List(home); err != nil {
+			assert.ErrorIs(t, err, irods.ErrQuotaExceeded)
+			failures++
+		}
+	}
+	assert.Greater(t, failures, 0)
+	assert.Less(t, failures, 200)
+	assert.Equal(t, int64(3+failures), c.GetCallMetrics().Faults)
+
+	c.ResetMetrics()
+	assert.Empty(t, c.GetCallMetrics().Calls)
+}
+
+func TestClientTransfers(t *testing.T) {
+	c := New(nil)
+	home := c.HomeDir()
+	tmpDir := t.TempDir()
+
+	localPath := filepath.Join(tmpDir, "upload.txt")
+	require.NoError(t, os.WriteFile(localPath, []byte("hello world"), 0644))
+	require.NoError(t, c.UploadFile(localPath, home+"/file.txt", nil))
+	require.NoError(t, c.VerifyUpload(localPath, home+"/file.txt"))
+
+	downloadPath := filepath.Join(tmpDir, "download.txt")
+	require.NoError(t, os.WriteFile(downloadPath, []byte("hello"), 0644))
+	require.NoError(t, c.DownloadFileWithOptions(home+"/file.txt", downloadPath, &irods.TransferOptions{Resume: true}))
+	data, err := os.ReadFile(downloadPath)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(data))
+
+	blocks := []string{}
+	err = c.DownloadFileWithCallback(home+"/file.txt", 4, 1, func(block []byte, offset int64) error {
+		blocks = append(blocks, string(block))
+		return nil
+	}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"hell", "o wo", "rld"}, blocks)
+
+	err = c.UploadFile(localPath, home+"/missing/file.txt", nil)
+	assert.ErrorIs(t, err, irods.ErrNotFound)
+	assert.Len(t, c.GetTransferManager().List(), 4)
+
+	require.NoError(t, c.AddFile(home+"/src/sub/nested.txt", []byte("nested")))
+	require.NoError(t, c.CopyDirToDir(home+"/src", home+"/dest", nil))
+	data, err = c.ReadFile(home + "/dest/sub/nested.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "nested", string(data))
+	assert.ErrorIs(t, c.CopyDirToDir(home+"/src", home+"/src/sub", nil), irods.ErrInvalid)
+
+	report, err := c.DownloadDir(home+"/dest", filepath.Join(tmpDir, "dest"), nil)
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Transferred)
+	data, err = os.ReadFile(filepath.Join(tmpDir, "dest", "sub", "nested.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "nested", string(data))
+}
+
+func TestClientBacksStagingFS(t *testing.T) {
+	c := New(nil)
+	home := c.HomeDir()
+
+	sf, err := stagingfs.NewStagingFS(&stagingfs.StagingFSConfig{
+		LocalRootPath: t.TempDir(),
+		Client:        c,
+		GracePeriod:   time.Hour,
+	})
+	require.NoError(t, err)
+	defer sf.Close(context.Background())
+
+	require.NoError(t, sf.Mkdir(home+"/dir"))
+	f, err := sf.OpenForWrite(home + "/dir/file.txt")
+	require.NoError(t, err)
+	_, err = f.Write([]byte("staged"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	c.InjectFault(irods.FailureFault("UploadFile", nil))
+	assert.Error(t, sf.SyncAll())
+	assert.False(t, c.ExistsFile(home+"/dir/file.txt"))
+
+	c.ClearFaults()
+	sf.ClearFailedItems()
+	require.NoError(t, sf.SyncAll())
+	data, err := c.ReadFile(home + "/dir/file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "staged", string(data))
+}
+
+func TestClientBacksBufferedClient(t *testing.T) {
+	c := New(nil)
+	home := c.HomeDir()
+
+	cacheManager, err := cache.NewMemoryCacheManager(&cache.MemoryCacheConfig{
+		NumCounters: 1000,
+		MaxCost:     1024 * 1024,
+		BufferItems: 64,
+		TTL:         time.Hour,
+	})
+	require.NoError(t, err)
+
+	bc, err := irods.NewIRODSFSClientBufferedWithClient(c, cacheManager, &irods.IRODSFSClientBufferedConfig{
+		StagingRootPath: t.TempDir(),
+		GracePeriod:     time.Hour,
+	})
+	require.NoError(t, err)
+	defer bc.Release()
+
+	f, err := bc.CreateFile(home+"/file.txt", "w")
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte("buffered"), 0)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	assert.False(t, c.ExistsFile(home+"/file.txt"))
+
+	require.NoError(t, bc.Sync())
+	data, err := c.ReadFile(home + "/file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "buffered", string(data))
+
+	for i := 0; i < 2; i++ {
+		f, err = bc.OpenFile(home+"/file.txt", "r")
+		require.NoError(t, err)
+		buf := make([]byte, 16)
+		n, err := f.ReadAt(buf, 0)
+		if err != io.EOF {
+			require.NoError(t, err)
+		}
+		assert.Equal(t, "buffered", string(buf[:n]))
+		require.NoError(t, f.Close())
+	}
+	assert.NotZero(t, c.GetCallMetrics().Calls["ReadAt"])
+}
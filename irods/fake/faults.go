@@ -0,0 +1,36 @@
+package fake
+
+import (
+	"github.com/cyverse/irodsfs-common/irods"
+)
+
+// Faults are irods.Fault values, naming the operations of a Client after its methods without the Ctx
+// suffix. A Client applies irods.FaultFail faults only; wrap it in an irods.IRODSFSClientFaulty for
+// partial reads and writes and dropped connections.
+
+// InjectFault adds a fault and returns its ID for RemoveFault. Faults apply in the order they were added;
+// the first that fails a call ends it.
+func (c *Client) InjectFault(fault irods.Fault) int {
+	return c.faults.InjectFault(fault)
+}
+
+// RemoveFault removes the fault with the given ID
+func (c *Client) RemoveFault(id int) {
+	c.faults.RemoveFault(id)
+}
+
+// ClearFaults removes all faults
+func (c *Client) ClearFaults() {
+	c.faults.ClearFaults()
+}
+
+// begin counts a call of op on p and applies the faults that match it
+func (c *Client) begin(op string, p string) error {
+	c.recordCall(op)
+
+	if err := c.faults.Apply(op, p, irods.FaultFail); err != nil {
+		c.recordFault()
+		return err
+	}
+	return nil
+}
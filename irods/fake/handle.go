@@ -0,0 +1,183 @@
+package fake
+
+import (
+	"io"
+	"os"
+
+	"github.com/cockroachdb/errors"
+	irodsclient_fs "github.com/cyverse/go-irodsclient/fs"
+	irodsclient_types "github.com/cyverse/go-irodsclient/irods/types"
+	"github.com/cyverse/irodsfs-common/irods"
+	"github.com/rs/xid"
+)
+
+// FileHandle is an open data object of a Client. Like an iRODS handle, it keeps working on the data
+// object after it is renamed.
+type FileHandle struct {
+	id      string
+	client  *Client
+	path    string
+	node    *node
+	mode    irodsclient_types.FileOpenMode
+	replica *irodsclient_types.IRODSReplica
+	closed  bool // Guarded by client.mu
+}
+
+var _ irods.IRODSFSFileHandle = (*FileHandle)(nil)
+
+// newHandle opens a handle on n. Must be called with c.mu held.
+func (c *Client) newHandle(p string, n *node, mode irodsclient_types.FileOpenMode, replica *irodsclient_types.IRODSReplica) *FileHandle {
+	c.openHandles++
+	return &FileHandle{
+		id:      xid.New().String(),
+		client:  c,
+		path:    p,
+		node:    n,
+		mode:    mode,
+		replica: replica,
+	}
+}
+
+func (h *FileHandle) GetID() string {
+	return h.id
+}
+
+func (h *FileHandle) GetEntry() *irodsclient_fs.Entry {
+	h.client.mu.Lock()
+	defer h.client.mu.Unlock()
+
+	return h.client.entry(h.path, h.node)
+}
+
+func (h *FileHandle) GetReplica() *irodsclient_types.IRODSReplica {
+	return h.replica
+}
+
+func (h *FileHandle) GetOpenMode() irodsclient_types.FileOpenMode {
+	return h.mode
+}
+
+func (h *FileHandle) IsReadMode() bool {
+	return h.mode.IsRead()
+}
+
+func (h *FileHandle) IsWriteMode() bool {
+	return h.mode.IsWrite()
+}
+
+// checkOpen returns an error if the handle is closed. Must be called with client.mu held.
+func (h *FileHandle) checkOpen(op string) error {
+	if h.closed {
+		return newError(op, h.path, irods.ErrInvalid, os.ErrClosed)
+	}
+	return nil
+}
+
+func (h *FileHandle) ReadAt(buffer []byte, offset int64) (int, error) {
+	if err := h.client.begin("ReadAt", h.path); err != nil {
+		return 0, err
+	}
+	if !h.IsReadMode() {
+		return 0, irods.ErrNotReadMode
+	}
+
+	h.client.mu.Lock()
+	defer h.client.mu.Unlock()
+
+	if err := h.checkOpen("read"); err != nil {
+		return 0, err
+	}
+	if offset < 0 {
+		return 0, newError("read", h.path, irods.ErrInvalid, errors.Newf("negative offset %d", offset))
+	}
+	if offset >= int64(len(h.node.data)) {
+		return 0, io.EOF
+	}
+
+	n := copy(buffer, h.node.data[offset:])
+	h.client.recordBytes(int64(n), 0)
+	if n < len(buffer) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// GetAvailable returns the number of bytes that can be read at offset
+func (h *FileHandle) GetAvailable(offset int64) int64 {
+	h.client.mu.Lock()
+	defer h.client.mu.Unlock()
+
+	return max(int64(len(h.node.data))-offset, 0)
+}
+
+// WriteAt writes data at offset, or at the end of the data object in append modes
+func (h *FileHandle) WriteAt(data []byte, offset int64) (int, error) {
+	if err := h.client.begin("WriteAt", h.path); err != nil {
+		return 0, err
+	}
+	if !h.IsWriteMode() {
+		return 0, irods.ErrNotWriteMode
+	}
+
+	h.client.mu.Lock()
+	defer h.client.mu.Unlock()
+
+	if err := h.checkOpen("write"); err != nil {
+		return 0, err
+	}
+	if offset < 0 {
+		return 0, newError("write", h.path, irods.ErrInvalid, errors.Newf("negative offset %d", offset))
+	}
+	if h.mode.IsAppend() {
+		offset = int64(len(h.node.data))
+	}
+
+	h.node.writeAt(data, offset)
+	h.client.recordBytes(0, int64(len(data)))
+	return len(data), nil
+}
+
+func (h *FileHandle) Truncate(size int64) error {
+	if err := h.client.begin("Truncate", h.path); err != nil {
+		return err
+	}
+	if !h.IsWriteMode() {
+		return irods.ErrNotWriteMode
+	}
+
+	h.client.mu.Lock()
+	defer h.client.mu.Unlock()
+
+	if err := h.checkOpen("truncate"); err != nil {
+		return err
+	}
+	return h.node.truncate("truncate", h.path, size)
+}
+
+// Flush does nothing; writes are visible as soon as they return
+func (h *FileHandle) Flush() error {
+	if err := h.client.begin("Flush", h.path); err != nil {
+		return err
+	}
+
+	h.client.mu.Lock()
+	defer h.client.mu.Unlock()
+
+	return h.checkOpen("flush")
+}
+
+func (h *FileHandle) Close() error {
+	if err := h.client.begin("Close", h.path); err != nil {
+		return err
+	}
+
+	h.client.mu.Lock()
+	defer h.client.mu.Unlock()
+
+	if err := h.checkOpen("close"); err != nil {
+		return err
+	}
+	h.closed = true
+	h.client.openHandles--
+	return nil
+}
@@ -0,0 +1,255 @@
+package fake
+
+import (
+	"sort"
+
+	"github.com/cockroachdb/errors"
+	irodsclient_types "github.com/cyverse/go-irodsclient/irods/types"
+	"github.com/cyverse/irodsfs-common/irods"
+)
+
+// Symlink creates a symlink at linkPath pointing to target, replacing a data object at linkPath.
+// Like the direct client, the target is also the content of the link.
+func (c *Client) Symlink(target string, linkPath string) error {
+	linkPath = cleanPath(linkPath)
+	if err := c.begin("Symlink", linkPath); err != nil {
+		return err
+	}
+
+	if target == "" || len(target) > irods.MaxSymlinkTargetLength {
+		return newError("symlink", linkPath, irods.ErrInvalid, errors.Newf("invalid symlink target %q", target))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.lookupParent("symlink", linkPath); err != nil {
+		return err
+	}
+	if n, ok := c.nodes[linkPath]; ok && n.isDir {
+		return newError("symlink", linkPath, irods.ErrExists, errors.New("is a collection"))
+	}
+
+	n := c.newFileNode("", []byte(target))
+	n.target = target
+	c.nodes[linkPath] = n
+	return nil
+}
+
+func (c *Client) Readlink(p string) (string, error) {
+	p = cleanPath(p)
+	if err := c.begin("Readlink", p); err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n, err := c.lookup("readlink", p)
+	if err != nil {
+		return "", err
+	}
+	if n.target == "" {
+		return "", newError("readlink", p, irods.ErrInvalid, errors.New("not a symlink"))
+	}
+	return n.target, nil
+}
+
+func (c *Client) GetXattr(p string, name string) ([]byte, error) {
+	p = cleanPath(p)
+	if err := c.begin("GetXattr", p); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n, err := c.lookup("getxattr", p)
+	if err != nil {
+		return nil, err
+	}
+
+	value, ok := n.xattrs[name]
+	if !ok {
+		return nil, newError("getxattr", p, irods.ErrNoAttribute, nil)
+	}
+	return append([]byte{}, value...), nil
+}
+
+func (c *Client) SetXattr(p string, name string, value []byte) error {
+	p = cleanPath(p)
+	if err := c.begin("SetXattr", p); err != nil {
+		return err
+	}
+
+	if name == "" {
+		return newError("setxattr", p, irods.ErrInvalid, errors.New("empty attribute name"))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n, err := c.lookup("setxattr", p)
+	if err != nil {
+		return err
+	}
+	n.xattrs[name] = append([]byte{}, value...)
+	return nil
+}
+
+// ListXattr returns the names of extended attributes, sorted
+func (c *Client) ListXattr(p string) ([]string, error) {
+	p = cleanPath(p)
+	if err := c.begin("ListXattr", p); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n, err := c.lookup("listxattr", p)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(n.xattrs))
+	for name := range n.xattrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (c *Client) RemoveXattr(p string, name string) error {
+	p = cleanPath(p)
+	if err := c.begin("RemoveXattr", p); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n, err := c.lookup("removexattr", p)
+	if err != nil {
+		return err
+	}
+	if _, ok := n.xattrs[name]; !ok {
+		return newError("removexattr", p, irods.ErrNoAttribute, nil)
+	}
+	delete(n.xattrs, name)
+	return nil
+}
+
+func (c *Client) GetACL(p string) ([]*irodsclient_types.IRODSAccess, error) {
+	p = cleanPath(p)
+	if err := c.begin("GetACL", p); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n, err := c.lookup("getacl", p)
+	if err != nil {
+		return nil, err
+	}
+
+	accesses := make([]*irodsclient_types.IRODSAccess, 0, len(n.acl))
+	for _, access := range n.acl {
+		copied := *access
+		copied.Path = p
+		accesses = append(accesses, &copied)
+	}
+	return accesses, nil
+}
+
+// SetACL grants access to a user or group. IRODSAccessLevelNull removes the access.
+func (c *Client) SetACL(p string, userName string, zoneName string, access irodsclient_types.IRODSAccessLevelType, recurse bool) error {
+	p = cleanPath(p)
+	if err := c.begin("SetACL", p); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n, err := c.lookup("setacl", p)
+	if err != nil {
+		return err
+	}
+
+	paths := []string{p}
+	if recurse && n.isDir {
+		paths = c.subtree(p)
+	}
+	for _, other := range paths {
+		c.nodes[other].setAccess(userName, zoneName, access)
+	}
+	return nil
+}
+
+// setAccess replaces the access of a user. Must be called with c.mu held.
+func (n *node) setAccess(userName string, zoneName string, level irodsclient_types.IRODSAccessLevelType) {
+	acl := n.acl[:0]
+	for _, access := range n.acl {
+		if access.UserName != userName || access.UserZone != zoneName {
+			acl = append(acl, access)
+		}
+	}
+	if level != irodsclient_types.IRODSAccessLevelNull {
+		acl = append(acl, &irodsclient_types.IRODSAccess{
+			UserName:    userName,
+			UserZone:    zoneName,
+			UserType:    irodsclient_types.IRODSUserRodsUser,
+			AccessLevel: level,
+		})
+	}
+	n.acl = acl
+}
+
+// SetInheritance sets the inheritance flag of a collection
+func (c *Client) SetInheritance(p string, inherit bool, recurse bool) error {
+	p = cleanPath(p)
+	if err := c.begin("SetInheritance", p); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n, err := c.lookup("setinheritance", p)
+	if err != nil {
+		return err
+	}
+	if !n.isDir {
+		return newError("setinheritance", p, irods.ErrInvalid, errors.New("not a collection"))
+	}
+
+	paths := []string{p}
+	if recurse {
+		paths = c.subtree(p)
+	}
+	for _, other := range paths {
+		if c.nodes[other].isDir {
+			c.nodes[other].inherit = inherit
+		}
+	}
+	return nil
+}
+
+// GetInheritance returns the inheritance flag of a collection
+func (c *Client) GetInheritance(p string) (bool, error) {
+	p = cleanPath(p)
+	if err := c.begin("GetInheritance", p); err != nil {
+		return false, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n, err := c.lookup("getinheritance", p)
+	if err != nil {
+		return false, err
+	}
+	return n.inherit, nil
+}
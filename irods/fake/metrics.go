@@ -0,0 +1,52 @@
+package fake
+
+// Metrics counts the calls of a Client
+type Metrics struct {
+	Calls        map[string]int64 // Calls by operation, including failed ones
+	Faults       int64            // Calls failed by an injected fault
+	BytesRead    int64            // Bytes read through file handles and downloads
+	BytesWritten int64            // Bytes written through file handles and uploads
+}
+
+// GetCallMetrics returns a snapshot of the metrics of the client
+func (c *Client) GetCallMetrics() Metrics {
+	c.metricsMu.Lock()
+	defer c.metricsMu.Unlock()
+
+	snapshot := c.metrics
+	snapshot.Calls = make(map[string]int64, len(c.metrics.Calls))
+	for op, calls := range c.metrics.Calls {
+		snapshot.Calls[op] = calls
+	}
+	return snapshot
+}
+
+// ResetMetrics sets all metrics to zero
+func (c *Client) ResetMetrics() {
+	c.metricsMu.Lock()
+	defer c.metricsMu.Unlock()
+
+	c.metrics = Metrics{Calls: map[string]int64{}}
+}
+
+func (c *Client) recordCall(op string) {
+	c.metricsMu.Lock()
+	defer c.metricsMu.Unlock()
+
+	c.metrics.Calls[op]++
+}
+
+func (c *Client) recordFault() {
+	c.metricsMu.Lock()
+	defer c.metricsMu.Unlock()
+
+	c.metrics.Faults++
+}
+
+func (c *Client) recordBytes(read int64, written int64) {
+	c.metricsMu.Lock()
+	defer c.metricsMu.Unlock()
+
+	c.metrics.BytesRead += read
+	c.metrics.BytesWritten += written
+}
@@ -0,0 +1,401 @@
+package fake
+
+import (
+	"context"
+	"crypto/sha256"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	irodsclient_common "github.com/cyverse/go-irodsclient/irods/common"
+	irodsclient_types "github.com/cyverse/go-irodsclient/irods/types"
+	"github.com/cyverse/irodsfs-common/irods"
+)
+
+// checksumAlgorithm is the algorithm of the checksums in entries of data objects
+const checksumAlgorithm = "SHA-256"
+
+func checksum(data []byte) []byte {
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
+
+// Downloads are "DownloadFile" operations and uploads "UploadFile" operations, whichever method starts them
+
+// snapshot returns a copy of the data of the data object at p
+func (c *Client) snapshot(op string, p string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n, err := c.lookup(op, p)
+	if err != nil {
+		return nil, err
+	}
+	if n.isDir {
+		return nil, newError(op, p, irods.ErrInvalid, errors.New("is a collection"))
+	}
+	return append([]byte{}, n.data...), nil
+}
+
+func (c *Client) DownloadFile(irodsPath string, localPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.DownloadFileWithOptions(irodsPath, localPath, &irods.TransferOptions{TransferCallback: transferCallback})
+}
+
+func (c *Client) DownloadFileParallel(irodsPath string, localPath string, taskNum int, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.DownloadFileWithOptions(irodsPath, localPath, &irods.TransferOptions{TaskNum: taskNum, TransferCallback: transferCallback})
+}
+
+func (c *Client) DownloadFileWithCallback(irodsPath string, blockSize int, numBlocks int, blockReadyCallback irodsclient_common.DataObjectBlockCallback, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.DownloadFileWithOptions(irodsPath, "", &irods.TransferOptions{
+		BlockSize:          blockSize,
+		NumBlocks:          numBlocks,
+		BlockReadyCallback: blockReadyCallback,
+		TransferCallback:   transferCallback,
+	})
+}
+
+func (c *Client) DownloadFileParallelWithCallback(irodsPath string, blockSize int, numBlocks int, blockReadyCallback irodsclient_common.DataObjectBlockCallback, taskNum int, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.DownloadFileWithOptions(irodsPath, "", &irods.TransferOptions{
+		TaskNum:            taskNum,
+		BlockSize:          blockSize,
+		NumBlocks:          numBlocks,
+		BlockReadyCallback: blockReadyCallback,
+		TransferCallback:   transferCallback,
+	})
+}
+
+// DownloadFileWithOptions downloads a data object to localPath, or in blocks to options.BlockReadyCallback
+// if localPath is empty. With options.Resume, a shorter local file is completed.
+func (c *Client) DownloadFileWithOptions(irodsPath string, localPath string, options *irods.TransferOptions) error {
+	irodsPath = cleanPath(irodsPath)
+
+	return c.transfers.Run(context.Background(), irods.TransferDownload, irodsPath, localPath, options, func(ctx context.Context, options *irods.TransferOptions) error {
+		if err := c.begin("DownloadFile", irodsPath); err != nil {
+			return err
+		}
+
+		data, err := c.snapshot("download", irodsPath)
+		if err != nil {
+			return err
+		}
+
+		blockReadyCallback := options.BlockReadyCallback
+		offset := int64(0)
+		if localPath != "" {
+			flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+			if options.Resume {
+				if info, statErr := os.Stat(localPath); statErr == nil && info.Size() <= int64(len(data)) {
+					flags = os.O_CREATE | os.O_WRONLY
+					offset = info.Size()
+				}
+			}
+
+			f, err := os.OpenFile(localPath, flags, 0644)
+			if err != nil {
+				return errors.Wrapf(err, "failed to open local file %q", localPath)
+			}
+			defer f.Close()
+
+			blockReadyCallback = func(block []byte, blockOffset int64) error {
+				_, writeErr := f.WriteAt(block, blockOffset)
+				return writeErr
+			}
+		}
+
+		if err := c.sendBlocks(ctx, data, offset, options, blockReadyCallback); err != nil {
+			return err
+		}
+		c.recordBytes(int64(len(data))-offset, 0)
+		return nil
+	})
+}
+
+// sendBlocks passes data from offset to blockReadyCallback in blocks of options.BlockSize, stopping once
+// ctx is done
+func (c *Client) sendBlocks(ctx context.Context, data []byte, offset int64, options *irods.TransferOptions, blockReadyCallback irodsclient_common.DataObjectBlockCallback) error {
+	size := int64(len(data))
+	for offset < size {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		end := min(offset+int64(options.BlockSize), size)
+		if blockReadyCallback != nil {
+			if err := blockReadyCallback(data[offset:end], offset); err != nil {
+				return err
+			}
+		}
+		offset = end
+
+		if options.TransferCallback != nil {
+			options.TransferCallback(string(irods.TransferDownload), offset, size)
+		}
+	}
+
+	if size == 0 && options.TransferCallback != nil {
+		options.TransferCallback(string(irods.TransferDownload), 0, 0)
+	}
+	return nil
+}
+
+func (c *Client) UploadFile(localPath string, irodsPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.UploadFileWithOptions(localPath, irodsPath, &irods.TransferOptions{TransferCallback: transferCallback})
+}
+
+func (c *Client) UploadFileParallel(localPath string, irodsPath string, taskNum int, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.UploadFileWithOptions(localPath, irodsPath, &irods.TransferOptions{TaskNum: taskNum, TransferCallback: transferCallback})
+}
+
+// UploadFileWithOptions uploads a local file, replacing the data object at irodsPath. With options.Resume,
+// a shorter data object is completed.
+func (c *Client) UploadFileWithOptions(localPath string, irodsPath string, options *irods.TransferOptions) error {
+	irodsPath = cleanPath(irodsPath)
+
+	return c.transfers.Run(context.Background(), irods.TransferUpload, irodsPath, localPath, options, func(ctx context.Context, options *irods.TransferOptions) error {
+		if err := c.begin("UploadFile", irodsPath); err != nil {
+			return err
+		}
+
+		data, err := os.ReadFile(localPath)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read local file %q", localPath)
+		}
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		if err := c.lookupParent("upload", irodsPath); err != nil {
+			return err
+		}
+
+		n, ok := c.nodes[irodsPath]
+		switch {
+		case ok && n.isDir:
+			return newError("upload", irodsPath, irods.ErrExists, errors.New("is a collection"))
+		case ok && options.Resume && len(n.data) <= len(data):
+			n.writeAt(data[len(n.data):], int64(len(n.data)))
+		case ok:
+			n.target = ""
+			n.data = data
+			n.truncate("upload", irodsPath, int64(len(data)))
+		default:
+			c.nodes[irodsPath] = c.newFileNode(options.Resource, data)
+		}
+
+		c.recordBytes(0, int64(len(data)))
+		if options.TransferCallback != nil {
+			options.TransferCallback(string(irods.TransferUpload), int64(len(data)), int64(len(data)))
+		}
+		return nil
+	})
+}
+
+// UploadFileRange uploads a byte range of a local file to the same range of a data object, creating it
+// if it does not exist. Together with TruncateFile and GetDataObjectSize, it makes Client a stagingfs.ResumableStagingClient.
+func (c *Client) UploadFileRange(localPath string, irodsPath string, offset int64, length int64, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	irodsPath = cleanPath(irodsPath)
+	if err := c.begin("UploadFileRange", irodsPath); err != nil {
+		return err
+	}
+
+	f, err := os.Open(localPath)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open local file %q", localPath)
+	}
+	defer f.Close()
+
+	data := make([]byte, length)
+	n, err := f.ReadAt(data, offset)
+	if err != nil && err != io.EOF {
+		return errors.Wrapf(err, "failed to read local file %q", localPath)
+	}
+	data = data[:n]
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.lookupParent("upload", irodsPath); err != nil {
+		return err
+	}
+
+	node, ok := c.nodes[irodsPath]
+	if !ok {
+		node = c.newFileNode("", nil)
+		c.nodes[irodsPath] = node
+	}
+	if node.isDir {
+		return newError("upload", irodsPath, irods.ErrExists, errors.New("is a collection"))
+	}
+
+	node.writeAt(data, offset)
+	c.recordBytes(0, int64(n))
+	if transferCallback != nil {
+		transferCallback(string(irods.TransferUpload), int64(n), length)
+	}
+	return nil
+}
+
+// GetDataObjectSize returns the size of the data object at p
+func (c *Client) GetDataObjectSize(p string) (int64, error) {
+	p = cleanPath(p)
+	if err := c.begin("GetDataObjectSize", p); err != nil {
+		return 0, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n, err := c.lookup("stat", p)
+	if err != nil {
+		return 0, err
+	}
+	if n.isDir {
+		return 0, newError("stat", p, irods.ErrInvalid, errors.New("is a collection"))
+	}
+	return int64(len(n.data)), nil
+}
+
+// VerifyUpload checks that the data object at irodsPath has the content of the local file
+func (c *Client) VerifyUpload(localPath string, irodsPath string) error {
+	irodsPath = cleanPath(irodsPath)
+
+	local, err := os.ReadFile(localPath)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read local file %q", localPath)
+	}
+
+	data, err := c.snapshot("verify", irodsPath)
+	if err != nil {
+		return err
+	}
+	if string(data) != string(local) {
+		return newError("verify", irodsPath, irods.ErrTransient, errors.Newf("content differs from %q", localPath))
+	}
+	return nil
+}
+
+func (c *Client) GetTransferManager() *irods.TransferManager {
+	return c.transfers
+}
+
+// UploadDir uploads a local directory recursively
+func (c *Client) UploadDir(localDir string, irodsDir string, options *irods.DirTransferOptions) (*irods.DirTransferReport, error) {
+	return irods.WithContext(c).UploadDirCtx(context.Background(), localDir, irodsDir, options)
+}
+
+// DownloadDir downloads a collection recursively
+func (c *Client) DownloadDir(irodsDir string, localDir string, options *irods.DirTransferOptions) (*irods.DirTransferReport, error) {
+	return irods.WithContext(c).DownloadDirCtx(context.Background(), irodsDir, localDir, options)
+}
+
+// CopyFileToFile copies a data object or symlink, replacing a data object at destPath
+func (c *Client) CopyFileToFile(srcPath string, destPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	srcPath = cleanPath(srcPath)
+	destPath = cleanPath(destPath)
+	if err := c.begin("CopyFileToFile", srcPath); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	src, err := c.lookup("copy", srcPath)
+	if err != nil {
+		return err
+	}
+	if src.isDir {
+		return newError("copy", srcPath, irods.ErrInvalid, errors.New("is a collection"))
+	}
+	if err := c.copyNode(srcPath, destPath); err != nil {
+		return err
+	}
+
+	if transferCallback != nil {
+		size := int64(len(src.data))
+		transferCallback(irods.CopyTaskName, size, size)
+	}
+	return nil
+}
+
+// CopyDirToDir copies a collection recursively into destPath, creating it if it does not exist
+func (c *Client) CopyDirToDir(srcPath string, destPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	srcPath = cleanPath(srcPath)
+	destPath = cleanPath(destPath)
+	if err := c.begin("CopyDirToDir", srcPath); err != nil {
+		return err
+	}
+
+	if destPath == srcPath || isBelow(destPath, srcPath) {
+		return newError("copy", destPath, irods.ErrInvalid, errors.Newf("cannot copy %q into itself", srcPath))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	src, err := c.lookup("copy", srcPath)
+	if err != nil {
+		return err
+	}
+	if !src.isDir {
+		return newError("copy", srcPath, irods.ErrInvalid, errors.New("not a collection"))
+	}
+
+	total := int64(0)
+	paths := c.subtree(srcPath)
+	for _, p := range paths {
+		total += int64(len(c.nodes[p].data))
+	}
+
+	copied := int64(0)
+	for _, p := range paths {
+		if err := c.copyNode(p, destPath+strings.TrimPrefix(p, srcPath)); err != nil {
+			return err
+		}
+		copied += int64(len(c.nodes[p].data))
+	}
+
+	if transferCallback != nil {
+		transferCallback(irods.CopyTaskName, copied, total)
+	}
+	return nil
+}
+
+// copyNode copies the entry at srcPath to destPath without its subtree. Collections are merged into
+// existing ones, data objects replace existing ones. Must be called with c.mu held.
+func (c *Client) copyNode(srcPath string, destPath string) error {
+	src := c.nodes[srcPath]
+	if err := c.lookupParent("copy", destPath); err != nil {
+		return err
+	}
+
+	dest, ok := c.nodes[destPath]
+	if ok && dest.isDir != src.isDir {
+		return newError("copy", destPath, irods.ErrExists, errors.New("is a different type of entry"))
+	}
+	if ok && dest.isDir {
+		return nil
+	}
+
+	copied := c.newNode(src.isDir)
+	copied.data = append([]byte{}, src.data...)
+	copied.target = src.target
+	for name, value := range src.xattrs {
+		copied.xattrs[name] = append([]byte{}, value...)
+	}
+	if !src.isDir {
+		copied.replicas = []*irodsclient_types.IRODSReplica{newReplica(0, c.config.DefaultResource, copied)}
+	}
+	c.nodes[destPath] = copied
+	return nil
+}
+
+// CacheFile reads a data object like a cache fill would, without keeping it
+func (c *Client) CacheFile(irodsPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.DownloadFileWithCallback(irodsPath, irods.DefaultTransferBlockSize, irods.DefaultTransferNumBlocks, nil, transferCallback)
+}
+
+// Sync does nothing; changes are visible as soon as they return
+func (c *Client) Sync() error {
+	return c.begin("Sync", "/")
+}
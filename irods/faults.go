@@ -0,0 +1,301 @@
+package irods
+
+import (
+	"context"
+	"math/rand"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+// Faults name operations after the client methods without the Ctx suffix; the WithOptions, Parallel and
+// WithCallback variants of a method share its name, e.g. "OpenFile", "DownloadFile" or "UploadFile".
+// File handle operations are "ReadAt", "WriteAt", "Truncate", "Flush" and "Close".
+
+// errInjectedFault is the cause of faults injected without a configured error
+var errInjectedFault = errors.Wrap(syscall.ECONNRESET, "injected fault")
+
+// faultWriteOps are the operations that change the tree
+var faultWriteOps = map[string]bool{
+	"RemoveFile":       true,
+	"RemoveDir":        true,
+	"MakeDir":          true,
+	"RenameDirToDir":   true,
+	"RenameFileToFile": true,
+	"CreateFile":       true,
+	"TruncateFile":     true,
+	"Symlink":          true,
+	"SetXattr":         true,
+	"RemoveXattr":      true,
+	"SetACL":           true,
+	"SetInheritance":   true,
+	"CopyFileToFile":   true,
+	"CopyDirToDir":     true,
+	"UploadFile":       true,
+	"UploadFileRange":  true,
+	"UploadDir":        true,
+	"WriteAt":          true,
+	"Truncate":         true,
+}
+
+// FaultEffect is what a fault does to the calls it applies to
+type FaultEffect int
+
+const (
+	// FaultFail fails a call with Err before it runs; a fault without Err only delays it
+	FaultFail FaultEffect = iota
+	// FaultPartial makes a ReadAt or WriteAt transfer only part of its buffer, then fail
+	FaultPartial
+	// FaultDrop loses the connection of a download, an upload or a file handle partway through. Dropped
+	// uploads leave part of the file in iRODS, dropped downloads part of it locally, and dropped handles
+	// fail every later call.
+	FaultDrop
+)
+
+// Fault is injected into the matching calls of a client. IRODSFSClientFaulty applies all effects to the
+// client it wraps; fake.Client applies FaultFail faults only.
+type Fault struct {
+	Op         string        // Operation (empty = all operations)
+	PathPrefix string        // Applies to paths at or below this path (empty = all paths)
+	WritesOnly bool          // Applies only to operations that change the tree
+	Effect     FaultEffect   // What the fault does to a call (default: FaultFail)
+	Latency    time.Duration // Delay of the calls the fault applies to
+	Jitter     time.Duration // Random extra delay, up to this
+	Err        error         // Error of failed calls (nil = a transient connection reset, or no failure for FaultFail)
+
+	Probability float64 // Chance that a matching call is affected (0 = every call)
+	Count       int     // Number of calls affected before the fault is removed (0 = unlimited)
+}
+
+// LatencyFault delays every call of op
+func LatencyFault(op string, latency time.Duration) Fault {
+	return Fault{Op: op, Latency: latency}
+}
+
+// FailureFault fails calls of op. A nil err fails them with a transient error.
+func FailureFault(op string, err error) Fault {
+	if err == nil {
+		err = errInjectedFault
+	}
+	return Fault{Op: op, Err: err}
+}
+
+// PermissionFault denies calls on entries at or below pathPrefix; only those that change them if writesOnly
+// is set
+func PermissionFault(pathPrefix string, writesOnly bool) Fault {
+	return Fault{PathPrefix: pathPrefix, WritesOnly: writesOnly, Err: ErrPermissionDenied}
+}
+
+func (f *Fault) matches(op string, p string, effect FaultEffect) bool {
+	if f.Effect != effect {
+		return false
+	}
+	if f.Op != "" && f.Op != op {
+		return false
+	}
+	if f.WritesOnly && !faultWriteOps[op] {
+		return false
+	}
+	if f.PathPrefix != "" {
+		prefix := path.Clean("/" + f.PathPrefix)
+		p = path.Clean("/" + p)
+		if p != prefix && prefix != "/" && !strings.HasPrefix(p, prefix+"/") {
+			return false
+		}
+	}
+	return true
+}
+
+// failure returns the error of a call of op on p the fault applies to
+func (f *Fault) failure(op string, p string) error {
+	err := f.Err
+	if err == nil {
+		err = errInjectedFault
+	}
+
+	var kindErr *Error
+	if errors.As(err, &kindErr) {
+		return err
+	}
+	return newError(op, p, faultKind(err), err)
+}
+
+// faultKind returns the kind of an injected error, ErrTransient if unknown
+func faultKind(err error) error {
+	for _, kind := range []error{
+		ErrNotFound, ErrExists, ErrNotEmpty, ErrPermissionDenied, ErrQuotaExceeded, ErrStaging, ErrConflict,
+		ErrTransient, ErrInvalid, ErrNoAttribute, ErrReadOnly,
+	} {
+		if errors.Is(err, kind) {
+			return kind
+		}
+	}
+
+	if kind := errorKind(err); kind != nil {
+		return kind
+	}
+	return ErrTransient
+}
+
+// faultRule is an injected fault and the calls it has left
+type faultRule struct {
+	id        int
+	fault     Fault
+	remaining int
+}
+
+// FaultInjector holds the faults of a client and decides which calls they apply to. Decisions come from
+// a seeded source, so a seed injects the same faults into the same sequence of calls.
+// It is safe for concurrent use.
+type FaultInjector struct {
+	mu       sync.Mutex
+	random   *rand.Rand
+	enabled  bool
+	faults   []*faultRule
+	injected map[string]int64
+}
+
+// NewFaultInjector creates an enabled FaultInjector with the given faults
+func NewFaultInjector(seed int64, faults ...Fault) *FaultInjector {
+	injector := &FaultInjector{
+		random:   rand.New(rand.NewSource(seed)),
+		enabled:  true,
+		injected: map[string]int64{},
+	}
+	for _, fault := range faults {
+		injector.InjectFault(fault)
+	}
+	return injector
+}
+
+// InjectFault adds a fault and returns its ID for RemoveFault. Faults apply in the order they were added;
+// the first that fails a call ends it.
+func (i *FaultInjector) InjectFault(fault Fault) int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	id := 1
+	if len(i.faults) > 0 {
+		id = i.faults[len(i.faults)-1].id + 1
+	}
+	i.faults = append(i.faults, &faultRule{id: id, fault: fault, remaining: fault.Count})
+	return id
+}
+
+// RemoveFault removes the fault with the given ID
+func (i *FaultInjector) RemoveFault(id int) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	for index, rule := range i.faults {
+		if rule.id == id {
+			i.faults = append(i.faults[:index], i.faults[index+1:]...)
+			return
+		}
+	}
+}
+
+// ClearFaults removes all faults
+func (i *FaultInjector) ClearFaults() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.faults = nil
+}
+
+// SetEnabled turns fault injection on or off, e.g. to set up or check the state of iRODS
+func (i *FaultInjector) SetEnabled(enabled bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.enabled = enabled
+}
+
+// GetInjectedFaults returns the number of calls failed, cut short or dropped by operation
+func (i *FaultInjector) GetInjectedFaults() map[string]int64 {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	injected := make(map[string]int64, len(i.injected))
+	for op, count := range i.injected {
+		injected[op] = count
+	}
+	return injected
+}
+
+// Apply delays a call of op on p by the latency of the faults with the given effect that apply to it,
+// and returns the error of the first of them that fails it, if any
+func (i *FaultInjector) Apply(op string, p string, effect FaultEffect) error {
+	return i.ApplyCtx(context.Background(), op, p, effect)
+}
+
+// ApplyCtx is Apply for a call with a context. The delay ends early once ctx is done, failing the call
+// with ctx.Err().
+func (i *FaultInjector) ApplyCtx(ctx context.Context, op string, p string, effect FaultEffect) error {
+	latency, err := i.match(op, p, effect)
+	if latency <= 0 {
+		return err
+	}
+
+	timer := time.NewTimer(latency)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+	}
+	return err
+}
+
+func (i *FaultInjector) match(op string, p string, effect FaultEffect) (time.Duration, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if !i.enabled {
+		return 0, nil
+	}
+
+	latency := time.Duration(0)
+	kept := i.faults[:0]
+	var err error
+	for _, rule := range i.faults {
+		applies := err == nil && rule.fault.matches(op, p, effect) &&
+			(rule.fault.Probability <= 0 || i.random.Float64() < rule.fault.Probability)
+		if applies {
+			latency += rule.fault.Latency
+			if rule.fault.Jitter > 0 {
+				latency += time.Duration(i.random.Int63n(int64(rule.fault.Jitter)))
+			}
+			if rule.fault.Err != nil || effect != FaultFail {
+				err = rule.fault.failure(op, p)
+				i.injected[op]++
+			}
+			if rule.remaining > 0 {
+				rule.remaining--
+				if rule.remaining == 0 {
+					continue
+				}
+			}
+		}
+		kept = append(kept, rule)
+	}
+	i.faults = kept
+	return latency, err
+}
+
+// randomInt64 returns a random number in [0, n)
+func (i *FaultInjector) randomInt64(n int64) int64 {
+	if n <= 0 {
+		return 0
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	return i.random.Int63n(n)
+}
@@ -1,6 +1,8 @@
 package irods
 
 import (
+	"context"
+
 	"github.com/cockroachdb/errors"
 	irodsclient_fs "github.com/cyverse/go-irodsclient/fs"
 	irodsclient_common "github.com/cyverse/go-irodsclient/irods/common"
@@ -11,7 +13,7 @@ import (
 var (
 	ErrNotReadMode  = errors.New("file is not opened in read mode")
 	ErrNotWriteMode = errors.New("file is not opened in write mode")
-	ErrFileStaging  = errors.New("file is in staging state and not yet synced to iRODS")
+	ErrFileStaging  = newError("", "", ErrStaging, errors.New("file is in staging state and not yet synced to iRODS"))
 )
 
 type IRODSFSClient interface {
@@ -37,6 +39,28 @@ type IRODSFSClient interface {
 	OpenFile(path string, mode string) (IRODSFSFileHandle, error)
 	TruncateFile(path string, size int64) error
 
+	// Resource and replica selection, see OpenOptions
+	CreateFileWithOptions(path string, mode string, options *OpenOptions) (IRODSFSFileHandle, error)
+	OpenFileWithOptions(path string, mode string, options *OpenOptions) (IRODSFSFileHandle, error)
+	ListReplicas(path string) ([]*irodsclient_types.IRODSReplica, error)
+
+	// Symlinks and extended attributes, stored as AVUs in AVUNamespace
+	Symlink(target string, linkPath string) error
+	Readlink(path string) (string, error)
+	GetXattr(path string, name string) ([]byte, error)
+	SetXattr(path string, name string, value []byte) error
+	ListXattr(path string) ([]string, error)
+	RemoveXattr(path string, name string) error
+
+	// Access control
+	GetACL(path string) ([]*irodsclient_types.IRODSAccess, error)
+	SetACL(path string, userName string, zoneName string, access irodsclient_types.IRODSAccessLevelType, recurse bool) error
+	SetInheritance(path string, inherit bool, recurse bool) error
+
+	// Server-side copy
+	CopyFileToFile(srcPath string, destPath string, transferCallback irodsclient_common.TransferTrackerCallback) error
+	CopyDirToDir(srcPath string, destPath string, transferCallback irodsclient_common.TransferTrackerCallback) error
+
 	// Sync
 	Sync() error
 
@@ -47,6 +71,13 @@ type IRODSFSClient interface {
 	DownloadFileParallelWithCallback(irodsPath string, blockSize int, numBlocks int, blockReadyCallback irodsclient_common.DataObjectBlockCallback, taskNum int, transferCallback irodsclient_common.TransferTrackerCallback) error
 	UploadFile(localPath string, irodsPath string, transferCallback irodsclient_common.TransferTrackerCallback) error
 	UploadFileParallel(localPath string, irodsPath string, taskNum int, transferCallback irodsclient_common.TransferTrackerCallback) error
+	DownloadFileWithOptions(irodsPath string, localPath string, options *TransferOptions) error
+	UploadFileWithOptions(localPath string, irodsPath string, options *TransferOptions) error
+	GetTransferManager() *TransferManager
+
+	// Directory Transfer
+	UploadDir(localDir string, irodsDir string, options *DirTransferOptions) (*DirTransferReport, error)
+	DownloadDir(irodsDir string, localDir string, options *DirTransferOptions) (*DirTransferReport, error)
 
 	// Cache
 	CacheFile(irodsPath string, transferCallback irodsclient_common.TransferTrackerCallback) error
@@ -55,6 +86,8 @@ type IRODSFSClient interface {
 type IRODSFSFileHandle interface {
 	GetID() string
 	GetEntry() *irodsclient_fs.Entry
+	// GetReplica returns the replica reads are pinned to, or nil if the server chooses
+	GetReplica() *irodsclient_types.IRODSReplica
 	GetOpenMode() irodsclient_types.FileOpenMode
 	IsReadMode() bool
 	IsWriteMode() bool
@@ -65,3 +98,81 @@ type IRODSFSFileHandle interface {
 	Flush() error
 	Close() error
 }
+
+// IRODSFSClientCtx is an IRODSFSClient with variants of its operations that take a context.
+// Operations that only read return ctx.Err() as soon as ctx is done; the abandoned request may still
+// run to completion in the background. Operations that change iRODS are not started once ctx is done,
+// but are not interrupted while running because iRODS cannot roll them back.
+// Use WithContext to get an IRODSFSClientCtx for any IRODSFSClient.
+type IRODSFSClientCtx interface {
+	IRODSFSClient
+
+	// API
+	ListCtx(ctx context.Context, path string) ([]*irodsclient_fs.Entry, error)
+	StatCtx(ctx context.Context, path string) (*irodsclient_fs.Entry, error)
+	ExistsDirCtx(ctx context.Context, path string) bool
+	ExistsFileCtx(ctx context.Context, path string) bool
+	RemoveFileCtx(ctx context.Context, path string, force bool) error
+	RemoveDirCtx(ctx context.Context, path string, recurse bool, force bool) error
+	MakeDirCtx(ctx context.Context, path string, recurse bool) error
+	RenameDirToDirCtx(ctx context.Context, srcPath string, destPath string) error
+	RenameFileToFileCtx(ctx context.Context, srcPath string, destPath string) error
+	CreateFileCtx(ctx context.Context, path string, mode string) (IRODSFSFileHandle, error)
+	OpenFileCtx(ctx context.Context, path string, mode string) (IRODSFSFileHandle, error)
+	TruncateFileCtx(ctx context.Context, path string, size int64) error
+
+	// Resource and replica selection
+	CreateFileWithOptionsCtx(ctx context.Context, path string, mode string, options *OpenOptions) (IRODSFSFileHandle, error)
+	OpenFileWithOptionsCtx(ctx context.Context, path string, mode string, options *OpenOptions) (IRODSFSFileHandle, error)
+	ListReplicasCtx(ctx context.Context, path string) ([]*irodsclient_types.IRODSReplica, error)
+
+	// Symlinks and extended attributes
+	SymlinkCtx(ctx context.Context, target string, linkPath string) error
+	ReadlinkCtx(ctx context.Context, path string) (string, error)
+	GetXattrCtx(ctx context.Context, path string, name string) ([]byte, error)
+	SetXattrCtx(ctx context.Context, path string, name string, value []byte) error
+	ListXattrCtx(ctx context.Context, path string) ([]string, error)
+	RemoveXattrCtx(ctx context.Context, path string, name string) error
+
+	// Access control
+	GetACLCtx(ctx context.Context, path string) ([]*irodsclient_types.IRODSAccess, error)
+	SetACLCtx(ctx context.Context, path string, userName string, zoneName string, access irodsclient_types.IRODSAccessLevelType, recurse bool) error
+	SetInheritanceCtx(ctx context.Context, path string, inherit bool, recurse bool) error
+
+	// Server-side copy
+	CopyFileToFileCtx(ctx context.Context, srcPath string, destPath string, transferCallback irodsclient_common.TransferTrackerCallback) error
+	CopyDirToDirCtx(ctx context.Context, srcPath string, destPath string, transferCallback irodsclient_common.TransferTrackerCallback) error
+
+	// Sync
+	SyncCtx(ctx context.Context) error
+
+	// File Transfer
+	DownloadFileCtx(ctx context.Context, irodsPath string, localPath string, transferCallback irodsclient_common.TransferTrackerCallback) error
+	DownloadFileParallelCtx(ctx context.Context, irodsPath string, localPath string, taskNum int, transferCallback irodsclient_common.TransferTrackerCallback) error
+	DownloadFileWithCallbackCtx(ctx context.Context, irodsPath string, blockSize int, numBlocks int, blockReadyCallback irodsclient_common.DataObjectBlockCallback, transferCallback irodsclient_common.TransferTrackerCallback) error
+	DownloadFileParallelWithCallbackCtx(ctx context.Context, irodsPath string, blockSize int, numBlocks int, blockReadyCallback irodsclient_common.DataObjectBlockCallback, taskNum int, transferCallback irodsclient_common.TransferTrackerCallback) error
+	UploadFileCtx(ctx context.Context, localPath string, irodsPath string, transferCallback irodsclient_common.TransferTrackerCallback) error
+	UploadFileParallelCtx(ctx context.Context, localPath string, irodsPath string, taskNum int, transferCallback irodsclient_common.TransferTrackerCallback) error
+	DownloadFileWithOptionsCtx(ctx context.Context, irodsPath string, localPath string, options *TransferOptions) error
+	UploadFileWithOptionsCtx(ctx context.Context, localPath string, irodsPath string, options *TransferOptions) error
+
+	// Directory Transfer
+	UploadDirCtx(ctx context.Context, localDir string, irodsDir string, options *DirTransferOptions) (*DirTransferReport, error)
+	DownloadDirCtx(ctx context.Context, irodsDir string, localDir string, options *DirTransferOptions) (*DirTransferReport, error)
+
+	// Cache
+	CacheFileCtx(ctx context.Context, irodsPath string, transferCallback irodsclient_common.TransferTrackerCallback) error
+}
+
+// IRODSFSFileHandleCtx is an IRODSFSFileHandle with variants of its I/O operations that take a context.
+// ReadAtCtx returns ctx.Err() as soon as ctx is done. WriteAtCtx, TruncateCtx and FlushCtx only check
+// ctx before they start, a write that has started is not interrupted.
+// Handles returned by an IRODSFSClientCtx implement it; use WithContextHandle for any IRODSFSFileHandle.
+type IRODSFSFileHandleCtx interface {
+	IRODSFSFileHandle
+
+	ReadAtCtx(ctx context.Context, buffer []byte, offset int64) (int, error)
+	WriteAtCtx(ctx context.Context, data []byte, offset int64) (int, error)
+	TruncateCtx(ctx context.Context, size int64) error
+	FlushCtx(ctx context.Context) error
+}
@@ -0,0 +1,816 @@
+package irods
+
+import (
+	"context"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	irodsclient_fs "github.com/cyverse/go-irodsclient/fs"
+	irodsclient_common "github.com/cyverse/go-irodsclient/irods/common"
+	irodsclient_metrics "github.com/cyverse/go-irodsclient/irods/metrics"
+	irodsclient_types "github.com/cyverse/go-irodsclient/irods/types"
+	"github.com/cyverse/irodsfs-common/irods/stagingfs"
+	"github.com/rs/xid"
+	log "github.com/sirupsen/logrus"
+)
+
+// auditEventBufferSize is the number of staging events buffered for the audit client. Events that do not
+// fit are dropped by the staging filesystem, so it is large enough for bursts of syncs.
+const auditEventBufferSize = 4096
+
+// AuditOpEventsDropped is the Op of records noting staging events the audit client missed, whose syncs
+// are missing from the audit log
+const AuditOpEventsDropped = "EVENTS_DROPPED"
+
+// auditStagingClient is implemented by clients that stage changes, such as IRODSFSClientBuffered
+type auditStagingClient interface {
+	GetStagingFSFor(irodsPath string) *stagingfs.StagingFS
+	GetStagingFSs() []*stagingfs.StagingFS
+}
+
+// IRODSFSClientAudit is an IRODSFSClient that writes an AuditRecord for every call that changes iRODS,
+// every open of a file for writing and every close of such a file.
+//
+// If the client stages changes, calls whose change is staged are marked Staged, and the sync of the
+// change is recorded from the staging events with the IDs of those calls in RelatedIDs.
+type IRODSFSClientAudit struct {
+	id          string
+	client      IRODSFSClientCtx
+	sink        AuditSink
+	account     irodsclient_types.IRODSAccount
+	application string
+	staging     auditStagingClient // nil if the client does not stage changes
+	logger      *log.Entry
+
+	sinkMu        sync.Mutex          // Serializes writes to the sink
+	pendingMu     sync.Mutex          // Guards pending
+	pending       map[string][]string // IDs of records of staged changes by iRODS path, until they are synced
+	subscriptions []*stagingfs.StagingEventSubscription
+	watchers      sync.WaitGroup
+	failed        atomic.Int64 // Records the sink failed to write
+	dropped       atomic.Int64 // Staging events missed by the watchers
+}
+
+// NewIRODSFSClientAudit creates an IRODSFSClientAudit that writes the records of client to sink.
+// The sink is closed on Release.
+func NewIRODSFSClientAudit(client IRODSFSClient, sink AuditSink) (*IRODSFSClientAudit, error) {
+	if client == nil {
+		return nil, errors.New("client is required")
+	}
+	if sink == nil {
+		return nil, errors.New("audit sink is required")
+	}
+
+	clientID := xid.New().String()
+	auditClient := &IRODSFSClientAudit{
+		id:          clientID,
+		client:      WithContext(client),
+		sink:        sink,
+		application: client.GetApplicationName(),
+		logger: log.WithFields(log.Fields{
+			"fsclient_audit_id": clientID,
+		}),
+		pending: map[string][]string{},
+	}
+
+	// Read once, since staging syncs may still be recorded while the client is released
+	if account := client.GetAccount(); account != nil {
+		auditClient.account = *account
+	}
+
+	if staging, ok := client.(auditStagingClient); ok {
+		auditClient.staging = staging
+		for _, sf := range staging.GetStagingFSs() {
+			subscription := sf.Subscribe(auditEventBufferSize)
+			auditClient.subscriptions = append(auditClient.subscriptions, subscription)
+			auditClient.watchers.Add(1)
+			go auditClient.watch(subscription)
+		}
+	}
+	return auditClient, nil
+}
+
+// GetFailedRecords returns the number of records the sink failed to write
+func (c *IRODSFSClientAudit) GetFailedRecords() int64 {
+	return c.failed.Load()
+}
+
+// GetDroppedEvents returns the number of staging events the client missed. The syncs they reported are
+// missing from the audit log.
+func (c *IRODSFSClientAudit) GetDroppedEvents() int64 {
+	return c.dropped.Load()
+}
+
+// newRecord creates a record of op on p for the account of the client
+func (c *IRODSFSClientAudit) newRecord(op string, p string) *AuditRecord {
+	record := &AuditRecord{
+		ID:          xid.New().String(),
+		Time:        time.Now(),
+		User:        c.account.ClientUser,
+		Zone:        c.account.ClientZone,
+		Host:        c.account.Host,
+		Application: c.application,
+		Op:          op,
+		Path:        p,
+	}
+	if c.account.ProxyUser != c.account.ClientUser {
+		record.ProxyUser = c.account.ProxyUser
+	}
+	return record
+}
+
+// write writes a record to the sink
+func (c *IRODSFSClientAudit) write(record *AuditRecord) {
+	c.sinkMu.Lock()
+	defer c.sinkMu.Unlock()
+
+	if err := c.sink.Write(record); err != nil {
+		c.failed.Add(1)
+		c.logger.WithError(err).Errorf("failed to write audit record %s of %s %q", record.ID, record.Op, record.Path)
+	}
+}
+
+// finish completes the record of a call that ended with err and writes it
+func (c *IRODSFSClientAudit) finish(record *AuditRecord, err error) {
+	record.Duration = time.Since(record.Time)
+	record.setResult(err)
+
+	if err == nil {
+		c.pendingMu.Lock()
+		c.trackStaged(record)
+		c.pendingMu.Unlock()
+	}
+	c.write(record)
+}
+
+// audit runs op and records it
+func (c *IRODSFSClientAudit) audit(record *AuditRecord, op func() error) error {
+	err := op()
+	c.finish(record, err)
+	return err
+}
+
+// isStaged returns true if the change at p is staged and not yet synced
+func (c *IRODSFSClientAudit) isStaged(p string) bool {
+	if c.staging == nil {
+		return false
+	}
+
+	sf := c.staging.GetStagingFSFor(p)
+	return sf != nil && sf.Get(p) != nil
+}
+
+// movePending moves the pending records at and below srcPath to destPath, caller must hold pendingMu
+func (c *IRODSFSClientAudit) movePending(srcPath string, destPath string) {
+	for p, ids := range c.pending {
+		if p == srcPath || strings.HasPrefix(p, srcPath+"/") {
+			delete(c.pending, p)
+			moved := destPath + strings.TrimPrefix(p, srcPath)
+			c.pending[moved] = append(c.pending[moved], ids...)
+		}
+	}
+}
+
+// dropPending forgets the pending records at and below p, caller must hold pendingMu
+func (c *IRODSFSClientAudit) dropPending(p string) {
+	for pendingPath := range c.pending {
+		if pendingPath == p || strings.HasPrefix(pendingPath, p+"/") {
+			delete(c.pending, pendingPath)
+		}
+	}
+}
+
+// trackStaged marks a successful call as staged if its change waits for a sync, so that the sync
+// record refers to it, caller must hold pendingMu
+func (c *IRODSFSClientAudit) trackStaged(record *AuditRecord) {
+	if c.staging == nil {
+		return
+	}
+
+	changed := record.Path
+	if record.DestPath != "" {
+		changed = record.DestPath
+		if strings.HasPrefix(record.Op, "Rename") {
+			c.movePending(record.Path, record.DestPath)
+		}
+	}
+
+	if !c.isStaged(changed) {
+		// Synced by the call, or staged changes undone, e.g. by removing a file that was never synced
+		c.dropPending(changed)
+		return
+	}
+	record.Staged = true
+	c.pending[changed] = append(c.pending[changed], record.ID)
+}
+
+// watch records the syncs of a staging filesystem until its events end
+func (c *IRODSFSClientAudit) watch(subscription *stagingfs.StagingEventSubscription) {
+	defer c.watchers.Done()
+
+	dropped := uint64(0)
+	for event := range subscription.Events() {
+		dropped = c.checkDropped(subscription, dropped)
+		c.recordSync(event)
+	}
+	c.checkDropped(subscription, dropped)
+}
+
+// checkDropped records the staging events of subscription dropped since reported ones were counted, and
+// returns the new count
+func (c *IRODSFSClientAudit) checkDropped(subscription *stagingfs.StagingEventSubscription, reported uint64) uint64 {
+	count := subscription.GetDroppedCount()
+	if count <= reported {
+		return reported
+	}
+
+	missed := count - reported
+	c.dropped.Add(int64(missed))
+	c.logger.Errorf("missed %d staging events, syncs of staged changes are missing from the audit log", missed)
+
+	record := c.newRecord(AuditOpEventsDropped, "")
+	record.setResult(errors.Newf("missed %d staging events", missed))
+	c.write(record)
+	return count
+}
+
+// recordSync records the end of a sync of a staged change
+func (c *IRODSFSClientAudit) recordSync(event *stagingfs.StagingEvent) {
+	switch event.Type {
+	case stagingfs.EventSyncSucceeded, stagingfs.EventSyncFailed, stagingfs.EventMovedToFailed:
+	default:
+		return
+	}
+
+	meta := event.Metadata
+	if meta == nil {
+		return
+	}
+
+	record := c.newRecord(event.Type.String(), meta.Path)
+	if meta.OldPath != "" {
+		record.Path = meta.OldPath
+		record.DestPath = meta.Path
+	}
+	record.Time = event.Timestamp.Add(-event.Duration)
+	record.Duration = event.Duration
+	record.Action = meta.Action.String()
+	if meta.Action == stagingfs.ActionUpload {
+		record.BytesWritten = event.Bytes
+	}
+	record.setResult(event.Err)
+
+	c.pendingMu.Lock()
+	record.RelatedIDs = c.pending[meta.Path]
+	if event.Type != stagingfs.EventSyncFailed {
+		// Failed syncs are retried, until they succeed or the item is moved to the failed items
+		delete(c.pending, meta.Path)
+	}
+	c.pendingMu.Unlock()
+
+	c.write(record)
+}
+
+// Release releases the client, records the syncs of staged changes made on release, and closes the sink
+func (c *IRODSFSClientAudit) Release() {
+	c.client.Release()
+
+	for _, subscription := range c.subscriptions {
+		subscription.Close()
+	}
+	c.watchers.Wait()
+
+	if err := c.sink.Close(); err != nil {
+		c.logger.WithError(err).Error("failed to close audit sink")
+	}
+}
+
+func (c *IRODSFSClientAudit) GetAccount() *irodsclient_types.IRODSAccount {
+	return c.client.GetAccount()
+}
+
+func (c *IRODSFSClientAudit) GetApplicationName() string {
+	return c.client.GetApplicationName()
+}
+
+func (c *IRODSFSClientAudit) GetOpenConnections() int {
+	return c.client.GetOpenConnections()
+}
+
+func (c *IRODSFSClientAudit) GetMetrics() *irodsclient_metrics.IRODSMetrics {
+	return c.client.GetMetrics()
+}
+
+func (c *IRODSFSClientAudit) List(path string) ([]*irodsclient_fs.Entry, error) {
+	return c.ListCtx(context.Background(), path)
+}
+
+func (c *IRODSFSClientAudit) ListCtx(ctx context.Context, path string) ([]*irodsclient_fs.Entry, error) {
+	return c.client.ListCtx(ctx, path)
+}
+
+func (c *IRODSFSClientAudit) Stat(path string) (*irodsclient_fs.Entry, error) {
+	return c.StatCtx(context.Background(), path)
+}
+
+func (c *IRODSFSClientAudit) StatCtx(ctx context.Context, path string) (*irodsclient_fs.Entry, error) {
+	return c.client.StatCtx(ctx, path)
+}
+
+func (c *IRODSFSClientAudit) ExistsDir(path string) bool {
+	return c.ExistsDirCtx(context.Background(), path)
+}
+
+func (c *IRODSFSClientAudit) ExistsDirCtx(ctx context.Context, path string) bool {
+	return c.client.ExistsDirCtx(ctx, path)
+}
+
+func (c *IRODSFSClientAudit) ExistsFile(path string) bool {
+	return c.ExistsFileCtx(context.Background(), path)
+}
+
+func (c *IRODSFSClientAudit) ExistsFileCtx(ctx context.Context, path string) bool {
+	return c.client.ExistsFileCtx(ctx, path)
+}
+
+func (c *IRODSFSClientAudit) RemoveFile(path string, force bool) error {
+	return c.RemoveFileCtx(context.Background(), path, force)
+}
+
+func (c *IRODSFSClientAudit) RemoveFileCtx(ctx context.Context, path string, force bool) error {
+	return c.audit(c.newRecord("RemoveFile", path), func() error {
+		return c.client.RemoveFileCtx(ctx, path, force)
+	})
+}
+
+func (c *IRODSFSClientAudit) RemoveDir(path string, recurse bool, force bool) error {
+	return c.RemoveDirCtx(context.Background(), path, recurse, force)
+}
+
+func (c *IRODSFSClientAudit) RemoveDirCtx(ctx context.Context, path string, recurse bool, force bool) error {
+	return c.audit(c.newRecord("RemoveDir", path), func() error {
+		return c.client.RemoveDirCtx(ctx, path, recurse, force)
+	})
+}
+
+func (c *IRODSFSClientAudit) MakeDir(path string, recurse bool) error {
+	return c.MakeDirCtx(context.Background(), path, recurse)
+}
+
+func (c *IRODSFSClientAudit) MakeDirCtx(ctx context.Context, path string, recurse bool) error {
+	return c.audit(c.newRecord("MakeDir", path), func() error {
+		return c.client.MakeDirCtx(ctx, path, recurse)
+	})
+}
+
+func (c *IRODSFSClientAudit) RenameDirToDir(srcPath string, destPath string) error {
+	return c.RenameDirToDirCtx(context.Background(), srcPath, destPath)
+}
+
+func (c *IRODSFSClientAudit) RenameDirToDirCtx(ctx context.Context, srcPath string, destPath string) error {
+	record := c.newRecord("RenameDirToDir", srcPath)
+	record.DestPath = destPath
+	return c.audit(record, func() error {
+		return c.client.RenameDirToDirCtx(ctx, srcPath, destPath)
+	})
+}
+
+func (c *IRODSFSClientAudit) RenameFileToFile(srcPath string, destPath string) error {
+	return c.RenameFileToFileCtx(context.Background(), srcPath, destPath)
+}
+
+func (c *IRODSFSClientAudit) RenameFileToFileCtx(ctx context.Context, srcPath string, destPath string) error {
+	record := c.newRecord("RenameFileToFile", srcPath)
+	record.DestPath = destPath
+	return c.audit(record, func() error {
+		return c.client.RenameFileToFileCtx(ctx, srcPath, destPath)
+	})
+}
+
+// openForWrite records the open of a file for writing and wraps its handle to record its close
+func (c *IRODSFSClientAudit) openForWrite(op string, path string, mode string, open func() (IRODSFSFileHandle, error)) (IRODSFSFileHandle, error) {
+	record := c.newRecord(op, path)
+	record.Mode = mode
+
+	handle, err := open()
+	if err != nil {
+		c.finish(record, err)
+		return nil, err
+	}
+
+	record.HandleID = handle.GetID()
+	c.finish(record, nil)
+	return &IRODSFSClientAuditFileHandle{
+		client: c,
+		handle: handle,
+		openID: record.ID,
+	}, nil
+}
+
+func (c *IRODSFSClientAudit) CreateFile(path string, mode string) (IRODSFSFileHandle, error) {
+	return c.CreateFileCtx(context.Background(), path, mode)
+}
+
+func (c *IRODSFSClientAudit) CreateFileCtx(ctx context.Context, path string, mode string) (IRODSFSFileHandle, error) {
+	return c.openForWrite("CreateFile", path, mode, func() (IRODSFSFileHandle, error) {
+		return c.client.CreateFileCtx(ctx, path, mode)
+	})
+}
+
+func (c *IRODSFSClientAudit) OpenFile(path string, mode string) (IRODSFSFileHandle, error) {
+	return c.OpenFileCtx(context.Background(), path, mode)
+}
+
+// OpenFileCtx records opens for writing only
+func (c *IRODSFSClientAudit) OpenFileCtx(ctx context.Context, path string, mode string) (IRODSFSFileHandle, error) {
+	if !irodsclient_types.FileOpenMode(mode).IsWrite() {
+		return c.client.OpenFileCtx(ctx, path, mode)
+	}
+
+	return c.openForWrite("OpenFile", path, mode, func() (IRODSFSFileHandle, error) {
+		return c.client.OpenFileCtx(ctx, path, mode)
+	})
+}
+
+func (c *IRODSFSClientAudit) TruncateFile(path string, size int64) error {
+	return c.TruncateFileCtx(context.Background(), path, size)
+}
+
+func (c *IRODSFSClientAudit) TruncateFileCtx(ctx context.Context, path string, size int64) error {
+	return c.audit(c.newRecord("TruncateFile", path), func() error {
+		return c.client.TruncateFileCtx(ctx, path, size)
+	})
+}
+
+func (c *IRODSFSClientAudit) CreateFileWithOptions(path string, mode string, options *OpenOptions) (IRODSFSFileHandle, error) {
+	return c.CreateFileWithOptionsCtx(context.Background(), path, mode, options)
+}
+
+func (c *IRODSFSClientAudit) CreateFileWithOptionsCtx(ctx context.Context, path string, mode string, options *OpenOptions) (IRODSFSFileHandle, error) {
+	return c.openForWrite("CreateFileWithOptions", path, mode, func() (IRODSFSFileHandle, error) {
+		return c.client.CreateFileWithOptionsCtx(ctx, path, mode, options)
+	})
+}
+
+func (c *IRODSFSClientAudit) OpenFileWithOptions(path string, mode string, options *OpenOptions) (IRODSFSFileHandle, error) {
+	return c.OpenFileWithOptionsCtx(context.Background(), path, mode, options)
+}
+
+// OpenFileWithOptionsCtx records opens for writing only
+func (c *IRODSFSClientAudit) OpenFileWithOptionsCtx(ctx context.Context, path string, mode string, options *OpenOptions) (IRODSFSFileHandle, error) {
+	if !irodsclient_types.FileOpenMode(mode).IsWrite() {
+		return c.client.OpenFileWithOptionsCtx(ctx, path, mode, options)
+	}
+
+	return c.openForWrite("OpenFileWithOptions", path, mode, func() (IRODSFSFileHandle, error) {
+		return c.client.OpenFileWithOptionsCtx(ctx, path, mode, options)
+	})
+}
+
+func (c *IRODSFSClientAudit) ListReplicas(path string) ([]*irodsclient_types.IRODSReplica, error) {
+	return c.ListReplicasCtx(context.Background(), path)
+}
+
+func (c *IRODSFSClientAudit) ListReplicasCtx(ctx context.Context, path string) ([]*irodsclient_types.IRODSReplica, error) {
+	return c.client.ListReplicasCtx(ctx, path)
+}
+
+func (c *IRODSFSClientAudit) Symlink(target string, linkPath string) error {
+	return c.SymlinkCtx(context.Background(), target, linkPath)
+}
+
+func (c *IRODSFSClientAudit) SymlinkCtx(ctx context.Context, target string, linkPath string) error {
+	record := c.newRecord("Symlink", linkPath)
+	record.Detail = target
+	return c.audit(record, func() error {
+		return c.client.SymlinkCtx(ctx, target, linkPath)
+	})
+}
+
+func (c *IRODSFSClientAudit) Readlink(path string) (string, error) {
+	return c.ReadlinkCtx(context.Background(), path)
+}
+
+func (c *IRODSFSClientAudit) ReadlinkCtx(ctx context.Context, path string) (string, error) {
+	return c.client.ReadlinkCtx(ctx, path)
+}
+
+func (c *IRODSFSClientAudit) GetXattr(path string, name string) ([]byte, error) {
+	return c.GetXattrCtx(context.Background(), path, name)
+}
+
+func (c *IRODSFSClientAudit) GetXattrCtx(ctx context.Context, path string, name string) ([]byte, error) {
+	return c.client.GetXattrCtx(ctx, path, name)
+}
+
+func (c *IRODSFSClientAudit) SetXattr(path string, name string, value []byte) error {
+	return c.SetXattrCtx(context.Background(), path, name, value)
+}
+
+func (c *IRODSFSClientAudit) SetXattrCtx(ctx context.Context, path string, name string, value []byte) error {
+	record := c.newRecord("SetXattr", path)
+	record.Detail = name
+	return c.audit(record, func() error {
+		return c.client.SetXattrCtx(ctx, path, name, value)
+	})
+}
+
+func (c *IRODSFSClientAudit) ListXattr(path string) ([]string, error) {
+	return c.ListXattrCtx(context.Background(), path)
+}
+
+func (c *IRODSFSClientAudit) ListXattrCtx(ctx context.Context, path string) ([]string, error) {
+	return c.client.ListXattrCtx(ctx, path)
+}
+
+func (c *IRODSFSClientAudit) RemoveXattr(path string, name string) error {
+	return c.RemoveXattrCtx(context.Background(), path, name)
+}
+
+func (c *IRODSFSClientAudit) RemoveXattrCtx(ctx context.Context, path string, name string) error {
+	record := c.newRecord("RemoveXattr", path)
+	record.Detail = name
+	return c.audit(record, func() error {
+		return c.client.RemoveXattrCtx(ctx, path, name)
+	})
+}
+
+func (c *IRODSFSClientAudit) GetACL(path string) ([]*irodsclient_types.IRODSAccess, error) {
+	return c.GetACLCtx(context.Background(), path)
+}
+
+func (c *IRODSFSClientAudit) GetACLCtx(ctx context.Context, path string) ([]*irodsclient_types.IRODSAccess, error) {
+	return c.client.GetACLCtx(ctx, path)
+}
+
+func (c *IRODSFSClientAudit) SetACL(path string, userName string, zoneName string, access irodsclient_types.IRODSAccessLevelType, recurse bool) error {
+	return c.SetACLCtx(context.Background(), path, userName, zoneName, access, recurse)
+}
+
+func (c *IRODSFSClientAudit) SetACLCtx(ctx context.Context, path string, userName string, zoneName string, access irodsclient_types.IRODSAccessLevelType, recurse bool) error {
+	record := c.newRecord("SetACL", path)
+	record.Detail = userName + "#" + zoneName + ":" + string(access)
+	return c.audit(record, func() error {
+		return c.client.SetACLCtx(ctx, path, userName, zoneName, access, recurse)
+	})
+}
+
+func (c *IRODSFSClientAudit) SetInheritance(path string, inherit bool, recurse bool) error {
+	return c.SetInheritanceCtx(context.Background(), path, inherit, recurse)
+}
+
+func (c *IRODSFSClientAudit) SetInheritanceCtx(ctx context.Context, path string, inherit bool, recurse bool) error {
+	record := c.newRecord("SetInheritance", path)
+	record.Detail = "inherit"
+	if !inherit {
+		record.Detail = "noinherit"
+	}
+	return c.audit(record, func() error {
+		return c.client.SetInheritanceCtx(ctx, path, inherit, recurse)
+	})
+}
+
+func (c *IRODSFSClientAudit) CopyFileToFile(srcPath string, destPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.CopyFileToFileCtx(context.Background(), srcPath, destPath, transferCallback)
+}
+
+func (c *IRODSFSClientAudit) CopyFileToFileCtx(ctx context.Context, srcPath string, destPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	record := c.newRecord("CopyFileToFile", srcPath)
+	record.DestPath = destPath
+	return c.audit(record, func() error {
+		return c.client.CopyFileToFileCtx(ctx, srcPath, destPath, transferCallback)
+	})
+}
+
+func (c *IRODSFSClientAudit) CopyDirToDir(srcPath string, destPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.CopyDirToDirCtx(context.Background(), srcPath, destPath, transferCallback)
+}
+
+func (c *IRODSFSClientAudit) CopyDirToDirCtx(ctx context.Context, srcPath string, destPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	record := c.newRecord("CopyDirToDir", srcPath)
+	record.DestPath = destPath
+	return c.audit(record, func() error {
+		return c.client.CopyDirToDirCtx(ctx, srcPath, destPath, transferCallback)
+	})
+}
+
+func (c *IRODSFSClientAudit) Sync() error {
+	return c.SyncCtx(context.Background())
+}
+
+// SyncCtx is not recorded itself, the syncs of the staged changes it flushes are
+func (c *IRODSFSClientAudit) SyncCtx(ctx context.Context) error {
+	return c.client.SyncCtx(ctx)
+}
+
+func (c *IRODSFSClientAudit) DownloadFile(irodsPath string, localPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.DownloadFileCtx(context.Background(), irodsPath, localPath, transferCallback)
+}
+
+func (c *IRODSFSClientAudit) DownloadFileCtx(ctx context.Context, irodsPath string, localPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.client.DownloadFileCtx(ctx, irodsPath, localPath, transferCallback)
+}
+
+func (c *IRODSFSClientAudit) DownloadFileParallel(irodsPath string, localPath string, taskNum int, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.DownloadFileParallelCtx(context.Background(), irodsPath, localPath, taskNum, transferCallback)
+}
+
+func (c *IRODSFSClientAudit) DownloadFileParallelCtx(ctx context.Context, irodsPath string, localPath string, taskNum int, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.client.DownloadFileParallelCtx(ctx, irodsPath, localPath, taskNum, transferCallback)
+}
+
+func (c *IRODSFSClientAudit) DownloadFileWithCallback(irodsPath string, blockSize int, numBlocks int, blockReadyCallback irodsclient_common.DataObjectBlockCallback, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.DownloadFileWithCallbackCtx(context.Background(), irodsPath, blockSize, numBlocks, blockReadyCallback, transferCallback)
+}
+
+func (c *IRODSFSClientAudit) DownloadFileWithCallbackCtx(ctx context.Context, irodsPath string, blockSize int, numBlocks int, blockReadyCallback irodsclient_common.DataObjectBlockCallback, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.client.DownloadFileWithCallbackCtx(ctx, irodsPath, blockSize, numBlocks, blockReadyCallback, transferCallback)
+}
+
+func (c *IRODSFSClientAudit) DownloadFileParallelWithCallback(irodsPath string, blockSize int, numBlocks int, blockReadyCallback irodsclient_common.DataObjectBlockCallback, taskNum int, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.DownloadFileParallelWithCallbackCtx(context.Background(), irodsPath, blockSize, numBlocks, blockReadyCallback, taskNum, transferCallback)
+}
+
+func (c *IRODSFSClientAudit) DownloadFileParallelWithCallbackCtx(ctx context.Context, irodsPath string, blockSize int, numBlocks int, blockReadyCallback irodsclient_common.DataObjectBlockCallback, taskNum int, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.client.DownloadFileParallelWithCallbackCtx(ctx, irodsPath, blockSize, numBlocks, blockReadyCallback, taskNum, transferCallback)
+}
+
+// auditUpload runs an upload of the file at localPath and records it with the size of the file
+func (c *IRODSFSClientAudit) auditUpload(op string, localPath string, irodsPath string, upload func() error) error {
+	record := c.newRecord(op, irodsPath)
+	err := upload()
+	if err == nil {
+		if info, statErr := os.Stat(localPath); statErr == nil {
+			record.BytesWritten = info.Size()
+		}
+	}
+	c.finish(record, err)
+	return err
+}
+
+func (c *IRODSFSClientAudit) UploadFile(localPath string, irodsPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.UploadFileCtx(context.Background(), localPath, irodsPath, transferCallback)
+}
+
+func (c *IRODSFSClientAudit) UploadFileCtx(ctx context.Context, localPath string, irodsPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.auditUpload("UploadFile", localPath, irodsPath, func() error {
+		return c.client.UploadFileCtx(ctx, localPath, irodsPath, transferCallback)
+	})
+}
+
+func (c *IRODSFSClientAudit) UploadFileParallel(localPath string, irodsPath string, taskNum int, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.UploadFileParallelCtx(context.Background(), localPath, irodsPath, taskNum, transferCallback)
+}
+
+func (c *IRODSFSClientAudit) UploadFileParallelCtx(ctx context.Context, localPath string, irodsPath string, taskNum int, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.auditUpload("UploadFileParallel", localPath, irodsPath, func() error {
+		return c.client.UploadFileParallelCtx(ctx, localPath, irodsPath, taskNum, transferCallback)
+	})
+}
+
+func (c *IRODSFSClientAudit) DownloadFileWithOptions(irodsPath string, localPath string, options *TransferOptions) error {
+	return c.DownloadFileWithOptionsCtx(context.Background(), irodsPath, localPath, options)
+}
+
+func (c *IRODSFSClientAudit) DownloadFileWithOptionsCtx(ctx context.Context, irodsPath string, localPath string, options *TransferOptions) error {
+	return c.client.DownloadFileWithOptionsCtx(ctx, irodsPath, localPath, options)
+}
+
+func (c *IRODSFSClientAudit) UploadFileWithOptions(localPath string, irodsPath string, options *TransferOptions) error {
+	return c.UploadFileWithOptionsCtx(context.Background(), localPath, irodsPath, options)
+}
+
+func (c *IRODSFSClientAudit) UploadFileWithOptionsCtx(ctx context.Context, localPath string, irodsPath string, options *TransferOptions) error {
+	return c.auditUpload("UploadFileWithOptions", localPath, irodsPath, func() error {
+		return c.client.UploadFileWithOptionsCtx(ctx, localPath, irodsPath, options)
+	})
+}
+
+func (c *IRODSFSClientAudit) GetTransferManager() *TransferManager {
+	return c.client.GetTransferManager()
+}
+
+func (c *IRODSFSClientAudit) UploadDir(localDir string, irodsDir string, options *DirTransferOptions) (*DirTransferReport, error) {
+	return c.UploadDirCtx(context.Background(), localDir, irodsDir, options)
+}
+
+func (c *IRODSFSClientAudit) UploadDirCtx(ctx context.Context, localDir string, irodsDir string, options *DirTransferOptions) (*DirTransferReport, error) {
+	record := c.newRecord("UploadDir", irodsDir)
+	report, err := c.client.UploadDirCtx(ctx, localDir, irodsDir, options)
+	if report != nil {
+		record.BytesWritten = report.Bytes
+	}
+	c.finish(record, err)
+	return report, err
+}
+
+func (c *IRODSFSClientAudit) DownloadDir(irodsDir string, localDir string, options *DirTransferOptions) (*DirTransferReport, error) {
+	return c.DownloadDirCtx(context.Background(), irodsDir, localDir, options)
+}
+
+func (c *IRODSFSClientAudit) DownloadDirCtx(ctx context.Context, irodsDir string, localDir string, options *DirTransferOptions) (*DirTransferReport, error) {
+	return c.client.DownloadDirCtx(ctx, irodsDir, localDir, options)
+}
+
+func (c *IRODSFSClientAudit) CacheFile(irodsPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.CacheFileCtx(context.Background(), irodsPath, transferCallback)
+}
+
+func (c *IRODSFSClientAudit) CacheFileCtx(ctx context.Context, irodsPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.client.CacheFileCtx(ctx, irodsPath, transferCallback)
+}
+
+// IRODSFSClientAuditFileHandle is a file handle of an IRODSFSClientAudit opened for writing. It
+// counts the bytes written and records truncates and the close.
+type IRODSFSClientAuditFileHandle struct {
+	client  *IRODSFSClientAudit
+	handle  IRODSFSFileHandle
+	openID  string       // ID of the record of the open
+	written atomic.Int64 // Bytes written
+}
+
+// newHandleRecord creates a record of op on the handle
+func (h *IRODSFSClientAuditFileHandle) newHandleRecord(op string) *AuditRecord {
+	record := h.client.newRecord(op, h.handle.GetEntry().Path)
+	record.Mode = string(h.handle.GetOpenMode())
+	record.HandleID = h.handle.GetID()
+	record.RelatedIDs = []string{h.openID}
+	return record
+}
+
+func (h *IRODSFSClientAuditFileHandle) GetID() string {
+	return h.handle.GetID()
+}
+
+func (h *IRODSFSClientAuditFileHandle) GetEntry() *irodsclient_fs.Entry {
+	return h.handle.GetEntry()
+}
+
+func (h *IRODSFSClientAuditFileHandle) GetReplica() *irodsclient_types.IRODSReplica {
+	return h.handle.GetReplica()
+}
+
+func (h *IRODSFSClientAuditFileHandle) GetOpenMode() irodsclient_types.FileOpenMode {
+	return h.handle.GetOpenMode()
+}
+
+func (h *IRODSFSClientAuditFileHandle) IsReadMode() bool {
+	return h.handle.IsReadMode()
+}
+
+func (h *IRODSFSClientAuditFileHandle) IsWriteMode() bool {
+	return h.handle.IsWriteMode()
+}
+
+func (h *IRODSFSClientAuditFileHandle) ReadAt(buffer []byte, offset int64) (int, error) {
+	return h.ReadAtCtx(context.Background(), buffer, offset)
+}
+
+func (h *IRODSFSClientAuditFileHandle) ReadAtCtx(ctx context.Context, buffer []byte, offset int64) (int, error) {
+	return WithContextHandle(h.handle).ReadAtCtx(ctx, buffer, offset)
+}
+
+func (h *IRODSFSClientAuditFileHandle) GetAvailable(offset int64) int64 {
+	return h.handle.GetAvailable(offset)
+}
+
+func (h *IRODSFSClientAuditFileHandle) WriteAt(data []byte, offset int64) (int, error) {
+	return h.WriteAtCtx(context.Background(), data, offset)
+}
+
+func (h *IRODSFSClientAuditFileHandle) WriteAtCtx(ctx context.Context, data []byte, offset int64) (int, error) {
+	written, err := WithContextHandle(h.handle).WriteAtCtx(ctx, data, offset)
+	h.written.Add(int64(written))
+	return written, err
+}
+
+func (h *IRODSFSClientAuditFileHandle) Truncate(size int64) error {
+	return h.TruncateCtx(context.Background(), size)
+}
+
+func (h *IRODSFSClientAuditFileHandle) TruncateCtx(ctx context.Context, size int64) error {
+	return h.client.audit(h.newHandleRecord("Truncate"), func() error {
+		return WithContextHandle(h.handle).TruncateCtx(ctx, size)
+	})
+}
+
+func (h *IRODSFSClientAuditFileHandle) Flush() error {
+	return h.FlushCtx(context.Background())
+}
+
+func (h *IRODSFSClientAuditFileHandle) FlushCtx(ctx context.Context) error {
+	return WithContextHandle(h.handle).FlushCtx(ctx)
+}
+
+// Close records the close with the bytes written through the handle
+func (h *IRODSFSClientAuditFileHandle) Close() error {
+	record := h.newHandleRecord("Close")
+	err := h.handle.Close()
+	record.BytesWritten = h.written.Load()
+	h.client.finish(record, err)
+	return err
+}
@@ -0,0 +1,277 @@
+package irods_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	irodsclient_types "github.com/cyverse/go-irodsclient/irods/types"
+	"github.com/cyverse/irodsfs-common/irods"
+	"github.com/cyverse/irodsfs-common/irods/fake"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// auditRecorder collects audit records
+type auditRecorder struct {
+	mu      sync.Mutex
+	records []*irods.AuditRecord
+}
+
+func (r *auditRecorder) sink() irods.AuditFuncSink {
+	return func(record *irods.AuditRecord) error {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		r.records = append(r.records, record)
+		return nil
+	}
+}
+
+// byOp returns the records of op
+func (r *auditRecorder) byOp(op string) []*irods.AuditRecord {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	records := []*irods.AuditRecord{}
+	for _, record := range r.records {
+		if record.Op == op {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+// syslogBuffer is an AuditSyslogWriter writing to a buffer
+type syslogBuffer struct {
+	bytes.Buffer
+}
+
+func (b *syslogBuffer) Info(message string) error {
+	_, err := b.WriteString("info: " + message + "\n")
+	return err
+}
+
+func (b *syslogBuffer) Warning(message string) error {
+	_, err := b.WriteString("warning: " + message + "\n")
+	return err
+}
+
+func (b *syslogBuffer) Close() error {
+	return nil
+}
+
+func TestAuditClientRecords(t *testing.T) {
+	c, home := newFakeClient(t, nil, map[string]string{"file": "data"})
+	recorder := &auditRecorder{}
+	client, err := irods.NewIRODSFSClientAudit(c, recorder.sink())
+	require.NoError(t, err)
+
+	require.NoError(t, client.MakeDir(home+"/dir", false))
+	assert.Error(t, client.RemoveFile(home+"/missing", false))
+	require.NoError(t, client.RenameFileToFile(home+"/file", home+"/dir/file"))
+
+	handle, err := client.OpenFile(home+"/dir/file", "r")
+	require.NoError(t, err)
+	require.NoError(t, handle.Close())
+	assert.Empty(t, recorder.byOp("OpenFile"))
+
+	handle, err = client.OpenFile(home+"/dir/file", "r+")
+	require.NoError(t, err)
+	_, err = handle.WriteAt([]byte("hello"), 0)
+	require.NoError(t, err)
+	_, err = handle.WriteAt([]byte("!"), 5)
+	require.NoError(t, err)
+	require.NoError(t, handle.Close())
+	client.Release()
+
+	mkdir := recorder.byOp("MakeDir")
+	require.Len(t, mkdir, 1)
+	assert.Equal(t, fake.DefaultUser, mkdir[0].User)
+	assert.Equal(t, fake.DefaultZone, mkdir[0].Zone)
+	assert.Empty(t, mkdir[0].ProxyUser)
+	assert.Equal(t, "localhost", mkdir[0].Host)
+	assert.Equal(t, fake.DefaultApplicationName, mkdir[0].Application)
+	assert.Equal(t, home+"/dir", mkdir[0].Path)
+	assert.Equal(t, irods.AuditResultOK, mkdir[0].Result)
+	assert.False(t, mkdir[0].Staged)
+
+	remove := recorder.byOp("RemoveFile")
+	require.Len(t, remove, 1)
+	assert.Equal(t, irods.AuditResultError, remove[0].Result)
+	assert.Contains(t, remove[0].Error, "not found")
+
+	rename := recorder.byOp("RenameFileToFile")
+	require.Len(t, rename, 1)
+	assert.Equal(t, home+"/file", rename[0].Path)
+	assert.Equal(t, home+"/dir/file", rename[0].DestPath)
+
+	open := recorder.byOp("OpenFile")
+	require.Len(t, open, 1)
+	assert.Equal(t, "r+", open[0].Mode)
+	assert.NotEmpty(t, open[0].HandleID)
+
+	closed := recorder.byOp("Close")
+	require.Len(t, closed, 1)
+	assert.Equal(t, home+"/dir/file", closed[0].Path)
+	assert.Equal(t, int64(6), closed[0].BytesWritten)
+	assert.Equal(t, open[0].HandleID, closed[0].HandleID)
+	assert.Equal(t, []string{open[0].ID}, closed[0].RelatedIDs)
+}
+
+func TestAuditClientStagingSync(t *testing.T) {
+	c, home := newFakeClient(t, nil, nil)
+	buffered := newTestBufferedClient(t, c)
+
+	recorder := &auditRecorder{}
+	client, err := irods.NewIRODSFSClientAudit(buffered, recorder.sink())
+	require.NoError(t, err)
+
+	require.NoError(t, client.MakeDir(home+"/new", false))
+	handle, err := client.CreateFile(home+"/new/a.txt", string(irodsclient_types.FileOpenModeWriteOnly))
+	require.NoError(t, err)
+	_, err = handle.WriteAt([]byte("staged"), 0)
+	require.NoError(t, err)
+	require.NoError(t, handle.Close())
+	require.NoError(t, client.RenameFileToFile(home+"/new/a.txt", home+"/new/b.txt"))
+
+	// Nothing has reached iRODS yet
+	dirs, files := fakeTree(t, c)
+	assert.Empty(t, dirs)
+	assert.Empty(t, files)
+	for _, op := range []string{"MakeDir", "CreateFile", "Close", "RenameFileToFile"} {
+		records := recorder.byOp(op)
+		require.Len(t, records, 1, op)
+		assert.True(t, records[0].Staged, op)
+	}
+
+	require.NoError(t, client.Sync())
+	client.Release()
+
+	_, files = fakeTree(t, c)
+	assert.Equal(t, map[string]string{"new/b.txt": "staged"}, files)
+
+	synced := map[string]*irods.AuditRecord{}
+	for _, record := range recorder.byOp("SYNC_SUCCEEDED") {
+		synced[record.Action] = record
+	}
+	require.Contains(t, synced, "MKDIR")
+	assert.Equal(t, home+"/new", synced["MKDIR"].Path)
+	assert.Equal(t, []string{recorder.byOp("MakeDir")[0].ID}, synced["MKDIR"].RelatedIDs)
+
+	require.Contains(t, synced, "UPLOAD")
+	assert.Equal(t, home+"/new/b.txt", synced["UPLOAD"].Path)
+	assert.Equal(t, int64(6), synced["UPLOAD"].BytesWritten)
+	assert.Equal(t, []string{
+		recorder.byOp("CreateFile")[0].ID,
+		recorder.byOp("Close")[0].ID,
+		recorder.byOp("RenameFileToFile")[0].ID,
+	}, synced["UPLOAD"].RelatedIDs)
+	assert.Zero(t, irods.PendingAuditPaths(client))
+}
+
+func TestAuditClientBlockedSink(t *testing.T) {
+	c, home := newFakeClient(t, nil, nil)
+	buffered := newTestBufferedClient(t, c)
+
+	// The sink blocks on the first record until unblocked
+	writing := make(chan *irods.AuditRecord, 1)
+	unblock := make(chan struct{})
+	var once sync.Once
+	client, err := irods.NewIRODSFSClientAudit(buffered, irods.AuditFuncSink(func(record *irods.AuditRecord) error {
+		once.Do(func() {
+			writing <- record
+			<-unblock
+		})
+		return nil
+	}))
+	require.NoError(t, err)
+
+	done := make(chan error)
+	go func() {
+		done <- client.MakeDir(home+"/new", false)
+	}()
+
+	// Staged changes are tracked while the sink is busy writing their record
+	record := <-writing
+	assert.Equal(t, "MakeDir", record.Op)
+	assert.True(t, record.Staged)
+	assert.Equal(t, 1, irods.PendingAuditPaths(client))
+
+	close(unblock)
+	require.NoError(t, <-done)
+	client.Release()
+	assert.Zero(t, client.GetDroppedEvents())
+}
+
+func TestAuditClientContext(t *testing.T) {
+	c, home := newFakeClient(t, nil, map[string]string{"file": "data"})
+	faulty := newTestFaultyClient(t, c, &irods.FaultInjectionConfig{
+		Faults: []irods.Fault{
+			irods.LatencyFault("DownloadFile", time.Hour),
+			irods.LatencyFault("MakeDir", time.Hour),
+		},
+	})
+	recorder := &auditRecorder{}
+	client, err := irods.NewIRODSFSClientAudit(faulty, recorder.sink())
+	require.NoError(t, err)
+	defer client.Release()
+
+	// Cancellation reaches the wrapped client
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = client.DownloadFileWithOptionsCtx(ctx, home+"/file", filepath.Join(t.TempDir(), "file"), nil)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Minute)
+
+	// A cancelled change is recorded as failed
+	assert.ErrorIs(t, client.MakeDirCtx(ctx, home+"/dir", false), context.DeadlineExceeded)
+	mkdir := recorder.byOp("MakeDir")
+	require.Len(t, mkdir, 1)
+	assert.Equal(t, irods.AuditResultError, mkdir[0].Result)
+}
+
+func TestAuditSinks(t *testing.T) {
+	record := &irods.AuditRecord{
+		ID:           "id1",
+		Time:         time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+		User:         "rods",
+		Zone:         "zone",
+		Application:  "app",
+		Op:           "RenameFileToFile",
+		Path:         "/zone/a b",
+		DestPath:     "/zone/c",
+		BytesWritten: 3,
+		Result:       irods.AuditResultOK,
+		Duration:     time.Millisecond,
+	}
+
+	var buffer bytes.Buffer
+	jsonSink := irods.NewAuditJSONSink(&buffer)
+	require.NoError(t, jsonSink.Write(record))
+	require.NoError(t, jsonSink.Write(record))
+	require.NoError(t, jsonSink.Close())
+
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	require.Len(t, lines, 2)
+	decoded := &irods.AuditRecord{}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), decoded))
+	assert.Equal(t, record, decoded)
+
+	syslog := &syslogBuffer{}
+	syslogSink := irods.NewAuditSyslogSink(syslog)
+	require.NoError(t, syslogSink.Write(record))
+	failed := *record
+	failed.Result = irods.AuditResultError
+	failed.Error = irods.ErrNotFound.Error()
+	require.NoError(t, syslogSink.Write(&failed))
+	assert.Equal(t, `info: id=id1 time=2026-01-02T03:04:05Z user=rods zone=zone application="app" op=RenameFileToFile path="/zone/a b" dest_path="/zone/c" bytes_written=3 result=ok duration=1ms
+warning: id=id1 time=2026-01-02T03:04:05Z user=rods zone=zone application="app" op=RenameFileToFile path="/zone/a b" dest_path="/zone/c" bytes_written=3 result=error error="not found" duration=1ms
+`, syslog.String())
+}
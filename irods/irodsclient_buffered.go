@@ -1,10 +1,11 @@
 package irods
 
 import (
+	"context"
 	"io"
-	"os"
 	"path"
 	"strconv"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -29,23 +30,60 @@ type IRODSFSClientBufferedConfig struct {
 	MaxStagingDataSize int64                      // Max disk usage for staged data (0 = use default 10GB)
 	SyncInterval       time.Duration              // Background sync interval (default: 5s)
 	GracePeriod        time.Duration              // Grace period before sync (default: 10s)
-	UsePersistence     bool                       // Use BadgerDB for crash recovery
+	UsePersistence     bool                       // Persist staging state for crash recovery
 	OnSyncError        stagingfs.SyncErrorHandler // Optional error callback
+	CloseTimeout       time.Duration              // Max time to sync staged data on Release (0 = no limit)
+
+	ResumableUploadThreshold int64 // Staged files at least this large are uploaded in resumable parts (0 = default 256MB, <0 = disabled)
+	UploadPartSize           int64 // Size of each resumable upload part (0 = default 64MB)
+	UploadPartConcurrency    int   // Resumable upload parts uploaded concurrently (0 = default 1)
+
+	SyncBandwidthLimit    int64                  // Max bytes per second for background sync transfers (0 = unlimited)
+	SyncBandwidthBurst    int64                  // Bandwidth limit burst size in bytes (0 = one second of SyncBandwidthLimit)
+	SyncWindows           []stagingfs.SyncWindow // Times of day when large staged files are synced (empty = any time)
+	WindowedSyncThreshold int64                  // Staged files at least this large wait for a sync window (0 = default 64MB)
+
+	PriorityRules []stagingfs.PriorityRule // Path glob rules assigning sync priorities to staged files
+
+	AtomicReplace     bool   // Upload staged files to a temporary sibling and rename it over the destination after verification
+	AtomicTempPattern string // Temporary object name pattern with {name} and {id} placeholders (default: ".{name}.staging-{id}.tmp")
+
+	// Per path prefix staging policies, overriding the settings above for matching paths
+	StagingPolicies []StagingPolicy
+
+	// Creates the metadata store of a staging root when UsePersistence is set (nil = Badger in <root>/meta)
+	MetadataStoreFactory  func(rootPath string) (stagingfs.MetadataStore, error)
+	MetadataDurability    stagingfs.MetadataDurability // Strict (default) commits every staging state change, batched group-commits them
+	MetadataFlushInterval time.Duration                // Max delay of batched commits (0 = default 100ms)
+
+	ACLCacheTTL time.Duration // How long ACLs from GetACL are cached (0 = default 5s, <0 = disabled)
+
+	// Default resource and replica selection. Staged files are uploaded to OpenOptions.Resource.
+	OpenOptions OpenOptions
+
+	TransferOptions TransferOptions       // Defaults of downloads and uploads (BlockSize defaults to the cache block size)
+	Transfers       TransferManagerConfig // Queueing and bandwidth limits of downloads and uploads
 }
 
 // IRODSFSClientBuffered wraps IRODSFSClient with block-level read-through caching
 // and local staging for write/readwrite modes.
 type IRODSFSClientBuffered struct {
-	id      string
-	fs      *irodsclient_fs.FileSystem
-	client  *IRODSFSClientDirect
-	cache   *cache.MemoryCacheManager
-	helper  *util.FileBlockHelper
-	staging *stagingfs.StagingFS
-	logger  *log.Entry
+	id     string
+	fs     *irodsclient_fs.FileSystem // nil if not built on the direct client
+	client IRODSFSClientCtx           // iRODS operations not served by staging or the cache
+	direct *IRODSFSClientDirect       // Runs transfers if the client is the direct client, else nil
+	cache  *cache.MemoryCacheManager
+	helper *util.FileBlockHelper
+	router *stagingRouter
+	acls   *aclCache
+	config *IRODSFSClientBufferedConfig
+	logger *log.Entry
 
 	cacheHit  uint64
 	cacheMiss uint64
+
+	replicaMu      sync.Mutex
+	pinnedReplicas map[string]map[int64]int // Open handles per replica that reads of a path were pinned to, whose blocks may be cached
 }
 
 // NewIRODSFSClientBuffered creates a new IRODSFSClientBuffered with the given config.
@@ -61,61 +99,87 @@ func NewIRODSFSClientBuffered(fs *irodsclient_fs.FileSystem, cache *cache.Memory
 		return nil, errors.New("config is required")
 	}
 
-	blockSize := config.BlockSize
-	if blockSize <= 0 {
-		blockSize = 4 * 1024 * 1024
-	}
-
 	// Create direct client
-	client, err := NewIRODSFSClientDirect(fs)
+	client, err := NewIRODSFSClientDirectWithOptions(fs, &config.OpenOptions)
 	if err != nil {
 		return nil, err
 	}
 	directClient := client.(*IRODSFSClientDirect)
+	directClient.transfers = NewTransferManager(config.Transfers)
 
-	// Create staging filesystem (optional)
-	var staging *stagingfs.StagingFS
-	if config.StagingRootPath != "" {
-		stagingConfig := &stagingfs.StagingFSConfig{
-			LocalRootPath: config.StagingRootPath,
-			Client:        directClient,
-			MaxDataSize:   config.MaxStagingDataSize,
-			SyncInterval:  config.SyncInterval,
-			GracePeriod:   config.GracePeriod,
-			OnSyncError:   config.OnSyncError,
-		}
+	bufferedClient, err := newIRODSFSClientBuffered(directClient, directClient, directClient, cache, config, fs.GetLogger())
+	if err != nil {
+		directClient.Release()
+		return nil, err
+	}
+	bufferedClient.fs = fs
+	return bufferedClient, nil
+}
 
-		if config.UsePersistence {
-			staging, err = stagingfs.NewStagingFSWithPersistence(stagingConfig)
-		} else {
-			staging, err = stagingfs.NewStagingFS(stagingConfig)
-		}
-		if err != nil {
-			directClient.Release()
-			return nil, errors.Wrap(err, "failed to create staging filesystem")
-		}
+// NewIRODSFSClientBufferedWithClient creates a new IRODSFSClientBuffered that adds caching and staging
+// to client, e.g. a fake.Client in tests. Files are staged and synced through client, and its transfer
+// manager runs the transfers; config.OpenOptions and config.Transfers are not applied to client.
+// The cache is provided externally so it can be shared across multiple clients.
+func NewIRODSFSClientBufferedWithClient(client IRODSFSClient, cache *cache.MemoryCacheManager, config *IRODSFSClientBufferedConfig) (IRODSFSClient, error) {
+	if client == nil {
+		return nil, errors.New("client is required")
+	}
+	if cache == nil {
+		return nil, errors.New("cache is required")
+	}
+	if config == nil {
+		return nil, errors.New("config is required")
+	}
+
+	// Staging checks the client itself for the optional stagingfs client interfaces
+	return newIRODSFSClientBuffered(WithContext(client), nil, client, cache, config, log.NewEntry(log.StandardLogger()))
+}
+
+// newIRODSFSClientBuffered creates an IRODSFSClientBuffered over client, staging through stagingClient.
+// direct is client if it is the direct client, whose transfers then run in the transfer manager of
+// the buffered client.
+func newIRODSFSClientBuffered(client IRODSFSClientCtx, direct *IRODSFSClientDirect, stagingClient stagingfs.StagingClient, cache *cache.MemoryCacheManager, config *IRODSFSClientBufferedConfig, logger *log.Entry) (*IRODSFSClientBuffered, error) {
+	blockSize := config.BlockSize
+	if blockSize <= 0 {
+		blockSize = 4 * 1024 * 1024
+	}
+
+	// Create staging filesystems (optional)
+	router, err := newStagingRouter(config, stagingClient)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create staging filesystem")
 	}
 
 	clientID := xid.New().String()
-	logger := fs.GetLogger().WithFields(log.Fields{
-		"fsclient_buffered_id": clientID,
-	})
 
 	return &IRODSFSClientBuffered{
-		id:      clientID,
-		fs:      fs,
-		client:  directClient,
-		cache:   cache,
-		helper:  util.NewFileBlockHelper(blockSize),
-		staging: staging,
-		logger:  logger,
+		id:     clientID,
+		client: client,
+		direct: direct,
+		cache:  cache,
+		helper: util.NewFileBlockHelper(blockSize),
+		router: router,
+		acls:   newACLCache(config.ACLCacheTTL),
+		config: config,
+		logger: logger.WithFields(log.Fields{
+			"fsclient_buffered_id": clientID,
+		}),
 	}, nil
 }
 
 func (c *IRODSFSClientBuffered) Release() {
-	if c.staging != nil {
-		c.staging.Close()
-		c.staging = nil
+	if c.router != nil {
+		ctx := context.Background()
+		if c.config != nil && c.config.CloseTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, c.config.CloseTimeout)
+			defer cancel()
+		}
+
+		if err := c.router.close(ctx); err != nil {
+			c.logger.Warnf("failed to sync all staged data on release: %v", err)
+		}
+		c.router = nil
 	}
 
 	if c.client != nil {
@@ -125,7 +189,12 @@ func (c *IRODSFSClientBuffered) Release() {
 }
 
 func (c *IRODSFSClientBuffered) Sync() error {
-	if c.staging == nil {
+	return c.SyncCtx(context.Background())
+}
+
+func (c *IRODSFSClientBuffered) SyncCtx(ctx context.Context) error {
+	stagings := c.router.getStagings()
+	if len(stagings) == 0 {
 		return nil
 	}
 
@@ -135,8 +204,10 @@ func (c *IRODSFSClientBuffered) Sync() error {
 
 	logger.Info("syncing all staged data to iRODS")
 
-	if err := c.staging.SyncAll(); err != nil {
-		return errors.Wrap(err, "failed to sync staged data")
+	for _, staging := range stagings {
+		if err := staging.SyncAllCtx(ctx); err != nil {
+			return errors.Wrap(err, "failed to sync staged data")
+		}
 	}
 
 	c.cache.Clear(true)
@@ -154,11 +225,22 @@ func (c *IRODSFSClientBuffered) GetApplicationName() string {
 
 // GetFSClient returns iRODS fs client
 func (c *IRODSFSClientBuffered) GetFSClient() *irodsclient_fs.FileSystem {
-	return c.client.fs
+	return c.fs
 }
 
+// GetStagingFS returns the client-wide staging filesystem, or nil if staging is disabled
 func (c *IRODSFSClientBuffered) GetStagingFS() *stagingfs.StagingFS {
-	return c.staging
+	return c.router.getStaging("/")
+}
+
+// GetStagingFSFor returns the staging filesystem serving an iRODS path, or nil if staging is disabled for it
+func (c *IRODSFSClientBuffered) GetStagingFSFor(irodsPath string) *stagingfs.StagingFS {
+	return c.router.getStaging(irodsPath)
+}
+
+// GetStagingFSs returns all staging filesystems of the client
+func (c *IRODSFSClientBuffered) GetStagingFSs() []*stagingfs.StagingFS {
+	return c.router.getStagings()
 }
 
 func (c *IRODSFSClientBuffered) GetOpenConnections() int {
@@ -173,12 +255,21 @@ func (c *IRODSFSClientBuffered) GetMetrics() *irodsclient_metrics.IRODSMetrics {
 }
 
 func (c *IRODSFSClientBuffered) List(dirPath string) ([]*irodsclient_fs.Entry, error) {
-	entries, err := c.client.List(dirPath)
+	return c.ListCtx(context.Background(), dirPath)
+}
+
+func (c *IRODSFSClientBuffered) ListCtx(ctx context.Context, dirPath string) ([]*irodsclient_fs.Entry, error) {
+	entries, err := c.client.ListCtx(ctx, dirPath)
 	if err != nil {
+		if ctx.Err() != nil {
+			return nil, err
+		}
+
 		entries = []*irodsclient_fs.Entry{}
 	}
 
-	if c.staging == nil {
+	stagings := c.router.getStagings()
+	if len(stagings) == 0 {
 		if err != nil {
 			return nil, err
 		}
@@ -191,19 +282,26 @@ func (c *IRODSFSClientBuffered) List(dirPath string) ([]*irodsclient_fs.Entry, e
 		entryMap[e.Path] = e
 	}
 
-	// Apply staging state
-	allMeta := c.staging.GetAll()
-	for _, meta := range allMeta {
-		entryDir := path.Dir(meta.Path)
-		if entryDir != dirPath {
-			continue
-		}
+	// Apply staging state of every staging root
+	for _, staging := range stagings {
+		c.applyStagingToListing(staging, dirPath, entryMap)
+	}
 
+	result := make([]*irodsclient_fs.Entry, 0, len(entryMap))
+	for _, e := range entryMap {
+		result = append(result, e)
+	}
+	return result, nil
+}
+
+// applyStagingToListing overlays staged changes of a staging filesystem on the entries of dirPath
+func (c *IRODSFSClientBuffered) applyStagingToListing(staging *stagingfs.StagingFS, dirPath string, entryMap map[string]*irodsclient_fs.Entry) {
+	for _, meta := range staging.GetChildren(dirPath) {
 		switch meta.Action {
 		case stagingfs.ActionUpload:
 			if meta.IsNew {
 				// New file created locally — add to listing
-				size := c.staging.GetLocalFileSize(meta.Path)
+				size := staging.GetLocalFileSize(meta.Path)
 				if size < 0 {
 					size = 0
 				}
@@ -219,7 +317,7 @@ func (c *IRODSFSClientBuffered) List(dirPath string) ([]*irodsclient_fs.Entry, e
 			} else {
 				// Existing file modified — update size
 				if e, ok := entryMap[meta.Path]; ok {
-					size := c.staging.GetLocalFileSize(meta.Path)
+					size := staging.GetLocalFileSize(meta.Path)
 					if size >= 0 {
 						e.Size = size
 					}
@@ -283,40 +381,39 @@ func (c *IRODSFSClientBuffered) List(dirPath string) ([]*irodsclient_fs.Entry, e
 	}
 
 	// Also remove entries that were renamed away (OldPath in this dir)
-	for _, meta := range allMeta {
-		if meta.Action == stagingfs.ActionRename || meta.Action == stagingfs.ActionRenameDir {
-			if path.Dir(meta.OldPath) == dirPath {
-				delete(entryMap, meta.OldPath)
-			}
-		}
+	for _, oldPath := range staging.GetRenamedAway(dirPath) {
+		delete(entryMap, oldPath)
 	}
-
-	result := make([]*irodsclient_fs.Entry, 0, len(entryMap))
-	for _, e := range entryMap {
-		result = append(result, e)
-	}
-	return result, nil
 }
 
 func (c *IRODSFSClientBuffered) Stat(filePath string) (*irodsclient_fs.Entry, error) {
-	if c.staging != nil {
+	return c.StatCtx(context.Background(), filePath)
+}
+
+func (c *IRODSFSClientBuffered) StatCtx(ctx context.Context, filePath string) (*irodsclient_fs.Entry, error) {
+	if staging := c.router.getStaging(filePath); staging != nil {
 		// Check staging state first
-		meta := c.staging.Get(filePath)
+		meta := staging.Get(filePath)
 		if meta != nil {
 			switch meta.Action {
 			case stagingfs.ActionDelete, stagingfs.ActionRmdir:
-				return nil, errors.Errorf("file not found: %s", filePath)
+				return nil, newError("stat", filePath, ErrNotFound, nil)
 
 			case stagingfs.ActionUpload:
 				// Return entry with local file size
-				size := c.staging.GetLocalFileSize(filePath)
+				size := staging.GetLocalFileSize(filePath)
 				if size < 0 {
 					size = 0
 				}
 
 				if meta.IsNew {
+					entryType := irodsclient_fs.FileEntry
+					if meta.SymlinkTarget != "" {
+						entryType = SymlinkEntry
+					}
+
 					return &irodsclient_fs.Entry{
-						Type:       irodsclient_fs.FileEntry,
+						Type:       entryType,
 						Name:       path.Base(filePath),
 						Path:       filePath,
 						Size:       size,
@@ -327,7 +424,7 @@ func (c *IRODSFSClientBuffered) Stat(filePath string) (*irodsclient_fs.Entry, er
 				}
 
 				// Modified existing file — get base entry from iRODS, override size
-				entry, err := c.client.Stat(filePath)
+				entry, err := c.client.StatCtx(ctx, filePath)
 				if err != nil {
 					return nil, err
 				}
@@ -349,17 +446,21 @@ func (c *IRODSFSClientBuffered) Stat(filePath string) (*irodsclient_fs.Entry, er
 		}
 
 		// Check if this path was renamed away
-		if c.staging.IsRenamedFrom(filePath) {
-			return nil, errors.Errorf("file not found: %s", filePath)
+		if staging.IsRenamedFrom(filePath) {
+			return nil, newError("stat", filePath, ErrNotFound, nil)
 		}
 	}
 
-	return c.client.Stat(filePath)
+	return c.client.StatCtx(ctx, filePath)
 }
 
 func (c *IRODSFSClientBuffered) ExistsDir(dirPath string) bool {
-	if c.staging != nil {
-		meta := c.staging.Get(dirPath)
+	return c.ExistsDirCtx(context.Background(), dirPath)
+}
+
+func (c *IRODSFSClientBuffered) ExistsDirCtx(ctx context.Context, dirPath string) bool {
+	if staging := c.router.getStaging(dirPath); staging != nil {
+		meta := staging.Get(dirPath)
 		if meta != nil {
 			switch meta.Action {
 			case stagingfs.ActionRmdir:
@@ -368,16 +469,20 @@ func (c *IRODSFSClientBuffered) ExistsDir(dirPath string) bool {
 				return true
 			}
 		}
-		if c.staging.IsRenamedFrom(dirPath) {
+		if staging.IsRenamedFrom(dirPath) {
 			return false
 		}
 	}
-	return c.client.ExistsDir(dirPath)
+	return c.client.ExistsDirCtx(ctx, dirPath)
 }
 
 func (c *IRODSFSClientBuffered) ExistsFile(filePath string) bool {
-	if c.staging != nil {
-		meta := c.staging.Get(filePath)
+	return c.ExistsFileCtx(context.Background(), filePath)
+}
+
+func (c *IRODSFSClientBuffered) ExistsFileCtx(ctx context.Context, filePath string) bool {
+	if staging := c.router.getStaging(filePath); staging != nil {
+		meta := staging.Get(filePath)
 		if meta != nil {
 			switch meta.Action {
 			case stagingfs.ActionDelete:
@@ -386,57 +491,146 @@ func (c *IRODSFSClientBuffered) ExistsFile(filePath string) bool {
 				return true
 			}
 		}
-		if c.staging.IsRenamedFrom(filePath) {
+		if staging.IsRenamedFrom(filePath) {
 			return false
 		}
 	}
-	return c.client.ExistsFile(filePath)
+	return c.client.ExistsFileCtx(ctx, filePath)
 }
 
 func (c *IRODSFSClientBuffered) RemoveFile(irodsPath string, force bool) error {
-	if c.staging != nil {
-		if err := c.staging.Delete(irodsPath); err != nil {
-			return err
+	return c.RemoveFileCtx(context.Background(), irodsPath, force)
+}
+
+func (c *IRODSFSClientBuffered) RemoveFileCtx(ctx context.Context, irodsPath string, force bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	defer c.acls.invalidate(irodsPath, false)
+
+	if staging := c.router.getStaging(irodsPath); staging != nil {
+		if err := staging.Delete(irodsPath); err != nil {
+			return wrapStagingError("remove", irodsPath, err)
 		}
 		c.invalidateFileCacheBlocks(irodsPath)
-		return nil
+		return c.syncWriteThrough(ctx, irodsPath)
 	}
-	return c.client.RemoveFile(irodsPath, force)
+
+	// Invalidate while the size can still be read
+	c.invalidateFileCacheBlocks(irodsPath)
+	return c.client.RemoveFileCtx(ctx, irodsPath, force)
 }
 
 func (c *IRODSFSClientBuffered) RemoveDir(irodsPath string, recurse bool, force bool) error {
-	if c.staging != nil {
-		return c.staging.Rmdir(irodsPath)
+	return c.RemoveDirCtx(context.Background(), irodsPath, recurse, force)
+}
+
+func (c *IRODSFSClientBuffered) RemoveDirCtx(ctx context.Context, irodsPath string, recurse bool, force bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	defer c.acls.invalidate(irodsPath, true)
+
+	if staging := c.router.getStaging(irodsPath); staging != nil {
+		return wrapStagingError("rmdir", irodsPath, staging.Rmdir(irodsPath))
 	}
-	return c.client.RemoveDir(irodsPath, recurse, force)
+	return c.client.RemoveDirCtx(ctx, irodsPath, recurse, force)
 }
 
 func (c *IRODSFSClientBuffered) MakeDir(irodsPath string, recurse bool) error {
-	if c.staging != nil {
-		return c.staging.Mkdir(irodsPath)
+	return c.MakeDirCtx(context.Background(), irodsPath, recurse)
+}
+
+func (c *IRODSFSClientBuffered) MakeDirCtx(ctx context.Context, irodsPath string, recurse bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
 	}
-	return c.client.MakeDir(irodsPath, recurse)
+
+	if staging := c.router.getStaging(irodsPath); staging != nil {
+		if err := staging.Mkdir(irodsPath); err != nil {
+			return wrapStagingError("mkdir", irodsPath, err)
+		}
+		return c.syncWriteThrough(ctx, irodsPath)
+	}
+	return c.client.MakeDirCtx(ctx, irodsPath, recurse)
 }
 
 func (c *IRODSFSClientBuffered) RenameDirToDir(srcPath string, destPath string) error {
-	if c.staging != nil {
-		return c.staging.RenameDir(srcPath, destPath)
+	return c.RenameDirToDirCtx(context.Background(), srcPath, destPath)
+}
+
+func (c *IRODSFSClientBuffered) RenameDirToDirCtx(ctx context.Context, srcPath string, destPath string) error {
+	if err := ctx.Err(); err != nil {
+		return err
 	}
-	return c.client.RenameDirToDir(srcPath, destPath)
+
+	defer c.acls.invalidate(srcPath, true)
+	defer c.acls.invalidate(destPath, true)
+
+	if staging := c.getStagingForRename(srcPath, destPath); staging != nil {
+		if err := staging.RenameDir(srcPath, destPath); err != nil {
+			return wrapStagingError("rename", srcPath, err)
+		}
+		return c.syncWriteThrough(ctx, destPath)
+	}
+
+	// Source and destination are served differently: flush staged changes, then rename in iRODS
+	if err := c.syncStagedSubtrees(ctx, srcPath, destPath); err != nil {
+		return err
+	}
+	return c.client.RenameDirToDirCtx(ctx, srcPath, destPath)
 }
 
 func (c *IRODSFSClientBuffered) RenameFileToFile(srcPath string, destPath string) error {
-	if c.staging != nil {
-		if err := c.staging.Rename(srcPath, destPath); err != nil {
-			return err
+	return c.RenameFileToFileCtx(context.Background(), srcPath, destPath)
+}
+
+func (c *IRODSFSClientBuffered) RenameFileToFileCtx(ctx context.Context, srcPath string, destPath string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	defer c.acls.invalidate(srcPath, false)
+	defer c.acls.invalidate(destPath, false)
+
+	if staging := c.getStagingForRename(srcPath, destPath); staging != nil {
+		if err := staging.Rename(srcPath, destPath); err != nil {
+			return wrapStagingError("rename", srcPath, err)
 		}
 		c.invalidateFileCacheBlocks(srcPath)
-		return nil
+		c.invalidateFileCacheBlocks(destPath)
+		return c.syncWriteThrough(ctx, destPath)
+	}
+
+	// Source and destination are served differently: flush staged changes, then rename in iRODS
+	if err := c.syncStagedSubtrees(ctx, srcPath, destPath); err != nil {
+		return err
 	}
-	return c.client.RenameFileToFile(srcPath, destPath)
+	if err := c.client.RenameFileToFileCtx(ctx, srcPath, destPath); err != nil {
+		return err
+	}
+	c.invalidateFileCacheBlocks(srcPath)
+	c.invalidateFileCacheBlocks(destPath)
+	return nil
 }
 
 func (c *IRODSFSClientBuffered) CreateFile(path string, mode string) (IRODSFSFileHandle, error) {
+	return c.CreateFileWithOptionsCtx(context.Background(), path, mode, nil)
+}
+
+func (c *IRODSFSClientBuffered) CreateFileCtx(ctx context.Context, path string, mode string) (IRODSFSFileHandle, error) {
+	return c.CreateFileWithOptionsCtx(ctx, path, mode, nil)
+}
+
+func (c *IRODSFSClientBuffered) CreateFileWithOptions(path string, mode string, options *OpenOptions) (IRODSFSFileHandle, error) {
+	return c.CreateFileWithOptionsCtx(context.Background(), path, mode, options)
+}
+
+// CreateFileWithOptionsCtx creates a file. Files created in staging are uploaded to the default resource
+// of the client when synced, whatever options select.
+func (c *IRODSFSClientBuffered) CreateFileWithOptionsCtx(ctx context.Context, path string, mode string, options *OpenOptions) (IRODSFSFileHandle, error) {
 	logger := c.logger.WithFields(log.Fields{
 		"path": path,
 		"mode": mode,
@@ -444,6 +638,10 @@ func (c *IRODSFSClientBuffered) CreateFile(path string, mode string) (IRODSFSFil
 
 	defer util.StackTraceFromPanic(logger)
 
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	// Invalidate cache before creating (file may be overwritten)
 	if err := c.invalidateFileCacheBlocks(path); err != nil {
 		logger.Warnf("failed to invalidate cache before file creation: %v", err)
@@ -451,18 +649,20 @@ func (c *IRODSFSClientBuffered) CreateFile(path string, mode string) (IRODSFSFil
 
 	openMode := irodsclient_types.FileOpenMode(mode)
 
+	staging := c.router.getStaging(path)
+
 	// Use staging for write modes
-	if c.staging != nil && openMode.IsWrite() {
-		f, err := c.staging.OpenForWrite(path)
+	if staging != nil && openMode.IsWrite() {
+		f, err := staging.OpenForWrite(path)
 		if err != nil {
-			return nil, err
+			return nil, wrapStagingError("create", path, err)
 		}
 
 		// Truncate if mode requires it
 		if openMode.Truncate() {
 			if err := f.Truncate(0); err != nil {
 				f.Close()
-				return nil, err
+				return nil, wrapStagingError("create", path, err)
 			}
 		}
 
@@ -470,26 +670,29 @@ func (c *IRODSFSClientBuffered) CreateFile(path string, mode string) (IRODSFSFil
 	}
 
 	// Fallback to direct for non-staging
-	handle, err := c.client.CreateFile(path, mode)
+	handle, err := c.client.CreateFileWithOptionsCtx(ctx, path, mode, options)
 	if err != nil {
 		return nil, err
 	}
 
-	handleLogger := logger.WithFields(log.Fields{
-		"handle_id": handle.GetID(),
-	})
-
-	return &IRODSFSClientBufferedFileHandle{
-		client:    c,
-		handle:    handle,
-		cache:     c.cache,
-		irodsPath: path,
-		helper:    c.helper,
-		logger:    handleLogger,
-	}, nil
+	return c.newBufferedHandle(handle, path, logger), nil
 }
 
 func (c *IRODSFSClientBuffered) OpenFile(path string, mode string) (IRODSFSFileHandle, error) {
+	return c.OpenFileWithOptionsCtx(context.Background(), path, mode, nil)
+}
+
+func (c *IRODSFSClientBuffered) OpenFileCtx(ctx context.Context, path string, mode string) (IRODSFSFileHandle, error) {
+	return c.OpenFileWithOptionsCtx(ctx, path, mode, nil)
+}
+
+func (c *IRODSFSClientBuffered) OpenFileWithOptions(path string, mode string, options *OpenOptions) (IRODSFSFileHandle, error) {
+	return c.OpenFileWithOptionsCtx(context.Background(), path, mode, options)
+}
+
+// OpenFileWithOptionsCtx opens a file. Staged files are read and written locally whatever options select.
+// Blocks read from a pinned replica are cached apart from blocks read from any replica.
+func (c *IRODSFSClientBuffered) OpenFileWithOptionsCtx(ctx context.Context, path string, mode string, options *OpenOptions) (IRODSFSFileHandle, error) {
 	logger := c.logger.WithFields(log.Fields{
 		"path": path,
 		"mode": mode,
@@ -497,20 +700,26 @@ func (c *IRODSFSClientBuffered) OpenFile(path string, mode string) (IRODSFSFileH
 
 	defer util.StackTraceFromPanic(logger)
 
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	openMode := irodsclient_types.FileOpenMode(mode)
 
+	staging := c.router.getStaging(path)
+
 	// Use staging for write modes
-	if c.staging != nil && openMode.IsWrite() {
-		entry, err := c.Stat(path)
+	if staging != nil && openMode.IsWrite() {
+		entry, err := c.StatCtx(ctx, path)
 		if err != nil {
 			return nil, err
 		}
 
 		if openMode.IsRead() {
 			// Read+Write mode (r+, a+): download first, then allow read/write
-			f, err := c.staging.OpenForReadWrite(path)
+			f, err := staging.OpenForReadWrite(path)
 			if err != nil {
-				return nil, err
+				return nil, wrapStagingError("open", path, err)
 			}
 
 			// For append mode, caller handles seeking; local file has full content
@@ -520,35 +729,35 @@ func (c *IRODSFSClientBuffered) OpenFile(path string, mode string) (IRODSFSFileH
 		// Write-only mode (w, w+, a)
 		if openMode.Truncate() {
 			// w+ mode: no need to download, start fresh
-			f, err := c.staging.OpenForWrite(path)
+			f, err := staging.OpenForWrite(path)
 			if err != nil {
-				return nil, err
+				return nil, wrapStagingError("open", path, err)
 			}
 			if err := f.Truncate(0); err != nil {
 				f.Close()
-				return nil, err
+				return nil, wrapStagingError("open", path, err)
 			}
 			return newStagedHandle(c, f, path, openMode, entry), nil
 		}
 
 		// w, a modes: need existing content to avoid data loss
-		f, err := c.staging.OpenForReadWrite(path)
+		f, err := staging.OpenForReadWrite(path)
 		if err != nil {
-			return nil, err
+			return nil, wrapStagingError("open", path, err)
 		}
 		return newStagedHandle(c, f, path, openMode, entry), nil
 	}
 
 	// Read-only mode: check staging first
-	if c.staging != nil {
-		meta := c.staging.Get(path)
+	if staging != nil {
+		meta := staging.Get(path)
 		if meta != nil && meta.Action == stagingfs.ActionUpload {
-			f, err := c.staging.OpenForRead(path)
+			f, err := staging.OpenForRead(path)
 			if err != nil {
-				return nil, err
+				return nil, wrapStagingError("open", path, err)
 			}
 
-			entry, err := c.Stat(path)
+			entry, err := c.StatCtx(ctx, path)
 			if err != nil {
 				f.Close()
 				return nil, err
@@ -559,37 +768,172 @@ func (c *IRODSFSClientBuffered) OpenFile(path string, mode string) (IRODSFSFileH
 	}
 
 	// No staging or file not in staging: use cached read path
-	handle, err := c.client.OpenFile(path, mode)
+	handle, err := c.client.OpenFileWithOptionsCtx(ctx, path, mode, options)
 	if err != nil {
 		return nil, err
 	}
 
+	return c.newBufferedHandle(handle, path, logger), nil
+}
+
+// newBufferedHandle wraps a handle of the direct client to cache its reads
+func (c *IRODSFSClientBuffered) newBufferedHandle(handle IRODSFSFileHandle, path string, logger *log.Entry) *IRODSFSClientBufferedFileHandle {
 	handleLogger := logger.WithFields(log.Fields{
 		"handle_id": handle.GetID(),
 	})
 
+	replica := handle.GetReplica()
+	if replica != nil {
+		c.addPinnedReplica(path, replica.Number)
+	}
+
 	return &IRODSFSClientBufferedFileHandle{
 		client:    c,
 		handle:    handle,
 		cache:     c.cache,
 		irodsPath: path,
+		replica:   replica,
 		helper:    c.helper,
 		logger:    handleLogger,
-	}, nil
+	}
+}
+
+func (c *IRODSFSClientBuffered) ListReplicas(path string) ([]*irodsclient_types.IRODSReplica, error) {
+	return c.ListReplicasCtx(context.Background(), path)
+}
+
+func (c *IRODSFSClientBuffered) ListReplicasCtx(ctx context.Context, path string) ([]*irodsclient_types.IRODSReplica, error) {
+	_, meta, err := c.getPendingStaged("listreplicas", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if meta != nil && meta.IsNew {
+		// Not in iRODS yet
+		return []*irodsclient_types.IRODSReplica{}, nil
+	}
+	return c.client.ListReplicasCtx(ctx, path)
+}
+
+// addPinnedReplica records that a handle caches blocks of path read from a replica
+func (c *IRODSFSClientBuffered) addPinnedReplica(irodsPath string, replicaNumber int64) {
+	c.replicaMu.Lock()
+	defer c.replicaMu.Unlock()
+
+	if c.pinnedReplicas == nil {
+		c.pinnedReplicas = map[string]map[int64]int{}
+	}
+	if c.pinnedReplicas[irodsPath] == nil {
+		c.pinnedReplicas[irodsPath] = map[int64]int{}
+	}
+	c.pinnedReplicas[irodsPath][replicaNumber]++
+}
+
+// releasePinnedReplica records that a handle reading from a replica was closed. The replica stays
+// recorded until its cached blocks are invalidated.
+func (c *IRODSFSClientBuffered) releasePinnedReplica(irodsPath string, replicaNumber int64) {
+	c.replicaMu.Lock()
+	defer c.replicaMu.Unlock()
+
+	if handles, ok := c.pinnedReplicas[irodsPath][replicaNumber]; ok && handles > 0 {
+		c.pinnedReplicas[irodsPath][replicaNumber] = handles - 1
+	}
+}
+
+// dropPinnedReplicas forgets the replicas of path without open handles, once their blocks were invalidated
+func (c *IRODSFSClientBuffered) dropPinnedReplicas(irodsPath string) {
+	c.replicaMu.Lock()
+	defer c.replicaMu.Unlock()
+
+	for replicaNumber, handles := range c.pinnedReplicas[irodsPath] {
+		if handles == 0 {
+			delete(c.pinnedReplicas[irodsPath], replicaNumber)
+		}
+	}
+	if len(c.pinnedReplicas[irodsPath]) == 0 {
+		delete(c.pinnedReplicas, irodsPath)
+	}
+}
+
+// getPinnedReplicas returns the replicas whose blocks of path may be cached
+func (c *IRODSFSClientBuffered) getPinnedReplicas(irodsPath string) []int64 {
+	c.replicaMu.Lock()
+	defer c.replicaMu.Unlock()
+
+	replicas := []int64{}
+	for replicaNumber := range c.pinnedReplicas[irodsPath] {
+		replicas = append(replicas, replicaNumber)
+	}
+	return replicas
+}
+
+// deleteCachedBlock removes a cached block of a file, as read from any replica
+func (c *IRODSFSClientBuffered) deleteCachedBlock(cacheMgr *cache.MemoryCacheManager, irodsPath string, blockNum int64) {
+	cacheMgr.Delete(c.makeCacheKey(irodsPath, blockNum), false)
+	for _, replicaNumber := range c.getPinnedReplicas(irodsPath) {
+		cacheMgr.Delete(makeReplicaCacheKey(irodsPath, replicaNumber, blockNum), false)
+	}
 }
 
 func (c *IRODSFSClientBuffered) TruncateFile(path string, size int64) error {
-	if c.staging != nil {
-		meta := c.staging.Get(path)
+	return c.TruncateFileCtx(context.Background(), path, size)
+}
+
+func (c *IRODSFSClientBuffered) TruncateFileCtx(ctx context.Context, path string, size int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if staging := c.router.getStaging(path); staging != nil {
+		meta := staging.Get(path)
 		if meta != nil && meta.Action == stagingfs.ActionUpload {
-			return c.staging.TruncateFile(path, size)
+			if err := staging.TruncateFile(path, size); err != nil {
+				return wrapStagingError("truncate", path, err)
+			}
+			return c.syncWriteThrough(ctx, path)
 		}
 	}
-	return c.client.TruncateFile(path, size)
+	return c.client.TruncateFileCtx(ctx, path, size)
+}
+
+// GetTransferManager returns the manager that queues and tracks the transfers of the client
+func (c *IRODSFSClientBuffered) GetTransferManager() *TransferManager {
+	if c.direct != nil {
+		return c.direct.transfers
+	}
+	return c.client.GetTransferManager()
+}
+
+// transferDefaults returns the transfer options of the config, with blocks of the cache block size
+func (c *IRODSFSClientBuffered) transferDefaults() *TransferOptions {
+	defaults := c.config.TransferOptions
+	if defaults.BlockSize <= 0 {
+		defaults.BlockSize = c.helper.GetBlockSize()
+	}
+	return &defaults
+}
+
+// getStagedUpload returns the staging filesystem holding irodsPath if the file is staged locally
+func (c *IRODSFSClientBuffered) getStagedUpload(irodsPath string) *stagingfs.StagingFS {
+	staging := c.router.getStaging(irodsPath)
+	if staging == nil {
+		return nil
+	}
+
+	meta := staging.Get(irodsPath)
+	if meta != nil && meta.Action == stagingfs.ActionUpload {
+		return staging
+	}
+	return nil
 }
 
 // CacheFile downloads a file from iRODS into the block cache without writing to local disk
 func (c *IRODSFSClientBuffered) CacheFile(irodsPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.CacheFileCtx(context.Background(), irodsPath, transferCallback)
+}
+
+// CacheFileCtx downloads a file from iRODS into the block cache without writing to local disk
+func (c *IRODSFSClientBuffered) CacheFileCtx(ctx context.Context, irodsPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
 	logger := c.logger.WithFields(log.Fields{
 		"irodsPath": irodsPath,
 	})
@@ -597,24 +941,30 @@ func (c *IRODSFSClientBuffered) CacheFile(irodsPath string, transferCallback iro
 	defer util.StackTraceFromPanic(logger)
 
 	// skip if the file is staged locally (already on disk)
-	if c.staging != nil {
-		meta := c.staging.Get(irodsPath)
-		if meta != nil && meta.Action == stagingfs.ActionUpload {
-			return nil
-		}
+	if c.getStagedUpload(irodsPath) != nil {
+		return nil
 	}
 
 	// skip if all blocks are already cached
-	entry, err := c.client.Stat(irodsPath)
+	entry, err := c.client.StatCtx(ctx, irodsPath)
 	if err != nil {
 		return errors.Wrap(err, "failed to stat file for cache check")
 	}
 
+	// Downloads read from the replica the client pins reads to, if any
+	_, replica, err := readReplica(ctx, c.client, irodsPath, &c.config.OpenOptions, c.logger)
+	if err != nil {
+		return err
+	}
+	if replica != nil {
+		c.addPinnedReplica(irodsPath, replica.Number)
+	}
+
 	if entry.Size > 0 {
 		lastBlock := c.helper.GetLastBlockID(entry.Size)
 		allCached := true
 		for blockNum := int64(0); blockNum <= lastBlock; blockNum++ {
-			cacheKey := c.makeCacheKey(irodsPath, blockNum)
+			cacheKey := c.makeBlockCacheKey(irodsPath, replica, blockNum)
 			if !c.cache.Has(cacheKey) {
 				allCached = false
 				break
@@ -628,7 +978,7 @@ func (c *IRODSFSClientBuffered) CacheFile(irodsPath string, transferCallback iro
 	blockReadyCallback := func(data []byte, offset int64) error {
 		if len(data) > 0 {
 			blockNum := c.helper.GetBlockID(offset)
-			cacheKey := c.makeCacheKey(irodsPath, blockNum)
+			cacheKey := c.makeBlockCacheKey(irodsPath, replica, blockNum)
 			if _, err := c.cache.PutCopy(cacheKey, data, false); err != nil {
 				logger.Warnf("failed to cache block %d: %v", blockNum, err)
 			}
@@ -636,115 +986,117 @@ func (c *IRODSFSClientBuffered) CacheFile(irodsPath string, transferCallback iro
 		return nil
 	}
 
-	_, err = c.client.fs.DownloadFileParallelWithCallback(irodsPath, "", c.helper.GetBlockSize(), 3, blockReadyCallback, 4, transferCallback)
-	return err
+	// Blocks must line up with cache blocks
+	options := c.transferDefaults()
+	options.BlockSize = c.helper.GetBlockSize()
+	options.Resume = false
+	options.BlockReadyCallback = blockReadyCallback
+	options.TransferCallback = transferCallback
+	return c.client.DownloadFileWithOptionsCtx(ctx, irodsPath, "", options)
 }
 
 // DownloadFile downloads a file to a local path
 func (c *IRODSFSClientBuffered) DownloadFile(irodsPath string, localPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
-	logger := c.logger.WithFields(log.Fields{
-		"irodsPath": irodsPath,
-		"localPath": localPath,
+	return c.DownloadFileCtx(context.Background(), irodsPath, localPath, transferCallback)
+}
+
+// DownloadFileCtx downloads a file to a local path
+func (c *IRODSFSClientBuffered) DownloadFileCtx(ctx context.Context, irodsPath string, localPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.DownloadFileWithOptionsCtx(ctx, irodsPath, localPath, &TransferOptions{TransferCallback: transferCallback})
+}
+
+// DownloadFileParallel downloads a file in parallel to a local path
+func (c *IRODSFSClientBuffered) DownloadFileParallel(irodsPath string, localPath string, taskNum int, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.DownloadFileParallelCtx(context.Background(), irodsPath, localPath, taskNum, transferCallback)
+}
+
+// DownloadFileParallelCtx downloads a file in parallel to a local path
+func (c *IRODSFSClientBuffered) DownloadFileParallelCtx(ctx context.Context, irodsPath string, localPath string, taskNum int, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.DownloadFileWithOptionsCtx(ctx, irodsPath, localPath, &TransferOptions{
+		TaskNum:          taskNum,
+		TransferCallback: transferCallback,
 	})
+}
 
-	defer util.StackTraceFromPanic(logger)
+func (c *IRODSFSClientBuffered) DownloadFileWithCallback(irodsPath string, blockSize int, numBlocks int, blockReadyCallback irodsclient_common.DataObjectBlockCallback, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.DownloadFileWithCallbackCtx(context.Background(), irodsPath, blockSize, numBlocks, blockReadyCallback, transferCallback)
+}
 
-	f, err := os.Create(localPath)
-	if err != nil {
-		return errors.Wrap(err, "failed to create local file")
-	}
-	defer f.Close()
+func (c *IRODSFSClientBuffered) DownloadFileWithCallbackCtx(ctx context.Context, irodsPath string, blockSize int, numBlocks int, blockReadyCallback irodsclient_common.DataObjectBlockCallback, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.DownloadFileWithOptionsCtx(ctx, irodsPath, "", &TransferOptions{
+		BlockSize:          blockSize,
+		NumBlocks:          numBlocks,
+		BlockReadyCallback: blockReadyCallback,
+		TransferCallback:   transferCallback,
+	})
+}
 
-	writeCallback := func(data []byte, offset int64) error {
-		if len(data) > 0 {
-			if _, writeErr := f.WriteAt(data, offset); writeErr != nil {
-				return errors.Wrapf(writeErr, "failed to write block at offset %d", offset)
-			}
-		}
-		return nil
-	}
+func (c *IRODSFSClientBuffered) DownloadFileParallelWithCallback(irodsPath string, blockSize int, numBlocks int, blockReadyCallback irodsclient_common.DataObjectBlockCallback, taskNum int, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.DownloadFileParallelWithCallbackCtx(context.Background(), irodsPath, blockSize, numBlocks, blockReadyCallback, taskNum, transferCallback)
+}
 
-	// check if the file is staged locally
-	if c.staging != nil {
-		meta := c.staging.Get(irodsPath)
-		if meta != nil && meta.Action == stagingfs.ActionUpload {
-			return c.downloadFromStaging(irodsPath, c.helper.GetBlockSize(), writeCallback, transferCallback)
-		}
-	}
+func (c *IRODSFSClientBuffered) DownloadFileParallelWithCallbackCtx(ctx context.Context, irodsPath string, blockSize int, numBlocks int, blockReadyCallback irodsclient_common.DataObjectBlockCallback, taskNum int, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.DownloadFileWithOptionsCtx(ctx, irodsPath, "", &TransferOptions{
+		TaskNum:            taskNum,
+		BlockSize:          blockSize,
+		NumBlocks:          numBlocks,
+		BlockReadyCallback: blockReadyCallback,
+		TransferCallback:   transferCallback,
+	})
+}
 
-	_, err = c.client.fs.DownloadFileWithCallback(irodsPath, "", c.helper.GetBlockSize(), 3, writeCallback, transferCallback)
-	return err
+// DownloadFileWithOptions downloads a file to localPath, or to options.BlockReadyCallback if localPath
+// is empty
+func (c *IRODSFSClientBuffered) DownloadFileWithOptions(irodsPath string, localPath string, options *TransferOptions) error {
+	return c.DownloadFileWithOptionsCtx(context.Background(), irodsPath, localPath, options)
 }
 
-// DownloadFileParallel downloads a file in parallel to a local path
-func (c *IRODSFSClientBuffered) DownloadFileParallel(irodsPath string, localPath string, taskNum int, transferCallback irodsclient_common.TransferTrackerCallback) error {
+// DownloadFileWithOptionsCtx downloads a file to localPath, or to options.BlockReadyCallback if localPath
+// is empty. Files staged locally are read from their local bytes. Unset options are taken from
+// IRODSFSClientBufferedConfig.TransferOptions.
+func (c *IRODSFSClientBuffered) DownloadFileWithOptionsCtx(ctx context.Context, irodsPath string, localPath string, options *TransferOptions) error {
 	logger := c.logger.WithFields(log.Fields{
 		"irodsPath": irodsPath,
 		"localPath": localPath,
-		"taskNum":   taskNum,
 	})
 
 	defer util.StackTraceFromPanic(logger)
 
-	f, err := os.Create(localPath)
-	if err != nil {
-		return errors.Wrap(err, "failed to create local file")
+	options = options.withDefaults(c.transferDefaults())
+	if c.direct == nil && c.getStagedUpload(irodsPath) == nil {
+		// The client queues its transfers itself
+		return c.client.DownloadFileWithOptionsCtx(ctx, irodsPath, localPath, options)
 	}
-	defer f.Close()
 
-	writeCallback := func(data []byte, offset int64) error {
-		if len(data) > 0 {
-			if _, writeErr := f.WriteAt(data, offset); writeErr != nil {
-				return errors.Wrapf(writeErr, "failed to write block at offset %d", offset)
+	return c.GetTransferManager().runTransfer(ctx, TransferDownload, irodsPath, localPath, options, func(ctx context.Context, options *TransferOptions) error {
+		staging := c.getStagedUpload(irodsPath)
+		if staging == nil {
+			if c.direct == nil {
+				return c.client.DownloadFileWithOptionsCtx(ctx, irodsPath, localPath, options)
 			}
+			return c.direct.download(ctx, irodsPath, localPath, options)
 		}
-		return nil
-	}
 
-	// check if the file is staged locally
-	if c.staging != nil {
-		meta := c.staging.Get(irodsPath)
-		if meta != nil && meta.Action == stagingfs.ActionUpload {
-			return c.downloadFromStaging(irodsPath, c.helper.GetBlockSize(), writeCallback, transferCallback)
+		downloadStaged := func(blockReadyCallback irodsclient_common.DataObjectBlockCallback) error {
+			return c.downloadFromStaging(staging, irodsPath, options.BlockSize, contextBlockCallback(ctx, blockReadyCallback), options.TransferCallback)
 		}
-	}
-
-	_, err = c.client.fs.DownloadFileParallelWithCallback(irodsPath, "", c.helper.GetBlockSize(), taskNum*3, writeCallback, taskNum, transferCallback)
-	return err
-}
-
-func (c *IRODSFSClientBuffered) DownloadFileWithCallback(irodsPath string, blockSize int, numBlocks int, blockReadyCallback irodsclient_common.DataObjectBlockCallback, transferCallback irodsclient_common.TransferTrackerCallback) error {
-	logger := c.logger.WithFields(log.Fields{
-		"irodsPath": irodsPath,
-		"blockSize": blockSize,
-		"numBlocks": numBlocks,
-	})
-
-	defer util.StackTraceFromPanic(logger)
-
-	// check if the file is staged locally
-	if c.staging != nil {
-		meta := c.staging.Get(irodsPath)
-		if meta != nil && meta.Action == stagingfs.ActionUpload {
-			return c.downloadFromStaging(irodsPath, blockSize, blockReadyCallback, transferCallback)
+		if localPath == "" {
+			return downloadStaged(options.BlockReadyCallback)
 		}
-	}
-
-	_, err := c.fs.DownloadFileWithCallback(irodsPath, "", blockSize, numBlocks, blockReadyCallback, transferCallback)
-	return err
+		return downloadFileByBlocks(ctx, localPath, downloadStaged)
+	})
 }
 
-
-func (c *IRODSFSClientBuffered) downloadFromStaging(irodsPath string, blockSize int, blockReadyCallback irodsclient_common.DataObjectBlockCallback, transferCallback irodsclient_common.TransferTrackerCallback) error {
-	f, err := c.staging.OpenForRead(irodsPath)
+func (c *IRODSFSClientBuffered) downloadFromStaging(staging *stagingfs.StagingFS, irodsPath string, blockSize int, blockReadyCallback irodsclient_common.DataObjectBlockCallback, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	f, err := staging.OpenForRead(irodsPath)
 	if err != nil {
-		return errors.Wrapf(err, "failed to open staged file %q", irodsPath)
+		return wrapStagingError("download", irodsPath, errors.Wrapf(err, "failed to open staged file %q", irodsPath))
 	}
 	defer f.Close()
 
 	info, err := f.Stat()
 	if err != nil {
-		return errors.Wrapf(err, "failed to stat staged file %q", irodsPath)
+		return wrapStagingError("download", irodsPath, errors.Wrapf(err, "failed to stat staged file %q", irodsPath))
 	}
 	fileSize := info.Size()
 
@@ -767,7 +1119,7 @@ func (c *IRODSFSClientBuffered) downloadFromStaging(irodsPath string, blockSize
 			break
 		}
 		if readErr != nil {
-			return errors.Wrapf(readErr, "failed to read staged file %q", irodsPath)
+			return wrapStagingError("download", irodsPath, errors.Wrapf(readErr, "failed to read staged file %q", irodsPath))
 		}
 		offset += int64(n)
 	}
@@ -775,62 +1127,52 @@ func (c *IRODSFSClientBuffered) downloadFromStaging(irodsPath string, blockSize
 	return nil
 }
 
-func (c *IRODSFSClientBuffered) DownloadFileParallelWithCallback(irodsPath string, blockSize int, numBlocks int, blockReadyCallback irodsclient_common.DataObjectBlockCallback, taskNum int, transferCallback irodsclient_common.TransferTrackerCallback) error {
-	logger := c.logger.WithFields(log.Fields{
-		"irodsPath": irodsPath,
-		"blockSize": blockSize,
-		"numBlocks": numBlocks,
-		"taskNum":   taskNum,
-	})
-
-	defer util.StackTraceFromPanic(logger)
-
-	// check if the file is staged locally
-	if c.staging != nil {
-		meta := c.staging.Get(irodsPath)
-		if meta != nil && meta.Action == stagingfs.ActionUpload {
-			return c.downloadFromStaging(irodsPath, blockSize, blockReadyCallback, transferCallback)
-		}
-	}
-
-	_, err := c.fs.DownloadFileParallelWithCallback(irodsPath, "", blockSize, numBlocks, blockReadyCallback, taskNum, transferCallback)
-	return err
-}
-
 // UploadFile uploads a file and invalidates cache based on server file size
 func (c *IRODSFSClientBuffered) UploadFile(localPath string, irodsPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
-	logger := c.logger.WithFields(log.Fields{
-		"localPath": localPath,
-		"irodsPath": irodsPath,
-	})
+	return c.UploadFileCtx(context.Background(), localPath, irodsPath, transferCallback)
+}
 
-	defer util.StackTraceFromPanic(logger)
+// UploadFileCtx uploads a file and invalidates cache based on server file size
+func (c *IRODSFSClientBuffered) UploadFileCtx(ctx context.Context, localPath string, irodsPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.UploadFileWithOptionsCtx(ctx, localPath, irodsPath, &TransferOptions{TransferCallback: transferCallback})
+}
 
-	// Upload file
-	if err := c.client.UploadFile(localPath, irodsPath, transferCallback); err != nil {
-		return err
-	}
+// UploadFileParallel uploads a file in parallel and invalidates cache based on server file size
+func (c *IRODSFSClientBuffered) UploadFileParallel(localPath string, irodsPath string, taskNum int, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.UploadFileParallelCtx(context.Background(), localPath, irodsPath, taskNum, transferCallback)
+}
 
-	// Invalidate cache based on server file size after upload
-	if err := c.invalidateFileCacheBlocks(irodsPath); err != nil {
-		logger.Warnf("failed to invalidate cache after upload: %v", err)
-	}
+// UploadFileParallelCtx uploads a file in parallel and invalidates cache based on server file size
+func (c *IRODSFSClientBuffered) UploadFileParallelCtx(ctx context.Context, localPath string, irodsPath string, taskNum int, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.UploadFileWithOptionsCtx(ctx, localPath, irodsPath, &TransferOptions{TaskNum: taskNum, TransferCallback: transferCallback})
+}
 
-	return nil
+// UploadFileWithOptions uploads a file and invalidates cache based on server file size
+func (c *IRODSFSClientBuffered) UploadFileWithOptions(localPath string, irodsPath string, options *TransferOptions) error {
+	return c.UploadFileWithOptionsCtx(context.Background(), localPath, irodsPath, options)
 }
 
-// UploadFileParallel uploads a file in parallel and invalidates cache based on server file size
-func (c *IRODSFSClientBuffered) UploadFileParallel(localPath string, irodsPath string, taskNum int, transferCallback irodsclient_common.TransferTrackerCallback) error {
+// UploadFileWithOptionsCtx uploads a file and invalidates cache based on server file size. Unset options
+// are taken from IRODSFSClientBufferedConfig.TransferOptions.
+func (c *IRODSFSClientBuffered) UploadFileWithOptionsCtx(ctx context.Context, localPath string, irodsPath string, options *TransferOptions) error {
 	logger := c.logger.WithFields(log.Fields{
 		"localPath": localPath,
 		"irodsPath": irodsPath,
-		"taskNum":   taskNum,
 	})
 
 	defer util.StackTraceFromPanic(logger)
 
-	// Upload file in parallel
-	if err := c.client.UploadFileParallel(localPath, irodsPath, taskNum, transferCallback); err != nil {
+	options = options.withDefaults(c.transferDefaults())
+	var err error
+	if c.direct == nil {
+		// The client queues its transfers itself
+		err = c.client.UploadFileWithOptionsCtx(ctx, localPath, irodsPath, options)
+	} else {
+		err = c.direct.transfers.runTransfer(ctx, TransferUpload, irodsPath, localPath, options, func(ctx context.Context, options *TransferOptions) error {
+			return c.direct.upload(ctx, localPath, irodsPath, options)
+		})
+	}
+	if err != nil {
 		return err
 	}
 
@@ -847,6 +1189,14 @@ func (c *IRODSFSClientBuffered) makeCacheKey(irodsPath string, blockNum int64) s
 	return "irods:block:" + irodsPath + ":" + strconv.FormatInt(blockNum, 10)
 }
 
+// makeBlockCacheKey creates a cache key for a block read from replica, or from any replica if nil
+func (c *IRODSFSClientBuffered) makeBlockCacheKey(irodsPath string, replica *irodsclient_types.IRODSReplica, blockNum int64) string {
+	if replica != nil {
+		return makeReplicaCacheKey(irodsPath, replica.Number, blockNum)
+	}
+	return c.makeCacheKey(irodsPath, blockNum)
+}
+
 // invalidateFileCacheBlocks removes all cached blocks for a file
 func (c *IRODSFSClientBuffered) invalidateFileCacheBlocks(irodsPath string) error {
 	logger := c.logger.WithFields(log.Fields{
@@ -858,8 +1208,8 @@ func (c *IRODSFSClientBuffered) invalidateFileCacheBlocks(irodsPath string) erro
 	var fileSize int64
 
 	// Check both staging and iRODS, use the larger value to ensure all blocks are invalidated
-	if c.staging != nil {
-		localSize := c.staging.GetLocalFileSize(irodsPath)
+	if staging := c.router.getStaging(irodsPath); staging != nil {
+		localSize := staging.GetLocalFileSize(irodsPath)
 		if localSize > fileSize {
 			fileSize = localSize
 		}
@@ -878,10 +1228,10 @@ func (c *IRODSFSClientBuffered) invalidateFileCacheBlocks(irodsPath string) erro
 	lastBlockID := c.helper.GetLastBlockID(fileSize)
 
 	for blockNum := int64(0); blockNum <= lastBlockID; blockNum++ {
-		cacheKey := c.makeCacheKey(irodsPath, blockNum)
-		c.cache.Delete(cacheKey, false)
+		c.deleteCachedBlock(c.cache, irodsPath, blockNum)
 	}
 
+	c.dropPinnedReplicas(irodsPath)
 	return nil
 }
 
@@ -891,6 +1241,7 @@ type IRODSFSClientBufferedFileHandle struct {
 	handle    IRODSFSFileHandle
 	cache     *cache.MemoryCacheManager
 	irodsPath string
+	replica   *irodsclient_types.IRODSReplica // Replica reads are pinned to, nil if the server chooses
 	helper    *util.FileBlockHelper
 	logger    *log.Entry
 }
@@ -903,6 +1254,10 @@ func (h *IRODSFSClientBufferedFileHandle) GetEntry() *irodsclient_fs.Entry {
 	return h.handle.GetEntry()
 }
 
+func (h *IRODSFSClientBufferedFileHandle) GetReplica() *irodsclient_types.IRODSReplica {
+	return h.replica
+}
+
 func (h *IRODSFSClientBufferedFileHandle) GetOpenMode() irodsclient_types.FileOpenMode {
 	return h.handle.GetOpenMode()
 }
@@ -959,8 +1314,14 @@ func (h *IRODSFSClientBufferedFileHandle) GetAvailable(offset int64) int64 {
 // ReadAt reads from cache if available, otherwise reads full blocks from the
 // underlying handle and caches them. Handles cross-block reads correctly.
 func (h *IRODSFSClientBufferedFileHandle) ReadAt(buffer []byte, offset int64) (int, error) {
+	return h.ReadAtCtx(context.Background(), buffer, offset)
+}
+
+// ReadAtCtx reads from cache if available, otherwise reads full blocks from the
+// underlying handle and caches them. Handles cross-block reads correctly.
+func (h *IRODSFSClientBufferedFileHandle) ReadAtCtx(ctx context.Context, buffer []byte, offset int64) (int, error) {
 	if !h.handle.IsReadMode() {
-		return 0, errors.Errorf("file is opened with %q mode", h.handle.GetOpenMode())
+		return 0, ErrNotReadMode
 	}
 
 	defer util.StackTraceFromPanic(h.logger)
@@ -1016,7 +1377,7 @@ func (h *IRODSFSClientBufferedFileHandle) ReadAt(buffer []byte, offset int64) (i
 		// Cache miss — read the full block from underlying handle
 		atomic.AddUint64(&h.client.cacheMiss, 1)
 		blockBuf := make([]byte, blockDataLen)
-		n, err := h.handle.ReadAt(blockBuf, blockStart)
+		n, err := WithContextHandle(h.handle).ReadAtCtx(ctx, blockBuf, blockStart)
 		if err != nil && err != io.EOF {
 			if totalCopied > 0 {
 				return totalCopied, nil
@@ -1058,9 +1419,14 @@ func (h *IRODSFSClientBufferedFileHandle) ReadAt(buffer []byte, offset int64) (i
 
 // WriteAt writes to underlying handle and invalidates affected cache blocks
 func (h *IRODSFSClientBufferedFileHandle) WriteAt(data []byte, offset int64) (int, error) {
+	return h.WriteAtCtx(context.Background(), data, offset)
+}
+
+// WriteAtCtx writes to underlying handle and invalidates affected cache blocks
+func (h *IRODSFSClientBufferedFileHandle) WriteAtCtx(ctx context.Context, data []byte, offset int64) (int, error) {
 	defer util.StackTraceFromPanic(h.logger)
 
-	n, err := h.handle.WriteAt(data, offset)
+	n, err := WithContextHandle(h.handle).WriteAtCtx(ctx, data, offset)
 	if err != nil {
 		return n, err
 	}
@@ -1070,17 +1436,20 @@ func (h *IRODSFSClientBufferedFileHandle) WriteAt(data []byte, offset int64) (in
 	endBlock++ // Include the end block
 
 	for blockNum := startBlock; blockNum < endBlock; blockNum++ {
-		cacheKey := h.makeCacheKey(blockNum)
-		h.cache.Delete(cacheKey, false)
+		h.client.deleteCachedBlock(h.cache, h.irodsPath, blockNum)
 	}
 
 	return n, nil
 }
 
 func (h *IRODSFSClientBufferedFileHandle) Truncate(size int64) error {
+	return h.TruncateCtx(context.Background(), size)
+}
+
+func (h *IRODSFSClientBufferedFileHandle) TruncateCtx(ctx context.Context, size int64) error {
 	defer util.StackTraceFromPanic(h.logger)
 
-	err := h.handle.Truncate(size)
+	err := WithContextHandle(h.handle).TruncateCtx(ctx, size)
 	if err != nil {
 		return err
 	}
@@ -1088,21 +1457,27 @@ func (h *IRODSFSClientBufferedFileHandle) Truncate(size int64) error {
 	// Invalidate all cache blocks after truncation
 	lastBlockID := h.helper.GetLastBlockID(size)
 	for blockNum := int64(0); blockNum <= lastBlockID; blockNum++ {
-		cacheKey := h.makeCacheKey(blockNum)
-		h.cache.Delete(cacheKey, false)
+		h.client.deleteCachedBlock(h.cache, h.irodsPath, blockNum)
 	}
 
 	return nil
 }
 
 func (h *IRODSFSClientBufferedFileHandle) Flush() error {
-	return h.handle.Flush()
+	return h.FlushCtx(context.Background())
+}
+
+func (h *IRODSFSClientBufferedFileHandle) FlushCtx(ctx context.Context) error {
+	return WithContextHandle(h.handle).FlushCtx(ctx)
 }
 
 func (h *IRODSFSClientBufferedFileHandle) Close() error {
+	if h.replica != nil {
+		h.client.releasePinnedReplica(h.irodsPath, h.replica.Number)
+	}
 	return h.handle.Close()
 }
 
 func (h *IRODSFSClientBufferedFileHandle) makeCacheKey(blockNum int64) string {
-	return "irods:block:" + h.irodsPath + ":" + strconv.FormatInt(blockNum, 10)
+	return h.client.makeBlockCacheKey(h.irodsPath, h.replica, blockNum)
 }
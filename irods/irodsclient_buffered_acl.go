@@ -0,0 +1,210 @@
+package irods
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	irodsclient_types "github.com/cyverse/go-irodsclient/irods/types"
+)
+
+// defaultACLCacheTTL is how long the buffered client caches ACLs unless configured
+const defaultACLCacheTTL = 5 * time.Second
+
+// maxACLCacheEntries bounds the ACL cache; expired entries are dropped when it is full
+const maxACLCacheEntries = 100000
+
+type aclCacheEntry struct {
+	accesses  []*irodsclient_types.IRODSAccess
+	expiresAt time.Time
+}
+
+// aclCache caches ACLs by path, because FUSE getattr needs them for every entry it shows.
+// A nil *aclCache caches nothing.
+type aclCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]*aclCacheEntry
+}
+
+// newACLCache returns an ACL cache with the given TTL (0 = default, <0 = disabled)
+func newACLCache(ttl time.Duration) *aclCache {
+	if ttl < 0 {
+		return nil
+	}
+	if ttl == 0 {
+		ttl = defaultACLCacheTTL
+	}
+
+	return &aclCache{
+		ttl:     ttl,
+		entries: map[string]*aclCacheEntry{},
+	}
+}
+
+func (ac *aclCache) get(p string) ([]*irodsclient_types.IRODSAccess, bool) {
+	if ac == nil {
+		return nil, false
+	}
+
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+
+	entry, ok := ac.entries[p]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expiresAt) {
+		delete(ac.entries, p)
+		return nil, false
+	}
+	return append([]*irodsclient_types.IRODSAccess{}, entry.accesses...), true
+}
+
+func (ac *aclCache) put(p string, accesses []*irodsclient_types.IRODSAccess) {
+	if ac == nil {
+		return
+	}
+
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+
+	now := time.Now()
+	if len(ac.entries) >= maxACLCacheEntries {
+		for key, entry := range ac.entries {
+			if now.After(entry.expiresAt) {
+				delete(ac.entries, key)
+			}
+		}
+		if len(ac.entries) >= maxACLCacheEntries {
+			ac.entries = map[string]*aclCacheEntry{}
+		}
+	}
+
+	ac.entries[p] = &aclCacheEntry{
+		accesses:  append([]*irodsclient_types.IRODSAccess{}, accesses...),
+		expiresAt: now.Add(ac.ttl),
+	}
+}
+
+// invalidate drops the cached ACL of p, and of everything below p if subtree is set
+func (ac *aclCache) invalidate(p string, subtree bool) {
+	if ac == nil {
+		return
+	}
+
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+
+	delete(ac.entries, p)
+	if !subtree {
+		return
+	}
+
+	prefix := strings.TrimSuffix(p, "/") + "/"
+	for key := range ac.entries {
+		if strings.HasPrefix(key, prefix) {
+			delete(ac.entries, key)
+		}
+	}
+}
+
+func (c *IRODSFSClientBuffered) GetACL(path string) ([]*irodsclient_types.IRODSAccess, error) {
+	return c.GetACLCtx(context.Background(), path)
+}
+
+func (c *IRODSFSClientBuffered) GetACLCtx(ctx context.Context, path string) ([]*irodsclient_types.IRODSAccess, error) {
+	_, meta, err := c.getPendingStaged("getacl", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if meta != nil && meta.IsNew {
+		// Not in iRODS yet; the client user will own it like any object it creates
+		account := c.GetAccount()
+		return []*irodsclient_types.IRODSAccess{
+			{
+				Path:        path,
+				UserName:    account.ClientUser,
+				UserZone:    account.ClientZone,
+				UserType:    irodsclient_types.IRODSUserRodsUser,
+				AccessLevel: irodsclient_types.IRODSAccessLevelOwner,
+			},
+		}, nil
+	}
+
+	if accesses, ok := c.acls.get(path); ok {
+		return accesses, nil
+	}
+
+	accesses, err := c.client.GetACLCtx(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	c.acls.put(path, accesses)
+	return accesses, nil
+}
+
+func (c *IRODSFSClientBuffered) SetACL(path string, userName string, zoneName string, access irodsclient_types.IRODSAccessLevelType, recurse bool) error {
+	return c.SetACLCtx(context.Background(), path, userName, zoneName, access, recurse)
+}
+
+func (c *IRODSFSClientBuffered) SetACLCtx(ctx context.Context, path string, userName string, zoneName string, access irodsclient_types.IRODSAccessLevelType, recurse bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := c.syncForACL(ctx, "setacl", path, recurse); err != nil {
+		return err
+	}
+
+	defer c.acls.invalidate(path, recurse)
+
+	if err := c.client.SetACLCtx(ctx, path, userName, zoneName, access, recurse); err != nil {
+		return err
+	}
+
+	// Cached blocks must not be served once read access is revoked
+	if accessLevelRank(access) < accessLevelRank(irodsclient_types.IRODSAccessLevelReadObject) {
+		if recurse {
+			c.cache.Clear(false)
+		} else {
+			c.invalidateFileCacheBlocks(path)
+		}
+	}
+	return nil
+}
+
+func (c *IRODSFSClientBuffered) SetInheritance(path string, inherit bool, recurse bool) error {
+	return c.SetInheritanceCtx(context.Background(), path, inherit, recurse)
+}
+
+func (c *IRODSFSClientBuffered) SetInheritanceCtx(ctx context.Context, path string, inherit bool, recurse bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := c.syncForACL(ctx, "setinheritance", path, recurse); err != nil {
+		return err
+	}
+
+	defer c.acls.invalidate(path, recurse)
+
+	return c.client.SetInheritanceCtx(ctx, path, inherit, recurse)
+}
+
+// syncForACL syncs what is staged at path, and below it if recurse is set, so that the ACL change
+// applies to it in iRODS
+func (c *IRODSFSClientBuffered) syncForACL(ctx context.Context, op string, path string, recurse bool) error {
+	_, meta, err := c.getPendingStaged(op, path)
+	if err != nil {
+		return err
+	}
+
+	if recurse || (meta != nil && meta.IsNew) {
+		return c.syncStagedSubtrees(ctx, path)
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package irods
+
+import (
+	"context"
+	"io"
+	"path"
+
+	"github.com/cockroachdb/errors"
+	irodsclient_common "github.com/cyverse/go-irodsclient/irods/common"
+	"github.com/cyverse/irodsfs-common/irods/stagingfs"
+)
+
+func (c *IRODSFSClientBuffered) CopyFileToFile(srcPath string, destPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.CopyFileToFileCtx(context.Background(), srcPath, destPath, transferCallback)
+}
+
+// CopyFileToFileCtx copies a file. Files staged and not yet synced are copied from their local bytes,
+// other files with an iRODS server-side copy after flushing staged changes in the way.
+func (c *IRODSFSClientBuffered) CopyFileToFileCtx(ctx context.Context, srcPath string, destPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := validateCopyPaths(srcPath, destPath); err != nil {
+		return err
+	}
+
+	staging, meta, err := c.getPendingStaged("copy", srcPath)
+	if err != nil {
+		return err
+	}
+
+	defer c.acls.invalidate(destPath, false)
+
+	if meta != nil && meta.Action == stagingfs.ActionUpload {
+		if meta.SymlinkTarget != "" {
+			if err := c.SymlinkCtx(ctx, meta.SymlinkTarget, destPath); err != nil {
+				return err
+			}
+			if transferCallback != nil {
+				size := int64(len(meta.SymlinkTarget))
+				transferCallback(CopyTaskName, size, size)
+			}
+			return nil
+		}
+		return c.copyFromStaging(ctx, staging, srcPath, destPath, transferCallback)
+	}
+
+	// iRODS must have the source, and staged changes of the destination must not be applied over the copy
+	if staging != nil && staging.Get(srcPath) != nil {
+		if err := c.syncStagedSubtrees(ctx, srcPath); err != nil {
+			return err
+		}
+	}
+	if err := c.syncCopyDestination(ctx, destPath); err != nil {
+		return err
+	}
+
+	c.invalidateFileCacheBlocks(destPath)
+	return c.client.CopyFileToFileCtx(ctx, srcPath, destPath, transferCallback)
+}
+
+// syncCopyDestination syncs staged changes of destPath and its staged new parent directories, so that
+// a server-side copy can create destPath
+func (c *IRODSFSClientBuffered) syncCopyDestination(ctx context.Context, destPath string) error {
+	staging := c.router.getStaging(destPath)
+	if staging == nil {
+		return nil
+	}
+
+	if staging.IsRenamedFrom(destPath) {
+		// The rename must take the current data object away first
+		if err := staging.SyncAllCtx(ctx); err != nil {
+			return wrapStagingError("sync", destPath, errors.Wrapf(err, "failed to sync staged changes before copying to %q", destPath))
+		}
+		return nil
+	}
+
+	syncPath := ""
+	if staging.Get(destPath) != nil {
+		syncPath = destPath
+	}
+	for dir := path.Dir(destPath); dir != "/" && dir != "."; dir = path.Dir(dir) {
+		dirMeta := staging.Get(dir)
+		if dirMeta == nil || dirMeta.Action != stagingfs.ActionMkdir || !dirMeta.IsNew {
+			break
+		}
+		syncPath = dir
+	}
+
+	if syncPath == "" {
+		return nil
+	}
+	return c.syncStagedSubtrees(ctx, syncPath)
+}
+
+// copyFromStaging copies the local bytes of a staged file, staging them at destPath if it is staged
+// or uploading them otherwise
+func (c *IRODSFSClientBuffered) copyFromStaging(ctx context.Context, staging *stagingfs.StagingFS, srcPath string, destPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	destStaging := c.router.getStaging(destPath)
+	if destStaging == nil {
+		if err := c.syncCopyDestination(ctx, destPath); err != nil {
+			return err
+		}
+		c.invalidateFileCacheBlocks(destPath)
+		return c.client.UploadFileCtx(ctx, staging.GetLocalDataPath(srcPath), destPath, transferCallback)
+	}
+
+	src, err := staging.OpenForRead(srcPath)
+	if err != nil {
+		return wrapStagingError("copy", srcPath, errors.Wrapf(err, "failed to open staged file %q", srcPath))
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return wrapStagingError("copy", srcPath, errors.Wrapf(err, "failed to stat staged file %q", srcPath))
+	}
+	size := info.Size()
+
+	c.invalidateFileCacheBlocks(destPath)
+
+	dest, err := destStaging.OpenForWrite(destPath)
+	if err != nil {
+		return wrapStagingError("copy", destPath, err)
+	}
+
+	if err := copyStagedData(ctx, dest, src, size, c.helper.GetBlockSize(), transferCallback); err != nil {
+		dest.Close()
+		return wrapStagingError("copy", destPath, errors.Wrapf(err, "failed to copy staged file %q", srcPath))
+	}
+
+	if err := dest.Close(); err != nil {
+		return wrapStagingError("copy", destPath, err)
+	}
+	return c.syncWriteThrough(ctx, destPath)
+}
+
+// copyStagedData replaces the content of dest with the first size bytes of src, in blocks
+func copyStagedData(ctx context.Context, dest io.WriterAt, src io.ReaderAt, size int64, blockSize int, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	if truncater, ok := dest.(interface{ Truncate(int64) error }); ok {
+		if err := truncater.Truncate(0); err != nil {
+			return err
+		}
+	}
+
+	buf := make([]byte, blockSize)
+	for offset := int64(0); offset < size; {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		n, readErr := src.ReadAt(buf, offset)
+		if n > 0 {
+			if _, err := dest.WriteAt(buf[:n], offset); err != nil {
+				return err
+			}
+			offset += int64(n)
+			if transferCallback != nil {
+				transferCallback(CopyTaskName, offset, size)
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+
+	if size == 0 && transferCallback != nil {
+		transferCallback(CopyTaskName, 0, 0)
+	}
+	return nil
+}
+
+func (c *IRODSFSClientBuffered) CopyDirToDir(srcPath string, destPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.CopyDirToDirCtx(context.Background(), srcPath, destPath, transferCallback)
+}
+
+// CopyDirToDirCtx copies a directory recursively, file by file with CopyFileToFileCtx, so that staged
+// directories and files are copied as they are seen through the client
+func (c *IRODSFSClientBuffered) CopyDirToDirCtx(ctx context.Context, srcPath string, destPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	defer c.acls.invalidate(destPath, true)
+
+	return copyDir(ctx, c, srcPath, destPath, transferCallback)
+}
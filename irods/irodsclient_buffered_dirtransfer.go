@@ -0,0 +1,46 @@
+package irods
+
+import (
+	"context"
+)
+
+// bufferedDirUploadClient creates the directories of directory uploads in iRODS, where the uploaded files go
+type bufferedDirUploadClient struct {
+	*IRODSFSClientBuffered
+}
+
+func (c bufferedDirUploadClient) MakeDirCtx(ctx context.Context, irodsPath string, recurse bool) error {
+	return c.client.MakeDirCtx(ctx, irodsPath, recurse)
+}
+
+// UploadDir uploads a local directory recursively to iRODS
+func (c *IRODSFSClientBuffered) UploadDir(localDir string, irodsDir string, options *DirTransferOptions) (*DirTransferReport, error) {
+	return c.UploadDirCtx(context.Background(), localDir, irodsDir, options)
+}
+
+// UploadDirCtx uploads a local directory recursively to iRODS. Staged changes below irodsDir are synced
+// first, so that the upload is compared with and applied over what the client shows.
+func (c *IRODSFSClientBuffered) UploadDirCtx(ctx context.Context, localDir string, irodsDir string, options *DirTransferOptions) (*DirTransferReport, error) {
+	if options == nil || !options.DryRun {
+		if err := c.syncCopyDestination(ctx, irodsDir); err != nil {
+			return nil, err
+		}
+		if err := c.syncStagedSubtrees(ctx, irodsDir); err != nil {
+			return nil, err
+		}
+		defer c.acls.invalidate(irodsDir, true)
+	}
+
+	return uploadDir(ctx, bufferedDirUploadClient{c}, localDir, irodsDir, options)
+}
+
+// DownloadDir downloads an iRODS collection recursively to a local directory
+func (c *IRODSFSClientBuffered) DownloadDir(irodsDir string, localDir string, options *DirTransferOptions) (*DirTransferReport, error) {
+	return c.DownloadDirCtx(context.Background(), irodsDir, localDir, options)
+}
+
+// DownloadDirCtx downloads an iRODS collection recursively to a local directory, including staged
+// changes not yet synced
+func (c *IRODSFSClientBuffered) DownloadDirCtx(ctx context.Context, irodsDir string, localDir string, options *DirTransferOptions) (*DirTransferReport, error) {
+	return downloadDir(ctx, c, irodsDir, localDir, options)
+}
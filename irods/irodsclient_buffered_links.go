@@ -0,0 +1,213 @@
+package irods
+
+import (
+	"context"
+	"sort"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cyverse/irodsfs-common/irods/stagingfs"
+)
+
+// getPendingStaged returns the staging filesystem of p and the pending upload or mkdir staged at p,
+// e.g. to serve the symlink target and extended attributes not yet synced to iRODS.
+// The metadata is nil if iRODS has the current state of p. Paths deleted or renamed away in staging
+// are not found.
+func (c *IRODSFSClientBuffered) getPendingStaged(op string, p string) (*stagingfs.StagingFS, *stagingfs.StagingMetadata, error) {
+	staging := c.router.getStaging(p)
+	if staging == nil {
+		return nil, nil, nil
+	}
+
+	meta := staging.Get(p)
+	if meta != nil {
+		switch meta.Action {
+		case stagingfs.ActionDelete, stagingfs.ActionRmdir:
+			return staging, nil, newError(op, p, ErrNotFound, nil)
+		case stagingfs.ActionUpload, stagingfs.ActionMkdir:
+			return staging, meta, nil
+		}
+	}
+
+	if staging.IsRenamedFrom(p) {
+		return staging, nil, newError(op, p, ErrNotFound, nil)
+	}
+	return staging, nil, nil
+}
+
+func (c *IRODSFSClientBuffered) Symlink(target string, linkPath string) error {
+	return c.SymlinkCtx(context.Background(), target, linkPath)
+}
+
+func (c *IRODSFSClientBuffered) SymlinkCtx(ctx context.Context, target string, linkPath string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := validateSymlinkTarget(linkPath, target); err != nil {
+		return err
+	}
+
+	// The link replaces the data object at linkPath
+	c.invalidateFileCacheBlocks(linkPath)
+	defer c.acls.invalidate(linkPath, false)
+
+	if staging := c.router.getStaging(linkPath); staging != nil {
+		if err := staging.Symlink(linkPath, target); err != nil {
+			return wrapStagingError("symlink", linkPath, err)
+		}
+		return c.syncWriteThrough(ctx, linkPath)
+	}
+	return c.client.SymlinkCtx(ctx, target, linkPath)
+}
+
+func (c *IRODSFSClientBuffered) Readlink(path string) (string, error) {
+	return c.ReadlinkCtx(context.Background(), path)
+}
+
+func (c *IRODSFSClientBuffered) ReadlinkCtx(ctx context.Context, path string) (string, error) {
+	_, meta, err := c.getPendingStaged("readlink", path)
+	if err != nil {
+		return "", err
+	}
+
+	if meta != nil {
+		if meta.SymlinkTarget != "" {
+			return meta.SymlinkTarget, nil
+		}
+		if meta.IsNew {
+			return "", newError("readlink", path, ErrInvalid, errors.New("not a symlink"))
+		}
+	}
+	return c.client.ReadlinkCtx(ctx, path)
+}
+
+func (c *IRODSFSClientBuffered) GetXattr(path string, name string) ([]byte, error) {
+	return c.GetXattrCtx(context.Background(), path, name)
+}
+
+func (c *IRODSFSClientBuffered) GetXattrCtx(ctx context.Context, path string, name string) ([]byte, error) {
+	_, meta, err := c.getPendingStaged("getxattr", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if meta != nil {
+		if value, ok := meta.Xattrs[name]; ok {
+			if value == nil {
+				// Removal not yet synced
+				return nil, newError("getxattr", path, ErrNoAttribute, nil)
+			}
+			return append([]byte{}, value...), nil
+		}
+		if meta.IsNew {
+			return nil, newError("getxattr", path, ErrNoAttribute, nil)
+		}
+	}
+	return c.client.GetXattrCtx(ctx, path, name)
+}
+
+func (c *IRODSFSClientBuffered) SetXattr(path string, name string, value []byte) error {
+	return c.SetXattrCtx(context.Background(), path, name, value)
+}
+
+func (c *IRODSFSClientBuffered) SetXattrCtx(ctx context.Context, path string, name string, value []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := validateXattrName("setxattr", path, name); err != nil {
+		return err
+	}
+
+	staging, meta, err := c.getPendingStaged("setxattr", path)
+	if err != nil {
+		return err
+	}
+
+	if meta != nil {
+		err := staging.SetXattr(path, name, value)
+		if err == nil {
+			return c.syncWriteThrough(ctx, path)
+		}
+		if !errors.Is(err, stagingfs.ErrNotStaged) {
+			return wrapStagingError("setxattr", path, err)
+		}
+		// Synced in the meantime
+	}
+	return c.client.SetXattrCtx(ctx, path, name, value)
+}
+
+func (c *IRODSFSClientBuffered) ListXattr(path string) ([]string, error) {
+	return c.ListXattrCtx(context.Background(), path)
+}
+
+func (c *IRODSFSClientBuffered) ListXattrCtx(ctx context.Context, path string) ([]string, error) {
+	_, meta, err := c.getPendingStaged("listxattr", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if meta == nil {
+		return c.client.ListXattrCtx(ctx, path)
+	}
+
+	names := map[string]bool{}
+	if !meta.IsNew {
+		irodsNames, err := c.client.ListXattrCtx(ctx, path)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range irodsNames {
+			names[name] = true
+		}
+	}
+
+	// Staged changes override iRODS
+	for name, value := range meta.Xattrs {
+		names[name] = value != nil
+	}
+
+	result := []string{}
+	for name, exists := range names {
+		if exists {
+			result = append(result, name)
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+func (c *IRODSFSClientBuffered) RemoveXattr(path string, name string) error {
+	return c.RemoveXattrCtx(context.Background(), path, name)
+}
+
+func (c *IRODSFSClientBuffered) RemoveXattrCtx(ctx context.Context, path string, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	staging, meta, err := c.getPendingStaged("removexattr", path)
+	if err != nil {
+		return err
+	}
+
+	if meta != nil {
+		// Removing a missing attribute fails now rather than when the removal is synced
+		if _, err := c.GetXattrCtx(ctx, path, name); err != nil {
+			if errors.Is(err, ErrNoAttribute) {
+				return newError("removexattr", path, ErrNoAttribute, nil)
+			}
+			return err
+		}
+
+		err := staging.RemoveXattr(path, name)
+		if err == nil {
+			return c.syncWriteThrough(ctx, path)
+		}
+		if !errors.Is(err, stagingfs.ErrNotStaged) {
+			return wrapStagingError("removexattr", path, err)
+		}
+		// Synced in the meantime
+	}
+	return c.client.RemoveXattrCtx(ctx, path, name)
+}
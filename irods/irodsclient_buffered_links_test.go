@@ -0,0 +1,126 @@
+package irods
+
+import (
+	"syscall"
+	"testing"
+	"time"
+
+	irodsclient_types "github.com/cyverse/go-irodsclient/irods/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestXattrValueEncoding(t *testing.T) {
+	for _, value := range [][]byte{{}, []byte("text"), {0, 0xff, '\n'}} {
+		encoded := encodeXattrValue(value)
+		assert.NotEmpty(t, encoded, "AVU values cannot be empty")
+
+		decoded, err := decodeXattrValue(encoded)
+		require.NoError(t, err)
+		assert.Equal(t, value, decoded)
+	}
+
+	// Values set by other tools are returned as they are
+	decoded, err := decodeXattrValue("set with imeta")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("set with imeta"), decoded)
+
+	_, err = decodeXattrValue(xattrValuePrefix + "not base64!")
+	assert.Error(t, err)
+}
+
+func TestXattrNames(t *testing.T) {
+	metas := []*irodsclient_types.IRODSMeta{
+		{Name: XattrAVUPrefix + "user.b", Value: encodeXattrValue([]byte("1"))},
+		{Name: SymlinkAVUName, Value: "/target"},
+		{Name: "project", Value: "other metadata"},
+		{Name: XattrAVUPrefix + "user.a", Value: encodeXattrValue([]byte("2"))},
+		{Name: XattrAVUPrefix + "user.b", Value: "duplicate"},
+		{Name: XattrAVUPrefix, Value: "no name"},
+	}
+
+	assert.Equal(t, []string{"user.a", "user.b"}, xattrNames(metas))
+	assert.Equal(t, "/target", findAVU(metas, SymlinkAVUName).Value)
+	assert.Nil(t, findAVU(metas, XattrAVUPrefix+"user.c"))
+}
+
+func TestBufferedClientStagedSymlink(t *testing.T) {
+	mockClient := &mockStagingClient{}
+	client := newTestBufferedClient(t, &IRODSFSClientBufferedConfig{
+		StagingRootPath: t.TempDir(),
+		GracePeriod:     time.Hour,
+	}, mockClient)
+
+	staging := client.router.getStaging("/zone/link")
+	require.NoError(t, staging.Symlink("/zone/link", "target.txt"))
+
+	entry, err := client.Stat("/zone/link")
+	require.NoError(t, err)
+	assert.Equal(t, SymlinkEntry, entry.Type)
+	assert.Equal(t, int64(len("target.txt")), entry.Size)
+
+	target, err := client.Readlink("/zone/link")
+	require.NoError(t, err)
+	assert.Equal(t, "target.txt", target)
+
+	// A new staged file or directory is not a symlink
+	require.NoError(t, staging.Create("/zone/file"))
+	_, err = client.Readlink("/zone/file")
+	assert.ErrorIs(t, err, ErrInvalid)
+	assert.Equal(t, syscall.EINVAL, ErrnoFor(err))
+
+	require.NoError(t, client.Sync())
+	assert.Contains(t, mockClient.getOps(), "symlink /zone/link target.txt")
+	assert.NotContains(t, mockClient.getOps(), "upload /zone/link")
+}
+
+func TestBufferedClientStagedXattrs(t *testing.T) {
+	mockClient := &mockStagingClient{}
+	client := newTestBufferedClient(t, &IRODSFSClientBufferedConfig{
+		StagingRootPath: t.TempDir(),
+		GracePeriod:     time.Hour,
+	}, mockClient)
+
+	require.NoError(t, client.MakeDir("/zone/dir", false))
+	require.NoError(t, client.SetXattr("/zone/dir", "user.b", []byte("2")))
+	require.NoError(t, client.SetXattr("/zone/dir", "user.a", []byte("1")))
+	require.NoError(t, client.SetXattr("/zone/dir", "user.empty", nil))
+
+	value, err := client.GetXattr("/zone/dir", "user.a")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("1"), value)
+
+	value, err = client.GetXattr("/zone/dir", "user.empty")
+	require.NoError(t, err)
+	assert.Empty(t, value)
+
+	names, err := client.ListXattr("/zone/dir")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user.a", "user.b", "user.empty"}, names)
+
+	require.NoError(t, client.RemoveXattr("/zone/dir", "user.b"))
+	_, err = client.GetXattr("/zone/dir", "user.b")
+	assert.ErrorIs(t, err, ErrNoAttribute)
+	assert.Equal(t, syscall.ENODATA, ErrnoFor(err))
+
+	err = client.RemoveXattr("/zone/dir", "user.missing")
+	assert.ErrorIs(t, err, ErrNoAttribute)
+
+	err = client.SetXattr("/zone/dir", "", []byte("1"))
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	// Paths deleted in staging have no attributes to change
+	staging := client.router.getStaging("/zone/deleted")
+	require.NoError(t, staging.Delete("/zone/deleted"))
+	_, err = client.ListXattr("/zone/deleted")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.ErrorIs(t, client.SetXattr("/zone/deleted", "user.a", []byte("1")), ErrNotFound)
+
+	require.NoError(t, client.Sync())
+	ops := mockClient.getOps()
+	assert.Contains(t, ops, "mkdir /zone/dir")
+	assert.Contains(t, ops, "setxattr /zone/dir user.a=1")
+	assert.Contains(t, ops, "setxattr /zone/dir user.empty=")
+	assert.NotContains(t, ops, "setxattr /zone/dir user.b=2")
+	assert.NotContains(t, ops, "removexattr /zone/dir user.b")
+}
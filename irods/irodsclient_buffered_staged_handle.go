@@ -1,6 +1,7 @@
 package irods
 
 import (
+	"context"
 	"io"
 	"os"
 	"path"
@@ -14,7 +15,7 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-var _ IRODSFSFileHandle = (*IRODSFSClientBufferedStagedHandle)(nil)
+var _ IRODSFSFileHandleCtx = (*IRODSFSClientBufferedStagedHandle)(nil)
 
 // IRODSFSClientBufferedStagedHandle implements IRODSFSFileHandle using local staging files.
 // Write operations go to a local file; background sync uploads to iRODS.
@@ -77,6 +78,11 @@ func (h *IRODSFSClientBufferedStagedHandle) GetID() string {
 	return h.id
 }
 
+// GetReplica returns nil, staged handles read and write local bytes
+func (h *IRODSFSClientBufferedStagedHandle) GetReplica() *irodsclient_types.IRODSReplica {
+	return nil
+}
+
 func (h *IRODSFSClientBufferedStagedHandle) GetEntry() *irodsclient_fs.Entry {
 	return h.entry
 }
@@ -110,35 +116,51 @@ func (h *IRODSFSClientBufferedStagedHandle) GetAvailable(offset int64) int64 {
 }
 
 func (h *IRODSFSClientBufferedStagedHandle) ReadAt(buffer []byte, offset int64) (int, error) {
+	return h.ReadAtCtx(context.Background(), buffer, offset)
+}
+
+func (h *IRODSFSClientBufferedStagedHandle) ReadAtCtx(ctx context.Context, buffer []byte, offset int64) (int, error) {
 	if !h.openMode.IsRead() {
 		return 0, ErrNotReadMode
 	}
 
 	defer util.StackTraceFromPanic(h.logger)
 
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
 	n, err := h.file.ReadAt(buffer, offset)
 	if err != nil && err != io.EOF {
-		return n, err
+		return n, wrapStagingError("read", h.irodsPath, err)
 	}
 	return n, err
 }
 
 func (h *IRODSFSClientBufferedStagedHandle) WriteAt(data []byte, offset int64) (int, error) {
+	return h.WriteAtCtx(context.Background(), data, offset)
+}
+
+func (h *IRODSFSClientBufferedStagedHandle) WriteAtCtx(ctx context.Context, data []byte, offset int64) (int, error) {
 	if !h.openMode.IsWrite() {
 		return 0, ErrNotWriteMode
 	}
 
 	defer util.StackTraceFromPanic(h.logger)
 
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
 	n, err := h.file.WriteAt(data, offset)
 	if err != nil {
-		return n, err
+		return n, wrapStagingError("write", h.irodsPath, err)
 	}
 
 	// Update entry size if file grew
@@ -152,13 +174,21 @@ func (h *IRODSFSClientBufferedStagedHandle) WriteAt(data []byte, offset int64) (
 
 
 func (h *IRODSFSClientBufferedStagedHandle) Truncate(size int64) error {
+	return h.TruncateCtx(context.Background(), size)
+}
+
+func (h *IRODSFSClientBufferedStagedHandle) TruncateCtx(ctx context.Context, size int64) error {
 	defer util.StackTraceFromPanic(h.logger)
 
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
 	if err := h.file.Truncate(size); err != nil {
-		return err
+		return wrapStagingError("truncate", h.irodsPath, err)
 	}
 
 	h.entry.Size = size
@@ -166,12 +196,30 @@ func (h *IRODSFSClientBufferedStagedHandle) Truncate(size int64) error {
 }
 
 func (h *IRODSFSClientBufferedStagedHandle) Flush() error {
+	return h.FlushCtx(context.Background())
+}
+
+func (h *IRODSFSClientBufferedStagedHandle) FlushCtx(ctx context.Context) error {
 	defer util.StackTraceFromPanic(h.logger)
 
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
-	return h.file.Sync()
+	if err := h.file.Sync(); err != nil {
+		return wrapStagingError("flush", h.irodsPath, err)
+	}
+
+	// Make the staging state of the file durable too when metadata writes are batched
+	if h.client != nil {
+		if staging := h.client.router.getStaging(h.irodsPath); staging != nil {
+			return wrapStagingError("flush", h.irodsPath, staging.FlushMetadata())
+		}
+	}
+	return nil
 }
 
 func (h *IRODSFSClientBufferedStagedHandle) Close() error {
@@ -181,12 +229,16 @@ func (h *IRODSFSClientBufferedStagedHandle) Close() error {
 	defer h.mu.Unlock()
 
 	if err := h.file.Close(); err != nil {
-		return err
+		return wrapStagingError("close", h.irodsPath, err)
 	}
 
 	// Invalidate read cache for this path since local writes may differ
 	if h.client != nil {
 		h.client.invalidateFileCacheBlocks(h.irodsPath)
+
+		if h.openMode.IsWrite() {
+			return h.client.syncWriteThrough(context.Background(), h.irodsPath)
+		}
 	}
 
 	return nil
@@ -0,0 +1,301 @@
+package irods
+
+import (
+	"context"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cyverse/irodsfs-common/irods/stagingfs"
+)
+
+// StagingSyncMode determines when staged changes reach iRODS
+type StagingSyncMode string
+
+const (
+	// StagingSyncModeAsync syncs staged changes in background after the grace period
+	StagingSyncModeAsync StagingSyncMode = "async"
+	// StagingSyncModeWriteThrough syncs staged changes when the file handle is closed or the operation returns
+	StagingSyncModeWriteThrough StagingSyncMode = "write_through"
+)
+
+// StagingPolicy configures staging for iRODS paths under IRODSPathPrefix.
+// The policy with the longest matching prefix applies; paths matching no policy use the client-wide settings.
+type StagingPolicy struct {
+	IRODSPathPrefix string          `yaml:"irods_path_prefix" json:"irods_path_prefix"`
+	RootPath        string          `yaml:"root_path" json:"root_path"`         // Local staging root (empty = StagingRootPath of the client)
+	MaxDataSize     int64           `yaml:"max_data_size" json:"max_data_size"` // Max disk usage of the root (0 = default)
+	GracePeriod     time.Duration   `yaml:"grace_period" json:"grace_period"`   // Grace period before sync (0 = default)
+	SyncMode        StagingSyncMode `yaml:"sync_mode" json:"sync_mode"`         // Sync mode (empty = async)
+	Disabled        bool            `yaml:"disabled" json:"disabled"`           // Bypass staging, write to iRODS directly
+}
+
+// Validate validates StagingPolicy
+func (policy *StagingPolicy) Validate() error {
+	if !path.IsAbs(policy.IRODSPathPrefix) {
+		return errors.Newf("staging policy prefix %q is not absolute", policy.IRODSPathPrefix)
+	}
+
+	switch policy.SyncMode {
+	case "", StagingSyncModeAsync, StagingSyncModeWriteThrough:
+	default:
+		return errors.Newf("invalid staging sync mode %q", policy.SyncMode)
+	}
+
+	return nil
+}
+
+// IsWriteThrough returns true if staged changes are synced immediately
+func (policy *StagingPolicy) IsWriteThrough() bool {
+	return policy.SyncMode == StagingSyncModeWriteThrough
+}
+
+// stagingRoute binds a path prefix to the staging filesystem serving it
+type stagingRoute struct {
+	prefix  string
+	policy  StagingPolicy
+	staging *stagingfs.StagingFS // nil when staging is disabled for the prefix
+}
+
+func (r *stagingRoute) matches(p string) bool {
+	return r.prefix == "/" || p == r.prefix || strings.HasPrefix(p, r.prefix+"/")
+}
+
+// stagingRouter selects the staging filesystem for each iRODS path
+type stagingRouter struct {
+	routes   []*stagingRoute        // sorted by prefix length, longest first
+	stagings []*stagingfs.StagingFS // distinct staging filesystems
+}
+
+// newStagingRouter creates staging filesystems for the client-wide staging root and every staging policy.
+// Policies sharing a root share its staging filesystem and must agree on its quota and grace period.
+func newStagingRouter(config *IRODSFSClientBufferedConfig, client stagingfs.StagingClient) (*stagingRouter, error) {
+	router := &stagingRouter{}
+	roots := map[string]*StagingPolicy{}
+	rootStagings := map[string]*stagingfs.StagingFS{}
+
+	openRoot := func(policy *StagingPolicy) (*stagingfs.StagingFS, error) {
+		if existing, ok := roots[policy.RootPath]; ok {
+			if existing.MaxDataSize != policy.MaxDataSize || existing.GracePeriod != policy.GracePeriod {
+				return nil, errors.Newf("staging policies sharing root %q must use the same quota and grace period", policy.RootPath)
+			}
+			return rootStagings[policy.RootPath], nil
+		}
+
+		staging, err := newStagingFSForRoot(config, client, policy)
+		if err != nil {
+			return nil, err
+		}
+
+		roots[policy.RootPath] = policy
+		rootStagings[policy.RootPath] = staging
+		router.stagings = append(router.stagings, staging)
+		return staging, nil
+	}
+
+	defaultPolicy := StagingPolicy{
+		IRODSPathPrefix: "/",
+		RootPath:        config.StagingRootPath,
+		MaxDataSize:     config.MaxStagingDataSize,
+		GracePeriod:     config.GracePeriod,
+		SyncMode:        StagingSyncModeAsync,
+		Disabled:        config.StagingRootPath == "",
+	}
+
+	policies := append([]StagingPolicy{defaultPolicy}, config.StagingPolicies...)
+	for i := range policies {
+		policy := policies[i]
+		if err := policy.Validate(); err != nil {
+			router.close(context.Background())
+			return nil, errors.Wrap(err, "failed to validate staging policy")
+		}
+
+		route := &stagingRoute{
+			prefix: path.Clean(policy.IRODSPathPrefix),
+			policy: policy,
+		}
+
+		if !policy.Disabled {
+			if route.policy.RootPath == "" {
+				route.policy.RootPath = config.StagingRootPath
+				if route.policy.MaxDataSize == 0 {
+					route.policy.MaxDataSize = config.MaxStagingDataSize
+				}
+				if route.policy.GracePeriod == 0 {
+					route.policy.GracePeriod = config.GracePeriod
+				}
+			}
+			if route.policy.RootPath == "" {
+				router.close(context.Background())
+				return nil, errors.Newf("staging policy for %q requires a root path", policy.IRODSPathPrefix)
+			}
+
+			staging, err := openRoot(&route.policy)
+			if err != nil {
+				router.close(context.Background())
+				return nil, err
+			}
+			route.staging = staging
+		}
+
+		// a later policy for the same prefix replaces an earlier one
+		replaced := false
+		for j, existing := range router.routes {
+			if existing.prefix == route.prefix {
+				router.routes[j] = route
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			router.routes = append(router.routes, route)
+		}
+	}
+
+	sort.SliceStable(router.routes, func(i, j int) bool {
+		return len(router.routes[i].prefix) > len(router.routes[j].prefix)
+	})
+
+	return router, nil
+}
+
+// newStagingFSForRoot creates a staging filesystem for a root with the client-wide sync settings
+func newStagingFSForRoot(config *IRODSFSClientBufferedConfig, client stagingfs.StagingClient, policy *StagingPolicy) (*stagingfs.StagingFS, error) {
+	stagingConfig := &stagingfs.StagingFSConfig{
+		LocalRootPath: policy.RootPath,
+		Client:        client,
+		MaxDataSize:   policy.MaxDataSize,
+		SyncInterval:  config.SyncInterval,
+		GracePeriod:   policy.GracePeriod,
+		OnSyncError:   config.OnSyncError,
+
+		ResumableUploadThreshold: config.ResumableUploadThreshold,
+		UploadPartSize:           config.UploadPartSize,
+		UploadPartConcurrency:    config.UploadPartConcurrency,
+		SyncBandwidthLimit:       config.SyncBandwidthLimit,
+		SyncBandwidthBurst:       config.SyncBandwidthBurst,
+		SyncWindows:              config.SyncWindows,
+		WindowedSyncThreshold:    config.WindowedSyncThreshold,
+		PriorityRules:            config.PriorityRules,
+		AtomicReplace:            config.AtomicReplace,
+		AtomicTempPattern:        config.AtomicTempPattern,
+		MetadataDurability:       config.MetadataDurability,
+		MetadataFlushInterval:    config.MetadataFlushInterval,
+	}
+
+	var staging *stagingfs.StagingFS
+	var err error
+	if config.UsePersistence {
+		if config.MetadataStoreFactory != nil {
+			store, storeErr := config.MetadataStoreFactory(policy.RootPath)
+			if storeErr != nil {
+				return nil, errors.Wrapf(storeErr, "failed to create metadata store for %q", policy.RootPath)
+			}
+			stagingConfig.MetadataStore = store
+		}
+		staging, err = stagingfs.NewStagingFSWithPersistence(stagingConfig)
+	} else {
+		staging, err = stagingfs.NewStagingFS(stagingConfig)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create staging filesystem at %q", policy.RootPath)
+	}
+	return staging, nil
+}
+
+// getRoute returns the route for an iRODS path
+func (r *stagingRouter) getRoute(p string) *stagingRoute {
+	if r == nil {
+		return nil
+	}
+
+	for _, route := range r.routes {
+		if route.matches(p) {
+			return route
+		}
+	}
+	return nil
+}
+
+// getStaging returns the staging filesystem for an iRODS path, or nil if staging is disabled for it
+func (r *stagingRouter) getStaging(p string) *stagingfs.StagingFS {
+	route := r.getRoute(p)
+	if route == nil {
+		return nil
+	}
+	return route.staging
+}
+
+// isWriteThrough returns true if staged changes to an iRODS path are synced immediately
+func (r *stagingRouter) isWriteThrough(p string) bool {
+	route := r.getRoute(p)
+	return route != nil && route.staging != nil && route.policy.IsWriteThrough()
+}
+
+// getStagings returns all distinct staging filesystems
+func (r *stagingRouter) getStagings() []*stagingfs.StagingFS {
+	if r == nil {
+		return nil
+	}
+	return r.stagings
+}
+
+// close closes all staging filesystems, returning the first error
+func (r *stagingRouter) close(ctx context.Context) error {
+	if r == nil {
+		return nil
+	}
+
+	var firstErr error
+	for _, staging := range r.stagings {
+		if err := staging.Close(ctx); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	r.stagings = nil
+	r.routes = nil
+	return firstErr
+}
+
+// getStagingForRename returns the staging filesystem that can stage a rename from srcPath to destPath,
+// or nil if the rename has to be performed in iRODS directly because the paths are served differently
+// or other staging roots hold staged changes under srcPath
+func (c *IRODSFSClientBuffered) getStagingForRename(srcPath string, destPath string) *stagingfs.StagingFS {
+	staging := c.router.getStaging(srcPath)
+	if staging == nil || staging != c.router.getStaging(destPath) {
+		return nil
+	}
+
+	for _, other := range c.router.getStagings() {
+		if other != staging && len(other.GetSubtree(srcPath)) > 0 {
+			return nil
+		}
+	}
+	return staging
+}
+
+// syncStagedSubtrees syncs staged changes at and below the given paths in every staging root
+func (c *IRODSFSClientBuffered) syncStagedSubtrees(ctx context.Context, paths ...string) error {
+	for _, staging := range c.router.getStagings() {
+		for _, p := range paths {
+			if err := staging.SyncSubtreeCtx(ctx, p); err != nil {
+				return wrapStagingError("sync", p, errors.Wrapf(err, "failed to sync staged changes under %q", p))
+			}
+		}
+	}
+	return nil
+}
+
+// syncWriteThrough syncs staged changes at and below irodsPath if its staging policy is write-through
+func (c *IRODSFSClientBuffered) syncWriteThrough(ctx context.Context, irodsPath string) error {
+	if !c.router.isWriteThrough(irodsPath) {
+		return nil
+	}
+
+	if err := c.router.getStaging(irodsPath).SyncSubtreeCtx(ctx, irodsPath); err != nil {
+		return wrapStagingError("sync", irodsPath, errors.Wrapf(err, "failed to write through staged changes of %q", irodsPath))
+	}
+	return nil
+}
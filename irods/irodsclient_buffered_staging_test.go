@@ -0,0 +1,190 @@
+package irods
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	irodsclient_common "github.com/cyverse/go-irodsclient/irods/common"
+	"github.com/cyverse/irodsfs-common/irods/stagingfs"
+	"github.com/cyverse/irodsfs-common/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockStagingClient records the operations synced to iRODS
+type mockStagingClient struct {
+	mu  sync.Mutex
+	ops []string
+	err error // returned by all operations if set
+}
+
+func (m *mockStagingClient) record(op string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ops = append(m.ops, op)
+	return m.err
+}
+
+func (m *mockStagingClient) getOps() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string{}, m.ops...)
+}
+
+func (m *mockStagingClient) DownloadFileParallel(irodsPath string, localPath string, taskNum int, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return m.record("download " + irodsPath)
+}
+
+func (m *mockStagingClient) UploadFileParallel(localPath string, irodsPath string, taskNum int, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return m.record("upload " + irodsPath)
+}
+
+func (m *mockStagingClient) RenameFileToFile(srcPath string, destPath string) error {
+	return m.record("rename " + srcPath + " " + destPath)
+}
+
+func (m *mockStagingClient) RenameDirToDir(srcPath string, destPath string) error {
+	return m.record("renamedir " + srcPath + " " + destPath)
+}
+
+func (m *mockStagingClient) RemoveFile(path string, force bool) error {
+	return m.record("rm " + path)
+}
+
+func (m *mockStagingClient) MakeDir(path string, recurse bool) error {
+	return m.record("mkdir " + path)
+}
+
+func (m *mockStagingClient) RemoveDir(path string, recurse bool, force bool) error {
+	return m.record("rmdir " + path)
+}
+
+func (m *mockStagingClient) Symlink(target string, linkPath string) error {
+	return m.record("symlink " + linkPath + " " + target)
+}
+
+func (m *mockStagingClient) SetXattr(path string, name string, value []byte) error {
+	return m.record("setxattr " + path + " " + name + "=" + string(value))
+}
+
+func (m *mockStagingClient) RemoveXattr(path string, name string) error {
+	return m.record("removexattr " + path + " " + name)
+}
+
+func newTestStagingRouter(t *testing.T, config *IRODSFSClientBufferedConfig, client stagingfs.StagingClient) *stagingRouter {
+	router, err := newStagingRouter(config, client)
+	require.NoError(t, err)
+	t.Cleanup(func() { router.close(context.Background()) })
+	return router
+}
+
+func TestStagingRouterLongestPrefix(t *testing.T) {
+	defaultRoot := t.TempDir()
+	projectRoot := t.TempDir()
+
+	router := newTestStagingRouter(t, &IRODSFSClientBufferedConfig{
+		StagingRootPath: defaultRoot,
+		StagingPolicies: []StagingPolicy{
+			{IRODSPathPrefix: "/zone/home/user/project", RootPath: projectRoot},
+			{IRODSPathPrefix: "/zone/home/user/project/archive", Disabled: true},
+			{IRODSPathPrefix: "/zone/home/user/scratch"},
+		},
+	}, &mockStagingClient{})
+
+	assert.Len(t, router.getStagings(), 2)
+
+	defaultStaging := router.getStaging("/zone/home/user/file.txt")
+	projectStaging := router.getStaging("/zone/home/user/project/data.csv")
+	require.NotNil(t, defaultStaging)
+	require.NotNil(t, projectStaging)
+
+	assert.True(t, strings.HasPrefix(defaultStaging.GetLocalDataPath("/f"), defaultRoot))
+	assert.True(t, strings.HasPrefix(projectStaging.GetLocalDataPath("/f"), projectRoot))
+	assert.Same(t, projectStaging, router.getStaging("/zone/home/user/project"))
+	assert.Same(t, defaultStaging, router.getStaging("/zone/home/user/project2/data.csv"))
+	assert.Same(t, defaultStaging, router.getStaging("/zone/home/user/scratch/tmp.dat"))
+	assert.Nil(t, router.getStaging("/zone/home/user/project/archive/old.tar"))
+}
+
+func TestStagingRouterNoDefaultRoot(t *testing.T) {
+	router := newTestStagingRouter(t, &IRODSFSClientBufferedConfig{
+		StagingPolicies: []StagingPolicy{
+			{IRODSPathPrefix: "/zone/home/user/project", RootPath: t.TempDir()},
+		},
+	}, &mockStagingClient{})
+
+	assert.Nil(t, router.getStaging("/zone/home/user/file.txt"))
+	assert.NotNil(t, router.getStaging("/zone/home/user/project/file.txt"))
+}
+
+func TestStagingRouterSharedRoot(t *testing.T) {
+	root := t.TempDir()
+
+	router := newTestStagingRouter(t, &IRODSFSClientBufferedConfig{
+		StagingPolicies: []StagingPolicy{
+			{IRODSPathPrefix: "/zone/a", RootPath: root, GracePeriod: time.Minute},
+			{IRODSPathPrefix: "/zone/b", RootPath: root, GracePeriod: time.Minute, SyncMode: StagingSyncModeWriteThrough},
+		},
+	}, &mockStagingClient{})
+
+	assert.Len(t, router.getStagings(), 1)
+	assert.Same(t, router.getStaging("/zone/a/x"), router.getStaging("/zone/b/y"))
+	assert.False(t, router.isWriteThrough("/zone/a/x"))
+	assert.True(t, router.isWriteThrough("/zone/b/y"))
+}
+
+func TestStagingRouterConflictingRoot(t *testing.T) {
+	root := t.TempDir()
+
+	_, err := newStagingRouter(&IRODSFSClientBufferedConfig{
+		StagingPolicies: []StagingPolicy{
+			{IRODSPathPrefix: "/zone/a", RootPath: root, MaxDataSize: 1024},
+			{IRODSPathPrefix: "/zone/b", RootPath: root, MaxDataSize: 2048},
+		},
+	}, &mockStagingClient{})
+	assert.Error(t, err)
+}
+
+func TestStagingRouterInvalidPolicy(t *testing.T) {
+	_, err := newStagingRouter(&IRODSFSClientBufferedConfig{
+		StagingPolicies: []StagingPolicy{
+			{IRODSPathPrefix: "relative/path", RootPath: t.TempDir()},
+		},
+	}, &mockStagingClient{})
+	assert.Error(t, err)
+
+	_, err = newStagingRouter(&IRODSFSClientBufferedConfig{
+		StagingPolicies: []StagingPolicy{
+			{IRODSPathPrefix: "/zone/a", RootPath: t.TempDir(), SyncMode: "sometimes"},
+		},
+	}, &mockStagingClient{})
+	assert.Error(t, err)
+}
+
+func TestBufferedClientWriteThroughMakeDir(t *testing.T) {
+	mockClient := &mockStagingClient{}
+	router := newTestStagingRouter(t, &IRODSFSClientBufferedConfig{
+		StagingRootPath: t.TempDir(),
+		GracePeriod:     time.Hour,
+		StagingPolicies: []StagingPolicy{
+			{IRODSPathPrefix: "/zone/sync", RootPath: t.TempDir(), SyncMode: StagingSyncModeWriteThrough},
+		},
+	}, mockClient)
+
+	client := &IRODSFSClientBuffered{
+		cache:  newTestCacheManager(t),
+		helper: util.NewFileBlockHelper(1024 * 1024),
+		router: router,
+		logger: newTestLogger(),
+	}
+
+	require.NoError(t, client.MakeDir("/zone/async/dir", false))
+	require.NoError(t, client.MakeDir("/zone/sync/dir", false))
+
+	assert.Equal(t, []string{"mkdir /zone/sync/dir"}, mockClient.getOps())
+	assert.NotNil(t, router.getStaging("/zone/async/dir").Get("/zone/async/dir"))
+	assert.Nil(t, router.getStaging("/zone/sync/dir").Get("/zone/sync/dir"))
+}
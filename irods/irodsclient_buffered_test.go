@@ -21,6 +21,7 @@ type mockFileHandle struct {
 	entry    *irodsclient_fs.Entry
 	openMode irodsclient_types.FileOpenMode
 	data     []byte
+	replica  *irodsclient_types.IRODSReplica
 }
 
 func newMockFileHandle(path string, data []byte, mode irodsclient_types.FileOpenMode) *mockFileHandle {
@@ -41,6 +42,7 @@ func newMockFileHandle(path string, data []byte, mode irodsclient_types.FileOpen
 
 func (h *mockFileHandle) GetID() string                               { return h.id }
 func (h *mockFileHandle) GetEntry() *irodsclient_fs.Entry             { return h.entry }
+func (h *mockFileHandle) GetReplica() *irodsclient_types.IRODSReplica { return h.replica }
 func (h *mockFileHandle) GetOpenMode() irodsclient_types.FileOpenMode { return h.openMode }
 func (h *mockFileHandle) IsReadMode() bool                            { return h.openMode.IsRead() }
 func (h *mockFileHandle) IsWriteMode() bool                           { return h.openMode.IsWrite() }
@@ -1,7 +1,11 @@
 package irods
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
 	"io"
+	"os"
 
 	"github.com/cockroachdb/errors"
 	irodsclient_fs "github.com/cyverse/go-irodsclient/fs"
@@ -17,13 +21,21 @@ import (
 // direct access to iRODS server
 // implements interfaces defined in interface.go
 type IRODSFSClientDirect struct {
-	id     string
-	fs     *irodsclient_fs.FileSystem
-	logger *log.Entry
+	id        string
+	fs        *irodsclient_fs.FileSystem
+	options   *OpenOptions
+	transfers *TransferManager
+	logger    *log.Entry
 }
 
 // NewIRODSFSClientDirect creates IRODSFSClient using IRODSFSClientDirect
 func NewIRODSFSClientDirect(fs *irodsclient_fs.FileSystem) (IRODSFSClient, error) {
+	return NewIRODSFSClientDirectWithOptions(fs, nil)
+}
+
+// NewIRODSFSClientDirectWithOptions creates IRODSFSClient using IRODSFSClientDirect that reads and
+// writes on the resources and replicas selected by options unless calls select others
+func NewIRODSFSClientDirectWithOptions(fs *irodsclient_fs.FileSystem, options *OpenOptions) (IRODSFSClient, error) {
 	if fs == nil {
 		return nil, errors.New("fs is required")
 	}
@@ -35,9 +47,11 @@ func NewIRODSFSClientDirect(fs *irodsclient_fs.FileSystem) (IRODSFSClient, error
 	})
 
 	return &IRODSFSClientDirect{
-		id:     clientID,
-		fs:     fs,
-		logger: logger,
+		id:        clientID,
+		fs:        fs,
+		options:   options.merge(nil),
+		transfers: NewTransferManager(TransferManagerConfig{}),
+		logger:    logger,
 	}, nil
 }
 
@@ -77,63 +91,142 @@ func (c *IRODSFSClientDirect) Release() {
 
 // Sync is a no-op for direct client (no staging layer)
 func (c *IRODSFSClientDirect) Sync() error {
+	return c.SyncCtx(context.Background())
+}
+
+// SyncCtx is a no-op for direct client (no staging layer)
+func (c *IRODSFSClientDirect) SyncCtx(ctx context.Context) error {
 	return nil
 }
 
 // List lists directory entries
 func (c *IRODSFSClientDirect) List(path string) ([]*irodsclient_fs.Entry, error) {
+	return c.ListCtx(context.Background(), path)
+}
+
+// ListCtx lists directory entries, giving up once ctx is done. Symlinks are listed as files; Stat
+// detects them, since that takes a metadata query per data object.
+func (c *IRODSFSClientDirect) ListCtx(ctx context.Context, path string) ([]*irodsclient_fs.Entry, error) {
 	logger := c.logger.WithFields(log.Fields{
 		"path": path,
 	})
 
 	defer util.StackTraceFromPanic(logger)
 
-	entries, err := c.fs.List(path)
+	var entries []*irodsclient_fs.Entry
+	err := waitContext(ctx, func() error {
+		listed, listErr := c.fs.List(path)
+		entries = listed
+		return listErr
+	})
 	if err != nil {
-		return nil, err
+		return nil, wrapError("list", path, err)
 	}
 	return entries, nil
 }
 
 // Stat stats fs entry
 func (c *IRODSFSClientDirect) Stat(path string) (*irodsclient_fs.Entry, error) {
+	return c.StatCtx(context.Background(), path)
+}
+
+// StatCtx stats fs entry, giving up once ctx is done
+func (c *IRODSFSClientDirect) StatCtx(ctx context.Context, path string) (*irodsclient_fs.Entry, error) {
 	logger := c.logger.WithFields(log.Fields{
 		"path": path,
 	})
 
 	defer util.StackTraceFromPanic(logger)
 
-	entry, err := c.fs.Stat(path)
+	var entry *irodsclient_fs.Entry
+	err := waitContext(ctx, func() error {
+		stat, statErr := c.fs.Stat(path)
+		if statErr != nil {
+			return statErr
+		}
+		entry = c.markSymlink(stat)
+		return nil
+	})
 	if err != nil {
-		return nil, err
+		return nil, wrapError("stat", path, err)
 	}
 	return entry, nil
 }
 
+// markSymlink returns entry with type SymlinkEntry if it is a small data object with the symlink AVU.
+// Entries may be shared with the cache of go-irodsclient, so a symlink is returned as a marked copy.
+// If the AVUs cannot be listed, the entry is returned as a plain file rather than failing.
+func (c *IRODSFSClientDirect) markSymlink(entry *irodsclient_fs.Entry) *irodsclient_fs.Entry {
+	if entry == nil || entry.Type != irodsclient_fs.FileEntry || entry.Size == 0 || entry.Size > MaxSymlinkTargetLength {
+		return entry
+	}
+
+	metas, err := c.fs.ListMetadata(entry.Path)
+	if err != nil {
+		c.logger.WithFields(log.Fields{
+			"path": entry.Path,
+		}).Warnf("failed to list metadata to detect a symlink, treating it as a file: %v", err)
+		return entry
+	}
+
+	if findAVU(metas, SymlinkAVUName) == nil {
+		return entry
+	}
+
+	marked := *entry
+	marked.Type = SymlinkEntry
+	return &marked
+}
+
 // ExistsDir checks existance of a dir
 func (c *IRODSFSClientDirect) ExistsDir(path string) bool {
+	return c.ExistsDirCtx(context.Background(), path)
+}
+
+// ExistsDirCtx checks existance of a dir, returning false once ctx is done
+func (c *IRODSFSClientDirect) ExistsDirCtx(ctx context.Context, path string) bool {
 	logger := c.logger.WithFields(log.Fields{
 		"path": path,
 	})
 
 	defer util.StackTraceFromPanic(logger)
 
-	return c.fs.ExistsDir(path)
+	exists := false
+	err := waitContext(ctx, func() error {
+		exists = c.fs.ExistsDir(path)
+		return nil
+	})
+	return err == nil && exists
 }
 
 // ExistsFile checks existance of a file
 func (c *IRODSFSClientDirect) ExistsFile(path string) bool {
+	return c.ExistsFileCtx(context.Background(), path)
+}
+
+// ExistsFileCtx checks existance of a file, returning false once ctx is done
+func (c *IRODSFSClientDirect) ExistsFileCtx(ctx context.Context, path string) bool {
 	logger := c.logger.WithFields(log.Fields{
 		"path": path,
 	})
 
 	defer util.StackTraceFromPanic(logger)
 
-	return c.fs.ExistsFile(path)
+	exists := false
+	err := waitContext(ctx, func() error {
+		exists = c.fs.ExistsFile(path)
+		return nil
+	})
+	return err == nil && exists
 }
 
 // RemoveFile removes a file
 func (c *IRODSFSClientDirect) RemoveFile(path string, force bool) error {
+	return c.RemoveFileCtx(context.Background(), path, force)
+}
+
+// RemoveFileCtx removes a file
+func (c *IRODSFSClientDirect) RemoveFileCtx(ctx context.Context, path string, force bool) error {
 	logger := c.logger.WithFields(log.Fields{
 		"path":  path,
 		"force": force,
@@ -141,15 +234,24 @@ func (c *IRODSFSClientDirect) RemoveFile(path string, force bool) error {
 
 	defer util.StackTraceFromPanic(logger)
 
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	err := c.fs.RemoveFile(path, force)
 	if err != nil {
-		return err
+		return wrapError("remove", path, err)
 	}
 	return nil
 }
 
 // RemoveDir removes a directory
 func (c *IRODSFSClientDirect) RemoveDir(path string, recurse bool, force bool) error {
+	return c.RemoveDirCtx(context.Background(), path, recurse, force)
+}
+
+// RemoveDirCtx removes a directory
+func (c *IRODSFSClientDirect) RemoveDirCtx(ctx context.Context, path string, recurse bool, force bool) error {
 	logger := c.logger.WithFields(log.Fields{
 		"path":    path,
 		"recurse": recurse,
@@ -158,15 +260,24 @@ func (c *IRODSFSClientDirect) RemoveDir(path string, recurse bool, force bool) e
 
 	defer util.StackTraceFromPanic(logger)
 
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	err := c.fs.RemoveDir(path, recurse, force)
 	if err != nil {
-		return err
+		return wrapError("rmdir", path, err)
 	}
 	return nil
 }
 
 // MakeDir makes a new directory
 func (c *IRODSFSClientDirect) MakeDir(path string, recurse bool) error {
+	return c.MakeDirCtx(context.Background(), path, recurse)
+}
+
+// MakeDirCtx makes a new directory
+func (c *IRODSFSClientDirect) MakeDirCtx(ctx context.Context, path string, recurse bool) error {
 	logger := c.logger.WithFields(log.Fields{
 		"path":    path,
 		"recurse": recurse,
@@ -174,15 +285,24 @@ func (c *IRODSFSClientDirect) MakeDir(path string, recurse bool) error {
 
 	defer util.StackTraceFromPanic(logger)
 
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	err := c.fs.MakeDir(path, recurse)
 	if err != nil {
-		return err
+		return wrapError("mkdir", path, err)
 	}
 	return nil
 }
 
 // RenameDirToDir renames a directory, dest path is also a non-existing path for dir
 func (c *IRODSFSClientDirect) RenameDirToDir(srcPath string, destPath string) error {
+	return c.RenameDirToDirCtx(context.Background(), srcPath, destPath)
+}
+
+// RenameDirToDirCtx renames a directory, dest path is also a non-existing path for dir
+func (c *IRODSFSClientDirect) RenameDirToDirCtx(ctx context.Context, srcPath string, destPath string) error {
 	logger := c.logger.WithFields(log.Fields{
 		"srcPath":  srcPath,
 		"destPath": destPath,
@@ -190,15 +310,24 @@ func (c *IRODSFSClientDirect) RenameDirToDir(srcPath string, destPath string) er
 
 	defer util.StackTraceFromPanic(logger)
 
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	err := c.fs.RenameDirToDir(srcPath, destPath)
 	if err != nil {
-		return err
+		return wrapError("rename", srcPath, err)
 	}
 	return nil
 }
 
 // RenameFileToFile renames a file, dest path is also a non-existing path for file
 func (c *IRODSFSClientDirect) RenameFileToFile(srcPath string, destPath string) error {
+	return c.RenameFileToFileCtx(context.Background(), srcPath, destPath)
+}
+
+// RenameFileToFileCtx renames a file, dest path is also a non-existing path for file
+func (c *IRODSFSClientDirect) RenameFileToFileCtx(ctx context.Context, srcPath string, destPath string) error {
 	logger := c.logger.WithFields(log.Fields{
 		"srcPath":  srcPath,
 		"destPath": destPath,
@@ -206,15 +335,34 @@ func (c *IRODSFSClientDirect) RenameFileToFile(srcPath string, destPath string)
 
 	defer util.StackTraceFromPanic(logger)
 
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	err := c.fs.RenameFileToFile(srcPath, destPath)
 	if err != nil {
-		return err
+		return wrapError("rename", srcPath, err)
 	}
 	return nil
 }
 
 // CreateFile creates a file
 func (c *IRODSFSClientDirect) CreateFile(path string, mode string) (IRODSFSFileHandle, error) {
+	return c.CreateFileWithOptionsCtx(context.Background(), path, mode, nil)
+}
+
+// CreateFileCtx creates a file
+func (c *IRODSFSClientDirect) CreateFileCtx(ctx context.Context, path string, mode string) (IRODSFSFileHandle, error) {
+	return c.CreateFileWithOptionsCtx(ctx, path, mode, nil)
+}
+
+// CreateFileWithOptions creates a file on the resource selected by options
+func (c *IRODSFSClientDirect) CreateFileWithOptions(path string, mode string, options *OpenOptions) (IRODSFSFileHandle, error) {
+	return c.CreateFileWithOptionsCtx(context.Background(), path, mode, options)
+}
+
+// CreateFileWithOptionsCtx creates a file on the resource selected by options
+func (c *IRODSFSClientDirect) CreateFileWithOptionsCtx(ctx context.Context, path string, mode string, options *OpenOptions) (IRODSFSFileHandle, error) {
 	logger := c.logger.WithFields(log.Fields{
 		"path": path,
 		"mode": mode,
@@ -222,11 +370,17 @@ func (c *IRODSFSClientDirect) CreateFile(path string, mode string) (IRODSFSFileH
 
 	defer util.StackTraceFromPanic(logger)
 
-	handle, err := c.fs.CreateFile(path, "", mode)
-	if err != nil {
+	if err := ctx.Err(); err != nil {
 		return nil, err
 	}
 
+	options = options.merge(c.options)
+
+	handle, err := c.fs.CreateFile(path, options.Resource, mode)
+	if err != nil {
+		return nil, wrapError("create", path, err)
+	}
+
 	handleID := xid.New().String()
 	handleLogger := logger.WithFields(log.Fields{
 		"handle_id": handleID,
@@ -244,6 +398,22 @@ func (c *IRODSFSClientDirect) CreateFile(path string, mode string) (IRODSFSFileH
 
 // OpenFile opens a file
 func (c *IRODSFSClientDirect) OpenFile(path string, mode string) (IRODSFSFileHandle, error) {
+	return c.OpenFileWithOptionsCtx(context.Background(), path, mode, nil)
+}
+
+// OpenFileCtx opens a file
+func (c *IRODSFSClientDirect) OpenFileCtx(ctx context.Context, path string, mode string) (IRODSFSFileHandle, error) {
+	return c.OpenFileWithOptionsCtx(ctx, path, mode, nil)
+}
+
+// OpenFileWithOptions opens a file on the resource or replica selected by options
+func (c *IRODSFSClientDirect) OpenFileWithOptions(path string, mode string, options *OpenOptions) (IRODSFSFileHandle, error) {
+	return c.OpenFileWithOptionsCtx(context.Background(), path, mode, options)
+}
+
+// OpenFileWithOptionsCtx opens a file on the resource or replica selected by options. Files opened for
+// writing use options.Resource, files opened read-only the replica options pin reads to.
+func (c *IRODSFSClientDirect) OpenFileWithOptionsCtx(ctx context.Context, path string, mode string, options *OpenOptions) (IRODSFSFileHandle, error) {
 	logger := c.logger.WithFields(log.Fields{
 		"path": path,
 		"mode": mode,
@@ -251,28 +421,81 @@ func (c *IRODSFSClientDirect) OpenFile(path string, mode string) (IRODSFSFileHan
 
 	defer util.StackTraceFromPanic(logger)
 
-	handle, err := c.fs.OpenFile(path, "", mode)
-	if err != nil {
+	if err := ctx.Err(); err != nil {
 		return nil, err
 	}
 
+	options = options.merge(c.options)
+
+	resource := options.Resource
+	var replica *irodsclient_types.IRODSReplica
+	if !irodsclient_types.FileOpenMode(mode).IsWrite() {
+		var err error
+		resource, replica, err = c.readReplica(ctx, path, options)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	handle, err := c.fs.OpenFile(path, resource, mode)
+	if err != nil {
+		return nil, wrapError("open", path, err)
+	}
+
 	handleID := xid.New().String()
 	handleLogger := logger.WithFields(log.Fields{
 		"handle_id": handleID,
 	})
 
 	fileHandle := &IRODSFSClientDirectFileHandle{
-		id:     handleID,
-		client: c,
-		handle: handle,
-		logger: handleLogger,
+		id:      handleID,
+		client:  c,
+		handle:  handle,
+		replica: replica,
+		logger:  handleLogger,
 	}
 
 	return fileHandle, nil
 }
 
+// readReplica returns the resource to read path from and the replica on it that options pin reads to.
+// Both are empty if reads are not pinned.
+func (c *IRODSFSClientDirect) readReplica(ctx context.Context, path string, options *OpenOptions) (string, *irodsclient_types.IRODSReplica, error) {
+	return readReplica(ctx, c, path, options, c.logger)
+}
+
+// ListReplicas lists the replicas of a data object
+func (c *IRODSFSClientDirect) ListReplicas(path string) ([]*irodsclient_types.IRODSReplica, error) {
+	return c.ListReplicasCtx(context.Background(), path)
+}
+
+// ListReplicasCtx lists the replicas of a data object, giving up once ctx is done
+func (c *IRODSFSClientDirect) ListReplicasCtx(ctx context.Context, path string) ([]*irodsclient_types.IRODSReplica, error) {
+	logger := c.logger.WithFields(log.Fields{
+		"path": path,
+	})
+
+	defer util.StackTraceFromPanic(logger)
+
+	var replicas []*irodsclient_types.IRODSReplica
+	err := waitContext(ctx, func() error {
+		var listErr error
+		replicas, listErr = c.fs.ListFileReplicas(path)
+		return listErr
+	})
+	if err != nil {
+		return nil, wrapError("listreplicas", path, err)
+	}
+	return replicas, nil
+}
+
 // TruncateFile truncates a file
 func (c *IRODSFSClientDirect) TruncateFile(path string, size int64) error {
+	return c.TruncateFileCtx(context.Background(), path, size)
+}
+
+// TruncateFileCtx truncates a file
+func (c *IRODSFSClientDirect) TruncateFileCtx(ctx context.Context, path string, size int64) error {
 	logger := c.logger.WithFields(log.Fields{
 		"path": path,
 		"size": size,
@@ -280,105 +503,930 @@ func (c *IRODSFSClientDirect) TruncateFile(path string, size int64) error {
 
 	defer util.StackTraceFromPanic(logger)
 
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	err := c.fs.TruncateFile(path, size)
 	if err != nil {
+		return wrapError("truncate", path, err)
+	}
+	return nil
+}
+
+// Symlink creates a symlink at linkPath pointing to target, replacing a data object at linkPath
+func (c *IRODSFSClientDirect) Symlink(target string, linkPath string) error {
+	return c.SymlinkCtx(context.Background(), target, linkPath)
+}
+
+// SymlinkCtx creates a symlink at linkPath pointing to target, replacing a data object at linkPath
+func (c *IRODSFSClientDirect) SymlinkCtx(ctx context.Context, target string, linkPath string) error {
+	logger := c.logger.WithFields(log.Fields{
+		"target":   target,
+		"linkPath": linkPath,
+	})
+
+	defer util.StackTraceFromPanic(logger)
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := validateSymlinkTarget(linkPath, target); err != nil {
 		return err
 	}
+
+	// The content makes the link readable for clients that do not know the AVU
+	handle, err := c.fs.CreateFile(linkPath, c.options.Resource, string(irodsclient_types.FileOpenModeWriteOnly))
+	if err != nil {
+		return wrapError("symlink", linkPath, err)
+	}
+
+	if _, err := handle.WriteAt([]byte(target), 0); err != nil {
+		handle.Close()
+		return wrapError("symlink", linkPath, err)
+	}
+
+	if err := handle.Close(); err != nil {
+		return wrapError("symlink", linkPath, err)
+	}
+
+	if err := c.setAVU(linkPath, SymlinkAVUName, target); err != nil {
+		return wrapError("symlink", linkPath, err)
+	}
 	return nil
 }
 
-// CacheFile is a no-op for direct client
-func (c *IRODSFSClientDirect) CacheFile(irodsPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+// Readlink returns the target of a symlink
+func (c *IRODSFSClientDirect) Readlink(path string) (string, error) {
+	return c.ReadlinkCtx(context.Background(), path)
+}
+
+// ReadlinkCtx returns the target of a symlink, giving up once ctx is done
+func (c *IRODSFSClientDirect) ReadlinkCtx(ctx context.Context, path string) (string, error) {
+	logger := c.logger.WithFields(log.Fields{
+		"path": path,
+	})
+
+	defer util.StackTraceFromPanic(logger)
+
+	var metas []*irodsclient_types.IRODSMeta
+	err := waitContext(ctx, func() error {
+		var listErr error
+		metas, listErr = c.fs.ListMetadata(path)
+		return listErr
+	})
+	if err != nil {
+		return "", wrapError("readlink", path, err)
+	}
+
+	meta := findAVU(metas, SymlinkAVUName)
+	if meta == nil {
+		return "", newError("readlink", path, ErrInvalid, errors.New("not a symlink"))
+	}
+	return meta.Value, nil
+}
+
+// GetXattr returns the value of an extended attribute
+func (c *IRODSFSClientDirect) GetXattr(path string, name string) ([]byte, error) {
+	return c.GetXattrCtx(context.Background(), path, name)
+}
+
+// GetXattrCtx returns the value of an extended attribute, giving up once ctx is done
+func (c *IRODSFSClientDirect) GetXattrCtx(ctx context.Context, path string, name string) ([]byte, error) {
+	logger := c.logger.WithFields(log.Fields{
+		"path": path,
+		"name": name,
+	})
+
+	defer util.StackTraceFromPanic(logger)
+
+	var metas []*irodsclient_types.IRODSMeta
+	err := waitContext(ctx, func() error {
+		var listErr error
+		metas, listErr = c.fs.ListMetadata(path)
+		return listErr
+	})
+	if err != nil {
+		return nil, wrapError("getxattr", path, err)
+	}
+
+	meta := findAVU(metas, XattrAVUPrefix+name)
+	if meta == nil {
+		return nil, newError("getxattr", path, ErrNoAttribute, nil)
+	}
+
+	value, err := decodeXattrValue(meta.Value)
+	if err != nil {
+		return nil, wrapError("getxattr", path, err)
+	}
+	return value, nil
+}
+
+// SetXattr sets the value of an extended attribute
+func (c *IRODSFSClientDirect) SetXattr(path string, name string, value []byte) error {
+	return c.SetXattrCtx(context.Background(), path, name, value)
+}
+
+// SetXattrCtx sets the value of an extended attribute
+func (c *IRODSFSClientDirect) SetXattrCtx(ctx context.Context, path string, name string, value []byte) error {
+	logger := c.logger.WithFields(log.Fields{
+		"path": path,
+		"name": name,
+	})
+
+	defer util.StackTraceFromPanic(logger)
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := validateXattrName("setxattr", path, name); err != nil {
+		return err
+	}
+
+	if err := c.setAVU(path, XattrAVUPrefix+name, encodeXattrValue(value)); err != nil {
+		return wrapError("setxattr", path, err)
+	}
 	return nil
 }
 
-// DownloadFile downloads a file from iRODS to local filesystem
-func (c *IRODSFSClientDirect) DownloadFile(irodsPath string, localPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+// ListXattr returns the names of extended attributes
+func (c *IRODSFSClientDirect) ListXattr(path string) ([]string, error) {
+	return c.ListXattrCtx(context.Background(), path)
+}
+
+// ListXattrCtx returns the names of extended attributes, giving up once ctx is done
+func (c *IRODSFSClientDirect) ListXattrCtx(ctx context.Context, path string) ([]string, error) {
 	logger := c.logger.WithFields(log.Fields{
-		"irodsPath": irodsPath,
-		"localPath": localPath,
+		"path": path,
 	})
 
 	defer util.StackTraceFromPanic(logger)
 
-	_, err := c.fs.DownloadFile(irodsPath, "", localPath, false, transferCallback)
-	return err
+	var metas []*irodsclient_types.IRODSMeta
+	err := waitContext(ctx, func() error {
+		var listErr error
+		metas, listErr = c.fs.ListMetadata(path)
+		return listErr
+	})
+	if err != nil {
+		return nil, wrapError("listxattr", path, err)
+	}
+	return xattrNames(metas), nil
 }
 
-// DownloadFileParallel downloads a file from iRODS to local filesystem in parallel
-func (c *IRODSFSClientDirect) DownloadFileParallel(irodsPath string, localPath string, taskNum int, transferCallback irodsclient_common.TransferTrackerCallback) error {
+// RemoveXattr removes an extended attribute
+func (c *IRODSFSClientDirect) RemoveXattr(path string, name string) error {
+	return c.RemoveXattrCtx(context.Background(), path, name)
+}
+
+// RemoveXattrCtx removes an extended attribute
+func (c *IRODSFSClientDirect) RemoveXattrCtx(ctx context.Context, path string, name string) error {
 	logger := c.logger.WithFields(log.Fields{
-		"irodsPath": irodsPath,
-		"localPath": localPath,
-		"taskNum":   taskNum,
+		"path": path,
+		"name": name,
 	})
 
 	defer util.StackTraceFromPanic(logger)
 
-	_, err := c.fs.DownloadFileParallel(irodsPath, "", localPath, taskNum, false, transferCallback)
-	return err
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	removed, err := c.removeAVUs(path, XattrAVUPrefix+name)
+	if err != nil {
+		return wrapError("removexattr", path, err)
+	}
+	if removed == 0 {
+		return newError("removexattr", path, ErrNoAttribute, nil)
+	}
+	return nil
 }
 
-func (c *IRODSFSClientDirect) DownloadFileWithCallback(irodsPath string, blockSize int, numBlocks int, blockReadyCallback irodsclient_common.DataObjectBlockCallback, transferCallback irodsclient_common.TransferTrackerCallback) error {
+// GetACL returns the access control list of a data object or collection
+func (c *IRODSFSClientDirect) GetACL(path string) ([]*irodsclient_types.IRODSAccess, error) {
+	return c.GetACLCtx(context.Background(), path)
+}
+
+// GetACLCtx returns the access control list of a data object or collection, giving up once ctx is done
+func (c *IRODSFSClientDirect) GetACLCtx(ctx context.Context, path string) ([]*irodsclient_types.IRODSAccess, error) {
 	logger := c.logger.WithFields(log.Fields{
-		"irodsPath": irodsPath,
-		"blockSize": blockSize,
-		"numBlocks": numBlocks,
+		"path": path,
+	})
+
+	defer util.StackTraceFromPanic(logger)
+
+	var accesses []*irodsclient_types.IRODSAccess
+	err := waitContext(ctx, func() error {
+		var listErr error
+		accesses, listErr = c.fs.ListACLs(path)
+		return listErr
+	})
+	if err != nil {
+		return nil, wrapError("getacl", path, err)
+	}
+	return accesses, nil
+}
+
+// SetACL gives a user or group access to a data object or collection, replacing its current access.
+// IRODSAccessLevelNull removes the access.
+func (c *IRODSFSClientDirect) SetACL(path string, userName string, zoneName string, access irodsclient_types.IRODSAccessLevelType, recurse bool) error {
+	return c.SetACLCtx(context.Background(), path, userName, zoneName, access, recurse)
+}
+
+// SetACLCtx gives a user or group access to a data object or collection, replacing its current access.
+// IRODSAccessLevelNull removes the access.
+func (c *IRODSFSClientDirect) SetACLCtx(ctx context.Context, path string, userName string, zoneName string, access irodsclient_types.IRODSAccessLevelType, recurse bool) error {
+	logger := c.logger.WithFields(log.Fields{
+		"path":     path,
+		"userName": userName,
+		"zoneName": zoneName,
+		"access":   access,
+		"recurse":  recurse,
+	})
+
+	defer util.StackTraceFromPanic(logger)
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	err := c.fs.ChangeACLs(path, access, userName, zoneName, recurse, false)
+	if err != nil {
+		return wrapError("setacl", path, err)
+	}
+	return nil
+}
+
+// SetInheritance sets whether new entries in a collection inherit its access control list
+func (c *IRODSFSClientDirect) SetInheritance(path string, inherit bool, recurse bool) error {
+	return c.SetInheritanceCtx(context.Background(), path, inherit, recurse)
+}
+
+// SetInheritanceCtx sets whether new entries in a collection inherit its access control list
+func (c *IRODSFSClientDirect) SetInheritanceCtx(ctx context.Context, path string, inherit bool, recurse bool) error {
+	logger := c.logger.WithFields(log.Fields{
+		"path":    path,
+		"inherit": inherit,
+		"recurse": recurse,
+	})
+
+	defer util.StackTraceFromPanic(logger)
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	err := c.fs.ChangeDirACLInheritance(path, inherit, recurse, false)
+	if err != nil {
+		return wrapError("setinheritance", path, err)
+	}
+	return nil
+}
+
+// CopyFileToFile copies a file with an iRODS server-side copy, replacing a data object at destPath.
+// Symlinks are copied as symlinks.
+func (c *IRODSFSClientDirect) CopyFileToFile(srcPath string, destPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.CopyFileToFileCtx(context.Background(), srcPath, destPath, transferCallback)
+}
+
+// CopyFileToFileCtx copies a file with an iRODS server-side copy, replacing a data object at destPath.
+// Symlinks are copied as symlinks.
+func (c *IRODSFSClientDirect) CopyFileToFileCtx(ctx context.Context, srcPath string, destPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	logger := c.logger.WithFields(log.Fields{
+		"srcPath":  srcPath,
+		"destPath": destPath,
+	})
+
+	defer util.StackTraceFromPanic(logger)
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := validateCopyPaths(srcPath, destPath); err != nil {
+		return err
+	}
+
+	entry, err := c.StatCtx(ctx, srcPath)
+	if err != nil {
+		return err
+	}
+
+	switch entry.Type {
+	case irodsclient_fs.DirectoryEntry:
+		return newError("copy", srcPath, ErrInvalid, errors.New("is a directory"))
+	case SymlinkEntry:
+		target, err := c.ReadlinkCtx(ctx, srcPath)
+		if err != nil {
+			return err
+		}
+		if err := c.SymlinkCtx(ctx, target, destPath); err != nil {
+			return err
+		}
+		if transferCallback != nil {
+			transferCallback(CopyTaskName, entry.Size, entry.Size)
+		}
+		return nil
+	}
+
+	if transferCallback != nil {
+		transferCallback(CopyTaskName, 0, entry.Size)
+	}
+
+	if err := c.fs.CopyFileToFile(srcPath, destPath, true); err != nil {
+		return wrapError("copy", srcPath, err)
+	}
+
+	if transferCallback != nil {
+		transferCallback(CopyTaskName, entry.Size, entry.Size)
+	}
+	return nil
+}
+
+// CopyDirToDir copies a directory recursively with iRODS server-side copies. destPath is created if
+// it does not exist.
+func (c *IRODSFSClientDirect) CopyDirToDir(srcPath string, destPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.CopyDirToDirCtx(context.Background(), srcPath, destPath, transferCallback)
+}
+
+// CopyDirToDirCtx copies a directory recursively with iRODS server-side copies. destPath is created if
+// it does not exist. The copy stops before the next file once ctx is done.
+func (c *IRODSFSClientDirect) CopyDirToDirCtx(ctx context.Context, srcPath string, destPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	logger := c.logger.WithFields(log.Fields{
+		"srcPath":  srcPath,
+		"destPath": destPath,
 	})
 
 	defer util.StackTraceFromPanic(logger)
 
-	_, err := c.fs.DownloadFileWithCallback(irodsPath, "", blockSize, numBlocks, blockReadyCallback, transferCallback)
-	return err
+	return copyDir(ctx, c, srcPath, destPath, transferCallback)
+}
+
+// setAVU sets the only AVU named name of path to value
+func (c *IRODSFSClientDirect) setAVU(path string, name string, value string) error {
+	metas, err := c.fs.ListMetadata(path)
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for _, meta := range metas {
+		if meta.Name != name {
+			continue
+		}
+
+		if !found && meta.Value == value && meta.Units == "" {
+			found = true
+			continue
+		}
+
+		if err := c.fs.DeleteMetadataByAVU(path, meta.Name, meta.Value, meta.Units); err != nil {
+			return err
+		}
+	}
+
+	if found {
+		return nil
+	}
+	return c.fs.AddMetadata(path, name, value, "")
+}
+
+// removeAVUs removes all AVUs named name of path, returning how many there were
+func (c *IRODSFSClientDirect) removeAVUs(path string, name string) (int, error) {
+	metas, err := c.fs.ListMetadata(path)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, meta := range metas {
+		if meta.Name != name {
+			continue
+		}
+
+		if err := c.fs.DeleteMetadataByAVU(path, meta.Name, meta.Value, meta.Units); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// CacheFile is a no-op for direct client
+func (c *IRODSFSClientDirect) CacheFile(irodsPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.CacheFileCtx(context.Background(), irodsPath, transferCallback)
+}
+
+// CacheFileCtx is a no-op for direct client
+func (c *IRODSFSClientDirect) CacheFileCtx(ctx context.Context, irodsPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return nil
+}
+
+// GetTransferManager returns the manager that queues and tracks the transfers of the client
+func (c *IRODSFSClientDirect) GetTransferManager() *TransferManager {
+	return c.transfers
+}
+
+// DownloadFile downloads a file from iRODS to local filesystem
+func (c *IRODSFSClientDirect) DownloadFile(irodsPath string, localPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.DownloadFileWithOptionsCtx(context.Background(), irodsPath, localPath, &TransferOptions{TransferCallback: transferCallback})
+}
+
+// DownloadFileCtx downloads a file from iRODS to local filesystem
+func (c *IRODSFSClientDirect) DownloadFileCtx(ctx context.Context, irodsPath string, localPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.DownloadFileWithOptionsCtx(ctx, irodsPath, localPath, &TransferOptions{TransferCallback: transferCallback})
+}
+
+// DownloadFileParallel downloads a file from iRODS to local filesystem in parallel
+func (c *IRODSFSClientDirect) DownloadFileParallel(irodsPath string, localPath string, taskNum int, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.DownloadFileWithOptionsCtx(context.Background(), irodsPath, localPath, &TransferOptions{TaskNum: taskNum, TransferCallback: transferCallback})
+}
+
+// DownloadFileParallelCtx downloads a file from iRODS to local filesystem in parallel
+func (c *IRODSFSClientDirect) DownloadFileParallelCtx(ctx context.Context, irodsPath string, localPath string, taskNum int, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.DownloadFileWithOptionsCtx(ctx, irodsPath, localPath, &TransferOptions{TaskNum: taskNum, TransferCallback: transferCallback})
+}
+
+func (c *IRODSFSClientDirect) DownloadFileWithCallback(irodsPath string, blockSize int, numBlocks int, blockReadyCallback irodsclient_common.DataObjectBlockCallback, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.DownloadFileWithCallbackCtx(context.Background(), irodsPath, blockSize, numBlocks, blockReadyCallback, transferCallback)
+}
+
+func (c *IRODSFSClientDirect) DownloadFileWithCallbackCtx(ctx context.Context, irodsPath string, blockSize int, numBlocks int, blockReadyCallback irodsclient_common.DataObjectBlockCallback, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.DownloadFileWithOptionsCtx(ctx, irodsPath, "", &TransferOptions{
+		BlockSize:          blockSize,
+		NumBlocks:          numBlocks,
+		BlockReadyCallback: blockReadyCallback,
+		TransferCallback:   transferCallback,
+	})
 }
 
 func (c *IRODSFSClientDirect) DownloadFileParallelWithCallback(irodsPath string, blockSize int, numBlocks int, blockReadyCallback irodsclient_common.DataObjectBlockCallback, taskNum int, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.DownloadFileParallelWithCallbackCtx(context.Background(), irodsPath, blockSize, numBlocks, blockReadyCallback, taskNum, transferCallback)
+}
+
+func (c *IRODSFSClientDirect) DownloadFileParallelWithCallbackCtx(ctx context.Context, irodsPath string, blockSize int, numBlocks int, blockReadyCallback irodsclient_common.DataObjectBlockCallback, taskNum int, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.DownloadFileWithOptionsCtx(ctx, irodsPath, "", &TransferOptions{
+		TaskNum:            taskNum,
+		BlockSize:          blockSize,
+		NumBlocks:          numBlocks,
+		BlockReadyCallback: blockReadyCallback,
+		TransferCallback:   transferCallback,
+	})
+}
+
+// DownloadFileWithOptions downloads a file from iRODS to localPath, or to options.BlockReadyCallback
+// if localPath is empty
+func (c *IRODSFSClientDirect) DownloadFileWithOptions(irodsPath string, localPath string, options *TransferOptions) error {
+	return c.DownloadFileWithOptionsCtx(context.Background(), irodsPath, localPath, options)
+}
+
+// DownloadFileWithOptionsCtx downloads a file from iRODS to localPath, or to options.BlockReadyCallback
+// if localPath is empty. The download is queued in the transfer manager of the client and stops at the
+// next block once ctx is done.
+func (c *IRODSFSClientDirect) DownloadFileWithOptionsCtx(ctx context.Context, irodsPath string, localPath string, options *TransferOptions) error {
+	return c.transfers.runTransfer(ctx, TransferDownload, irodsPath, localPath, options.withDefaults(nil), func(ctx context.Context, options *TransferOptions) error {
+		return c.download(ctx, irodsPath, localPath, options)
+	})
+}
+
+// download downloads a file outside of the transfer manager
+func (c *IRODSFSClientDirect) download(ctx context.Context, irodsPath string, localPath string, options *TransferOptions) error {
 	logger := c.logger.WithFields(log.Fields{
 		"irodsPath": irodsPath,
-		"blockSize": blockSize,
-		"numBlocks": numBlocks,
-		"taskNum":   taskNum,
+		"localPath": localPath,
+		"taskNum":   options.TaskNum,
 	})
 
 	defer util.StackTraceFromPanic(logger)
 
-	_, err := c.fs.DownloadFileParallelWithCallback(irodsPath, "", blockSize, numBlocks, blockReadyCallback, taskNum, transferCallback)
-	return err
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	resource := options.Resource
+	if resource == "" {
+		var err error
+		resource, _, err = c.readReplica(ctx, irodsPath, c.options)
+		if err != nil {
+			return err
+		}
+	}
+
+	downloadBlocks := func(blockReadyCallback irodsclient_common.DataObjectBlockCallback) error {
+		var err error
+		if options.TaskNum > 1 {
+			_, err = c.fs.DownloadFileParallelWithCallback(irodsPath, resource, options.BlockSize, options.NumBlocks, contextBlockCallback(ctx, blockReadyCallback), options.TaskNum, options.TransferCallback)
+		} else {
+			_, err = c.fs.DownloadFileWithCallback(irodsPath, resource, options.BlockSize, options.NumBlocks, contextBlockCallback(ctx, blockReadyCallback), options.TransferCallback)
+		}
+		return err
+	}
+
+	// Verification needs a whole-file download; it cannot be interrupted, only abandoned
+	downloadVerified := func() error {
+		return waitContext(ctx, func() error {
+			var downloadErr error
+			if options.TaskNum > 1 {
+				_, downloadErr = c.fs.DownloadFileParallel(irodsPath, resource, localPath, options.TaskNum, true, options.TransferCallback)
+			} else {
+				_, downloadErr = c.fs.DownloadFile(irodsPath, resource, localPath, true, options.TransferCallback)
+			}
+			return downloadErr
+		})
+	}
+
+	var err error
+	switch {
+	case localPath == "":
+		err = downloadBlocks(options.BlockReadyCallback)
+	case options.Resume:
+		err = c.resumeDownload(ctx, irodsPath, resource, localPath, options)
+		if err == nil && options.VerifyChecksum {
+			// The kept data may not match the data object, download it whole if it does not
+			if verifyErr := c.verifyContent(ctx, localPath, irodsPath, resource); verifyErr != nil {
+				logger.Warnf("resumed download does not match, downloading the whole file: %v", verifyErr)
+				err = downloadVerified()
+			}
+		}
+	case options.VerifyChecksum:
+		err = downloadVerified()
+	default:
+		// Downloads go block by block so that they stop once ctx is done
+		err = downloadFileByBlocks(ctx, localPath, downloadBlocks)
+	}
+	return wrapError("download", irodsPath, err)
+}
+
+// resumeDownload downloads the part of a file missing from localPath. A local file larger than the data
+// object is downloaded again, one of the same size is taken as complete.
+func (c *IRODSFSClientDirect) resumeDownload(ctx context.Context, irodsPath string, resource string, localPath string, options *TransferOptions) error {
+	entry, err := c.StatCtx(ctx, irodsPath)
+	if err != nil {
+		return err
+	}
+
+	localSize := int64(0)
+	if info, statErr := os.Stat(localPath); statErr == nil {
+		localSize = info.Size()
+	}
+
+	if localSize == entry.Size {
+		if options.TransferCallback != nil {
+			options.TransferCallback(string(TransferDownload), entry.Size, entry.Size)
+		}
+		return nil
+	}
+	if localSize > entry.Size {
+		localSize = 0
+	}
+
+	localFile, err := os.OpenFile(localPath, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open local file %q", localPath)
+	}
+	defer localFile.Close()
+
+	if err := localFile.Truncate(localSize); err != nil {
+		return errors.Wrapf(err, "failed to truncate local file %q", localPath)
+	}
+
+	handle, err := c.fs.OpenFile(irodsPath, resource, string(irodsclient_types.FileOpenModeReadOnly))
+	if err != nil {
+		return err
+	}
+	defer handle.Close()
+
+	buffer := make([]byte, options.BlockSize)
+	for offset := localSize; offset < entry.Size; {
+		readLen, readErr := readAtContext(ctx, handle.ReadAt, buffer, offset)
+		if readLen > 0 {
+			if _, writeErr := localFile.WriteAt(buffer[:readLen], offset); writeErr != nil {
+				return errors.Wrapf(writeErr, "failed to write local file %q", localPath)
+			}
+			offset += int64(readLen)
+
+			if options.TransferCallback != nil {
+				options.TransferCallback(string(TransferDownload), offset, entry.Size)
+			}
+		}
+
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+
+	return localFile.Close()
 }
 
 // UploadFile uploads a file from local filesystem to iRODS
 func (c *IRODSFSClientDirect) UploadFile(localPath string, irodsPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.UploadFileWithOptionsCtx(context.Background(), localPath, irodsPath, &TransferOptions{TransferCallback: transferCallback})
+}
+
+// UploadFileCtx uploads a file from local filesystem to iRODS
+func (c *IRODSFSClientDirect) UploadFileCtx(ctx context.Context, localPath string, irodsPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.UploadFileWithOptionsCtx(ctx, localPath, irodsPath, &TransferOptions{TransferCallback: transferCallback})
+}
+
+// UploadFileParallel uploads a file from local filesystem to iRODS in parallel
+func (c *IRODSFSClientDirect) UploadFileParallel(localPath string, irodsPath string, taskNum int, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.UploadFileWithOptionsCtx(context.Background(), localPath, irodsPath, &TransferOptions{TaskNum: taskNum, TransferCallback: transferCallback})
+}
+
+// UploadFileParallelCtx uploads a file from local filesystem to iRODS in parallel
+func (c *IRODSFSClientDirect) UploadFileParallelCtx(ctx context.Context, localPath string, irodsPath string, taskNum int, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.UploadFileWithOptionsCtx(ctx, localPath, irodsPath, &TransferOptions{TaskNum: taskNum, TransferCallback: transferCallback})
+}
+
+// UploadFileWithOptions uploads a file from local filesystem to iRODS
+func (c *IRODSFSClientDirect) UploadFileWithOptions(localPath string, irodsPath string, options *TransferOptions) error {
+	return c.UploadFileWithOptionsCtx(context.Background(), localPath, irodsPath, options)
+}
+
+// UploadFileWithOptionsCtx uploads a file from local filesystem to iRODS. The upload is queued in the
+// transfer manager of the client; it does not start once ctx is done but is not interrupted.
+func (c *IRODSFSClientDirect) UploadFileWithOptionsCtx(ctx context.Context, localPath string, irodsPath string, options *TransferOptions) error {
+	return c.transfers.runTransfer(ctx, TransferUpload, irodsPath, localPath, options.withDefaults(nil), func(ctx context.Context, options *TransferOptions) error {
+		return c.upload(ctx, localPath, irodsPath, options)
+	})
+}
+
+// upload uploads a file outside of the transfer manager
+func (c *IRODSFSClientDirect) upload(ctx context.Context, localPath string, irodsPath string, options *TransferOptions) error {
 	logger := c.logger.WithFields(log.Fields{
 		"localPath": localPath,
 		"irodsPath": irodsPath,
+		"taskNum":   options.TaskNum,
 	})
 
 	defer util.StackTraceFromPanic(logger)
 
-	_, err := c.fs.UploadFile(localPath, irodsPath, "", false, false, transferCallback)
-	return err
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	resource := options.Resource
+	if resource == "" {
+		resource = c.options.Resource
+	}
+
+	if options.Resume {
+		done, err := c.resumeUpload(ctx, localPath, irodsPath, resource, options)
+		if err != nil {
+			return err
+		}
+
+		if done {
+			if !options.VerifyChecksum {
+				return nil
+			}
+
+			// The kept data may not match the local file, upload it whole if it does not
+			verifyErr := c.verifyContent(ctx, localPath, irodsPath, resource)
+			if verifyErr == nil {
+				return nil
+			}
+			logger.Warnf("resumed upload does not match, uploading the whole file: %v", verifyErr)
+		}
+	}
+
+	var err error
+	if options.TaskNum > 1 {
+		_, err = c.fs.UploadFileParallel(localPath, irodsPath, resource, options.TaskNum, false, options.VerifyChecksum, options.TransferCallback)
+	} else {
+		_, err = c.fs.UploadFile(localPath, irodsPath, resource, false, options.VerifyChecksum, options.TransferCallback)
+	}
+	return wrapError("upload", irodsPath, err)
 }
 
-// UploadFileParallel uploads a file from local filesystem to iRODS in parallel
-func (c *IRODSFSClientDirect) UploadFileParallel(localPath string, irodsPath string, taskNum int, transferCallback irodsclient_common.TransferTrackerCallback) error {
+// resumeUpload uploads the part of a local file missing from the data object at irodsPath.
+// Returns false if the whole file must be uploaded instead.
+func (c *IRODSFSClientDirect) resumeUpload(ctx context.Context, localPath string, irodsPath string, resource string, options *TransferOptions) (bool, error) {
+	localInfo, err := os.Stat(localPath)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to stat local file %q", localPath)
+	}
+
+	entry, err := c.StatCtx(ctx, irodsPath)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	switch {
+	case entry.Size == localInfo.Size():
+		if options.TransferCallback != nil {
+			options.TransferCallback(string(TransferUpload), entry.Size, entry.Size)
+		}
+		return true, nil
+	case entry.Size == 0 || entry.Size > localInfo.Size():
+		return false, nil
+	}
+
+	return true, c.uploadRange(localPath, irodsPath, resource, entry.Size, localInfo.Size()-entry.Size, options.TransferCallback)
+}
+
+// UploadDir uploads a local directory recursively to iRODS
+func (c *IRODSFSClientDirect) UploadDir(localDir string, irodsDir string, options *DirTransferOptions) (*DirTransferReport, error) {
+	return c.UploadDirCtx(context.Background(), localDir, irodsDir, options)
+}
+
+// UploadDirCtx uploads a local directory recursively to iRODS
+func (c *IRODSFSClientDirect) UploadDirCtx(ctx context.Context, localDir string, irodsDir string, options *DirTransferOptions) (*DirTransferReport, error) {
+	return uploadDir(ctx, c, localDir, irodsDir, options)
+}
+
+// DownloadDir downloads an iRODS collection recursively to a local directory
+func (c *IRODSFSClientDirect) DownloadDir(irodsDir string, localDir string, options *DirTransferOptions) (*DirTransferReport, error) {
+	return c.DownloadDirCtx(context.Background(), irodsDir, localDir, options)
+}
+
+// DownloadDirCtx downloads an iRODS collection recursively to a local directory
+func (c *IRODSFSClientDirect) DownloadDirCtx(ctx context.Context, irodsDir string, localDir string, options *DirTransferOptions) (*DirTransferReport, error) {
+	return downloadDir(ctx, c, irodsDir, localDir, options)
+}
+
+// UploadFileRange uploads a byte range of a local file into the same range of a data object,
+// creating the data object if it does not exist
+func (c *IRODSFSClientDirect) UploadFileRange(localPath string, irodsPath string, offset int64, length int64, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.uploadRange(localPath, irodsPath, c.options.Resource, offset, length, transferCallback)
+}
+
+// uploadRange uploads a byte range of a local file to a data object on resource
+func (c *IRODSFSClientDirect) uploadRange(localPath string, irodsPath string, resource string, offset int64, length int64, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	logger := c.logger.WithFields(log.Fields{
+		"localPath": localPath,
+		"irodsPath": irodsPath,
+		"offset":    offset,
+		"length":    length,
+	})
+
+	defer util.StackTraceFromPanic(logger)
+
+	localFile, err := os.Open(localPath)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open local file %q", localPath)
+	}
+	defer localFile.Close()
+
+	var handle *irodsclient_fs.FileHandle
+	if c.fs.ExistsFile(irodsPath) {
+		handle, err = c.fs.OpenFile(irodsPath, resource, string(irodsclient_types.FileOpenModeReadWrite))
+	} else {
+		handle, err = c.fs.CreateFile(irodsPath, resource, string(irodsclient_types.FileOpenModeWriteOnly))
+	}
+	if err != nil {
+		return wrapError("upload", irodsPath, err)
+	}
+
+	buffer := make([]byte, min(length, 4*1024*1024))
+	var written int64
+	for written < length {
+		toRead := min(int64(len(buffer)), length-written)
+		readLen, readErr := localFile.ReadAt(buffer[:toRead], offset+written)
+		if readLen > 0 {
+			if _, writeErr := handle.WriteAt(buffer[:readLen], offset+written); writeErr != nil {
+				handle.Close()
+				return wrapError("upload", irodsPath, writeErr)
+			}
+			written += int64(readLen)
+
+			if transferCallback != nil {
+				transferCallback("upload", written, length)
+			}
+		}
+
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			handle.Close()
+			return errors.Wrapf(readErr, "failed to read local file %q", localPath)
+		}
+	}
+
+	return wrapError("upload", irodsPath, handle.Close())
+}
+
+// GetDataObjectSize returns the size of the data object at path
+func (c *IRODSFSClientDirect) GetDataObjectSize(path string) (int64, error) {
+	logger := c.logger.WithFields(log.Fields{
+		"path": path,
+	})
+
+	defer util.StackTraceFromPanic(logger)
+
+	entry, err := c.fs.Stat(path)
+	if err != nil {
+		return 0, wrapError("stat", path, err)
+	}
+	return entry.Size, nil
+}
+
+// VerifyUpload checks that the data object at irodsPath has the content of the local file.
+// The checksum iRODS recorded for the data object is used if there is one; otherwise the data object
+// is read back and compared.
+func (c *IRODSFSClientDirect) VerifyUpload(localPath string, irodsPath string) error {
 	logger := c.logger.WithFields(log.Fields{
 		"localPath": localPath,
 		"irodsPath": irodsPath,
-		"taskNum":   taskNum,
 	})
 
 	defer util.StackTraceFromPanic(logger)
 
-	_, err := c.fs.UploadFileParallel(localPath, irodsPath, "", taskNum, false, false, transferCallback)
-	return err
+	return c.verifyContent(context.Background(), localPath, irodsPath, c.options.Resource)
+}
+
+// verifyContent checks that the data object at irodsPath has the content of the local file, by the
+// checksum iRODS recorded or by reading the data object back from resource
+func (c *IRODSFSClientDirect) verifyContent(ctx context.Context, localPath string, irodsPath string, resource string) error {
+	localInfo, err := os.Stat(localPath)
+	if err != nil {
+		return errors.Wrapf(err, "failed to stat local file %q", localPath)
+	}
+
+	entry, err := c.fs.Stat(irodsPath)
+	if err != nil {
+		return wrapError("stat", irodsPath, err)
+	}
+
+	if entry.Size != localInfo.Size() {
+		return errors.Newf("size mismatch for %q, expected %d but got %d", irodsPath, localInfo.Size(), entry.Size)
+	}
+
+	if len(entry.CheckSum) > 0 && newChecksumHash(string(entry.CheckSumAlgorithm)) != nil {
+		matches, err := matchesIRODSChecksum(localPath, entry)
+		if err != nil {
+			return err
+		}
+		if !matches {
+			return errors.Newf("checksum mismatch for %q", irodsPath)
+		}
+		return nil
+	}
+
+	// No usable checksum recorded, compare the content
+	localSum, err := hashLocalFile(localPath)
+	if err != nil {
+		return err
+	}
+
+	handle, err := c.fs.OpenFile(irodsPath, resource, string(irodsclient_types.FileOpenModeReadOnly))
+	if err != nil {
+		return wrapError("verify", irodsPath, err)
+	}
+	defer handle.Close()
+
+	h := sha256.New()
+	buffer := make([]byte, DefaultTransferBlockSize)
+	for offset := int64(0); offset < entry.Size; {
+		readLen, readErr := readAtContext(ctx, handle.ReadAt, buffer, offset)
+		h.Write(buffer[:readLen])
+		offset += int64(readLen)
+
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return wrapError("verify", irodsPath, readErr)
+		}
+	}
+
+	if !bytes.Equal(h.Sum(nil), localSum) {
+		return errors.Newf("content mismatch for %q", irodsPath)
+	}
+	return nil
+}
+
+// hashLocalFile returns the SHA-256 hash of a local file
+func hashLocalFile(localPath string) ([]byte, error) {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open local file %q", localPath)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, errors.Wrapf(err, "failed to read local file %q", localPath)
+	}
+	return h.Sum(nil), nil
 }
 
 // IRODSFSClientDirectFileHandle implements IRODSFSFileHandle
 type IRODSFSClientDirectFileHandle struct {
-	id     string
-	client *IRODSFSClientDirect
-	handle *irodsclient_fs.FileHandle
-	logger *log.Entry
+	id      string
+	client  *IRODSFSClientDirect
+	handle  *irodsclient_fs.FileHandle
+	replica *irodsclient_types.IRODSReplica
+	logger  *log.Entry
 }
 
 func (h *IRODSFSClientDirectFileHandle) GetID() string {
@@ -389,6 +1437,11 @@ func (h *IRODSFSClientDirectFileHandle) GetEntry() *irodsclient_fs.Entry {
 	return h.handle.GetEntry()
 }
 
+// GetReplica returns the replica reads are pinned to, or nil if the server chooses
+func (h *IRODSFSClientDirectFileHandle) GetReplica() *irodsclient_types.IRODSReplica {
+	return h.replica
+}
+
 func (h *IRODSFSClientDirectFileHandle) GetOpenMode() irodsclient_types.FileOpenMode {
 	return h.handle.GetOpenMode()
 }
@@ -402,11 +1455,15 @@ func (h *IRODSFSClientDirectFileHandle) IsWriteMode() bool {
 }
 
 func (h *IRODSFSClientDirectFileHandle) ReadAt(buffer []byte, offset int64) (int, error) {
+	return h.ReadAtCtx(context.Background(), buffer, offset)
+}
+
+func (h *IRODSFSClientDirectFileHandle) ReadAtCtx(ctx context.Context, buffer []byte, offset int64) (int, error) {
 	defer util.StackTraceFromPanic(h.logger)
 
-	readLen, err := h.handle.ReadAt(buffer, offset)
+	readLen, err := readAtContext(ctx, h.handle.ReadAt, buffer, offset)
 	if err != nil && err != io.EOF {
-		return readLen, err
+		return readLen, wrapError("read", h.handle.GetEntry().Path, err)
 	}
 	return readLen, err
 }
@@ -417,26 +1474,46 @@ func (h *IRODSFSClientDirectFileHandle) GetAvailable(offset int64) int64 {
 }
 
 func (h *IRODSFSClientDirectFileHandle) WriteAt(data []byte, offset int64) (int, error) {
+	return h.WriteAtCtx(context.Background(), data, offset)
+}
+
+func (h *IRODSFSClientDirectFileHandle) WriteAtCtx(ctx context.Context, data []byte, offset int64) (int, error) {
 	defer util.StackTraceFromPanic(h.logger)
 
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
 	writeLen, err := h.handle.WriteAt(data, offset)
 	if err != nil {
-		return writeLen, err
+		return writeLen, wrapError("write", h.handle.GetEntry().Path, err)
 	}
 	return writeLen, nil
 }
 
 func (h *IRODSFSClientDirectFileHandle) Truncate(size int64) error {
+	return h.TruncateCtx(context.Background(), size)
+}
+
+func (h *IRODSFSClientDirectFileHandle) TruncateCtx(ctx context.Context, size int64) error {
 	defer util.StackTraceFromPanic(h.logger)
 
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	err := h.handle.Truncate(size)
 	if err != nil {
-		return err
+		return wrapError("truncate", h.handle.GetEntry().Path, err)
 	}
 	return nil
 }
 
 func (h *IRODSFSClientDirectFileHandle) Flush() error {
+	return h.FlushCtx(context.Background())
+}
+
+func (h *IRODSFSClientDirectFileHandle) FlushCtx(ctx context.Context) error {
 	return nil
 }
 
@@ -445,7 +1522,7 @@ func (h *IRODSFSClientDirectFileHandle) Close() error {
 
 	err := h.handle.Close()
 	if err != nil {
-		return err
+		return wrapError("close", h.handle.GetEntry().Path, err)
 	}
 	return nil
 }
@@ -0,0 +1,761 @@
+package irods
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/cockroachdb/errors"
+	irodsclient_fs "github.com/cyverse/go-irodsclient/fs"
+	irodsclient_common "github.com/cyverse/go-irodsclient/irods/common"
+	irodsclient_metrics "github.com/cyverse/go-irodsclient/irods/metrics"
+	irodsclient_types "github.com/cyverse/go-irodsclient/irods/types"
+)
+
+// FaultInjectionConfig configures the faults an IRODSFSClientFaulty injects, see Fault for the names of
+// operations
+type FaultInjectionConfig struct {
+	Seed   int64   // Seed of the fault decisions; a seed injects the same faults into the same sequence of calls
+	Faults []Fault // Faults injected from the start; more can be added with InjectFault
+}
+
+// IRODSFSClientFaulty is an IRODSFSClient that injects errors, delays, partial reads and writes and
+// dropped connections into the calls of another client, to test how the layers above it cope.
+// Release, GetAccount, GetApplicationName, GetOpenConnections, GetMetrics and GetTransferManager are
+// never faulted. Injected delays end early once the ctx of a call is done.
+type IRODSFSClientFaulty struct {
+	client IRODSFSClientCtx
+	faults *FaultInjector
+}
+
+// NewIRODSFSClientFaulty creates an IRODSFSClientFaulty that injects faults into the calls of client
+func NewIRODSFSClientFaulty(client IRODSFSClient, config *FaultInjectionConfig) (*IRODSFSClientFaulty, error) {
+	if client == nil {
+		return nil, errors.New("client is required")
+	}
+	if config == nil {
+		return nil, errors.New("config is required")
+	}
+
+	return &IRODSFSClientFaulty{
+		client: WithContext(client),
+		faults: NewFaultInjector(config.Seed, config.Faults...),
+	}, nil
+}
+
+// InjectFault adds a fault and returns its ID for RemoveFault
+func (c *IRODSFSClientFaulty) InjectFault(fault Fault) int {
+	return c.faults.InjectFault(fault)
+}
+
+// RemoveFault removes the fault with the given ID
+func (c *IRODSFSClientFaulty) RemoveFault(id int) {
+	c.faults.RemoveFault(id)
+}
+
+// ClearFaults removes all faults
+func (c *IRODSFSClientFaulty) ClearFaults() {
+	c.faults.ClearFaults()
+}
+
+// SetEnabled turns fault injection on or off, e.g. to set up or check the state of iRODS
+func (c *IRODSFSClientFaulty) SetEnabled(enabled bool) {
+	c.faults.SetEnabled(enabled)
+}
+
+// GetInjectedFaults returns the number of faults injected by operation
+func (c *IRODSFSClientFaulty) GetInjectedFaults() map[string]int64 {
+	return c.faults.GetInjectedFaults()
+}
+
+// inject delays a call of op on path and returns the error it fails with, if any
+func (c *IRODSFSClientFaulty) inject(ctx context.Context, op string, path string) error {
+	return c.faults.ApplyCtx(ctx, op, path, FaultFail)
+}
+
+func (c *IRODSFSClientFaulty) Release() {
+	c.client.Release()
+}
+
+func (c *IRODSFSClientFaulty) GetAccount() *irodsclient_types.IRODSAccount {
+	return c.client.GetAccount()
+}
+
+func (c *IRODSFSClientFaulty) GetApplicationName() string {
+	return c.client.GetApplicationName()
+}
+
+func (c *IRODSFSClientFaulty) GetOpenConnections() int {
+	return c.client.GetOpenConnections()
+}
+
+func (c *IRODSFSClientFaulty) GetMetrics() *irodsclient_metrics.IRODSMetrics {
+	return c.client.GetMetrics()
+}
+
+func (c *IRODSFSClientFaulty) List(path string) ([]*irodsclient_fs.Entry, error) {
+	return c.ListCtx(context.Background(), path)
+}
+
+func (c *IRODSFSClientFaulty) ListCtx(ctx context.Context, path string) ([]*irodsclient_fs.Entry, error) {
+	if err := c.inject(ctx, "List", path); err != nil {
+		return nil, err
+	}
+	return c.client.ListCtx(ctx, path)
+}
+
+func (c *IRODSFSClientFaulty) Stat(path string) (*irodsclient_fs.Entry, error) {
+	return c.StatCtx(context.Background(), path)
+}
+
+func (c *IRODSFSClientFaulty) StatCtx(ctx context.Context, path string) (*irodsclient_fs.Entry, error) {
+	if err := c.inject(ctx, "Stat", path); err != nil {
+		return nil, err
+	}
+	return c.client.StatCtx(ctx, path)
+}
+
+func (c *IRODSFSClientFaulty) ExistsDir(path string) bool {
+	return c.ExistsDirCtx(context.Background(), path)
+}
+
+// ExistsDirCtx returns false when a fault is injected, like the client does for failed calls
+func (c *IRODSFSClientFaulty) ExistsDirCtx(ctx context.Context, path string) bool {
+	if err := c.inject(ctx, "ExistsDir", path); err != nil {
+		return false
+	}
+	return c.client.ExistsDirCtx(ctx, path)
+}
+
+func (c *IRODSFSClientFaulty) ExistsFile(path string) bool {
+	return c.ExistsFileCtx(context.Background(), path)
+}
+
+// ExistsFileCtx returns false when a fault is injected, like the client does for failed calls
+func (c *IRODSFSClientFaulty) ExistsFileCtx(ctx context.Context, path string) bool {
+	if err := c.inject(ctx, "ExistsFile", path); err != nil {
+		return false
+	}
+	return c.client.ExistsFileCtx(ctx, path)
+}
+
+func (c *IRODSFSClientFaulty) RemoveFile(path string, force bool) error {
+	return c.RemoveFileCtx(context.Background(), path, force)
+}
+
+func (c *IRODSFSClientFaulty) RemoveFileCtx(ctx context.Context, path string, force bool) error {
+	if err := c.inject(ctx, "RemoveFile", path); err != nil {
+		return err
+	}
+	return c.client.RemoveFileCtx(ctx, path, force)
+}
+
+func (c *IRODSFSClientFaulty) RemoveDir(path string, recurse bool, force bool) error {
+	return c.RemoveDirCtx(context.Background(), path, recurse, force)
+}
+
+func (c *IRODSFSClientFaulty) RemoveDirCtx(ctx context.Context, path string, recurse bool, force bool) error {
+	if err := c.inject(ctx, "RemoveDir", path); err != nil {
+		return err
+	}
+	return c.client.RemoveDirCtx(ctx, path, recurse, force)
+}
+
+func (c *IRODSFSClientFaulty) MakeDir(path string, recurse bool) error {
+	return c.MakeDirCtx(context.Background(), path, recurse)
+}
+
+func (c *IRODSFSClientFaulty) MakeDirCtx(ctx context.Context, path string, recurse bool) error {
+	if err := c.inject(ctx, "MakeDir", path); err != nil {
+		return err
+	}
+	return c.client.MakeDirCtx(ctx, path, recurse)
+}
+
+func (c *IRODSFSClientFaulty) RenameDirToDir(srcPath string, destPath string) error {
+	return c.RenameDirToDirCtx(context.Background(), srcPath, destPath)
+}
+
+func (c *IRODSFSClientFaulty) RenameDirToDirCtx(ctx context.Context, srcPath string, destPath string) error {
+	if err := c.inject(ctx, "RenameDirToDir", srcPath); err != nil {
+		return err
+	}
+	return c.client.RenameDirToDirCtx(ctx, srcPath, destPath)
+}
+
+func (c *IRODSFSClientFaulty) RenameFileToFile(srcPath string, destPath string) error {
+	return c.RenameFileToFileCtx(context.Background(), srcPath, destPath)
+}
+
+func (c *IRODSFSClientFaulty) RenameFileToFileCtx(ctx context.Context, srcPath string, destPath string) error {
+	if err := c.inject(ctx, "RenameFileToFile", srcPath); err != nil {
+		return err
+	}
+	return c.client.RenameFileToFileCtx(ctx, srcPath, destPath)
+}
+
+func (c *IRODSFSClientFaulty) CreateFile(path string, mode string) (IRODSFSFileHandle, error) {
+	return c.CreateFileCtx(context.Background(), path, mode)
+}
+
+func (c *IRODSFSClientFaulty) CreateFileCtx(ctx context.Context, path string, mode string) (IRODSFSFileHandle, error) {
+	if err := c.inject(ctx, "CreateFile", path); err != nil {
+		return nil, err
+	}
+	return c.newFaultyHandle(c.client.CreateFileCtx(ctx, path, mode))
+}
+
+func (c *IRODSFSClientFaulty) OpenFile(path string, mode string) (IRODSFSFileHandle, error) {
+	return c.OpenFileCtx(context.Background(), path, mode)
+}
+
+func (c *IRODSFSClientFaulty) OpenFileCtx(ctx context.Context, path string, mode string) (IRODSFSFileHandle, error) {
+	if err := c.inject(ctx, "OpenFile", path); err != nil {
+		return nil, err
+	}
+	return c.newFaultyHandle(c.client.OpenFileCtx(ctx, path, mode))
+}
+
+func (c *IRODSFSClientFaulty) TruncateFile(path string, size int64) error {
+	return c.TruncateFileCtx(context.Background(), path, size)
+}
+
+func (c *IRODSFSClientFaulty) TruncateFileCtx(ctx context.Context, path string, size int64) error {
+	if err := c.inject(ctx, "TruncateFile", path); err != nil {
+		return err
+	}
+	return c.client.TruncateFileCtx(ctx, path, size)
+}
+
+func (c *IRODSFSClientFaulty) CreateFileWithOptions(path string, mode string, options *OpenOptions) (IRODSFSFileHandle, error) {
+	return c.CreateFileWithOptionsCtx(context.Background(), path, mode, options)
+}
+
+func (c *IRODSFSClientFaulty) CreateFileWithOptionsCtx(ctx context.Context, path string, mode string, options *OpenOptions) (IRODSFSFileHandle, error) {
+	if err := c.inject(ctx, "CreateFile", path); err != nil {
+		return nil, err
+	}
+	return c.newFaultyHandle(c.client.CreateFileWithOptionsCtx(ctx, path, mode, options))
+}
+
+func (c *IRODSFSClientFaulty) OpenFileWithOptions(path string, mode string, options *OpenOptions) (IRODSFSFileHandle, error) {
+	return c.OpenFileWithOptionsCtx(context.Background(), path, mode, options)
+}
+
+func (c *IRODSFSClientFaulty) OpenFileWithOptionsCtx(ctx context.Context, path string, mode string, options *OpenOptions) (IRODSFSFileHandle, error) {
+	if err := c.inject(ctx, "OpenFile", path); err != nil {
+		return nil, err
+	}
+	return c.newFaultyHandle(c.client.OpenFileWithOptionsCtx(ctx, path, mode, options))
+}
+
+func (c *IRODSFSClientFaulty) ListReplicas(path string) ([]*irodsclient_types.IRODSReplica, error) {
+	return c.ListReplicasCtx(context.Background(), path)
+}
+
+func (c *IRODSFSClientFaulty) ListReplicasCtx(ctx context.Context, path string) ([]*irodsclient_types.IRODSReplica, error) {
+	if err := c.inject(ctx, "ListReplicas", path); err != nil {
+		return nil, err
+	}
+	return c.client.ListReplicasCtx(ctx, path)
+}
+
+func (c *IRODSFSClientFaulty) Symlink(target string, linkPath string) error {
+	return c.SymlinkCtx(context.Background(), target, linkPath)
+}
+
+func (c *IRODSFSClientFaulty) SymlinkCtx(ctx context.Context, target string, linkPath string) error {
+	if err := c.inject(ctx, "Symlink", linkPath); err != nil {
+		return err
+	}
+	return c.client.SymlinkCtx(ctx, target, linkPath)
+}
+
+func (c *IRODSFSClientFaulty) Readlink(path string) (string, error) {
+	return c.ReadlinkCtx(context.Background(), path)
+}
+
+func (c *IRODSFSClientFaulty) ReadlinkCtx(ctx context.Context, path string) (string, error) {
+	if err := c.inject(ctx, "Readlink", path); err != nil {
+		return "", err
+	}
+	return c.client.ReadlinkCtx(ctx, path)
+}
+
+func (c *IRODSFSClientFaulty) GetXattr(path string, name string) ([]byte, error) {
+	return c.GetXattrCtx(context.Background(), path, name)
+}
+
+func (c *IRODSFSClientFaulty) GetXattrCtx(ctx context.Context, path string, name string) ([]byte, error) {
+	if err := c.inject(ctx, "GetXattr", path); err != nil {
+		return nil, err
+	}
+	return c.client.GetXattrCtx(ctx, path, name)
+}
+
+func (c *IRODSFSClientFaulty) SetXattr(path string, name string, value []byte) error {
+	return c.SetXattrCtx(context.Background(), path, name, value)
+}
+
+func (c *IRODSFSClientFaulty) SetXattrCtx(ctx context.Context, path string, name string, value []byte) error {
+	if err := c.inject(ctx, "SetXattr", path); err != nil {
+		return err
+	}
+	return c.client.SetXattrCtx(ctx, path, name, value)
+}
+
+func (c *IRODSFSClientFaulty) ListXattr(path string) ([]string, error) {
+	return c.ListXattrCtx(context.Background(), path)
+}
+
+func (c *IRODSFSClientFaulty) ListXattrCtx(ctx context.Context, path string) ([]string, error) {
+	if err := c.inject(ctx, "ListXattr", path); err != nil {
+		return nil, err
+	}
+	return c.client.ListXattrCtx(ctx, path)
+}
+
+func (c *IRODSFSClientFaulty) RemoveXattr(path string, name string) error {
+	return c.RemoveXattrCtx(context.Background(), path, name)
+}
+
+func (c *IRODSFSClientFaulty) RemoveXattrCtx(ctx context.Context, path string, name string) error {
+	if err := c.inject(ctx, "RemoveXattr", path); err != nil {
+		return err
+	}
+	return c.client.RemoveXattrCtx(ctx, path, name)
+}
+
+func (c *IRODSFSClientFaulty) GetACL(path string) ([]*irodsclient_types.IRODSAccess, error) {
+	return c.GetACLCtx(context.Background(), path)
+}
+
+func (c *IRODSFSClientFaulty) GetACLCtx(ctx context.Context, path string) ([]*irodsclient_types.IRODSAccess, error) {
+	if err := c.inject(ctx, "GetACL", path); err != nil {
+		return nil, err
+	}
+	return c.client.GetACLCtx(ctx, path)
+}
+
+func (c *IRODSFSClientFaulty) SetACL(path string, userName string, zoneName string, access irodsclient_types.IRODSAccessLevelType, recurse bool) error {
+	return c.SetACLCtx(context.Background(), path, userName, zoneName, access, recurse)
+}
+
+func (c *IRODSFSClientFaulty) SetACLCtx(ctx context.Context, path string, userName string, zoneName string, access irodsclient_types.IRODSAccessLevelType, recurse bool) error {
+	if err := c.inject(ctx, "SetACL", path); err != nil {
+		return err
+	}
+	return c.client.SetACLCtx(ctx, path, userName, zoneName, access, recurse)
+}
+
+func (c *IRODSFSClientFaulty) SetInheritance(path string, inherit bool, recurse bool) error {
+	return c.SetInheritanceCtx(context.Background(), path, inherit, recurse)
+}
+
+func (c *IRODSFSClientFaulty) SetInheritanceCtx(ctx context.Context, path string, inherit bool, recurse bool) error {
+	if err := c.inject(ctx, "SetInheritance", path); err != nil {
+		return err
+	}
+	return c.client.SetInheritanceCtx(ctx, path, inherit, recurse)
+}
+
+func (c *IRODSFSClientFaulty) CopyFileToFile(srcPath string, destPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.CopyFileToFileCtx(context.Background(), srcPath, destPath, transferCallback)
+}
+
+func (c *IRODSFSClientFaulty) CopyFileToFileCtx(ctx context.Context, srcPath string, destPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	if err := c.inject(ctx, "CopyFileToFile", srcPath); err != nil {
+		return err
+	}
+	return c.client.CopyFileToFileCtx(ctx, srcPath, destPath, transferCallback)
+}
+
+func (c *IRODSFSClientFaulty) CopyDirToDir(srcPath string, destPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.CopyDirToDirCtx(context.Background(), srcPath, destPath, transferCallback)
+}
+
+func (c *IRODSFSClientFaulty) CopyDirToDirCtx(ctx context.Context, srcPath string, destPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	if err := c.inject(ctx, "CopyDirToDir", srcPath); err != nil {
+		return err
+	}
+	return c.client.CopyDirToDirCtx(ctx, srcPath, destPath, transferCallback)
+}
+
+func (c *IRODSFSClientFaulty) Sync() error {
+	return c.SyncCtx(context.Background())
+}
+
+func (c *IRODSFSClientFaulty) SyncCtx(ctx context.Context) error {
+	if err := c.inject(ctx, "Sync", "/"); err != nil {
+		return err
+	}
+	return c.client.SyncCtx(ctx)
+}
+
+// download runs a download to localPath. A dropped download leaves a random part of the file locally.
+func (c *IRODSFSClientFaulty) download(ctx context.Context, method string, irodsPath string, localPath string, download func() error) error {
+	if err := c.inject(ctx, method, irodsPath); err != nil {
+		return err
+	}
+	if err := download(); err != nil {
+		return err
+	}
+
+	dropErr := c.faults.ApplyCtx(ctx, method, irodsPath, FaultDrop)
+	if dropErr == nil {
+		return nil
+	}
+
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return errors.Wrapf(err, "failed to stat local file %q", localPath)
+	}
+	if err := os.Truncate(localPath, c.faults.randomInt64(info.Size())); err != nil {
+		return errors.Wrapf(err, "failed to truncate local file %q", localPath)
+	}
+	return dropErr
+}
+
+// downloadBlocks runs a download to blockReadyCallback. A dropped download fails after a random number
+// of blocks, or after the last one if it has fewer.
+func (c *IRODSFSClientFaulty) downloadBlocks(ctx context.Context, method string, irodsPath string, blockReadyCallback irodsclient_common.DataObjectBlockCallback, download func(irodsclient_common.DataObjectBlockCallback) error) error {
+	if err := c.inject(ctx, method, irodsPath); err != nil {
+		return err
+	}
+	if blockReadyCallback == nil {
+		return download(blockReadyCallback)
+	}
+	dropErr := c.faults.ApplyCtx(ctx, method, irodsPath, FaultDrop)
+	if dropErr == nil {
+		return download(blockReadyCallback)
+	}
+
+	dropAfter := c.faults.randomInt64(DefaultTransferNumBlocks)
+	blocks := int64(0)
+	err := download(func(block []byte, offset int64) error {
+		if blocks >= dropAfter {
+			return dropErr
+		}
+		blocks++
+		return blockReadyCallback(block, offset)
+	})
+	if err != nil {
+		return err
+	}
+	return dropErr
+}
+
+// upload runs an upload of localPath. A dropped upload leaves a random part of the file in iRODS.
+func (c *IRODSFSClientFaulty) upload(ctx context.Context, method string, localPath string, irodsPath string, upload func(localPath string) error) error {
+	if err := c.inject(ctx, method, irodsPath); err != nil {
+		return err
+	}
+	dropErr := c.faults.ApplyCtx(ctx, method, irodsPath, FaultDrop)
+	if dropErr == nil {
+		return upload(localPath)
+	}
+
+	data, err := os.ReadFile(localPath)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read local file %q", localPath)
+	}
+
+	partialDir, err := os.MkdirTemp("", "irodsfs-faulty-")
+	if err != nil {
+		return errors.Wrap(err, "failed to create directory for partial upload")
+	}
+	defer os.RemoveAll(partialDir)
+
+	partialPath := filepath.Join(partialDir, filepath.Base(localPath))
+	if err := os.WriteFile(partialPath, data[:c.faults.randomInt64(int64(len(data)))], 0600); err != nil {
+		return errors.Wrap(err, "failed to write partial upload")
+	}
+	if err := upload(partialPath); err != nil {
+		return err
+	}
+	return dropErr
+}
+
+func (c *IRODSFSClientFaulty) DownloadFile(irodsPath string, localPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.DownloadFileCtx(context.Background(), irodsPath, localPath, transferCallback)
+}
+
+func (c *IRODSFSClientFaulty) DownloadFileCtx(ctx context.Context, irodsPath string, localPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.download(ctx, "DownloadFile", irodsPath, localPath, func() error {
+		return c.client.DownloadFileCtx(ctx, irodsPath, localPath, transferCallback)
+	})
+}
+
+func (c *IRODSFSClientFaulty) DownloadFileParallel(irodsPath string, localPath string, taskNum int, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.DownloadFileParallelCtx(context.Background(), irodsPath, localPath, taskNum, transferCallback)
+}
+
+func (c *IRODSFSClientFaulty) DownloadFileParallelCtx(ctx context.Context, irodsPath string, localPath string, taskNum int, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.download(ctx, "DownloadFile", irodsPath, localPath, func() error {
+		return c.client.DownloadFileParallelCtx(ctx, irodsPath, localPath, taskNum, transferCallback)
+	})
+}
+
+func (c *IRODSFSClientFaulty) DownloadFileWithCallback(irodsPath string, blockSize int, numBlocks int, blockReadyCallback irodsclient_common.DataObjectBlockCallback, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.DownloadFileWithCallbackCtx(context.Background(), irodsPath, blockSize, numBlocks, blockReadyCallback, transferCallback)
+}
+
+func (c *IRODSFSClientFaulty) DownloadFileWithCallbackCtx(ctx context.Context, irodsPath string, blockSize int, numBlocks int, blockReadyCallback irodsclient_common.DataObjectBlockCallback, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.downloadBlocks(ctx, "DownloadFile", irodsPath, blockReadyCallback, func(blockReadyCallback irodsclient_common.DataObjectBlockCallback) error {
+		return c.client.DownloadFileWithCallbackCtx(ctx, irodsPath, blockSize, numBlocks, blockReadyCallback, transferCallback)
+	})
+}
+
+func (c *IRODSFSClientFaulty) DownloadFileParallelWithCallback(irodsPath string, blockSize int, numBlocks int, blockReadyCallback irodsclient_common.DataObjectBlockCallback, taskNum int, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.DownloadFileParallelWithCallbackCtx(context.Background(), irodsPath, blockSize, numBlocks, blockReadyCallback, taskNum, transferCallback)
+}
+
+func (c *IRODSFSClientFaulty) DownloadFileParallelWithCallbackCtx(ctx context.Context, irodsPath string, blockSize int, numBlocks int, blockReadyCallback irodsclient_common.DataObjectBlockCallback, taskNum int, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.downloadBlocks(ctx, "DownloadFile", irodsPath, blockReadyCallback, func(blockReadyCallback irodsclient_common.DataObjectBlockCallback) error {
+		return c.client.DownloadFileParallelWithCallbackCtx(ctx, irodsPath, blockSize, numBlocks, blockReadyCallback, taskNum, transferCallback)
+	})
+}
+
+func (c *IRODSFSClientFaulty) UploadFile(localPath string, irodsPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.UploadFileCtx(context.Background(), localPath, irodsPath, transferCallback)
+}
+
+func (c *IRODSFSClientFaulty) UploadFileCtx(ctx context.Context, localPath string, irodsPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.upload(ctx, "UploadFile", localPath, irodsPath, func(localPath string) error {
+		return c.client.UploadFileCtx(ctx, localPath, irodsPath, transferCallback)
+	})
+}
+
+func (c *IRODSFSClientFaulty) UploadFileParallel(localPath string, irodsPath string, taskNum int, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.UploadFileParallelCtx(context.Background(), localPath, irodsPath, taskNum, transferCallback)
+}
+
+func (c *IRODSFSClientFaulty) UploadFileParallelCtx(ctx context.Context, localPath string, irodsPath string, taskNum int, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.upload(ctx, "UploadFile", localPath, irodsPath, func(localPath string) error {
+		return c.client.UploadFileParallelCtx(ctx, localPath, irodsPath, taskNum, transferCallback)
+	})
+}
+
+func (c *IRODSFSClientFaulty) DownloadFileWithOptions(irodsPath string, localPath string, options *TransferOptions) error {
+	return c.DownloadFileWithOptionsCtx(context.Background(), irodsPath, localPath, options)
+}
+
+func (c *IRODSFSClientFaulty) DownloadFileWithOptionsCtx(ctx context.Context, irodsPath string, localPath string, options *TransferOptions) error {
+	if localPath != "" {
+		return c.download(ctx, "DownloadFile", irodsPath, localPath, func() error {
+			return c.client.DownloadFileWithOptionsCtx(ctx, irodsPath, localPath, options)
+		})
+	}
+
+	var blockReadyCallback irodsclient_common.DataObjectBlockCallback
+	if options != nil {
+		blockReadyCallback = options.BlockReadyCallback
+	}
+	return c.downloadBlocks(ctx, "DownloadFile", irodsPath, blockReadyCallback, func(blockReadyCallback irodsclient_common.DataObjectBlockCallback) error {
+		faultyOptions := TransferOptions{}
+		if options != nil {
+			faultyOptions = *options
+		}
+		faultyOptions.BlockReadyCallback = blockReadyCallback
+		return c.client.DownloadFileWithOptionsCtx(ctx, irodsPath, localPath, &faultyOptions)
+	})
+}
+
+func (c *IRODSFSClientFaulty) UploadFileWithOptions(localPath string, irodsPath string, options *TransferOptions) error {
+	return c.UploadFileWithOptionsCtx(context.Background(), localPath, irodsPath, options)
+}
+
+func (c *IRODSFSClientFaulty) UploadFileWithOptionsCtx(ctx context.Context, localPath string, irodsPath string, options *TransferOptions) error {
+	return c.upload(ctx, "UploadFile", localPath, irodsPath, func(localPath string) error {
+		return c.client.UploadFileWithOptionsCtx(ctx, localPath, irodsPath, options)
+	})
+}
+
+func (c *IRODSFSClientFaulty) GetTransferManager() *TransferManager {
+	return c.client.GetTransferManager()
+}
+
+func (c *IRODSFSClientFaulty) UploadDir(localDir string, irodsDir string, options *DirTransferOptions) (*DirTransferReport, error) {
+	return c.UploadDirCtx(context.Background(), localDir, irodsDir, options)
+}
+
+func (c *IRODSFSClientFaulty) UploadDirCtx(ctx context.Context, localDir string, irodsDir string, options *DirTransferOptions) (*DirTransferReport, error) {
+	if err := c.inject(ctx, "UploadDir", irodsDir); err != nil {
+		return nil, err
+	}
+	return c.client.UploadDirCtx(ctx, localDir, irodsDir, options)
+}
+
+func (c *IRODSFSClientFaulty) DownloadDir(irodsDir string, localDir string, options *DirTransferOptions) (*DirTransferReport, error) {
+	return c.DownloadDirCtx(context.Background(), irodsDir, localDir, options)
+}
+
+func (c *IRODSFSClientFaulty) DownloadDirCtx(ctx context.Context, irodsDir string, localDir string, options *DirTransferOptions) (*DirTransferReport, error) {
+	if err := c.inject(ctx, "DownloadDir", irodsDir); err != nil {
+		return nil, err
+	}
+	return c.client.DownloadDirCtx(ctx, irodsDir, localDir, options)
+}
+
+func (c *IRODSFSClientFaulty) CacheFile(irodsPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.CacheFileCtx(context.Background(), irodsPath, transferCallback)
+}
+
+func (c *IRODSFSClientFaulty) CacheFileCtx(ctx context.Context, irodsPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	if err := c.inject(ctx, "CacheFile", irodsPath); err != nil {
+		return err
+	}
+	return c.client.CacheFileCtx(ctx, irodsPath, transferCallback)
+}
+
+// IRODSFSClientFaultyFileHandle is a file handle of an IRODSFSClientFaulty
+type IRODSFSClientFaultyFileHandle struct {
+	client *IRODSFSClientFaulty
+	handle IRODSFSFileHandle
+
+	mu      sync.Mutex
+	dropped bool // The connection was dropped, later calls fail
+}
+
+// newFaultyHandle wraps a handle returned by the client, passing errors through
+func (c *IRODSFSClientFaulty) newFaultyHandle(handle IRODSFSFileHandle, err error) (IRODSFSFileHandle, error) {
+	if err != nil {
+		return nil, err
+	}
+	return &IRODSFSClientFaultyFileHandle{
+		client: c,
+		handle: handle,
+	}, nil
+}
+
+func (h *IRODSFSClientFaultyFileHandle) GetID() string {
+	return h.handle.GetID()
+}
+
+func (h *IRODSFSClientFaultyFileHandle) GetEntry() *irodsclient_fs.Entry {
+	return h.handle.GetEntry()
+}
+
+func (h *IRODSFSClientFaultyFileHandle) GetReplica() *irodsclient_types.IRODSReplica {
+	return h.handle.GetReplica()
+}
+
+func (h *IRODSFSClientFaultyFileHandle) GetOpenMode() irodsclient_types.FileOpenMode {
+	return h.handle.GetOpenMode()
+}
+
+func (h *IRODSFSClientFaultyFileHandle) IsReadMode() bool {
+	return h.handle.IsReadMode()
+}
+
+func (h *IRODSFSClientFaultyFileHandle) IsWriteMode() bool {
+	return h.handle.IsWriteMode()
+}
+
+func (h *IRODSFSClientFaultyFileHandle) GetAvailable(offset int64) int64 {
+	return h.handle.GetAvailable(offset)
+}
+
+func (h *IRODSFSClientFaultyFileHandle) path() string {
+	return h.handle.GetEntry().Path
+}
+
+// inject is IRODSFSClientFaulty.inject for a call on the handle, which fails once the handle is dropped
+func (h *IRODSFSClientFaultyFileHandle) inject(ctx context.Context, method string) error {
+	h.mu.Lock()
+	dropped := h.dropped
+	h.mu.Unlock()
+
+	if dropped {
+		return newError(method, h.path(), ErrTransient, errInjectedFault)
+	}
+	return h.client.inject(ctx, method, h.path())
+}
+
+// cutShort decides whether a ReadAt or WriteAt of size bytes is cut short, returning how many bytes
+// are transferred before it fails with the returned error
+func (h *IRODSFSClientFaultyFileHandle) cutShort(ctx context.Context, method string, size int) (int, error) {
+	err := h.client.faults.ApplyCtx(ctx, method, h.path(), FaultDrop)
+	if err != nil {
+		h.mu.Lock()
+		h.dropped = true
+		h.mu.Unlock()
+	} else if err = h.client.faults.ApplyCtx(ctx, method, h.path(), FaultPartial); err == nil {
+		return size, nil
+	}
+	return int(h.client.faults.randomInt64(int64(size))), err
+}
+
+func (h *IRODSFSClientFaultyFileHandle) ReadAt(buffer []byte, offset int64) (int, error) {
+	return h.ReadAtCtx(context.Background(), buffer, offset)
+}
+
+func (h *IRODSFSClientFaultyFileHandle) ReadAtCtx(ctx context.Context, buffer []byte, offset int64) (int, error) {
+	if err := h.inject(ctx, "ReadAt"); err != nil {
+		return 0, err
+	}
+
+	size, shortErr := h.cutShort(ctx, "ReadAt", len(buffer))
+	if shortErr == nil {
+		return WithContextHandle(h.handle).ReadAtCtx(ctx, buffer, offset)
+	}
+
+	readLen, err := WithContextHandle(h.handle).ReadAtCtx(ctx, buffer[:size], offset)
+	if err != nil {
+		return readLen, err
+	}
+	return readLen, shortErr
+}
+
+func (h *IRODSFSClientFaultyFileHandle) WriteAt(data []byte, offset int64) (int, error) {
+	return h.WriteAtCtx(context.Background(), data, offset)
+}
+
+func (h *IRODSFSClientFaultyFileHandle) WriteAtCtx(ctx context.Context, data []byte, offset int64) (int, error) {
+	if err := h.inject(ctx, "WriteAt"); err != nil {
+		return 0, err
+	}
+
+	size, shortErr := h.cutShort(ctx, "WriteAt", len(data))
+	if shortErr == nil {
+		return WithContextHandle(h.handle).WriteAtCtx(ctx, data, offset)
+	}
+
+	writeLen, err := WithContextHandle(h.handle).WriteAtCtx(ctx, data[:size], offset)
+	if err != nil {
+		return writeLen, err
+	}
+	return writeLen, shortErr
+}
+
+func (h *IRODSFSClientFaultyFileHandle) Truncate(size int64) error {
+	return h.TruncateCtx(context.Background(), size)
+}
+
+func (h *IRODSFSClientFaultyFileHandle) TruncateCtx(ctx context.Context, size int64) error {
+	if err := h.inject(ctx, "Truncate"); err != nil {
+		return err
+	}
+	return WithContextHandle(h.handle).TruncateCtx(ctx, size)
+}
+
+func (h *IRODSFSClientFaultyFileHandle) Flush() error {
+	return h.FlushCtx(context.Background())
+}
+
+func (h *IRODSFSClientFaultyFileHandle) FlushCtx(ctx context.Context) error {
+	if err := h.inject(ctx, "Flush"); err != nil {
+		return err
+	}
+	return WithContextHandle(h.handle).FlushCtx(ctx)
+}
+
+// Close closes the handle of the client even if a fault is injected, so that faults do not leak handles
+func (h *IRODSFSClientFaultyFileHandle) Close() error {
+	injectErr := h.inject(context.Background(), "Close")
+	if err := h.handle.Close(); err != nil {
+		return err
+	}
+	return injectErr
+}
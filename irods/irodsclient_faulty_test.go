@@ -0,0 +1,302 @@
+package irods_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	irodsclient_types "github.com/cyverse/go-irodsclient/irods/types"
+	"github.com/cyverse/irodsfs-common/irods"
+	"github.com/cyverse/irodsfs-common/irods/cache"
+	"github.com/cyverse/irodsfs-common/irods/fake"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newFakeClient returns a fake client with the given collections and files below its home collection,
+// and the path of its home collection
+func newFakeClient(t *testing.T, dirs []string, files map[string]string) (*fake.Client, string) {
+	client := fake.New(nil)
+	home := client.HomeDir()
+	for _, dir := range dirs {
+		require.NoError(t, client.AddDir(home+"/"+dir))
+	}
+	for name, content := range files {
+		require.NoError(t, client.AddFile(home+"/"+name, []byte(content)))
+	}
+	return client, home
+}
+
+// fakeTree returns the collections and the contents of the files below the home collection of client,
+// by path relative to it
+func fakeTree(t *testing.T, client *fake.Client) (map[string]bool, map[string]string) {
+	home := client.HomeDir()
+	dirs := map[string]bool{}
+	files := map[string]string{}
+	for _, p := range client.Paths() {
+		if !strings.HasPrefix(p, home+"/") {
+			continue
+		}
+		rel := strings.TrimPrefix(p, home+"/")
+		if client.ExistsDir(p) {
+			dirs[rel] = true
+			continue
+		}
+		data, err := client.ReadFile(p)
+		require.NoError(t, err)
+		files[rel] = string(data)
+	}
+	return dirs, files
+}
+
+func newTestFaultyClient(t *testing.T, client irods.IRODSFSClient, config *irods.FaultInjectionConfig) *irods.IRODSFSClientFaulty {
+	faulty, err := irods.NewIRODSFSClientFaulty(client, config)
+	require.NoError(t, err)
+	return faulty
+}
+
+func TestFaultyClientSeed(t *testing.T) {
+	failures := func(seed int64) []bool {
+		c, home := newFakeClient(t, nil, nil)
+		client := newTestFaultyClient(t, c, &irods.FaultInjectionConfig{
+			Seed:   seed,
+			Faults: []irods.Fault{{Op: "Stat", Err: syscall.ECONNRESET, Probability: 0.5}},
+		})
+
+		failed := []bool{}
+		for i := 0; i < 50; i++ {
+			_, err := client.Stat(home)
+			if err != nil {
+				assert.ErrorIs(t, err, irods.ErrTransient)
+			}
+			failed = append(failed, err != nil)
+		}
+		assert.Equal(t, int64(countTrue(failed)), client.GetInjectedFaults()["Stat"])
+		return failed
+	}
+
+	first := failures(42)
+	assert.Equal(t, first, failures(42))
+	assert.NotEqual(t, first, failures(7))
+	assert.Greater(t, countTrue(first), 0)
+	assert.Less(t, countTrue(first), 50)
+}
+
+func countTrue(values []bool) int {
+	count := 0
+	for _, value := range values {
+		if value {
+			count++
+		}
+	}
+	return count
+}
+
+func TestFaultyClientErrors(t *testing.T) {
+	c, home := newFakeClient(t, nil, nil)
+	client := newTestFaultyClient(t, c, &irods.FaultInjectionConfig{
+		Faults: []irods.Fault{
+			irods.FailureFault("MakeDir", errors.New("iRODS error -818000 (CAT_NO_ACCESS_PERMISSION)")),
+			irods.LatencyFault("RemoveFile", 10*time.Millisecond),
+			irods.FailureFault("ExistsDir", nil),
+		},
+	})
+
+	err := client.MakeDir(home+"/dir", false)
+	assert.ErrorIs(t, err, irods.ErrPermissionDenied)
+	assert.False(t, client.ExistsDir(home))
+
+	start := time.Now()
+	err = client.RemoveFile(home+"/missing", false)
+	assert.ErrorIs(t, err, irods.ErrNotFound)
+	assert.GreaterOrEqual(t, time.Since(start), 10*time.Millisecond)
+
+	client.SetEnabled(false)
+	require.NoError(t, client.MakeDir(home+"/dir", false))
+	assert.Equal(t, map[string]int64{"MakeDir": 1, "ExistsDir": 1}, client.GetInjectedFaults())
+
+	// Faults match paths
+	client.SetEnabled(true)
+	client.ClearFaults()
+	client.InjectFault(irods.PermissionFault(home+"/dir", true))
+	assert.ErrorIs(t, client.MakeDir(home+"/dir/sub", false), irods.ErrPermissionDenied)
+	require.NoError(t, client.MakeDir(home+"/other", false))
+	assert.True(t, client.ExistsDir(home+"/dir"))
+}
+
+func TestFaultyClientContext(t *testing.T) {
+	c, home := newFakeClient(t, nil, map[string]string{"file": "0123456789"})
+	client := newTestFaultyClient(t, c, &irods.FaultInjectionConfig{
+		Faults: []irods.Fault{irods.LatencyFault("DownloadFile", time.Hour)},
+	})
+	assert.Same(t, client, irods.WithContext(client))
+
+	// Injected delays end once ctx is done
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := client.DownloadFileWithOptionsCtx(ctx, home+"/file", filepath.Join(t.TempDir(), "file"), nil)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Minute)
+}
+
+func TestFaultyClientDroppedTransfers(t *testing.T) {
+	content := strings.Repeat("0123456789", 100)
+	c, home := newFakeClient(t, nil, map[string]string{"file": content})
+	client := newTestFaultyClient(t, c, &irods.FaultInjectionConfig{
+		Seed:   1,
+		Faults: []irods.Fault{{Effect: irods.FaultDrop}},
+	})
+
+	localPath := filepath.Join(t.TempDir(), "file")
+	err := client.DownloadFileParallel(home+"/file", localPath, 1, nil)
+	assert.ErrorIs(t, err, irods.ErrTransient)
+	data, err := os.ReadFile(localPath)
+	require.NoError(t, err)
+	assert.Less(t, len(data), len(content))
+	assert.True(t, strings.HasPrefix(content, string(data)))
+
+	require.NoError(t, os.WriteFile(localPath, []byte(content+content), 0644))
+	err = client.UploadFileParallel(localPath, home+"/uploaded", 1, nil)
+	assert.ErrorIs(t, err, irods.ErrTransient)
+	_, files := fakeTree(t, c)
+	assert.Less(t, len(files["uploaded"]), 2*len(content))
+	assert.True(t, strings.HasPrefix(content+content, files["uploaded"]))
+	assert.Equal(t, map[string]int64{"DownloadFile": 1, "UploadFile": 1}, client.GetInjectedFaults())
+}
+
+func TestFaultyFileHandle(t *testing.T) {
+	c, home := newFakeClient(t, nil, map[string]string{"file": "0123456789"})
+	client := newTestFaultyClient(t, c, &irods.FaultInjectionConfig{
+		Seed: 1,
+		Faults: []irods.Fault{
+			{Op: "ReadAt", Effect: irods.FaultPartial},
+			{Op: "WriteAt", Effect: irods.FaultDrop},
+		},
+	})
+
+	handle, err := client.OpenFile(home+"/file", "r+")
+	require.NoError(t, err)
+
+	buffer := make([]byte, 10)
+	n, err := handle.ReadAt(buffer, 0)
+	assert.ErrorIs(t, err, irods.ErrTransient)
+	assert.Less(t, n, 10)
+	assert.Equal(t, "0123456789"[:n], string(buffer[:n]))
+
+	n, err = handle.WriteAt([]byte("abcdefghij"), 0)
+	assert.ErrorIs(t, err, irods.ErrTransient)
+	_, files := fakeTree(t, c)
+	assert.Equal(t, "abcdefghij"[:n]+"0123456789"[n:], files["file"])
+
+	// The connection is gone, but the handle of the client is closed
+	assert.ErrorIs(t, handle.Flush(), irods.ErrTransient)
+	assert.ErrorIs(t, handle.Close(), irods.ErrTransient)
+	assert.Zero(t, c.GetOpenConnections())
+}
+
+// chaosRetries is how often the chaos test repeats an operation that failed with a transient error
+const chaosRetries = 100
+
+// retryTransient runs op until it does not fail with a transient error
+func retryTransient(t *testing.T, op func() error) {
+	for i := 0; i < chaosRetries; i++ {
+		err := op()
+		if !errors.Is(err, irods.ErrTransient) {
+			require.NoError(t, err)
+			return
+		}
+	}
+	t.Fatalf("operation failed %d times", chaosRetries)
+}
+
+// newTestBufferedClient returns a buffered client with staging over client
+func newTestBufferedClient(t *testing.T, client irods.IRODSFSClient) irods.IRODSFSClient {
+	cacheManager, err := cache.NewMemoryCacheManager(&cache.MemoryCacheConfig{
+		NumCounters: 1000,
+		MaxCost:     1024 * 1024,
+		BufferItems: 64,
+		TTL:         time.Hour,
+	})
+	require.NoError(t, err)
+	t.Cleanup(cacheManager.Release)
+
+	buffered, err := irods.NewIRODSFSClientBufferedWithClient(client, cacheManager, &irods.IRODSFSClientBufferedConfig{
+		StagingRootPath: t.TempDir(),
+		GracePeriod:     time.Hour,
+	})
+	require.NoError(t, err)
+	return buffered
+}
+
+// writeBufferedFile writes a new file through a buffered client
+func writeBufferedFile(t *testing.T, client irods.IRODSFSClient, p string, content string) {
+	handle, err := client.CreateFile(p, string(irodsclient_types.FileOpenModeWriteOnly))
+	require.NoError(t, err)
+	_, err = handle.WriteAt([]byte(content), 0)
+	require.NoError(t, err)
+	require.NoError(t, handle.Close())
+}
+
+// TestBufferedClientStagingChaos changes iRODS through a buffered client with staging whose syncs hit
+// errors and dropped uploads, and checks that iRODS ends up as intended once syncs succeed
+func TestBufferedClientStagingChaos(t *testing.T) {
+	injected := int64(0)
+	for seed := int64(1); seed <= 5; seed++ {
+		t.Run(fmt.Sprintf("seed %d", seed), func(t *testing.T) {
+			c, home := newFakeClient(t, []string{"old"}, map[string]string{
+				"old/a.txt": "old a",
+				"keep.txt":  "keep",
+				"gone.txt":  "gone",
+			})
+			faulty := newTestFaultyClient(t, c, &irods.FaultInjectionConfig{
+				Seed: seed,
+				Faults: []irods.Fault{
+					{Err: syscall.ECONNRESET, Probability: 0.3},
+					{Effect: irods.FaultDrop, Probability: 0.5},
+				},
+			})
+			client := newTestBufferedClient(t, faulty)
+			defer client.Release()
+
+			large := strings.Repeat(fmt.Sprintf("seed %d ", seed), 10000)
+
+			retryTransient(t, func() error { return client.MakeDir(home+"/new", false) })
+			writeBufferedFile(t, client, home+"/new/large.bin", large)
+			writeBufferedFile(t, client, home+"/new/small.txt", "small")
+			retryTransient(t, func() error {
+				return client.RenameFileToFile(home+"/new/small.txt", home+"/new/renamed.txt")
+			})
+			writeBufferedFile(t, client, home+"/old/b.txt", "b")
+			retryTransient(t, func() error { return client.RemoveFile(home+"/gone.txt", false) })
+			retryTransient(t, func() error { return client.RenameFileToFile(home+"/keep.txt", home+"/kept.txt") })
+
+			// A staged directory removed before it is synced never reaches iRODS
+			retryTransient(t, func() error { return client.MakeDir(home+"/tmp", false) })
+			writeBufferedFile(t, client, home+"/tmp/x.txt", "x")
+			retryTransient(t, func() error { return client.RemoveDir(home+"/tmp", true, false) })
+
+			retryTransient(t, client.Sync)
+
+			dirs, files := fakeTree(t, c)
+			assert.Equal(t, map[string]bool{"old": true, "new": true}, dirs)
+			assert.Equal(t, map[string]string{
+				"old/a.txt":       "old a",
+				"old/b.txt":       "b",
+				"kept.txt":        "keep",
+				"new/large.bin":   large,
+				"new/renamed.txt": "small",
+			}, files)
+			for _, count := range faulty.GetInjectedFaults() {
+				injected += count
+			}
+		})
+	}
+	assert.Greater(t, injected, int64(0))
+}
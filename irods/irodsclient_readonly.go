@@ -0,0 +1,507 @@
+package irods
+
+import (
+	"context"
+	"path"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	irodsclient_fs "github.com/cyverse/go-irodsclient/fs"
+	irodsclient_common "github.com/cyverse/go-irodsclient/irods/common"
+	irodsclient_metrics "github.com/cyverse/go-irodsclient/irods/metrics"
+	irodsclient_types "github.com/cyverse/go-irodsclient/irods/types"
+)
+
+// ReadOnlyConfig configures an IRODSFSClientReadOnly
+type ReadOnlyConfig struct {
+	AllPaths     bool     `yaml:"all_paths" json:"all_paths"`         // Reject changes on all paths
+	PathPrefixes []string `yaml:"path_prefixes" json:"path_prefixes"` // iRODS paths at or below which changes are rejected (empty = none)
+}
+
+// IRODSFSClientReadOnly is an IRODSFSClient that rejects calls changing iRODS with ErrReadOnly, on all
+// paths or at and below the configured prefixes. Reads, downloads, local caching and Sync, which only
+// uploads changes made before, pass through.
+type IRODSFSClientReadOnly struct {
+	client   IRODSFSClientCtx
+	allPaths bool
+	prefixes []string
+}
+
+// NewIRODSFSClientReadOnly creates an IRODSFSClientReadOnly that protects the paths of config, or all
+// paths if config is nil
+func NewIRODSFSClientReadOnly(client IRODSFSClient, config *ReadOnlyConfig) (*IRODSFSClientReadOnly, error) {
+	if client == nil {
+		return nil, errors.New("client is required")
+	}
+
+	if config == nil {
+		config = &ReadOnlyConfig{AllPaths: true}
+	}
+
+	readOnlyClient := &IRODSFSClientReadOnly{
+		client:   WithContext(client),
+		allPaths: config.AllPaths,
+	}
+	for _, prefix := range config.PathPrefixes {
+		if !path.IsAbs(prefix) {
+			return nil, errors.Newf("read-only path prefix %q is not absolute", prefix)
+		}
+		readOnlyClient.prefixes = append(readOnlyClient.prefixes, path.Clean(prefix))
+	}
+	return readOnlyClient, nil
+}
+
+// IsReadOnly returns true if changes at p are rejected
+func (c *IRODSFSClientReadOnly) IsReadOnly(p string) bool {
+	if c.allPaths {
+		return true
+	}
+
+	p = path.Clean(p)
+	for _, prefix := range c.prefixes {
+		if prefix == "/" || p == prefix || strings.HasPrefix(p, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// isReadOnlyTree returns true if changes at p or below it are rejected, for calls that change a whole tree
+func (c *IRODSFSClientReadOnly) isReadOnlyTree(p string) bool {
+	if c.IsReadOnly(p) {
+		return true
+	}
+
+	p = path.Clean(p)
+	for _, prefix := range c.prefixes {
+		if p == "/" || strings.HasPrefix(prefix, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// check returns ErrReadOnly for op if changes at any of paths are rejected
+func (c *IRODSFSClientReadOnly) check(op string, paths ...string) error {
+	for _, p := range paths {
+		if c.IsReadOnly(p) {
+			return newError(op, p, ErrReadOnly, nil)
+		}
+	}
+	return nil
+}
+
+// checkTree is check for calls that change the trees at paths
+func (c *IRODSFSClientReadOnly) checkTree(op string, paths ...string) error {
+	for _, p := range paths {
+		if c.isReadOnlyTree(p) {
+			return newError(op, p, ErrReadOnly, nil)
+		}
+	}
+	return nil
+}
+
+func (c *IRODSFSClientReadOnly) Release() {
+	c.client.Release()
+}
+
+func (c *IRODSFSClientReadOnly) GetAccount() *irodsclient_types.IRODSAccount {
+	return c.client.GetAccount()
+}
+
+func (c *IRODSFSClientReadOnly) GetApplicationName() string {
+	return c.client.GetApplicationName()
+}
+
+func (c *IRODSFSClientReadOnly) GetOpenConnections() int {
+	return c.client.GetOpenConnections()
+}
+
+func (c *IRODSFSClientReadOnly) GetMetrics() *irodsclient_metrics.IRODSMetrics {
+	return c.client.GetMetrics()
+}
+
+func (c *IRODSFSClientReadOnly) List(path string) ([]*irodsclient_fs.Entry, error) {
+	return c.ListCtx(context.Background(), path)
+}
+
+func (c *IRODSFSClientReadOnly) ListCtx(ctx context.Context, path string) ([]*irodsclient_fs.Entry, error) {
+	return c.client.ListCtx(ctx, path)
+}
+
+func (c *IRODSFSClientReadOnly) Stat(path string) (*irodsclient_fs.Entry, error) {
+	return c.StatCtx(context.Background(), path)
+}
+
+func (c *IRODSFSClientReadOnly) StatCtx(ctx context.Context, path string) (*irodsclient_fs.Entry, error) {
+	return c.client.StatCtx(ctx, path)
+}
+
+func (c *IRODSFSClientReadOnly) ExistsDir(path string) bool {
+	return c.ExistsDirCtx(context.Background(), path)
+}
+
+func (c *IRODSFSClientReadOnly) ExistsDirCtx(ctx context.Context, path string) bool {
+	return c.client.ExistsDirCtx(ctx, path)
+}
+
+func (c *IRODSFSClientReadOnly) ExistsFile(path string) bool {
+	return c.ExistsFileCtx(context.Background(), path)
+}
+
+func (c *IRODSFSClientReadOnly) ExistsFileCtx(ctx context.Context, path string) bool {
+	return c.client.ExistsFileCtx(ctx, path)
+}
+
+func (c *IRODSFSClientReadOnly) RemoveFile(path string, force bool) error {
+	return c.RemoveFileCtx(context.Background(), path, force)
+}
+
+func (c *IRODSFSClientReadOnly) RemoveFileCtx(ctx context.Context, path string, force bool) error {
+	if err := c.check("remove", path); err != nil {
+		return err
+	}
+	return c.client.RemoveFileCtx(ctx, path, force)
+}
+
+func (c *IRODSFSClientReadOnly) RemoveDir(path string, recurse bool, force bool) error {
+	return c.RemoveDirCtx(context.Background(), path, recurse, force)
+}
+
+func (c *IRODSFSClientReadOnly) RemoveDirCtx(ctx context.Context, path string, recurse bool, force bool) error {
+	if err := c.checkTree("rmdir", path); err != nil {
+		return err
+	}
+	return c.client.RemoveDirCtx(ctx, path, recurse, force)
+}
+
+func (c *IRODSFSClientReadOnly) MakeDir(path string, recurse bool) error {
+	return c.MakeDirCtx(context.Background(), path, recurse)
+}
+
+func (c *IRODSFSClientReadOnly) MakeDirCtx(ctx context.Context, path string, recurse bool) error {
+	if err := c.check("mkdir", path); err != nil {
+		return err
+	}
+	return c.client.MakeDirCtx(ctx, path, recurse)
+}
+
+func (c *IRODSFSClientReadOnly) RenameDirToDir(srcPath string, destPath string) error {
+	return c.RenameDirToDirCtx(context.Background(), srcPath, destPath)
+}
+
+func (c *IRODSFSClientReadOnly) RenameDirToDirCtx(ctx context.Context, srcPath string, destPath string) error {
+	if err := c.checkTree("rename", srcPath, destPath); err != nil {
+		return err
+	}
+	return c.client.RenameDirToDirCtx(ctx, srcPath, destPath)
+}
+
+func (c *IRODSFSClientReadOnly) RenameFileToFile(srcPath string, destPath string) error {
+	return c.RenameFileToFileCtx(context.Background(), srcPath, destPath)
+}
+
+func (c *IRODSFSClientReadOnly) RenameFileToFileCtx(ctx context.Context, srcPath string, destPath string) error {
+	if err := c.check("rename", srcPath, destPath); err != nil {
+		return err
+	}
+	return c.client.RenameFileToFileCtx(ctx, srcPath, destPath)
+}
+
+func (c *IRODSFSClientReadOnly) CreateFile(path string, mode string) (IRODSFSFileHandle, error) {
+	return c.CreateFileCtx(context.Background(), path, mode)
+}
+
+func (c *IRODSFSClientReadOnly) CreateFileCtx(ctx context.Context, path string, mode string) (IRODSFSFileHandle, error) {
+	if err := c.check("create", path); err != nil {
+		return nil, err
+	}
+	return c.client.CreateFileCtx(ctx, path, mode)
+}
+
+func (c *IRODSFSClientReadOnly) OpenFile(path string, mode string) (IRODSFSFileHandle, error) {
+	return c.OpenFileCtx(context.Background(), path, mode)
+}
+
+// OpenFileCtx rejects opening files for writing
+func (c *IRODSFSClientReadOnly) OpenFileCtx(ctx context.Context, path string, mode string) (IRODSFSFileHandle, error) {
+	if irodsclient_types.FileOpenMode(mode).IsWrite() {
+		if err := c.check("open", path); err != nil {
+			return nil, err
+		}
+	}
+	return c.client.OpenFileCtx(ctx, path, mode)
+}
+
+func (c *IRODSFSClientReadOnly) TruncateFile(path string, size int64) error {
+	return c.TruncateFileCtx(context.Background(), path, size)
+}
+
+func (c *IRODSFSClientReadOnly) TruncateFileCtx(ctx context.Context, path string, size int64) error {
+	if err := c.check("truncate", path); err != nil {
+		return err
+	}
+	return c.client.TruncateFileCtx(ctx, path, size)
+}
+
+func (c *IRODSFSClientReadOnly) CreateFileWithOptions(path string, mode string, options *OpenOptions) (IRODSFSFileHandle, error) {
+	return c.CreateFileWithOptionsCtx(context.Background(), path, mode, options)
+}
+
+func (c *IRODSFSClientReadOnly) CreateFileWithOptionsCtx(ctx context.Context, path string, mode string, options *OpenOptions) (IRODSFSFileHandle, error) {
+	if err := c.check("create", path); err != nil {
+		return nil, err
+	}
+	return c.client.CreateFileWithOptionsCtx(ctx, path, mode, options)
+}
+
+func (c *IRODSFSClientReadOnly) OpenFileWithOptions(path string, mode string, options *OpenOptions) (IRODSFSFileHandle, error) {
+	return c.OpenFileWithOptionsCtx(context.Background(), path, mode, options)
+}
+
+// OpenFileWithOptionsCtx rejects opening files for writing
+func (c *IRODSFSClientReadOnly) OpenFileWithOptionsCtx(ctx context.Context, path string, mode string, options *OpenOptions) (IRODSFSFileHandle, error) {
+	if irodsclient_types.FileOpenMode(mode).IsWrite() {
+		if err := c.check("open", path); err != nil {
+			return nil, err
+		}
+	}
+	return c.client.OpenFileWithOptionsCtx(ctx, path, mode, options)
+}
+
+func (c *IRODSFSClientReadOnly) ListReplicas(path string) ([]*irodsclient_types.IRODSReplica, error) {
+	return c.ListReplicasCtx(context.Background(), path)
+}
+
+func (c *IRODSFSClientReadOnly) ListReplicasCtx(ctx context.Context, path string) ([]*irodsclient_types.IRODSReplica, error) {
+	return c.client.ListReplicasCtx(ctx, path)
+}
+
+func (c *IRODSFSClientReadOnly) Symlink(target string, linkPath string) error {
+	return c.SymlinkCtx(context.Background(), target, linkPath)
+}
+
+func (c *IRODSFSClientReadOnly) SymlinkCtx(ctx context.Context, target string, linkPath string) error {
+	if err := c.check("symlink", linkPath); err != nil {
+		return err
+	}
+	return c.client.SymlinkCtx(ctx, target, linkPath)
+}
+
+func (c *IRODSFSClientReadOnly) Readlink(path string) (string, error) {
+	return c.ReadlinkCtx(context.Background(), path)
+}
+
+func (c *IRODSFSClientReadOnly) ReadlinkCtx(ctx context.Context, path string) (string, error) {
+	return c.client.ReadlinkCtx(ctx, path)
+}
+
+func (c *IRODSFSClientReadOnly) GetXattr(path string, name string) ([]byte, error) {
+	return c.GetXattrCtx(context.Background(), path, name)
+}
+
+func (c *IRODSFSClientReadOnly) GetXattrCtx(ctx context.Context, path string, name string) ([]byte, error) {
+	return c.client.GetXattrCtx(ctx, path, name)
+}
+
+func (c *IRODSFSClientReadOnly) SetXattr(path string, name string, value []byte) error {
+	return c.SetXattrCtx(context.Background(), path, name, value)
+}
+
+func (c *IRODSFSClientReadOnly) SetXattrCtx(ctx context.Context, path string, name string, value []byte) error {
+	if err := c.check("setxattr", path); err != nil {
+		return err
+	}
+	return c.client.SetXattrCtx(ctx, path, name, value)
+}
+
+func (c *IRODSFSClientReadOnly) ListXattr(path string) ([]string, error) {
+	return c.ListXattrCtx(context.Background(), path)
+}
+
+func (c *IRODSFSClientReadOnly) ListXattrCtx(ctx context.Context, path string) ([]string, error) {
+	return c.client.ListXattrCtx(ctx, path)
+}
+
+func (c *IRODSFSClientReadOnly) RemoveXattr(path string, name string) error {
+	return c.RemoveXattrCtx(context.Background(), path, name)
+}
+
+func (c *IRODSFSClientReadOnly) RemoveXattrCtx(ctx context.Context, path string, name string) error {
+	if err := c.check("removexattr", path); err != nil {
+		return err
+	}
+	return c.client.RemoveXattrCtx(ctx, path, name)
+}
+
+func (c *IRODSFSClientReadOnly) GetACL(path string) ([]*irodsclient_types.IRODSAccess, error) {
+	return c.GetACLCtx(context.Background(), path)
+}
+
+func (c *IRODSFSClientReadOnly) GetACLCtx(ctx context.Context, path string) ([]*irodsclient_types.IRODSAccess, error) {
+	return c.client.GetACLCtx(ctx, path)
+}
+
+func (c *IRODSFSClientReadOnly) SetACL(path string, userName string, zoneName string, access irodsclient_types.IRODSAccessLevelType, recurse bool) error {
+	return c.SetACLCtx(context.Background(), path, userName, zoneName, access, recurse)
+}
+
+func (c *IRODSFSClientReadOnly) SetACLCtx(ctx context.Context, path string, userName string, zoneName string, access irodsclient_types.IRODSAccessLevelType, recurse bool) error {
+	check := c.check
+	if recurse {
+		check = c.checkTree
+	}
+	if err := check("setacl", path); err != nil {
+		return err
+	}
+	return c.client.SetACLCtx(ctx, path, userName, zoneName, access, recurse)
+}
+
+func (c *IRODSFSClientReadOnly) SetInheritance(path string, inherit bool, recurse bool) error {
+	return c.SetInheritanceCtx(context.Background(), path, inherit, recurse)
+}
+
+func (c *IRODSFSClientReadOnly) SetInheritanceCtx(ctx context.Context, path string, inherit bool, recurse bool) error {
+	check := c.check
+	if recurse {
+		check = c.checkTree
+	}
+	if err := check("setinheritance", path); err != nil {
+		return err
+	}
+	return c.client.SetInheritanceCtx(ctx, path, inherit, recurse)
+}
+
+func (c *IRODSFSClientReadOnly) CopyFileToFile(srcPath string, destPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.CopyFileToFileCtx(context.Background(), srcPath, destPath, transferCallback)
+}
+
+func (c *IRODSFSClientReadOnly) CopyFileToFileCtx(ctx context.Context, srcPath string, destPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	if err := c.check("copy", destPath); err != nil {
+		return err
+	}
+	return c.client.CopyFileToFileCtx(ctx, srcPath, destPath, transferCallback)
+}
+
+func (c *IRODSFSClientReadOnly) CopyDirToDir(srcPath string, destPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.CopyDirToDirCtx(context.Background(), srcPath, destPath, transferCallback)
+}
+
+func (c *IRODSFSClientReadOnly) CopyDirToDirCtx(ctx context.Context, srcPath string, destPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	if err := c.checkTree("copy", destPath); err != nil {
+		return err
+	}
+	return c.client.CopyDirToDirCtx(ctx, srcPath, destPath, transferCallback)
+}
+
+func (c *IRODSFSClientReadOnly) Sync() error {
+	return c.SyncCtx(context.Background())
+}
+
+func (c *IRODSFSClientReadOnly) SyncCtx(ctx context.Context) error {
+	return c.client.SyncCtx(ctx)
+}
+
+func (c *IRODSFSClientReadOnly) DownloadFile(irodsPath string, localPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.DownloadFileCtx(context.Background(), irodsPath, localPath, transferCallback)
+}
+
+func (c *IRODSFSClientReadOnly) DownloadFileCtx(ctx context.Context, irodsPath string, localPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.client.DownloadFileCtx(ctx, irodsPath, localPath, transferCallback)
+}
+
+func (c *IRODSFSClientReadOnly) DownloadFileParallel(irodsPath string, localPath string, taskNum int, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.DownloadFileParallelCtx(context.Background(), irodsPath, localPath, taskNum, transferCallback)
+}
+
+func (c *IRODSFSClientReadOnly) DownloadFileParallelCtx(ctx context.Context, irodsPath string, localPath string, taskNum int, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.client.DownloadFileParallelCtx(ctx, irodsPath, localPath, taskNum, transferCallback)
+}
+
+func (c *IRODSFSClientReadOnly) DownloadFileWithCallback(irodsPath string, blockSize int, numBlocks int, blockReadyCallback irodsclient_common.DataObjectBlockCallback, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.DownloadFileWithCallbackCtx(context.Background(), irodsPath, blockSize, numBlocks, blockReadyCallback, transferCallback)
+}
+
+func (c *IRODSFSClientReadOnly) DownloadFileWithCallbackCtx(ctx context.Context, irodsPath string, blockSize int, numBlocks int, blockReadyCallback irodsclient_common.DataObjectBlockCallback, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.client.DownloadFileWithCallbackCtx(ctx, irodsPath, blockSize, numBlocks, blockReadyCallback, transferCallback)
+}
+
+func (c *IRODSFSClientReadOnly) DownloadFileParallelWithCallback(irodsPath string, blockSize int, numBlocks int, blockReadyCallback irodsclient_common.DataObjectBlockCallback, taskNum int, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.DownloadFileParallelWithCallbackCtx(context.Background(), irodsPath, blockSize, numBlocks, blockReadyCallback, taskNum, transferCallback)
+}
+
+func (c *IRODSFSClientReadOnly) DownloadFileParallelWithCallbackCtx(ctx context.Context, irodsPath string, blockSize int, numBlocks int, blockReadyCallback irodsclient_common.DataObjectBlockCallback, taskNum int, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.client.DownloadFileParallelWithCallbackCtx(ctx, irodsPath, blockSize, numBlocks, blockReadyCallback, taskNum, transferCallback)
+}
+
+func (c *IRODSFSClientReadOnly) UploadFile(localPath string, irodsPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.UploadFileCtx(context.Background(), localPath, irodsPath, transferCallback)
+}
+
+func (c *IRODSFSClientReadOnly) UploadFileCtx(ctx context.Context, localPath string, irodsPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	if err := c.check("upload", irodsPath); err != nil {
+		return err
+	}
+	return c.client.UploadFileCtx(ctx, localPath, irodsPath, transferCallback)
+}
+
+func (c *IRODSFSClientReadOnly) UploadFileParallel(localPath string, irodsPath string, taskNum int, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.UploadFileParallelCtx(context.Background(), localPath, irodsPath, taskNum, transferCallback)
+}
+
+func (c *IRODSFSClientReadOnly) UploadFileParallelCtx(ctx context.Context, localPath string, irodsPath string, taskNum int, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	if err := c.check("upload", irodsPath); err != nil {
+		return err
+	}
+	return c.client.UploadFileParallelCtx(ctx, localPath, irodsPath, taskNum, transferCallback)
+}
+
+func (c *IRODSFSClientReadOnly) DownloadFileWithOptions(irodsPath string, localPath string, options *TransferOptions) error {
+	return c.DownloadFileWithOptionsCtx(context.Background(), irodsPath, localPath, options)
+}
+
+func (c *IRODSFSClientReadOnly) DownloadFileWithOptionsCtx(ctx context.Context, irodsPath string, localPath string, options *TransferOptions) error {
+	return c.client.DownloadFileWithOptionsCtx(ctx, irodsPath, localPath, options)
+}
+
+func (c *IRODSFSClientReadOnly) UploadFileWithOptions(localPath string, irodsPath string, options *TransferOptions) error {
+	return c.UploadFileWithOptionsCtx(context.Background(), localPath, irodsPath, options)
+}
+
+func (c *IRODSFSClientReadOnly) UploadFileWithOptionsCtx(ctx context.Context, localPath string, irodsPath string, options *TransferOptions) error {
+	if err := c.check("upload", irodsPath); err != nil {
+		return err
+	}
+	return c.client.UploadFileWithOptionsCtx(ctx, localPath, irodsPath, options)
+}
+
+func (c *IRODSFSClientReadOnly) GetTransferManager() *TransferManager {
+	return c.client.GetTransferManager()
+}
+
+func (c *IRODSFSClientReadOnly) UploadDir(localDir string, irodsDir string, options *DirTransferOptions) (*DirTransferReport, error) {
+	return c.UploadDirCtx(context.Background(), localDir, irodsDir, options)
+}
+
+func (c *IRODSFSClientReadOnly) UploadDirCtx(ctx context.Context, localDir string, irodsDir string, options *DirTransferOptions) (*DirTransferReport, error) {
+	if err := c.checkTree("upload", irodsDir); err != nil {
+		return nil, err
+	}
+	return c.client.UploadDirCtx(ctx, localDir, irodsDir, options)
+}
+
+func (c *IRODSFSClientReadOnly) DownloadDir(irodsDir string, localDir string, options *DirTransferOptions) (*DirTransferReport, error) {
+	return c.DownloadDirCtx(context.Background(), irodsDir, localDir, options)
+}
+
+func (c *IRODSFSClientReadOnly) DownloadDirCtx(ctx context.Context, irodsDir string, localDir string, options *DirTransferOptions) (*DirTransferReport, error) {
+	return c.client.DownloadDirCtx(ctx, irodsDir, localDir, options)
+}
+
+func (c *IRODSFSClientReadOnly) CacheFile(irodsPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.CacheFileCtx(context.Background(), irodsPath, transferCallback)
+}
+
+func (c *IRODSFSClientReadOnly) CacheFileCtx(ctx context.Context, irodsPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.client.CacheFileCtx(ctx, irodsPath, transferCallback)
+}
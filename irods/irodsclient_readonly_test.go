@@ -0,0 +1,120 @@
+package irods_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/cyverse/irodsfs-common/irods"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadOnlyClientAllPaths(t *testing.T) {
+	c, home := newFakeClient(t, []string{"dir"}, map[string]string{"file": "data"})
+	client, err := irods.NewIRODSFSClientReadOnly(c, nil)
+	require.NoError(t, err)
+
+	localPath := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(localPath, []byte("local"), 0644))
+
+	calls := map[string]func() error{
+		"RemoveFile": func() error { return client.RemoveFile(home+"/file", false) },
+		"RemoveDir":  func() error { return client.RemoveDir(home+"/dir", true, false) },
+		"MakeDir":    func() error { return client.MakeDir(home+"/new", false) },
+		"Rename":     func() error { return client.RenameFileToFile(home+"/file", home+"/moved") },
+		"Truncate":   func() error { return client.TruncateFile(home+"/file", 0) },
+		"SetXattr":   func() error { return client.SetXattr(home+"/file", "user.a", []byte("b")) },
+		"Upload":     func() error { return client.UploadFileParallel(localPath, home+"/uploaded", 1, nil) },
+		"CreateFile": func() error {
+			_, err := client.CreateFile(home+"/new", "w")
+			return err
+		},
+		"OpenFile": func() error {
+			_, err := client.OpenFile(home+"/file", "r+")
+			return err
+		},
+	}
+	for name, call := range calls {
+		t.Run(name, func(t *testing.T) {
+			err := call()
+			assert.ErrorIs(t, err, irods.ErrReadOnly)
+			assert.Equal(t, syscall.EROFS, irods.ErrnoFor(err))
+		})
+	}
+
+	dirs, files := fakeTree(t, c)
+	assert.Equal(t, map[string]bool{"dir": true}, dirs)
+	assert.Equal(t, map[string]string{"file": "data"}, files)
+
+	handle, err := client.OpenFile(home+"/file", "r")
+	require.NoError(t, err)
+	buffer := make([]byte, 4)
+	_, err = handle.ReadAt(buffer, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "data", string(buffer))
+
+	require.NoError(t, client.DownloadFileParallel(home+"/file", localPath, 1, nil))
+}
+
+func TestReadOnlyClientPathPrefixes(t *testing.T) {
+	c, home := newFakeClient(t, []string{"ro", "rw/sub"}, map[string]string{"ro/file": "data"})
+	client, err := irods.NewIRODSFSClientReadOnly(c, &irods.ReadOnlyConfig{PathPrefixes: []string{home + "/ro/", home + "/rw/sub/locked"}})
+	require.NoError(t, err)
+
+	assert.True(t, client.IsReadOnly(home+"/ro"))
+	assert.True(t, client.IsReadOnly(home+"/ro/file"))
+	assert.False(t, client.IsReadOnly(home+"/rofile"))
+	assert.False(t, client.IsReadOnly(home+"/rw/sub"))
+
+	require.NoError(t, client.MakeDir(home+"/rw/dir", false))
+	assert.ErrorIs(t, client.MakeDir(home+"/ro/dir", false), irods.ErrReadOnly)
+
+	// Moving a file out of or into a read-only tree changes it
+	assert.ErrorIs(t, client.RenameFileToFile(home+"/ro/file", home+"/rw/file"), irods.ErrReadOnly)
+
+	// Calls that change whole trees are rejected if the tree contains a read-only path
+	assert.ErrorIs(t, client.RemoveDir(home+"/rw", true, false), irods.ErrReadOnly)
+	assert.ErrorIs(t, client.RenameDirToDir(home+"/rw/sub", home+"/rw/moved"), irods.ErrReadOnly)
+	require.NoError(t, client.RemoveDir(home+"/rw/dir", true, false))
+
+	_, err = irods.NewIRODSFSClientReadOnly(c, &irods.ReadOnlyConfig{PathPrefixes: []string{"zone/ro"}})
+	assert.Error(t, err)
+}
+
+func TestReadOnlyClientConfig(t *testing.T) {
+	c, home := newFakeClient(t, nil, nil)
+
+	// A config without prefixes protects nothing unless it covers all paths
+	client, err := irods.NewIRODSFSClientReadOnly(c, &irods.ReadOnlyConfig{})
+	require.NoError(t, err)
+	assert.False(t, client.IsReadOnly(home))
+	require.NoError(t, client.MakeDir(home+"/dir", false))
+
+	client, err = irods.NewIRODSFSClientReadOnly(c, &irods.ReadOnlyConfig{AllPaths: true})
+	require.NoError(t, err)
+	assert.True(t, client.IsReadOnly("/"))
+	assert.ErrorIs(t, client.RemoveDir(home+"/dir", false, false), irods.ErrReadOnly)
+}
+
+func TestReadOnlyClientContext(t *testing.T) {
+	c, home := newFakeClient(t, nil, map[string]string{"file": "data"})
+	faulty := newTestFaultyClient(t, c, &irods.FaultInjectionConfig{
+		Faults: []irods.Fault{irods.LatencyFault("DownloadFile", time.Hour)},
+	})
+	client, err := irods.NewIRODSFSClientReadOnly(faulty, nil)
+	require.NoError(t, err)
+
+	// Cancellation reaches the wrapped client
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = client.DownloadFileWithOptionsCtx(ctx, home+"/file", filepath.Join(t.TempDir(), "file"), nil)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Minute)
+
+	assert.ErrorIs(t, client.MakeDirCtx(context.Background(), home+"/new", false), irods.ErrReadOnly)
+}
@@ -0,0 +1,890 @@
+package irods
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	irodsclient_fs "github.com/cyverse/go-irodsclient/fs"
+	irodsclient_common "github.com/cyverse/go-irodsclient/irods/common"
+	irodsclient_metrics "github.com/cyverse/go-irodsclient/irods/metrics"
+	irodsclient_types "github.com/cyverse/go-irodsclient/irods/types"
+)
+
+const (
+	// DefaultRetryMaxAttempts is the number of attempts of a call, including the first
+	DefaultRetryMaxAttempts = 3
+	// DefaultRetryInitialBackoff is the wait before the first retry of a call
+	DefaultRetryInitialBackoff = 100 * time.Millisecond
+	// DefaultRetryMaxBackoff is the longest wait between attempts of a call
+	DefaultRetryMaxBackoff = 5 * time.Second
+)
+
+// RetryPolicy tells whether and how calls of a method are retried after transient errors
+type RetryPolicy string
+
+const (
+	// RetryNever returns the first error
+	RetryNever RetryPolicy = "never"
+	// RetryIdempotent repeats the call, for methods whose repetition has the same effect as one call
+	RetryIdempotent RetryPolicy = "idempotent"
+	// RetryChecked repeats the call unless iRODS shows that the failed attempt took effect anyway, for
+	// methods that fail when repeated after success. Methods without a check are not retried.
+	RetryChecked RetryPolicy = "checked"
+)
+
+// defaultRetryPolicies are the policies of methods not configured in RetryConfig.Policies. Methods
+// missing here, ExistsDir, ExistsFile and the write methods of file handles, are not retried.
+var defaultRetryPolicies = map[string]RetryPolicy{
+	"List":                             RetryIdempotent,
+	"Stat":                             RetryIdempotent,
+	"TruncateFile":                     RetryIdempotent,
+	"CreateFile":                       RetryIdempotent,
+	"OpenFile":                         RetryIdempotent,
+	"CreateFileWithOptions":            RetryIdempotent,
+	"OpenFileWithOptions":              RetryIdempotent,
+	"ListReplicas":                     RetryIdempotent,
+	"Readlink":                         RetryIdempotent,
+	"GetXattr":                         RetryIdempotent,
+	"SetXattr":                         RetryIdempotent,
+	"ListXattr":                        RetryIdempotent,
+	"GetACL":                           RetryIdempotent,
+	"SetACL":                           RetryIdempotent,
+	"SetInheritance":                   RetryIdempotent,
+	"CopyFileToFile":                   RetryIdempotent,
+	"CopyDirToDir":                     RetryIdempotent,
+	"Sync":                             RetryIdempotent,
+	"DownloadFile":                     RetryIdempotent,
+	"DownloadFileParallel":             RetryIdempotent,
+	"DownloadFileWithCallback":         RetryIdempotent,
+	"DownloadFileParallelWithCallback": RetryIdempotent,
+	"UploadFile":                       RetryIdempotent,
+	"UploadFileParallel":               RetryIdempotent,
+	"DownloadFileWithOptions":          RetryIdempotent,
+	"UploadFileWithOptions":            RetryIdempotent,
+	"UploadDir":                        RetryIdempotent,
+	"DownloadDir":                      RetryIdempotent,
+	"CacheFile":                        RetryIdempotent,
+	"ReadAt":                           RetryIdempotent,
+
+	"RemoveFile":       RetryChecked,
+	"RemoveDir":        RetryChecked,
+	"MakeDir":          RetryChecked,
+	"RenameDirToDir":   RetryChecked,
+	"RenameFileToFile": RetryChecked,
+	"Symlink":          RetryChecked,
+	"RemoveXattr":      RetryChecked,
+}
+
+// RetryConfig configures an IRODSFSClientRetry
+type RetryConfig struct {
+	MaxAttempts    int                    // Attempts of a call, including the first (default: DefaultRetryMaxAttempts)
+	InitialBackoff time.Duration          // Wait before the first retry, doubled for every further one (default: DefaultRetryInitialBackoff)
+	MaxBackoff     time.Duration          // Longest wait between attempts (default: DefaultRetryMaxBackoff)
+	Policies       map[string]RetryPolicy // Policies by method name without the Ctx suffix, overriding the defaults
+}
+
+// RetryMetrics counts the retries of an IRODSFSClientRetry
+type RetryMetrics struct {
+	Retries   int64            // Attempts after the first
+	Recovered int64            // Calls that succeeded after a transient error
+	Exhausted int64            // Calls that failed with a transient error on their last attempt
+	Reopened  int64            // File handles re-opened to retry a read
+	ByMethod  map[string]int64 // Retries by method
+}
+
+// IRODSFSClientRetry is an IRODSFSClient that retries calls of another client that fail with transient
+// errors, see ErrTransient. Reads of file handles opened read-only are retried on a re-opened handle at
+// the same offset; writes are not retried since a dropped handle may have written part of the data.
+// The Ctx variants stop retrying once ctx is done, also while waiting between attempts.
+type IRODSFSClientRetry struct {
+	client IRODSFSClientCtx
+	config RetryConfig
+
+	metricsMu sync.Mutex
+	metrics   RetryMetrics
+	// retries already added to the metrics of the wrapped client
+	reportedRetries int64
+}
+
+// NewIRODSFSClientRetry creates an IRODSFSClientRetry that retries the calls of client
+func NewIRODSFSClientRetry(client IRODSFSClient, config *RetryConfig) (*IRODSFSClientRetry, error) {
+	if client == nil {
+		return nil, errors.New("client is required")
+	}
+
+	retryConfig := RetryConfig{}
+	if config != nil {
+		retryConfig = *config
+	}
+	if retryConfig.MaxAttempts <= 0 {
+		retryConfig.MaxAttempts = DefaultRetryMaxAttempts
+	}
+	if retryConfig.InitialBackoff <= 0 {
+		retryConfig.InitialBackoff = DefaultRetryInitialBackoff
+	}
+	if retryConfig.MaxBackoff <= 0 {
+		retryConfig.MaxBackoff = DefaultRetryMaxBackoff
+	}
+
+	return &IRODSFSClientRetry{
+		client:  WithContext(client),
+		config:  retryConfig,
+		metrics: RetryMetrics{ByMethod: map[string]int64{}},
+	}, nil
+}
+
+// GetRetryMetrics returns a snapshot of the retry metrics
+func (c *IRODSFSClientRetry) GetRetryMetrics() RetryMetrics {
+	c.metricsMu.Lock()
+	defer c.metricsMu.Unlock()
+
+	snapshot := c.metrics
+	snapshot.ByMethod = make(map[string]int64, len(c.metrics.ByMethod))
+	for method, retries := range c.metrics.ByMethod {
+		snapshot.ByMethod[method] = retries
+	}
+	return snapshot
+}
+
+func (c *IRODSFSClientRetry) record(update func(metrics *RetryMetrics)) {
+	c.metricsMu.Lock()
+	defer c.metricsMu.Unlock()
+
+	update(&c.metrics)
+}
+
+func (c *IRODSFSClientRetry) policy(method string) RetryPolicy {
+	if policy, ok := c.config.Policies[method]; ok {
+		return policy
+	}
+	if policy, ok := defaultRetryPolicies[method]; ok {
+		return policy
+	}
+	return RetryNever
+}
+
+// backoff returns the wait before the given retry, counting from 1
+func (c *IRODSFSClientRetry) backoff(retry int) time.Duration {
+	backoff := c.config.InitialBackoff
+	for i := 1; i < retry && backoff < c.config.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, c.config.MaxBackoff)
+}
+
+// waitBackoff waits before the given retry of method and counts it, returning ctx.Err() if ctx is done
+// first
+func (c *IRODSFSClientRetry) waitBackoff(ctx context.Context, method string, retry int) error {
+	timer := time.NewTimer(c.backoff(retry))
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+	}
+
+	c.record(func(metrics *RetryMetrics) {
+		metrics.Retries++
+		metrics.ByMethod[method]++
+	})
+	return nil
+}
+
+// isTransient returns true if err may not recur on retry
+func isTransient(err error) bool {
+	return err != nil && errorKind(err) == ErrTransient
+}
+
+// retry calls op until it succeeds, fails with an error that is not transient, runs out of attempts or
+// ctx is done. For RetryChecked methods, tookEffect is asked after failures whether an attempt took
+// effect after all.
+func (c *IRODSFSClientRetry) retry(ctx context.Context, method string, op func() error, tookEffect func() bool) error {
+	policy := c.policy(method)
+	if policy == RetryChecked && tookEffect == nil {
+		policy = RetryNever
+	}
+
+	err := op()
+	for attempt := 2; isTransient(err) && policy != RetryNever; attempt++ {
+		if policy == RetryChecked && tookEffect() {
+			c.record(func(metrics *RetryMetrics) { metrics.Recovered++ })
+			return nil
+		}
+		if attempt > c.config.MaxAttempts {
+			c.record(func(metrics *RetryMetrics) { metrics.Exhausted++ })
+			return err
+		}
+
+		if waitErr := c.waitBackoff(ctx, method, attempt-1); waitErr != nil {
+			return waitErr
+		}
+
+		err = op()
+		if err != nil && !isTransient(err) && policy == RetryChecked && tookEffect() {
+			// The retry failed because an earlier attempt took effect, e.g. a rename whose source is gone
+			err = nil
+		}
+		if err == nil {
+			c.record(func(metrics *RetryMetrics) { metrics.Recovered++ })
+		}
+	}
+	return err
+}
+
+// exists returns true if Stat finds path, with an entry of the given type unless the type is empty
+func (c *IRODSFSClientRetry) exists(ctx context.Context, path string, entryType irodsclient_fs.EntryType) bool {
+	entry, err := c.client.StatCtx(ctx, path)
+	return err == nil && (entryType == "" || entry.Type == entryType)
+}
+
+// missing returns true if Stat does not find path
+func (c *IRODSFSClientRetry) missing(ctx context.Context, path string) bool {
+	_, err := c.client.StatCtx(ctx, path)
+	return errors.Is(err, ErrNotFound)
+}
+
+func (c *IRODSFSClientRetry) Release() {
+	c.client.Release()
+}
+
+func (c *IRODSFSClientRetry) GetAccount() *irodsclient_types.IRODSAccount {
+	return c.client.GetAccount()
+}
+
+func (c *IRODSFSClientRetry) GetApplicationName() string {
+	return c.client.GetApplicationName()
+}
+
+func (c *IRODSFSClientRetry) GetOpenConnections() int {
+	return c.client.GetOpenConnections()
+}
+
+// GetMetrics returns the metrics of the client, counting retried attempts as request failures.
+// The wrapped client keeps its metrics, so only the retries since the last call are added to them.
+// GetRetryMetrics has the details.
+func (c *IRODSFSClientRetry) GetMetrics() *irodsclient_metrics.IRODSMetrics {
+	metrics := c.client.GetMetrics()
+
+	c.metricsMu.Lock()
+	defer c.metricsMu.Unlock()
+
+	metrics.IncreaseCounterForRequestResponseFailures(uint64(c.metrics.Retries - c.reportedRetries))
+	c.reportedRetries = c.metrics.Retries
+	return metrics
+}
+
+func (c *IRODSFSClientRetry) List(path string) ([]*irodsclient_fs.Entry, error) {
+	return c.ListCtx(context.Background(), path)
+}
+
+func (c *IRODSFSClientRetry) ListCtx(ctx context.Context, path string) ([]*irodsclient_fs.Entry, error) {
+	var entries []*irodsclient_fs.Entry
+	err := c.retry(ctx, "List", func() error {
+		var err error
+		entries, err = c.client.ListCtx(ctx, path)
+		return err
+	}, nil)
+	return entries, err
+}
+
+func (c *IRODSFSClientRetry) Stat(path string) (*irodsclient_fs.Entry, error) {
+	return c.StatCtx(context.Background(), path)
+}
+
+func (c *IRODSFSClientRetry) StatCtx(ctx context.Context, path string) (*irodsclient_fs.Entry, error) {
+	var entry *irodsclient_fs.Entry
+	err := c.retry(ctx, "Stat", func() error {
+		var err error
+		entry, err = c.client.StatCtx(ctx, path)
+		return err
+	}, nil)
+	return entry, err
+}
+
+func (c *IRODSFSClientRetry) ExistsDir(path string) bool {
+	return c.ExistsDirCtx(context.Background(), path)
+}
+
+// ExistsDirCtx is not retried since failures are reported as false
+func (c *IRODSFSClientRetry) ExistsDirCtx(ctx context.Context, path string) bool {
+	return c.client.ExistsDirCtx(ctx, path)
+}
+
+func (c *IRODSFSClientRetry) ExistsFile(path string) bool {
+	return c.ExistsFileCtx(context.Background(), path)
+}
+
+// ExistsFileCtx is not retried since failures are reported as false
+func (c *IRODSFSClientRetry) ExistsFileCtx(ctx context.Context, path string) bool {
+	return c.client.ExistsFileCtx(ctx, path)
+}
+
+func (c *IRODSFSClientRetry) RemoveFile(path string, force bool) error {
+	return c.RemoveFileCtx(context.Background(), path, force)
+}
+
+func (c *IRODSFSClientRetry) RemoveFileCtx(ctx context.Context, path string, force bool) error {
+	return c.retry(ctx, "RemoveFile", func() error {
+		return c.client.RemoveFileCtx(ctx, path, force)
+	}, func() bool {
+		return c.missing(ctx, path)
+	})
+}
+
+func (c *IRODSFSClientRetry) RemoveDir(path string, recurse bool, force bool) error {
+	return c.RemoveDirCtx(context.Background(), path, recurse, force)
+}
+
+func (c *IRODSFSClientRetry) RemoveDirCtx(ctx context.Context, path string, recurse bool, force bool) error {
+	return c.retry(ctx, "RemoveDir", func() error {
+		return c.client.RemoveDirCtx(ctx, path, recurse, force)
+	}, func() bool {
+		return c.missing(ctx, path)
+	})
+}
+
+func (c *IRODSFSClientRetry) MakeDir(path string, recurse bool) error {
+	return c.MakeDirCtx(context.Background(), path, recurse)
+}
+
+func (c *IRODSFSClientRetry) MakeDirCtx(ctx context.Context, path string, recurse bool) error {
+	return c.retry(ctx, "MakeDir", func() error {
+		return c.client.MakeDirCtx(ctx, path, recurse)
+	}, func() bool {
+		return c.exists(ctx, path, irodsclient_fs.DirectoryEntry)
+	})
+}
+
+func (c *IRODSFSClientRetry) RenameDirToDir(srcPath string, destPath string) error {
+	return c.RenameDirToDirCtx(context.Background(), srcPath, destPath)
+}
+
+func (c *IRODSFSClientRetry) RenameDirToDirCtx(ctx context.Context, srcPath string, destPath string) error {
+	return c.retry(ctx, "RenameDirToDir", func() error {
+		return c.client.RenameDirToDirCtx(ctx, srcPath, destPath)
+	}, func() bool {
+		return c.missing(ctx, srcPath) && c.exists(ctx, destPath, irodsclient_fs.DirectoryEntry)
+	})
+}
+
+func (c *IRODSFSClientRetry) RenameFileToFile(srcPath string, destPath string) error {
+	return c.RenameFileToFileCtx(context.Background(), srcPath, destPath)
+}
+
+func (c *IRODSFSClientRetry) RenameFileToFileCtx(ctx context.Context, srcPath string, destPath string) error {
+	return c.retry(ctx, "RenameFileToFile", func() error {
+		return c.client.RenameFileToFileCtx(ctx, srcPath, destPath)
+	}, func() bool {
+		return c.missing(ctx, srcPath) && c.exists(ctx, destPath, "")
+	})
+}
+
+// openHandle retries opening a handle and wraps it to retry reads
+func (c *IRODSFSClientRetry) openHandle(ctx context.Context, method string, path string, open func() (IRODSFSFileHandle, error)) (IRODSFSFileHandle, error) {
+	var handle IRODSFSFileHandle
+	err := c.retry(ctx, method, func() error {
+		var err error
+		handle, err = open()
+		return err
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.newRetryHandle(handle, path), nil
+}
+
+func (c *IRODSFSClientRetry) CreateFile(path string, mode string) (IRODSFSFileHandle, error) {
+	return c.CreateFileCtx(context.Background(), path, mode)
+}
+
+func (c *IRODSFSClientRetry) CreateFileCtx(ctx context.Context, path string, mode string) (IRODSFSFileHandle, error) {
+	return c.openHandle(ctx, "CreateFile", path, func() (IRODSFSFileHandle, error) {
+		return c.client.CreateFileCtx(ctx, path, mode)
+	})
+}
+
+func (c *IRODSFSClientRetry) OpenFile(path string, mode string) (IRODSFSFileHandle, error) {
+	return c.OpenFileCtx(context.Background(), path, mode)
+}
+
+func (c *IRODSFSClientRetry) OpenFileCtx(ctx context.Context, path string, mode string) (IRODSFSFileHandle, error) {
+	return c.openHandle(ctx, "OpenFile", path, func() (IRODSFSFileHandle, error) {
+		return c.client.OpenFileCtx(ctx, path, mode)
+	})
+}
+
+func (c *IRODSFSClientRetry) TruncateFile(path string, size int64) error {
+	return c.TruncateFileCtx(context.Background(), path, size)
+}
+
+func (c *IRODSFSClientRetry) TruncateFileCtx(ctx context.Context, path string, size int64) error {
+	return c.retry(ctx, "TruncateFile", func() error {
+		return c.client.TruncateFileCtx(ctx, path, size)
+	}, nil)
+}
+
+func (c *IRODSFSClientRetry) CreateFileWithOptions(path string, mode string, options *OpenOptions) (IRODSFSFileHandle, error) {
+	return c.CreateFileWithOptionsCtx(context.Background(), path, mode, options)
+}
+
+func (c *IRODSFSClientRetry) CreateFileWithOptionsCtx(ctx context.Context, path string, mode string, options *OpenOptions) (IRODSFSFileHandle, error) {
+	return c.openHandle(ctx, "CreateFileWithOptions", path, func() (IRODSFSFileHandle, error) {
+		return c.client.CreateFileWithOptionsCtx(ctx, path, mode, options)
+	})
+}
+
+func (c *IRODSFSClientRetry) OpenFileWithOptions(path string, mode string, options *OpenOptions) (IRODSFSFileHandle, error) {
+	return c.OpenFileWithOptionsCtx(context.Background(), path, mode, options)
+}
+
+func (c *IRODSFSClientRetry) OpenFileWithOptionsCtx(ctx context.Context, path string, mode string, options *OpenOptions) (IRODSFSFileHandle, error) {
+	return c.openHandle(ctx, "OpenFileWithOptions", path, func() (IRODSFSFileHandle, error) {
+		return c.client.OpenFileWithOptionsCtx(ctx, path, mode, options)
+	})
+}
+
+func (c *IRODSFSClientRetry) ListReplicas(path string) ([]*irodsclient_types.IRODSReplica, error) {
+	return c.ListReplicasCtx(context.Background(), path)
+}
+
+func (c *IRODSFSClientRetry) ListReplicasCtx(ctx context.Context, path string) ([]*irodsclient_types.IRODSReplica, error) {
+	var replicas []*irodsclient_types.IRODSReplica
+	err := c.retry(ctx, "ListReplicas", func() error {
+		var err error
+		replicas, err = c.client.ListReplicasCtx(ctx, path)
+		return err
+	}, nil)
+	return replicas, err
+}
+
+func (c *IRODSFSClientRetry) Symlink(target string, linkPath string) error {
+	return c.SymlinkCtx(context.Background(), target, linkPath)
+}
+
+func (c *IRODSFSClientRetry) SymlinkCtx(ctx context.Context, target string, linkPath string) error {
+	return c.retry(ctx, "Symlink", func() error {
+		return c.client.SymlinkCtx(ctx, target, linkPath)
+	}, func() bool {
+		linkTarget, err := c.client.ReadlinkCtx(ctx, linkPath)
+		return err == nil && linkTarget == target
+	})
+}
+
+func (c *IRODSFSClientRetry) Readlink(path string) (string, error) {
+	return c.ReadlinkCtx(context.Background(), path)
+}
+
+func (c *IRODSFSClientRetry) ReadlinkCtx(ctx context.Context, path string) (string, error) {
+	var target string
+	err := c.retry(ctx, "Readlink", func() error {
+		var err error
+		target, err = c.client.ReadlinkCtx(ctx, path)
+		return err
+	}, nil)
+	return target, err
+}
+
+func (c *IRODSFSClientRetry) GetXattr(path string, name string) ([]byte, error) {
+	return c.GetXattrCtx(context.Background(), path, name)
+}
+
+func (c *IRODSFSClientRetry) GetXattrCtx(ctx context.Context, path string, name string) ([]byte, error) {
+	var value []byte
+	err := c.retry(ctx, "GetXattr", func() error {
+		var err error
+		value, err = c.client.GetXattrCtx(ctx, path, name)
+		return err
+	}, nil)
+	return value, err
+}
+
+func (c *IRODSFSClientRetry) SetXattr(path string, name string, value []byte) error {
+	return c.SetXattrCtx(context.Background(), path, name, value)
+}
+
+func (c *IRODSFSClientRetry) SetXattrCtx(ctx context.Context, path string, name string, value []byte) error {
+	return c.retry(ctx, "SetXattr", func() error {
+		return c.client.SetXattrCtx(ctx, path, name, value)
+	}, nil)
+}
+
+func (c *IRODSFSClientRetry) ListXattr(path string) ([]string, error) {
+	return c.ListXattrCtx(context.Background(), path)
+}
+
+func (c *IRODSFSClientRetry) ListXattrCtx(ctx context.Context, path string) ([]string, error) {
+	var names []string
+	err := c.retry(ctx, "ListXattr", func() error {
+		var err error
+		names, err = c.client.ListXattrCtx(ctx, path)
+		return err
+	}, nil)
+	return names, err
+}
+
+func (c *IRODSFSClientRetry) RemoveXattr(path string, name string) error {
+	return c.RemoveXattrCtx(context.Background(), path, name)
+}
+
+func (c *IRODSFSClientRetry) RemoveXattrCtx(ctx context.Context, path string, name string) error {
+	return c.retry(ctx, "RemoveXattr", func() error {
+		return c.client.RemoveXattrCtx(ctx, path, name)
+	}, func() bool {
+		_, err := c.client.GetXattrCtx(ctx, path, name)
+		return errors.Is(err, ErrNoAttribute)
+	})
+}
+
+func (c *IRODSFSClientRetry) GetACL(path string) ([]*irodsclient_types.IRODSAccess, error) {
+	return c.GetACLCtx(context.Background(), path)
+}
+
+func (c *IRODSFSClientRetry) GetACLCtx(ctx context.Context, path string) ([]*irodsclient_types.IRODSAccess, error) {
+	var accesses []*irodsclient_types.IRODSAccess
+	err := c.retry(ctx, "GetACL", func() error {
+		var err error
+		accesses, err = c.client.GetACLCtx(ctx, path)
+		return err
+	}, nil)
+	return accesses, err
+}
+
+func (c *IRODSFSClientRetry) SetACL(path string, userName string, zoneName string, access irodsclient_types.IRODSAccessLevelType, recurse bool) error {
+	return c.SetACLCtx(context.Background(), path, userName, zoneName, access, recurse)
+}
+
+func (c *IRODSFSClientRetry) SetACLCtx(ctx context.Context, path string, userName string, zoneName string, access irodsclient_types.IRODSAccessLevelType, recurse bool) error {
+	return c.retry(ctx, "SetACL", func() error {
+		return c.client.SetACLCtx(ctx, path, userName, zoneName, access, recurse)
+	}, nil)
+}
+
+func (c *IRODSFSClientRetry) SetInheritance(path string, inherit bool, recurse bool) error {
+	return c.SetInheritanceCtx(context.Background(), path, inherit, recurse)
+}
+
+func (c *IRODSFSClientRetry) SetInheritanceCtx(ctx context.Context, path string, inherit bool, recurse bool) error {
+	return c.retry(ctx, "SetInheritance", func() error {
+		return c.client.SetInheritanceCtx(ctx, path, inherit, recurse)
+	}, nil)
+}
+
+func (c *IRODSFSClientRetry) CopyFileToFile(srcPath string, destPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.CopyFileToFileCtx(context.Background(), srcPath, destPath, transferCallback)
+}
+
+func (c *IRODSFSClientRetry) CopyFileToFileCtx(ctx context.Context, srcPath string, destPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.retry(ctx, "CopyFileToFile", func() error {
+		return c.client.CopyFileToFileCtx(ctx, srcPath, destPath, transferCallback)
+	}, nil)
+}
+
+func (c *IRODSFSClientRetry) CopyDirToDir(srcPath string, destPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.CopyDirToDirCtx(context.Background(), srcPath, destPath, transferCallback)
+}
+
+func (c *IRODSFSClientRetry) CopyDirToDirCtx(ctx context.Context, srcPath string, destPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.retry(ctx, "CopyDirToDir", func() error {
+		return c.client.CopyDirToDirCtx(ctx, srcPath, destPath, transferCallback)
+	}, nil)
+}
+
+func (c *IRODSFSClientRetry) Sync() error {
+	return c.SyncCtx(context.Background())
+}
+
+func (c *IRODSFSClientRetry) SyncCtx(ctx context.Context) error {
+	return c.retry(ctx, "Sync", func() error {
+		return c.client.SyncCtx(ctx)
+	}, nil)
+}
+
+func (c *IRODSFSClientRetry) DownloadFile(irodsPath string, localPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.DownloadFileCtx(context.Background(), irodsPath, localPath, transferCallback)
+}
+
+func (c *IRODSFSClientRetry) DownloadFileCtx(ctx context.Context, irodsPath string, localPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.retry(ctx, "DownloadFile", func() error {
+		return c.client.DownloadFileCtx(ctx, irodsPath, localPath, transferCallback)
+	}, nil)
+}
+
+func (c *IRODSFSClientRetry) DownloadFileParallel(irodsPath string, localPath string, taskNum int, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.DownloadFileParallelCtx(context.Background(), irodsPath, localPath, taskNum, transferCallback)
+}
+
+func (c *IRODSFSClientRetry) DownloadFileParallelCtx(ctx context.Context, irodsPath string, localPath string, taskNum int, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.retry(ctx, "DownloadFileParallel", func() error {
+		return c.client.DownloadFileParallelCtx(ctx, irodsPath, localPath, taskNum, transferCallback)
+	}, nil)
+}
+
+// DownloadFileWithCallback passes blocks again from the start of the file when retried
+func (c *IRODSFSClientRetry) DownloadFileWithCallback(irodsPath string, blockSize int, numBlocks int, blockReadyCallback irodsclient_common.DataObjectBlockCallback, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.DownloadFileWithCallbackCtx(context.Background(), irodsPath, blockSize, numBlocks, blockReadyCallback, transferCallback)
+}
+
+// DownloadFileWithCallbackCtx passes blocks again from the start of the file when retried
+func (c *IRODSFSClientRetry) DownloadFileWithCallbackCtx(ctx context.Context, irodsPath string, blockSize int, numBlocks int, blockReadyCallback irodsclient_common.DataObjectBlockCallback, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.retry(ctx, "DownloadFileWithCallback", func() error {
+		return c.client.DownloadFileWithCallbackCtx(ctx, irodsPath, blockSize, numBlocks, blockReadyCallback, transferCallback)
+	}, nil)
+}
+
+// DownloadFileParallelWithCallback passes blocks again from the start of the file when retried
+func (c *IRODSFSClientRetry) DownloadFileParallelWithCallback(irodsPath string, blockSize int, numBlocks int, blockReadyCallback irodsclient_common.DataObjectBlockCallback, taskNum int, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.DownloadFileParallelWithCallbackCtx(context.Background(), irodsPath, blockSize, numBlocks, blockReadyCallback, taskNum, transferCallback)
+}
+
+// DownloadFileParallelWithCallbackCtx passes blocks again from the start of the file when retried
+func (c *IRODSFSClientRetry) DownloadFileParallelWithCallbackCtx(ctx context.Context, irodsPath string, blockSize int, numBlocks int, blockReadyCallback irodsclient_common.DataObjectBlockCallback, taskNum int, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.retry(ctx, "DownloadFileParallelWithCallback", func() error {
+		return c.client.DownloadFileParallelWithCallbackCtx(ctx, irodsPath, blockSize, numBlocks, blockReadyCallback, taskNum, transferCallback)
+	}, nil)
+}
+
+func (c *IRODSFSClientRetry) UploadFile(localPath string, irodsPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.UploadFileCtx(context.Background(), localPath, irodsPath, transferCallback)
+}
+
+func (c *IRODSFSClientRetry) UploadFileCtx(ctx context.Context, localPath string, irodsPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.retry(ctx, "UploadFile", func() error {
+		return c.client.UploadFileCtx(ctx, localPath, irodsPath, transferCallback)
+	}, nil)
+}
+
+func (c *IRODSFSClientRetry) UploadFileParallel(localPath string, irodsPath string, taskNum int, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.UploadFileParallelCtx(context.Background(), localPath, irodsPath, taskNum, transferCallback)
+}
+
+func (c *IRODSFSClientRetry) UploadFileParallelCtx(ctx context.Context, localPath string, irodsPath string, taskNum int, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.retry(ctx, "UploadFileParallel", func() error {
+		return c.client.UploadFileParallelCtx(ctx, localPath, irodsPath, taskNum, transferCallback)
+	}, nil)
+}
+
+func (c *IRODSFSClientRetry) DownloadFileWithOptions(irodsPath string, localPath string, options *TransferOptions) error {
+	return c.DownloadFileWithOptionsCtx(context.Background(), irodsPath, localPath, options)
+}
+
+func (c *IRODSFSClientRetry) DownloadFileWithOptionsCtx(ctx context.Context, irodsPath string, localPath string, options *TransferOptions) error {
+	return c.retry(ctx, "DownloadFileWithOptions", func() error {
+		return c.client.DownloadFileWithOptionsCtx(ctx, irodsPath, localPath, options)
+	}, nil)
+}
+
+func (c *IRODSFSClientRetry) UploadFileWithOptions(localPath string, irodsPath string, options *TransferOptions) error {
+	return c.UploadFileWithOptionsCtx(context.Background(), localPath, irodsPath, options)
+}
+
+func (c *IRODSFSClientRetry) UploadFileWithOptionsCtx(ctx context.Context, localPath string, irodsPath string, options *TransferOptions) error {
+	return c.retry(ctx, "UploadFileWithOptions", func() error {
+		return c.client.UploadFileWithOptionsCtx(ctx, localPath, irodsPath, options)
+	}, nil)
+}
+
+func (c *IRODSFSClientRetry) GetTransferManager() *TransferManager {
+	return c.client.GetTransferManager()
+}
+
+func (c *IRODSFSClientRetry) UploadDir(localDir string, irodsDir string, options *DirTransferOptions) (*DirTransferReport, error) {
+	return c.UploadDirCtx(context.Background(), localDir, irodsDir, options)
+}
+
+func (c *IRODSFSClientRetry) UploadDirCtx(ctx context.Context, localDir string, irodsDir string, options *DirTransferOptions) (*DirTransferReport, error) {
+	var report *DirTransferReport
+	err := c.retry(ctx, "UploadDir", func() error {
+		var err error
+		report, err = c.client.UploadDirCtx(ctx, localDir, irodsDir, options)
+		return err
+	}, nil)
+	return report, err
+}
+
+func (c *IRODSFSClientRetry) DownloadDir(irodsDir string, localDir string, options *DirTransferOptions) (*DirTransferReport, error) {
+	return c.DownloadDirCtx(context.Background(), irodsDir, localDir, options)
+}
+
+func (c *IRODSFSClientRetry) DownloadDirCtx(ctx context.Context, irodsDir string, localDir string, options *DirTransferOptions) (*DirTransferReport, error) {
+	var report *DirTransferReport
+	err := c.retry(ctx, "DownloadDir", func() error {
+		var err error
+		report, err = c.client.DownloadDirCtx(ctx, irodsDir, localDir, options)
+		return err
+	}, nil)
+	return report, err
+}
+
+func (c *IRODSFSClientRetry) CacheFile(irodsPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.CacheFileCtx(context.Background(), irodsPath, transferCallback)
+}
+
+func (c *IRODSFSClientRetry) CacheFileCtx(ctx context.Context, irodsPath string, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	return c.retry(ctx, "CacheFile", func() error {
+		return c.client.CacheFileCtx(ctx, irodsPath, transferCallback)
+	}, nil)
+}
+
+// IRODSFSClientRetryFileHandle is a file handle of an IRODSFSClientRetry. If it is opened read-only,
+// reads that fail with transient errors are retried on a new handle opened the same way.
+type IRODSFSClientRetryFileHandle struct {
+	client *IRODSFSClientRetry
+	id     string
+	path   string
+	reopen func(ctx context.Context) (IRODSFSFileHandle, error) // Opens the handle again, nil if it must not be
+
+	mu     sync.Mutex
+	handle IRODSFSFileHandle
+}
+
+// newRetryHandle wraps a handle of the client
+func (c *IRODSFSClientRetry) newRetryHandle(handle IRODSFSFileHandle, path string) *IRODSFSClientRetryFileHandle {
+	retryHandle := &IRODSFSClientRetryFileHandle{
+		client: c,
+		id:     handle.GetID(),
+		path:   path,
+		handle: handle,
+	}
+
+	// Handles that write may have lost data with the connection and are not re-opened
+	if handle.IsReadMode() && !handle.IsWriteMode() {
+		mode := string(handle.GetOpenMode())
+		replica := handle.GetReplica()
+		retryHandle.reopen = func(ctx context.Context) (IRODSFSFileHandle, error) {
+			options := &OpenOptions{}
+			if replica != nil {
+				options.ReadReplica = &replica.Number
+			}
+			return c.client.OpenFileWithOptionsCtx(ctx, path, mode, options)
+		}
+	}
+	return retryHandle
+}
+
+func (h *IRODSFSClientRetryFileHandle) current() IRODSFSFileHandle {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.handle
+}
+
+// reopenAfter replaces failed by a new handle, unless another read has already done so
+func (h *IRODSFSClientRetryFileHandle) reopenAfter(ctx context.Context, failed IRODSFSFileHandle) (IRODSFSFileHandle, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.handle != failed {
+		return h.handle, nil
+	}
+
+	handle, err := h.reopen(ctx)
+	if err != nil {
+		return nil, err
+	}
+	failed.Close()
+	h.handle = handle
+	h.client.record(func(metrics *RetryMetrics) { metrics.Reopened++ })
+	return handle, nil
+}
+
+// GetID returns the ID of the first handle, which stays the same when the handle is re-opened
+func (h *IRODSFSClientRetryFileHandle) GetID() string {
+	return h.id
+}
+
+func (h *IRODSFSClientRetryFileHandle) GetEntry() *irodsclient_fs.Entry {
+	return h.current().GetEntry()
+}
+
+func (h *IRODSFSClientRetryFileHandle) GetReplica() *irodsclient_types.IRODSReplica {
+	return h.current().GetReplica()
+}
+
+func (h *IRODSFSClientRetryFileHandle) GetOpenMode() irodsclient_types.FileOpenMode {
+	return h.current().GetOpenMode()
+}
+
+func (h *IRODSFSClientRetryFileHandle) IsReadMode() bool {
+	return h.current().IsReadMode()
+}
+
+func (h *IRODSFSClientRetryFileHandle) IsWriteMode() bool {
+	return h.current().IsWriteMode()
+}
+
+func (h *IRODSFSClientRetryFileHandle) ReadAt(buffer []byte, offset int64) (int, error) {
+	return h.ReadAtCtx(context.Background(), buffer, offset)
+}
+
+// ReadAtCtx reads into buffer at offset. After a transient error, the rest of the buffer is read from a
+// re-opened handle, at the offset the failed read stopped at.
+func (h *IRODSFSClientRetryFileHandle) ReadAtCtx(ctx context.Context, buffer []byte, offset int64) (int, error) {
+	handle := h.current()
+	read, err := WithContextHandle(handle).ReadAtCtx(ctx, buffer, offset)
+	if h.reopen == nil || h.client.policy("ReadAt") == RetryNever {
+		return read, err
+	}
+
+	for attempt := 2; isTransient(err); attempt++ {
+		if attempt > h.client.config.MaxAttempts {
+			h.client.record(func(metrics *RetryMetrics) { metrics.Exhausted++ })
+			return read, err
+		}
+
+		if waitErr := h.client.waitBackoff(ctx, "ReadAt", attempt-1); waitErr != nil {
+			return read, waitErr
+		}
+
+		// The failed handle is kept until a new one is open, so that a failed re-open is retried
+		reopened, reopenErr := h.reopenAfter(ctx, handle)
+		if reopenErr != nil {
+			err = reopenErr
+			continue
+		}
+		handle = reopened
+
+		var n int
+		n, err = WithContextHandle(handle).ReadAtCtx(ctx, buffer[read:], offset+int64(read))
+		read += n
+		if err == nil || err == io.EOF {
+			h.client.record(func(metrics *RetryMetrics) { metrics.Recovered++ })
+		}
+	}
+	return read, err
+}
+
+func (h *IRODSFSClientRetryFileHandle) GetAvailable(offset int64) int64 {
+	return h.current().GetAvailable(offset)
+}
+
+func (h *IRODSFSClientRetryFileHandle) WriteAt(data []byte, offset int64) (int, error) {
+	return h.WriteAtCtx(context.Background(), data, offset)
+}
+
+func (h *IRODSFSClientRetryFileHandle) WriteAtCtx(ctx context.Context, data []byte, offset int64) (int, error) {
+	return WithContextHandle(h.current()).WriteAtCtx(ctx, data, offset)
+}
+
+func (h *IRODSFSClientRetryFileHandle) Truncate(size int64) error {
+	return h.TruncateCtx(context.Background(), size)
+}
+
+func (h *IRODSFSClientRetryFileHandle) TruncateCtx(ctx context.Context, size int64) error {
+	return WithContextHandle(h.current()).TruncateCtx(ctx, size)
+}
+
+func (h *IRODSFSClientRetryFileHandle) Flush() error {
+	return h.FlushCtx(context.Background())
+}
+
+func (h *IRODSFSClientRetryFileHandle) FlushCtx(ctx context.Context) error {
+	return WithContextHandle(h.current()).FlushCtx(ctx)
+}
+
+func (h *IRODSFSClientRetryFileHandle) Close() error {
+	return h.current().Close()
+}
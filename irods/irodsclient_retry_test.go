@@ -0,0 +1,243 @@
+package irods_test
+
+import (
+	"context"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cyverse/irodsfs-common/irods"
+	"github.com/cyverse/irodsfs-common/irods/fake"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRetryClient(t *testing.T, client irods.IRODSFSClient, config *irods.RetryConfig) *irods.IRODSFSClientRetry {
+	if config == nil {
+		config = &irods.RetryConfig{}
+	}
+	config.InitialBackoff = time.Millisecond
+	config.MaxBackoff = 2 * time.Millisecond
+
+	retry, err := irods.NewIRODSFSClientRetry(client, config)
+	require.NoError(t, err)
+	return retry
+}
+
+// lostReplyClient is a fake client whose calls take effect, but the first call of each method fails
+// with a transient error as if the reply was lost
+type lostReplyClient struct {
+	*fake.Client
+
+	lost map[string]bool
+}
+
+func (c *lostReplyClient) loseReply(method string, err error) error {
+	if err != nil || c.lost[method] {
+		return err
+	}
+	c.lost[method] = true
+	return errors.Wrap(syscall.ECONNRESET, "reply lost")
+}
+
+func (c *lostReplyClient) MakeDir(p string, recurse bool) error {
+	return c.loseReply("MakeDir", c.Client.MakeDir(p, recurse))
+}
+
+func (c *lostReplyClient) RemoveFile(p string, force bool) error {
+	return c.loseReply("RemoveFile", c.Client.RemoveFile(p, force))
+}
+
+func (c *lostReplyClient) RenameFileToFile(srcPath string, destPath string) error {
+	return c.loseReply("RenameFileToFile", c.Client.RenameFileToFile(srcPath, destPath))
+}
+
+func TestRetryClientIdempotent(t *testing.T) {
+	c, home := newFakeClient(t, nil, nil)
+	faulty := newTestFaultyClient(t, c, &irods.FaultInjectionConfig{
+		Seed:   1,
+		Faults: []irods.Fault{{Op: "Stat", Err: syscall.ECONNRESET, Probability: 0.5}},
+	})
+	client := newTestRetryClient(t, faulty, &irods.RetryConfig{MaxAttempts: 20})
+
+	for i := 0; i < 20; i++ {
+		_, err := client.Stat(home)
+		require.NoError(t, err)
+	}
+
+	metrics := client.GetRetryMetrics()
+	assert.Greater(t, metrics.Retries, int64(0))
+	assert.Equal(t, faulty.GetInjectedFaults()["Stat"], metrics.Retries)
+	assert.Equal(t, map[string]int64{"Stat": metrics.Retries}, metrics.ByMethod)
+	assert.Greater(t, metrics.Recovered, int64(0))
+	assert.Zero(t, metrics.Exhausted)
+}
+
+func TestRetryClientGivesUp(t *testing.T) {
+	c, home := newFakeClient(t, nil, nil)
+	faulty := newTestFaultyClient(t, c, &irods.FaultInjectionConfig{
+		Faults: []irods.Fault{
+			irods.FailureFault("MakeDir", errors.New("iRODS error -818000 (CAT_NO_ACCESS_PERMISSION)")),
+			irods.FailureFault("", nil),
+		},
+	})
+	client := newTestRetryClient(t, faulty, &irods.RetryConfig{
+		MaxAttempts: 3,
+		Policies:    map[string]irods.RetryPolicy{"List": irods.RetryNever},
+	})
+
+	_, err := client.Stat(home)
+	assert.ErrorIs(t, err, irods.ErrTransient)
+	_, err = client.List(home)
+	assert.ErrorIs(t, err, irods.ErrTransient)
+	err = client.MakeDir(home+"/dir", false)
+	assert.ErrorIs(t, err, irods.ErrPermissionDenied)
+
+	assert.Equal(t, map[string]int64{"Stat": 3, "List": 1, "MakeDir": 1}, faulty.GetInjectedFaults())
+	metrics := client.GetRetryMetrics()
+	assert.Equal(t, int64(2), metrics.Retries)
+	assert.Equal(t, int64(1), metrics.Exhausted)
+	assert.Zero(t, metrics.Recovered)
+}
+
+func TestRetryClientChecked(t *testing.T) {
+	c, home := newFakeClient(t, nil, map[string]string{"a": "a", "b": "b"})
+	client := newTestRetryClient(t, &lostReplyClient{Client: c, lost: map[string]bool{}}, nil)
+
+	// Repeating these calls would fail, since the lost attempts took effect
+	require.NoError(t, client.MakeDir(home+"/dir", false))
+	require.NoError(t, client.RenameFileToFile(home+"/a", home+"/dir/a"))
+	require.NoError(t, client.RemoveFile(home+"/b", false))
+
+	dirs, files := fakeTree(t, c)
+	assert.True(t, dirs["dir"])
+	assert.Equal(t, map[string]string{"dir/a": "a"}, files)
+
+	metrics := client.GetRetryMetrics()
+	assert.Zero(t, metrics.Retries)
+	assert.Equal(t, int64(3), metrics.Recovered)
+
+	// Calls that did not take effect are repeated
+	faulty := newTestFaultyClient(t, c, &irods.FaultInjectionConfig{
+		Seed:   1,
+		Faults: []irods.Fault{{Op: "RenameFileToFile", Err: syscall.ECONNRESET, Probability: 0.5}},
+	})
+	client = newTestRetryClient(t, faulty, &irods.RetryConfig{MaxAttempts: 20})
+	for i := 0; i < 10; i++ {
+		src, dest := home+"/dir/a", home+"/a"
+		if i%2 == 1 {
+			src, dest = dest, src
+		}
+		require.NoError(t, client.RenameFileToFile(src, dest))
+	}
+	assert.Equal(t, faulty.GetInjectedFaults()["RenameFileToFile"], client.GetRetryMetrics().Retries)
+	_, files = fakeTree(t, c)
+	assert.Equal(t, map[string]string{"dir/a": "a"}, files)
+}
+
+func TestRetryFileHandleReopen(t *testing.T) {
+	content := strings.Repeat("0123456789", 100)
+	c, home := newFakeClient(t, nil, map[string]string{"file": content})
+	faulty := newTestFaultyClient(t, c, &irods.FaultInjectionConfig{
+		Seed:   1,
+		Faults: []irods.Fault{{Op: "ReadAt", Effect: irods.FaultDrop, Probability: 0.5}},
+	})
+	client := newTestRetryClient(t, faulty, &irods.RetryConfig{MaxAttempts: 20})
+
+	handle, err := client.OpenFile(home+"/file", "r")
+	require.NoError(t, err)
+	id := handle.GetID()
+
+	for i := 0; i < 5; i++ {
+		buffer := make([]byte, 200)
+		n, err := handle.ReadAt(buffer, int64(i*200))
+		require.NoError(t, err)
+		assert.Equal(t, content[i*200:(i+1)*200], string(buffer[:n]))
+	}
+
+	metrics := client.GetRetryMetrics()
+	assert.Greater(t, metrics.Reopened, int64(0))
+	assert.Equal(t, metrics.Retries, metrics.Reopened)
+	assert.Equal(t, id, handle.GetID())
+	assert.Equal(t, 1+metrics.Reopened, c.GetCallMetrics().Calls["OpenFile"])
+	// Dropped handles are closed when they are replaced
+	assert.Equal(t, 1, c.GetOpenConnections())
+	require.NoError(t, handle.Close())
+	assert.Zero(t, c.GetOpenConnections())
+
+	// Handles that write are not re-opened
+	faulty.ClearFaults()
+	faulty.InjectFault(irods.Fault{Op: "ReadAt", Effect: irods.FaultDrop})
+	handle, err = client.OpenFile(home+"/file", "r+")
+	require.NoError(t, err)
+	_, err = handle.ReadAt(make([]byte, 10), 0)
+	assert.ErrorIs(t, err, irods.ErrTransient)
+	assert.Equal(t, metrics.Reopened, client.GetRetryMetrics().Reopened)
+}
+
+func TestRetryFileHandleReopenFails(t *testing.T) {
+	content := strings.Repeat("0123456789", 10)
+	c, home := newFakeClient(t, nil, map[string]string{"file": content})
+	faulty := newTestFaultyClient(t, c, &irods.FaultInjectionConfig{Seed: 1})
+	client := newTestRetryClient(t, faulty, &irods.RetryConfig{MaxAttempts: 3})
+
+	handle, err := client.OpenFile(home+"/file", "r")
+	require.NoError(t, err)
+	defer handle.Close()
+
+	// The first re-open fails, the second replaces the dropped handle
+	faulty.InjectFault(irods.Fault{Op: "ReadAt", Effect: irods.FaultDrop, Count: 1})
+	faulty.InjectFault(irods.Fault{Op: "OpenFile", Err: syscall.ECONNRESET, Count: 1})
+
+	buffer := make([]byte, len(content))
+	n, err := handle.ReadAt(buffer, 0)
+	require.NoError(t, err)
+	assert.Equal(t, content, string(buffer[:n]))
+
+	metrics := client.GetRetryMetrics()
+	assert.Equal(t, int64(2), metrics.Retries)
+	assert.Equal(t, int64(1), metrics.Reopened)
+	assert.Equal(t, uint64(metrics.Retries), client.GetMetrics().GetCounterForRequestResponseFailures())
+}
+
+func TestRetryClientMetrics(t *testing.T) {
+	c, home := newFakeClient(t, nil, map[string]string{"file": "data"})
+	faulty := newTestFaultyClient(t, c, &irods.FaultInjectionConfig{Seed: 1})
+	client := newTestRetryClient(t, faulty, &irods.RetryConfig{MaxAttempts: 3})
+
+	faulty.InjectFault(irods.Fault{Op: "Stat", Err: syscall.ECONNRESET, Count: 1})
+	_, err := client.Stat(home + "/file")
+	require.NoError(t, err)
+
+	// The wrapped client keeps its metrics, retries are counted once
+	assert.Same(t, c.GetMetrics(), client.GetMetrics())
+	assert.Equal(t, uint64(1), client.GetMetrics().GetCounterForRequestResponseFailures())
+	assert.Equal(t, uint64(1), client.GetMetrics().GetCounterForRequestResponseFailures())
+
+	faulty.InjectFault(irods.Fault{Op: "Stat", Err: syscall.ECONNRESET, Count: 1})
+	_, err = client.Stat(home + "/file")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), client.GetMetrics().GetCounterForRequestResponseFailures())
+	assert.Equal(t, uint64(2), c.GetMetrics().GetCounterForRequestResponseFailures())
+}
+
+func TestRetryClientContext(t *testing.T) {
+	c, home := newFakeClient(t, nil, nil)
+	faulty := newTestFaultyClient(t, c, &irods.FaultInjectionConfig{
+		Faults: []irods.Fault{irods.FailureFault("Stat", nil)},
+	})
+	client, err := irods.NewIRODSFSClientRetry(faulty, &irods.RetryConfig{InitialBackoff: time.Hour})
+	require.NoError(t, err)
+
+	// The backoff is cut short when ctx is done
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = client.StatCtx(ctx, home)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Minute)
+	assert.Zero(t, client.GetRetryMetrics().Retries)
+	assert.Equal(t, map[string]int64{"Stat": 1}, faulty.GetInjectedFaults())
+}
@@ -0,0 +1,133 @@
+package irods
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	irodsclient_types "github.com/cyverse/go-irodsclient/irods/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// OpenOptions selects the storage resources and replicas files are read from and written to.
+// Clients take defaults in their config; fields set in per-call options override them.
+// iRODS opens data objects on root resources, so a replica that shares its root resource with other
+// replicas cannot be selected reliably; reads then come from any replica below that root.
+type OpenOptions struct {
+	Resource     string // Resource that writes and new data objects go to ("" = server default)
+	ReadResource string // Preferred resource to read from; reads use any replica if it has none ("" = server choice)
+	ReadReplica  *int64 // Number of the replica to read from, overriding ReadResource (nil = any replica)
+}
+
+// ReplicaNumber returns a pointer to n for OpenOptions.ReadReplica
+func ReplicaNumber(n int64) *int64 {
+	return &n
+}
+
+// merge returns options with unset fields taken from defaults
+func (options *OpenOptions) merge(defaults *OpenOptions) *OpenOptions {
+	merged := OpenOptions{}
+	if defaults != nil {
+		merged = *defaults
+	}
+	if options == nil {
+		return &merged
+	}
+
+	if options.Resource != "" {
+		merged.Resource = options.Resource
+	}
+	if options.ReadResource != "" {
+		merged.ReadResource = options.ReadResource
+	}
+	if options.ReadReplica != nil {
+		merged.ReadReplica = options.ReadReplica
+	}
+	return &merged
+}
+
+// pinsReads returns true if reads are served from a chosen replica
+func (options *OpenOptions) pinsReads() bool {
+	return options != nil && (options.ReadResource != "" || options.ReadReplica != nil)
+}
+
+// replicaRootResource returns the root resource of the hierarchy holding replica. Opening a data object
+// on it selects the replica only if no other replica is in the same hierarchy, see isOnlyReplicaOfRoot.
+func replicaRootResource(replica *irodsclient_types.IRODSReplica) string {
+	if replica.ResourceHierarchy != "" {
+		return strings.SplitN(replica.ResourceHierarchy, ";", 2)[0]
+	}
+	return replica.ResourceName
+}
+
+// isOnlyReplicaOfRoot returns true if no other replica is in the hierarchy of replica's root resource.
+// Data objects can only be opened on a root resource, which picks among the replicas below it, so
+// reads are only known to come from replica if it is the only one.
+func isOnlyReplicaOfRoot(replica *irodsclient_types.IRODSReplica, replicas []*irodsclient_types.IRODSReplica) bool {
+	root := replicaRootResource(replica)
+	for _, other := range replicas {
+		if other.Number != replica.Number && replicaRootResource(other) == root {
+			return false
+		}
+	}
+	return true
+}
+
+// selectReadReplica returns the replica of path that options pin reads to, or nil to let the server
+// choose. A missing ReadReplica is an error; a ReadResource without a replica is not.
+func selectReadReplica(path string, replicas []*irodsclient_types.IRODSReplica, options *OpenOptions) (*irodsclient_types.IRODSReplica, error) {
+	if options.ReadReplica != nil {
+		for _, replica := range replicas {
+			if replica.Number == *options.ReadReplica {
+				return replica, nil
+			}
+		}
+		return nil, newError("open", path, ErrNotFound, errors.Newf("no replica %d", *options.ReadReplica))
+	}
+
+	if options.ReadResource != "" {
+		for _, replica := range replicas {
+			if replica.ResourceName == options.ReadResource || replicaRootResource(replica) == options.ReadResource {
+				return replica, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// makeReplicaCacheKey creates a cache key for a block read from a pinned replica
+func makeReplicaCacheKey(irodsPath string, replicaNumber int64, blockNum int64) string {
+	return "irods:replica:" + strconv.FormatInt(replicaNumber, 10) + ":block:" + irodsPath + ":" + strconv.FormatInt(blockNum, 10)
+}
+
+// readReplica returns the resource to read path from and the replica on it that options pin reads to,
+// listing the replicas with client. Both are empty if reads are not pinned. The replica is nil if other
+// replicas share its root resource, since the server then picks among them and reads cannot be
+// attributed to the replica.
+func readReplica(ctx context.Context, client IRODSFSClientCtx, path string, options *OpenOptions, logger *log.Entry) (string, *irodsclient_types.IRODSReplica, error) {
+	if !options.pinsReads() {
+		return "", nil, nil
+	}
+
+	replicas, err := client.ListReplicasCtx(ctx, path)
+	if err != nil {
+		return "", nil, err
+	}
+
+	replica, err := selectReadReplica(path, replicas, options)
+	if err != nil || replica == nil {
+		return "", nil, err
+	}
+
+	resource := replicaRootResource(replica)
+	if !isOnlyReplicaOfRoot(replica, replicas) {
+		logger.WithFields(log.Fields{
+			"path":     path,
+			"replica":  replica.Number,
+			"resource": resource,
+		}).Warnf("replica %d shares root resource %s with other replicas, reads may come from any of them", replica.Number, resource)
+		return resource, nil, nil
+	}
+	return resource, replica, nil
+}
@@ -0,0 +1,171 @@
+package irods
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	irodsclient_fs "github.com/cyverse/go-irodsclient/fs"
+	irodsclient_types "github.com/cyverse/go-irodsclient/irods/types"
+	"github.com/cyverse/irodsfs-common/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenOptionsMerge(t *testing.T) {
+	defaults := &OpenOptions{Resource: "fastResc", ReadResource: "hpcResc"}
+
+	merged := (*OpenOptions)(nil).merge(defaults)
+	assert.Equal(t, *defaults, *merged)
+	assert.True(t, merged.pinsReads())
+
+	merged = (&OpenOptions{Resource: "archiveResc", ReadReplica: ReplicaNumber(0)}).merge(defaults)
+	assert.Equal(t, "archiveResc", merged.Resource)
+	assert.Equal(t, "hpcResc", merged.ReadResource)
+	require.NotNil(t, merged.ReadReplica)
+	assert.Equal(t, int64(0), *merged.ReadReplica)
+
+	merged = (*OpenOptions)(nil).merge(nil)
+	assert.Equal(t, OpenOptions{}, *merged)
+	assert.False(t, merged.pinsReads())
+}
+
+func TestSelectReadReplica(t *testing.T) {
+	replicas := []*irodsclient_types.IRODSReplica{
+		{Number: 0, ResourceName: "archive1", ResourceHierarchy: "archiveResc;archive1"},
+		{Number: 1, ResourceName: "hpcResc"},
+	}
+
+	replica, err := selectReadReplica("/zone/file", replicas, &OpenOptions{ReadReplica: ReplicaNumber(1), ReadResource: "archiveResc"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), replica.Number)
+	assert.Equal(t, "hpcResc", replicaRootResource(replica))
+
+	_, err = selectReadReplica("/zone/file", replicas, &OpenOptions{ReadReplica: ReplicaNumber(2)})
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// Resources match by leaf or by root of the hierarchy
+	for _, resource := range []string{"archiveResc", "archive1"} {
+		replica, err = selectReadReplica("/zone/file", replicas, &OpenOptions{ReadResource: resource})
+		require.NoError(t, err)
+		assert.Equal(t, int64(0), replica.Number)
+		assert.Equal(t, "archiveResc", replicaRootResource(replica))
+	}
+
+	// Without a replica on the preferred resource, the server chooses
+	replica, err = selectReadReplica("/zone/file", replicas, &OpenOptions{ReadResource: "otherResc"})
+	require.NoError(t, err)
+	assert.Nil(t, replica)
+}
+
+func TestIsOnlyReplicaOfRoot(t *testing.T) {
+	replicas := []*irodsclient_types.IRODSReplica{
+		{Number: 0, ResourceName: "archive1", ResourceHierarchy: "replResc;archive1"},
+		{Number: 1, ResourceName: "archive2", ResourceHierarchy: "replResc;archive2"},
+		{Number: 2, ResourceName: "hpcResc"},
+	}
+
+	// Opening on replResc may read either of its replicas
+	assert.False(t, isOnlyReplicaOfRoot(replicas[0], replicas))
+	assert.False(t, isOnlyReplicaOfRoot(replicas[1], replicas))
+	assert.True(t, isOnlyReplicaOfRoot(replicas[2], replicas))
+}
+
+func TestBufferedFileHandlePinnedReplicaCache(t *testing.T) {
+	cacheMgr := newTestCacheManager(t)
+	defer cacheMgr.Release()
+
+	client := &IRODSFSClientBuffered{
+		cache:  cacheMgr,
+		helper: util.NewFileBlockHelper(8),
+		logger: newTestLogger(),
+	}
+
+	pinnedMock := newMockFileHandle("/test/file.dat", []byte("replica1"), irodsclient_types.FileOpenModeReadOnly)
+	pinnedMock.replica = &irodsclient_types.IRODSReplica{Number: 1, ResourceName: "hpcResc"}
+	pinned := client.newBufferedHandle(pinnedMock, "/test/file.dat", newTestLogger())
+	assert.Equal(t, int64(1), pinned.GetReplica().Number)
+
+	anyMock := newMockFileHandle("/test/file.dat", []byte("anyrepl0"), irodsclient_types.FileOpenModeReadOnly)
+	unpinned := client.newBufferedHandle(anyMock, "/test/file.dat", newTestLogger())
+	assert.Nil(t, unpinned.GetReplica())
+
+	// Blocks of each replica are cached under their own key
+	pinnedKey := makeReplicaCacheKey("/test/file.dat", 1, 0)
+	unpinnedKey := client.makeCacheKey("/test/file.dat", 0)
+	assert.Equal(t, pinnedKey, pinned.makeCacheKey(0))
+	assert.Equal(t, unpinnedKey, unpinned.makeCacheKey(0))
+
+	_, err := cacheMgr.PutCopy(pinnedKey, []byte("cached-1"), true)
+	require.NoError(t, err)
+	_, err = cacheMgr.PutCopy(unpinnedKey, []byte("cached-0"), true)
+	require.NoError(t, err)
+
+	buf := make([]byte, 8)
+	_, err = pinned.ReadAt(buf, 0)
+	require.True(t, err == nil || err == io.EOF)
+	assert.Equal(t, []byte("cached-1"), buf)
+
+	_, err = unpinned.ReadAt(buf, 0)
+	require.True(t, err == nil || err == io.EOF)
+	assert.Equal(t, []byte("cached-0"), buf)
+
+	// Changes invalidate the blocks of every replica
+	client.deleteCachedBlock(cacheMgr, "/test/file.dat", 0)
+	assert.False(t, cacheMgr.Has(pinnedKey))
+	assert.False(t, cacheMgr.Has(unpinnedKey))
+}
+
+// sizeClient implements Stat of the IRODSFSClient methods, reporting every path as a file of size bytes
+type sizeClient struct {
+	IRODSFSClient
+
+	size int64
+}
+
+func (c *sizeClient) Stat(path string) (*irodsclient_fs.Entry, error) {
+	return &irodsclient_fs.Entry{Type: irodsclient_fs.FileEntry, Path: path, Size: c.size}, nil
+}
+
+func TestBufferedClientDropsInvalidatedPinnedReplicas(t *testing.T) {
+	client := newTestBufferedClient(t, &IRODSFSClientBufferedConfig{}, &mockStagingClient{})
+	client.client = WithContext(&sizeClient{size: 8})
+
+	first := newMockFileHandle("/test/file.dat", []byte("replica1"), irodsclient_types.FileOpenModeReadOnly)
+	first.replica = &irodsclient_types.IRODSReplica{Number: 1, ResourceName: "hpcResc"}
+	firstHandle := client.newBufferedHandle(first, "/test/file.dat", newTestLogger())
+
+	second := newMockFileHandle("/test/file.dat", []byte("replica2"), irodsclient_types.FileOpenModeReadOnly)
+	second.replica = &irodsclient_types.IRODSReplica{Number: 2, ResourceName: "archiveResc"}
+	secondHandle := client.newBufferedHandle(second, "/test/file.dat", newTestLogger())
+
+	require.NoError(t, firstHandle.Close())
+	assert.ElementsMatch(t, []int64{1, 2}, client.getPinnedReplicas("/test/file.dat"))
+
+	// Blocks of a closed handle are invalidated, blocks of an open handle may still be cached
+	require.NoError(t, client.invalidateFileCacheBlocks("/test/file.dat"))
+	assert.Equal(t, []int64{2}, client.getPinnedReplicas("/test/file.dat"))
+
+	require.NoError(t, secondHandle.Close())
+	require.NoError(t, client.invalidateFileCacheBlocks("/test/file.dat"))
+	assert.Empty(t, client.getPinnedReplicas("/test/file.dat"))
+	assert.Empty(t, client.pinnedReplicas)
+}
+
+func TestBufferedClientListReplicasStaged(t *testing.T) {
+	client := newTestBufferedClient(t, &IRODSFSClientBufferedConfig{
+		StagingRootPath: t.TempDir(),
+		GracePeriod:     time.Hour,
+	}, &mockStagingClient{})
+
+	staging := client.router.getStaging("/zone/new")
+	require.NoError(t, staging.Create("/zone/new"))
+
+	replicas, err := client.ListReplicas("/zone/new")
+	require.NoError(t, err)
+	assert.Empty(t, replicas)
+
+	require.NoError(t, staging.Delete("/zone/deleted"))
+	_, err = client.ListReplicas("/zone/deleted")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
@@ -0,0 +1,212 @@
+package stagingfs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/rs/xid"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultAtomicTempPattern names temporary data objects; {name} is the destination base name, {id} a unique id
+	DefaultAtomicTempPattern         = ".{name}.staging-{id}.tmp"
+	DefaultAbandonedTempGracePeriod  = 10 * time.Minute
+	atomicTempPatternNamePlaceholder = "{name}"
+	atomicTempPatternIDPlaceholder   = "{id}"
+)
+
+// VerifyingStagingClient is implemented by clients that can check an uploaded data object against the local file.
+// With AtomicReplace, temporary uploads are verified before they replace the destination.
+type VerifyingStagingClient interface {
+	VerifyUpload(localPath string, irodsPath string) error
+}
+
+// TempUpload records a temporary data object used for an atomic replace
+type TempUpload struct {
+	Path      string    // Destination iRODS path
+	TempPath  string    // Temporary sibling data object
+	CreatedAt time.Time // When the temporary object was first used
+}
+
+// GetTempUpload returns a copy of the temporary upload for a destination path, or nil
+func (sm *StagingStateManager) GetTempUpload(path string) *TempUpload {
+	sm.auxMu.Lock()
+	defer sm.auxMu.Unlock()
+
+	temp, ok := sm.temps[path]
+	if !ok {
+		return nil
+	}
+
+	copied := *temp
+	return &copied
+}
+
+// GetTempUploads returns copies of all tracked temporary uploads
+func (sm *StagingStateManager) GetTempUploads() []*TempUpload {
+	sm.auxMu.Lock()
+	defer sm.auxMu.Unlock()
+
+	result := make([]*TempUpload, 0, len(sm.temps))
+	for _, temp := range sm.temps {
+		copied := *temp
+		result = append(result, &copied)
+	}
+	return result
+}
+
+// SaveTempUpload tracks a temporary upload in memory and the store
+func (sm *StagingStateManager) SaveTempUpload(temp *TempUpload) error {
+	sm.auxMu.Lock()
+	defer sm.auxMu.Unlock()
+
+	sm.temps[temp.Path] = temp
+
+	if sm.store == nil {
+		return nil
+	}
+
+	key := []byte(fmt.Sprintf("temp:%s", temp.Path))
+	data, err := json.Marshal(temp)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal temporary upload")
+	}
+
+	return sm.updateStore(func(txn MetadataTxn) error {
+		return txn.Put(key, data)
+	})
+}
+
+// DeleteTempUpload stops tracking the temporary upload for a destination path
+func (sm *StagingStateManager) DeleteTempUpload(path string) error {
+	sm.auxMu.Lock()
+	defer sm.auxMu.Unlock()
+
+	if _, ok := sm.temps[path]; !ok {
+		return nil
+	}
+	delete(sm.temps, path)
+
+	if sm.store == nil {
+		return nil
+	}
+
+	key := []byte(fmt.Sprintf("temp:%s", path))
+	return sm.updateStore(func(txn MetadataTxn) error {
+		return txn.Delete(key)
+	})
+}
+
+// restoreTempUploads loads tracked temporary uploads from the store (caller must hold auxMu)
+func (sm *StagingStateManager) restoreTempUploads(txn MetadataTxn) error {
+	return txn.Iterate([]byte("temp:"), func(_ []byte, val []byte) error {
+		var temp TempUpload
+		if err := json.Unmarshal(val, &temp); err != nil {
+			return errors.Wrap(err, "failed to unmarshal temporary upload")
+		}
+
+		sm.temps[temp.Path] = &temp
+		return nil
+	})
+}
+
+// makeTempPath returns a temporary sibling path for irodsPath
+func (sf *StagingFS) makeTempPath(irodsPath string) string {
+	pattern := sf.config.AtomicTempPattern
+	if pattern == "" {
+		pattern = DefaultAtomicTempPattern
+	}
+
+	name := strings.ReplaceAll(pattern, atomicTempPatternNamePlaceholder, path.Base(irodsPath))
+	name = strings.ReplaceAll(name, atomicTempPatternIDPlaceholder, xid.New().String())
+	return path.Join(path.Dir(irodsPath), name)
+}
+
+// uploadFileAtomic uploads to a temporary sibling data object, verifies it and renames it over irodsPath,
+// so that readers never observe a partially written destination. The destination is removed first only
+// when the client's rename error matches fs.ErrExist.
+// The temporary object is reused by later attempts, allowing checkpointed uploads to resume into it.
+func (sf *StagingFS) uploadFileAtomic(localPath string, irodsPath string) error {
+	temp := sf.sm.GetTempUpload(irodsPath)
+	if temp == nil {
+		temp = &TempUpload{
+			Path:      irodsPath,
+			TempPath:  sf.makeTempPath(irodsPath),
+			CreatedAt: time.Now(),
+		}
+		if err := sf.sm.SaveTempUpload(temp); err != nil {
+			return errors.Wrapf(err, "failed to track temporary upload for %s", irodsPath)
+		}
+	}
+
+	if err := sf.uploadFileTo(localPath, temp.TempPath); err != nil {
+		return err
+	}
+
+	if verifier, ok := sf.client.(VerifyingStagingClient); ok {
+		if err := verifier.VerifyUpload(localPath, temp.TempPath); err != nil {
+			// The temporary object is corrupt, start over on the next attempt
+			sf.removeTempUpload(temp)
+			return errors.Wrapf(err, "failed to verify temporary upload %s", temp.TempPath)
+		}
+	}
+
+	if err := sf.client.RenameFileToFile(temp.TempPath, irodsPath); err != nil {
+		if !errors.Is(err, fs.ErrExist) {
+			return errors.Wrapf(err, "failed to rename %s to %s", temp.TempPath, irodsPath)
+		}
+
+		// iRODS does not rename over an existing data object, remove the destination and retry
+		if removeErr := sf.client.RemoveFile(irodsPath, true); removeErr != nil {
+			return errors.Wrapf(removeErr, "failed to remove %s to replace it with %s", irodsPath, temp.TempPath)
+		}
+
+		if err := sf.client.RenameFileToFile(temp.TempPath, irodsPath); err != nil {
+			return errors.Wrapf(err, "failed to rename %s to %s", temp.TempPath, irodsPath)
+		}
+	}
+
+	return sf.sm.DeleteTempUpload(irodsPath)
+}
+
+// removeTempUpload removes a temporary data object and stops tracking it
+func (sf *StagingFS) removeTempUpload(temp *TempUpload) {
+	if err := sf.client.RemoveFile(temp.TempPath, true); err != nil {
+		log.Warnf("failed to remove temporary upload %s: %v", temp.TempPath, err)
+	}
+
+	sf.sm.DeleteCheckpoint(temp.TempPath)
+	sf.sm.DeleteTempUpload(temp.Path)
+}
+
+// cleanupAbandonedTemps removes temporary data objects whose staged upload no longer exists
+// (deleted, renamed or given up on) once they are older than AbandonedTempGracePeriod
+func (sf *StagingFS) cleanupAbandonedTemps() {
+	gracePeriod := sf.config.AbandonedTempGracePeriod
+	if gracePeriod < 0 {
+		return
+	}
+	if gracePeriod == 0 {
+		gracePeriod = DefaultAbandonedTempGracePeriod
+	}
+
+	now := time.Now()
+	for _, temp := range sf.sm.GetTempUploads() {
+		if now.Sub(temp.CreatedAt) < gracePeriod {
+			continue
+		}
+
+		if meta := sf.sm.Get(temp.Path); meta != nil && meta.Action == ActionUpload {
+			continue
+		}
+
+		log.Infof("removing abandoned temporary upload %s for %s", temp.TempPath, temp.Path)
+		sf.removeTempUpload(temp)
+	}
+}
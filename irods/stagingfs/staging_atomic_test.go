@@ -0,0 +1,265 @@
+package stagingfs
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	irodsclient_common "github.com/cyverse/go-irodsclient/irods/common"
+)
+
+// MockAtomicClient behaves like iRODS for renames (no rename over an existing object) and can verify uploads
+type MockAtomicClient struct {
+	*MockResumableClient
+
+	failUpload   bool
+	failVerify   bool
+	failRename   error
+	failRemove   error
+	destOnUpload map[string]string // destination content observed while each upload ran
+}
+
+func newMockAtomicClient() *MockAtomicClient {
+	return &MockAtomicClient{
+		MockResumableClient: newMockResumableClient(),
+		destOnUpload:        map[string]string{},
+	}
+}
+
+func (m *MockAtomicClient) UploadFileParallel(localPath string, irodsPath string, taskNum int, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	if m.failUpload {
+		m.mu.Lock()
+		m.objects[irodsPath] = []byte("partial")
+		m.mu.Unlock()
+		return errors.New("connection reset")
+	}
+
+	m.mu.Lock()
+	for p, data := range m.objects {
+		m.destOnUpload[p] = string(data)
+	}
+	m.mu.Unlock()
+
+	return m.MockResumableClient.UploadFileParallel(localPath, irodsPath, taskNum, transferCallback)
+}
+
+func (m *MockAtomicClient) RenameFileToFile(srcPath string, destPath string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.failRename != nil {
+		return m.failRename
+	}
+	if _, ok := m.objects[destPath]; ok {
+		return errors.Wrapf(os.ErrExist, "data object %s", destPath)
+	}
+
+	data, ok := m.objects[srcPath]
+	if !ok {
+		return errors.Newf("data object %s not found", srcPath)
+	}
+	m.objects[destPath] = data
+	delete(m.objects, srcPath)
+	return nil
+}
+
+func (m *MockAtomicClient) RemoveFile(path string, force bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.failRemove != nil {
+		return m.failRemove
+	}
+	delete(m.objects, path)
+	return nil
+}
+
+func (m *MockAtomicClient) VerifyUpload(localPath string, irodsPath string) error {
+	if m.failVerify {
+		return errors.New("size mismatch")
+	}
+	return nil
+}
+
+func (m *MockAtomicClient) getObjectPaths() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	paths := []string{}
+	for p := range m.objects {
+		paths = append(paths, p)
+	}
+	return paths
+}
+
+func newAtomicTestFS(t *testing.T, client *MockAtomicClient, config *StagingFSConfig) *StagingFS {
+	config.LocalRootPath = t.TempDir()
+	config.Client = client
+	config.AtomicReplace = true
+
+	sf, err := NewStagingFS(config)
+	if err != nil {
+		t.Fatalf("Failed to create StagingFS: %v", err)
+	}
+	t.Cleanup(func() { sf.Close(context.Background()) })
+	return sf
+}
+
+func TestStagingFSAtomicReplace(t *testing.T) {
+	client := newMockAtomicClient()
+	client.objects["/dir/f.txt"] = []byte("old")
+
+	sf := newAtomicTestFS(t, client, &StagingFSConfig{})
+	writeStagedFile(t, sf, "/dir/f.txt", 8)
+
+	sf.syncOldItems(0)
+
+	if client.destOnUpload["/dir/f.txt"] != "old" {
+		t.Errorf("Expected destination to stay intact during upload, got %q", client.destOnUpload["/dir/f.txt"])
+	}
+	if string(client.objects["/dir/f.txt"]) != "xxxxxxxx" {
+		t.Errorf("Expected destination to be replaced, got %q", client.objects["/dir/f.txt"])
+	}
+	if paths := client.getObjectPaths(); len(paths) != 1 {
+		t.Errorf("Expected no temporary objects left, got %v", paths)
+	}
+	if len(sf.sm.GetTempUploads()) != 0 {
+		t.Errorf("Expected no tracked temporary uploads")
+	}
+}
+
+func TestStagingFSAtomicReplaceVerifyFailure(t *testing.T) {
+	client := newMockAtomicClient()
+	client.objects["/f.txt"] = []byte("old")
+	client.failVerify = true
+
+	sf := newAtomicTestFS(t, client, &StagingFSConfig{})
+	writeStagedFile(t, sf, "/f.txt", 4)
+
+	sf.syncOldItems(0)
+
+	if string(client.objects["/f.txt"]) != "old" {
+		t.Errorf("Expected destination untouched after failed verification, got %q", client.objects["/f.txt"])
+	}
+	if paths := client.getObjectPaths(); len(paths) != 1 {
+		t.Errorf("Expected unverified temporary object to be removed, got %v", paths)
+	}
+	if sf.Get("/f.txt") == nil {
+		t.Errorf("Expected item to stay staged for retry")
+	}
+}
+
+func TestStagingFSAtomicReplaceRenameFailure(t *testing.T) {
+	client := newMockAtomicClient()
+	client.objects["/f.txt"] = []byte("old")
+	client.failRename = errors.New("connection reset")
+
+	sf := newAtomicTestFS(t, client, &StagingFSConfig{})
+	writeStagedFile(t, sf, "/f.txt", 4)
+
+	sf.syncOldItems(0)
+
+	// Only a rename failing because the destination exists removes it
+	if string(client.objects["/f.txt"]) != "old" {
+		t.Errorf("Expected destination untouched after failed rename, got %q", client.objects["/f.txt"])
+	}
+	if sf.Get("/f.txt") == nil {
+		t.Errorf("Expected item to stay staged for retry")
+	}
+
+	client.failRename = nil
+	sf.syncOldItems(0)
+
+	if string(client.objects["/f.txt"]) != "xxxx" {
+		t.Errorf("Expected destination to be replaced on retry, got %q", client.objects["/f.txt"])
+	}
+}
+
+func TestStagingFSAtomicReplaceRemoveFailure(t *testing.T) {
+	client := newMockAtomicClient()
+	client.objects["/f.txt"] = []byte("old")
+	client.failRemove = errors.Wrap(os.ErrPermission, "CAT_NO_ACCESS_PERMISSION")
+
+	var syncErr error
+	sf := newAtomicTestFS(t, client, &StagingFSConfig{
+		OnSyncError: func(meta *StagingMetadata, err error) {
+			syncErr = err
+		},
+	})
+	writeStagedFile(t, sf, "/f.txt", 4)
+
+	sf.syncOldItems(0)
+
+	// The error of the removal is reported, not the rename that required it
+	if !errors.Is(syncErr, os.ErrPermission) {
+		t.Errorf("Expected permission error of the removal, got %v", syncErr)
+	}
+	if string(client.objects["/f.txt"]) != "old" {
+		t.Errorf("Expected destination untouched, got %q", client.objects["/f.txt"])
+	}
+}
+
+func TestStagingFSAtomicReplaceTempPattern(t *testing.T) {
+	client := newMockAtomicClient()
+	client.failUpload = true
+
+	sf := newAtomicTestFS(t, client, &StagingFSConfig{AtomicTempPattern: "{name}.part-{id}"})
+	writeStagedFile(t, sf, "/dir/data.bin", 4)
+
+	sf.syncOldItems(0)
+
+	temp := sf.sm.GetTempUpload("/dir/data.bin")
+	if temp == nil {
+		t.Fatalf("Expected temporary upload to be tracked after failure")
+	}
+	if !strings.HasPrefix(temp.TempPath, "/dir/data.bin.part-") {
+		t.Errorf("Expected temp path to follow the pattern, got %s", temp.TempPath)
+	}
+
+	// The next attempt reuses the same temporary object
+	client.failUpload = false
+	sf.syncOldItems(0)
+
+	if string(client.objects["/dir/data.bin"]) != "xxxx" {
+		t.Errorf("Expected destination after retry, got %q", client.objects["/dir/data.bin"])
+	}
+	if _, ok := client.objects[temp.TempPath]; ok {
+		t.Errorf("Expected temporary object to be renamed away")
+	}
+}
+
+func TestStagingFSCleanupAbandonedTemps(t *testing.T) {
+	client := newMockAtomicClient()
+	client.failUpload = true
+
+	sf := newAtomicTestFS(t, client, &StagingFSConfig{AbandonedTempGracePeriod: time.Nanosecond})
+	writeStagedFile(t, sf, "/keep.txt", 4)
+	writeStagedFile(t, sf, "/gone.txt", 4)
+
+	sf.syncOldItems(0)
+
+	gone := sf.sm.GetTempUpload("/gone.txt")
+	keep := sf.sm.GetTempUpload("/keep.txt")
+	if gone == nil || keep == nil {
+		t.Fatalf("Expected both temporary uploads to be tracked")
+	}
+
+	if err := sf.Delete("/gone.txt"); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+
+	time.Sleep(time.Millisecond)
+	sf.cleanupAbandonedTemps()
+
+	if _, ok := client.objects[gone.TempPath]; ok {
+		t.Errorf("Expected abandoned temporary object to be removed")
+	}
+	if sf.sm.GetTempUpload("/gone.txt") != nil {
+		t.Errorf("Expected abandoned temporary upload to be untracked")
+	}
+	if _, ok := client.objects[keep.TempPath]; !ok {
+		t.Errorf("Expected temporary object of a pending upload to be kept")
+	}
+}
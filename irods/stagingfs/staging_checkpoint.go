@@ -0,0 +1,361 @@
+package stagingfs
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	irodsclient_common "github.com/cyverse/go-irodsclient/irods/common"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultResumableUploadThreshold = 256 * 1024 * 1024 // 256MB
+	DefaultUploadPartSize           = 64 * 1024 * 1024  // 64MB
+)
+
+// ResumableStagingClient is implemented by clients that can write a byte range of a data object.
+// StagingFS uses it to upload large files in checkpointed parts that can be resumed after a failure.
+type ResumableStagingClient interface {
+	StagingClient
+	UploadFileRange(localPath string, irodsPath string, offset int64, length int64, transferCallback irodsclient_common.TransferTrackerCallback) error
+	TruncateFile(path string, size int64) error
+	GetDataObjectSize(path string) (int64, error)
+}
+
+// UploadCheckpoint records the progress of a checkpointed upload
+type UploadCheckpoint struct {
+	Path           string    // iRODS path being uploaded
+	Size           int64     // Local file size when the upload started
+	ModTime        time.Time // Local file modification time when the upload started
+	PartSize       int64     // Size of each part
+	CompletedParts []int64   // Indexes of parts already written to iRODS
+}
+
+// GetNumParts returns the total number of parts
+func (cp *UploadCheckpoint) GetNumParts() int64 {
+	if cp.Size == 0 {
+		return 1
+	}
+	return (cp.Size + cp.PartSize - 1) / cp.PartSize
+}
+
+// GetPendingParts returns indexes of parts not yet uploaded, in order
+func (cp *UploadCheckpoint) GetPendingParts() []int64 {
+	completed := make(map[int64]bool, len(cp.CompletedParts))
+	for _, part := range cp.CompletedParts {
+		completed[part] = true
+	}
+
+	pending := []int64{}
+	for part := int64(0); part < cp.GetNumParts(); part++ {
+		if !completed[part] {
+			pending = append(pending, part)
+		}
+	}
+	return pending
+}
+
+// GetCompletedBytes returns the number of bytes already uploaded
+func (cp *UploadCheckpoint) GetCompletedBytes() int64 {
+	var total int64
+	for _, part := range cp.CompletedParts {
+		_, length := cp.GetPartRange(part)
+		total += length
+	}
+	return total
+}
+
+// GetCompletedEnd returns the end offset of the last completed part, the size the data object must
+// have at least for the checkpoint to be resumed
+func (cp *UploadCheckpoint) GetCompletedEnd() int64 {
+	var end int64
+	for _, part := range cp.CompletedParts {
+		offset, length := cp.GetPartRange(part)
+		end = max(end, offset+length)
+	}
+	return end
+}
+
+// GetPartRange returns offset and length of a part
+func (cp *UploadCheckpoint) GetPartRange(part int64) (int64, int64) {
+	offset := part * cp.PartSize
+	length := min(cp.PartSize, cp.Size-offset)
+	return offset, max(length, 0)
+}
+
+// matches returns true if the checkpoint was taken for the same local file content
+func (cp *UploadCheckpoint) matches(info os.FileInfo, partSize int64) bool {
+	return cp.Size == info.Size() && cp.ModTime.Equal(info.ModTime()) && cp.PartSize == partSize
+}
+
+// GetCheckpoint returns a copy of the upload checkpoint for a path, or nil
+func (sm *StagingStateManager) GetCheckpoint(path string) *UploadCheckpoint {
+	sm.auxMu.Lock()
+	defer sm.auxMu.Unlock()
+
+	cp, ok := sm.checkpoints[path]
+	if !ok {
+		return nil
+	}
+
+	copied := *cp
+	copied.CompletedParts = append([]int64{}, cp.CompletedParts...)
+	return &copied
+}
+
+// SaveCheckpoint stores an upload checkpoint in memory and the store
+func (sm *StagingStateManager) SaveCheckpoint(cp *UploadCheckpoint) error {
+	sm.auxMu.Lock()
+	defer sm.auxMu.Unlock()
+	return sm.persistCheckpoint(cp)
+}
+
+// CompleteCheckpointPart marks a part of a checkpointed upload as completed
+func (sm *StagingStateManager) CompleteCheckpointPart(path string, part int64) error {
+	sm.auxMu.Lock()
+	defer sm.auxMu.Unlock()
+
+	cp, ok := sm.checkpoints[path]
+	if !ok {
+		return errors.Newf("no upload checkpoint for %s", path)
+	}
+
+	cp.CompletedParts = append(cp.CompletedParts, part)
+	sort.Slice(cp.CompletedParts, func(i, j int) bool {
+		return cp.CompletedParts[i] < cp.CompletedParts[j]
+	})
+	return sm.persistCheckpoint(cp)
+}
+
+// DeleteCheckpoint removes the upload checkpoint for a path
+func (sm *StagingStateManager) DeleteCheckpoint(path string) error {
+	return sm.deleteCheckpoint(path)
+}
+
+// persistCheckpoint saves a checkpoint to memory and the store (caller must hold auxMu)
+func (sm *StagingStateManager) persistCheckpoint(cp *UploadCheckpoint) error {
+	sm.checkpoints[cp.Path] = cp
+
+	if sm.store == nil {
+		return nil
+	}
+
+	key := []byte(fmt.Sprintf("checkpoint:%s", cp.Path))
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal upload checkpoint")
+	}
+
+	return sm.updateStore(func(txn MetadataTxn) error {
+		return txn.Put(key, data)
+	})
+}
+
+// deleteCheckpoint removes a checkpoint from memory and the store
+func (sm *StagingStateManager) deleteCheckpoint(path string) error {
+	sm.auxMu.Lock()
+	defer sm.auxMu.Unlock()
+
+	if _, ok := sm.checkpoints[path]; !ok {
+		return nil
+	}
+	delete(sm.checkpoints, path)
+
+	if sm.store == nil {
+		return nil
+	}
+
+	key := []byte(fmt.Sprintf("checkpoint:%s", path))
+	return sm.updateStore(func(txn MetadataTxn) error {
+		return txn.Delete(key)
+	})
+}
+
+// restoreCheckpoints loads checkpoints from the store (caller must hold auxMu)
+func (sm *StagingStateManager) restoreCheckpoints(txn MetadataTxn) error {
+	return txn.Iterate([]byte("checkpoint:"), func(_ []byte, val []byte) error {
+		var cp UploadCheckpoint
+		if err := json.Unmarshal(val, &cp); err != nil {
+			return errors.Wrap(err, "failed to unmarshal upload checkpoint")
+		}
+
+		sm.checkpoints[cp.Path] = &cp
+		return nil
+	})
+}
+
+// uploadFile uploads a staged file, through a temporary data object when AtomicReplace is set
+func (sf *StagingFS) uploadFile(localPath string, irodsPath string) error {
+	if sf.config.AtomicReplace {
+		return sf.uploadFileAtomic(localPath, irodsPath)
+	}
+	return sf.uploadFileTo(localPath, irodsPath)
+}
+
+// uploadFileTo uploads a local file to irodsPath, using a checkpointed upload for large files when the client supports it
+func (sf *StagingFS) uploadFileTo(localPath string, irodsPath string) error {
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return errors.Wrapf(err, "failed to stat local file %s", localPath)
+	}
+
+	threshold := sf.config.ResumableUploadThreshold
+	if threshold == 0 {
+		threshold = DefaultResumableUploadThreshold
+	}
+
+	resumable, ok := sf.client.(ResumableStagingClient)
+	if !ok || threshold < 0 || info.Size() < threshold {
+		// Fall back to a full upload
+		sf.sm.DeleteCheckpoint(irodsPath)
+		return sf.interruptible(func() error {
+			return sf.client.UploadFileParallel(localPath, irodsPath, 4, sf.transferCallback())
+		})
+	}
+
+	return sf.uploadFileResumable(resumable, localPath, irodsPath, info)
+}
+
+// uploadFileResumable uploads the parts missing from the checkpoint and truncates the
+// data object to the local size once all parts are written
+func (sf *StagingFS) uploadFileResumable(client ResumableStagingClient, localPath string, irodsPath string, info os.FileInfo) error {
+	partSize := sf.config.UploadPartSize
+	if partSize <= 0 {
+		partSize = DefaultUploadPartSize
+	}
+
+	concurrency := sf.config.UploadPartConcurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	cp := sf.sm.GetCheckpoint(irodsPath)
+	if cp != nil && cp.matches(info, partSize) && !sf.checkpointUploaded(client, cp) {
+		cp = nil
+	}
+	if cp == nil || !cp.matches(info, partSize) {
+		cp = &UploadCheckpoint{
+			Path:           irodsPath,
+			Size:           info.Size(),
+			ModTime:        info.ModTime(),
+			PartSize:       partSize,
+			CompletedParts: []int64{},
+		}
+		if err := sf.sm.SaveCheckpoint(cp); err != nil {
+			return errors.Wrapf(err, "failed to save upload checkpoint for %s", irodsPath)
+		}
+	} else {
+		log.Infof("resuming upload of %s from checkpoint (%d of %d bytes done)", irodsPath, cp.GetCompletedBytes(), cp.Size)
+	}
+
+	transferCallback := sf.transferCallback()
+
+	uploadPart := func(part int64) error {
+		if err := sf.syncCtx.Err(); err != nil {
+			return errors.Wrapf(err, "upload of %s interrupted before part %d", irodsPath, part)
+		}
+
+		offset, length := cp.GetPartRange(part)
+		err := sf.interruptible(func() error {
+			return client.UploadFileRange(localPath, irodsPath, offset, length, partCallback(transferCallback, part))
+		})
+		if err != nil {
+			return errors.Wrapf(err, "failed to upload part %d of %s", part, irodsPath)
+		}
+		return sf.sm.CompleteCheckpointPart(irodsPath, part)
+	}
+
+	pending := cp.GetPendingParts()
+	if len(pending) > 0 {
+		// Upload the first part alone so the data object exists before parts are written concurrently
+		if err := uploadPart(pending[0]); err != nil {
+			return err
+		}
+		pending = pending[1:]
+	}
+
+	partCh := make(chan int64, len(pending))
+	for _, part := range pending {
+		partCh <- part
+	}
+	close(partCh)
+
+	var wg sync.WaitGroup
+	var errMutex sync.Mutex
+	var firstErr error
+
+	for i := 0; i < min(concurrency, len(pending)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for part := range partCh {
+				errMutex.Lock()
+				failed := firstErr != nil
+				errMutex.Unlock()
+				if failed {
+					return
+				}
+
+				if err := uploadPart(part); err != nil {
+					errMutex.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					errMutex.Unlock()
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+
+	// Drop any trailing data left by a previous, larger version of the data object
+	if err := client.TruncateFile(irodsPath, cp.Size); err != nil {
+		return errors.Wrapf(err, "failed to truncate %s after upload", irodsPath)
+	}
+
+	return sf.sm.DeleteCheckpoint(irodsPath)
+}
+
+// checkpointUploaded returns true if the data object still holds the completed parts of the checkpoint.
+// The data object may have been removed, replaced or truncated since they were written, e.g. while the
+// staging filesystem was not running.
+func (sf *StagingFS) checkpointUploaded(client ResumableStagingClient, cp *UploadCheckpoint) bool {
+	end := cp.GetCompletedEnd()
+	if end == 0 {
+		return true
+	}
+
+	size, err := client.GetDataObjectSize(cp.Path)
+	if err != nil {
+		log.WithError(err).Warnf("failed to stat %s, restarting its upload", cp.Path)
+		return false
+	}
+	if size < end {
+		log.Warnf("%s holds %d bytes but its upload checkpoint completed %d, restarting its upload", cp.Path, size, end)
+		return false
+	}
+	return true
+}
+
+// partCallback reports the progress of a part under its own task name, since clients report every
+// range upload under the same name and concurrent parts would otherwise be mixed up
+func partCallback(transferCallback irodsclient_common.TransferTrackerCallback, part int64) irodsclient_common.TransferTrackerCallback {
+	if transferCallback == nil {
+		return nil
+	}
+
+	return func(taskName string, processed int64, total int64) {
+		transferCallback(fmt.Sprintf("%s part %d", taskName, part), processed, total)
+	}
+}
@@ -0,0 +1,369 @@
+package stagingfs
+
+import (
+	"context"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	irodsclient_common "github.com/cyverse/go-irodsclient/irods/common"
+)
+
+// MockResumableClient implements ResumableStagingClient, storing uploaded data in memory
+type MockResumableClient struct {
+	MockStagingClient
+
+	mu          sync.Mutex
+	objects     map[string][]byte
+	rangeCalls  []int64 // offsets of UploadFileRange calls
+	fullUploads int
+	failOffset  int64 // UploadFileRange at this offset fails once (-1 = never)
+
+	onRange func(offset int64) // called before UploadFileRange, outside the lock
+}
+
+func newMockResumableClient() *MockResumableClient {
+	return &MockResumableClient{
+		objects:    make(map[string][]byte),
+		failOffset: -1,
+	}
+}
+
+func (m *MockResumableClient) UploadFileParallel(localPath string, irodsPath string, taskNum int, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	data, err := os.ReadFile(localPath)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.fullUploads++
+	m.objects[irodsPath] = data
+	return nil
+}
+
+func (m *MockResumableClient) UploadFileRange(localPath string, irodsPath string, offset int64, length int64, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	if m.onRange != nil {
+		m.onRange(offset)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.rangeCalls = append(m.rangeCalls, offset)
+	if offset == m.failOffset {
+		m.failOffset = -1
+		return errors.New("connection reset")
+	}
+
+	data, err := os.ReadFile(localPath)
+	if err != nil {
+		return err
+	}
+
+	obj := m.objects[irodsPath]
+	if int64(len(obj)) < offset+length {
+		obj = append(obj, make([]byte, offset+length-int64(len(obj)))...)
+	}
+	copy(obj[offset:offset+length], data[offset:offset+length])
+	m.objects[irodsPath] = obj
+	return nil
+}
+
+func (m *MockResumableClient) TruncateFile(path string, size int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[path] = m.objects[path][:size]
+	return nil
+}
+
+func (m *MockResumableClient) GetDataObjectSize(path string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	obj, ok := m.objects[path]
+	if !ok {
+		return 0, os.ErrNotExist
+	}
+	return int64(len(obj)), nil
+}
+
+func TestUploadCheckpointParts(t *testing.T) {
+	cp := &UploadCheckpoint{Size: 10, PartSize: 4, CompletedParts: []int64{1}}
+
+	if cp.GetNumParts() != 3 {
+		t.Errorf("Expected 3 parts, got %d", cp.GetNumParts())
+	}
+
+	pending := cp.GetPendingParts()
+	if len(pending) != 2 || pending[0] != 0 || pending[1] != 2 {
+		t.Errorf("Expected pending parts [0 2], got %v", pending)
+	}
+
+	offset, length := cp.GetPartRange(2)
+	if offset != 8 || length != 2 {
+		t.Errorf("Expected last part at 8 with length 2, got %d/%d", offset, length)
+	}
+
+	if cp.GetCompletedBytes() != 4 {
+		t.Errorf("Expected 4 completed bytes, got %d", cp.GetCompletedBytes())
+	}
+}
+
+func TestStagingFSResumeUploadAfterFailure(t *testing.T) {
+	tmpDir := t.TempDir()
+	client := newMockResumableClient()
+	client.failOffset = 4
+
+	config := &StagingFSConfig{
+		LocalRootPath:            tmpDir,
+		Client:                   client,
+		ResumableUploadThreshold: 1,
+		UploadPartSize:           4,
+	}
+
+	sf, err := NewStagingFSWithPersistence(config)
+	if err != nil {
+		t.Fatalf("Failed to create StagingFS: %v", err)
+	}
+
+	f, err := sf.OpenForWrite("/big.dat")
+	if err != nil {
+		t.Fatalf("Failed to open file for writing: %v", err)
+	}
+	f.Write([]byte("0123456789"))
+	f.Close()
+
+	// First attempt fails on the second part
+	sf.syncOldItems(0)
+
+	cp := sf.sm.GetCheckpoint("/big.dat")
+	if cp == nil {
+		t.Fatalf("Expected checkpoint after failed upload")
+	}
+	if len(cp.CompletedParts) != 1 || cp.CompletedParts[0] != 0 {
+		t.Fatalf("Expected part 0 completed, got %v", cp.CompletedParts)
+	}
+
+	// Simulate a crash and restart
+	sf.stopOnce.Do(func() { close(sf.stopCh) })
+	<-sf.workerDone
+	if err := sf.sm.store.Close(); err != nil {
+		t.Fatalf("Failed to close DB: %v", err)
+	}
+
+	sf2, err := NewStagingFSWithPersistence(config)
+	if err != nil {
+		t.Fatalf("Failed to reopen StagingFS: %v", err)
+	}
+	defer sf2.Close(context.Background())
+
+	if sf2.sm.GetCheckpoint("/big.dat") == nil {
+		t.Fatalf("Expected checkpoint to be restored")
+	}
+
+	client.rangeCalls = nil
+	sf2.syncOldItems(0)
+
+	if len(client.rangeCalls) != 2 || client.rangeCalls[0] != 4 || client.rangeCalls[1] != 8 {
+		t.Errorf("Expected resume from offset 4, got calls %v", client.rangeCalls)
+	}
+	if string(client.objects["/big.dat"]) != "0123456789" {
+		t.Errorf("Expected uploaded content 0123456789, got %q", client.objects["/big.dat"])
+	}
+	if sf2.sm.GetCheckpoint("/big.dat") != nil {
+		t.Errorf("Expected checkpoint to be removed after upload")
+	}
+	if client.fullUploads != 0 {
+		t.Errorf("Expected no full uploads, got %d", client.fullUploads)
+	}
+}
+
+func TestStagingFSResumeRestartsWhenFileChanged(t *testing.T) {
+	tmpDir := t.TempDir()
+	client := newMockResumableClient()
+	client.failOffset = 4
+
+	config := &StagingFSConfig{
+		LocalRootPath:            tmpDir,
+		Client:                   client,
+		ResumableUploadThreshold: 1,
+		UploadPartSize:           4,
+	}
+
+	sf, err := NewStagingFS(config)
+	if err != nil {
+		t.Fatalf("Failed to create StagingFS: %v", err)
+	}
+	defer sf.Close(context.Background())
+
+	f, err := sf.OpenForWrite("/changed.dat")
+	if err != nil {
+		t.Fatalf("Failed to open file for writing: %v", err)
+	}
+	f.Write([]byte("0123456789"))
+	f.Close()
+
+	sf.syncOldItems(0)
+
+	// Local file changes after the partial upload
+	f, err = sf.OpenForWrite("/changed.dat")
+	if err != nil {
+		t.Fatalf("Failed to open file for writing: %v", err)
+	}
+	f.Write([]byte("abcdefghijkl"))
+	f.Close()
+
+	client.rangeCalls = nil
+	sf.syncOldItems(0)
+
+	if len(client.rangeCalls) != 3 || client.rangeCalls[0] != 0 {
+		t.Errorf("Expected full restart from offset 0, got calls %v", client.rangeCalls)
+	}
+	if string(client.objects["/changed.dat"]) != "abcdefghijkl" {
+		t.Errorf("Expected uploaded content abcdefghijkl, got %q", client.objects["/changed.dat"])
+	}
+}
+
+func TestStagingFSResumeRestartsWhenObjectRemoved(t *testing.T) {
+	tmpDir := t.TempDir()
+	client := newMockResumableClient()
+	client.failOffset = 8
+
+	config := &StagingFSConfig{
+		LocalRootPath:            tmpDir,
+		Client:                   client,
+		ResumableUploadThreshold: 1,
+		UploadPartSize:           4,
+	}
+
+	sf, err := NewStagingFS(config)
+	if err != nil {
+		t.Fatalf("Failed to create StagingFS: %v", err)
+	}
+	defer sf.Close(context.Background())
+
+	f, err := sf.OpenForWrite("/removed.dat")
+	if err != nil {
+		t.Fatalf("Failed to open file for writing: %v", err)
+	}
+	f.Write([]byte("0123456789"))
+	f.Close()
+
+	// First attempt writes parts 0 and 1, then fails
+	sf.syncOldItems(0)
+
+	// The data object is removed before the upload resumes
+	client.mu.Lock()
+	delete(client.objects, "/removed.dat")
+	client.rangeCalls = nil
+	client.mu.Unlock()
+
+	sf.syncOldItems(0)
+
+	if len(client.rangeCalls) != 3 || client.rangeCalls[0] != 0 {
+		t.Errorf("Expected full restart from offset 0, got calls %v", client.rangeCalls)
+	}
+	if string(client.objects["/removed.dat"]) != "0123456789" {
+		t.Errorf("Expected uploaded content 0123456789, got %q", client.objects["/removed.dat"])
+	}
+}
+
+func TestStagingFSSmallFilesUseFullUpload(t *testing.T) {
+	tmpDir := t.TempDir()
+	client := newMockResumableClient()
+
+	config := &StagingFSConfig{
+		LocalRootPath:            tmpDir,
+		Client:                   client,
+		ResumableUploadThreshold: 1024,
+	}
+
+	sf, err := NewStagingFS(config)
+	if err != nil {
+		t.Fatalf("Failed to create StagingFS: %v", err)
+	}
+	defer sf.Close(context.Background())
+
+	f, err := sf.OpenForWrite("/small.dat")
+	if err != nil {
+		t.Fatalf("Failed to open file for writing: %v", err)
+	}
+	f.Write([]byte("small"))
+	f.Close()
+
+	sf.syncOldItems(0)
+
+	if client.fullUploads != 1 || len(client.rangeCalls) != 0 {
+		t.Errorf("Expected a single full upload, got %d full / %d range", client.fullUploads, len(client.rangeCalls))
+	}
+}
+
+func TestStagingFSCloseInterruptsResumableUpload(t *testing.T) {
+	tmpDir := t.TempDir()
+	client := newMockResumableClient()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	client.onRange = func(offset int64) {
+		if offset == 4 {
+			close(started)
+			<-release
+		}
+	}
+
+	config := &StagingFSConfig{
+		LocalRootPath:            tmpDir,
+		Client:                   client,
+		ResumableUploadThreshold: 1,
+		UploadPartSize:           4,
+	}
+
+	sf, err := NewStagingFSWithPersistence(config)
+	if err != nil {
+		t.Fatalf("Failed to create StagingFS: %v", err)
+	}
+
+	f, err := sf.OpenForWrite("/big.dat")
+	if err != nil {
+		t.Fatalf("Failed to open file for writing: %v", err)
+	}
+	f.Write([]byte("0123456789"))
+	f.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	closeDone := make(chan error)
+	go func() {
+		closeDone <- sf.Close(ctx)
+	}()
+
+	// Give up while the second part is uploading: Close returns without waiting for it
+	<-started
+	cancel()
+	err = <-closeDone
+	var pendingErr *PendingSyncError
+	if !errors.As(err, &pendingErr) {
+		t.Fatalf("Expected PendingSyncError, got %v", err)
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected close to report the cancellation, got %v", err)
+	}
+	if len(client.rangeCalls) != 1 {
+		t.Errorf("Expected no parts after cancellation, got calls %v", client.rangeCalls)
+	}
+	close(release)
+
+	// The finished part is kept for the next mount, the abandoned one is uploaded again
+	client.onRange = nil
+	sf2, err := NewStagingFSWithPersistence(config)
+	if err != nil {
+		t.Fatalf("Failed to reopen StagingFS: %v", err)
+	}
+	defer sf2.Close(context.Background())
+
+	cp := sf2.sm.GetCheckpoint("/big.dat")
+	if cp == nil || len(cp.CompletedParts) != 1 || cp.CompletedParts[0] != 0 {
+		t.Fatalf("Expected checkpoint with part 0 completed, got %+v", cp)
+	}
+}
@@ -0,0 +1,198 @@
+package stagingfs
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// StagingEventType is the type of a staging lifecycle event
+type StagingEventType int
+
+const (
+	EventStaged StagingEventType = iota
+	EventSyncStarted
+	EventSyncSucceeded
+	EventSyncFailed
+	EventMovedToFailed
+	EventQuotaPressure
+	EventRecoveredOnStartup
+)
+
+func (t StagingEventType) String() string {
+	switch t {
+	case EventStaged:
+		return "STAGED"
+	case EventSyncStarted:
+		return "SYNC_STARTED"
+	case EventSyncSucceeded:
+		return "SYNC_SUCCEEDED"
+	case EventSyncFailed:
+		return "SYNC_FAILED"
+	case EventMovedToFailed:
+		return "MOVED_TO_FAILED"
+	case EventQuotaPressure:
+		return "QUOTA_PRESSURE"
+	case EventRecoveredOnStartup:
+		return "RECOVERED_ON_STARTUP"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// DefaultEventBufferSize is the channel size used when Subscribe is given a non-positive size
+const DefaultEventBufferSize = 256
+
+// QuotaPressureRatio is the fraction of MaxDataSize above which quota pressure events are emitted
+const QuotaPressureRatio = 0.9
+
+// StagingEvent describes a single staging lifecycle event
+type StagingEvent struct {
+	Type      StagingEventType
+	Metadata  *StagingMetadata // Snapshot of the metadata at the time of the event (nil for quota events)
+	Bytes     int64            // Size of the staged data involved (current usage for quota events)
+	Duration  time.Duration    // Time taken by the sync (sync-succeeded / sync-failed only)
+	Err       error            // Sync error (sync-failed / moved-to-failed only)
+	Timestamp time.Time
+}
+
+// StagingEventSubscription receives staging events through a buffered channel.
+// Delivery never blocks the staging layer; events that do not fit in the buffer are dropped and counted.
+type StagingEventSubscription struct {
+	id      uint64
+	bus     *stagingEventBus
+	ch      chan *StagingEvent
+	dropped uint64
+	closed  bool
+}
+
+// Events returns the channel delivering events. It is closed when the subscription or StagingFS is closed.
+func (s *StagingEventSubscription) Events() <-chan *StagingEvent {
+	return s.ch
+}
+
+// GetDroppedCount returns the number of events dropped because the buffer was full
+func (s *StagingEventSubscription) GetDroppedCount() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Close unsubscribes and closes the event channel
+func (s *StagingEventSubscription) Close() {
+	s.bus.unsubscribe(s)
+}
+
+// deliver sends the event without blocking (caller must hold bus.mu)
+func (s *StagingEventSubscription) deliver(event *StagingEvent) {
+	select {
+	case s.ch <- event:
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+	}
+}
+
+// stagingEventBus fans out events to all subscribers
+type stagingEventBus struct {
+	mu          sync.RWMutex
+	subscribers map[uint64]*StagingEventSubscription
+	nextID      uint64
+	closed      bool
+	replay      []*StagingEvent // events replayed to every new subscriber (recovered-on-startup)
+}
+
+func newStagingEventBus() *stagingEventBus {
+	return &stagingEventBus{
+		subscribers: make(map[uint64]*StagingEventSubscription),
+	}
+}
+
+func (b *stagingEventBus) subscribe(bufferSize int) *StagingEventSubscription {
+	if bufferSize <= 0 {
+		bufferSize = DefaultEventBufferSize
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sub := &StagingEventSubscription{
+		id:  b.nextID,
+		bus: b,
+		ch:  make(chan *StagingEvent, bufferSize),
+	}
+	b.nextID++
+
+	if b.closed {
+		sub.closed = true
+		close(sub.ch)
+		return sub
+	}
+
+	for _, event := range b.replay {
+		sub.deliver(event)
+	}
+
+	b.subscribers[sub.id] = sub
+	return sub
+}
+
+func (b *stagingEventBus) unsubscribe(sub *StagingEventSubscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if sub.closed {
+		return
+	}
+
+	delete(b.subscribers, sub.id)
+	sub.closed = true
+	close(sub.ch)
+}
+
+// addReplay records an event that every current and future subscriber receives
+func (b *stagingEventBus) addReplay(event *StagingEvent) {
+	b.mu.Lock()
+	b.replay = append(b.replay, event)
+	b.mu.Unlock()
+
+	b.publish(event)
+}
+
+func (b *stagingEventBus) publish(event *StagingEvent) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, sub := range b.subscribers {
+		sub.deliver(event)
+	}
+}
+
+func (b *stagingEventBus) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return
+	}
+	b.closed = true
+
+	for id, sub := range b.subscribers {
+		delete(b.subscribers, id)
+		sub.closed = true
+		close(sub.ch)
+	}
+}
+
+// newStagingEvent creates an event with a snapshot of the given metadata
+func newStagingEvent(eventType StagingEventType, meta *StagingMetadata, bytes int64) *StagingEvent {
+	var snapshot *StagingMetadata
+	if meta != nil {
+		copied := *meta
+		snapshot = &copied
+	}
+
+	return &StagingEvent{
+		Type:      eventType,
+		Metadata:  snapshot,
+		Bytes:     bytes,
+		Timestamp: time.Now(),
+	}
+}
@@ -0,0 +1,249 @@
+package stagingfs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+// collectEvents drains all events currently buffered in the subscription
+func collectEvents(sub *StagingEventSubscription) []*StagingEvent {
+	var events []*StagingEvent
+	for {
+		select {
+		case event, ok := <-sub.Events():
+			if !ok {
+				return events
+			}
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func countEvents(events []*StagingEvent, eventType StagingEventType) int {
+	count := 0
+	for _, event := range events {
+		if event.Type == eventType {
+			count++
+		}
+	}
+	return count
+}
+
+func TestStagingEventsStagedAndSync(t *testing.T) {
+	tmpDir := t.TempDir()
+	config := &StagingFSConfig{
+		LocalRootPath: tmpDir,
+		Client:        &MockStagingClient{},
+	}
+
+	sf, err := NewStagingFS(config)
+	if err != nil {
+		t.Fatalf("Failed to create StagingFS: %v", err)
+	}
+	defer sf.Close(context.Background())
+
+	sub1 := sf.Subscribe(16)
+	sub2 := sf.Subscribe(16)
+
+	f, err := sf.OpenForWrite("/test.txt")
+	if err != nil {
+		t.Fatalf("Failed to open file for writing: %v", err)
+	}
+	f.Write([]byte("hello"))
+	f.Close()
+
+	if err := sf.SyncAll(); err != nil {
+		t.Fatalf("Failed to sync: %v", err)
+	}
+
+	for _, sub := range []*StagingEventSubscription{sub1, sub2} {
+		events := collectEvents(sub)
+		if countEvents(events, EventStaged) != 1 {
+			t.Errorf("Expected 1 staged event, got %d", countEvents(events, EventStaged))
+		}
+		if countEvents(events, EventSyncStarted) != 1 {
+			t.Errorf("Expected 1 sync-started event, got %d", countEvents(events, EventSyncStarted))
+		}
+		if countEvents(events, EventSyncSucceeded) != 1 {
+			t.Errorf("Expected 1 sync-succeeded event, got %d", countEvents(events, EventSyncSucceeded))
+		}
+
+		last := events[len(events)-1]
+		if last.Metadata == nil || last.Metadata.Path != "/test.txt" {
+			t.Errorf("Expected metadata snapshot for /test.txt, got %v", last.Metadata)
+		}
+		if last.Bytes != 5 {
+			t.Errorf("Expected 5 bytes, got %d", last.Bytes)
+		}
+	}
+}
+
+func TestStagingEventsSyncFailedAndMovedToFailed(t *testing.T) {
+	tmpDir := t.TempDir()
+	config := &StagingFSConfig{
+		LocalRootPath: tmpDir,
+		Client:        &MockStagingClient{},
+		SyncInterval:  time.Hour,
+	}
+
+	sf, err := NewStagingFS(config)
+	if err != nil {
+		t.Fatalf("Failed to create StagingFS: %v", err)
+	}
+	defer sf.Close(context.Background())
+
+	sf.RegisterActionHandler(func(meta *StagingMetadata) error {
+		return errors.New("upload failed")
+	})
+
+	sub := sf.Subscribe(64)
+
+	if err := sf.Create("/fail.txt"); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	for i := 0; i < MaxSyncFailCount; i++ {
+		sf.syncOldItems(0)
+	}
+
+	events := collectEvents(sub)
+	if countEvents(events, EventSyncFailed) != MaxSyncFailCount {
+		t.Errorf("Expected %d sync-failed events, got %d", MaxSyncFailCount, countEvents(events, EventSyncFailed))
+	}
+	if countEvents(events, EventMovedToFailed) != 1 {
+		t.Errorf("Expected 1 moved-to-failed event, got %d", countEvents(events, EventMovedToFailed))
+	}
+
+	for _, event := range events {
+		if event.Type == EventSyncFailed && event.Err == nil {
+			t.Errorf("Expected sync-failed event to carry an error")
+		}
+	}
+}
+
+func TestStagingEventsDropWhenFull(t *testing.T) {
+	tmpDir := t.TempDir()
+	config := &StagingFSConfig{
+		LocalRootPath: tmpDir,
+		Client:        &MockStagingClient{},
+	}
+
+	sf, err := NewStagingFS(config)
+	if err != nil {
+		t.Fatalf("Failed to create StagingFS: %v", err)
+	}
+	defer sf.Close(context.Background())
+
+	sub := sf.Subscribe(1)
+
+	for _, path := range []string{"/a.txt", "/b.txt", "/c.txt"} {
+		if err := sf.Create(path); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+
+	if len(collectEvents(sub)) != 1 {
+		t.Errorf("Expected only 1 buffered event")
+	}
+	if sub.GetDroppedCount() != 2 {
+		t.Errorf("Expected 2 dropped events, got %d", sub.GetDroppedCount())
+	}
+}
+
+func TestStagingEventsQuotaPressure(t *testing.T) {
+	tmpDir := t.TempDir()
+	config := &StagingFSConfig{
+		LocalRootPath: tmpDir,
+		Client:        &MockStagingClient{},
+		MaxDataSize:   100,
+	}
+
+	sf, err := NewStagingFS(config)
+	if err != nil {
+		t.Fatalf("Failed to create StagingFS: %v", err)
+	}
+	defer sf.Close(context.Background())
+
+	sub := sf.Subscribe(16)
+
+	sf.addDataSize(95)
+	if _, err := sf.OpenForWrite("/full.txt"); err != nil {
+		t.Fatalf("Expected open to succeed below quota: %v", err)
+	}
+
+	sf.addDataSize(10)
+	if _, err := sf.OpenForWrite("/over.txt"); err == nil {
+		t.Errorf("Expected quota error")
+	}
+
+	events := collectEvents(sub)
+	if countEvents(events, EventQuotaPressure) != 2 {
+		t.Errorf("Expected 2 quota-pressure events, got %d", countEvents(events, EventQuotaPressure))
+	}
+}
+
+func TestStagingEventsRecoveredOnStartup(t *testing.T) {
+	tmpDir := t.TempDir()
+	config := &StagingFSConfig{
+		LocalRootPath: tmpDir,
+		Client:        &MockStagingClient{},
+	}
+
+	sf, err := NewStagingFSWithPersistence(config)
+	if err != nil {
+		t.Fatalf("Failed to create StagingFS: %v", err)
+	}
+
+	if err := sf.Create("/recover.txt"); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	// Simulate a crash: stop the worker and close the DB without syncing
+	sf.stopOnce.Do(func() { close(sf.stopCh) })
+	if err := sf.sm.store.Close(); err != nil {
+		t.Fatalf("Failed to close DB: %v", err)
+	}
+
+	sf2, err := NewStagingFSWithPersistence(config)
+	if err != nil {
+		t.Fatalf("Failed to reopen StagingFS: %v", err)
+	}
+	defer sf2.Close(context.Background())
+
+	sub := sf2.Subscribe(16)
+	events := collectEvents(sub)
+	if countEvents(events, EventRecoveredOnStartup) != 1 {
+		t.Fatalf("Expected 1 recovered-on-startup event, got %d", countEvents(events, EventRecoveredOnStartup))
+	}
+	if events[0].Metadata.Path != "/recover.txt" {
+		t.Errorf("Expected recovered path /recover.txt, got %s", events[0].Metadata.Path)
+	}
+}
+
+func TestStagingEventsClosedOnClose(t *testing.T) {
+	tmpDir := t.TempDir()
+	config := &StagingFSConfig{
+		LocalRootPath: tmpDir,
+		Client:        &MockStagingClient{},
+	}
+
+	sf, err := NewStagingFS(config)
+	if err != nil {
+		t.Fatalf("Failed to create StagingFS: %v", err)
+	}
+
+	sub := sf.Subscribe(16)
+	sf.Close(context.Background())
+
+	// Drain until the channel is closed
+	for range sub.Events() {
+	}
+
+	// Closing again must be safe
+	sub.Close()
+}
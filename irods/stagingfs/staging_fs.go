@@ -1,17 +1,29 @@
 package stagingfs
 
 import (
+	"context"
+	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/cockroachdb/errors"
 	irodsclient_common "github.com/cyverse/go-irodsclient/irods/common"
-	"github.com/dgraph-io/badger/v3"
 	log "github.com/sirupsen/logrus"
 )
 
+// ErrQuotaExceeded is returned when staging more data would exceed MaxDataSize
+var ErrQuotaExceeded = errors.New("staging quota exceeded")
+
+// ErrInvalidTransition is returned when a change conflicts with the pending action of a staged path
+var ErrInvalidTransition = errors.New("invalid action transition")
+
+// ErrNotStaged is returned when a change needs pending staged state of a path that has none
+var ErrNotStaged = errors.New("path is not staged")
+
 // StagingClient defines the minimal interface that StagingFS needs from the backend storage
 type StagingClient interface {
 	DownloadFileParallel(irodsPath string, localPath string, taskNum int, transferCallback irodsclient_common.TransferTrackerCallback) error
@@ -34,12 +46,78 @@ type StagingFSConfig struct {
 	GracePeriod   time.Duration    // Items older than this are synced (default: 10s)
 	MaxDataSize   int64            // Max total disk usage for staged data (default: 1GB, 0 = unlimited)
 	OnSyncError   SyncErrorHandler // Called when background sync fails for an item (optional)
+
+	// Checkpointed uploads (used when Client implements ResumableStagingClient)
+	ResumableUploadThreshold int64 // Files at least this large are uploaded in resumable parts (default: 256MB, <0 = disabled)
+	UploadPartSize           int64 // Size of each resumable part (default: 64MB)
+	UploadPartConcurrency    int   // Parts uploaded concurrently (default: 1)
+
+	// Background sync throttling
+	SyncBandwidthLimit    int64        // Max bytes per second for sync uploads and downloads (0 = unlimited)
+	SyncBandwidthBurst    int64        // Token bucket burst size in bytes (default: one second of SyncBandwidthLimit)
+	SyncWindows           []SyncWindow // Times of day when large files are synced in background (empty = any time)
+	WindowedSyncThreshold int64        // Uploads at least this large wait for a sync window (default: 64MB)
+
+	// Background sync priority
+	PriorityRules         []PriorityRule // Path glob rules assigning priorities, first match wins
+	SmallFileThreshold    int64          // Items smaller than this get PriorityHigh (default: 1MB, <0 = disabled)
+	LargeFileThreshold    int64          // Items at least this large get PriorityLow (0 = disabled)
+	PriorityAgingInterval time.Duration  // Waiting items are raised one priority level per interval (default: 5m)
+
+	// Atomic replace: upload to a temporary sibling and rename it over the destination after verification
+	AtomicReplace            bool          // Enable atomic replace for staged uploads
+	AtomicTempPattern        string        // Temporary object name, {name} and {id} are substituted (default: ".{name}.staging-{id}.tmp")
+	AbandonedTempGracePeriod time.Duration // Temporaries of cancelled uploads are removed after this (default: 10m, <0 = keep)
+
+	// Persistence (NewStagingFSWithPersistence only)
+	MetadataStore         MetadataStore      // Store for staging state, closed by StagingFS.Close (default: Badger in <LocalRootPath>/meta)
+	MetadataDurability    MetadataDurability // DurabilityStrict commits every change before it returns (default), DurabilityBatched group-commits
+	MetadataFlushInterval time.Duration      // Max delay of batched commits (default: 100ms)
+	MetadataMaxBatchSize  int                // Queued writes that trigger a commit before the interval (default: 1000)
 }
 
 const DefaultMaxDataSize = 10 * 1024 * 1024 * 1024 // 10GB
 
 const MaxSyncFailCount = 3
 
+// PendingSyncError is returned by Close when some staged items could not be synced.
+// With persistence, their local data and metadata are kept for the next mount. Without it, the
+// changes are lost: Lost is set, and the data left in the staging root is removed on the next start.
+type PendingSyncError struct {
+	Pending []*StagingMetadata // items still waiting to be synced
+	Failed  []*StagingMetadata // items that exceeded the max retry count
+	Errors  []error            // sync errors and context errors that occurred during Close
+	Lost    bool               // the staging state is memory-only, so the items cannot be resumed
+}
+
+func (e *PendingSyncError) Error() string {
+	items := make([]string, 0, len(e.Pending)+len(e.Failed))
+	for _, meta := range e.Pending {
+		items = append(items, fmt.Sprintf("%s (%s)", meta.Path, meta.Action))
+	}
+	for _, meta := range e.Failed {
+		items = append(items, fmt.Sprintf("%s (%s, failed)", meta.Path, meta.Action))
+	}
+
+	msg := fmt.Sprintf("%d staged items remain unsynced: %s", len(items), strings.Join(items, ", "))
+	if e.Lost {
+		msg = fmt.Sprintf("%d staged items were lost unsynced, the staging state is not persisted: %s", len(items), strings.Join(items, ", "))
+	}
+	if len(e.Errors) > 0 {
+		errMsgs := make([]string, 0, len(e.Errors))
+		for _, err := range e.Errors {
+			errMsgs = append(errMsgs, err.Error())
+		}
+		msg += "; errors: " + strings.Join(errMsgs, "; ")
+	}
+	return msg
+}
+
+// Unwrap returns the underlying errors so errors.Is can match context errors
+func (e *PendingSyncError) Unwrap() []error {
+	return e.Errors
+}
+
 // StagingFS manages local file staging and metadata tracking
 type StagingFS struct {
 	config      *StagingFSConfig
@@ -47,11 +125,16 @@ type StagingFS struct {
 	client      StagingClient
 	stopCh      chan struct{}
 	stopOnce    sync.Once
+	workerDone  chan struct{}
+	syncCtx     context.Context // Done once Close gives up on syncing, aborts resumable uploads between parts
+	cancelSync  context.CancelFunc
 	sizeMutex   sync.Mutex
 	currentSize int64 // current total staged data size
 	maxSize     int64 // max allowed data size
 	failedMutex sync.Mutex
 	failedItems map[string]*StagingMetadata // items that exceeded max retry count
+	events      *stagingEventBus
+	limiter     *BandwidthLimiter // nil when sync bandwidth is unlimited
 }
 
 // NewStagingFS creates a new StagingFS with memory-only state manager
@@ -92,8 +175,15 @@ func NewStagingFS(config *StagingFSConfig) (*StagingFS, error) {
 		sm:          sm,
 		client:      config.Client,
 		stopCh:      make(chan struct{}),
+		workerDone:  make(chan struct{}),
 		maxSize:     maxSize,
 		failedItems: make(map[string]*StagingMetadata),
+		events:      newStagingEventBus(),
+	}
+	sf.syncCtx, sf.cancelSync = context.WithCancel(context.Background())
+
+	if config.SyncBandwidthLimit > 0 {
+		sf.limiter = NewBandwidthLimiter(config.SyncBandwidthLimit, config.SyncBandwidthBurst)
 	}
 
 	sf.currentSize = sf.computeDataDirSize()
@@ -104,7 +194,8 @@ func NewStagingFS(config *StagingFSConfig) (*StagingFS, error) {
 	return sf, nil
 }
 
-// NewStagingFSWithPersistence creates a new StagingFS with Badger persistence
+// NewStagingFSWithPersistence creates a new StagingFS persisting its state to config.MetadataStore,
+// or to a Badger database in <LocalRootPath>/meta if not set
 func NewStagingFSWithPersistence(config *StagingFSConfig) (*StagingFS, error) {
 	if config == nil {
 		return nil, errors.New("config is required")
@@ -130,17 +221,29 @@ func NewStagingFSWithPersistence(config *StagingFSConfig) (*StagingFS, error) {
 		return nil, errors.Wrap(err, "failed to create data directory")
 	}
 
-	// Open Badger database
-	opts := badger.DefaultOptions(metaPath)
-	opts.Logger = nil
-	db, err := badger.Open(opts)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to open Badger database")
+	store := config.MetadataStore
+	if store == nil {
+		badgerStore, err := NewBadgerMetadataStore(metaPath, false)
+		if err != nil {
+			return nil, err
+		}
+		store = badgerStore
 	}
 
-	sm := NewStagingStateManagerWithPersistence(db)
+	sm := NewStagingStateManagerWithStore(store)
 	if err := sm.Restore(); err != nil {
-		return nil, errors.Wrap(err, "failed to restore from Badger")
+		store.Close()
+		return nil, errors.Wrap(err, "failed to restore from metadata store")
+	}
+
+	failedItems, err := sm.restoreFailed()
+	if err != nil {
+		store.Close()
+		return nil, errors.Wrap(err, "failed to restore failed items from metadata store")
+	}
+
+	if config.MetadataDurability == DurabilityBatched {
+		sm.EnableBatchedWrites(config.MetadataFlushInterval, config.MetadataMaxBatchSize)
 	}
 
 	maxSize := config.MaxDataSize
@@ -153,12 +256,20 @@ func NewStagingFSWithPersistence(config *StagingFSConfig) (*StagingFS, error) {
 		sm:          sm,
 		client:      config.Client,
 		stopCh:      make(chan struct{}),
+		workerDone:  make(chan struct{}),
 		maxSize:     maxSize,
-		failedItems: make(map[string]*StagingMetadata),
+		failedItems: failedItems,
+		events:      newStagingEventBus(),
+	}
+	sf.syncCtx, sf.cancelSync = context.WithCancel(context.Background())
+
+	if config.SyncBandwidthLimit > 0 {
+		sf.limiter = NewBandwidthLimiter(config.SyncBandwidthLimit, config.SyncBandwidthBurst)
 	}
 
 	sf.currentSize = sf.computeDataDirSize()
 	sf.cleanOrphanFiles()
+	sf.emitRecovered()
 	sf.registerDefaultHandler()
 	sf.startBackgroundWorker()
 
@@ -194,6 +305,7 @@ func (sf *StagingFS) Create(path string) error {
 	}
 	f.Close()
 
+	sf.emitStaged(path)
 	return nil
 }
 
@@ -231,6 +343,7 @@ func (sf *StagingFS) OpenForWrite(path string) (*os.File, error) {
 		return nil, errors.Wrap(err, "failed to open local file for writing")
 	}
 
+	sf.emitStaged(path)
 	return f, nil
 }
 
@@ -267,9 +380,8 @@ func (sf *StagingFS) TruncateFile(path string, size int64) error {
 	sf.addDataSize(size)
 
 	// Update last modified time to reset grace period
-	meta := sf.sm.Get(path)
-	if meta != nil {
-		meta.LastModifiedAt = time.Now()
+	if err := sf.sm.Touch(path); err != nil {
+		return errors.Wrap(err, "failed to update staging metadata for truncate")
 	}
 
 	return nil
@@ -288,7 +400,7 @@ func (sf *StagingFS) OpenForReadWrite(path string) (*os.File, error) {
 			return nil, errors.Wrap(err, "failed to create parent directory")
 		}
 
-		if err := sf.client.DownloadFileParallel(path, localPath, 4, nil); err != nil {
+		if err := sf.client.DownloadFileParallel(path, localPath, 4, sf.transferCallback()); err != nil {
 			return nil, errors.Wrapf(err, "failed to download file from iRODS: %s", path)
 		}
 
@@ -317,6 +429,7 @@ func (sf *StagingFS) OpenForReadWrite(path string) (*os.File, error) {
 		return nil, errors.Wrap(err, "failed to open local file for reading and writing")
 	}
 
+	sf.emitStaged(path)
 	return f, nil
 }
 
@@ -342,6 +455,7 @@ func (sf *StagingFS) Rename(oldPath, newPath string) error {
 		return errors.Wrap(err, "failed to rename local file")
 	}
 
+	sf.emitStaged(newPath)
 	return nil
 }
 
@@ -352,13 +466,16 @@ func (sf *StagingFS) RenameDir(oldPath, newPath string) error {
 		return err
 	}
 
-	if syncNow {
-		return nil
-	}
-
 	oldLocalPath := sf.getLocalDataPath(oldPath)
 	newLocalPath := sf.getLocalDataPath(newPath)
 
+	if syncNow {
+		// The directory was renamed in iRODS; staged data below it must follow
+		if _, err := os.Stat(oldLocalPath); err != nil {
+			return nil
+		}
+	}
+
 	if err := os.MkdirAll(filepath.Dir(newLocalPath), 0755); err != nil {
 		return errors.Wrap(err, "failed to create parent directory")
 	}
@@ -367,6 +484,7 @@ func (sf *StagingFS) RenameDir(oldPath, newPath string) error {
 		return errors.Wrap(err, "failed to rename local directory")
 	}
 
+	sf.emitStaged(newPath)
 	return nil
 }
 
@@ -387,6 +505,7 @@ func (sf *StagingFS) Delete(path string) error {
 		return errors.Wrap(err, "failed to delete local file")
 	}
 
+	sf.emitStaged(path)
 	return nil
 }
 
@@ -402,31 +521,36 @@ func (sf *StagingFS) Mkdir(path string) error {
 		return errors.Wrap(err, "failed to create local directory")
 	}
 
+	sf.emitStaged(path)
 	return nil
 }
 
 // Rmdir removes a directory
 func (sf *StagingFS) Rmdir(path string) error {
-	syncNow, err := sf.sm.Rmdir(path)
-	if err != nil {
+	// If immediate sync occurs, handler is called by StagingStateManager.
+	// Staged entries under the directory are cancelled either way.
+	if _, err := sf.sm.Rmdir(path); err != nil {
 		return err
 	}
 
 	localPath := sf.getLocalDataPath(path)
+	size := sf.computeLocalTreeSize(localPath)
 	if err := os.RemoveAll(localPath); err != nil && !os.IsNotExist(err) {
 		return errors.Wrap(err, "failed to delete local directory")
 	}
-
-	// If immediate sync occurred, handler was already called by StagingStateManager
-	// No additional cleanup needed here
-	_ = syncNow
+	sf.subtractDataSize(size)
 
 	return nil
 }
 
 // SyncAll performs all pending operations
 func (sf *StagingFS) SyncAll() error {
-	if err := sf.sm.SyncAll(); err != nil {
+	return sf.SyncAllCtx(context.Background())
+}
+
+// SyncAllCtx is SyncAll that stops before the next item once ctx is done
+func (sf *StagingFS) SyncAllCtx(ctx context.Context) error {
+	if err := sf.sm.SyncAllCtx(ctx); err != nil {
 		return err
 	}
 
@@ -473,80 +597,227 @@ func (sf *StagingFS) SyncOld(gracePeriod time.Duration) error {
 
 // registerDefaultHandler registers the default iRODS operation handler
 func (sf *StagingFS) registerDefaultHandler() {
-	handler := func(meta *StagingMetadata) error {
-		switch meta.Action {
-		case ActionUpload:
-			// Upload file to iRODS in parallel
-			localPath := sf.getLocalDataPath(meta.Path)
-
-			if err := sf.client.UploadFileParallel(localPath, meta.Path, 4, nil); err != nil {
-				return errors.Wrapf(err, "failed to upload file in iRODS: %s", meta.Path)
-			}
+	sf.sm.RegisterActionHandler(sf.wrapActionHandler(sf.applyAction))
+}
 
-		case ActionRename:
-			// Rename file in iRODS
-			if err := sf.client.RenameFileToFile(meta.OldPath, meta.Path); err != nil {
-				return errors.Wrapf(err, "failed to rename file in iRODS: %s -> %s", meta.OldPath, meta.Path)
+// applyAction performs the iRODS operation for a staged item
+func (sf *StagingFS) applyAction(meta *StagingMetadata) error {
+	switch meta.Action {
+	case ActionUpload:
+		if meta.SymlinkTarget != "" {
+			// Create symlink in iRODS
+			if err := sf.createSymlink(meta); err != nil {
+				return errors.Wrapf(err, "failed to create symlink in iRODS: %s", meta.Path)
 			}
+			break
+		}
 
-		case ActionRenameDir:
-			// Rename directory in iRODS
-			if err := sf.client.RenameDirToDir(meta.OldPath, meta.Path); err != nil {
-				return errors.Wrapf(err, "failed to rename directory in iRODS: %s -> %s", meta.OldPath, meta.Path)
-			}
+		// Upload file to iRODS, resuming from a checkpoint if one exists
+		localPath := sf.getLocalDataPath(meta.Path)
 
-		case ActionDelete:
-			// Delete file from iRODS
-			if err := sf.client.RemoveFile(meta.Path, false); err != nil {
-				return errors.Wrapf(err, "failed to delete file in iRODS: %s", meta.Path)
-			}
+		if err := sf.uploadFile(localPath, meta.Path); err != nil {
+			return errors.Wrapf(err, "failed to upload file in iRODS: %s", meta.Path)
+		}
 
-		case ActionMkdir:
-			// Create directory in iRODS
-			if err := sf.client.MakeDir(meta.Path, true); err != nil {
-				return errors.Wrapf(err, "failed to create directory in iRODS: %s", meta.Path)
-			}
+	case ActionRename:
+		// Rename file in iRODS
+		if err := sf.client.RenameFileToFile(meta.OldPath, meta.Path); err != nil {
+			return errors.Wrapf(err, "failed to rename file in iRODS: %s -> %s", meta.OldPath, meta.Path)
+		}
 
-		case ActionRmdir:
-			// Remove directory from iRODS
-			if err := sf.client.RemoveDir(meta.Path, true, false); err != nil {
-				return errors.Wrapf(err, "failed to remove directory in iRODS: %s", meta.Path)
-			}
+	case ActionRenameDir:
+		// Rename directory in iRODS
+		if err := sf.client.RenameDirToDir(meta.OldPath, meta.Path); err != nil {
+			return errors.Wrapf(err, "failed to rename directory in iRODS: %s -> %s", meta.OldPath, meta.Path)
 		}
 
-		return nil
+	case ActionDelete:
+		// Delete file from iRODS
+		if err := sf.client.RemoveFile(meta.Path, false); err != nil {
+			return errors.Wrapf(err, "failed to delete file in iRODS: %s", meta.Path)
+		}
+
+	case ActionMkdir:
+		// Create directory in iRODS
+		if err := sf.client.MakeDir(meta.Path, true); err != nil {
+			return errors.Wrapf(err, "failed to create directory in iRODS: %s", meta.Path)
+		}
+
+	case ActionRmdir:
+		// Remove directory from iRODS
+		if err := sf.client.RemoveDir(meta.Path, true, false); err != nil {
+			return errors.Wrapf(err, "failed to remove directory in iRODS: %s", meta.Path)
+		}
+	}
+
+	if meta.Action == ActionUpload || meta.Action == ActionMkdir {
+		if err := sf.applyXattrs(meta); err != nil {
+			return errors.Wrapf(err, "failed to set extended attributes in iRODS: %s", meta.Path)
+		}
 	}
 
-	sf.sm.RegisterActionHandler(handler)
+	return nil
 }
 
 // RegisterActionHandler registers a custom handler for iRODS operations
 func (sf *StagingFS) RegisterActionHandler(handler ActionHandler) {
-	sf.sm.RegisterActionHandler(handler)
+	sf.sm.RegisterActionHandler(sf.wrapActionHandler(handler))
 }
 
-// Close syncs all pending data, stops the background worker, and closes the staging filesystem
-func (sf *StagingFS) Close() error {
+// wrapActionHandler wraps a handler to emit sync-started, sync-succeeded and sync-failed events
+func (sf *StagingFS) wrapActionHandler(handler ActionHandler) ActionHandler {
+	return func(meta *StagingMetadata) error {
+		var size int64
+		if meta.Action == ActionUpload {
+			size = max(sf.GetLocalFileSize(meta.Path), 0)
+		}
+
+		sf.events.publish(newStagingEvent(EventSyncStarted, meta, size))
+
+		start := time.Now()
+		err := handler(meta)
+
+		var event *StagingEvent
+		if err != nil {
+			event = newStagingEvent(EventSyncFailed, meta, size)
+			event.Err = err
+		} else {
+			event = newStagingEvent(EventSyncSucceeded, meta, size)
+		}
+		event.Duration = time.Since(start)
+		sf.events.publish(event)
+
+		return err
+	}
+}
+
+// Close stops the background worker, syncs as many pending items as possible before ctx is done,
+// and closes the staging filesystem. The staging directory is removed only when everything was synced;
+// otherwise the data and metadata of unsynced items are kept so that NewStagingFSWithPersistence can
+// resume them, and a *PendingSyncError listing them is returned. Without persistence, the error
+// reports the items as lost.
+func (sf *StagingFS) Close(ctx context.Context) error {
+	// Uploads in progress in the worker or in syncPending are abandoned once ctx is done, see interruptible
+	stopCancel := context.AfterFunc(ctx, sf.cancelSync)
+	defer stopCancel()
+	defer sf.cancelSync()
+
 	sf.stopOnce.Do(func() {
 		close(sf.stopCh)
 	})
+	<-sf.workerDone
 
-	if err := sf.SyncAll(); err != nil {
-		log.Warnf("failed to sync all staged data on close: %v", err)
-	}
+	defer sf.events.close()
+
+	syncErrs := sf.syncPending(ctx)
+
+	pending := sf.sm.GetAll()
+	failed := sf.GetFailedItems()
 
-	if sf.sm.db != nil {
-		if err := sf.sm.db.Close(); err != nil {
+	if len(pending) == 0 && len(failed) == 0 {
+		if err := sf.sm.closeStore(); err != nil {
 			return err
 		}
+
+		// Remove staging directory after successful sync and DB close
+		if sf.config.LocalRootPath != "" {
+			os.RemoveAll(sf.config.LocalRootPath)
+		}
+		return nil
 	}
 
-	// Remove staging directory after successful sync and DB close
-	if sf.config.LocalRootPath != "" {
-		os.RemoveAll(sf.config.LocalRootPath)
+	pendingErr := &PendingSyncError{
+		Errors: syncErrs,
+		Lost:   sf.sm.store == nil,
 	}
+	for _, meta := range pending {
+		pendingErr.Pending = append(pendingErr.Pending, meta)
+	}
+	for _, meta := range failed {
+		pendingErr.Failed = append(pendingErr.Failed, meta)
+	}
+	sortMetadataByPath(pendingErr.Pending)
+	sortMetadataByPath(pendingErr.Failed)
 
-	return nil
+	if sf.sm.store != nil {
+		// Stage failed items again so they are retried on next mount
+		for _, meta := range pendingErr.Failed {
+			restored := *meta
+			restored.SyncFailCount = 0
+			if err := sf.sm.persistMetadataPublic(restored.Path, &restored); err != nil {
+				pendingErr.Errors = append(pendingErr.Errors, errors.Wrapf(err, "failed to persist failed item %s", meta.Path))
+				continue
+			}
+			if err := sf.sm.deleteFailed(meta.Path); err != nil {
+				pendingErr.Errors = append(pendingErr.Errors, errors.Wrapf(err, "failed to remove failed item record %s", meta.Path))
+			}
+		}
+
+		if err := sf.sm.closeStore(); err != nil {
+			pendingErr.Errors = append(pendingErr.Errors, errors.Wrap(err, "failed to close metadata store"))
+		}
+	}
+
+	log.Warnf("closing staging with unsynced data: %v", pendingErr)
+	return pendingErr
+}
+
+// interruptible runs a network call of a sync, returning early once Close gives up on syncing. An
+// abandoned call keeps running in the background and its result is ignored; the item stays pending.
+func (sf *StagingFS) interruptible(call func() error) error {
+	if err := sf.syncCtx.Err(); err != nil {
+		return errors.Wrap(err, "sync interrupted")
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- call()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-sf.syncCtx.Done():
+		return errors.Wrap(sf.syncCtx.Err(), "sync interrupted")
+	}
+}
+
+// syncPending syncs all pending items in path order until ctx is done, returning the errors encountered
+func (sf *StagingFS) syncPending(ctx context.Context) []error {
+	var errs []error
+
+	metas := make([]*StagingMetadata, 0)
+	for _, meta := range sf.sm.GetAll() {
+		metas = append(metas, meta)
+	}
+	// Parents sort before their children, so directories are created before files in them
+	sortMetadataByPath(metas)
+
+	for _, meta := range metas {
+		if err := ctx.Err(); err != nil {
+			errs = append(errs, err)
+			break
+		}
+
+		synced, err := sf.sm.syncOne(meta)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		if synced {
+			sf.removeSyncedLocalFile(meta.Path)
+		}
+	}
+
+	return errs
+}
+
+// sortMetadataByPath sorts metadata in place by path
+func sortMetadataByPath(metas []*StagingMetadata) {
+	sort.Slice(metas, func(i, j int) bool {
+		return metas[i].Path < metas[j].Path
+	})
 }
 
 // startBackgroundWorker launches a goroutine that periodically syncs old items
@@ -562,6 +833,8 @@ func (sf *StagingFS) startBackgroundWorker() {
 	}
 
 	go func() {
+		defer close(sf.workerDone)
+
 		ticker := time.NewTicker(syncInterval)
 		defer ticker.Stop()
 
@@ -571,46 +844,99 @@ func (sf *StagingFS) startBackgroundWorker() {
 				return
 			case <-ticker.C:
 				sf.syncOldItems(gracePeriod)
+				sf.cleanupAbandonedTemps()
 			}
 		}
 	}()
 }
 
-// syncOldItems syncs items individually, reporting errors via callback without stopping
+// syncOldItems syncs items individually, reporting errors via callback without stopping.
+// The eligible items are ranked once per pass; items staged during the pass, such as during a long
+// upload, are ranked against the remaining ones on the next pass.
 func (sf *StagingFS) syncOldItems(gracePeriod time.Duration) {
-	now := time.Now()
-	all := sf.sm.GetAll()
+	cutoff := time.Now().Add(-gracePeriod)
 
-	for _, meta := range all {
-		if now.Sub(meta.LastModifiedAt) < gracePeriod {
+	for _, candidate := range sf.syncCandidates(cutoff) {
+		select {
+		case <-sf.stopCh:
+			return
+		default:
+		}
+
+		// Earlier syncs of this pass may have removed or changed the item
+		meta := sf.sm.Get(candidate.Path)
+		if meta == nil || meta.LastModifiedAt.After(cutoff) {
 			continue
 		}
 
-		if err := sf.sm.syncOne(meta); err != nil {
-			meta.SyncFailCount++
+		synced, err := sf.sm.syncOne(meta)
+		if err != nil {
+			updated, failed, recordErr := sf.sm.recordSyncFailure(meta.Path, MaxSyncFailCount)
+			if updated == nil {
+				// No longer staged
+				continue
+			}
+			meta = updated
+
 			log.Warnf("background sync failed for %s (%s), attempt %d: %v", meta.Path, meta.Action, meta.SyncFailCount, err)
+			if recordErr != nil {
+				log.Warnf("failed to record sync failure of %s: %v", meta.Path, recordErr)
+			}
 
 			if sf.config.OnSyncError != nil {
 				sf.config.OnSyncError(meta, err)
 			}
 
-			if meta.SyncFailCount >= MaxSyncFailCount {
+			if failed {
 				sf.failedMutex.Lock()
 				sf.failedItems[meta.Path] = meta
 				sf.failedMutex.Unlock()
 
-				sf.sm.deleteMetadataPublic(meta.Path)
+				event := newStagingEvent(EventMovedToFailed, meta, max(sf.GetLocalFileSize(meta.Path), 0))
+				event.Err = err
+				sf.events.publish(event)
 			}
 			continue
 		}
 
 		// Clean up local file after successful sync
-		localPath := sf.getLocalDataPath(meta.Path)
-		if info, err := os.Stat(localPath); err == nil {
-			sf.subtractDataSize(info.Size())
+		if synced {
+			sf.removeSyncedLocalFile(meta.Path)
+		}
+	}
+}
+
+// syncCandidates returns the items last modified before cutoff and allowed by the sync window,
+// highest ranked first. A sync pass ranks its items once; items staged meanwhile wait for the next pass.
+func (sf *StagingFS) syncCandidates(cutoff time.Time) []*StagingMetadata {
+	now := time.Now()
+
+	candidates := []*syncCandidate{}
+	for _, meta := range sf.sm.GetModifiedBefore(cutoff) {
+		// Large files wait for a sync window, small files go immediately
+		if sf.waitsForSyncWindow(meta, now) {
+			continue
 		}
-		os.Remove(localPath)
+
+		candidates = append(candidates, sf.newSyncCandidate(meta, now))
+	}
+
+	sortSyncCandidates(candidates)
+
+	items := make([]*StagingMetadata, len(candidates))
+	for i, candidate := range candidates {
+		items[i] = candidate.meta
+	}
+	return items
+}
+
+// removeSyncedLocalFile removes the local data of a synced item and updates the tracked data size
+func (sf *StagingFS) removeSyncedLocalFile(path string) {
+	localPath := sf.getLocalDataPath(path)
+	if info, err := os.Stat(localPath); err == nil {
+		sf.subtractDataSize(info.Size())
 	}
+	os.Remove(localPath)
 }
 
 // GetLocalDataPath returns the local file path for an iRODS path (exported for external use)
@@ -631,13 +957,7 @@ func (sf *StagingFS) GetLocalFileSize(path string) int64 {
 // IsRenamedFrom checks if the given path was renamed away by any staging entry.
 // Returns true if some entry has OldPath == path (meaning this path no longer exists).
 func (sf *StagingFS) IsRenamedFrom(path string) bool {
-	all := sf.sm.GetAll()
-	for _, meta := range all {
-		if meta.OldPath == path && (meta.Action == ActionRename || meta.Action == ActionRenameDir) {
-			return true
-		}
-	}
-	return false
+	return sf.sm.IsRenamedFrom(path)
 }
 
 // Get retrieves metadata for a path
@@ -645,6 +965,16 @@ func (sf *StagingFS) Get(path string) *StagingMetadata {
 	return sf.sm.Get(path)
 }
 
+// GetChildren retrieves staged metadata of the entries directly under dir, sorted by path
+func (sf *StagingFS) GetChildren(dir string) []*StagingMetadata {
+	return sf.sm.GetChildren(dir)
+}
+
+// GetRenamedAway retrieves the paths directly under dir that pending renames moved away
+func (sf *StagingFS) GetRenamedAway(dir string) []string {
+	return sf.sm.GetRenamedAway(dir)
+}
+
 // GetAll retrieves all staged metadata
 func (sf *StagingFS) GetAll() map[string]*StagingMetadata {
 	return sf.sm.GetAll()
@@ -662,10 +992,50 @@ func (sf *StagingFS) GetFailedItems() map[string]*StagingMetadata {
 	return result
 }
 
+// Subscribe registers a new event subscriber with the given channel buffer size
+// (DefaultEventBufferSize if <= 0). Items recovered on startup are replayed to every new subscriber.
+func (sf *StagingFS) Subscribe(bufferSize int) *StagingEventSubscription {
+	return sf.events.subscribe(bufferSize)
+}
+
+// emitStaged publishes a staged event for the current metadata of the path, if any
+func (sf *StagingFS) emitStaged(path string) {
+	meta := sf.sm.Get(path)
+	if meta == nil {
+		return
+	}
+
+	var size int64
+	if meta.Action == ActionUpload {
+		size = max(sf.GetLocalFileSize(path), 0)
+	}
+	sf.events.publish(newStagingEvent(EventStaged, meta, size))
+}
+
+// emitRecovered records recovered-on-startup events for all restored items
+func (sf *StagingFS) emitRecovered() {
+	for path, meta := range sf.sm.GetAll() {
+		var size int64
+		if meta.Action == ActionUpload {
+			size = max(sf.GetLocalFileSize(path), 0)
+		}
+		sf.events.addReplay(newStagingEvent(EventRecoveredOnStartup, meta, size))
+	}
+}
+
 // ClearFailedItems removes all failed items
 func (sf *StagingFS) ClearFailedItems() {
 	sf.failedMutex.Lock()
 	defer sf.failedMutex.Unlock()
+
+	paths := make([]string, 0, len(sf.failedItems))
+	for path := range sf.failedItems {
+		paths = append(paths, path)
+	}
+	if err := sf.sm.deleteFailed(paths...); err != nil {
+		log.Warnf("failed to remove failed items from metadata store: %v", err)
+	}
+
 	sf.failedItems = make(map[string]*StagingMetadata)
 }
 
@@ -711,11 +1081,13 @@ func (sf *StagingFS) GetAvailableDataSize() int64 {
 // checkQuota checks if adding size bytes would exceed the quota
 func (sf *StagingFS) checkQuota(size int64) error {
 	sf.sizeMutex.Lock()
-	defer sf.sizeMutex.Unlock()
+	current := sf.currentSize
+	sf.sizeMutex.Unlock()
 
-	if sf.currentSize+size > sf.maxSize {
-		return errors.Errorf("staging quota exceeded: current %d + requested %d > max %d",
-			sf.currentSize, size, sf.maxSize)
+	if current+size > sf.maxSize {
+		sf.events.publish(newStagingEvent(EventQuotaPressure, nil, current))
+		return errors.Wrapf(ErrQuotaExceeded, "current %d + requested %d > max %d",
+			current, size, sf.maxSize)
 	}
 	return nil
 }
@@ -723,8 +1095,16 @@ func (sf *StagingFS) checkQuota(size int64) error {
 // addDataSize adds to the tracked data size
 func (sf *StagingFS) addDataSize(size int64) {
 	sf.sizeMutex.Lock()
-	defer sf.sizeMutex.Unlock()
+	before := sf.currentSize
 	sf.currentSize += size
+	after := sf.currentSize
+	sf.sizeMutex.Unlock()
+
+	// Emit once when usage crosses the pressure threshold
+	threshold := int64(float64(sf.maxSize) * QuotaPressureRatio)
+	if before < threshold && after >= threshold {
+		sf.events.publish(newStagingEvent(EventQuotaPressure, nil, after))
+	}
 }
 
 // subtractDataSize subtracts from the tracked data size
@@ -739,9 +1119,13 @@ func (sf *StagingFS) subtractDataSize(size int64) {
 
 // computeDataDirSize walks the data directory and sums file sizes
 func (sf *StagingFS) computeDataDirSize() int64 {
-	dataPath := filepath.Join(sf.config.LocalRootPath, "data")
+	return sf.computeLocalTreeSize(filepath.Join(sf.config.LocalRootPath, "data"))
+}
+
+// computeLocalTreeSize walks a local directory and sums file sizes
+func (sf *StagingFS) computeLocalTreeSize(localPath string) int64 {
 	var total int64
-	filepath.Walk(dataPath, func(_ string, info os.FileInfo, err error) error {
+	filepath.Walk(localPath, func(_ string, info os.FileInfo, err error) error {
 		if err != nil {
 			return nil
 		}
@@ -755,9 +1139,13 @@ func (sf *StagingFS) computeDataDirSize() int64 {
 
 // cleanOrphanFiles removes data files that have no corresponding metadata entry.
 // These are leftover from incomplete downloads that crashed before metadata was written.
+// Data of failed items is kept for inspection and replay.
 func (sf *StagingFS) cleanOrphanFiles() {
 	dataPath := filepath.Join(sf.config.LocalRootPath, "data")
 	metadata := sf.sm.GetAll()
+	for path, meta := range sf.GetFailedItems() {
+		metadata[path] = meta
+	}
 
 	filepath.Walk(dataPath, func(filePath string, info os.FileInfo, err error) error {
 		if err != nil || info.IsDir() {
@@ -1,10 +1,12 @@
 package stagingfs
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 	"testing"
 
+	"github.com/cockroachdb/errors"
 	irodsclient_common "github.com/cyverse/go-irodsclient/irods/common"
 )
 
@@ -482,3 +484,258 @@ func TestStagingFSRenameDirExisting(t *testing.T) {
 		t.Errorf("Expected metadata to be removed after immediate sync")
 	}
 }
+
+func TestStagingFSCloseRemovesRootWhenSynced(t *testing.T) {
+	tmpDir := filepath.Join(t.TempDir(), "staging")
+	config := &StagingFSConfig{
+		LocalRootPath: tmpDir,
+		Client:        &MockStagingClient{},
+	}
+
+	sf, err := NewStagingFSWithPersistence(config)
+	if err != nil {
+		t.Fatalf("Failed to create StagingFS: %v", err)
+	}
+
+	if err := sf.Create("/synced.txt"); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	if err := sf.Close(context.Background()); err != nil {
+		t.Fatalf("Expected clean close, got %v", err)
+	}
+
+	if _, err := os.Stat(tmpDir); !os.IsNotExist(err) {
+		t.Errorf("Expected staging root to be removed after full sync")
+	}
+}
+
+func TestStagingFSCloseKeepsUnsyncedData(t *testing.T) {
+	tmpDir := t.TempDir()
+	config := &StagingFSConfig{
+		LocalRootPath: tmpDir,
+		Client:        &MockStagingClient{},
+	}
+
+	sf, err := NewStagingFSWithPersistence(config)
+	if err != nil {
+		t.Fatalf("Failed to create StagingFS: %v", err)
+	}
+
+	f, err := sf.OpenForWrite("/pending.txt")
+	if err != nil {
+		t.Fatalf("Failed to open file for writing: %v", err)
+	}
+	f.Write([]byte("unsynced"))
+	f.Close()
+
+	sf.RegisterActionHandler(func(meta *StagingMetadata) error {
+		return errors.New("iRODS unavailable")
+	})
+
+	err = sf.Close(context.Background())
+	var pendingErr *PendingSyncError
+	if !errors.As(err, &pendingErr) {
+		t.Fatalf("Expected PendingSyncError, got %v", err)
+	}
+	if len(pendingErr.Pending) != 1 || pendingErr.Pending[0].Path != "/pending.txt" {
+		t.Errorf("Expected /pending.txt to be pending, got %v", pendingErr.Pending)
+	}
+
+	// Reopen and verify the item is resumed with its data
+	sf2, err := NewStagingFSWithPersistence(config)
+	if err != nil {
+		t.Fatalf("Failed to reopen StagingFS: %v", err)
+	}
+	defer sf2.Close(context.Background())
+
+	if meta := sf2.Get("/pending.txt"); meta == nil || meta.Action != ActionUpload {
+		t.Errorf("Expected pending upload to be restored, got %v", meta)
+	}
+
+	data, err := os.ReadFile(sf2.GetLocalDataPath("/pending.txt"))
+	if err != nil {
+		t.Fatalf("Expected local data to be kept: %v", err)
+	}
+	if string(data) != "unsynced" {
+		t.Errorf("Expected unsynced, got %s", data)
+	}
+}
+
+func TestStagingFSCloseRespectsContext(t *testing.T) {
+	tmpDir := t.TempDir()
+	config := &StagingFSConfig{
+		LocalRootPath: tmpDir,
+		Client:        &MockStagingClient{},
+	}
+
+	sf, err := NewStagingFSWithPersistence(config)
+	if err != nil {
+		t.Fatalf("Failed to create StagingFS: %v", err)
+	}
+
+	if err := sf.Create("/a.txt"); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	if err := sf.Create("/b.txt"); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err = sf.Close(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+
+	var pendingErr *PendingSyncError
+	if !errors.As(err, &pendingErr) {
+		t.Fatalf("Expected PendingSyncError, got %v", err)
+	}
+	if len(pendingErr.Pending) != 2 {
+		t.Errorf("Expected 2 pending items, got %d", len(pendingErr.Pending))
+	}
+}
+
+// blockingUploadClient is a MockStagingClient whose uploads block until released
+type blockingUploadClient struct {
+	MockStagingClient
+
+	started chan struct{}
+	release chan struct{}
+}
+
+func (m *blockingUploadClient) UploadFileParallel(localPath string, irodsPath string, taskNum int, transferCallback irodsclient_common.TransferTrackerCallback) error {
+	close(m.started)
+	<-m.release
+	return nil
+}
+
+func TestStagingFSCloseInterruptsUpload(t *testing.T) {
+	tmpDir := t.TempDir()
+	client := &blockingUploadClient{
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	defer close(client.release)
+
+	config := &StagingFSConfig{
+		LocalRootPath: tmpDir,
+		Client:        client,
+	}
+
+	sf, err := NewStagingFSWithPersistence(config)
+	if err != nil {
+		t.Fatalf("Failed to create StagingFS: %v", err)
+	}
+
+	f, err := sf.OpenForWrite("/big.dat")
+	if err != nil {
+		t.Fatalf("Failed to open file for writing: %v", err)
+	}
+	f.Write([]byte("data"))
+	f.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	closeDone := make(chan error)
+	go func() {
+		closeDone <- sf.Close(ctx)
+	}()
+
+	// Give up while the upload is in progress: Close returns without waiting for it
+	<-client.started
+	cancel()
+	err = <-closeDone
+
+	var pendingErr *PendingSyncError
+	if !errors.As(err, &pendingErr) {
+		t.Fatalf("Expected PendingSyncError, got %v", err)
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected close to report the cancellation, got %v", err)
+	}
+	if len(pendingErr.Pending) != 1 || pendingErr.Pending[0].Path != "/big.dat" {
+		t.Errorf("Expected /big.dat to be pending, got %v", pendingErr.Pending)
+	}
+	if pendingErr.Lost {
+		t.Errorf("Expected persisted items not to be reported as lost")
+	}
+}
+
+func TestStagingFSCloseReportsLostDataWithoutPersistence(t *testing.T) {
+	tmpDir := t.TempDir()
+	config := &StagingFSConfig{
+		LocalRootPath: tmpDir,
+		Client:        &MockStagingClient{},
+	}
+
+	sf, err := NewStagingFS(config)
+	if err != nil {
+		t.Fatalf("Failed to create StagingFS: %v", err)
+	}
+
+	sf.RegisterActionHandler(func(meta *StagingMetadata) error {
+		return errors.New("iRODS unavailable")
+	})
+
+	if err := sf.Create("/pending.txt"); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	err = sf.Close(context.Background())
+	var pendingErr *PendingSyncError
+	if !errors.As(err, &pendingErr) {
+		t.Fatalf("Expected PendingSyncError, got %v", err)
+	}
+	if !pendingErr.Lost {
+		t.Errorf("Expected memory-only items to be reported as lost, got %v", err)
+	}
+}
+
+func TestStagingFSCloseRequeuesFailedItems(t *testing.T) {
+	tmpDir := t.TempDir()
+	config := &StagingFSConfig{
+		LocalRootPath: tmpDir,
+		Client:        &MockStagingClient{},
+	}
+
+	sf, err := NewStagingFSWithPersistence(config)
+	if err != nil {
+		t.Fatalf("Failed to create StagingFS: %v", err)
+	}
+
+	sf.RegisterActionHandler(func(meta *StagingMetadata) error {
+		return errors.New("iRODS unavailable")
+	})
+
+	if err := sf.Create("/failed.txt"); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	for i := 0; i < MaxSyncFailCount; i++ {
+		sf.syncOldItems(0)
+	}
+	if len(sf.GetFailedItems()) != 1 {
+		t.Fatalf("Expected 1 failed item")
+	}
+
+	err = sf.Close(context.Background())
+	var pendingErr *PendingSyncError
+	if !errors.As(err, &pendingErr) || len(pendingErr.Failed) != 1 {
+		t.Fatalf("Expected PendingSyncError with 1 failed item, got %v", err)
+	}
+
+	sf2, err := NewStagingFSWithPersistence(config)
+	if err != nil {
+		t.Fatalf("Failed to reopen StagingFS: %v", err)
+	}
+	defer sf2.Close(context.Background())
+
+	meta := sf2.Get("/failed.txt")
+	if meta == nil {
+		t.Fatalf("Expected failed item to be restored for retry")
+	}
+	if meta.SyncFailCount != 0 {
+		t.Errorf("Expected SyncFailCount reset, got %d", meta.SyncFailCount)
+	}
+}
@@ -0,0 +1,185 @@
+package stagingfs
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+// LinkStagingClient is implemented by clients that can create symlinks and set extended attributes.
+// StagingFS uses it to sync staged symlinks and extended attributes; without it syncing them fails.
+type LinkStagingClient interface {
+	StagingClient
+	Symlink(target string, linkPath string) error
+	SetXattr(path string, name string, value []byte) error
+	RemoveXattr(path string, name string) error
+}
+
+// Symlink marks a path as a newly created symlink to target
+func (sm *StagingStateManager) Symlink(path string, target string) error {
+	// Wait for path to be unlocked if it's locked during sync
+	shard := sm.lockShared(path)
+	defer sm.unlockShared(shard)
+
+	now := time.Now()
+	meta := &StagingMetadata{
+		Path:           path,
+		Action:         ActionUpload,
+		IsNew:          true,
+		CreatedAt:      now,
+		LastModifiedAt: now,
+		SymlinkTarget:  target,
+	}
+	return sm.persistMetadata(path, meta)
+}
+
+// SetXattr records an extended attribute of a staged file or directory
+func (sm *StagingStateManager) SetXattr(path string, name string, value []byte) error {
+	if value == nil {
+		value = []byte{}
+	}
+	return sm.updateXattrs(path, func(xattrs map[string][]byte, isNew bool) {
+		xattrs[name] = value
+	})
+}
+
+// RemoveXattr records the removal of an extended attribute of a staged file or directory
+func (sm *StagingStateManager) RemoveXattr(path string, name string) error {
+	return sm.updateXattrs(path, func(xattrs map[string][]byte, isNew bool) {
+		if isNew {
+			// Nothing to remove in iRODS
+			delete(xattrs, name)
+			return
+		}
+		xattrs[name] = nil
+	})
+}
+
+// updateXattrs applies update to a copy of the extended attributes of a pending upload or mkdir.
+// Returns ErrNotStaged if nothing is staged at path.
+func (sm *StagingStateManager) updateXattrs(path string, update func(xattrs map[string][]byte, isNew bool)) error {
+	// Wait for path to be unlocked if it's locked during sync
+	shard := sm.lockShared(path)
+	defer sm.unlockShared(shard)
+
+	meta := sm.getMetadata(path)
+	if meta == nil {
+		return errors.Wrapf(ErrNotStaged, "cannot set extended attributes of %s", path)
+	}
+	if meta.Action != ActionUpload && meta.Action != ActionMkdir {
+		return errors.Wrapf(ErrInvalidTransition, "cannot set extended attributes of %s: %s pending", path, meta.Action)
+	}
+
+	// Readers may hold the current metadata, replace it instead of changing it in place
+	copied := *meta
+	copied.Xattrs = make(map[string][]byte, len(meta.Xattrs)+1)
+	for k, v := range meta.Xattrs {
+		copied.Xattrs[k] = v
+	}
+	update(copied.Xattrs, meta.IsNew)
+	if len(copied.Xattrs) == 0 {
+		copied.Xattrs = nil
+	}
+	copied.LastModifiedAt = time.Now()
+
+	return sm.persistMetadata(path, &copied)
+}
+
+// Symlink creates a symlink to target. The local data file holds the target, like the object in iRODS.
+func (sf *StagingFS) Symlink(path string, target string) error {
+	sf.sm.WaitForSync(path)
+
+	if err := sf.checkQuota(int64(len(target))); err != nil {
+		return err
+	}
+
+	if err := sf.sm.Symlink(path, target); err != nil {
+		return err
+	}
+
+	localPath := sf.getLocalDataPath(path)
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return errors.Wrap(err, "failed to create parent directory")
+	}
+
+	// Replaces a file staged at the same path
+	if info, err := os.Stat(localPath); err == nil {
+		sf.subtractDataSize(info.Size())
+	}
+
+	if err := os.WriteFile(localPath, []byte(target), 0644); err != nil {
+		sf.sm.Delete(path) // Cleanup on error
+		return errors.Wrap(err, "failed to create local symlink file")
+	}
+	sf.addDataSize(int64(len(target)))
+
+	sf.emitStaged(path)
+	return nil
+}
+
+// SetXattr sets an extended attribute of a staged file or directory, applied after it is synced.
+// Returns ErrNotStaged if nothing is staged at path.
+func (sf *StagingFS) SetXattr(path string, name string, value []byte) error {
+	if err := sf.sm.SetXattr(path, name, value); err != nil {
+		return err
+	}
+
+	sf.emitStaged(path)
+	return nil
+}
+
+// RemoveXattr removes an extended attribute of a staged file or directory, applied after it is synced.
+// Returns ErrNotStaged if nothing is staged at path.
+func (sf *StagingFS) RemoveXattr(path string, name string) error {
+	if err := sf.sm.RemoveXattr(path, name); err != nil {
+		return err
+	}
+
+	sf.emitStaged(path)
+	return nil
+}
+
+// createSymlink creates a staged symlink in iRODS
+func (sf *StagingFS) createSymlink(meta *StagingMetadata) error {
+	linkClient, ok := sf.client.(LinkStagingClient)
+	if !ok {
+		return errors.New("staging client does not support symlinks")
+	}
+	return linkClient.Symlink(meta.SymlinkTarget, meta.Path)
+}
+
+// applyXattrs sets and removes the staged extended attributes of a synced item in iRODS
+func (sf *StagingFS) applyXattrs(meta *StagingMetadata) error {
+	if len(meta.Xattrs) == 0 {
+		return nil
+	}
+
+	linkClient, ok := sf.client.(LinkStagingClient)
+	if !ok {
+		return errors.New("staging client does not support extended attributes")
+	}
+
+	names := make([]string, 0, len(meta.Xattrs))
+	for name := range meta.Xattrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value := meta.Xattrs[name]
+		if value == nil {
+			if err := linkClient.RemoveXattr(meta.Path, name); err != nil {
+				return errors.Wrapf(err, "failed to remove %s", name)
+			}
+			continue
+		}
+
+		if err := linkClient.SetXattr(meta.Path, name, value); err != nil {
+			return errors.Wrapf(err, "failed to set %s", name)
+		}
+	}
+	return nil
+}
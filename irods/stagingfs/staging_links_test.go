@@ -0,0 +1,227 @@
+package stagingfs
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+)
+
+// MockLinkClient records symlinks and extended attributes like iRODS AVUs
+type MockLinkClient struct {
+	*MockResumableClient
+
+	links  map[string]string
+	xattrs map[string]map[string]string
+	calls  []string
+}
+
+func newMockLinkClient() *MockLinkClient {
+	return &MockLinkClient{
+		MockResumableClient: newMockResumableClient(),
+		links:               map[string]string{},
+		xattrs:              map[string]map[string]string{},
+	}
+}
+
+func (m *MockLinkClient) Symlink(target string, linkPath string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls = append(m.calls, "symlink "+linkPath)
+	m.links[linkPath] = target
+	m.objects[linkPath] = []byte(target)
+	return nil
+}
+
+func (m *MockLinkClient) SetXattr(path string, name string, value []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls = append(m.calls, "setxattr "+path+" "+name)
+	if m.xattrs[path] == nil {
+		m.xattrs[path] = map[string]string{}
+	}
+	m.xattrs[path][name] = string(value)
+	return nil
+}
+
+func (m *MockLinkClient) RemoveXattr(path string, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls = append(m.calls, "removexattr "+path+" "+name)
+	delete(m.xattrs[path], name)
+	return nil
+}
+
+func newLinkTestFS(t *testing.T, client StagingClient) *StagingFS {
+	sf, err := NewStagingFS(&StagingFSConfig{
+		LocalRootPath: t.TempDir(),
+		Client:        client,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create StagingFS: %v", err)
+	}
+	t.Cleanup(func() { sf.Close(context.Background()) })
+	return sf
+}
+
+func TestStagingFSSymlink(t *testing.T) {
+	client := newMockLinkClient()
+	sf := newLinkTestFS(t, client)
+
+	if err := sf.Symlink("/link", "../target.txt"); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+
+	meta := sf.Get("/link")
+	if meta == nil || meta.Action != ActionUpload || !meta.IsNew || meta.SymlinkTarget != "../target.txt" {
+		t.Fatalf("Expected a new staged symlink, got %+v", meta)
+	}
+
+	data, err := os.ReadFile(sf.GetLocalDataPath("/link"))
+	if err != nil || string(data) != "../target.txt" {
+		t.Errorf("Expected local file to hold the target, got %q (%v)", data, err)
+	}
+	if sf.GetCurrentDataSize() != int64(len("../target.txt")) {
+		t.Errorf("Expected data size %d, got %d", len("../target.txt"), sf.GetCurrentDataSize())
+	}
+
+	// Renamed before sync: the symlink moves with its target
+	if err := sf.Rename("/link", "/moved"); err != nil {
+		t.Fatalf("Failed to rename symlink: %v", err)
+	}
+
+	if err := sf.SyncAll(); err != nil {
+		t.Fatalf("Failed to sync: %v", err)
+	}
+
+	if client.links["/moved"] != "../target.txt" {
+		t.Errorf("Expected symlink /moved in iRODS, got %v", client.links)
+	}
+	if client.fullUploads != 0 {
+		t.Errorf("Expected symlink to be created without an upload, got %d uploads", client.fullUploads)
+	}
+}
+
+func TestStagingFSSymlinkWithoutLinkClient(t *testing.T) {
+	sf := newLinkTestFS(t, &MockStagingClient{})
+
+	if err := sf.Symlink("/link", "/target"); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+
+	err := sf.SyncAll()
+	if err == nil || !strings.Contains(err.Error(), "does not support symlinks") {
+		t.Errorf("Expected sync to fail without symlink support, got %v", err)
+	}
+}
+
+func TestStagingFSXattrs(t *testing.T) {
+	client := newMockLinkClient()
+	sf := newLinkTestFS(t, client)
+
+	if err := sf.Mkdir("/dir"); err != nil {
+		t.Fatalf("Failed to mkdir: %v", err)
+	}
+	if err := sf.SetXattr("/dir", "user.dir", []byte("d")); err != nil {
+		t.Fatalf("Failed to set xattr: %v", err)
+	}
+
+	if err := sf.Create("/dir/new.txt"); err != nil {
+		t.Fatalf("Failed to create: %v", err)
+	}
+	if err := sf.SetXattr("/dir/new.txt", "user.a", []byte("1")); err != nil {
+		t.Fatalf("Failed to set xattr: %v", err)
+	}
+	if err := sf.SetXattr("/dir/new.txt", "user.b", nil); err != nil {
+		t.Fatalf("Failed to set xattr: %v", err)
+	}
+	if err := sf.RemoveXattr("/dir/new.txt", "user.a"); err != nil {
+		t.Fatalf("Failed to remove xattr: %v", err)
+	}
+
+	// A new file has nothing to remove in iRODS, an empty value is still set
+	xattrs := sf.Get("/dir/new.txt").Xattrs
+	if len(xattrs) != 1 || xattrs["user.b"] == nil || len(xattrs["user.b"]) != 0 {
+		t.Errorf("Expected only an empty user.b, got %v", xattrs)
+	}
+
+	// An existing file records the removal
+	if err := sf.sm.Modify("/old.txt"); err != nil {
+		t.Fatalf("Failed to modify: %v", err)
+	}
+	if err := sf.RemoveXattr("/old.txt", "user.gone"); err != nil {
+		t.Fatalf("Failed to remove xattr: %v", err)
+	}
+	if value, ok := sf.Get("/old.txt").Xattrs["user.gone"]; !ok || value != nil {
+		t.Errorf("Expected removal of user.gone to be recorded, got %v", sf.Get("/old.txt").Xattrs)
+	}
+
+	if err := sf.SetXattr("/unstaged.txt", "user.a", []byte("1")); !errors.Is(err, ErrNotStaged) {
+		t.Errorf("Expected ErrNotStaged, got %v", err)
+	}
+	if err := sf.Delete("/old.txt"); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+	if err := sf.SetXattr("/old.txt", "user.a", []byte("1")); !errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("Expected ErrInvalidTransition on a pending delete, got %v", err)
+	}
+
+	if err := sf.SyncAll(); err != nil {
+		t.Fatalf("Failed to sync: %v", err)
+	}
+
+	if client.xattrs["/dir"]["user.dir"] != "d" {
+		t.Errorf("Expected user.dir on /dir, got %v", client.xattrs["/dir"])
+	}
+	if value, ok := client.xattrs["/dir/new.txt"]["user.b"]; !ok || value != "" || len(client.xattrs["/dir/new.txt"]) != 1 {
+		t.Errorf("Expected only user.b on /dir/new.txt, got %v", client.xattrs["/dir/new.txt"])
+	}
+	for _, call := range client.calls {
+		if strings.HasPrefix(call, "removexattr") {
+			t.Errorf("Expected no removal for the deleted file, got %s", call)
+		}
+	}
+}
+
+func TestStagingFSXattrsPersisted(t *testing.T) {
+	config := &StagingFSConfig{
+		LocalRootPath: t.TempDir(),
+		Client:        newMockLinkClient(),
+	}
+
+	sf, err := NewStagingFSWithPersistence(config)
+	if err != nil {
+		t.Fatalf("Failed to create StagingFS: %v", err)
+	}
+
+	if err := sf.Symlink("/link", "/target"); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+	if err := sf.SetXattr("/link", "user.a", []byte{0, 1, 2}); err != nil {
+		t.Fatalf("Failed to set xattr: %v", err)
+	}
+	if err := sf.sm.Modify("/old.txt"); err != nil {
+		t.Fatalf("Failed to modify: %v", err)
+	}
+	if err := sf.RemoveXattr("/old.txt", "user.gone"); err != nil {
+		t.Fatalf("Failed to remove xattr: %v", err)
+	}
+
+	stopWithoutSync(t, sf)
+
+	sf2, err := NewStagingFSWithPersistence(config)
+	if err != nil {
+		t.Fatalf("Failed to reopen StagingFS: %v", err)
+	}
+	defer sf2.Close(context.Background())
+
+	meta := sf2.Get("/link")
+	if meta == nil || meta.SymlinkTarget != "/target" || string(meta.Xattrs["user.a"]) != "\x00\x01\x02" {
+		t.Errorf("Expected symlink and xattr to survive a restart, got %+v", meta)
+	}
+	if value, ok := sf2.Get("/old.txt").Xattrs["user.gone"]; !ok || value != nil {
+		t.Errorf("Expected removal of user.gone to survive a restart, got %v", sf2.Get("/old.txt").Xattrs)
+	}
+}
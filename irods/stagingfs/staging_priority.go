@@ -0,0 +1,158 @@
+package stagingfs
+
+import (
+	"path"
+	"sort"
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+// SyncPriority is the priority class of a staged item
+type SyncPriority int
+
+const (
+	PriorityDefault SyncPriority = iota // Resolved from PriorityRules and size
+	PriorityLow
+	PriorityNormal
+	PriorityHigh
+)
+
+const (
+	DefaultSmallFileThreshold    = 1024 * 1024 // 1MB
+	DefaultPriorityAgingInterval = 5 * time.Minute
+)
+
+func (p SyncPriority) String() string {
+	switch p {
+	case PriorityDefault:
+		return "DEFAULT"
+	case PriorityLow:
+		return "LOW"
+	case PriorityNormal:
+		return "NORMAL"
+	case PriorityHigh:
+		return "HIGH"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// PriorityRule assigns a priority to staged items whose path matches a glob pattern.
+// Patterns use path.Match syntax and are matched against the full path, then against the base name.
+type PriorityRule struct {
+	Pattern  string
+	Priority SyncPriority
+}
+
+func (r PriorityRule) matches(p string) bool {
+	if matched, _ := path.Match(r.Pattern, p); matched {
+		return true
+	}
+	matched, _ := path.Match(r.Pattern, path.Base(p))
+	return matched
+}
+
+// SetPriority explicitly sets the priority of a staged item, overriding rules
+func (sm *StagingStateManager) SetPriority(path string, priority SyncPriority) error {
+	shard := sm.lockShared(path)
+	defer sm.unlockShared(shard)
+
+	meta := sm.getMetadata(path)
+	if meta == nil {
+		return errors.Newf("no staged item for %s", path)
+	}
+
+	copied := *meta
+	copied.Priority = priority
+	return sm.persistMetadata(path, &copied)
+}
+
+// SetPriority explicitly sets the priority of a staged item.
+// PriorityDefault clears an explicit priority so rules apply again.
+func (sf *StagingFS) SetPriority(path string, priority SyncPriority) error {
+	return sf.sm.SetPriority(path, priority)
+}
+
+// GetPriority returns the resolved priority of a staged item
+func (sf *StagingFS) GetPriority(path string) SyncPriority {
+	meta := sf.sm.Get(path)
+	if meta == nil {
+		return PriorityDefault
+	}
+	return sf.resolvePriority(meta, sf.getSyncSize(meta))
+}
+
+// resolvePriority returns the priority of an item: explicit priority first, then the first matching
+// path rule, then size thresholds
+func (sf *StagingFS) resolvePriority(meta *StagingMetadata, size int64) SyncPriority {
+	if meta.Priority != PriorityDefault {
+		return meta.Priority
+	}
+
+	for _, rule := range sf.config.PriorityRules {
+		if rule.matches(meta.Path) {
+			return rule.Priority
+		}
+	}
+
+	smallThreshold := sf.config.SmallFileThreshold
+	if smallThreshold == 0 {
+		smallThreshold = DefaultSmallFileThreshold
+	}
+	if smallThreshold > 0 && size < smallThreshold {
+		return PriorityHigh
+	}
+
+	if sf.config.LargeFileThreshold > 0 && size >= sf.config.LargeFileThreshold {
+		return PriorityLow
+	}
+
+	return PriorityNormal
+}
+
+// getSyncSize returns the number of bytes a sync of the item transfers
+func (sf *StagingFS) getSyncSize(meta *StagingMetadata) int64 {
+	if meta.Action != ActionUpload {
+		return 0
+	}
+	return max(sf.GetLocalFileSize(meta.Path), 0)
+}
+
+// syncCandidate is an item eligible for background sync with its scheduling keys
+type syncCandidate struct {
+	meta  *StagingMetadata
+	size  int64
+	level int // priority raised by one for every aging interval the item has waited
+}
+
+// newSyncCandidate computes the scheduling keys of an item
+func (sf *StagingFS) newSyncCandidate(meta *StagingMetadata, now time.Time) *syncCandidate {
+	agingInterval := sf.config.PriorityAgingInterval
+	if agingInterval <= 0 {
+		agingInterval = DefaultPriorityAgingInterval
+	}
+
+	size := sf.getSyncSize(meta)
+	waited := now.Sub(meta.LastModifiedAt)
+
+	return &syncCandidate{
+		meta:  meta,
+		size:  size,
+		level: int(sf.resolvePriority(meta, size)) + int(waited/agingInterval),
+	}
+}
+
+// sortSyncCandidates orders candidates by aged priority, then smaller size, then older creation time
+func sortSyncCandidates(candidates []*syncCandidate) {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.level != b.level {
+			return a.level > b.level
+		}
+		if a.size != b.size {
+			return a.size < b.size
+		}
+		return a.meta.CreatedAt.Before(b.meta.CreatedAt)
+	})
+}
@@ -0,0 +1,182 @@
+package stagingfs
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newPriorityTestFS(t *testing.T, config *StagingFSConfig) (*StagingFS, *[]string) {
+	config.LocalRootPath = t.TempDir()
+	config.Client = &MockStagingClient{}
+
+	sf, err := NewStagingFS(config)
+	if err != nil {
+		t.Fatalf("Failed to create StagingFS: %v", err)
+	}
+	t.Cleanup(func() { sf.Close(context.Background()) })
+
+	order := []string{}
+	sf.RegisterActionHandler(func(meta *StagingMetadata) error {
+		order = append(order, meta.Path)
+		return nil
+	})
+
+	return sf, &order
+}
+
+func writeStagedFile(t *testing.T, sf *StagingFS, path string, size int) {
+	f, err := sf.OpenForWrite(path)
+	if err != nil {
+		t.Fatalf("Failed to open %s for writing: %v", path, err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write([]byte(strings.Repeat("x", size))); err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+}
+
+func TestStagingFSResolvePriority(t *testing.T) {
+	sf, _ := newPriorityTestFS(t, &StagingFSConfig{
+		PriorityRules: []PriorityRule{
+			{Pattern: "*.marker", Priority: PriorityHigh},
+			{Pattern: "/scratch/*", Priority: PriorityLow},
+		},
+		SmallFileThreshold: 10,
+		LargeFileThreshold: 100,
+	})
+
+	writeStagedFile(t, sf, "/data/done.marker", 500)
+	writeStagedFile(t, sf, "/scratch/tiny.txt", 1)
+	writeStagedFile(t, sf, "/data/small.txt", 5)
+	writeStagedFile(t, sf, "/data/medium.txt", 50)
+	writeStagedFile(t, sf, "/data/large.txt", 500)
+
+	expected := map[string]SyncPriority{
+		"/data/done.marker": PriorityHigh,
+		"/scratch/tiny.txt": PriorityLow,
+		"/data/small.txt":   PriorityHigh,
+		"/data/medium.txt":  PriorityNormal,
+		"/data/large.txt":   PriorityLow,
+	}
+	for path, priority := range expected {
+		if got := sf.GetPriority(path); got != priority {
+			t.Errorf("Expected %s priority for %s, got %s", priority, path, got)
+		}
+	}
+
+	// Explicit priority overrides rules and size
+	if err := sf.SetPriority("/scratch/tiny.txt", PriorityHigh); err != nil {
+		t.Fatalf("SetPriority failed: %v", err)
+	}
+	if got := sf.GetPriority("/scratch/tiny.txt"); got != PriorityHigh {
+		t.Errorf("Expected explicit HIGH priority, got %s", got)
+	}
+
+	if err := sf.SetPriority("/missing.txt", PriorityHigh); err == nil {
+		t.Errorf("Expected error setting priority of an unstaged path")
+	}
+}
+
+func TestStagingFSSyncOrderFavoursSmallFiles(t *testing.T) {
+	sf, order := newPriorityTestFS(t, &StagingFSConfig{
+		SmallFileThreshold: 10,
+	})
+
+	writeStagedFile(t, sf, "/big.dat", 1000)
+	writeStagedFile(t, sf, "/medium.dat", 100)
+	writeStagedFile(t, sf, "/small.marker", 1)
+
+	sf.syncOldItems(0)
+
+	expected := []string{"/small.marker", "/medium.dat", "/big.dat"}
+	if strings.Join(*order, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected sync order %v, got %v", expected, *order)
+	}
+}
+
+func TestStagingFSSyncOrderExplicitPriority(t *testing.T) {
+	sf, order := newPriorityTestFS(t, &StagingFSConfig{
+		SmallFileThreshold: 10,
+	})
+
+	writeStagedFile(t, sf, "/big.dat", 1000)
+	writeStagedFile(t, sf, "/small.marker", 1)
+
+	if err := sf.SetPriority("/big.dat", PriorityHigh); err != nil {
+		t.Fatalf("SetPriority failed: %v", err)
+	}
+	if err := sf.SetPriority("/small.marker", PriorityLow); err != nil {
+		t.Fatalf("SetPriority failed: %v", err)
+	}
+
+	sf.syncOldItems(0)
+
+	if len(*order) != 2 || (*order)[0] != "/big.dat" {
+		t.Errorf("Expected explicitly prioritized file first, got %v", *order)
+	}
+}
+
+func TestSyncCandidateAging(t *testing.T) {
+	sf, _ := newPriorityTestFS(t, &StagingFSConfig{
+		SmallFileThreshold:    10,
+		LargeFileThreshold:    100,
+		PriorityAgingInterval: time.Minute,
+	})
+
+	writeStagedFile(t, sf, "/large.dat", 1000)
+	writeStagedFile(t, sf, "/small.dat", 1)
+
+	now := time.Now()
+	large := sf.newSyncCandidate(sf.Get("/large.dat"), now.Add(3*time.Minute))
+	small := sf.newSyncCandidate(sf.Get("/small.dat"), now)
+
+	candidates := []*syncCandidate{small, large}
+	sortSyncCandidates(candidates)
+
+	// LOW raised by three levels outranks a fresh HIGH item
+	if candidates[0] != large {
+		t.Errorf("Expected aged large item to be scheduled first, got %s", candidates[0].meta.Path)
+	}
+}
+
+func TestStagingFSSyncPassSkipsItemsChangedDuringPass(t *testing.T) {
+	sf, order := newPriorityTestFS(t, &StagingFSConfig{
+		SmallFileThreshold: 10,
+	})
+
+	writeStagedFile(t, sf, "/big.dat", 1000)
+	writeStagedFile(t, sf, "/gone.dat", 100)
+	writeStagedFile(t, sf, "/small.marker", 1)
+
+	// While the first item syncs, one remaining item is modified and another is removed
+	sf.RegisterActionHandler(func(meta *StagingMetadata) error {
+		*order = append(*order, meta.Path)
+		if meta.Path == "/small.marker" {
+			if err := sf.sm.Modify("/big.dat"); err != nil {
+				t.Errorf("Failed to modify /big.dat: %v", err)
+			}
+			if err := sf.Delete("/gone.dat"); err != nil {
+				t.Errorf("Failed to remove /gone.dat: %v", err)
+			}
+		}
+		return nil
+	})
+
+	sf.syncOldItems(0)
+
+	if strings.Join(*order, ",") != "/small.marker" {
+		t.Errorf("Expected only /small.marker to sync in the first pass, got %v", *order)
+	}
+
+	// The modified item waits for its grace period again
+	time.Sleep(time.Millisecond)
+	sf.syncOldItems(0)
+
+	expected := []string{"/small.marker", "/big.dat"}
+	if strings.Join(*order, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected sync order %v, got %v", expected, *order)
+	}
+}
@@ -0,0 +1,379 @@
+package stagingfs
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+// StagingSnapshot is an offline view of a staging root (<LocalRootPath>/meta and data/) for inspection and repair.
+// It must not be opened while a StagingFS is using the same root.
+type StagingSnapshot struct {
+	rootPath string
+	readOnly bool
+	store    MetadataStore
+	sm       *StagingStateManager
+	failed   map[string]*StagingMetadata
+}
+
+// SnapshotItem describes a staged item found in a snapshot
+type SnapshotItem struct {
+	Path       string            `json:"path"`
+	OldPath    string            `json:"old_path,omitempty"`
+	Action     string            `json:"action"`
+	Failed     bool              `json:"failed"`
+	LocalSize  int64             `json:"local_size"` // Size of the local data file (-1 = missing or not an upload)
+	Metadata   *StagingMetadata  `json:"metadata"`
+	Checkpoint *UploadCheckpoint `json:"checkpoint,omitempty"`
+	TempUpload *TempUpload       `json:"temp_upload,omitempty"`
+}
+
+// SnapshotVerifyReport lists inconsistencies between metadata and local data files
+type SnapshotVerifyReport struct {
+	MissingData      []string `json:"missing_data"`      // Uploads without a local data file
+	OrphanFiles      []string `json:"orphan_files"`      // Local data files without metadata (removed on next mount)
+	StaleCheckpoints []string `json:"stale_checkpoints"` // Checkpoints that no longer match the local file or any upload
+}
+
+// IsConsistent returns true if no inconsistency was found
+func (r *SnapshotVerifyReport) IsConsistent() bool {
+	return len(r.MissingData) == 0 && len(r.OrphanFiles) == 0 && len(r.StaleCheckpoints) == 0
+}
+
+// SnapshotExport is the JSON document written by Export
+type SnapshotExport struct {
+	LocalRootPath string                `json:"local_root_path"`
+	ExportedAt    time.Time             `json:"exported_at"`
+	Pending       []*SnapshotItem       `json:"pending"`
+	Failed        []*SnapshotItem       `json:"failed"`
+	TempUploads   []*TempUpload         `json:"temp_uploads"`
+	Verify        *SnapshotVerifyReport `json:"verify"`
+}
+
+// OpenStagingSnapshot opens the staging root at localRootPath with its Badger database in <root>/meta.
+// With readOnly, the database is opened read-only and Replay and Discard are rejected.
+func OpenStagingSnapshot(localRootPath string, readOnly bool) (*StagingSnapshot, error) {
+	if localRootPath == "" {
+		return nil, errors.New("LocalRootPath is required")
+	}
+
+	metaPath := filepath.Join(localRootPath, "meta")
+	if _, err := os.Stat(metaPath); err != nil {
+		return nil, errors.Wrapf(err, "failed to find meta directory in %s", localRootPath)
+	}
+
+	store, err := NewBadgerMetadataStore(metaPath, readOnly)
+	if err != nil {
+		return nil, err
+	}
+	return OpenStagingSnapshotWithStore(localRootPath, store, readOnly)
+}
+
+// OpenStagingSnapshotWithStore opens the staging root at localRootPath with state read from store.
+// The snapshot takes ownership of store.
+func OpenStagingSnapshotWithStore(localRootPath string, store MetadataStore, readOnly bool) (*StagingSnapshot, error) {
+	sm := NewStagingStateManagerWithStore(store)
+	if err := sm.Restore(); err != nil {
+		store.Close()
+		return nil, errors.Wrap(err, "failed to restore from metadata store")
+	}
+
+	failed, err := sm.restoreFailed()
+	if err != nil {
+		store.Close()
+		return nil, errors.Wrap(err, "failed to restore failed items from metadata store")
+	}
+
+	return &StagingSnapshot{
+		rootPath: localRootPath,
+		readOnly: readOnly,
+		store:    store,
+		sm:       sm,
+		failed:   failed,
+	}, nil
+}
+
+// Close closes the metadata store
+func (s *StagingSnapshot) Close() error {
+	return s.store.Close()
+}
+
+// getLocalDataPath returns the local file path for an iRODS path
+func (s *StagingSnapshot) getLocalDataPath(path string) string {
+	if len(path) > 0 && path[0] == '/' {
+		path = path[1:]
+	}
+	return filepath.Join(s.rootPath, "data", path)
+}
+
+// getLocalFileSize returns the size of the local data file, or -1 if it does not exist
+func (s *StagingSnapshot) getLocalFileSize(path string) int64 {
+	info, err := os.Stat(s.getLocalDataPath(path))
+	if err != nil || info.IsDir() {
+		return -1
+	}
+	return info.Size()
+}
+
+func (s *StagingSnapshot) newItem(meta *StagingMetadata, failed bool) *SnapshotItem {
+	item := &SnapshotItem{
+		Path:      meta.Path,
+		OldPath:   meta.OldPath,
+		Action:    meta.Action.String(),
+		Failed:    failed,
+		LocalSize: -1,
+		Metadata:  meta,
+	}
+
+	if meta.Action == ActionUpload {
+		item.LocalSize = s.getLocalFileSize(meta.Path)
+		item.TempUpload = s.sm.GetTempUpload(meta.Path)
+
+		checkpointPath := meta.Path
+		if item.TempUpload != nil {
+			checkpointPath = item.TempUpload.TempPath
+		}
+		item.Checkpoint = s.sm.GetCheckpoint(checkpointPath)
+	}
+	return item
+}
+
+func sortSnapshotItems(items []*SnapshotItem) {
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Path < items[j].Path
+	})
+}
+
+// GetPending returns items waiting to be synced, sorted by path
+func (s *StagingSnapshot) GetPending() []*SnapshotItem {
+	items := []*SnapshotItem{}
+	for _, meta := range s.sm.GetAll() {
+		items = append(items, s.newItem(meta, false))
+	}
+	sortSnapshotItems(items)
+	return items
+}
+
+// GetFailed returns items that exceeded the max retry count, sorted by path
+func (s *StagingSnapshot) GetFailed() []*SnapshotItem {
+	items := []*SnapshotItem{}
+	for _, meta := range s.failed {
+		items = append(items, s.newItem(meta, true))
+	}
+	sortSnapshotItems(items)
+	return items
+}
+
+// GetItem returns the pending or failed item for a path, or nil
+func (s *StagingSnapshot) GetItem(path string) *SnapshotItem {
+	if meta := s.sm.Get(path); meta != nil {
+		return s.newItem(meta, false)
+	}
+	if meta, ok := s.failed[path]; ok {
+		return s.newItem(meta, true)
+	}
+	return nil
+}
+
+// Verify checks local data files against metadata
+func (s *StagingSnapshot) Verify() *SnapshotVerifyReport {
+	report := &SnapshotVerifyReport{
+		MissingData:      []string{},
+		OrphanFiles:      []string{},
+		StaleCheckpoints: []string{},
+	}
+
+	items := append(s.GetPending(), s.GetFailed()...)
+	known := map[string]bool{}
+	uploads := map[string]*SnapshotItem{}
+	for _, item := range items {
+		known[item.Path] = true
+		if item.Metadata.Action != ActionUpload {
+			continue
+		}
+
+		if item.LocalSize < 0 {
+			report.MissingData = append(report.MissingData, item.Path)
+		}
+
+		checkpointPath := item.Path
+		if item.TempUpload != nil {
+			checkpointPath = item.TempUpload.TempPath
+		}
+		uploads[checkpointPath] = item
+	}
+
+	dataPath := filepath.Join(s.rootPath, "data")
+	filepath.Walk(dataPath, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(dataPath, filePath)
+		if err != nil {
+			return nil
+		}
+
+		irodsPath := "/" + filepath.ToSlash(relPath)
+		if !known[irodsPath] {
+			report.OrphanFiles = append(report.OrphanFiles, irodsPath)
+		}
+		return nil
+	})
+
+	s.sm.auxMu.Lock()
+	for path, cp := range s.sm.checkpoints {
+		item, ok := uploads[path]
+		if !ok {
+			report.StaleCheckpoints = append(report.StaleCheckpoints, path)
+			continue
+		}
+
+		info, err := os.Stat(s.getLocalDataPath(item.Path))
+		if err != nil || info.Size() != cp.Size || !info.ModTime().Equal(cp.ModTime) {
+			report.StaleCheckpoints = append(report.StaleCheckpoints, path)
+		}
+	}
+	s.sm.auxMu.Unlock()
+
+	sort.Strings(report.MissingData)
+	sort.Strings(report.OrphanFiles)
+	sort.Strings(report.StaleCheckpoints)
+	return report
+}
+
+// Export writes the snapshot state and a verify report as JSON
+func (s *StagingSnapshot) Export(w io.Writer) error {
+	temps := s.sm.GetTempUploads()
+	sort.Slice(temps, func(i, j int) bool {
+		return temps[i].Path < temps[j].Path
+	})
+
+	export := &SnapshotExport{
+		LocalRootPath: s.rootPath,
+		ExportedAt:    time.Now(),
+		Pending:       s.GetPending(),
+		Failed:        s.GetFailed(),
+		TempUploads:   temps,
+		Verify:        s.Verify(),
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(export); err != nil {
+		return errors.Wrap(err, "failed to encode staging snapshot")
+	}
+	return nil
+}
+
+// getItemsForRepair resolves paths to pending or failed metadata, parents first
+func (s *StagingSnapshot) getItemsForRepair(paths []string) ([]*StagingMetadata, error) {
+	if s.readOnly {
+		return nil, errors.New("staging snapshot is opened read-only")
+	}
+
+	metas := []*StagingMetadata{}
+	for _, path := range paths {
+		item := s.GetItem(path)
+		if item == nil {
+			return nil, errors.Newf("no staged item for %s", path)
+		}
+		metas = append(metas, item.Metadata)
+	}
+	sortMetadataByPath(metas)
+	return metas, nil
+}
+
+// forget removes all records and local data of a staged item (temporary upload tracking is kept,
+// so that the next mount still removes the temporary object in iRODS)
+func (s *StagingSnapshot) forget(meta *StagingMetadata) error {
+	if err := s.sm.deleteMetadataPublic(meta.Path); err != nil {
+		return err
+	}
+	if err := s.sm.deleteFailed(meta.Path); err != nil {
+		return err
+	}
+	delete(s.failed, meta.Path)
+
+	checkpointPath := meta.Path
+	if temp := s.sm.GetTempUpload(meta.Path); temp != nil {
+		checkpointPath = temp.TempPath
+	}
+	if err := s.sm.DeleteCheckpoint(checkpointPath); err != nil {
+		return err
+	}
+
+	if meta.Action == ActionUpload {
+		if err := os.Remove(s.getLocalDataPath(meta.Path)); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "failed to remove local data of %s", meta.Path)
+		}
+	}
+	return nil
+}
+
+// Replay performs the iRODS operations of the given pending or failed items, parents first, and forgets
+// each item once it succeeds. It stops at the first failure and returns the paths replayed so far.
+func (s *StagingSnapshot) Replay(client StagingClient, config *StagingFSConfig, paths []string) ([]string, error) {
+	metas, err := s.getItemsForRepair(paths)
+	if err != nil {
+		return nil, err
+	}
+	if client == nil {
+		return nil, errors.New("client is required")
+	}
+
+	// A StagingFS without background worker, sharing the snapshot's state manager for checkpoints
+	replayConfig := StagingFSConfig{}
+	if config != nil {
+		replayConfig = *config
+	}
+	replayConfig.LocalRootPath = s.rootPath
+	replayConfig.Client = client
+
+	sf := &StagingFS{
+		config:      &replayConfig,
+		sm:          s.sm,
+		client:      client,
+		failedItems: make(map[string]*StagingMetadata),
+		events:      newStagingEventBus(),
+	}
+	sf.syncCtx, sf.cancelSync = context.WithCancel(context.Background())
+	defer sf.cancelSync()
+	if replayConfig.SyncBandwidthLimit > 0 {
+		sf.limiter = NewBandwidthLimiter(replayConfig.SyncBandwidthLimit, replayConfig.SyncBandwidthBurst)
+	}
+
+	replayed := []string{}
+	for _, meta := range metas {
+		if err := sf.applyAction(meta); err != nil {
+			return replayed, errors.Wrapf(err, "failed to replay %s action on %s", meta.Action, meta.Path)
+		}
+
+		if err := s.forget(meta); err != nil {
+			return replayed, errors.Wrapf(err, "failed to remove replayed item %s", meta.Path)
+		}
+		replayed = append(replayed, meta.Path)
+	}
+	return replayed, nil
+}
+
+// Discard forgets the given pending or failed items without touching iRODS, removing their local data
+func (s *StagingSnapshot) Discard(paths []string) error {
+	metas, err := s.getItemsForRepair(paths)
+	if err != nil {
+		return err
+	}
+
+	for _, meta := range metas {
+		if err := s.forget(meta); err != nil {
+			return errors.Wrapf(err, "failed to discard %s", meta.Path)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,323 @@
+package stagingfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// stopWithoutSync simulates a dead mount: the worker stops and Badger is closed without syncing
+func stopWithoutSync(t *testing.T, sf *StagingFS) {
+	sf.stopOnce.Do(func() { close(sf.stopCh) })
+	<-sf.workerDone
+	if err := sf.sm.store.Close(); err != nil {
+		t.Fatalf("Failed to close DB: %v", err)
+	}
+}
+
+// newDeadStagingRoot leaves a staging root with pending /dir, /dir/a.txt and failed /b.txt
+func newDeadStagingRoot(t *testing.T) string {
+	rootPath := t.TempDir()
+	client := newMockAtomicClient()
+
+	sf, err := NewStagingFSWithPersistence(&StagingFSConfig{LocalRootPath: rootPath, Client: client})
+	if err != nil {
+		t.Fatalf("Failed to create StagingFS: %v", err)
+	}
+
+	writeStagedFile(t, sf, "/b.txt", 3)
+	client.failUpload = true
+	for i := 0; i < MaxSyncFailCount; i++ {
+		sf.syncOldItems(0)
+	}
+	if len(sf.GetFailedItems()) != 1 {
+		t.Fatalf("Expected one failed item, got %d", len(sf.GetFailedItems()))
+	}
+
+	if err := sf.Mkdir("/dir"); err != nil {
+		t.Fatalf("Failed to mkdir: %v", err)
+	}
+	writeStagedFile(t, sf, "/dir/a.txt", 5)
+
+	stopWithoutSync(t, sf)
+	return rootPath
+}
+
+// copyCrashedStagingRoot simulates a mount that died without closing Badger: the staging root is
+// copied while the database is open, and the copy is returned
+func copyCrashedStagingRoot(t *testing.T, sf *StagingFS) string {
+	sf.stopOnce.Do(func() { close(sf.stopCh) })
+	<-sf.workerDone
+	t.Cleanup(func() { sf.sm.store.Close() })
+
+	crashedPath := filepath.Join(t.TempDir(), "crashed")
+	err := filepath.Walk(sf.config.LocalRootPath, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(sf.config.LocalRootPath, p)
+		if err != nil {
+			return err
+		}
+		destPath := filepath.Join(crashedPath, relPath)
+
+		if info.IsDir() {
+			return os.MkdirAll(destPath, 0755)
+		}
+		return copySparseFile(p, destPath)
+	})
+	if err != nil {
+		t.Fatalf("Failed to copy staging root: %v", err)
+	}
+	return crashedPath
+}
+
+func TestStagingSnapshotList(t *testing.T) {
+	rootPath := newDeadStagingRoot(t)
+
+	snapshot, err := OpenStagingSnapshot(rootPath, true)
+	if err != nil {
+		t.Fatalf("Failed to open snapshot: %v", err)
+	}
+	defer snapshot.Close()
+
+	pending := snapshot.GetPending()
+	if len(pending) != 2 || pending[0].Path != "/dir" || pending[1].Path != "/dir/a.txt" {
+		t.Fatalf("Expected pending /dir and /dir/a.txt, got %v", pending)
+	}
+	if pending[0].Action != "MKDIR" || pending[0].LocalSize != -1 {
+		t.Errorf("Expected MKDIR without local data, got %s %d", pending[0].Action, pending[0].LocalSize)
+	}
+	if pending[1].LocalSize != 5 {
+		t.Errorf("Expected local size 5, got %d", pending[1].LocalSize)
+	}
+
+	failed := snapshot.GetFailed()
+	if len(failed) != 1 || failed[0].Path != "/b.txt" || !failed[0].Failed {
+		t.Fatalf("Expected failed /b.txt, got %v", failed)
+	}
+	if failed[0].LocalSize != 3 {
+		t.Errorf("Expected data of failed item to be kept, got size %d", failed[0].LocalSize)
+	}
+	if failed[0].Metadata.SyncFailCount != MaxSyncFailCount {
+		t.Errorf("Expected fail count %d, got %d", MaxSyncFailCount, failed[0].Metadata.SyncFailCount)
+	}
+
+	if err := snapshot.Discard([]string{"/b.txt"}); err == nil {
+		t.Errorf("Expected discard to be rejected in read-only mode")
+	}
+}
+
+func TestStagingSnapshotOpensUncleanDatabase(t *testing.T) {
+	sf, err := NewStagingFSWithPersistence(&StagingFSConfig{LocalRootPath: t.TempDir(), Client: newMockAtomicClient()})
+	if err != nil {
+		t.Fatalf("Failed to create StagingFS: %v", err)
+	}
+	writeStagedFile(t, sf, "/a.txt", 5)
+
+	rootPath := copyCrashedStagingRoot(t, sf)
+	metaFiles := func() map[string]int64 {
+		files := map[string]int64{}
+		entries, _ := os.ReadDir(filepath.Join(rootPath, "meta"))
+		for _, entry := range entries {
+			info, _ := entry.Info()
+			files[entry.Name()] = info.Size()
+		}
+		return files
+	}
+	before := metaFiles()
+
+	// Badger needs to truncate the logs of the database, which a read-only open cannot do
+	for i := 0; i < 2; i++ {
+		snapshot, err := OpenStagingSnapshot(rootPath, true)
+		if err != nil {
+			t.Fatalf("Failed to open snapshot of unclean database: %v", err)
+		}
+
+		pending := snapshot.GetPending()
+		if len(pending) != 1 || pending[0].Path != "/a.txt" || pending[0].LocalSize != 5 {
+			t.Errorf("Expected pending /a.txt, got %v", pending)
+		}
+		if err := snapshot.Discard([]string{"/a.txt"}); err == nil {
+			t.Errorf("Expected discard to be rejected in read-only mode")
+		}
+		if err := snapshot.Close(); err != nil {
+			t.Fatalf("Failed to close snapshot: %v", err)
+		}
+	}
+
+	after := metaFiles()
+	if len(after) != len(before) {
+		t.Errorf("Expected the database to be left untouched, files went from %v to %v", before, after)
+	}
+	for name, size := range before {
+		if after[name] != size {
+			t.Errorf("Expected %s to be left untouched, size went from %d to %d", name, size, after[name])
+		}
+	}
+
+	// A read-write open recovers the database in place
+	snapshot, err := OpenStagingSnapshot(rootPath, false)
+	if err != nil {
+		t.Fatalf("Failed to open snapshot read-write: %v", err)
+	}
+	defer snapshot.Close()
+	if len(snapshot.GetPending()) != 1 {
+		t.Errorf("Expected pending /a.txt after recovery, got %v", snapshot.GetPending())
+	}
+}
+
+func TestStagingSnapshotVerifyAndExport(t *testing.T) {
+	rootPath := newDeadStagingRoot(t)
+
+	// Corrupt the root: an orphan file and a missing data file
+	orphan := filepath.Join(rootPath, "data", "orphan.bin")
+	if err := os.WriteFile(orphan, []byte("x"), 0644); err != nil {
+		t.Fatalf("Failed to write orphan: %v", err)
+	}
+	if err := os.Remove(filepath.Join(rootPath, "data", "dir", "a.txt")); err != nil {
+		t.Fatalf("Failed to remove data file: %v", err)
+	}
+
+	snapshot, err := OpenStagingSnapshot(rootPath, true)
+	if err != nil {
+		t.Fatalf("Failed to open snapshot: %v", err)
+	}
+	defer snapshot.Close()
+
+	report := snapshot.Verify()
+	if report.IsConsistent() {
+		t.Fatalf("Expected inconsistencies")
+	}
+	if strings.Join(report.MissingData, ",") != "/dir/a.txt" {
+		t.Errorf("Expected missing /dir/a.txt, got %v", report.MissingData)
+	}
+	if strings.Join(report.OrphanFiles, ",") != "/orphan.bin" {
+		t.Errorf("Expected orphan /orphan.bin, got %v", report.OrphanFiles)
+	}
+
+	var buf bytes.Buffer
+	if err := snapshot.Export(&buf); err != nil {
+		t.Fatalf("Failed to export: %v", err)
+	}
+
+	var export SnapshotExport
+	if err := json.Unmarshal(buf.Bytes(), &export); err != nil {
+		t.Fatalf("Failed to decode export: %v", err)
+	}
+	if len(export.Pending) != 2 || len(export.Failed) != 1 || len(export.Verify.OrphanFiles) != 1 {
+		t.Errorf("Unexpected export content: %s", buf.String())
+	}
+}
+
+func TestStagingSnapshotReplay(t *testing.T) {
+	rootPath := newDeadStagingRoot(t)
+
+	snapshot, err := OpenStagingSnapshot(rootPath, false)
+	if err != nil {
+		t.Fatalf("Failed to open snapshot: %v", err)
+	}
+
+	client := newMockAtomicClient()
+	replayed, err := snapshot.Replay(client, nil, []string{"/dir/a.txt", "/dir", "/b.txt"})
+	if err != nil {
+		t.Fatalf("Failed to replay: %v", err)
+	}
+	if strings.Join(replayed, ",") != "/b.txt,/dir,/dir/a.txt" {
+		t.Errorf("Expected parents replayed first, got %v", replayed)
+	}
+	if string(client.objects["/dir/a.txt"]) != "xxxxx" || string(client.objects["/b.txt"]) != "xxx" {
+		t.Errorf("Expected uploads in iRODS, got %v", client.getObjectPaths())
+	}
+	if len(snapshot.GetPending()) != 0 || len(snapshot.GetFailed()) != 0 {
+		t.Errorf("Expected replayed items to be removed")
+	}
+	if _, err := os.Stat(filepath.Join(rootPath, "data", "b.txt")); !os.IsNotExist(err) {
+		t.Errorf("Expected local data of replayed item to be removed")
+	}
+
+	if _, err := snapshot.Replay(client, nil, []string{"/missing"}); err == nil {
+		t.Errorf("Expected error for unknown path")
+	}
+
+	if err := snapshot.Close(); err != nil {
+		t.Fatalf("Failed to close snapshot: %v", err)
+	}
+
+	// A remount sees nothing left to do
+	sf, err := NewStagingFSWithPersistence(&StagingFSConfig{LocalRootPath: rootPath, Client: client})
+	if err != nil {
+		t.Fatalf("Failed to reopen StagingFS: %v", err)
+	}
+	defer sf.Close(context.Background())
+
+	if len(sf.GetAll()) != 0 || len(sf.GetFailedItems()) != 0 {
+		t.Errorf("Expected no staged or failed items after replay")
+	}
+}
+
+func TestStagingSnapshotDiscard(t *testing.T) {
+	rootPath := newDeadStagingRoot(t)
+
+	snapshot, err := OpenStagingSnapshot(rootPath, false)
+	if err != nil {
+		t.Fatalf("Failed to open snapshot: %v", err)
+	}
+
+	if err := snapshot.Discard([]string{"/b.txt"}); err != nil {
+		t.Fatalf("Failed to discard: %v", err)
+	}
+	if snapshot.GetItem("/b.txt") != nil {
+		t.Errorf("Expected discarded item to be gone")
+	}
+	if _, err := os.Stat(filepath.Join(rootPath, "data", "b.txt")); !os.IsNotExist(err) {
+		t.Errorf("Expected local data of discarded item to be removed")
+	}
+	if err := snapshot.Close(); err != nil {
+		t.Fatalf("Failed to close snapshot: %v", err)
+	}
+
+	sf, err := NewStagingFSWithPersistence(&StagingFSConfig{LocalRootPath: rootPath, Client: &MockStagingClient{}})
+	if err != nil {
+		t.Fatalf("Failed to reopen StagingFS: %v", err)
+	}
+	defer sf.Close(context.Background())
+
+	if len(sf.GetFailedItems()) != 0 {
+		t.Errorf("Expected discarded failed item not to be restored")
+	}
+	if len(sf.GetAll()) != 2 {
+		t.Errorf("Expected pending items to be kept, got %v", getStagedPaths(sf))
+	}
+}
+
+func TestStagingFSRestoresFailedItems(t *testing.T) {
+	rootPath := newDeadStagingRoot(t)
+
+	sf, err := NewStagingFSWithPersistence(&StagingFSConfig{LocalRootPath: rootPath, Client: &MockStagingClient{}})
+	if err != nil {
+		t.Fatalf("Failed to reopen StagingFS: %v", err)
+	}
+	defer sf.Close(context.Background())
+
+	if _, ok := sf.GetFailedItems()["/b.txt"]; !ok {
+		t.Fatalf("Expected failed item to be restored")
+	}
+	if sf.GetLocalFileSize("/b.txt") != 3 {
+		t.Errorf("Expected local data of failed item to survive orphan cleanup")
+	}
+
+	sf.ClearFailedItems()
+	failed, err := sf.sm.restoreFailed()
+	if err != nil {
+		t.Fatalf("Failed to read failed items: %v", err)
+	}
+	if len(failed) != 0 {
+		t.Errorf("Expected failed items to be removed from Badger")
+	}
+}
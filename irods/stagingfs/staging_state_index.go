@@ -0,0 +1,399 @@
+package stagingfs
+
+import (
+	"container/list"
+	"hash/fnv"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// stagingShardCount is the number of shards staged paths are hashed into
+const stagingShardCount = 32
+
+// stagingShard holds the staged metadata and sync locks of the paths hashing to it.
+// Its maps are accessed with its mu held, or with the manager mu held exclusively.
+type stagingShard struct {
+	mu          sync.Mutex
+	metadata    map[string]*StagingMetadata
+	lockedPaths map[string]chan struct{} // Paths locked during sync operations, closed on unlock
+}
+
+func newStagingShard() *stagingShard {
+	return &stagingShard{
+		metadata:    make(map[string]*StagingMetadata),
+		lockedPaths: make(map[string]chan struct{}),
+	}
+}
+
+// lockPath marks path as locked by a sync (caller must hold the shard)
+func (s *stagingShard) lockPath(path string) {
+	s.lockedPaths[path] = make(chan struct{})
+}
+
+// unlockPath releases the sync lock of path and wakes up waiting goroutines (caller must hold the shard)
+func (s *stagingShard) unlockPath(path string) {
+	if done, ok := s.lockedPaths[path]; ok {
+		delete(s.lockedPaths, path)
+		close(done)
+	}
+}
+
+// indexEntry is what the index recorded for a staged path
+type indexEntry struct {
+	meta    *StagingMetadata
+	oldPath string        // OldPath of a rename entry, "" otherwise
+	age     *list.Element // Element of the age list
+}
+
+// ageEntry is an element of the age list
+type ageEntry struct {
+	path           string
+	lastModifiedAt time.Time
+}
+
+// stagingIndex keeps secondary indexes over all shards so that listings, rename checks and
+// grace period scans do not visit every staged item
+type stagingIndex struct {
+	mu          sync.RWMutex
+	entries     map[string]*indexEntry
+	children    map[string]map[string]*StagingMetadata // Parent dir -> path -> metadata
+	renamedFrom map[string]map[string]bool             // OldPath -> paths of rename entries
+	renamedAway map[string]map[string]bool             // Parent dir of OldPath -> OldPaths
+	age         *list.List                             // *ageEntry, oldest LastModifiedAt first
+}
+
+func newStagingIndex() *stagingIndex {
+	return &stagingIndex{
+		entries:     make(map[string]*indexEntry),
+		children:    make(map[string]map[string]*StagingMetadata),
+		renamedFrom: make(map[string]map[string]bool),
+		renamedAway: make(map[string]map[string]bool),
+		age:         list.New(),
+	}
+}
+
+// put indexes meta, replacing what was indexed for the same path
+func (idx *stagingIndex) put(meta *StagingMetadata) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.remove(meta.Path)
+
+	entry := &indexEntry{meta: meta}
+
+	parent := path.Dir(meta.Path)
+	if idx.children[parent] == nil {
+		idx.children[parent] = make(map[string]*StagingMetadata)
+	}
+	idx.children[parent][meta.Path] = meta
+
+	if meta.OldPath != "" && (meta.Action == ActionRename || meta.Action == ActionRenameDir) {
+		entry.oldPath = meta.OldPath
+		if idx.renamedFrom[meta.OldPath] == nil {
+			idx.renamedFrom[meta.OldPath] = make(map[string]bool)
+		}
+		idx.renamedFrom[meta.OldPath][meta.Path] = true
+
+		oldParent := path.Dir(meta.OldPath)
+		if idx.renamedAway[oldParent] == nil {
+			idx.renamedAway[oldParent] = make(map[string]bool)
+		}
+		idx.renamedAway[oldParent][meta.OldPath] = true
+	}
+
+	// Changes are mostly stamped with the current time, so the position is found from the back
+	aged := &ageEntry{path: meta.Path, lastModifiedAt: meta.LastModifiedAt}
+	elem := idx.age.Back()
+	for elem != nil && elem.Value.(*ageEntry).lastModifiedAt.After(aged.lastModifiedAt) {
+		elem = elem.Prev()
+	}
+	if elem == nil {
+		entry.age = idx.age.PushFront(aged)
+	} else {
+		entry.age = idx.age.InsertAfter(aged, elem)
+	}
+
+	idx.entries[meta.Path] = entry
+}
+
+// delete removes path from the index
+func (idx *stagingIndex) delete(p string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.remove(p)
+}
+
+// remove removes path from the index (caller must hold mu)
+func (idx *stagingIndex) remove(p string) {
+	entry, ok := idx.entries[p]
+	if !ok {
+		return
+	}
+	delete(idx.entries, p)
+
+	parent := path.Dir(p)
+	delete(idx.children[parent], p)
+	if len(idx.children[parent]) == 0 {
+		delete(idx.children, parent)
+	}
+
+	if entry.oldPath != "" {
+		delete(idx.renamedFrom[entry.oldPath], p)
+		if len(idx.renamedFrom[entry.oldPath]) == 0 {
+			delete(idx.renamedFrom, entry.oldPath)
+
+			oldParent := path.Dir(entry.oldPath)
+			delete(idx.renamedAway[oldParent], entry.oldPath)
+			if len(idx.renamedAway[oldParent]) == 0 {
+				delete(idx.renamedAway, oldParent)
+			}
+		}
+	}
+
+	idx.age.Remove(entry.age)
+}
+
+// reset empties the index
+func (idx *stagingIndex) reset() {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.entries = make(map[string]*indexEntry)
+	idx.children = make(map[string]map[string]*StagingMetadata)
+	idx.renamedFrom = make(map[string]map[string]bool)
+	idx.renamedAway = make(map[string]map[string]bool)
+	idx.age.Init()
+}
+
+// getChildren returns the staged entries directly under dir
+func (idx *stagingIndex) getChildren(dir string) []*StagingMetadata {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	result := make([]*StagingMetadata, 0, len(idx.children[dir]))
+	for _, meta := range idx.children[dir] {
+		result = append(result, meta)
+	}
+	return result
+}
+
+// getSubtree returns the staged entries at and below dir
+func (idx *stagingIndex) getSubtree(dir string) []*StagingMetadata {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	result := []*StagingMetadata{}
+	if entry, ok := idx.entries[dir]; ok {
+		result = append(result, entry.meta)
+	}
+
+	prefix := subtreePrefix(dir)
+	for parent, children := range idx.children {
+		if parent != dir && !strings.HasPrefix(parent, prefix) {
+			continue
+		}
+		for _, meta := range children {
+			result = append(result, meta)
+		}
+	}
+	return result
+}
+
+// isRenamedFrom returns true if a rename entry moved p away
+func (idx *stagingIndex) isRenamedFrom(p string) bool {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return len(idx.renamedFrom[p]) > 0
+}
+
+// getRenamedAway returns the paths directly under dir that rename entries moved away
+func (idx *stagingIndex) getRenamedAway(dir string) []string {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	result := make([]string, 0, len(idx.renamedAway[dir]))
+	for oldPath := range idx.renamedAway[dir] {
+		result = append(result, oldPath)
+	}
+	return result
+}
+
+// getRenamedFromSubtree returns the rename entries whose OldPath is at or below dir
+func (idx *stagingIndex) getRenamedFromSubtree(dir string) []*StagingMetadata {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	result := []*StagingMetadata{}
+	for oldPath, paths := range idx.renamedFrom {
+		if !isInSubtree(oldPath, dir) {
+			continue
+		}
+		for p := range paths {
+			result = append(result, idx.entries[p].meta)
+		}
+	}
+	return result
+}
+
+// getModifiedBefore returns the staged entries last modified at or before cutoff, oldest first
+func (idx *stagingIndex) getModifiedBefore(cutoff time.Time) []*StagingMetadata {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	result := []*StagingMetadata{}
+	for elem := idx.age.Front(); elem != nil; elem = elem.Next() {
+		aged := elem.Value.(*ageEntry)
+		if aged.lastModifiedAt.After(cutoff) {
+			break
+		}
+		result = append(result, idx.entries[aged.path].meta)
+	}
+	return result
+}
+
+// getOldest returns the entry with the oldest LastModifiedAt, or nil
+func (idx *stagingIndex) getOldest() *StagingMetadata {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	elem := idx.age.Front()
+	if elem == nil {
+		return nil
+	}
+	return idx.entries[elem.Value.(*ageEntry).path].meta
+}
+
+// shardFor returns the shard holding path
+func (sm *StagingStateManager) shardFor(path string) *stagingShard {
+	h := fnv.New32a()
+	h.Write([]byte(path))
+	return sm.shards[h.Sum32()%stagingShardCount]
+}
+
+// lockShared takes mu shared and the shard of path once path is not locked by a sync and not
+// in a tree whose operation runs in withoutLock. Release with unlockShared.
+func (sm *StagingStateManager) lockShared(path string) *stagingShard {
+	shard := sm.shardFor(path)
+	for {
+		sm.mu.RLock()
+		shard.mu.Lock()
+
+		done, locked := shard.lockedPaths[path]
+		if !locked {
+			done, locked = sm.busyDone, sm.isBusy(path)
+		}
+		if !locked {
+			return shard
+		}
+
+		shard.mu.Unlock()
+		sm.mu.RUnlock()
+		<-done
+	}
+}
+
+// isBusy returns true if path is in a tree whose operation runs in withoutLock (caller must hold mu)
+func (sm *StagingStateManager) isBusy(path string) bool {
+	for _, busy := range sm.busyPaths {
+		if isInSubtree(path, busy) {
+			return true
+		}
+	}
+	return false
+}
+
+// withoutLock runs fn, usually a handler call doing network I/O, with mu released. The caller
+// holds mu exclusively and has it again when withoutLock returns. Operations on paths in the trees
+// of paths and other exclusive operations wait until fn returns, the rest of the state stays usable.
+func (sm *StagingStateManager) withoutLock(fn func() error, paths ...string) error {
+	done := make(chan struct{})
+	sm.busyPaths = paths
+	sm.busyDone = done
+	sm.mu.Unlock()
+
+	err := fn()
+
+	sm.mu.Lock()
+	sm.busyPaths = nil
+	sm.busyDone = nil
+	close(done)
+	return err
+}
+
+// unlockShared releases what lockShared took
+func (sm *StagingStateManager) unlockShared(shard *stagingShard) {
+	shard.mu.Unlock()
+	sm.mu.RUnlock()
+}
+
+// lockExclusive takes mu exclusively once no path matching waitFor is locked by a sync and no
+// other exclusive operation runs in withoutLock
+func (sm *StagingStateManager) lockExclusive(waitFor func(p string) bool) {
+	for {
+		sm.mu.Lock()
+
+		done := sm.busyDone
+		for _, shard := range sm.shards {
+			if done != nil {
+				break
+			}
+			for p, ch := range shard.lockedPaths {
+				if waitFor(p) {
+					done = ch
+					break
+				}
+			}
+		}
+
+		if done == nil {
+			return
+		}
+
+		sm.mu.Unlock()
+		<-done
+	}
+}
+
+// setMetadata stores meta in memory (caller must hold its shard or mu exclusively)
+func (sm *StagingStateManager) setMetadata(meta *StagingMetadata) {
+	sm.shardFor(meta.Path).metadata[meta.Path] = meta
+	sm.index.put(meta)
+}
+
+// unsetMetadata removes the metadata of path from memory (caller must hold its shard or mu exclusively)
+func (sm *StagingStateManager) unsetMetadata(path string) {
+	delete(sm.shardFor(path).metadata, path)
+	sm.index.delete(path)
+}
+
+// getMetadata returns the metadata of path (caller must hold its shard or mu exclusively)
+func (sm *StagingStateManager) getMetadata(path string) *StagingMetadata {
+	return sm.shardFor(path).metadata[path]
+}
+
+// GetChildren returns staged metadata of the entries directly under dir, sorted by path
+func (sm *StagingStateManager) GetChildren(dir string) []*StagingMetadata {
+	result := sm.index.getChildren(dir)
+	sortMetadataByPath(result)
+	return result
+}
+
+// IsRenamedFrom returns true if a pending RENAME or RENAME_DIR moved path away
+func (sm *StagingStateManager) IsRenamedFrom(path string) bool {
+	return sm.index.isRenamedFrom(path)
+}
+
+// GetRenamedAway returns the paths directly under dir that a pending RENAME or RENAME_DIR moved away, sorted
+func (sm *StagingStateManager) GetRenamedAway(dir string) []string {
+	result := sm.index.getRenamedAway(dir)
+	sort.Strings(result)
+	return result
+}
+
+// GetModifiedBefore returns staged metadata last modified at or before cutoff, oldest first
+func (sm *StagingStateManager) GetModifiedBefore(cutoff time.Time) []*StagingMetadata {
+	return sm.index.getModifiedBefore(cutoff)
+}
@@ -0,0 +1,479 @@
+package stagingfs
+
+import (
+	"fmt"
+	"math/rand"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func metadataPaths(metas []*StagingMetadata) string {
+	paths := []string{}
+	for _, meta := range metas {
+		paths = append(paths, meta.Path)
+	}
+	return strings.Join(paths, ",")
+}
+
+// checkIndexConsistency compares the indexes against the staged metadata
+func checkIndexConsistency(t *testing.T, sm *StagingStateManager) {
+	t.Helper()
+
+	all := sm.GetAll()
+
+	byParent := map[string][]string{}
+	for p := range all {
+		byParent[path.Dir(p)] = append(byParent[path.Dir(p)], p)
+	}
+	for parent, paths := range byParent {
+		sort.Strings(paths)
+		if got := metadataPaths(sm.GetChildren(parent)); got != strings.Join(paths, ",") {
+			t.Errorf("Expected children of %s to be %v, got %s", parent, paths, got)
+		}
+	}
+
+	aged := sm.GetModifiedBefore(time.Now().Add(time.Hour))
+	if len(aged) != len(all) {
+		t.Errorf("Expected %d items in the age index, got %d", len(all), len(aged))
+	}
+	for i := 1; i < len(aged); i++ {
+		if aged[i].LastModifiedAt.Before(aged[i-1].LastModifiedAt) {
+			t.Errorf("Expected age index ordered by LastModifiedAt, %s is before %s", aged[i].Path, aged[i-1].Path)
+		}
+	}
+	for _, meta := range aged {
+		if all[meta.Path] != meta {
+			t.Errorf("Expected age index to hold the current metadata of %s", meta.Path)
+		}
+	}
+}
+
+func TestStagingIndexChildren(t *testing.T) {
+	sm := NewStagingStateManager()
+
+	for _, p := range []string{"/d/a", "/d/b", "/d/sub/c", "/top"} {
+		if err := sm.Create(p); err != nil {
+			t.Fatalf("Failed to create %s: %v", p, err)
+		}
+	}
+	if err := sm.Mkdir("/d/sub"); err != nil {
+		t.Fatalf("Failed to mkdir: %v", err)
+	}
+
+	if got := metadataPaths(sm.GetChildren("/d")); got != "/d/a,/d/b,/d/sub" {
+		t.Errorf("Unexpected children of /d: %s", got)
+	}
+	if got := metadataPaths(sm.GetChildren("/")); got != "/top" {
+		t.Errorf("Unexpected children of /: %s", got)
+	}
+
+	if _, err := sm.Rename("/d/a", "/e/a"); err != nil {
+		t.Fatalf("Failed to rename: %v", err)
+	}
+	if err := sm.Delete("/d/b"); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+	if _, err := sm.RenameDir("/d/sub", "/e/sub"); err != nil {
+		t.Fatalf("Failed to rename dir: %v", err)
+	}
+
+	if got := metadataPaths(sm.GetChildren("/d")); got != "" {
+		t.Errorf("Expected no children of /d, got %s", got)
+	}
+	if got := metadataPaths(sm.GetChildren("/e")); got != "/e/a,/e/sub" {
+		t.Errorf("Unexpected children of /e: %s", got)
+	}
+	if got := metadataPaths(sm.GetChildren("/e/sub")); got != "/e/sub/c" {
+		t.Errorf("Unexpected children of /e/sub: %s", got)
+	}
+	if got := metadataPaths(sm.GetSubtree("/e")); got != "/e/a,/e/sub,/e/sub/c" {
+		t.Errorf("Unexpected subtree of /e: %s", got)
+	}
+
+	if _, err := sm.Rmdir("/e/sub"); err != nil {
+		t.Fatalf("Failed to rmdir: %v", err)
+	}
+	if got := metadataPaths(sm.GetSubtree("/e")); got != "/e/a" {
+		t.Errorf("Unexpected subtree of /e after rmdir: %s", got)
+	}
+
+	checkIndexConsistency(t, sm)
+}
+
+func TestStagingIndexRenamedFrom(t *testing.T) {
+	sm := NewStagingStateManager()
+
+	// Rename entries come from restored state
+	err := sm.persistMetadataPublic("/d/new", &StagingMetadata{
+		Path:           "/d/new",
+		OldPath:        "/d/old",
+		Action:         ActionRename,
+		LastModifiedAt: time.Now(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to persist: %v", err)
+	}
+
+	if !sm.IsRenamedFrom("/d/old") || sm.IsRenamedFrom("/d/new") {
+		t.Errorf("Expected only /d/old to be renamed from")
+	}
+	if got := sm.GetRenamedAway("/d"); len(got) != 1 || got[0] != "/d/old" {
+		t.Errorf("Expected /d/old renamed away from /d, got %v", got)
+	}
+
+	// Existing directory renamed: OldPath follows the directory
+	if _, err := sm.RenameDir("/d", "/x"); err != nil {
+		t.Fatalf("Failed to rename dir: %v", err)
+	}
+	if sm.IsRenamedFrom("/d/old") || !sm.IsRenamedFrom("/x/old") {
+		t.Errorf("Expected rename index to follow the directory")
+	}
+	if got := sm.GetRenamedAway("/x"); len(got) != 1 || got[0] != "/x/old" {
+		t.Errorf("Expected /x/old renamed away from /x, got %v", got)
+	}
+
+	if err := sm.deleteMetadataPublic("/x/new"); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+	if sm.IsRenamedFrom("/x/old") || len(sm.GetRenamedAway("/x")) != 0 {
+		t.Errorf("Expected rename index to be empty after removal")
+	}
+}
+
+func TestStagingIndexModifiedBefore(t *testing.T) {
+	sm := NewStagingStateManager()
+
+	base := time.Now().Add(-time.Hour)
+	for i, p := range []string{"/c", "/a", "/b"} {
+		err := sm.persistMetadataPublic(p, &StagingMetadata{
+			Path:           p,
+			Action:         ActionUpload,
+			LastModifiedAt: base.Add(time.Duration(2-i) * time.Minute),
+		})
+		if err != nil {
+			t.Fatalf("Failed to persist: %v", err)
+		}
+	}
+
+	if got := metadataPaths(sm.GetModifiedBefore(base.Add(time.Minute))); got != "/b,/a" {
+		t.Errorf("Expected oldest items first, got %s", got)
+	}
+
+	// A modification makes the item the youngest
+	if err := sm.Modify("/b"); err != nil {
+		t.Fatalf("Failed to modify: %v", err)
+	}
+	if got := metadataPaths(sm.GetModifiedBefore(time.Now())); got != "/a,/c,/b" {
+		t.Errorf("Expected modified item last, got %s", got)
+	}
+	if got := metadataPaths(sm.GetModifiedBefore(base.Add(5 * time.Minute))); got != "/a,/c" {
+		t.Errorf("Expected modified item to be excluded, got %s", got)
+	}
+
+	if err := sm.SyncOld(30 * time.Minute); err != nil {
+		t.Fatalf("Failed to sync: %v", err)
+	}
+	if got := metadataPaths(sm.GetModifiedBefore(time.Now())); got != "/b" {
+		t.Errorf("Expected only the young item left, got %s", got)
+	}
+}
+
+func TestStagingFSTruncateUpdatesAgeIndex(t *testing.T) {
+	sf, _ := newPriorityTestFS(t, &StagingFSConfig{GracePeriod: time.Hour})
+	writeStagedFile(t, sf, "/a.txt", 8)
+	writeStagedFile(t, sf, "/b.txt", 8)
+
+	before := sf.Get("/a.txt")
+	modifiedAt := before.LastModifiedAt
+	time.Sleep(time.Millisecond)
+
+	if err := sf.TruncateFile("/a.txt", 4); err != nil {
+		t.Fatalf("Failed to truncate: %v", err)
+	}
+
+	// The metadata is replaced, not changed under readers holding it
+	if !before.LastModifiedAt.Equal(modifiedAt) {
+		t.Errorf("Expected metadata held by readers to stay unchanged")
+	}
+	if !sf.Get("/a.txt").LastModifiedAt.After(modifiedAt) {
+		t.Errorf("Expected truncate to update LastModifiedAt")
+	}
+	if got := metadataPaths(sf.sm.GetModifiedBefore(time.Now())); got != "/b.txt,/a.txt" {
+		t.Errorf("Expected truncated item last in the age index, got %s", got)
+	}
+	checkIndexConsistency(t, sf.sm)
+}
+
+func TestStagingIndexRestoreOrder(t *testing.T) {
+	store := NewMemoryMetadataStore()
+	sm := NewStagingStateManagerWithStore(store)
+
+	// Paths sort in the opposite order of their modification times
+	base := time.Now().Add(-time.Hour)
+	for i := 0; i < 1000; i++ {
+		p := fmt.Sprintf("/f%04d", i)
+		err := sm.persistMetadataPublic(p, &StagingMetadata{
+			Path:           p,
+			Action:         ActionUpload,
+			LastModifiedAt: base.Add(-time.Duration(i) * time.Second),
+		})
+		if err != nil {
+			t.Fatalf("Failed to persist: %v", err)
+		}
+	}
+
+	restored := NewStagingStateManagerWithStore(store)
+	if err := restored.Restore(); err != nil {
+		t.Fatalf("Failed to restore: %v", err)
+	}
+
+	checkIndexConsistency(t, restored)
+	if oldest := restored.index.getOldest(); oldest == nil || oldest.Path != "/f0999" {
+		t.Errorf("Expected /f0999 to be the oldest item, got %v", oldest)
+	}
+}
+
+func TestStagingSyncDoesNotBlockOtherPaths(t *testing.T) {
+	sm := NewStagingStateManager()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	sm.RegisterActionHandler(func(meta *StagingMetadata) error {
+		if meta.Path == "/slow" {
+			close(started)
+			<-release
+		}
+		return nil
+	})
+
+	if err := sm.Create("/slow"); err != nil {
+		t.Fatalf("Failed to create: %v", err)
+	}
+
+	syncDone := make(chan error)
+	go func() {
+		_, err := sm.syncOne(sm.Get("/slow"))
+		syncDone <- err
+	}()
+	<-started
+
+	// Other paths go ahead while /slow is uploading
+	for i := 0; i < 10; i++ {
+		if err := sm.Create(fmt.Sprintf("/other%d", i)); err != nil {
+			t.Fatalf("Failed to create: %v", err)
+		}
+	}
+	if got := len(sm.GetChildren("/")); got != 11 {
+		t.Errorf("Expected 11 staged items, got %d", got)
+	}
+
+	// The synced path itself waits
+	modified := make(chan error)
+	go func() {
+		modified <- sm.Modify("/slow")
+	}()
+
+	select {
+	case <-modified:
+		t.Fatalf("Expected modify to wait for the sync")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-syncDone; err != nil {
+		t.Fatalf("Failed to sync: %v", err)
+	}
+	if err := <-modified; err != nil {
+		t.Fatalf("Failed to modify: %v", err)
+	}
+	if meta := sm.Get("/slow"); meta == nil || meta.IsNew {
+		t.Errorf("Expected /slow restaged as an existing file, got %+v", meta)
+	}
+}
+
+func TestStagingRenameDoesNotBlockOtherPaths(t *testing.T) {
+	sm := NewStagingStateManager()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	sm.RegisterActionHandler(func(meta *StagingMetadata) error {
+		if meta.Action == ActionRenameDir {
+			close(started)
+			<-release
+		}
+		return nil
+	})
+
+	if err := sm.Create("/src/f"); err != nil {
+		t.Fatalf("Failed to create: %v", err)
+	}
+
+	renameDone := make(chan error)
+	go func() {
+		_, err := sm.RenameDir("/src", "/dst")
+		renameDone <- err
+	}()
+	<-started
+
+	// Paths outside the renamed trees go ahead while iRODS renames the directory
+	if err := sm.Create("/other"); err != nil {
+		t.Fatalf("Failed to create: %v", err)
+	}
+	if meta := sm.Get("/other"); meta == nil {
+		t.Errorf("Expected /other to be staged")
+	}
+
+	// Paths in the renamed trees wait
+	created := make(chan error)
+	go func() {
+		created <- sm.Create("/dst/g")
+	}()
+
+	select {
+	case <-created:
+		t.Fatalf("Expected create in the renamed tree to wait for the rename")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-renameDone; err != nil {
+		t.Fatalf("Failed to rename: %v", err)
+	}
+	if err := <-created; err != nil {
+		t.Fatalf("Failed to create: %v", err)
+	}
+	if got := metadataPaths(sm.GetChildren("/dst")); got != "/dst/f,/dst/g" {
+		t.Errorf("Expected /dst/f,/dst/g, got %s", got)
+	}
+}
+
+func TestStagingSyncOneKeepsEntryReplacedDuringSync(t *testing.T) {
+	sm := NewStagingStateManager()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	sm.RegisterActionHandler(func(meta *StagingMetadata) error {
+		if meta.Path == "/d/f" && meta.Action == ActionUpload {
+			close(started)
+			<-release
+		}
+		return nil
+	})
+
+	if err := sm.Create("/d/f"); err != nil {
+		t.Fatalf("Failed to create: %v", err)
+	}
+	stale := sm.Get("/d/f")
+
+	// A sync of a stale copy syncs the current entry
+	if err := sm.Modify("/d/f"); err != nil {
+		t.Fatalf("Failed to modify: %v", err)
+	}
+	current := sm.Get("/d/f")
+
+	syncDone := make(chan error)
+	go func() {
+		synced, err := sm.syncOne(stale)
+		if err == nil && synced {
+			err = fmt.Errorf("expected the replaced entry not to be reported as synced")
+		}
+		syncDone <- err
+	}()
+	<-started
+
+	// Replace the entry while it is uploading, as a tree operation does
+	sm.mu.Lock()
+	replaced := *current
+	replaced.LastModifiedAt = time.Now()
+	sm.setMetadata(&replaced)
+	sm.mu.Unlock()
+
+	close(release)
+	if err := <-syncDone; err != nil {
+		t.Fatalf("Failed to sync: %v", err)
+	}
+	if meta := sm.Get("/d/f"); meta != &replaced {
+		t.Errorf("Expected the replacing entry to stay staged, got %+v", meta)
+	}
+
+	// Nothing is synced once the path is gone
+	if err := sm.Delete("/d/f"); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+	if synced, err := sm.syncOne(stale); err != nil || synced {
+		t.Errorf("Expected no sync of a removed entry, got %v/%v", synced, err)
+	}
+}
+
+func TestStagingRecordSyncFailureConcurrently(t *testing.T) {
+	sm := NewStagingStateManager()
+	if err := sm.Create("/f"); err != nil {
+		t.Fatalf("Failed to create: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, err := sm.recordSyncFailure("/f", 100); err != nil {
+				t.Errorf("Failed to record sync failure: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if meta := sm.Get("/f"); meta == nil || meta.SyncFailCount != 20 {
+		t.Errorf("Expected 20 recorded failures, got %+v", meta)
+	}
+
+	meta, failed, err := sm.recordSyncFailure("/missing", 100)
+	if meta != nil || failed || err != nil {
+		t.Errorf("Expected nothing recorded for an unstaged path, got %v/%v/%v", meta, failed, err)
+	}
+}
+
+func TestStagingIndexConcurrentOperations(t *testing.T) {
+	sm := NewStagingStateManager()
+	sm.RegisterActionHandler(func(meta *StagingMetadata) error {
+		return nil
+	})
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(int64(w)))
+
+			for i := 0; i < 300; i++ {
+				p := fmt.Sprintf("/d%d/f%d", r.Intn(4), r.Intn(8))
+				switch r.Intn(7) {
+				case 0:
+					sm.Create(p)
+				case 1:
+					sm.Modify(p)
+				case 2:
+					sm.Delete(p)
+				case 3:
+					sm.Rename(p, fmt.Sprintf("/d%d/f%d", r.Intn(4), r.Intn(8)))
+				case 4:
+					if meta := sm.Get(p); meta != nil {
+						sm.syncOne(meta)
+					}
+				case 5:
+					sm.GetChildren(fmt.Sprintf("/d%d", r.Intn(4)))
+				case 6:
+					sm.SyncOld(time.Hour)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	checkIndexConsistency(t, sm)
+}
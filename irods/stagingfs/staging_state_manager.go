@@ -1,9 +1,10 @@
 package stagingfs
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"strings"
+	"sort"
 	"sync"
 	"time"
 
@@ -48,57 +49,71 @@ func (a ActionType) String() string {
 
 // StagingMetadata represents the state of a staged file
 type StagingMetadata struct {
-	Path           string     // Current path
-	OldPath        string     // Old path (for RENAME actions)
-	Action         ActionType // Final action
-	IsNew          bool       // Is this a new file?
-	CreatedAt      time.Time  // Creation time
-	LastModifiedAt time.Time  // Last modification time
-	SyncFailCount  int        // Number of consecutive sync failures
+	Path           string       // Current path
+	OldPath        string       // Old path (for RENAME actions)
+	Action         ActionType   // Final action
+	IsNew          bool         // Is this a new file?
+	CreatedAt      time.Time    // Creation time
+	LastModifiedAt time.Time    // Last modification time
+	SyncFailCount  int          // Number of consecutive sync failures
+	Priority       SyncPriority // Explicit priority (PriorityDefault = resolved from rules and size)
+
+	SymlinkTarget string            // Target of a staged symlink (empty = not a symlink)
+	Xattrs        map[string][]byte // Extended attributes to set after sync (nil value = remove)
 }
 
-// StagingStateManager manages staging metadata for async uploads
+// StagingStateManager manages staging metadata for async uploads.
+//
+// Staged paths are hashed into shards with their own locks. Operations on a single path hold mu
+// shared plus the shard of the path, so FUSE threads working on different paths do not contend.
+// Operations spanning several paths (renames, rmdir, clear, restore) hold mu exclusively.
+// Secondary indexes serve listings, rename checks and grace period scans without visiting every
+// staged item. Lock order: mu, then a shard, then the index or auxMu.
 type StagingStateManager struct {
-	metadata      map[string]*StagingMetadata
-	lockedPaths   map[string]bool       // Paths locked during sync operations
-	pathConds     map[string]*sync.Cond // Per-path condition variables
-	db            *badger.DB
+	shards        [stagingShardCount]*stagingShard
+	index         *stagingIndex
+	checkpoints   map[string]*UploadCheckpoint // Progress of checkpointed uploads
+	temps         map[string]*TempUpload       // Temporary objects of atomic replace uploads
+	auxMu         sync.Mutex                   // Guards checkpoints and temps
+	store         MetadataStore
+	batcher       *metadataBatcher // nil in strict durability mode
 	mu            sync.RWMutex
 	ActionHandler ActionHandler
+
+	// Trees whose operation calls the handler with mu released, see withoutLock (guarded by mu)
+	busyPaths []string
+	busyDone  chan struct{}
 }
 
 // NewStagingStateManager creates a new manager (memory only)
 func NewStagingStateManager() *StagingStateManager {
-	return &StagingStateManager{
-		metadata:    make(map[string]*StagingMetadata),
-		lockedPaths: make(map[string]bool),
-		pathConds:   make(map[string]*sync.Cond),
-		db:          nil,
-	}
+	return NewStagingStateManagerWithStore(nil)
 }
 
 // NewStagingStateManagerWithPersistence creates a new manager with Badger persistence
 func NewStagingStateManagerWithPersistence(db *badger.DB) *StagingStateManager {
-	return &StagingStateManager{
-		metadata:    make(map[string]*StagingMetadata),
-		lockedPaths: make(map[string]bool),
-		pathConds:   make(map[string]*sync.Cond),
-		db:          db,
+	return NewStagingStateManagerWithStore(NewBadgerMetadataStoreWithDB(db))
+}
+
+// NewStagingStateManagerWithStore creates a new manager persisting to the given MetadataStore
+func NewStagingStateManagerWithStore(store MetadataStore) *StagingStateManager {
+	sm := &StagingStateManager{
+		index:       newStagingIndex(),
+		checkpoints: make(map[string]*UploadCheckpoint),
+		temps:       make(map[string]*TempUpload),
+		store:       store,
 	}
+	for i := range sm.shards {
+		sm.shards[i] = newStagingShard()
+	}
+	return sm
 }
 
 // Create marks a path as newly created
 func (sm *StagingStateManager) Create(path string) error {
-	sm.mu.Lock()
-	defer sm.mu.Unlock()
-
 	// Wait for path to be unlocked if it's locked during sync
-	for sm.lockedPaths[path] {
-		if sm.pathConds[path] == nil {
-			sm.pathConds[path] = sync.NewCond(&sm.mu)
-		}
-		sm.pathConds[path].Wait()
-	}
+	shard := sm.lockShared(path)
+	defer sm.unlockShared(shard)
 
 	now := time.Now()
 	meta := &StagingMetadata{
@@ -113,24 +128,17 @@ func (sm *StagingStateManager) Create(path string) error {
 
 // Modify marks a path as modified
 func (sm *StagingStateManager) Modify(path string) error {
-	sm.mu.Lock()
-	defer sm.mu.Unlock()
-
 	// Wait for path to be unlocked if it's locked during sync
-	for sm.lockedPaths[path] {
-		if sm.pathConds[path] == nil {
-			sm.pathConds[path] = sync.NewCond(&sm.mu)
-		}
-		sm.pathConds[path].Wait()
-	}
+	shard := sm.lockShared(path)
+	defer sm.unlockShared(shard)
 
-	meta, exists := sm.metadata[path]
+	meta := sm.getMetadata(path)
 
-	if exists && !sm.isValidAction(meta.Action, ActionUpload) {
-		return errors.Newf("cannot modify %s: invalid action transition from %s to UPLOAD", path, meta.Action)
+	if meta != nil && !sm.isValidAction(meta.Action, ActionUpload) {
+		return errors.Wrapf(ErrInvalidTransition, "cannot modify %s: from %s to UPLOAD", path, meta.Action)
 	}
 
-	if !exists {
+	if meta == nil {
 		// Existing file
 		now := time.Now()
 		meta = &StagingMetadata{
@@ -141,58 +149,56 @@ func (sm *StagingStateManager) Modify(path string) error {
 			LastModifiedAt: now,
 		}
 	} else {
-		meta.Action = ActionUpload
-		meta.LastModifiedAt = time.Now()
+		// Readers may hold the current metadata, replace it instead of changing it in place
+		copied := *meta
+		copied.Action = ActionUpload
+		copied.LastModifiedAt = time.Now()
+		meta = &copied
 	}
 
 	return sm.persistMetadata(path, meta)
 }
 
+// Touch resets the grace period of a staged path by updating its LastModifiedAt. Unstaged paths are left alone.
+func (sm *StagingStateManager) Touch(path string) error {
+	shard := sm.lockShared(path)
+	defer sm.unlockShared(shard)
+
+	meta := sm.getMetadata(path)
+	if meta == nil {
+		return nil
+	}
+
+	// Readers may hold the current metadata, replace it instead of changing it in place
+	copied := *meta
+	copied.LastModifiedAt = time.Now()
+	return sm.persistMetadata(path, &copied)
+}
+
 // Rename renames a file
 // Returns true if immediate sync was performed (for existing files)
 func (sm *StagingStateManager) Rename(oldPath, newPath string) (bool, error) {
-	sm.mu.Lock()
-	defer sm.mu.Unlock()
-
 	// Wait for both paths to be unlocked if they're locked during sync
-	for sm.lockedPaths[oldPath] || sm.lockedPaths[newPath] {
-		if sm.pathConds[oldPath] == nil {
-			sm.pathConds[oldPath] = sync.NewCond(&sm.mu)
-		}
-		if sm.pathConds[newPath] == nil {
-			sm.pathConds[newPath] = sync.NewCond(&sm.mu)
-		}
-		// Wait on oldPath condition (will be signaled when either path is unlocked)
-		sm.pathConds[oldPath].Wait()
-	}
+	sm.lockExclusive(func(p string) bool {
+		return p == oldPath || p == newPath
+	})
+	defer sm.mu.Unlock()
 
-	meta, exists := sm.metadata[oldPath]
+	meta := sm.getMetadata(oldPath)
+	handler := sm.ActionHandler
 
-	if !exists {
+	if meta == nil {
 		// Existing file without metadata → immediate RENAME
-		// Lock both paths during sync
-		sm.lockedPaths[oldPath] = true
-		sm.lockedPaths[newPath] = true
-		// Unlock after sync completes and signal waiting goroutines
-		defer func() {
-			delete(sm.lockedPaths, oldPath)
-			delete(sm.lockedPaths, newPath)
-			if sm.pathConds[oldPath] != nil {
-				sm.pathConds[oldPath].Broadcast()
-			}
-			if sm.pathConds[newPath] != nil {
-				sm.pathConds[newPath].Broadcast()
-			}
-		}()
-
-		// Call handler immediately
-		if sm.ActionHandler != nil {
-			err := sm.ActionHandler(&StagingMetadata{
-				Path:    newPath,
-				OldPath: oldPath,
-				Action:  ActionRename,
-				IsNew:   false,
-			})
+		// Operations on both paths wait while the handler runs
+		if handler != nil {
+			err := sm.withoutLock(func() error {
+				return handler(&StagingMetadata{
+					Path:    newPath,
+					OldPath: oldPath,
+					Action:  ActionRename,
+					IsNew:   false,
+				})
+			}, oldPath, newPath)
 			if err != nil {
 				return false, errors.Wrap(err, "handler failed for immediate RENAME sync")
 			}
@@ -203,42 +209,33 @@ func (sm *StagingStateManager) Rename(oldPath, newPath string) (bool, error) {
 	// IsNew=false and Action=ActionUpload (modified)
 	if !meta.IsNew && meta.Action == ActionUpload {
 		// Perform preceding action (PUT) then RENAME
-		// Lock both paths during sync
-		sm.lockedPaths[oldPath] = true
-		sm.lockedPaths[newPath] = true
-		// Unlock after sync completes and signal waiting goroutines
-		defer func() {
-			delete(sm.lockedPaths, oldPath)
-			delete(sm.lockedPaths, newPath)
-			if sm.pathConds[oldPath] != nil {
-				sm.pathConds[oldPath].Broadcast()
-			}
-			if sm.pathConds[newPath] != nil {
-				sm.pathConds[newPath].Broadcast()
-			}
-		}()
-
-		// Call handler for PUT and RENAME
-		if sm.ActionHandler != nil {
-			// First call PUT action
-			err := sm.ActionHandler(&StagingMetadata{
-				Path:   oldPath,
-				Action: ActionUpload,
-				IsNew:  false,
-			})
-			if err != nil {
-				return false, errors.Wrap(err, "handler failed for PUT action before RENAME")
-			}
+		if handler != nil {
+			err := sm.withoutLock(func() error {
+				// First call PUT action
+				err := handler(&StagingMetadata{
+					Path:   oldPath,
+					Action: ActionUpload,
+					IsNew:  false,
+					Xattrs: meta.Xattrs,
+				})
+				if err != nil {
+					return errors.Wrap(err, "handler failed for PUT action before RENAME")
+				}
 
-			// Then call RENAME action
-			err = sm.ActionHandler(&StagingMetadata{
-				Path:    newPath,
-				OldPath: oldPath,
-				Action:  ActionRename,
-				IsNew:   false,
-			})
+				// Then call RENAME action
+				err = handler(&StagingMetadata{
+					Path:    newPath,
+					OldPath: oldPath,
+					Action:  ActionRename,
+					IsNew:   false,
+				})
+				if err != nil {
+					return errors.Wrap(err, "handler failed for RENAME action")
+				}
+				return nil
+			}, oldPath, newPath)
 			if err != nil {
-				return false, errors.Wrap(err, "handler failed for RENAME action")
+				return false, err
 			}
 		}
 
@@ -250,16 +247,18 @@ func (sm *StagingStateManager) Rename(oldPath, newPath string) (bool, error) {
 	}
 
 	// IsNew=true case: change path only
-	meta.Path = newPath
-	meta.LastModifiedAt = time.Now()
-
-	delete(sm.metadata, oldPath)
-	sm.metadata[newPath] = meta
+	copied := *meta
+	copied.Path = newPath
+	copied.LastModifiedAt = time.Now()
 
+	// A partial upload at the old path cannot be resumed at the new path
+	if err := sm.deleteCheckpoint(oldPath); err != nil {
+		return false, err
+	}
 	if err := sm.deleteMetadata(oldPath); err != nil {
 		return false, err
 	}
-	if err := sm.persistMetadata(newPath, meta); err != nil {
+	if err := sm.persistMetadata(newPath, &copied); err != nil {
 		return false, err
 	}
 
@@ -269,109 +268,57 @@ func (sm *StagingStateManager) Rename(oldPath, newPath string) (bool, error) {
 // RenameDir renames a directory
 // Returns true if immediate sync was performed (for existing directories)
 func (sm *StagingStateManager) RenameDir(oldPath, newPath string) (bool, error) {
-	sm.mu.Lock()
+	// Wait for both subtrees to be unlocked if any of their entries are being synced
+	sm.lockExclusive(func(p string) bool {
+		return isInSubtree(p, oldPath) || isInSubtree(p, newPath)
+	})
 	defer sm.mu.Unlock()
 
-	// Wait for both paths to be unlocked if they're locked during sync
-	for sm.lockedPaths[oldPath] || sm.lockedPaths[newPath] {
-		if sm.pathConds[oldPath] == nil {
-			sm.pathConds[oldPath] = sync.NewCond(&sm.mu)
-		}
-		if sm.pathConds[newPath] == nil {
-			sm.pathConds[newPath] = sync.NewCond(&sm.mu)
-		}
-		sm.pathConds[oldPath].Wait()
-	}
-
-	meta, exists := sm.metadata[oldPath]
-
-	if !exists {
+	if sm.getMetadata(oldPath) == nil {
 		// Existing directory without metadata → immediate RENAME
-		sm.lockedPaths[oldPath] = true
-		sm.lockedPaths[newPath] = true
-		defer func() {
-			delete(sm.lockedPaths, oldPath)
-			delete(sm.lockedPaths, newPath)
-			if sm.pathConds[oldPath] != nil {
-				sm.pathConds[oldPath].Broadcast()
-			}
-			if sm.pathConds[newPath] != nil {
-				sm.pathConds[newPath].Broadcast()
-			}
-		}()
-
-		// Call handler immediately
-		if sm.ActionHandler != nil {
-			err := sm.ActionHandler(&StagingMetadata{
-				Path:    newPath,
-				OldPath: oldPath,
-				Action:  ActionRenameDir,
-				IsNew:   false,
-			})
+		// Operations in both subtrees wait while the handler runs
+		if handler := sm.ActionHandler; handler != nil {
+			err := sm.withoutLock(func() error {
+				return handler(&StagingMetadata{
+					Path:    newPath,
+					OldPath: oldPath,
+					Action:  ActionRenameDir,
+					IsNew:   false,
+				})
+			}, oldPath, newPath)
 			if err != nil {
 				return false, errors.Wrap(err, "handler failed for immediate RENAME_DIR sync")
 			}
 		}
+
+		// Staged descendants follow the directory to its new location
+		if err := sm.rewriteSubtree(oldPath, newPath, time.Now()); err != nil {
+			return true, err
+		}
 		return true, nil
 	}
 
-	// IsNew=true case: change path and update all children
-	now := time.Now()
-	meta.Path = newPath
-	meta.LastModifiedAt = now
-
-	delete(sm.metadata, oldPath)
-	sm.metadata[newPath] = meta
-
-	if err := sm.deleteMetadata(oldPath); err != nil {
-		return false, err
-	}
-	if err := sm.persistMetadata(newPath, meta); err != nil {
+	// IsNew=true case: move the directory and all its descendants
+	if err := sm.rewriteSubtree(oldPath, newPath, time.Now()); err != nil {
 		return false, err
 	}
 
-	// Update all child entries under oldPath
-	oldPrefix := oldPath + "/"
-	for childPath, childMeta := range sm.metadata {
-		if strings.HasPrefix(childPath, oldPrefix) {
-			newChildPath := newPath + "/" + childPath[len(oldPrefix):]
-			childMeta.Path = newChildPath
-			childMeta.LastModifiedAt = now
-			if childMeta.OldPath != "" && strings.HasPrefix(childMeta.OldPath, oldPrefix) {
-				childMeta.OldPath = newPath + "/" + childMeta.OldPath[len(oldPrefix):]
-			}
-
-			delete(sm.metadata, childPath)
-			sm.metadata[newChildPath] = childMeta
-
-			sm.deleteMetadata(childPath)
-			sm.persistMetadata(newChildPath, childMeta)
-		}
-	}
-
 	return false, nil
 }
 
 // Delete marks a path as deleted (file deletion only)
 func (sm *StagingStateManager) Delete(path string) error {
-	sm.mu.Lock()
-	defer sm.mu.Unlock()
-
 	// Wait for path to be unlocked if it's locked during sync
-	for sm.lockedPaths[path] {
-		if sm.pathConds[path] == nil {
-			sm.pathConds[path] = sync.NewCond(&sm.mu)
-		}
-		sm.pathConds[path].Wait()
-	}
+	shard := sm.lockShared(path)
+	defer sm.unlockShared(shard)
 
-	meta, exists := sm.metadata[path]
+	meta := sm.getMetadata(path)
 
-	if exists && !sm.isValidAction(meta.Action, ActionDelete) {
-		return errors.Newf("cannot delete %s: invalid action transition from %s to DELETE", path, meta.Action)
+	if meta != nil && !sm.isValidAction(meta.Action, ActionDelete) {
+		return errors.Wrapf(ErrInvalidTransition, "cannot delete %s: from %s to DELETE", path, meta.Action)
 	}
 
-	if !exists {
+	if meta == nil {
 		// Direct deletion of existing file
 		now := time.Now()
 		meta = &StagingMetadata{
@@ -381,16 +328,24 @@ func (sm *StagingStateManager) Delete(path string) error {
 			CreatedAt:      now,
 			LastModifiedAt: now,
 		}
-		sm.metadata[path] = meta
 	} else if meta.IsNew {
 		// CREATE → DELETE: remove metadata
+		if err := sm.deleteCheckpoint(path); err != nil {
+			return err
+		}
 		if err := sm.deleteMetadata(path); err != nil {
 			return err
 		}
 		return nil
 	} else {
-		meta.Action = ActionDelete
-		meta.LastModifiedAt = time.Now()
+		copied := *meta
+		copied.Action = ActionDelete
+		copied.LastModifiedAt = time.Now()
+		meta = &copied
+	}
+
+	if err := sm.deleteCheckpoint(path); err != nil {
+		return err
 	}
 
 	return sm.persistMetadata(path, meta)
@@ -398,16 +353,9 @@ func (sm *StagingStateManager) Delete(path string) error {
 
 // Mkdir marks a directory as created
 func (sm *StagingStateManager) Mkdir(path string) error {
-	sm.mu.Lock()
-	defer sm.mu.Unlock()
-
 	// Wait for path to be unlocked if it's locked during sync
-	for sm.lockedPaths[path] {
-		if sm.pathConds[path] == nil {
-			sm.pathConds[path] = sync.NewCond(&sm.mu)
-		}
-		sm.pathConds[path].Wait()
-	}
+	shard := sm.lockShared(path)
+	defer sm.unlockShared(shard)
 
 	now := time.Now()
 	meta := &StagingMetadata{
@@ -423,51 +371,55 @@ func (sm *StagingStateManager) Mkdir(path string) error {
 // Rmdir marks a directory as removed
 // Returns true if immediate sync was performed (for existing directories)
 func (sm *StagingStateManager) Rmdir(path string) (bool, error) {
-	sm.mu.Lock()
+	// Wait for the subtree and renames out of it to be unlocked if any of them are being synced
+	sm.lockExclusive(func(p string) bool {
+		return isInSubtree(p, path) || sm.isRenamedOutOf(p, path)
+	})
 	defer sm.mu.Unlock()
 
-	// Wait for path to be unlocked if it's locked during sync
-	for sm.lockedPaths[path] {
-		if sm.pathConds[path] == nil {
-			sm.pathConds[path] = sync.NewCond(&sm.mu)
-		}
-		sm.pathConds[path].Wait()
-	}
+	meta := sm.getMetadata(path)
 
-	meta, exists := sm.metadata[path]
+	if meta != nil && !sm.isValidAction(meta.Action, ActionRmdir) {
+		return false, errors.Wrapf(ErrInvalidTransition, "cannot remove directory %s: from %s to RMDIR", path, meta.Action)
+	}
 
-	if exists && !sm.isValidAction(meta.Action, ActionRmdir) {
-		return false, errors.Newf("cannot remove directory %s: invalid action transition from %s to RMDIR", path, meta.Action)
+	if meta == nil || !meta.IsNew {
+		// The recursive removal would delete the sources of pending renames out of the directory
+		if err := sm.syncRenamesOutOf(path); err != nil {
+			return false, err
+		}
 	}
 
-	if !exists {
+	if meta == nil {
 		// Existing directory without metadata → immediate RMDIR
-		sm.lockedPaths[path] = true
-		defer func() {
-			delete(sm.lockedPaths, path)
-			if sm.pathConds[path] != nil {
-				sm.pathConds[path].Broadcast()
-			}
-		}()
-
-		// Call handler immediately
-		if sm.ActionHandler != nil {
-			err := sm.ActionHandler(&StagingMetadata{
-				Path:           path,
-				Action:         ActionRmdir,
-				IsNew:          false,
-				CreatedAt:      time.Now(),
-				LastModifiedAt: time.Now(),
-			})
+		// Operations in the subtree wait while the handler runs
+		if handler := sm.ActionHandler; handler != nil {
+			err := sm.withoutLock(func() error {
+				return handler(&StagingMetadata{
+					Path:           path,
+					Action:         ActionRmdir,
+					IsNew:          false,
+					CreatedAt:      time.Now(),
+					LastModifiedAt: time.Now(),
+				})
+			}, path)
 			if err != nil {
 				return false, errors.Wrap(err, "handler failed for immediate RMDIR sync")
 			}
 		}
+
+		// Pending operations below the removed directory must not recreate it
+		if _, err := sm.cancelSubtree(path); err != nil {
+			return true, err
+		}
 		return true, nil
 	}
 
 	if meta.IsNew {
-		// MKDIR → RMDIR: remove metadata
+		// MKDIR → RMDIR: remove metadata of the directory and everything staged under it
+		if _, err := sm.cancelSubtree(path); err != nil {
+			return false, err
+		}
 		if err := sm.deleteMetadata(path); err != nil {
 			return false, err
 		}
@@ -475,29 +427,25 @@ func (sm *StagingStateManager) Rmdir(path string) (bool, error) {
 	}
 
 	// Existing directory with MKDIR metadata → immediate RMDIR
-	sm.lockedPaths[path] = true
-	defer func() {
-		delete(sm.lockedPaths, path)
-		if sm.pathConds[path] != nil {
-			sm.pathConds[path].Broadcast()
-		}
-	}()
-
-	// Call handler immediately
-	if sm.ActionHandler != nil {
-		err := sm.ActionHandler(&StagingMetadata{
-			Path:           path,
-			Action:         ActionRmdir,
-			IsNew:          false,
-			CreatedAt:      meta.CreatedAt,
-			LastModifiedAt: time.Now(),
-		})
+	if handler := sm.ActionHandler; handler != nil {
+		err := sm.withoutLock(func() error {
+			return handler(&StagingMetadata{
+				Path:           path,
+				Action:         ActionRmdir,
+				IsNew:          false,
+				CreatedAt:      meta.CreatedAt,
+				LastModifiedAt: time.Now(),
+			})
+		}, path)
 		if err != nil {
 			return false, errors.Wrap(err, "handler failed for immediate RMDIR sync")
 		}
 	}
 
-	// Remove metadata
+	// Remove metadata of the directory and everything staged under it
+	if _, err := sm.cancelSubtree(path); err != nil {
+		return false, err
+	}
 	if err := sm.deleteMetadata(path); err != nil {
 		return false, err
 	}
@@ -506,9 +454,14 @@ func (sm *StagingStateManager) Rmdir(path string) (bool, error) {
 
 // Get retrieves metadata for a path
 func (sm *StagingStateManager) Get(path string) *StagingMetadata {
+	shard := sm.shardFor(path)
+
 	sm.mu.RLock()
 	defer sm.mu.RUnlock()
-	return sm.metadata[path]
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	return shard.metadata[path]
 }
 
 // GetAll returns all staged metadata
@@ -517,84 +470,106 @@ func (sm *StagingStateManager) GetAll() map[string]*StagingMetadata {
 	defer sm.mu.RUnlock()
 
 	result := make(map[string]*StagingMetadata)
-	for k, v := range sm.metadata {
-		result[k] = v
+	for _, shard := range sm.shards {
+		shard.mu.Lock()
+		for k, v := range shard.metadata {
+			result[k] = v
+		}
+		shard.mu.Unlock()
 	}
 	return result
 }
 
 // syncOne performs handler call and removes metadata for a single path with internal locking
-// Acquires and releases lock for the path
-func (sm *StagingStateManager) syncOne(meta *StagingMetadata) error {
-	// Wait for path to be unlocked if it's locked by another sync operation
-	sm.mu.Lock()
-	for sm.lockedPaths[meta.Path] {
-		if sm.pathConds[meta.Path] == nil {
-			sm.pathConds[meta.Path] = sync.NewCond(&sm.mu)
-		}
-		sm.pathConds[meta.Path].Wait()
+// Acquires and releases lock for the path. meta may be a stale copy; the current metadata of its
+// path is synced. Returns true if it was synced and removed, false if the path is no longer staged
+// or its metadata was replaced during the sync.
+func (sm *StagingStateManager) syncOne(meta *StagingMetadata) (bool, error) {
+	// Wait for path to be unlocked if it's locked by another sync operation, then lock it
+	shard := sm.lockShared(meta.Path)
+	meta = sm.getMetadata(meta.Path)
+	if meta == nil {
+		sm.unlockShared(shard)
+		return false, nil
 	}
+	shard.lockPath(meta.Path)
+	handler := sm.ActionHandler
+	sm.unlockShared(shard)
 
-	// Lock the path for this sync
-	sm.lockedPaths[meta.Path] = true
-	if sm.pathConds[meta.Path] == nil {
-		sm.pathConds[meta.Path] = sync.NewCond(&sm.mu)
+	// Call handler without lock (handler may take time)
+	var handlerErr error
+	if handler != nil {
+		handlerErr = handler(meta)
 	}
-	sm.mu.Unlock()
 
-	// Call handler without lock (handler may take time)
-	if sm.ActionHandler != nil {
-		if err := sm.ActionHandler(meta); err != nil {
-			// Unlock on handler error
-			sm.mu.Lock()
-			delete(sm.lockedPaths, meta.Path)
-			if sm.pathConds[meta.Path] != nil {
-				sm.pathConds[meta.Path].Broadcast()
-			}
-			sm.mu.Unlock()
-			return errors.Wrapf(err, "handler failed for %s action on %s", meta.Action, meta.Path)
-		}
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	// Unlock path and signal waiting goroutines
+	defer shard.unlockPath(meta.Path)
+
+	if handlerErr != nil {
+		return false, errors.Wrapf(handlerErr, "handler failed for %s action on %s", meta.Action, meta.Path)
 	}
 
-	// Remove from metadata and Badger with lock
-	sm.mu.Lock()
-	defer sm.mu.Unlock()
+	// A tree operation may have replaced the entry meanwhile, it is synced on its own
+	if sm.getMetadata(meta.Path) != meta {
+		return false, nil
+	}
 
+	// Remove from metadata and the store
 	if err := sm.deleteMetadata(meta.Path); err != nil {
-		return err
+		return false, err
 	}
+	return true, nil
+}
 
-	// Unlock path and signal waiting goroutines
-	delete(sm.lockedPaths, meta.Path)
-	if sm.pathConds[meta.Path] != nil {
-		sm.pathConds[meta.Path].Broadcast()
+// recordSyncFailure counts a failed sync of the current metadata of path and moves it to the
+// failed items once maxFailCount is reached. It returns the updated metadata, nil if the path is no
+// longer staged, and whether it was moved.
+func (sm *StagingStateManager) recordSyncFailure(path string, maxFailCount int) (*StagingMetadata, bool, error) {
+	shard := sm.lockShared(path)
+	defer sm.unlockShared(shard)
+
+	meta := sm.getMetadata(path)
+	if meta == nil {
+		return nil, false, nil
 	}
 
-	return nil
+	// Readers may hold the current metadata, replace it instead of changing it in place
+	copied := *meta
+	copied.SyncFailCount++
+
+	if copied.SyncFailCount < maxFailCount {
+		return &copied, false, sm.persistMetadata(path, &copied)
+	}
+	return &copied, true, sm.moveToFailed(&copied)
 }
 
 // SyncAll performs all pending iRODS operations and clears metadata one by one (exclusive lock)
 func (sm *StagingStateManager) SyncAll() error {
+	return sm.SyncAllCtx(context.Background())
+}
+
+// SyncAllCtx is SyncAll that stops before the next item once ctx is done
+func (sm *StagingStateManager) SyncAllCtx(ctx context.Context) error {
 	for {
-		sm.mu.Lock()
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 
 		// Get next unprocessed item
-		var meta *StagingMetadata
-		for _, m := range sm.metadata {
-			meta = m
-			break
-		}
+		meta := sm.index.getOldest()
 
 		// If no more items, we're done
 		if meta == nil {
-			sm.mu.Unlock()
 			break
 		}
 
-		sm.mu.Unlock()
-
 		// Process this item (syncOne handles locking/unlocking and waiting)
-		if err := sm.syncOne(meta); err != nil {
+		if _, err := sm.syncOne(meta); err != nil {
 			return err
 		}
 	}
@@ -604,22 +579,12 @@ func (sm *StagingStateManager) SyncAll() error {
 
 // SyncOld performs sync on items older than gracePeriod (10 seconds) with per-path locking
 func (sm *StagingStateManager) SyncOld(gracePeriod time.Duration) error {
-	sm.mu.Lock()
-
 	// Find items older than grace period
-	now := time.Now()
-	var metasToSync []*StagingMetadata
-	for _, meta := range sm.metadata {
-		if now.Sub(meta.LastModifiedAt) >= gracePeriod {
-			metasToSync = append(metasToSync, meta)
-		}
-	}
-
-	sm.mu.Unlock()
+	metasToSync := sm.GetModifiedBefore(time.Now().Add(-gracePeriod))
 
 	// Execute handlers for old items (syncOne handles locking/waiting/unlocking)
 	for _, meta := range metasToSync {
-		if err := sm.syncOne(meta); err != nil {
+		if _, err := sm.syncOne(meta); err != nil {
 			return err
 		}
 	}
@@ -629,20 +594,30 @@ func (sm *StagingStateManager) SyncOld(gracePeriod time.Duration) error {
 
 // Clear removes all metadata
 func (sm *StagingStateManager) Clear() error {
-	sm.mu.Lock()
+	sm.lockExclusive(func(string) bool { return false })
 	defer sm.mu.Unlock()
 
-	sm.metadata = make(map[string]*StagingMetadata)
+	// Temporary uploads stay tracked so that abandoned temporaries are still cleaned up in iRODS
+	for _, shard := range sm.shards {
+		shard.metadata = make(map[string]*StagingMetadata)
+	}
+	sm.index.reset()
+
+	sm.auxMu.Lock()
+	sm.checkpoints = make(map[string]*UploadCheckpoint)
+	sm.auxMu.Unlock()
 
-	if sm.db != nil {
-		return sm.db.Update(func(txn *badger.Txn) error {
-			opts := badger.DefaultIteratorOptions
-			opts.Prefix = []byte("staging:")
-			it := txn.NewIterator(opts)
-			defer it.Close()
+	if sm.store != nil {
+		// Deleting by prefix reads the store, commit queued writes first
+		if err := sm.Flush(); err != nil {
+			return err
+		}
 
-			for it.Rewind(); it.Valid(); it.Next() {
-				if err := txn.Delete(it.Item().Key()); err != nil {
+		return sm.store.Update(func(txn MetadataTxn) error {
+			for _, prefix := range []string{"staging:", "checkpoint:"} {
+				if err := txn.Iterate([]byte(prefix), func(key []byte, _ []byte) error {
+					return txn.Delete(key)
+				}); err != nil {
 					return err
 				}
 			}
@@ -653,43 +628,52 @@ func (sm *StagingStateManager) Clear() error {
 	return nil
 }
 
-// Restore restores metadata from Badger (crash recovery)
+// Restore restores metadata from the store (crash recovery)
 func (sm *StagingStateManager) Restore() error {
-	if sm.db == nil {
+	if sm.store == nil {
 		return nil
 	}
 
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
-	return sm.db.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-		opts.Prefix = []byte("staging:")
-		it := txn.NewIterator(opts)
-		defer it.Close()
-
-		for it.Rewind(); it.Valid(); it.Next() {
-			item := it.Item()
+	return sm.store.View(func(txn MetadataTxn) error {
+		restored := []*StagingMetadata{}
+		if err := txn.Iterate([]byte("staging:"), func(_ []byte, val []byte) error {
 			var meta StagingMetadata
-
-			if err := item.Value(func(val []byte) error {
-				return json.Unmarshal(val, &meta)
-			}); err != nil {
+			if err := json.Unmarshal(val, &meta); err != nil {
 				return errors.Wrap(err, "failed to unmarshal staging metadata")
 			}
 
-			sm.metadata[meta.Path] = &meta
+			restored = append(restored, &meta)
+			return nil
+		}); err != nil {
+			return err
 		}
 
-		return nil
+		// The store iterates by path, index the oldest items first so that each one is appended to the age list
+		sort.SliceStable(restored, func(i, j int) bool {
+			return restored[i].LastModifiedAt.Before(restored[j].LastModifiedAt)
+		})
+		for _, meta := range restored {
+			sm.setMetadata(meta)
+		}
+
+		sm.auxMu.Lock()
+		defer sm.auxMu.Unlock()
+
+		if err := sm.restoreCheckpoints(txn); err != nil {
+			return err
+		}
+		return sm.restoreTempUploads(txn)
 	})
 }
 
-// persistMetadata saves metadata to memory and Badger
+// persistMetadata saves metadata to memory and the store (caller must hold the shard of path or mu exclusively)
 func (sm *StagingStateManager) persistMetadata(path string, meta *StagingMetadata) error {
-	sm.metadata[path] = meta
+	sm.setMetadata(meta)
 
-	if sm.db == nil {
+	if sm.store == nil {
 		return nil
 	}
 
@@ -699,43 +683,104 @@ func (sm *StagingStateManager) persistMetadata(path string, meta *StagingMetadat
 		return errors.Wrap(err, "failed to marshal staging metadata")
 	}
 
-	return sm.db.Update(func(txn *badger.Txn) error {
-		return txn.Set(key, data)
+	return sm.updateStore(func(txn MetadataTxn) error {
+		return txn.Put(key, data)
 	})
 }
 
-// deleteMetadata removes metadata from memory and Badger (caller must hold mu)
+// deleteMetadata removes metadata from memory and the store (caller must hold the shard of path or mu exclusively)
 func (sm *StagingStateManager) deleteMetadata(path string) error {
-	delete(sm.metadata, path)
+	sm.unsetMetadata(path)
 
-	if sm.db == nil {
+	if sm.store == nil {
 		return nil
 	}
 
 	key := []byte(fmt.Sprintf("staging:%s", path))
-	return sm.db.Update(func(txn *badger.Txn) error {
+	return sm.updateStore(func(txn MetadataTxn) error {
 		return txn.Delete(key)
 	})
 }
 
+// persistMetadataPublic saves metadata with locking (for external callers)
+func (sm *StagingStateManager) persistMetadataPublic(path string, meta *StagingMetadata) error {
+	shard := sm.lockShared(path)
+	defer sm.unlockShared(shard)
+	return sm.persistMetadata(path, meta)
+}
+
 // deleteMetadataPublic removes metadata with locking (for external callers)
 func (sm *StagingStateManager) deleteMetadataPublic(path string) error {
-	sm.mu.Lock()
-	defer sm.mu.Unlock()
+	shard := sm.lockShared(path)
+	defer sm.unlockShared(shard)
 	return sm.deleteMetadata(path)
 }
 
-// WaitForSync blocks until the given path is no longer being synced.
-func (sm *StagingStateManager) WaitForSync(path string) {
-	sm.mu.Lock()
-	defer sm.mu.Unlock()
+// moveToFailed removes metadata and records it under the failed prefix in a single store transaction,
+// so that failed items and their local data survive a restart (caller must hold the shard of meta.Path)
+func (sm *StagingStateManager) moveToFailed(meta *StagingMetadata) error {
+	sm.unsetMetadata(meta.Path)
+
+	if sm.store == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal staging metadata")
+	}
 
-	for sm.lockedPaths[path] {
-		if sm.pathConds[path] == nil {
-			sm.pathConds[path] = sync.NewCond(&sm.mu)
+	return sm.updateStore(func(txn MetadataTxn) error {
+		if err := txn.Delete([]byte(fmt.Sprintf("staging:%s", meta.Path))); err != nil {
+			return err
 		}
-		sm.pathConds[path].Wait()
+		return txn.Put([]byte(fmt.Sprintf("failed:%s", meta.Path)), data)
+	})
+}
+
+// restoreFailed loads failed items from the store
+func (sm *StagingStateManager) restoreFailed() (map[string]*StagingMetadata, error) {
+	failed := make(map[string]*StagingMetadata)
+	if sm.store == nil {
+		return failed, nil
+	}
+
+	err := sm.store.View(func(txn MetadataTxn) error {
+		return txn.Iterate([]byte("failed:"), func(_ []byte, val []byte) error {
+			var meta StagingMetadata
+			if err := json.Unmarshal(val, &meta); err != nil {
+				return errors.Wrap(err, "failed to unmarshal failed staging metadata")
+			}
+
+			failed[meta.Path] = &meta
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
 	}
+	return failed, nil
+}
+
+// deleteFailed removes failed item records from the store
+func (sm *StagingStateManager) deleteFailed(paths ...string) error {
+	if sm.store == nil || len(paths) == 0 {
+		return nil
+	}
+
+	return sm.updateStore(func(txn MetadataTxn) error {
+		for _, path := range paths {
+			if err := txn.Delete([]byte(fmt.Sprintf("failed:%s", path))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// WaitForSync blocks until the given path is no longer being synced.
+func (sm *StagingStateManager) WaitForSync(path string) {
+	sm.unlockShared(sm.lockShared(path))
 }
 
 // RegisterActionHandler registers a handler for operations
@@ -0,0 +1,36 @@
+package stagingfs
+
+import (
+	"github.com/cockroachdb/errors"
+)
+
+// ErrMetadataKeyNotFound is returned by MetadataTxn.Get for a missing key
+var ErrMetadataKeyNotFound = errors.New("metadata key not found")
+
+// ErrMetadataTxnReadOnly is returned when writing in a read-only transaction
+var ErrMetadataTxnReadOnly = errors.New("metadata transaction is read-only")
+
+// MetadataStore persists staging state (metadata, checkpoints, temporary uploads and failed items)
+// as key/value pairs. Implementations must make each Update atomic: either all writes of the
+// transaction are applied or none.
+type MetadataStore interface {
+	// View runs fn in a read-only transaction
+	View(fn func(txn MetadataTxn) error) error
+	// Update runs fn in a read-write transaction, committed only if fn returns nil
+	Update(fn func(txn MetadataTxn) error) error
+	// Close releases the store
+	Close() error
+}
+
+// MetadataTxn is a transaction of a MetadataStore. Reads observe the transaction's own writes.
+type MetadataTxn interface {
+	// Get returns the value of key, or ErrMetadataKeyNotFound
+	Get(key []byte) ([]byte, error)
+	// Put sets the value of key
+	Put(key []byte, value []byte) error
+	// Delete removes key, deleting a missing key is not an error
+	Delete(key []byte) error
+	// Iterate calls fn for every key with the given prefix in ascending key order.
+	// key and value are only valid during the call. Keys may be deleted from fn.
+	Iterate(prefix []byte, fn func(key []byte, value []byte) error) error
+}
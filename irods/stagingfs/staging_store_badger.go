@@ -0,0 +1,192 @@
+package stagingfs
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/dgraph-io/badger/v3"
+)
+
+// BadgerMetadataStore is a MetadataStore backed by Badger
+type BadgerMetadataStore struct {
+	db       *badger.DB
+	readOnly bool
+	tempDir  string // Recovered copy of a read-only database, removed on Close
+}
+
+// NewBadgerMetadataStore opens a Badger database in dirPath.
+// Badger cannot open a database left by an unclean shutdown read-only, since its logs must be
+// truncated first. With readOnly, such a database is copied to a temporary directory and the copy
+// is recovered and read instead, leaving dirPath untouched.
+func NewBadgerMetadataStore(dirPath string, readOnly bool) (*BadgerMetadataStore, error) {
+	db, err := openBadger(dirPath, readOnly)
+	if err == nil {
+		return &BadgerMetadataStore{db: db, readOnly: readOnly}, nil
+	}
+	// Badger formats the errors it wraps, errors.Is does not find ErrTruncateNeeded
+	if !readOnly || !strings.Contains(err.Error(), badger.ErrTruncateNeeded.Error()) {
+		return nil, errors.Wrap(err, "failed to open Badger database")
+	}
+
+	tempDir, err := os.MkdirTemp("", "irodsfs-staging-meta-")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create temporary directory for Badger database recovery")
+	}
+
+	if err := copyBadgerDir(dirPath, tempDir); err != nil {
+		os.RemoveAll(tempDir)
+		return nil, errors.Wrapf(err, "failed to copy Badger database %s for recovery", dirPath)
+	}
+
+	db, err = openBadger(tempDir, false)
+	if err != nil {
+		os.RemoveAll(tempDir)
+		return nil, errors.Wrap(err, "failed to open recovered copy of Badger database")
+	}
+	return &BadgerMetadataStore{db: db, readOnly: true, tempDir: tempDir}, nil
+}
+
+func openBadger(dirPath string, readOnly bool) (*badger.DB, error) {
+	opts := badger.DefaultOptions(dirPath)
+	opts.Logger = nil
+	opts.ReadOnly = readOnly
+	return badger.Open(opts)
+}
+
+// copyBadgerDir copies the files of a Badger database except its lock file. Zero blocks are skipped
+// so that preallocated log files stay sparse.
+func copyBadgerDir(srcDir string, destDir string) error {
+	entries, err := os.ReadDir(srcDir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || entry.Name() == "LOCK" {
+			continue
+		}
+		if err := copySparseFile(filepath.Join(srcDir, entry.Name()), filepath.Join(destDir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copySparseFile(srcPath string, destPath string) error {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dest, err := os.OpenFile(destPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer dest.Close()
+
+	buffer := make([]byte, 64*1024)
+	zero := make([]byte, len(buffer))
+	var offset int64
+	for {
+		n, readErr := io.ReadFull(src, buffer)
+		if n > 0 && !bytes.Equal(buffer[:n], zero[:n]) {
+			if _, err := dest.WriteAt(buffer[:n], offset); err != nil {
+				return err
+			}
+		}
+		offset += int64(n)
+
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+	return dest.Truncate(offset)
+}
+
+// NewBadgerMetadataStoreWithDB wraps an open Badger database, the store takes ownership of it
+func NewBadgerMetadataStoreWithDB(db *badger.DB) *BadgerMetadataStore {
+	return &BadgerMetadataStore{db: db}
+}
+
+// View runs fn in a read-only Badger transaction
+func (s *BadgerMetadataStore) View(fn func(txn MetadataTxn) error) error {
+	return s.db.View(func(txn *badger.Txn) error {
+		return fn(&badgerMetadataTxn{txn: txn, readOnly: true})
+	})
+}
+
+// Update runs fn in a read-write Badger transaction
+func (s *BadgerMetadataStore) Update(fn func(txn MetadataTxn) error) error {
+	if s.readOnly {
+		return ErrMetadataTxnReadOnly
+	}
+
+	return s.db.Update(func(txn *badger.Txn) error {
+		return fn(&badgerMetadataTxn{txn: txn})
+	})
+}
+
+// Close closes the Badger database
+func (s *BadgerMetadataStore) Close() error {
+	err := s.db.Close()
+	if s.tempDir != "" {
+		os.RemoveAll(s.tempDir)
+	}
+	return err
+}
+
+type badgerMetadataTxn struct {
+	txn      *badger.Txn
+	readOnly bool
+}
+
+func (t *badgerMetadataTxn) Get(key []byte) ([]byte, error) {
+	item, err := t.txn.Get(key)
+	if err != nil {
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return nil, ErrMetadataKeyNotFound
+		}
+		return nil, err
+	}
+	return item.ValueCopy(nil)
+}
+
+func (t *badgerMetadataTxn) Put(key []byte, value []byte) error {
+	if t.readOnly {
+		return ErrMetadataTxnReadOnly
+	}
+	return t.txn.Set(key, value)
+}
+
+func (t *badgerMetadataTxn) Delete(key []byte) error {
+	if t.readOnly {
+		return ErrMetadataTxnReadOnly
+	}
+	return t.txn.Delete(key)
+}
+
+func (t *badgerMetadataTxn) Iterate(prefix []byte, fn func(key []byte, value []byte) error) error {
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = prefix
+	it := t.txn.NewIterator(opts)
+	defer it.Close()
+
+	for it.Rewind(); it.Valid(); it.Next() {
+		item := it.Item()
+		key := item.KeyCopy(nil)
+		if err := item.Value(func(val []byte) error {
+			return fn(key, val)
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,279 @@
+package stagingfs
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// MetadataDurability determines when staging state changes are committed to the MetadataStore
+type MetadataDurability int
+
+const (
+	// DurabilityStrict commits every change before the operation returns
+	DurabilityStrict MetadataDurability = iota
+	// DurabilityBatched applies changes in memory immediately and group-commits them within
+	// MetadataFlushInterval; changes acknowledged in the last interval may be lost on a crash
+	DurabilityBatched
+)
+
+const (
+	DefaultMetadataFlushInterval = 100 * time.Millisecond
+	DefaultMetadataMaxBatchSize  = 1000
+)
+
+func (d MetadataDurability) String() string {
+	switch d {
+	case DurabilityStrict:
+		return "STRICT"
+	case DurabilityBatched:
+		return "BATCHED"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// errBatchedRead is returned when a queued transaction tries to read
+var errBatchedRead = errors.New("reads are not supported in batched metadata writes")
+
+// metadataWrite is a single put or delete
+type metadataWrite struct {
+	key    []byte
+	value  []byte
+	delete bool
+}
+
+// recordingMetadataTxn records the writes of a transaction instead of applying them
+type recordingMetadataTxn struct {
+	writes []metadataWrite
+}
+
+func (t *recordingMetadataTxn) Get(key []byte) ([]byte, error) {
+	return nil, errBatchedRead
+}
+
+func (t *recordingMetadataTxn) Put(key []byte, value []byte) error {
+	t.writes = append(t.writes, metadataWrite{key: append([]byte{}, key...), value: append([]byte{}, value...)})
+	return nil
+}
+
+func (t *recordingMetadataTxn) Delete(key []byte) error {
+	t.writes = append(t.writes, metadataWrite{key: append([]byte{}, key...), delete: true})
+	return nil
+}
+
+func (t *recordingMetadataTxn) Iterate(prefix []byte, fn func(key []byte, value []byte) error) error {
+	return errBatchedRead
+}
+
+// metadataBatcher queues store transactions and group-commits them in order.
+// Each queued transaction is committed atomically; consecutive transactions are merged into
+// one store commit up to maxBatchSize writes.
+type metadataBatcher struct {
+	store        MetadataStore
+	interval     time.Duration
+	maxBatchSize int
+
+	mu           sync.Mutex
+	pending      [][]metadataWrite // queued transactions, oldest first
+	pendingCount int               // total writes in pending
+
+	flushMu sync.Mutex // serializes commits so that batches reach the store in order
+	kickCh  chan struct{}
+	stopCh  chan struct{}
+	done    chan struct{}
+	commits uint64 // number of store commits, for tests and metrics
+}
+
+func newMetadataBatcher(store MetadataStore, interval time.Duration, maxBatchSize int) *metadataBatcher {
+	if interval <= 0 {
+		interval = DefaultMetadataFlushInterval
+	}
+	if maxBatchSize <= 0 {
+		maxBatchSize = DefaultMetadataMaxBatchSize
+	}
+
+	b := &metadataBatcher{
+		store:        store,
+		interval:     interval,
+		maxBatchSize: maxBatchSize,
+		kickCh:       make(chan struct{}, 1),
+		stopCh:       make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+// queue records the writes of fn for the next group commit
+func (b *metadataBatcher) queue(fn func(txn MetadataTxn) error) error {
+	txn := &recordingMetadataTxn{}
+	if err := fn(txn); err != nil {
+		return err
+	}
+	if len(txn.writes) == 0 {
+		return nil
+	}
+
+	b.mu.Lock()
+	b.pending = append(b.pending, txn.writes)
+	b.pendingCount += len(txn.writes)
+	full := b.pendingCount >= b.maxBatchSize
+	b.mu.Unlock()
+
+	if full {
+		select {
+		case b.kickCh <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+func (b *metadataBatcher) run() {
+	defer close(b.done)
+
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.stopCh:
+			return
+		case <-ticker.C:
+		case <-b.kickCh:
+		}
+
+		if err := b.flush(); err != nil {
+			log.Warnf("failed to commit batched staging metadata, will retry: %v", err)
+		}
+	}
+}
+
+// flush commits all queued transactions. On failure, uncommitted transactions stay queued.
+func (b *metadataBatcher) flush() error {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+
+	b.mu.Lock()
+	queued := b.pending
+	b.pending = nil
+	b.pendingCount = 0
+	b.mu.Unlock()
+
+	for len(queued) > 0 {
+		// Merge whole transactions up to maxBatchSize writes (a larger transaction is committed alone)
+		n, count := 0, 0
+		for n < len(queued) && (n == 0 || count+len(queued[n]) <= b.maxBatchSize) {
+			count += len(queued[n])
+			n++
+		}
+
+		err := b.store.Update(func(txn MetadataTxn) error {
+			for _, writes := range queued[:n] {
+				for _, w := range writes {
+					var err error
+					if w.delete {
+						err = txn.Delete(w.key)
+					} else {
+						err = txn.Put(w.key, w.value)
+					}
+					if err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			b.requeue(queued)
+			return errors.Wrap(err, "failed to commit metadata batch")
+		}
+
+		b.mu.Lock()
+		b.commits++
+		b.mu.Unlock()
+		queued = queued[n:]
+	}
+	return nil
+}
+
+// requeue puts uncommitted transactions back in front of newer ones
+func (b *metadataBatcher) requeue(queued [][]metadataWrite) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	count := 0
+	for _, writes := range queued {
+		count += len(writes)
+	}
+	b.pending = append(queued, b.pending...)
+	b.pendingCount += count
+}
+
+// getCommitCount returns the number of store commits so far
+func (b *metadataBatcher) getCommitCount() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.commits
+}
+
+// stop stops the flusher and commits what is still queued
+func (b *metadataBatcher) stop() error {
+	close(b.stopCh)
+	<-b.done
+	return b.flush()
+}
+
+// EnableBatchedWrites switches the manager to DurabilityBatched: store writes are queued and
+// group-committed at least every interval or once maxBatchSize writes are queued
+func (sm *StagingStateManager) EnableBatchedWrites(interval time.Duration, maxBatchSize int) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if sm.store == nil || sm.batcher != nil {
+		return
+	}
+	sm.batcher = newMetadataBatcher(sm.store, interval, maxBatchSize)
+}
+
+// updateStore applies fn to the store: committed before returning in strict mode, or queued for the
+// next group commit in batched mode, where fn may only write
+func (sm *StagingStateManager) updateStore(fn func(txn MetadataTxn) error) error {
+	if sm.batcher == nil {
+		return sm.store.Update(fn)
+	}
+	return sm.batcher.queue(fn)
+}
+
+// Flush commits all queued metadata writes (no-op in strict mode)
+func (sm *StagingStateManager) Flush() error {
+	if sm.batcher == nil {
+		return nil
+	}
+	return sm.batcher.flush()
+}
+
+// closeStore commits queued metadata writes and closes the store
+func (sm *StagingStateManager) closeStore() error {
+	if sm.store == nil {
+		return nil
+	}
+
+	var flushErr error
+	if sm.batcher != nil {
+		flushErr = sm.batcher.stop()
+	}
+
+	if err := sm.store.Close(); err != nil {
+		return err
+	}
+	return flushErr
+}
+
+// FlushMetadata commits queued staging state changes, e.g. on fsync (no-op in strict mode)
+func (sf *StagingFS) FlushMetadata() error {
+	return sf.sm.Flush()
+}
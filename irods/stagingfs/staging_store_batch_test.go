@@ -0,0 +1,278 @@
+package stagingfs
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+var errStoreCrashed = errors.New("store crashed")
+
+// crashableStore counts commits and can fail or freeze like a crashed process
+type crashableStore struct {
+	*MemoryMetadataStore
+
+	mu        sync.RWMutex
+	crashed   bool
+	failNext  int
+	commitsMu sync.Mutex
+	commits   int
+}
+
+func newCrashableStore() *crashableStore {
+	return &crashableStore{MemoryMetadataStore: NewMemoryMetadataStore()}
+}
+
+func (s *crashableStore) Update(fn func(txn MetadataTxn) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.crashed {
+		return errStoreCrashed
+	}
+
+	s.commitsMu.Lock()
+	if s.failNext > 0 {
+		s.failNext--
+		s.commitsMu.Unlock()
+		return errors.New("disk full")
+	}
+	s.commits++
+	s.commitsMu.Unlock()
+
+	return s.MemoryMetadataStore.Update(fn)
+}
+
+// crash waits for in-flight commits and freezes the store
+func (s *crashableStore) crash() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.crashed = true
+}
+
+func (s *crashableStore) getCommits() int {
+	s.commitsMu.Lock()
+	defer s.commitsMu.Unlock()
+	return s.commits
+}
+
+func (s *crashableStore) setFailNext(n int) {
+	s.commitsMu.Lock()
+	defer s.commitsMu.Unlock()
+	s.failNext = n
+}
+
+// restoredPaths returns the staged paths a restarted manager would see
+func restoredPaths(t *testing.T, store MetadataStore) map[string]*StagingMetadata {
+	sm := NewStagingStateManagerWithStore(store)
+	if err := sm.Restore(); err != nil {
+		t.Fatalf("Failed to restore: %v", err)
+	}
+	return sm.GetAll()
+}
+
+func TestBatchedWritesGroupCommit(t *testing.T) {
+	store := newCrashableStore()
+	sm := NewStagingStateManagerWithStore(store)
+	sm.EnableBatchedWrites(time.Hour, 10000)
+	defer sm.closeStore()
+
+	for i := 0; i < 500; i++ {
+		if err := sm.Create(fmt.Sprintf("/dir/f%03d", i)); err != nil {
+			t.Fatalf("Failed to create: %v", err)
+		}
+	}
+
+	// Applied in memory immediately, nothing committed yet
+	if len(sm.GetAll()) != 500 {
+		t.Errorf("Expected 500 items in memory, got %d", len(sm.GetAll()))
+	}
+	if store.getCommits() != 0 {
+		t.Errorf("Expected no commits before flush, got %d", store.getCommits())
+	}
+
+	if err := sm.Flush(); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+	if store.getCommits() != 1 {
+		t.Errorf("Expected a single group commit, got %d", store.getCommits())
+	}
+	if got := restoredPaths(t, store.MemoryMetadataStore); len(got) != 500 {
+		t.Errorf("Expected 500 restored items, got %d", len(got))
+	}
+}
+
+func TestBatchedWritesMaxBatchSize(t *testing.T) {
+	store := newCrashableStore()
+	sm := NewStagingStateManagerWithStore(store)
+	sm.EnableBatchedWrites(time.Hour, 10)
+	defer sm.closeStore()
+
+	for i := 0; i < 25; i++ {
+		if err := sm.Create(fmt.Sprintf("/f%02d", i)); err != nil {
+			t.Fatalf("Failed to create: %v", err)
+		}
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(restoredPaths(t, store.MemoryMetadataStore)) < 20 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := len(restoredPaths(t, store.MemoryMetadataStore)); got < 20 {
+		t.Errorf("Expected full batches to be committed early, got %d items", got)
+	}
+
+	if err := sm.Flush(); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+	if got := len(restoredPaths(t, store.MemoryMetadataStore)); got != 25 {
+		t.Errorf("Expected 25 items after flush, got %d", got)
+	}
+}
+
+func TestBatchedWritesFlushInterval(t *testing.T) {
+	store := newCrashableStore()
+	sm := NewStagingStateManagerWithStore(store)
+	sm.EnableBatchedWrites(10*time.Millisecond, 0)
+	defer sm.closeStore()
+
+	if err := sm.Create("/a"); err != nil {
+		t.Fatalf("Failed to create: %v", err)
+	}
+	if err := sm.Mkdir("/d"); err != nil {
+		t.Fatalf("Failed to mkdir: %v", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(restoredPaths(t, store.MemoryMetadataStore)) < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := restoredPaths(t, store.MemoryMetadataStore); len(got) != 2 {
+		t.Errorf("Expected changes committed within the flush interval, got %v", got)
+	}
+}
+
+func TestBatchedWritesRetryKeepsOrder(t *testing.T) {
+	store := newCrashableStore()
+	sm := NewStagingStateManagerWithStore(store)
+	sm.EnableBatchedWrites(time.Hour, 0)
+	defer sm.closeStore()
+
+	if err := sm.Create("/a"); err != nil {
+		t.Fatalf("Failed to create: %v", err)
+	}
+
+	store.setFailNext(1)
+	if err := sm.Flush(); err == nil {
+		t.Fatalf("Expected flush to fail")
+	}
+
+	// Queued after the failed batch, must be applied after it
+	if err := sm.Delete("/a"); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+	if err := sm.Create("/b"); err != nil {
+		t.Fatalf("Failed to create: %v", err)
+	}
+
+	if err := sm.Flush(); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+
+	got := restoredPaths(t, store.MemoryMetadataStore)
+	if len(got) != 1 || got["/b"] == nil {
+		t.Errorf("Expected only /b after retry, got %v", got)
+	}
+}
+
+// TestStrictDurabilityCrash crashes the store while writers are running: every operation that
+// returned successfully must be visible after restart
+func TestStrictDurabilityCrash(t *testing.T) {
+	store := newCrashableStore()
+	sm := NewStagingStateManagerWithStore(store)
+
+	const writers = 8
+	const opsPerWriter = 300
+
+	var ackMu sync.Mutex
+	acked := map[string]bool{}
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < opsPerWriter; i++ {
+				path := fmt.Sprintf("/w%d/f%04d", w, i)
+				if err := sm.Create(path); err != nil {
+					if !errors.Is(err, errStoreCrashed) {
+						t.Errorf("Unexpected error: %v", err)
+					}
+					return
+				}
+
+				ackMu.Lock()
+				acked[path] = true
+				ackMu.Unlock()
+			}
+		}(w)
+	}
+
+	// Crash somewhere in the middle of the run
+	for store.getCommits() < writers*opsPerWriter/3 {
+		time.Sleep(time.Millisecond)
+	}
+	store.crash()
+	wg.Wait()
+
+	restored := restoredPaths(t, store.MemoryMetadataStore)
+	if len(acked) == 0 {
+		t.Fatalf("Expected some acknowledged operations")
+	}
+
+	lost := 0
+	for path := range acked {
+		if restored[path] == nil {
+			lost++
+		}
+	}
+	if lost > 0 {
+		t.Errorf("Lost %d of %d acknowledged operations in strict mode", lost, len(acked))
+	}
+}
+
+func TestStagingFSBatchedDurability(t *testing.T) {
+	store := newCrashableStore()
+
+	sf, err := NewStagingFSWithPersistence(&StagingFSConfig{
+		LocalRootPath:         t.TempDir(),
+		Client:                &MockStagingClient{},
+		MetadataStore:         store,
+		MetadataDurability:    DurabilityBatched,
+		MetadataFlushInterval: time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create StagingFS: %v", err)
+	}
+
+	writeStagedFile(t, sf, "/f.txt", 4)
+	if got := restoredPaths(t, store.MemoryMetadataStore); len(got) != 0 {
+		t.Errorf("Expected nothing committed before flush, got %v", got)
+	}
+
+	if err := sf.FlushMetadata(); err != nil {
+		t.Fatalf("Failed to flush metadata: %v", err)
+	}
+	if got := restoredPaths(t, store.MemoryMetadataStore); got["/f.txt"] == nil {
+		t.Errorf("Expected /f.txt committed after flush, got %v", got)
+	}
+
+	// Simulate a dead mount after a flush
+	sf.stopOnce.Do(func() { close(sf.stopCh) })
+	<-sf.workerDone
+	close(sf.sm.batcher.stopCh)
+	<-sf.sm.batcher.done
+}
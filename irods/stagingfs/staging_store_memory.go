@@ -0,0 +1,142 @@
+package stagingfs
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+
+	"github.com/cockroachdb/errors"
+)
+
+// MemoryMetadataStore is a MetadataStore kept in memory. State is lost on restart; it suits tests
+// and hosts that cannot run an mmap-based store but still want the persistence code paths.
+// Update transactions are serialized.
+type MemoryMetadataStore struct {
+	mu     sync.RWMutex
+	data   map[string][]byte
+	closed bool
+}
+
+// NewMemoryMetadataStore creates an empty in-memory store
+func NewMemoryMetadataStore() *MemoryMetadataStore {
+	return &MemoryMetadataStore{
+		data: make(map[string][]byte),
+	}
+}
+
+// View runs fn in a read-only transaction
+func (s *MemoryMetadataStore) View(fn func(txn MetadataTxn) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return errors.New("metadata store is closed")
+	}
+	return fn(&memoryMetadataTxn{store: s, readOnly: true})
+}
+
+// Update runs fn in a read-write transaction, buffering writes until fn returns nil
+func (s *MemoryMetadataStore) Update(fn func(txn MetadataTxn) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return errors.New("metadata store is closed")
+	}
+
+	txn := &memoryMetadataTxn{store: s, writes: make(map[string][]byte)}
+	if err := fn(txn); err != nil {
+		return err
+	}
+
+	for key, value := range txn.writes {
+		if value == nil {
+			delete(s.data, key)
+		} else {
+			s.data[key] = value
+		}
+	}
+	return nil
+}
+
+// Close marks the store closed
+func (s *MemoryMetadataStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+type memoryMetadataTxn struct {
+	store    *MemoryMetadataStore
+	readOnly bool
+	writes   map[string][]byte // pending writes, nil value = deleted
+}
+
+func (t *memoryMetadataTxn) lookup(key string) ([]byte, bool) {
+	if value, ok := t.writes[key]; ok {
+		return value, value != nil
+	}
+	value, ok := t.store.data[key]
+	return value, ok
+}
+
+func (t *memoryMetadataTxn) Get(key []byte) ([]byte, error) {
+	value, ok := t.lookup(string(key))
+	if !ok {
+		return nil, ErrMetadataKeyNotFound
+	}
+	return bytes.Clone(value), nil
+}
+
+func (t *memoryMetadataTxn) Put(key []byte, value []byte) error {
+	if t.readOnly {
+		return ErrMetadataTxnReadOnly
+	}
+
+	copied := make([]byte, len(value))
+	copy(copied, value)
+	t.writes[string(key)] = copied
+	return nil
+}
+
+func (t *memoryMetadataTxn) Delete(key []byte) error {
+	if t.readOnly {
+		return ErrMetadataTxnReadOnly
+	}
+	t.writes[string(key)] = nil
+	return nil
+}
+
+func (t *memoryMetadataTxn) Iterate(prefix []byte, fn func(key []byte, value []byte) error) error {
+	// Iterate over the entries present when iteration started, like a Badger iterator
+	entries := map[string][]byte{}
+	for key, value := range t.store.data {
+		if bytes.HasPrefix([]byte(key), prefix) {
+			entries[key] = value
+		}
+	}
+	for key, value := range t.writes {
+		if !bytes.HasPrefix([]byte(key), prefix) {
+			continue
+		}
+		if value == nil {
+			delete(entries, key)
+		} else {
+			entries[key] = value
+		}
+	}
+
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if err := fn([]byte(key), bytes.Clone(entries[key])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,246 @@
+package stagingfs
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+)
+
+// runMetadataStoreConformance checks the MetadataStore contract; every implementation must pass it
+func runMetadataStoreConformance(t *testing.T, newStore func(t *testing.T) MetadataStore) {
+	put := func(t *testing.T, store MetadataStore, kvs ...string) {
+		err := store.Update(func(txn MetadataTxn) error {
+			for i := 0; i < len(kvs); i += 2 {
+				if err := txn.Put([]byte(kvs[i]), []byte(kvs[i+1])); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Failed to put: %v", err)
+		}
+	}
+
+	collect := func(t *testing.T, store MetadataStore, prefix string) []string {
+		result := []string{}
+		err := store.View(func(txn MetadataTxn) error {
+			return txn.Iterate([]byte(prefix), func(key []byte, value []byte) error {
+				result = append(result, string(key)+"="+string(value))
+				return nil
+			})
+		})
+		if err != nil {
+			t.Fatalf("Failed to iterate: %v", err)
+		}
+		return result
+	}
+
+	t.Run("GetPut", func(t *testing.T) {
+		store := newStore(t)
+		put(t, store, "staging:/a", "1")
+
+		err := store.View(func(txn MetadataTxn) error {
+			value, err := txn.Get([]byte("staging:/a"))
+			if err != nil {
+				return err
+			}
+			if string(value) != "1" {
+				t.Errorf("Expected value 1, got %q", value)
+			}
+
+			if _, err := txn.Get([]byte("staging:/missing")); !errors.Is(err, ErrMetadataKeyNotFound) {
+				t.Errorf("Expected ErrMetadataKeyNotFound, got %v", err)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Failed to view: %v", err)
+		}
+	})
+
+	t.Run("IteratePrefixOrder", func(t *testing.T) {
+		store := newStore(t)
+		put(t, store, "staging:/b", "2", "checkpoint:/a", "x", "staging:/a", "1", "staging:/a/c", "3")
+
+		got := strings.Join(collect(t, store, "staging:"), ",")
+		if got != "staging:/a=1,staging:/a/c=3,staging:/b=2" {
+			t.Errorf("Unexpected iteration result: %s", got)
+		}
+	})
+
+	t.Run("RollbackOnError", func(t *testing.T) {
+		store := newStore(t)
+		put(t, store, "staging:/keep", "1")
+
+		err := store.Update(func(txn MetadataTxn) error {
+			if err := txn.Put([]byte("staging:/new"), []byte("1")); err != nil {
+				return err
+			}
+			if err := txn.Delete([]byte("staging:/keep")); err != nil {
+				return err
+			}
+			return errors.New("abort")
+		})
+		if err == nil {
+			t.Fatalf("Expected update error")
+		}
+
+		if got := strings.Join(collect(t, store, "staging:"), ","); got != "staging:/keep=1" {
+			t.Errorf("Expected aborted transaction to leave no trace, got %s", got)
+		}
+	})
+
+	t.Run("ReadOwnWrites", func(t *testing.T) {
+		store := newStore(t)
+		put(t, store, "staging:/a", "1", "staging:/b", "2")
+
+		err := store.Update(func(txn MetadataTxn) error {
+			if err := txn.Put([]byte("staging:/c"), []byte("3")); err != nil {
+				return err
+			}
+			if err := txn.Delete([]byte("staging:/a")); err != nil {
+				return err
+			}
+
+			if value, err := txn.Get([]byte("staging:/c")); err != nil || string(value) != "3" {
+				t.Errorf("Expected own write to be visible, got %q, %v", value, err)
+			}
+			if _, err := txn.Get([]byte("staging:/a")); !errors.Is(err, ErrMetadataKeyNotFound) {
+				t.Errorf("Expected own delete to be visible, got %v", err)
+			}
+
+			keys := []string{}
+			if err := txn.Iterate([]byte("staging:"), func(key []byte, _ []byte) error {
+				keys = append(keys, string(key))
+				return nil
+			}); err != nil {
+				return err
+			}
+			if strings.Join(keys, ",") != "staging:/b,staging:/c" {
+				t.Errorf("Expected iteration to see own writes, got %v", keys)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Failed to update: %v", err)
+		}
+	})
+
+	t.Run("DeleteWhileIterating", func(t *testing.T) {
+		store := newStore(t)
+		put(t, store, "staging:/a", "1", "staging:/b", "2", "temp:/a", "t")
+
+		err := store.Update(func(txn MetadataTxn) error {
+			return txn.Iterate([]byte("staging:"), func(key []byte, _ []byte) error {
+				return txn.Delete(key)
+			})
+		})
+		if err != nil {
+			t.Fatalf("Failed to delete: %v", err)
+		}
+
+		if got := collect(t, store, "staging:"); len(got) != 0 {
+			t.Errorf("Expected all staging keys deleted, got %v", got)
+		}
+		if got := collect(t, store, "temp:"); len(got) != 1 {
+			t.Errorf("Expected other prefixes untouched, got %v", got)
+		}
+	})
+
+	t.Run("DeleteMissing", func(t *testing.T) {
+		store := newStore(t)
+		err := store.Update(func(txn MetadataTxn) error {
+			return txn.Delete([]byte("staging:/missing"))
+		})
+		if err != nil {
+			t.Errorf("Expected deleting a missing key to succeed, got %v", err)
+		}
+	})
+
+	t.Run("ViewIsReadOnly", func(t *testing.T) {
+		store := newStore(t)
+		err := store.View(func(txn MetadataTxn) error {
+			return txn.Put([]byte("staging:/a"), []byte("1"))
+		})
+		if !errors.Is(err, ErrMetadataTxnReadOnly) {
+			t.Errorf("Expected ErrMetadataTxnReadOnly, got %v", err)
+		}
+	})
+
+	t.Run("ConcurrentUpdates", func(t *testing.T) {
+		store := newStore(t)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 16; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				err := store.Update(func(txn MetadataTxn) error {
+					return txn.Put([]byte(fmt.Sprintf("staging:/f%02d", i)), []byte("x"))
+				})
+				if err != nil {
+					t.Errorf("Failed to put concurrently: %v", err)
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		if got := collect(t, store, "staging:"); len(got) != 16 {
+			t.Errorf("Expected 16 keys, got %d", len(got))
+		}
+	})
+}
+
+func TestMemoryMetadataStoreConformance(t *testing.T) {
+	runMetadataStoreConformance(t, func(t *testing.T) MetadataStore {
+		store := NewMemoryMetadataStore()
+		t.Cleanup(func() { store.Close() })
+		return store
+	})
+}
+
+func TestBadgerMetadataStoreConformance(t *testing.T) {
+	runMetadataStoreConformance(t, func(t *testing.T) MetadataStore {
+		store, err := NewBadgerMetadataStore(t.TempDir(), false)
+		if err != nil {
+			t.Fatalf("Failed to open Badger store: %v", err)
+		}
+		t.Cleanup(func() { store.Close() })
+		return store
+	})
+}
+
+func TestStagingFSWithMemoryMetadataStore(t *testing.T) {
+	store := NewMemoryMetadataStore()
+
+	sf, err := NewStagingFSWithPersistence(&StagingFSConfig{
+		LocalRootPath: t.TempDir(),
+		Client:        &MockStagingClient{},
+		MetadataStore: store,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create StagingFS: %v", err)
+	}
+	defer sf.Close(context.Background())
+
+	writeStagedFile(t, sf, "/dir/f.txt", 4)
+	if err := sf.Mkdir("/new"); err != nil {
+		t.Fatalf("Failed to mkdir: %v", err)
+	}
+
+	// A second manager over the same store restores the same state
+	sm := NewStagingStateManagerWithStore(store)
+	if err := sm.Restore(); err != nil {
+		t.Fatalf("Failed to restore: %v", err)
+	}
+
+	all := sm.GetAll()
+	if len(all) != 2 || all["/dir/f.txt"] == nil || all["/new"] == nil {
+		t.Errorf("Expected restored /dir/f.txt and /new, got %v", all)
+	}
+}
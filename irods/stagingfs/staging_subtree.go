@@ -0,0 +1,317 @@
+package stagingfs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+// subtreePrefix returns the prefix shared by all descendants of dir
+func subtreePrefix(dir string) string {
+	if strings.HasSuffix(dir, "/") {
+		return dir
+	}
+	return dir + "/"
+}
+
+// isInSubtree returns true if p is dir or a descendant of dir
+func isInSubtree(p string, dir string) bool {
+	return p == dir || strings.HasPrefix(p, subtreePrefix(dir))
+}
+
+// rebasePath moves p from under oldDir to under newDir
+func rebasePath(p string, oldDir string, newDir string) string {
+	if p == oldDir {
+		return newDir
+	}
+	return subtreePrefix(newDir) + p[len(subtreePrefix(oldDir)):]
+}
+
+// GetSubtree returns staged metadata of dir and all its descendants, sorted by path
+func (sm *StagingStateManager) GetSubtree(dir string) []*StagingMetadata {
+	result := sm.index.getSubtree(dir)
+	sortMetadataByPath(result)
+	return result
+}
+
+// rewriteSubtree moves the metadata of oldDir and all its descendants under newDir, also rewriting
+// OldPath of any entry that refers into the subtree. The store is updated in a single transaction.
+// Checkpoints of moved uploads are dropped since a partial upload cannot be resumed at another path.
+// Caller must hold mu exclusively.
+func (sm *StagingStateManager) rewriteSubtree(oldDir string, newDir string, now time.Time) error {
+	type move struct {
+		from string
+		meta *StagingMetadata
+	}
+
+	moves := []move{}
+	updated := []*StagingMetadata{}
+	// Entries in the subtree and rename entries moved out of it
+	candidates := map[string]*StagingMetadata{}
+	for _, meta := range sm.index.getSubtree(oldDir) {
+		candidates[meta.Path] = meta
+	}
+	for _, meta := range sm.index.getRenamedFromSubtree(oldDir) {
+		candidates[meta.Path] = meta
+	}
+
+	for p, meta := range candidates {
+		inSubtree := isInSubtree(p, oldDir)
+		refersInto := meta.OldPath != "" && isInSubtree(meta.OldPath, oldDir)
+		if !inSubtree && !refersInto {
+			continue
+		}
+
+		copied := *meta
+		if inSubtree {
+			copied.Path = rebasePath(p, oldDir, newDir)
+			copied.LastModifiedAt = now
+			moves = append(moves, move{from: p, meta: &copied})
+		}
+		if refersInto {
+			copied.OldPath = rebasePath(meta.OldPath, oldDir, newDir)
+		}
+		updated = append(updated, &copied)
+	}
+
+	if len(updated) == 0 {
+		return nil
+	}
+
+	sm.auxMu.Lock()
+	defer sm.auxMu.Unlock()
+
+	droppedCheckpoints := []string{}
+	for p := range sm.checkpoints {
+		if isInSubtree(p, oldDir) {
+			droppedCheckpoints = append(droppedCheckpoints, p)
+		}
+	}
+
+	if sm.store != nil {
+		err := sm.updateStore(func(txn MetadataTxn) error {
+			for _, m := range moves {
+				if err := txn.Delete([]byte(fmt.Sprintf("staging:%s", m.from))); err != nil {
+					return err
+				}
+			}
+			for _, p := range droppedCheckpoints {
+				if err := txn.Delete([]byte(fmt.Sprintf("checkpoint:%s", p))); err != nil {
+					return err
+				}
+			}
+			for _, meta := range updated {
+				data, err := json.Marshal(meta)
+				if err != nil {
+					return errors.Wrap(err, "failed to marshal staging metadata")
+				}
+				if err := txn.Put([]byte(fmt.Sprintf("staging:%s", meta.Path)), data); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return errors.Wrapf(err, "failed to rewrite staging metadata under %s", oldDir)
+		}
+	}
+
+	// The store is consistent, apply the same changes in memory
+	for _, m := range moves {
+		sm.unsetMetadata(m.from)
+	}
+	for _, p := range droppedCheckpoints {
+		delete(sm.checkpoints, p)
+	}
+	for _, meta := range updated {
+		sm.setMetadata(meta)
+	}
+
+	return nil
+}
+
+// isRenamedOutOf returns true if p is a pending rename from dir's subtree to outside of it.
+// Caller must hold mu exclusively.
+func (sm *StagingStateManager) isRenamedOutOf(p string, dir string) bool {
+	meta := sm.getMetadata(p)
+	return meta != nil && meta.OldPath != "" && isInSubtree(meta.OldPath, dir) && !isInSubtree(p, dir)
+}
+
+// syncRenamesOutOf syncs the pending renames from dir's subtree to outside of it, so that removing
+// dir recursively in iRODS does not delete their sources. Caller must hold mu exclusively, it is
+// released while the handler runs.
+func (sm *StagingStateManager) syncRenamesOutOf(dir string) error {
+	renamed := []*StagingMetadata{}
+	for _, meta := range sm.index.getRenamedFromSubtree(dir) {
+		if isInSubtree(meta.Path, dir) || (meta.Action != ActionRename && meta.Action != ActionRenameDir) {
+			continue
+		}
+		renamed = append(renamed, meta)
+	}
+	sortMetadataByPath(renamed)
+
+	handler := sm.ActionHandler
+	if len(renamed) == 0 || handler == nil {
+		return sm.deleteSynced(renamed)
+	}
+
+	busyPaths := []string{dir}
+	for _, meta := range renamed {
+		busyPaths = append(busyPaths, meta.Path)
+	}
+
+	synced := 0
+	handlerErr := sm.withoutLock(func() error {
+		for _, meta := range renamed {
+			if err := handler(meta); err != nil {
+				return errors.Wrapf(err, "handler failed for %s action on %s before RMDIR of %s", meta.Action, meta.Path, dir)
+			}
+			synced++
+		}
+		return nil
+	}, busyPaths...)
+
+	if err := sm.deleteSynced(renamed[:synced]); err != nil {
+		return err
+	}
+	return handlerErr
+}
+
+// deleteSynced removes the metadata of synced entries (caller must hold mu exclusively)
+func (sm *StagingStateManager) deleteSynced(synced []*StagingMetadata) error {
+	for _, meta := range synced {
+		if err := sm.deleteMetadata(meta.Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cancelSubtree removes the metadata and checkpoints of all descendants of dir (not dir itself)
+// in a single store transaction and returns the removed metadata sorted by path.
+// Cancelling a rename into the subtree from outside would bring its source back, so the source is
+// staged for deletion instead. Caller must hold mu exclusively.
+func (sm *StagingStateManager) cancelSubtree(dir string) ([]*StagingMetadata, error) {
+	prefix := subtreePrefix(dir)
+	now := time.Now()
+
+	cancelled := []*StagingMetadata{}
+	removedSources := []*StagingMetadata{}
+	for _, meta := range sm.index.getSubtree(dir) {
+		if !strings.HasPrefix(meta.Path, prefix) {
+			continue
+		}
+		cancelled = append(cancelled, meta)
+
+		if meta.OldPath == "" || isInSubtree(meta.OldPath, dir) {
+			continue
+		}
+
+		if source := sm.getMetadata(meta.OldPath); source != nil {
+			// Something was staged at the source since; it replaces the object still in iRODS
+			copied := *source
+			copied.IsNew = false
+			copied.LastModifiedAt = now
+			removedSources = append(removedSources, &copied)
+			continue
+		}
+
+		action := ActionDelete
+		if meta.Action == ActionRenameDir {
+			action = ActionRmdir
+		}
+		removedSources = append(removedSources, &StagingMetadata{
+			Path:           meta.OldPath,
+			Action:         action,
+			IsNew:          false,
+			CreatedAt:      now,
+			LastModifiedAt: now,
+		})
+	}
+
+	sm.auxMu.Lock()
+	defer sm.auxMu.Unlock()
+
+	droppedCheckpoints := []string{}
+	for p := range sm.checkpoints {
+		if strings.HasPrefix(p, prefix) {
+			droppedCheckpoints = append(droppedCheckpoints, p)
+		}
+	}
+
+	if len(cancelled) == 0 && len(droppedCheckpoints) == 0 {
+		return cancelled, nil
+	}
+
+	if sm.store != nil {
+		err := sm.updateStore(func(txn MetadataTxn) error {
+			for _, meta := range cancelled {
+				if err := txn.Delete([]byte(fmt.Sprintf("staging:%s", meta.Path))); err != nil {
+					return err
+				}
+			}
+			for _, p := range droppedCheckpoints {
+				if err := txn.Delete([]byte(fmt.Sprintf("checkpoint:%s", p))); err != nil {
+					return err
+				}
+			}
+			for _, meta := range removedSources {
+				data, err := json.Marshal(meta)
+				if err != nil {
+					return errors.Wrap(err, "failed to marshal staging metadata")
+				}
+				if err := txn.Put([]byte(fmt.Sprintf("staging:%s", meta.Path)), data); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to cancel staging metadata under %s", dir)
+		}
+	}
+
+	for _, meta := range cancelled {
+		sm.unsetMetadata(meta.Path)
+	}
+	for _, p := range droppedCheckpoints {
+		delete(sm.checkpoints, p)
+	}
+	for _, meta := range removedSources {
+		sm.setMetadata(meta)
+	}
+
+	sortMetadataByPath(cancelled)
+	return cancelled, nil
+}
+
+// GetSubtree returns staged metadata of path and all its descendants, sorted by path
+func (sf *StagingFS) GetSubtree(path string) []*StagingMetadata {
+	return sf.sm.GetSubtree(path)
+}
+
+// SyncSubtree syncs staged items at and below path immediately, parents first
+func (sf *StagingFS) SyncSubtree(path string) error {
+	return sf.SyncSubtreeCtx(context.Background(), path)
+}
+
+// SyncSubtreeCtx is SyncSubtree that stops before the next item once ctx is done
+func (sf *StagingFS) SyncSubtreeCtx(ctx context.Context, path string) error {
+	for _, meta := range sf.sm.GetSubtree(path) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		synced, err := sf.sm.syncOne(meta)
+		if err != nil {
+			return err
+		}
+		if synced {
+			sf.removeSyncedLocalFile(meta.Path)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,332 @@
+package stagingfs
+
+import (
+	"context"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingHandler records synced actions and the staged data seen for uploads
+type recordingHandler struct {
+	mu      sync.Mutex
+	sf      *StagingFS
+	actions []string
+	data    map[string]string
+}
+
+func newRecordingHandler(sf *StagingFS) *recordingHandler {
+	h := &recordingHandler{sf: sf, data: map[string]string{}}
+	sf.RegisterActionHandler(h.handle)
+	return h
+}
+
+func (h *recordingHandler) handle(meta *StagingMetadata) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	entry := meta.Action.String() + " " + meta.Path
+	if meta.OldPath != "" {
+		entry = meta.Action.String() + " " + meta.OldPath + " -> " + meta.Path
+	}
+	h.actions = append(h.actions, entry)
+
+	if meta.Action == ActionUpload {
+		data, err := os.ReadFile(h.sf.GetLocalDataPath(meta.Path))
+		if err != nil {
+			return err
+		}
+		h.data[meta.Path] = string(data)
+	}
+	return nil
+}
+
+func (h *recordingHandler) getSortedActions() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	actions := append([]string{}, h.actions...)
+	sort.Strings(actions)
+	return actions
+}
+
+func getStagedPaths(sf *StagingFS) []string {
+	paths := []string{}
+	for p := range sf.GetAll() {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func TestStagingFSNestedCreateRenameParentSync(t *testing.T) {
+	sf, err := NewStagingFS(&StagingFSConfig{LocalRootPath: t.TempDir(), Client: &MockStagingClient{}})
+	if err != nil {
+		t.Fatalf("Failed to create StagingFS: %v", err)
+	}
+	defer sf.Close(context.Background())
+
+	handler := newRecordingHandler(sf)
+
+	for _, dir := range []string{"/a", "/a/b", "/a/b/c"} {
+		if err := sf.Mkdir(dir); err != nil {
+			t.Fatalf("Failed to mkdir %s: %v", dir, err)
+		}
+	}
+	writeStagedFile(t, sf, "/a/top.txt", 3)
+	writeStagedFile(t, sf, "/a/b/c/deep.txt", 5)
+
+	if err := sf.RenameDir("/a", "/z"); err != nil {
+		t.Fatalf("Failed to rename dir: %v", err)
+	}
+
+	expected := []string{"/z", "/z/b", "/z/b/c", "/z/b/c/deep.txt", "/z/top.txt"}
+	if got := getStagedPaths(sf); strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Fatalf("Expected staged paths %v, got %v", expected, got)
+	}
+
+	if err := sf.SyncAll(); err != nil {
+		t.Fatalf("SyncAll failed: %v", err)
+	}
+
+	expectedActions := []string{"MKDIR /z", "MKDIR /z/b", "MKDIR /z/b/c", "UPLOAD /z/b/c/deep.txt", "UPLOAD /z/top.txt"}
+	if got := handler.getSortedActions(); strings.Join(got, ",") != strings.Join(expectedActions, ",") {
+		t.Errorf("Expected actions %v, got %v", expectedActions, got)
+	}
+
+	if handler.data["/z/b/c/deep.txt"] != "xxxxx" {
+		t.Errorf("Expected deep file data to be uploaded from the renamed location")
+	}
+}
+
+func TestStagingFSRenameExistingDirMovesStagedChildren(t *testing.T) {
+	sf, err := NewStagingFS(&StagingFSConfig{LocalRootPath: t.TempDir(), Client: &MockStagingClient{}})
+	if err != nil {
+		t.Fatalf("Failed to create StagingFS: %v", err)
+	}
+	defer sf.Close(context.Background())
+
+	handler := newRecordingHandler(sf)
+
+	// /existing is in iRODS already, only the new file below it is staged
+	writeStagedFile(t, sf, "/existing/sub/new.txt", 4)
+
+	if err := sf.RenameDir("/existing", "/moved"); err != nil {
+		t.Fatalf("Failed to rename dir: %v", err)
+	}
+
+	if got := handler.getSortedActions(); len(got) != 1 || got[0] != "RENAME_DIR /existing -> /moved" {
+		t.Fatalf("Expected immediate RENAME_DIR, got %v", got)
+	}
+
+	if got := getStagedPaths(sf); len(got) != 1 || got[0] != "/moved/sub/new.txt" {
+		t.Fatalf("Expected staged child at the new path, got %v", got)
+	}
+
+	if sf.GetLocalFileSize("/moved/sub/new.txt") != 4 {
+		t.Errorf("Expected local data to move with the directory")
+	}
+
+	if err := sf.SyncAll(); err != nil {
+		t.Fatalf("SyncAll failed: %v", err)
+	}
+
+	if handler.data["/moved/sub/new.txt"] != "xxxx" {
+		t.Errorf("Expected child to be uploaded at the new path")
+	}
+	if _, ok := handler.data["/existing/sub/new.txt"]; ok {
+		t.Errorf("Expected nothing uploaded at the old path")
+	}
+}
+
+func TestStagingFSRmdirCancelsStagedChildren(t *testing.T) {
+	sf, err := NewStagingFS(&StagingFSConfig{LocalRootPath: t.TempDir(), Client: &MockStagingClient{}})
+	if err != nil {
+		t.Fatalf("Failed to create StagingFS: %v", err)
+	}
+	defer sf.Close(context.Background())
+
+	handler := newRecordingHandler(sf)
+
+	writeStagedFile(t, sf, "/existing/a.txt", 10)
+	writeStagedFile(t, sf, "/existing/sub/b.txt", 20)
+	writeStagedFile(t, sf, "/existing-sibling.txt", 5)
+
+	// Writes through the returned *os.File are accounted by the caller
+	sf.addDataSize(35)
+
+	if err := sf.Rmdir("/existing"); err != nil {
+		t.Fatalf("Failed to rmdir: %v", err)
+	}
+
+	if got := getStagedPaths(sf); len(got) != 1 || got[0] != "/existing-sibling.txt" {
+		t.Fatalf("Expected only the sibling to remain staged, got %v", got)
+	}
+
+	if sf.GetCurrentDataSize() != 5 {
+		t.Errorf("Expected data size 5 after rmdir, got %d", sf.GetCurrentDataSize())
+	}
+
+	if err := sf.SyncAll(); err != nil {
+		t.Fatalf("SyncAll failed: %v", err)
+	}
+
+	expectedActions := []string{"RMDIR /existing", "UPLOAD /existing-sibling.txt"}
+	if got := handler.getSortedActions(); strings.Join(got, ",") != strings.Join(expectedActions, ",") {
+		t.Errorf("Expected actions %v, got %v", expectedActions, got)
+	}
+}
+
+func TestStagingFSRmdirNewDirCancelsSubtree(t *testing.T) {
+	sf, err := NewStagingFS(&StagingFSConfig{LocalRootPath: t.TempDir(), Client: &MockStagingClient{}})
+	if err != nil {
+		t.Fatalf("Failed to create StagingFS: %v", err)
+	}
+	defer sf.Close(context.Background())
+
+	handler := newRecordingHandler(sf)
+
+	if err := sf.Mkdir("/new"); err != nil {
+		t.Fatalf("Failed to mkdir: %v", err)
+	}
+	if err := sf.Mkdir("/new/sub"); err != nil {
+		t.Fatalf("Failed to mkdir: %v", err)
+	}
+	writeStagedFile(t, sf, "/new/sub/file.txt", 3)
+
+	if err := sf.Rmdir("/new"); err != nil {
+		t.Fatalf("Failed to rmdir: %v", err)
+	}
+
+	if got := getStagedPaths(sf); len(got) != 0 {
+		t.Fatalf("Expected nothing staged, got %v", got)
+	}
+
+	if err := sf.SyncAll(); err != nil {
+		t.Fatalf("SyncAll failed: %v", err)
+	}
+	if got := handler.getSortedActions(); len(got) != 0 {
+		t.Errorf("Expected no actions, got %v", got)
+	}
+}
+
+func TestStagingFSRmdirSyncsRenamesOutOfDir(t *testing.T) {
+	sf, err := NewStagingFS(&StagingFSConfig{LocalRootPath: t.TempDir(), Client: &MockStagingClient{}})
+	if err != nil {
+		t.Fatalf("Failed to create StagingFS: %v", err)
+	}
+	defer sf.Close(context.Background())
+
+	handler := newRecordingHandler(sf)
+
+	// Rename entries come from restored state
+	err = sf.sm.persistMetadataPublic("/z", &StagingMetadata{
+		Path:           "/z",
+		OldPath:        "/d/y",
+		Action:         ActionRename,
+		LastModifiedAt: time.Now(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to persist: %v", err)
+	}
+
+	if err := sf.Rmdir("/d"); err != nil {
+		t.Fatalf("Failed to rmdir: %v", err)
+	}
+
+	// The rename must reach iRODS before the recursive removal deletes its source
+	expectedActions := []string{"RENAME /d/y -> /z", "RMDIR /d"}
+	if strings.Join(handler.actions, ",") != strings.Join(expectedActions, ",") {
+		t.Errorf("Expected actions %v, got %v", expectedActions, handler.actions)
+	}
+	if got := getStagedPaths(sf); len(got) != 0 {
+		t.Errorf("Expected nothing staged, got %v", got)
+	}
+}
+
+func TestStagingFSRmdirDeletesSourcesOfRenamesIntoDir(t *testing.T) {
+	sf, err := NewStagingFS(&StagingFSConfig{LocalRootPath: t.TempDir(), Client: &MockStagingClient{}})
+	if err != nil {
+		t.Fatalf("Failed to create StagingFS: %v", err)
+	}
+	defer sf.Close(context.Background())
+
+	handler := newRecordingHandler(sf)
+
+	// Rename entries come from restored state
+	renames := []*StagingMetadata{
+		{Path: "/d/x", OldPath: "/o/x", Action: ActionRename},
+		{Path: "/d/sub", OldPath: "/o/sub", Action: ActionRenameDir},
+		{Path: "/d/inner", OldPath: "/d/old", Action: ActionRename},
+	}
+	for _, meta := range renames {
+		meta.LastModifiedAt = time.Now()
+		if err := sf.sm.persistMetadataPublic(meta.Path, meta); err != nil {
+			t.Fatalf("Failed to persist: %v", err)
+		}
+	}
+
+	if err := sf.Rmdir("/d"); err != nil {
+		t.Fatalf("Failed to rmdir: %v", err)
+	}
+
+	// The sources of cancelled renames into the directory are still in iRODS and must go too
+	if got := getStagedPaths(sf); strings.Join(got, ",") != "/o/sub,/o/x" {
+		t.Fatalf("Expected the rename sources to be staged, got %v", got)
+	}
+	if meta := sf.Get("/o/x"); meta.Action != ActionDelete || meta.IsNew {
+		t.Errorf("Expected /o/x to be deleted, got %+v", meta)
+	}
+	if meta := sf.Get("/o/sub"); meta.Action != ActionRmdir || meta.IsNew {
+		t.Errorf("Expected /o/sub to be removed, got %+v", meta)
+	}
+
+	if err := sf.SyncAll(); err != nil {
+		t.Fatalf("SyncAll failed: %v", err)
+	}
+
+	expectedActions := []string{"DELETE /o/x", "RMDIR /d", "RMDIR /o/sub"}
+	if got := handler.getSortedActions(); strings.Join(got, ",") != strings.Join(expectedActions, ",") {
+		t.Errorf("Expected actions %v, got %v", expectedActions, got)
+	}
+}
+
+func TestStagingFSRenameDirPersistsSubtree(t *testing.T) {
+	tmpDir := t.TempDir()
+	config := &StagingFSConfig{LocalRootPath: tmpDir, Client: &MockStagingClient{}}
+
+	sf, err := NewStagingFSWithPersistence(config)
+	if err != nil {
+		t.Fatalf("Failed to create StagingFS: %v", err)
+	}
+
+	if err := sf.Mkdir("/p"); err != nil {
+		t.Fatalf("Failed to mkdir: %v", err)
+	}
+	writeStagedFile(t, sf, "/p/q/file.txt", 3)
+
+	if err := sf.RenameDir("/p", "/r"); err != nil {
+		t.Fatalf("Failed to rename dir: %v", err)
+	}
+
+	// Simulate a restart without syncing
+	sf.stopOnce.Do(func() { close(sf.stopCh) })
+	<-sf.workerDone
+	if err := sf.sm.store.Close(); err != nil {
+		t.Fatalf("Failed to close DB: %v", err)
+	}
+
+	sf2, err := NewStagingFSWithPersistence(config)
+	if err != nil {
+		t.Fatalf("Failed to reopen StagingFS: %v", err)
+	}
+	defer sf2.Close(context.Background())
+
+	expected := []string{"/r", "/r/q/file.txt"}
+	if got := getStagedPaths(sf2); strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected restored paths %v, got %v", expected, got)
+	}
+}
@@ -0,0 +1,178 @@
+package stagingfs
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	irodsclient_common "github.com/cyverse/go-irodsclient/irods/common"
+)
+
+const (
+	DefaultWindowedSyncThreshold = 64 * 1024 * 1024 // 64MB
+)
+
+// BandwidthLimiter is a token bucket limiting the throughput of sync transfers.
+// Tokens are bytes; the bucket refills at the configured rate up to the burst size.
+type BandwidthLimiter struct {
+	mu         sync.Mutex
+	rate       float64 // bytes per second
+	burst      float64 // max tokens
+	tokens     float64
+	lastRefill time.Time
+}
+
+// NewBandwidthLimiter creates a limiter allowing bytesPerSec on average with bursts up to burst bytes.
+// A non-positive burst defaults to one second worth of transfer.
+func NewBandwidthLimiter(bytesPerSec int64, burst int64) *BandwidthLimiter {
+	if burst <= 0 {
+		burst = bytesPerSec
+	}
+
+	return &BandwidthLimiter{
+		rate:       float64(bytesPerSec),
+		burst:      float64(burst),
+		tokens:     float64(burst),
+		lastRefill: time.Now(),
+	}
+}
+
+// GetRate returns the limit in bytes per second
+func (l *BandwidthLimiter) GetRate() int64 {
+	return int64(l.rate)
+}
+
+// reserve takes n tokens, going into debt if needed, and returns how long the caller must wait
+func (l *BandwidthLimiter) reserve(n int64) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.lastRefill).Seconds()*l.rate)
+	l.lastRefill = now
+
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// WaitN blocks until n bytes may be transferred
+func (l *BandwidthLimiter) WaitN(n int64) {
+	if n <= 0 {
+		return
+	}
+
+	if wait := l.reserve(n); wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// TransferCallback returns a transfer tracker callback that throttles the transfer reporting to it.
+// Progress is tracked per task so parallel transfers are charged for their combined throughput;
+// concurrent transfers must report under distinct task names. Progress going backwards is taken as
+// the task starting over. next is called after throttling, if not nil.
+func (l *BandwidthLimiter) TransferCallback(next irodsclient_common.TransferTrackerCallback) irodsclient_common.TransferTrackerCallback {
+	var mu sync.Mutex
+	lastProcessed := map[string]int64{}
+
+	return func(taskName string, processed int64, total int64) {
+		mu.Lock()
+		delta := processed - lastProcessed[taskName]
+		if delta < 0 {
+			delta = processed
+		}
+		if total > 0 && processed >= total {
+			delete(lastProcessed, taskName)
+		} else {
+			lastProcessed[taskName] = processed
+		}
+		mu.Unlock()
+
+		l.WaitN(delta)
+
+		if next != nil {
+			next(taskName, processed, total)
+		}
+	}
+}
+
+// SyncWindow is a daily time-of-day range during which large files may be synced.
+// Start and End are offsets from local midnight; a window with End before Start spans midnight.
+type SyncWindow struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// NewSyncWindow creates a window from "HH:MM" start and end times
+func NewSyncWindow(start string, end string) (SyncWindow, error) {
+	startOffset, err := parseTimeOfDay(start)
+	if err != nil {
+		return SyncWindow{}, err
+	}
+
+	endOffset, err := parseTimeOfDay(end)
+	if err != nil {
+		return SyncWindow{}, err
+	}
+
+	return SyncWindow{Start: startOffset, End: endOffset}, nil
+}
+
+// Contains returns true if t falls inside the window
+func (w SyncWindow) Contains(t time.Time) bool {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	offset := t.Sub(midnight)
+
+	if w.Start <= w.End {
+		return offset >= w.Start && offset < w.End
+	}
+
+	// spans midnight
+	return offset >= w.Start || offset < w.End
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid time of day %q, expected HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// inSyncWindow returns true if large files may be synced at t
+func (sf *StagingFS) inSyncWindow(t time.Time) bool {
+	if len(sf.config.SyncWindows) == 0 {
+		return true
+	}
+
+	for _, window := range sf.config.SyncWindows {
+		if window.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// waitsForSyncWindow returns true if the item must wait for a sync window before background sync
+func (sf *StagingFS) waitsForSyncWindow(meta *StagingMetadata, now time.Time) bool {
+	if meta.Action != ActionUpload || sf.inSyncWindow(now) {
+		return false
+	}
+
+	threshold := sf.config.WindowedSyncThreshold
+	if threshold == 0 {
+		threshold = DefaultWindowedSyncThreshold
+	}
+
+	return sf.GetLocalFileSize(meta.Path) >= threshold
+}
+
+// transferCallback returns the callback passed to sync transfers, throttled when a bandwidth limit is set
+func (sf *StagingFS) transferCallback() irodsclient_common.TransferTrackerCallback {
+	if sf.limiter == nil {
+		return nil
+	}
+	return sf.limiter.TransferCallback(nil)
+}
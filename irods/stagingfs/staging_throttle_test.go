@@ -0,0 +1,151 @@
+package stagingfs
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSyncWindowContains(t *testing.T) {
+	day, err := NewSyncWindow("09:00", "17:00")
+	if err != nil {
+		t.Fatalf("Failed to create window: %v", err)
+	}
+
+	night, err := NewSyncWindow("22:00", "06:00")
+	if err != nil {
+		t.Fatalf("Failed to create window: %v", err)
+	}
+
+	at := func(hour, minute int) time.Time {
+		return time.Date(2024, 1, 1, hour, minute, 0, 0, time.Local)
+	}
+
+	if !day.Contains(at(12, 0)) || day.Contains(at(8, 59)) || day.Contains(at(17, 0)) {
+		t.Errorf("Day window boundaries are wrong")
+	}
+
+	if !night.Contains(at(23, 30)) || !night.Contains(at(2, 0)) || night.Contains(at(12, 0)) {
+		t.Errorf("Night window should span midnight")
+	}
+
+	if _, err := NewSyncWindow("25:00", "06:00"); err == nil {
+		t.Errorf("Expected error for invalid time of day")
+	}
+}
+
+func TestBandwidthLimiterThrottles(t *testing.T) {
+	// 100KB/s with a 10KB burst: 30KB should take about 200ms
+	limiter := NewBandwidthLimiter(100*1024, 10*1024)
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		limiter.WaitN(10 * 1024)
+	}
+	elapsed := time.Since(start)
+
+	if elapsed < 150*time.Millisecond {
+		t.Errorf("Expected throttling to take at least 150ms, took %v", elapsed)
+	}
+}
+
+func TestBandwidthLimiterTransferCallback(t *testing.T) {
+	limiter := NewBandwidthLimiter(100*1024, 10*1024)
+
+	var reported int64
+	callback := limiter.TransferCallback(func(taskName string, processed int64, total int64) {
+		reported = processed
+	})
+
+	start := time.Now()
+	callback("upload", 10*1024, 30*1024)
+	callback("upload", 20*1024, 30*1024)
+	callback("upload", 30*1024, 30*1024)
+	elapsed := time.Since(start)
+
+	if reported != 30*1024 {
+		t.Errorf("Expected wrapped callback to see 30KB, got %d", reported)
+	}
+	if elapsed < 150*time.Millisecond {
+		t.Errorf("Expected callback to throttle by progress deltas, took %v", elapsed)
+	}
+}
+
+func TestBandwidthLimiterChargesConcurrentParts(t *testing.T) {
+	// A slow refill with a large burst, so the tokens left tell how many bytes were charged
+	limiter := NewBandwidthLimiter(1, 1024*1024)
+	callback := limiter.TransferCallback(nil)
+
+	// Clients report range uploads under one task name; parts interleave
+	part0 := partCallback(callback, 0)
+	part1 := partCallback(callback, 1)
+	part0("upload", 4*1024, 8*1024)
+	part1("upload", 2*1024, 8*1024)
+	part0("upload", 8*1024, 8*1024)
+	part1("upload", 8*1024, 8*1024)
+
+	// A part uploaded again after a failure is charged again
+	part1("upload", 4*1024, 8*1024)
+
+	limiter.mu.Lock()
+	charged := int64(limiter.burst - limiter.tokens)
+	limiter.mu.Unlock()
+
+	if charged < 20*1024-8 || charged > 20*1024 {
+		t.Errorf("Expected 20KB to be charged, got %d bytes", charged)
+	}
+}
+
+func TestStagingFSLargeFilesWaitForSyncWindow(t *testing.T) {
+	tmpDir := t.TempDir()
+	client := newMockResumableClient()
+
+	// A one-minute window twelve hours away from now
+	now := time.Now()
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	start := (now.Sub(midnight) + 12*time.Hour) % (24 * time.Hour)
+	window := SyncWindow{Start: start, End: (start + time.Minute) % (24 * time.Hour)}
+
+	config := &StagingFSConfig{
+		LocalRootPath:            tmpDir,
+		Client:                   client,
+		ResumableUploadThreshold: -1,
+		SyncWindows:              []SyncWindow{window},
+		WindowedSyncThreshold:    8,
+	}
+
+	sf, err := NewStagingFS(config)
+	if err != nil {
+		t.Fatalf("Failed to create StagingFS: %v", err)
+	}
+	defer sf.Close(context.Background())
+
+	for path, data := range map[string]string{"/small.txt": "tiny", "/large.dat": "0123456789"} {
+		f, err := sf.OpenForWrite(path)
+		if err != nil {
+			t.Fatalf("Failed to open %s for writing: %v", path, err)
+		}
+		f.Write([]byte(data))
+		f.Close()
+	}
+
+	sf.syncOldItems(0)
+
+	if _, ok := client.objects["/small.txt"]; !ok {
+		t.Errorf("Expected small file to sync outside the window")
+	}
+	if _, ok := client.objects["/large.dat"]; ok {
+		t.Errorf("Expected large file to wait for the window")
+	}
+	if sf.Get("/large.dat") == nil {
+		t.Errorf("Expected large file to stay staged")
+	}
+
+	// Explicit sync ignores the window
+	if err := sf.SyncAll(); err != nil {
+		t.Fatalf("SyncAll failed: %v", err)
+	}
+	if _, ok := client.objects["/large.dat"]; !ok {
+		t.Errorf("Expected SyncAll to upload the large file")
+	}
+}
@@ -0,0 +1,385 @@
+package irods
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	irodsclient_common "github.com/cyverse/go-irodsclient/irods/common"
+	"github.com/cyverse/irodsfs-common/irods/stagingfs"
+	"github.com/rs/xid"
+)
+
+const (
+	// DefaultTransferBlockSize is the size of blocks passed to TransferOptions.BlockReadyCallback
+	DefaultTransferBlockSize = 4 * 1024 * 1024
+	// DefaultTransferNumBlocks is the number of blocks read ahead for TransferOptions.BlockReadyCallback
+	DefaultTransferNumBlocks = 3
+	// DefaultMaxFinishedTransfers is how many finished transfers a TransferManager reports
+	DefaultMaxFinishedTransfers = 100
+)
+
+// TransferOptions configures a download or upload. The zero value transfers sequentially
+// with the defaults of the client.
+type TransferOptions struct {
+	TaskNum        int    // Parallel connections (0 = 1)
+	BlockSize      int    // Size of blocks passed to BlockReadyCallback (0 = DefaultTransferBlockSize)
+	NumBlocks      int    // Blocks read ahead for BlockReadyCallback (0 = DefaultTransferNumBlocks)
+	VerifyChecksum bool   // Verify the checksum of whole-file transfers
+	Resume         bool   // Keep the data a previous transfer left at the destination and transfer the rest (with VerifyChecksum, the result is verified and transferred whole if it does not match)
+	BandwidthLimit int64  // Max bytes per second (0 = unlimited)
+	Resource       string // Resource to transfer from or to ("" = the OpenOptions of the client)
+
+	// Downloads without a local path pass the data to BlockReadyCallback instead
+	BlockReadyCallback irodsclient_common.DataObjectBlockCallback
+	TransferCallback   irodsclient_common.TransferTrackerCallback
+}
+
+// withDefaults returns a copy of options with unset fields taken from defaults, then from the
+// package defaults
+func (options *TransferOptions) withDefaults(defaults *TransferOptions) *TransferOptions {
+	result := TransferOptions{}
+	if options != nil {
+		result = *options
+	}
+
+	if defaults != nil {
+		if result.TaskNum <= 0 {
+			result.TaskNum = defaults.TaskNum
+		}
+		if result.BlockSize <= 0 {
+			result.BlockSize = defaults.BlockSize
+		}
+		if result.NumBlocks <= 0 {
+			result.NumBlocks = defaults.NumBlocks
+		}
+		if result.BandwidthLimit <= 0 {
+			result.BandwidthLimit = defaults.BandwidthLimit
+		}
+		if result.Resource == "" {
+			result.Resource = defaults.Resource
+		}
+		result.VerifyChecksum = result.VerifyChecksum || defaults.VerifyChecksum
+		result.Resume = result.Resume || defaults.Resume
+	}
+
+	if result.TaskNum <= 0 {
+		result.TaskNum = 1
+	}
+	if result.BlockSize <= 0 {
+		result.BlockSize = DefaultTransferBlockSize
+	}
+	if result.NumBlocks <= 0 {
+		result.NumBlocks = DefaultTransferNumBlocks
+	}
+	return &result
+}
+
+// TransferDirection tells downloads from uploads
+type TransferDirection string
+
+const (
+	TransferDownload TransferDirection = "download"
+	TransferUpload   TransferDirection = "upload"
+)
+
+// TransferState is the state of a transfer in a TransferManager
+type TransferState string
+
+const (
+	TransferQueued    TransferState = "queued"
+	TransferRunning   TransferState = "running"
+	TransferCompleted TransferState = "completed"
+	TransferFailed    TransferState = "failed"
+	TransferCancelled TransferState = "cancelled"
+)
+
+// IsFinished returns true if the transfer will not change state anymore
+func (s TransferState) IsFinished() bool {
+	return s == TransferCompleted || s == TransferFailed || s == TransferCancelled
+}
+
+// TransferStatus is a snapshot of a transfer
+type TransferStatus struct {
+	ID         string
+	Direction  TransferDirection
+	IRODSPath  string
+	LocalPath  string // Empty for downloads to a block callback
+	State      TransferState
+	Processed  int64
+	Total      int64
+	Error      error
+	QueuedAt   time.Time
+	StartedAt  time.Time
+	FinishedAt time.Time
+}
+
+// Transfer is a download or upload queued or run by a TransferManager
+type Transfer struct {
+	mu     sync.Mutex
+	status TransferStatus
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// GetStatus returns a snapshot of the transfer
+func (t *Transfer) GetStatus() TransferStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.status
+}
+
+// Cancel cancels the transfer. Queued transfers do not start; running downloads stop at the next
+// block. Running uploads complete, because iRODS cannot roll them back.
+func (t *Transfer) Cancel() {
+	t.cancel()
+}
+
+// Done returns a channel closed once the transfer is finished
+func (t *Transfer) Done() <-chan struct{} {
+	return t.done
+}
+
+// Wait waits for the transfer to finish and returns its error
+func (t *Transfer) Wait() error {
+	<-t.done
+	return t.GetStatus().Error
+}
+
+func (t *Transfer) update(fn func(status *TransferStatus)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	fn(&t.status)
+}
+
+// TransferManagerConfig configures a TransferManager
+type TransferManagerConfig struct {
+	MaxConcurrent  int   // Transfers running at once, others are queued (0 = unlimited)
+	BandwidthLimit int64 // Max bytes per second of all transfers together (0 = unlimited)
+	MaxFinished    int   // Finished transfers kept for List (0 = DefaultMaxFinishedTransfers)
+}
+
+// TransferManager queues, tracks and cancels the transfers of a client
+type TransferManager struct {
+	slots       chan struct{}
+	limiter     *stagingfs.BandwidthLimiter
+	maxFinished int
+
+	mu        sync.Mutex
+	transfers map[string]*Transfer
+	finished  []string // IDs of finished transfers, oldest first
+}
+
+// NewTransferManager creates a TransferManager
+func NewTransferManager(config TransferManagerConfig) *TransferManager {
+	manager := &TransferManager{
+		maxFinished: config.MaxFinished,
+		transfers:   map[string]*Transfer{},
+	}
+
+	if config.MaxConcurrent > 0 {
+		manager.slots = make(chan struct{}, config.MaxConcurrent)
+	}
+	if config.BandwidthLimit > 0 {
+		manager.limiter = stagingfs.NewBandwidthLimiter(config.BandwidthLimit, 0)
+	}
+	if manager.maxFinished <= 0 {
+		manager.maxFinished = DefaultMaxFinishedTransfers
+	}
+	return manager
+}
+
+// transferFunc runs a transfer with options whose TransferCallback tracks and throttles it
+type transferFunc func(ctx context.Context, options *TransferOptions) error
+
+// start queues a transfer and runs it once a slot is free. The transfer is cancelled with ctx.
+func (m *TransferManager) start(ctx context.Context, direction TransferDirection, irodsPath string, localPath string, options *TransferOptions, run transferFunc) *Transfer {
+	transferCtx, cancel := context.WithCancel(ctx)
+
+	transfer := &Transfer{
+		status: TransferStatus{
+			ID:        xid.New().String(),
+			Direction: direction,
+			IRODSPath: irodsPath,
+			LocalPath: localPath,
+			State:     TransferQueued,
+			QueuedAt:  time.Now(),
+		},
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	m.mu.Lock()
+	m.transfers[transfer.status.ID] = transfer
+	m.mu.Unlock()
+
+	go m.run(transferCtx, transfer, options, run)
+	return transfer
+}
+
+// Run queues a transfer, runs it once a slot is free and waits for it. IRODSFSClient implementations
+// outside this package use it to track their transfers; run gets options with defaults filled in and a
+// TransferCallback that records progress and throttles the transfer.
+func (m *TransferManager) Run(ctx context.Context, direction TransferDirection, irodsPath string, localPath string, options *TransferOptions, run func(ctx context.Context, options *TransferOptions) error) error {
+	return m.runTransfer(ctx, direction, irodsPath, localPath, options.withDefaults(nil), run)
+}
+
+// runTransfer runs a transfer and waits for it
+func (m *TransferManager) runTransfer(ctx context.Context, direction TransferDirection, irodsPath string, localPath string, options *TransferOptions, run transferFunc) error {
+	return m.start(ctx, direction, irodsPath, localPath, options, run).Wait()
+}
+
+func (m *TransferManager) run(ctx context.Context, transfer *Transfer, options *TransferOptions, run transferFunc) {
+	defer close(transfer.done)
+	defer transfer.cancel()
+
+	err := m.acquire(ctx)
+	if err == nil {
+		transfer.update(func(status *TransferStatus) {
+			status.State = TransferRunning
+			status.StartedAt = time.Now()
+		})
+
+		err = run(ctx, m.trackOptions(transfer, options))
+		m.release()
+	}
+
+	transfer.update(func(status *TransferStatus) {
+		status.Error = err
+		status.FinishedAt = time.Now()
+		switch {
+		case err == nil:
+			status.State = TransferCompleted
+		case errors.Is(err, context.Canceled):
+			status.State = TransferCancelled
+		default:
+			status.State = TransferFailed
+		}
+	})
+
+	m.addFinished(transfer.GetStatus().ID)
+}
+
+func (m *TransferManager) acquire(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if m.slots == nil {
+		return nil
+	}
+
+	select {
+	case m.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (m *TransferManager) release() {
+	if m.slots != nil {
+		<-m.slots
+	}
+}
+
+// trackOptions returns a copy of options whose TransferCallback records progress in transfer and
+// throttles it to the limits of the transfer and the manager
+func (m *TransferManager) trackOptions(transfer *Transfer, options *TransferOptions) *TransferOptions {
+	tracked := *options
+
+	next := options.TransferCallback
+	callback := func(taskName string, processed int64, total int64) {
+		transfer.update(func(status *TransferStatus) {
+			status.Processed = processed
+			status.Total = total
+		})
+		if next != nil {
+			next(taskName, processed, total)
+		}
+	}
+
+	limiters := []*stagingfs.BandwidthLimiter{}
+	if options.BandwidthLimit > 0 {
+		limiters = append(limiters, stagingfs.NewBandwidthLimiter(options.BandwidthLimit, 0))
+	}
+	if m.limiter != nil {
+		limiters = append(limiters, m.limiter)
+	}
+	for _, limiter := range limiters {
+		callback = limiter.TransferCallback(callback)
+	}
+
+	tracked.TransferCallback = callback
+	return &tracked
+}
+
+func (m *TransferManager) addFinished(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.finished = append(m.finished, id)
+	for len(m.finished) > m.maxFinished {
+		delete(m.transfers, m.finished[0])
+		m.finished = m.finished[1:]
+	}
+}
+
+// Get returns the transfer with the given ID, or nil if it is unknown or was dropped after finishing
+func (m *TransferManager) Get(id string) *Transfer {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.transfers[id]
+}
+
+// List returns the status of queued, running and recently finished transfers, oldest first
+func (m *TransferManager) List() []TransferStatus {
+	m.mu.Lock()
+	transfers := make([]*Transfer, 0, len(m.transfers))
+	for _, transfer := range m.transfers {
+		transfers = append(transfers, transfer)
+	}
+	m.mu.Unlock()
+
+	statuses := make([]TransferStatus, 0, len(transfers))
+	for _, transfer := range transfers {
+		statuses = append(statuses, transfer.GetStatus())
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].QueuedAt.Equal(statuses[j].QueuedAt) {
+			return statuses[i].ID < statuses[j].ID
+		}
+		return statuses[i].QueuedAt.Before(statuses[j].QueuedAt)
+	})
+	return statuses
+}
+
+// Cancel cancels the transfer with the given ID. Returns false if it is unknown.
+func (m *TransferManager) Cancel(id string) bool {
+	transfer := m.Get(id)
+	if transfer == nil {
+		return false
+	}
+
+	transfer.Cancel()
+	return true
+}
+
+// CancelAll cancels all transfers
+func (m *TransferManager) CancelAll() {
+	m.mu.Lock()
+	transfers := make([]*Transfer, 0, len(m.transfers))
+	for _, transfer := range m.transfers {
+		transfers = append(transfers, transfer)
+	}
+	m.mu.Unlock()
+
+	for _, transfer := range transfers {
+		transfer.Cancel()
+	}
+}
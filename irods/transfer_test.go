@@ -0,0 +1,143 @@
+package irods
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransferOptionsWithDefaults(t *testing.T) {
+	options := (*TransferOptions)(nil).withDefaults(nil)
+	assert.Equal(t, 1, options.TaskNum)
+	assert.Equal(t, DefaultTransferBlockSize, options.BlockSize)
+	assert.Equal(t, DefaultTransferNumBlocks, options.NumBlocks)
+
+	defaults := &TransferOptions{TaskNum: 4, BlockSize: 1024, BandwidthLimit: 100, Resource: "fastResc", VerifyChecksum: true}
+	options = (&TransferOptions{TaskNum: 2, Resource: "archiveResc"}).withDefaults(defaults)
+	assert.Equal(t, 2, options.TaskNum)
+	assert.Equal(t, 1024, options.BlockSize)
+	assert.Equal(t, DefaultTransferNumBlocks, options.NumBlocks)
+	assert.Equal(t, int64(100), options.BandwidthLimit)
+	assert.Equal(t, "archiveResc", options.Resource)
+	assert.True(t, options.VerifyChecksum)
+	assert.False(t, options.Resume)
+}
+
+func TestTransferManagerQueue(t *testing.T) {
+	manager := NewTransferManager(TransferManagerConfig{MaxConcurrent: 1})
+
+	release := make(chan struct{})
+	first := manager.start(context.Background(), TransferDownload, "/zone/a", "/tmp/a", (*TransferOptions)(nil).withDefaults(nil), func(ctx context.Context, options *TransferOptions) error {
+		options.TransferCallback("download", 5, 10)
+		<-release
+		options.TransferCallback("download", 10, 10)
+		return nil
+	})
+	require.Eventually(t, func() bool {
+		return first.GetStatus().Processed == 5
+	}, time.Second, time.Millisecond)
+
+	second := manager.start(context.Background(), TransferUpload, "/zone/b", "/tmp/b", (*TransferOptions)(nil).withDefaults(nil), func(ctx context.Context, options *TransferOptions) error {
+		return errors.New("upload failed")
+	})
+	assert.Equal(t, TransferRunning, first.GetStatus().State)
+	assert.Equal(t, TransferQueued, second.GetStatus().State)
+
+	statuses := manager.List()
+	require.Len(t, statuses, 2)
+	assert.Equal(t, first.GetStatus().ID, statuses[0].ID)
+
+	close(release)
+	require.NoError(t, first.Wait())
+	assert.Error(t, second.Wait())
+
+	status := first.GetStatus()
+	assert.Equal(t, TransferCompleted, status.State)
+	assert.Equal(t, int64(10), status.Processed)
+	assert.Equal(t, int64(10), status.Total)
+	assert.Equal(t, TransferFailed, second.GetStatus().State)
+	assert.Same(t, first, manager.Get(status.ID))
+}
+
+func TestTransferManagerCancel(t *testing.T) {
+	manager := NewTransferManager(TransferManagerConfig{MaxConcurrent: 1})
+
+	running := manager.start(context.Background(), TransferDownload, "/zone/a", "", (*TransferOptions)(nil).withDefaults(nil), func(ctx context.Context, options *TransferOptions) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	ran := false
+	queued := manager.start(context.Background(), TransferDownload, "/zone/b", "", (*TransferOptions)(nil).withDefaults(nil), func(ctx context.Context, options *TransferOptions) error {
+		ran = true
+		return nil
+	})
+
+	assert.True(t, manager.Cancel(queued.GetStatus().ID))
+	assert.ErrorIs(t, queued.Wait(), context.Canceled)
+	assert.Equal(t, TransferCancelled, queued.GetStatus().State)
+	assert.False(t, ran)
+
+	manager.CancelAll()
+	assert.ErrorIs(t, running.Wait(), context.Canceled)
+	assert.Equal(t, TransferCancelled, running.GetStatus().State)
+
+	assert.False(t, manager.Cancel("unknown"))
+}
+
+func TestTransferManagerRetention(t *testing.T) {
+	manager := NewTransferManager(TransferManagerConfig{MaxFinished: 2})
+
+	ids := []string{}
+	for i := 0; i < 3; i++ {
+		transfer := manager.start(context.Background(), TransferUpload, "/zone/file", "", (*TransferOptions)(nil).withDefaults(nil), func(ctx context.Context, options *TransferOptions) error {
+			return nil
+		})
+		require.NoError(t, transfer.Wait())
+		ids = append(ids, transfer.GetStatus().ID)
+	}
+
+	assert.Nil(t, manager.Get(ids[0]))
+	assert.NotNil(t, manager.Get(ids[2]))
+	assert.Len(t, manager.List(), 2)
+}
+
+func TestBufferedClientDownloadStagedWithOptions(t *testing.T) {
+	client := newTestBufferedClient(t, &IRODSFSClientBufferedConfig{
+		StagingRootPath: t.TempDir(),
+		GracePeriod:     time.Hour,
+		TransferOptions: TransferOptions{BlockSize: 4},
+	}, &mockStagingClient{})
+	client.direct = &IRODSFSClientDirect{transfers: NewTransferManager(TransferManagerConfig{})}
+
+	staging := client.router.getStaging("/zone/file")
+	f, err := staging.OpenForWrite("/zone/file")
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte("hello world"), 0)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	progress := []int64{}
+	localPath := filepath.Join(t.TempDir(), "file")
+	err = client.DownloadFileWithOptions("/zone/file", localPath, &TransferOptions{
+		TransferCallback: func(taskName string, processed int64, total int64) {
+			progress = append(progress, processed)
+		},
+	})
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(localPath)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(data))
+	assert.Equal(t, []int64{4, 8, 11}, progress)
+
+	statuses := client.GetTransferManager().List()
+	require.Len(t, statuses, 1)
+	assert.Equal(t, TransferCompleted, statuses[0].State)
+	assert.Equal(t, int64(11), statuses[0].Processed)
+}
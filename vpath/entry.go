@@ -8,7 +8,6 @@ import (
 
 	"github.com/cockroachdb/errors"
 	irodsclient_fs "github.com/cyverse/go-irodsclient/fs"
-	irodsclient_types "github.com/cyverse/go-irodsclient/irods/types"
 	irodsclient_util "github.com/cyverse/go-irodsclient/irods/util"
 	"github.com/cyverse/irodsfs-common/irods"
 )
@@ -92,7 +91,7 @@ func (entry *VPathEntry) UpdateIRODSEntry(fsClient irods.IRODSFSClient) error {
 	if entry.Type == VPathIRODS {
 		irodsEntry, err := fsClient.Stat(entry.IRODSPath)
 		if err != nil {
-			if irodsclient_types.IsFileNotFoundError(err) {
+			if errors.Is(err, irods.ErrNotFound) {
 				return errors.Wrapf(err, "failed to find path %q", entry.IRODSPath)
 			}
 
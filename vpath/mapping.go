@@ -6,6 +6,7 @@ import (
 	"strings"
 
 	"github.com/cockroachdb/errors"
+	"github.com/cyverse/irodsfs-common/irods"
 )
 
 // VPathMappingResourceType determines the type of Virtual Path Mapping resource entry
@@ -58,6 +59,7 @@ type VPathMapping struct {
 	ReadOnly            bool                     `yaml:"read_only" json:"read_only"`
 	CreateDir           bool                     `yaml:"create_dir" json:"create_dir"`
 	IgnoreNotExistError bool                     `yaml:"ignore_not_exist_error" json:"ignore_not_exist_error"`
+	Staging             *irods.StagingPolicy     `yaml:"staging,omitempty" json:"staging,omitempty"` // Staging policy for the mapped iRODS path (nil = client default)
 }
 
 // Validate validates VPathMapping
@@ -70,9 +72,52 @@ func (mapping *VPathMapping) Validate() error {
 		return errors.Newf("path %q is not absolute", mapping.MappingPath)
 	}
 
+	if mapping.Staging != nil {
+		policy := mapping.GetStagingPolicy()
+		if err := policy.Validate(); err != nil {
+			return errors.Wrapf(err, "invalid staging policy for path %q", mapping.IRODSPath)
+		}
+	}
+
 	return nil
 }
 
+// GetStagingPolicy returns the staging policy of the mapping bound to its iRODS path, or nil if not set
+func (mapping *VPathMapping) GetStagingPolicy() *irods.StagingPolicy {
+	if mapping.Staging == nil {
+		return nil
+	}
+
+	policy := *mapping.Staging
+	policy.IRODSPathPrefix = mapping.IRODSPath
+	return &policy
+}
+
+// GetStagingPolicies returns the staging policies of the path mappings given, to be used in
+// IRODSFSClientBufferedConfig.StagingPolicies
+func GetStagingPolicies(mappings []VPathMapping) []irods.StagingPolicy {
+	policies := []irods.StagingPolicy{}
+	for i := range mappings {
+		if policy := mappings[i].GetStagingPolicy(); policy != nil {
+			policies = append(policies, *policy)
+		}
+	}
+	return policies
+}
+
+// GetReadOnlyPathPrefixes returns the iRODS paths of the read-only path mappings given, to be used in
+// ReadOnlyConfig.PathPrefixes so that the client enforces them. The list is empty if no mapping is
+// read-only, which ReadOnlyConfig takes as no path being read-only.
+func GetReadOnlyPathPrefixes(mappings []VPathMapping) []string {
+	prefixes := []string{}
+	for _, mapping := range mappings {
+		if mapping.ReadOnly {
+			prefixes = append(prefixes, mapping.IRODSPath)
+		}
+	}
+	return prefixes
+}
+
 // ValidateVPathMappings validates the path mappings given
 func ValidateVPathMappings(mappings []VPathMapping) error {
 	mappingDict := map[string]string{}
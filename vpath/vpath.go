@@ -6,7 +6,6 @@ import (
 	"time"
 
 	"github.com/cockroachdb/errors"
-	irodsclient_types "github.com/cyverse/go-irodsclient/irods/types"
 	irodsclient_util "github.com/cyverse/go-irodsclient/irods/util"
 	"github.com/cyverse/irodsfs-common/inode"
 	"github.com/cyverse/irodsfs-common/irods"
@@ -179,7 +178,7 @@ func (manager *VPathManager) buildMapping(mapping *VPathMapping) error {
 	logger.Debugf("Checking path - %q", mapping.IRODSPath)
 	irodsEntry, err := manager.fsClient.Stat(mapping.IRODSPath)
 	if err != nil {
-		if irodsclient_types.IsFileNotFoundError(err) {
+		if errors.Is(err, irods.ErrNotFound) {
 			if mapping.ResourceType == VPathMappingDirectory {
 				// dir not found
 				if mapping.CreateDir {